@@ -0,0 +1,131 @@
+// Comando invitar da de alta un usuario por invitación desde la consola. Sirve para crear
+// el primer administrador de una instalación, ya que no hay registro público y la API de
+// usuarios requiere un usuario con usuarios:manage.
+//
+// Uso:
+//
+//	invitar -nombre admin -email admin@example.com [-rol admin] [-consultorio <uuid>]
+//
+// El usuario se crea sin contraseña y recibe por correo el enlace para elegirla. Usa la
+// misma configuración que el servidor (DATABASE_URL, SMTP_* o MAIL_DIR, FRONTEND_URL).
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/config"
+	"github.com/FolkodeGroup/mediapp/internal/db"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/joho/godotenv"
+	"go.uber.org/zap"
+)
+
+func main() {
+	nombre := flag.String("nombre", "", "nombre de usuario (login)")
+	email := flag.String("email", "", "email al que se envía la invitación")
+	rol := flag.String("rol", "admin", "nombre del rol")
+	consultorio := flag.String("consultorio", "", "ID del consultorio (opcional)")
+	flag.Parse()
+	_ = godotenv.Load()
+
+	if err := invitar(strings.TrimSpace(*nombre), strings.TrimSpace(*email), *rol, *consultorio); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+}
+
+func invitar(nombre, email, rol, consultorio string) error {
+	if nombre == "" || email == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var consultorioID *uuid.UUID
+	if consultorio != "" {
+		id, err := uuid.Parse(consultorio)
+		if err != nil {
+			return fmt.Errorf("consultorio inválido: %w", err)
+		}
+		consultorioID = &id
+	}
+
+	logger, err := zap.NewDevelopment()
+	if err != nil {
+		return err
+	}
+	correo, err := config.Mailer(logger)
+	if err != nil {
+		return err
+	}
+	pool, err := db.Connect(zap.NewNop())
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var rolID int
+	err = pool.QueryRow(ctx, `SELECT id FROM roles WHERE nombre_rol = $1`, rol).Scan(&rolID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("rol %q inexistente", rol)
+	} else if err != nil {
+		return err
+	}
+
+	usuarioID := uuid.New()
+	creadoEn := time.Now()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO usuarios (id, nombre, email, contrasena_hash, rol_id, consultorio_id, activo, creado_en)
+		VALUES ($1, $2, $3, '', $4, $5, true, $6)
+	`, usuarioID, nombre, email, rolID, consultorioID, creadoEn)
+	if err != nil {
+		return err
+	}
+	err = audit.Write(ctx, tx, audit.Entry{
+		ConsultorioID: consultorioID,
+		Accion:        audit.AccionInvitar,
+		TablaAfectada: "usuarios",
+		RegistroID:    usuarioID.String(),
+		Despues: map[string]interface{}{
+			"id":             usuarioID,
+			"nombre":         nombre,
+			"email":          email,
+			"rol_id":         rolID,
+			"consultorio_id": consultorioID,
+			"activo":         true,
+			"creado_en":      creadoEn,
+			"origen":         "consola",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	// El comando termina cuando el correo salió; un fallo de envío queda en el log y la
+	// invitación se puede reenviar desde la API
+	cuenta := services.NewCuentaService(pool, correo, config.FrontendURL(), logger)
+	cuenta.SetSincronico()
+	if err := cuenta.Invitar(ctx, usuarioID); err != nil {
+		return fmt.Errorf("usuario %s creado, pero no se pudo emitir la invitación: %w", usuarioID, err)
+	}
+	fmt.Printf("OK: usuario %s invitado (%s)\n", usuarioID, email)
+	return nil
+}
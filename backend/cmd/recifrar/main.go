@@ -0,0 +1,139 @@
+// Comando recifrar vuelve a cifrar los datos personales con la versión vigente de la clave
+// maestra después de una rotación.
+//
+// Uso:
+//
+//	recifrar ejecutar [-lote 200]
+//	recifrar estado
+//	recifrar generar-clave
+//
+// ejecutar avanza por lotes y guarda el progreso en recifrado_trabajos: si se interrumpe
+// (Ctrl+C, reinicio) la próxima ejecución sigue desde la última fila confirmada. Usa la
+// misma configuración que el servidor (DATOS_MASTER_KEY, DATOS_MASTER_KEYS_ANTERIORES o
+// DATOS_VAULT_TRANSIT_KEY) y puede correr mientras el servidor atiende pedidos.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/FolkodeGroup/mediapp/internal/config"
+	"github.com/FolkodeGroup/mediapp/internal/db"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/joho/godotenv"
+	"go.uber.org/zap"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		uso()
+		os.Exit(2)
+	}
+	_ = godotenv.Load()
+
+	var err error
+	switch os.Args[1] {
+	case "ejecutar":
+		err = ejecutar(os.Args[2:])
+	case "estado":
+		err = estado()
+	case "generar-clave":
+		err = generarClave()
+	default:
+		uso()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+}
+
+func uso() {
+	fmt.Fprintln(os.Stderr, `uso:
+  recifrar ejecutar [-lote 200]
+  recifrar estado
+  recifrar generar-clave`)
+}
+
+func servicio(logger *zap.Logger) (*services.RecifradoService, func(), error) {
+	cipher, err := config.DatosPersonalesCipher()
+	if err != nil {
+		return nil, nil, err
+	}
+	pool, err := db.Connect(zap.NewNop())
+	if err != nil {
+		return nil, nil, err
+	}
+	return services.NewRecifradoService(pool, cipher, logger), pool.Close, nil
+}
+
+func ejecutar(args []string) error {
+	fs := flag.NewFlagSet("ejecutar", flag.ExitOnError)
+	lote := fs.Int("lote", services.RecifradoLoteDefault, "filas recifradas por transacción")
+	fs.Parse(args)
+
+	s, cerrar, err := servicio(zap.NewNop())
+	if err != nil {
+		return err
+	}
+	defer cerrar()
+	s.SetLote(*lote)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	trabajo, err := s.Ejecutar(ctx, func(t services.TrabajoRecifrado) {
+		fmt.Printf("trabajo %d (versión %d): %d filas recifradas, %d errores\n", t.ID, t.VersionDestino, t.Procesadas, t.Errores)
+	})
+	if ctx.Err() != nil {
+		fmt.Printf("interrumpido; el trabajo %d se reanuda en la próxima ejecución\n", trabajo.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("OK: trabajo %d completado, %d filas recifradas a la versión %d\n", trabajo.ID, trabajo.Procesadas, trabajo.VersionDestino)
+	if trabajo.Errores > 0 {
+		return fmt.Errorf("%d filas no se pudieron recifrar (último error: %s)", trabajo.Errores, *trabajo.UltimoError)
+	}
+	return nil
+}
+
+func estado() error {
+	s, cerrar, err := servicio(zap.NewNop())
+	if err != nil {
+		return err
+	}
+	defer cerrar()
+
+	e, err := s.Estado(context.Background())
+	if err != nil {
+		return err
+	}
+	salida, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(salida))
+	return nil
+}
+
+// generarClave imprime una clave maestra nueva. Para rotar: mover la clave actual a
+// DATOS_MASTER_KEYS_ANTERIORES, usar esta como DATOS_MASTER_KEY con la versión siguiente
+// y ejecutar el recifrado.
+func generarClave() error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	fmt.Println("DATOS_MASTER_KEY=" + base64.StdEncoding.EncodeToString(key))
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -17,10 +18,14 @@ import (
 	"github.com/FolkodeGroup/mediapp/internal/auth"
 	"github.com/FolkodeGroup/mediapp/internal/config"
 	"github.com/FolkodeGroup/mediapp/internal/db"
+	"github.com/FolkodeGroup/mediapp/internal/diagnostico"
 	"github.com/FolkodeGroup/mediapp/internal/handlers"
 	"github.com/FolkodeGroup/mediapp/internal/logger"
 	"github.com/FolkodeGroup/mediapp/internal/middleware"
+	"github.com/FolkodeGroup/mediapp/internal/security"
 	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/FolkodeGroup/mediapp/migrations"
 
 	_ "github.com/FolkodeGroup/mediapp/docs"
 	swaggerFiles "github.com/swaggo/files"
@@ -38,8 +43,28 @@ func main() {
 	logger.Init()
 	defer logger.Sync()
 
-	// Inicializar autenticación JWT
-	auth.Init(logger.L())
+	// Inicializar autenticación JWT. En producción las claves son obligatorias; en
+	// desarrollo sin claves se usa una efímera.
+	jwtKeys, err := config.JWTKeys()
+	if err != nil && (os.Getenv("ENV") == "production" || !errors.Is(err, config.ErrJWTKeysNoConfiguradas)) {
+		logger.L().Fatal("No se pudieron cargar las claves JWT", zap.Error(err))
+	}
+	if err := auth.Init(logger.L(), jwtKeys); err != nil {
+		logger.L().Fatal("No se pudo inicializar la autenticación JWT", zap.Error(err))
+	}
+
+	// Algoritmo de las contraseñas nuevas; los hashes anteriores se recalculan en el login
+	hashConfig, err := config.HashContrasenas()
+	if err != nil {
+		logger.L().Fatal("Configuración de hash de contraseñas inválida", zap.Error(err))
+	}
+	if err := security.ConfigurarHash(hashConfig); err != nil {
+		logger.L().Fatal("Configuración de hash de contraseñas inválida", zap.Error(err))
+	}
+	politica, err := config.PoliticaContrasena()
+	if err != nil {
+		logger.L().Fatal("Política de contraseñas inválida", zap.Error(err))
+	}
 
 	// Conexión a la base de datos
 	pool, err := db.Connect(logger.L())
@@ -74,11 +99,122 @@ func main() {
 	}
 
 	// Crear handlers
-	// Usar el constructor con Redis cuando exista redisService para mantener
-	// la funcionalidad de refresh token; en entornos de tests se puede usar
-	// el constructor sin Redis.
-	authHandler := handlers.NewAuthHandlerWithRedis(logger.L(), pool, redisService)
+	// Los refresh tokens viven en Redis: se emiten en el login y se rotan en /refresh;
+	// en entornos de tests se puede usar el constructor sin Redis.
+	refreshTTL, err := config.RefreshTokenTTL()
+	if err != nil {
+		logger.L().Fatal("Configuración de refresh tokens inválida", zap.Error(err))
+	}
+	refreshService := services.NewRefreshTokenService(redisClient, refreshTTL, logger.L())
+	// Lista de access tokens revocados (logout, usuarios desactivados o con contraseña nueva)
+	revocationService := services.NewRevocationService(redisClient, refreshService, logger.L())
+	// Registro de sesiones por dispositivo; el middleware rechaza los tokens de las sesiones
+	// cerradas y lleva su última actividad
+	sessionService := services.NewSessionService(redisClient, refreshService, logger.L())
+	jwtAuth := middleware.JWTAuthMiddlewareWithSesiones(revocationService, sessionService)
+	// Los tokens emitidos a apps OAuth solo se aceptan en /oauth/userinfo
+	appAuth := middleware.JWTAuthMiddlewareParaApps(revocationService, sessionService)
+	// Las integraciones (laboratorios, facturación) usan una API key en lugar del JWT en las
+	// rutas de pacientes, turnos y recetas
+	apiKeyService := services.NewAPIKeyService(pool, logger.L())
+	integracionAuth := middleware.APIKeyOrJWT(apiKeyService, jwtAuth)
+
+	// Cifrado de datos personales (DNI, teléfono); sin clave los endpoints responden 503
+	// y la detección de duplicados no compara DNI
+	datosCipher, err := config.DatosPersonalesCipher()
 	pacienteHandler := handlers.NewPacienteHandler(pool, logger.L())
+	if err != nil {
+		logger.L().Warn("Cifrado de datos personales deshabilitado", zap.Error(err))
+		datosCipher = nil
+	} else {
+		pacienteHandler = handlers.NewPacienteHandlerWithDNI(pool, handlers.NuevoDescifradorDNI(datosCipher), logger.L())
+	}
+	datosPersonalesHandler := handlers.NewDatosPersonalesHandler(pool, datosCipher, logger.L())
+
+	// Recifrado tras rotar la clave maestra; corre en segundo plano hasta el apagado y un
+	// trabajo interrumpido se reanuda al iniciar
+	trabajosCtx, stopTrabajos := context.WithCancel(context.Background())
+	defer stopTrabajos()
+	var recifrador handlers.Recifrador
+	if datosCipher != nil {
+		recifradoService := services.NewRecifradoService(pool, datosCipher, logger.L())
+		if err := recifradoService.Reanudar(trabajosCtx); err != nil {
+			logger.L().Error("No se pudo reanudar el recifrado", zap.Error(err))
+		}
+		recifrador = recifradoService
+	}
+	cifradoHandler := handlers.NewCifradoHandler(recifrador, trabajosCtx, logger.L())
+
+	// Autenticación en dos pasos (TOTP); los secretos se cifran como los datos personales,
+	// así que sin esa clave no está disponible y los usuarios que la requieren no pueden
+	// completar el login
+	var mfa handlers.MFA
+	if datosCipher != nil {
+		mfa = services.NewMFAService(pool, datosCipher, redisClient, logger.L())
+	}
+	// Restablecimiento de contraseña y verificación de email por correo; en producción sin
+	// SMTP esos endpoints responden 501
+	var cuenta handlers.Cuenta
+	var correosUsuario handlers.CorreosUsuario
+	if correo, err := config.Mailer(logger.L()); err != nil {
+		logger.L().Warn("Envío de correos deshabilitado", zap.Error(err))
+	} else {
+		cuentaService := services.NewCuentaService(pool, correo, config.FrontendURL(), logger.L())
+		cuentaService.SetPolitica(politica)
+		cuenta, correosUsuario = cuentaService, cuentaService
+	}
+	authHandler := handlers.NewAuthHandlerWithRedis(logger.L(), pool, redisService)
+	authHandler.SetRefresh(refreshService, revocationService)
+	authHandler.SetMFA(mfa)
+	authHandler.SetCuenta(cuenta)
+	authHandler.SetSesiones(sessionService)
+	mfaHandler := handlers.NewMFAHandler(mfa, logger.L())
+
+	// Permisos por rol (roles/permisos/rol_permiso) con caché invalidada vía LISTEN/NOTIFY
+	permissionService := services.NewPermissionService(pool, logger.L())
+	listenCtx, stopListen := context.WithCancel(context.Background())
+	defer stopListen()
+	go permissionService.Listen(listenCtx, pool)
+	go revocationService.Listen(listenCtx, pool)
+	rolHandler := handlers.NewRolHandler(pool, permissionService, logger.L())
+	usuarioHandler := handlers.NewUsuarioHandler(pool, redisService, correosUsuario, revocationService, sessionService, logger.L())
+	turnoHandler := handlers.NewTurnoHandler(pool, logger.L())
+	historiaHandler := handlers.NewHistoriaHandler(pool, logger.L())
+
+	// Clave maestra para las claves de firma de recetas; sin ella solo se puede verificar
+	recetasKey, err := config.RecetasSigningKey()
+	if err != nil {
+		logger.L().Warn("Firma de recetas deshabilitada", zap.Error(err))
+	}
+	recetaHandler := handlers.NewRecetaHandler(pool, recetasKey, logger.L())
+
+	// Alcance por consultorio de cada request; el acceso entre consultorios queda auditado
+	tenantScope := middleware.TenantScope(permissionService, pool)
+
+	// Registro de accesos a datos clínicos y accesos de emergencia
+	accesoService := services.NewAccesoService(pool, logger.L())
+	accesoHandler := handlers.NewAccesoHandler(pool, logger.L())
+	apiKeyHandler := handlers.NewAPIKeyHandler(pool, logger.L())
+
+	// Proveedor OpenID Connect para el portal de pacientes y la app móvil: el usuario inicia
+	// sesión y autoriza la app en el frontend, y la app canjea el código en /oauth/token
+	oauthService := services.NewOAuthService(pool, redisClient, refreshService, logger.L())
+	oauthHandler := handlers.NewOAuthHandler(pool, oauthService, sessionService, config.OIDCIssuer(), config.OIDCAuthorizeURL(), logger.L())
+
+	// Diagnóstico para administradores: compara el esquema con las migraciones incluidas
+	// en el binario
+	var diagnosticoHandler *handlers.DiagnosticoHandler
+	diagnosticoHabilitado, err := config.DiagnosticoHabilitado()
+	if err != nil {
+		logger.L().Fatal("Configuración de diagnóstico inválida", zap.Error(err))
+	}
+	if diagnosticoHabilitado {
+		esquema, err := diagnostico.EsquemaEsperado(migrations.Archivos)
+		if err != nil {
+			logger.L().Fatal("No se pudieron leer las migraciones", zap.Error(err))
+		}
+		diagnosticoHandler = handlers.NewDiagnosticoHandler(diagnostico.New(pool, redisClient, esquema, logger.L()), logger.L())
+	}
 
 	// Crear router
 	router := gin.New()
@@ -87,7 +223,7 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"POST", "GET", "OPTIONS", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", middleware.HeaderAccesoEmergencia, middleware.HeaderMotivoAcceso, tenant.HeaderConsultorio, middleware.HeaderAPIKey},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
@@ -136,33 +272,182 @@ func main() {
 	// Health check real con acceso a pool de DB
 	router.GET("/health", handlers.HealthCheck(pool))
 
+	// Claves públicas para que otros servicios verifiquen los access tokens
+	router.GET("/.well-known/jwks.json", handlers.JWKS)
+
+	// OpenID Connect (Authorization Code + PKCE)
+	router.GET("/.well-known/openid-configuration", oauthHandler.Discovery)
+	oauth := router.Group("/oauth")
+	{
+		oauth.GET("/authorize", jwtAuth, oauthHandler.GetAutorizacion)
+		oauth.POST("/authorize", jwtAuth, oauthHandler.Autorizar)
+		oauth.POST("/token", oauthHandler.Token)
+		oauth.GET("/userinfo", appAuth, oauthHandler.UserInfo)
+		oauth.POST("/userinfo", appAuth, oauthHandler.UserInfo)
+	}
+
 	// Rutas de autenticación (protegidas por rate limiting)
 	authRoutes := router.Group("/")
 	{
-		authRoutes.POST("/register", authHandler.Register)
 		authRoutes.POST("/login", authHandler.Login)
+		authRoutes.POST("/login/mfa", authHandler.LoginMFA)
+		authRoutes.POST("/login/mfa/enrolar", authHandler.EnrolarMFALogin)
 		authRoutes.POST("/refresh", authHandler.RefreshToken)
-		authRoutes.GET("/protected", authHandler.ProtectedEndpoint)
+		authRoutes.POST("/password/olvido", authHandler.SolicitarRestablecimiento)
+		authRoutes.POST("/password/restablecer", authHandler.RestablecerContrasena)
+		authRoutes.POST("/email/verificar", authHandler.VerificarEmail)
+		authRoutes.POST("/invitacion/aceptar", authHandler.ActivarCuenta)
+		authRoutes.POST("/logout", jwtAuth, authHandler.Logout)
+		authRoutes.POST("/logout-all", jwtAuth, authHandler.LogoutAll)
+		authRoutes.GET("/protected", jwtAuth, authHandler.ProtectedEndpoint)
 	}
 
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
-		// Rutas de pacientes protegidas por JWT
+		// Rutas de pacientes protegidas por JWT. Las lecturas de datos clínicos quedan
+		// registradas y requieren asignación al paciente o acceso de emergencia.
+		pacienteDeRuta := middleware.PacienteFromParam("id")
 		pacientes := v1.Group("/pacientes")
-		pacientes.Use(middleware.JWTAuthMiddleware())
+		pacientes.Use(integracionAuth, tenantScope)
+		{
+			pacientes.GET("", middleware.RequirePermission(permissionService, "pacientes:read"), pacienteHandler.GetPacientes)
+			pacientes.GET("search", middleware.RequirePermission(permissionService, "pacientes:read"), pacienteHandler.SearchPacientes)
+			pacientes.GET(":id", middleware.RequirePermission(permissionService, "pacientes:read"), middleware.RequirePatientAccess(accesoService, "paciente", pacienteDeRuta), pacienteHandler.GetPaciente)
+			pacientes.POST("", middleware.RequirePermission(permissionService, "pacientes:write"), pacienteHandler.CreatePaciente)
+			pacientes.PUT(":id", middleware.RequirePermission(permissionService, "pacientes:write"), pacienteHandler.UpdatePaciente)
+			pacientes.DELETE(":id", middleware.RequirePermission(permissionService, "pacientes:delete"), pacienteHandler.DeletePaciente)
+
+			// Datos personales cifrados
+			pacientes.POST("buscar-dni", middleware.RequirePermission(permissionService, "pacientes:read"), datosPersonalesHandler.BuscarPorDNI)
+			pacientes.GET(":id/datos-personales", middleware.RequirePermission(permissionService, "pacientes:read"), middleware.RequirePatientAccess(accesoService, "datos_personales", pacienteDeRuta), datosPersonalesHandler.GetDatosPersonales)
+			pacientes.PUT(":id/datos-personales", middleware.RequirePermission(permissionService, "pacientes:write"), datosPersonalesHandler.PutDatosPersonales)
+			pacientes.DELETE(":id/datos-personales", middleware.RequirePermission(permissionService, "pacientes:write"), datosPersonalesHandler.DeleteDatosPersonales)
+
+			// Detección de duplicados y fusión de registros
+			pacientes.POST("duplicados", middleware.RequirePermission(permissionService, "pacientes:write"), pacienteHandler.BuscarDuplicados)
+			pacientes.POST(":id/fusionar", middleware.RequirePermission(permissionService, "pacientes:fusionar"), pacienteHandler.FusionarPacientes)
+
+			// Historias clínicas versionadas
+			pacientes.GET(":id/historias", middleware.RequirePermission(permissionService, "historias:read"), middleware.RequirePatientAccess(accesoService, "historias", pacienteDeRuta), historiaHandler.GetHistorias)
+			pacientes.POST(":id/historias", middleware.RequirePermission(permissionService, "historias:write"), historiaHandler.CreateHistoria)
+			pacientes.GET(":id/historias/:historia_id", middleware.RequirePermission(permissionService, "historias:read"), middleware.RequirePatientAccess(accesoService, "historias", pacienteDeRuta), historiaHandler.GetHistoria)
+			pacientes.GET(":id/historias/:historia_id/diff", middleware.RequirePermission(permissionService, "historias:read"), middleware.RequirePatientAccess(accesoService, "historias", pacienteDeRuta), historiaHandler.DiffHistoria)
+			pacientes.POST(":id/historias/:historia_id/versiones", middleware.RequirePermission(permissionService, "historias:write"), historiaHandler.AppendVersion)
+
+			pacientes.GET(":id/recetas", middleware.RequirePermission(permissionService, "recetas:read"), middleware.RequirePatientAccess(accesoService, "recetas", pacienteDeRuta), recetaHandler.GetRecetasByPaciente)
+
+			// Profesionales asignados y registro de accesos
+			pacientes.PUT(":id/profesionales/:usuario_id", middleware.RequirePermission(permissionService, "pacientes:write"), accesoHandler.AsignarProfesional)
+			pacientes.DELETE(":id/profesionales/:usuario_id", middleware.RequirePermission(permissionService, "pacientes:write"), accesoHandler.DesasignarProfesional)
+			pacientes.GET(":id/accesos", middleware.RequirePermission(permissionService, "accesos:revisar"), accesoHandler.GetAccesosPaciente)
+		}
+
+		// Agenda de turnos
+		turnos := v1.Group("/turnos")
+		turnos.Use(integracionAuth, tenantScope)
+		{
+			turnos.POST("", middleware.RequirePermission(permissionService, "turnos:write"), turnoHandler.CreateTurno)
+			turnos.PUT("/:id", middleware.RequirePermission(permissionService, "turnos:write"), turnoHandler.RescheduleTurno)
+			turnos.POST("/:id/cancelar", middleware.RequirePermission(permissionService, "turnos:write"), turnoHandler.CancelTurno)
+			turnos.GET("/profesional/:usuario_id", middleware.RequirePermission(permissionService, "turnos:read"), turnoHandler.GetTurnosByProfesional)
+			turnos.GET("/paciente/:paciente_id", middleware.RequirePermission(permissionService, "turnos:read"), turnoHandler.GetTurnosByPaciente)
+		}
+
+		// Recetas firmadas. La verificación es pública para que las farmacias
+		// puedan validar la receta sin credenciales.
+		v1.GET("/recetas/:id/verify", recetaHandler.VerifyReceta)
+		recetas := v1.Group("/recetas")
+		recetas.Use(integracionAuth, tenantScope)
+		{
+			recetas.POST("", middleware.RequirePermission(permissionService, "recetas:write"), recetaHandler.CreateReceta)
+			recetas.GET("/:id", middleware.RequirePermission(permissionService, "recetas:read"), middleware.RequirePatientAccess(accesoService, "recetas", recetaHandler.PacienteDeReceta), recetaHandler.GetReceta)
+			recetas.POST("/:id/revocar", middleware.RequirePermission(permissionService, "recetas:write"), recetaHandler.RevokeReceta)
+			recetas.POST("/:id/reemitir", middleware.RequirePermission(permissionService, "recetas:write"), recetaHandler.ReissueReceta)
+		}
+
+		// Revisión obligatoria de accesos de emergencia
+		accesos := v1.Group("/accesos")
+		accesos.Use(jwtAuth, tenantScope, middleware.RequirePermission(permissionService, "accesos:revisar"))
 		{
-			pacientes.GET("", pacienteHandler.GetPacientes)
-			pacientes.GET(":id", pacienteHandler.GetPaciente)
-			pacientes.POST("", pacienteHandler.CreatePaciente)
-			pacientes.PUT(":id", pacienteHandler.UpdatePaciente)
-			pacientes.DELETE(":id", pacienteHandler.DeletePaciente)
+			accesos.GET("/emergencia", accesoHandler.GetAccesosEmergencia)
+			accesos.POST("/:id/revision", accesoHandler.RevisarAcceso)
 		}
 
-		// Rutas de prueba y diagnóstico
-		v1.GET("/test/supabase", pacienteHandler.TestSupabaseConnection)
-		v1.GET("/inspect/tables", pacienteHandler.InspectTables)
-		v1.GET("/connect/all-tables", pacienteHandler.ConnectAllTables)
+		// Administración de permisos por rol
+		roles := v1.Group("/roles")
+		roles.Use(jwtAuth, middleware.RequirePermission(permissionService, "roles:manage"))
+		{
+			roles.GET("", rolHandler.GetRoles)
+			roles.PUT("/:id/permisos/:permiso", rolHandler.AddPermisoToRol)
+			roles.DELETE("/:id/permisos/:permiso", rolHandler.RemovePermisoFromRol)
+			roles.PUT("/:id/mfa", rolHandler.SetMFARequerido)
+		}
+
+		// Administración de usuarios del consultorio. El alta es solo por invitación: no hay
+		// registro público.
+		usuarios := v1.Group("/usuarios")
+		usuarios.Use(jwtAuth, tenantScope)
+		{
+			usuarios.GET("", middleware.RequirePermission(permissionService, "usuarios:read"), usuarioHandler.GetUsuarios)
+			usuarios.GET("/:id", middleware.RequirePermission(permissionService, "usuarios:read"), usuarioHandler.GetUsuario)
+			usuarios.GET("/:id/bloqueo", middleware.RequirePermission(permissionService, "usuarios:read"), usuarioHandler.GetBloqueoUsuario)
+			usuarios.GET("/:id/sessions", middleware.RequirePermission(permissionService, "usuarios:read"), usuarioHandler.GetSesionesUsuario)
+			usuarios.POST("", middleware.RequirePermission(permissionService, "usuarios:manage"), usuarioHandler.InvitarUsuario)
+			usuarios.POST("/:id/invitacion", middleware.RequirePermission(permissionService, "usuarios:manage"), usuarioHandler.ReenviarInvitacion)
+			usuarios.PUT("/:id/rol", middleware.RequirePermission(permissionService, "usuarios:manage"), usuarioHandler.CambiarRolUsuario)
+			usuarios.PUT("/:id/consultorio", middleware.RequirePermission(permissionService, "usuarios:manage"), usuarioHandler.CambiarConsultorioUsuario)
+			usuarios.POST("/:id/desactivar", middleware.RequirePermission(permissionService, "usuarios:manage"), usuarioHandler.DesactivarUsuario)
+			usuarios.POST("/:id/reactivar", middleware.RequirePermission(permissionService, "usuarios:manage"), usuarioHandler.ReactivarUsuario)
+			usuarios.POST("/:id/restablecer-contrasena", middleware.RequirePermission(permissionService, "usuarios:manage"), usuarioHandler.ForzarRestablecimiento)
+			usuarios.DELETE("/:id/bloqueo", middleware.RequirePermission(permissionService, "usuarios:manage"), usuarioHandler.DesbloquearUsuario)
+			usuarios.DELETE("/:id/sessions/:sesion", middleware.RequirePermission(permissionService, "usuarios:manage"), usuarioHandler.CerrarSesionUsuario)
+		}
+
+		// API keys de las integraciones del consultorio
+		apiKeys := v1.Group("/api-keys")
+		apiKeys.Use(jwtAuth, tenantScope, middleware.RequirePermission(permissionService, "api_keys:manage"))
+		{
+			apiKeys.GET("", apiKeyHandler.GetAPIKeys)
+			apiKeys.POST("", apiKeyHandler.CrearAPIKey)
+			apiKeys.DELETE("/:id", apiKeyHandler.RevocarAPIKey)
+		}
+
+		// Apps registradas en el proveedor OpenID Connect; sirven a todos los consultorios
+		oauthClientes := v1.Group("/oauth/clientes")
+		oauthClientes.Use(jwtAuth, middleware.RequirePermission(permissionService, "oauth_clientes:manage"))
+		{
+			oauthClientes.GET("", oauthHandler.GetClientesOAuth)
+			oauthClientes.POST("", oauthHandler.CrearClienteOAuth)
+			oauthClientes.DELETE("/:client_id", oauthHandler.RevocarClienteOAuth)
+		}
+
+		// Cuenta del usuario autenticado
+		me := v1.Group("/me")
+		me.Use(jwtAuth)
+		{
+			me.GET("/mfa", mfaHandler.GetEstadoMFA)
+			me.POST("/mfa", mfaHandler.IniciarMFA)
+			me.POST("/mfa/confirmar", mfaHandler.ConfirmarMFA)
+			me.POST("/mfa/recuperacion", mfaHandler.RegenerarCodigosMFA)
+			me.DELETE("/mfa", mfaHandler.DesactivarMFA)
+			me.POST("/email/verificacion", authHandler.ReenviarVerificacion)
+			me.GET("/sessions", authHandler.GetSesiones)
+			me.DELETE("/sessions/:id", authHandler.CerrarSesion)
+		}
+
+		// Rotación de la clave maestra de datos personales
+		cifrado := v1.Group("/cifrado")
+		cifrado.Use(jwtAuth, middleware.RequirePermission(permissionService, "claves:rotar"))
+		{
+			cifrado.GET("/estado", cifradoHandler.GetEstadoCifrado)
+			cifrado.POST("/recifrar", cifradoHandler.Recifrar)
+		}
+
+		// Diagnóstico del esquema, la base y Redis; en producción solo si se habilita
+		if diagnosticoHandler != nil {
+			v1.GET("/diagnostico", jwtAuth, middleware.RequirePermission(permissionService, "sistema:diagnostico"), diagnosticoHandler.GetDiagnostico)
+		}
 	}
 
 	// Puerto
@@ -0,0 +1,251 @@
+// Package audit registra en la tabla auditorias quién modificó qué, cuándo y desde dónde.
+// Los registros se escriben con la misma transacción que el cambio auditado, de modo que
+// un cambio confirmado siempre tiene su fila de auditoría. Cada registro se encadena al
+// anterior de su consultorio mediante un hash, lo que permite detectar ediciones o
+// borrados posteriores (ver chain.go y cmd/auditchain).
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Acciones auditadas
+const (
+	AccionCrear        = "crear"
+	AccionActualizar   = "actualizar"
+	AccionEliminar     = "eliminar"
+	AccionLogin        = "login"
+	AccionLoginFallido = "login_fallido"
+	AccionRegistro     = "registro"
+	// AccionAccesoConsultorio registra un request de super-admin sobre otro consultorio
+	AccionAccesoConsultorio = "acceso_consultorio"
+	// AccionFusionar registra la fusión de un paciente duplicado en otro
+	AccionFusionar = "fusionar"
+	// AccionRefreshReutilizado registra el uso de un refresh token ya rotado (posible robo)
+	AccionRefreshReutilizado = "refresh_reutilizado"
+	// AccionLogout registra el cierre de una sesión o de todas las del usuario
+	AccionLogout = "logout"
+	// AccionMFAActivado y AccionMFADesactivado registran altas y bajas de la autenticación
+	// en dos pasos; AccionMFARecuperacion la regeneración de los códigos de recuperación
+	AccionMFAActivado     = "mfa_activado"
+	AccionMFADesactivado  = "mfa_desactivado"
+	AccionMFARecuperacion = "mfa_recuperacion"
+	// AccionRestablecerContrasena registra el cambio de contraseña con un token enviado por
+	// correo; AccionEmailVerificado la verificación del email
+	AccionRestablecerContrasena = "restablecer_contrasena"
+	AccionEmailVerificado       = "email_verificado"
+	// AccionDesbloquear registra el desbloqueo manual de una cuenta por un administrador
+	AccionDesbloquear = "desbloquear"
+	// Administración de usuarios: alta por invitación y su activación, cambios de rol y de
+	// consultorio, bajas y altas, y restablecimiento de contraseña forzado
+	AccionInvitar                = "invitar"
+	AccionActivarCuenta          = "activar_cuenta"
+	AccionCambiarRol             = "cambiar_rol"
+	AccionCambiarConsultorio     = "cambiar_consultorio"
+	AccionDesactivar             = "desactivar"
+	AccionReactivar              = "reactivar"
+	AccionForzarRestablecimiento = "forzar_restablecimiento"
+	// AccionCerrarSesion registra el cierre de una sesión desde otro dispositivo o por un
+	// administrador
+	AccionCerrarSesion = "cerrar_sesion"
+	// AccionCrearAPIKey y AccionRevocarAPIKey registran el alta y la baja de API keys
+	AccionCrearAPIKey   = "crear_api_key"
+	AccionRevocarAPIKey = "revocar_api_key"
+	// AccionAutorizarOAuth registra el consentimiento de un usuario a una app OAuth;
+	// AccionCrearClienteOAuth y AccionRevocarClienteOAuth el alta y la baja de las apps
+	AccionAutorizarOAuth      = "autorizar_oauth"
+	AccionCrearClienteOAuth   = "crear_cliente_oauth"
+	AccionRevocarClienteOAuth = "revocar_cliente_oauth"
+	// AccionAsignarPermiso y AccionQuitarPermiso registran los cambios de permisos de un rol
+	AccionAsignarPermiso = "asignar_permiso"
+	AccionQuitarPermiso  = "quitar_permiso"
+	// AccionRevocarReceta y AccionReemitirReceta registran la revocación de una receta
+	// firmada, sola o reemplazada por otra
+	AccionRevocarReceta  = "revocar_receta"
+	AccionReemitirReceta = "reemitir_receta"
+)
+
+// Querier es la parte de pgx.Tx que necesita Snapshot
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// DB es la parte de pgx.Tx que necesita Write
+type DB interface {
+	Querier
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// TxBeginner es la parte del pool de conexiones que necesita ExecTx
+type TxBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Entry es un registro de auditoría. Antes y Despues se serializan a JSON;
+// pueden ser json.RawMessage (por ejemplo el resultado de Snapshot) o cualquier valor.
+type Entry struct {
+	// ConsultorioID elige la cadena; si es nil se usa el consultorio del usuario
+	ConsultorioID *uuid.UUID
+	UsuarioID     *uuid.UUID
+	// APIKeyID es la API key con que se hizo el request, si no fue un usuario
+	APIKeyID      *uuid.UUID
+	Accion        string
+	TablaAfectada string
+	RegistroID    string
+	RequestID     string
+	IP            string
+	Antes         interface{}
+	Despues       interface{}
+}
+
+// FromRequest arma una entrada con el usuario autenticado, el request_id y la IP del cliente.
+// Con una API key la entrada queda a nombre de la key, en la cadena de su consultorio.
+func FromRequest(c *gin.Context, accion, tabla, registroID string) Entry {
+	e := Entry{
+		Accion:        accion,
+		TablaAfectada: tabla,
+		RegistroID:    registroID,
+		RequestID:     c.GetString("request_id"),
+	}
+	if c.Request != nil {
+		e.IP = utils.GetRealIP(c.Request)
+	}
+	if raw := c.GetString("user_id"); raw != "" {
+		if id, err := uuid.Parse(raw); err == nil {
+			e.UsuarioID = &id
+		}
+	}
+	if raw := c.GetString("api_key_id"); raw != "" {
+		if id, err := uuid.Parse(raw); err == nil {
+			e.APIKeyID = &id
+		}
+		if consultorioID, err := uuid.Parse(c.GetString("consultorio_id")); err == nil {
+			e.ConsultorioID = &consultorioID
+		}
+	}
+	return e
+}
+
+// Write inserta la entrada usando db, que debería ser la transacción del cambio auditado.
+// La cadena del consultorio queda bloqueada hasta que esa transacción termine.
+// Si devuelve error el llamador debe descartar la transacción.
+func Write(ctx context.Context, db DB, e Entry) error {
+	antes, err := marshal(e.Antes)
+	if err != nil {
+		return fmt.Errorf("error serializando datos_antes: %w", err)
+	}
+	despues, err := marshal(e.Despues)
+	if err != nil {
+		return fmt.Errorf("error serializando datos_despues: %w", err)
+	}
+
+	consultorioID := e.ConsultorioID
+	if consultorioID == nil && e.UsuarioID != nil {
+		err := db.QueryRow(ctx, `SELECT consultorio_id FROM usuarios WHERE id = $1`, *e.UsuarioID).Scan(&consultorioID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("error obteniendo consultorio del usuario: %w", err)
+		}
+	}
+
+	secuencia, hashAnterior, err := cabeza(ctx, db, consultorioID)
+	if err != nil {
+		return fmt.Errorf("error obteniendo cabeza de la cadena de auditoría: %w", err)
+	}
+	eslabon := Eslabon{
+		ConsultorioID: consultorioID,
+		Secuencia:     secuencia + 1,
+		UsuarioID:     e.UsuarioID,
+		APIKeyID:      e.APIKeyID,
+		Accion:        e.Accion,
+		TablaAfectada: e.TablaAfectada,
+		RegistroID:    nullIfEmpty(e.RegistroID),
+		RequestID:     nullIfEmpty(e.RequestID),
+		IP:            nullIfEmpty(e.IP),
+		DatosAntes:    antes,
+		DatosDespues:  despues,
+		Fecha:         time.Now().UTC().Truncate(time.Microsecond),
+		HashAnterior:  hashAnterior,
+	}
+	hash, err := eslabon.Hash()
+	if err != nil {
+		return fmt.Errorf("error calculando hash de auditoría: %w", err)
+	}
+
+	_, err = db.Exec(ctx, `
+		INSERT INTO auditorias (usuario_id, accion, tabla_afectada, registro_id, request_id, ip, datos_antes, datos_despues, fecha,
+			consultorio_id, secuencia, hash_anterior, hash, api_key_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`, eslabon.UsuarioID, eslabon.Accion, eslabon.TablaAfectada, eslabon.RegistroID, eslabon.RequestID, eslabon.IP,
+		eslabon.DatosAntes, eslabon.DatosDespues, eslabon.Fecha, eslabon.ConsultorioID, eslabon.Secuencia, eslabon.HashAnterior, hash,
+		eslabon.APIKeyID)
+	if err != nil {
+		return fmt.Errorf("error escribiendo auditoría: %w", err)
+	}
+	return nil
+}
+
+// ExecTx ejecuta la sentencia (si hay) y escribe la entrada en una misma transacción, que
+// se confirma solo si ambas tienen éxito. Sin sentencia solo se registra la entrada.
+func ExecTx(ctx context.Context, db TxBeginner, e Entry, sql string, args ...interface{}) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if sql != "" {
+		if _, err := tx.Exec(ctx, sql, args...); err != nil {
+			return err
+		}
+	}
+	if err := Write(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Snapshot devuelve la fila completa como JSON. tabla debe ser una constante del código,
+// nunca un valor recibido del cliente. Con forUpdate la fila queda bloqueada hasta el
+// fin de la transacción. Devuelve pgx.ErrNoRows si la fila no existe.
+func Snapshot(ctx context.Context, db Querier, tabla string, id interface{}, forUpdate bool) (json.RawMessage, error) {
+	query := `SELECT row_to_json(t) FROM ` + tabla + ` t WHERE t.id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	var snapshot []byte
+	if err := db.QueryRow(ctx, query, id).Scan(&snapshot); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(snapshot), nil
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	switch d := v.(type) {
+	case nil:
+		return nil, nil
+	case json.RawMessage:
+		return CanonicalJSON(d)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return CanonicalJSON(b)
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeDB guarda el último INSERT; sus consultas no encuentran filas salvo que
+// se configure la cabeza de la cadena
+type fakeDB struct {
+	args      []interface{}
+	err       error
+	secuencia int64
+	hash      string
+}
+
+func (f *fakeDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if strings.Contains(sql, "INSERT INTO auditorias") {
+		f.args = args
+		return pgconn.NewCommandTag("INSERT 0 1"), f.err
+	}
+	return pgconn.NewCommandTag("SELECT 1"), nil
+}
+
+func (f *fakeDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return fakeRow{scan: func(dest ...interface{}) error {
+		if !strings.Contains(sql, "ORDER BY secuencia DESC") || f.hash == "" {
+			return pgx.ErrNoRows
+		}
+		*dest[0].(*int64) = f.secuencia
+		*dest[1].(*string) = f.hash
+		return nil
+	}}
+}
+
+type fakeRow struct {
+	scan func(dest ...interface{}) error
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error { return r.scan(dest...) }
+
+func TestFromRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("PUT", "/api/v1/pacientes/1", nil)
+	c.Request.Header.Set("X-Real-IP", "10.0.0.7")
+	usuario := uuid.New()
+	c.Set("user_id", usuario.String())
+	c.Set("request_id", "req-123")
+
+	e := FromRequest(c, AccionActualizar, "pacientes", "1")
+
+	if e.UsuarioID == nil || *e.UsuarioID != usuario {
+		t.Errorf("Se esperaba usuario %s, obtuvo %v", usuario, e.UsuarioID)
+	}
+	if e.RequestID != "req-123" || e.IP != "10.0.0.7" {
+		t.Errorf("Se esperaba request_id e IP del request, obtuvo %q y %q", e.RequestID, e.IP)
+	}
+	if e.Accion != AccionActualizar || e.TablaAfectada != "pacientes" || e.RegistroID != "1" {
+		t.Errorf("Entrada inesperada: %+v", e)
+	}
+}
+
+func TestFromRequest_Anonymous(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/register", nil)
+
+	e := FromRequest(c, AccionRegistro, "usuarios", "x")
+	if e.UsuarioID != nil {
+		t.Errorf("Se esperaba usuario nulo para un request anónimo, obtuvo %v", e.UsuarioID)
+	}
+}
+
+// TestFromRequest_APIKey verifica que un request con API key queda a nombre de la key y en
+// la cadena de su consultorio
+func TestFromRequest_APIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/v1/turnos", nil)
+	key, consultorio := uuid.New(), uuid.New()
+	c.Set("api_key_id", key.String())
+	c.Set("consultorio_id", consultorio.String())
+
+	e := FromRequest(c, AccionCrear, "turnos", "1")
+	if e.UsuarioID != nil || e.APIKeyID == nil || *e.APIKeyID != key {
+		t.Errorf("Se esperaba la API key %s sin usuario, obtuvo %v y %v", key, e.APIKeyID, e.UsuarioID)
+	}
+	if e.ConsultorioID == nil || *e.ConsultorioID != consultorio {
+		t.Errorf("Se esperaba la cadena del consultorio %s, obtuvo %v", consultorio, e.ConsultorioID)
+	}
+
+	// La API key queda cubierta por el hash del eslabón
+	sin := Eslabon{Accion: AccionCrear, TablaAfectada: "turnos", HashAnterior: GenesisHash}
+	con := sin
+	con.APIKeyID = &key
+	h1, _ := sin.Hash()
+	h2, _ := con.Hash()
+	if h1 == h2 {
+		t.Error("Se esperaba que la API key forme parte del hash")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	db := &fakeDB{}
+	usuario := uuid.New()
+	err := Write(context.Background(), db, Entry{
+		UsuarioID:     &usuario,
+		Accion:        AccionActualizar,
+		TablaAfectada: "pacientes",
+		RegistroID:    "1",
+		Antes:         json.RawMessage(`{"nombre":"Ana"}`),
+		Despues:       map[string]string{"nombre": "Ana María"},
+	})
+	if err != nil {
+		t.Fatalf("No se esperaba error: %v", err)
+	}
+
+	if string(db.args[6].([]byte)) != `{"nombre":"Ana"}` {
+		t.Errorf("datos_antes inesperado: %s", db.args[6])
+	}
+	if string(db.args[7].([]byte)) != `{"nombre":"Ana María"}` {
+		t.Errorf("datos_despues inesperado: %s", db.args[7])
+	}
+	if db.args[4].(*string) != nil || db.args[5].(*string) != nil {
+		t.Error("Se esperaba NULL para request_id e IP vacíos")
+	}
+}
+
+func TestWrite_NilSnapshots(t *testing.T) {
+	db := &fakeDB{}
+	if err := Write(context.Background(), db, Entry{Accion: AccionEliminar, TablaAfectada: "pacientes"}); err != nil {
+		t.Fatalf("No se esperaba error: %v", err)
+	}
+	if db.args[6].([]byte) != nil || db.args[7].([]byte) != nil {
+		t.Error("Se esperaba NULL para fotos vacías")
+	}
+}
+
+func TestWrite_Error(t *testing.T) {
+	db := &fakeDB{err: errors.New("conexión cerrada")}
+	if err := Write(context.Background(), db, Entry{Accion: AccionCrear, TablaAfectada: "pacientes"}); err == nil {
+		t.Error("Se esperaba que el error de la base se propagara")
+	}
+}
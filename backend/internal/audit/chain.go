@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// GenesisHash es el hash_anterior del primer eslabón de cada cadena
+var GenesisHash = strings.Repeat("0", 64)
+
+// Eslabon son los campos de una fila de auditoría cubiertos por su hash
+type Eslabon struct {
+	ConsultorioID *uuid.UUID
+	Secuencia     int64
+	UsuarioID     *uuid.UUID
+	APIKeyID      *uuid.UUID
+	Accion        string
+	TablaAfectada string
+	RegistroID    *string
+	RequestID     *string
+	IP            *string
+	DatosAntes    []byte
+	DatosDespues  []byte
+	Fecha         time.Time
+	HashAnterior  string
+}
+
+// eslabonCanonico fija el orden de los campos al serializar
+type eslabonCanonico struct {
+	ConsultorioID *uuid.UUID      `json:"consultorio_id"`
+	Secuencia     int64           `json:"secuencia"`
+	UsuarioID     *uuid.UUID      `json:"usuario_id"`
+	Accion        string          `json:"accion"`
+	TablaAfectada string          `json:"tabla_afectada"`
+	RegistroID    *string         `json:"registro_id"`
+	RequestID     *string         `json:"request_id"`
+	IP            *string         `json:"ip"`
+	DatosAntes    json.RawMessage `json:"datos_antes"`
+	DatosDespues  json.RawMessage `json:"datos_despues"`
+	Fecha         string          `json:"fecha"`
+	HashAnterior  string          `json:"hash_anterior"`
+	// Se omite si es nulo para que los eslabones anteriores a las API keys conserven su hash
+	APIKeyID *uuid.UUID `json:"api_key_id,omitempty"`
+}
+
+// Hash calcula el SHA-256 (hex) de la serialización canónica del eslabón
+func (e Eslabon) Hash() (string, error) {
+	antes, err := CanonicalJSON(e.DatosAntes)
+	if err != nil {
+		return "", fmt.Errorf("datos_antes: %w", err)
+	}
+	despues, err := CanonicalJSON(e.DatosDespues)
+	if err != nil {
+		return "", fmt.Errorf("datos_despues: %w", err)
+	}
+	b, err := json.Marshal(eslabonCanonico{
+		ConsultorioID: e.ConsultorioID,
+		Secuencia:     e.Secuencia,
+		UsuarioID:     e.UsuarioID,
+		Accion:        e.Accion,
+		TablaAfectada: e.TablaAfectada,
+		RegistroID:    e.RegistroID,
+		RequestID:     e.RequestID,
+		IP:            e.IP,
+		DatosAntes:    antes,
+		DatosDespues:  despues,
+		Fecha:         e.Fecha.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		HashAnterior:  e.HashAnterior,
+		APIKeyID:      e.APIKeyID,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CanonicalJSON reescribe un documento JSON con las claves ordenadas y sin espacios.
+// PostgreSQL reordena los JSONB al guardarlos, así que el hash se calcula siempre
+// sobre esta forma y no sobre los bytes originales.
+func CanonicalJSON(data []byte) (json.RawMessage, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// claveCadena identifica la cadena de un consultorio; las acciones sin consultorio
+// forman su propia cadena
+func claveCadena(consultorioID *uuid.UUID) string {
+	if consultorioID == nil {
+		return "auditorias:global"
+	}
+	return "auditorias:" + consultorioID.String()
+}
+
+// cabeza bloquea la cadena hasta el fin de la transacción y devuelve su último eslabón
+func cabeza(ctx context.Context, db DB, consultorioID *uuid.UUID) (int64, string, error) {
+	if _, err := db.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, claveCadena(consultorioID)); err != nil {
+		return 0, "", err
+	}
+	var (
+		secuencia int64
+		hash      string
+	)
+	err := db.QueryRow(ctx, `
+		SELECT secuencia, hash FROM auditorias
+		WHERE consultorio_id IS NOT DISTINCT FROM $1 AND secuencia IS NOT NULL
+		ORDER BY secuencia DESC
+		LIMIT 1
+	`, consultorioID).Scan(&secuencia, &hash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, GenesisHash, nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return secuencia, hash, nil
+}
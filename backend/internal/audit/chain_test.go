@@ -0,0 +1,203 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// filaGuardada es un eslabón tal como quedó en la tabla
+type filaGuardada struct {
+	id   int64
+	e    Eslabon
+	hash string
+}
+
+// fakeRows devuelve las filas guardadas en el orden de VerificarCadena
+type fakeRows struct {
+	pgx.Rows
+	filas []filaGuardada
+	i     int
+}
+
+func (r *fakeRows) Next() bool { r.i++; return r.i <= len(r.filas) }
+func (r *fakeRows) Close()     {}
+func (r *fakeRows) Err() error { return nil }
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	f := r.filas[r.i-1]
+	texto := func(b []byte) *string {
+		if b == nil {
+			return nil
+		}
+		s := string(b)
+		return &s
+	}
+	*dest[0].(*int64) = f.id
+	*dest[1].(**uuid.UUID) = f.e.ConsultorioID
+	*dest[2].(*int64) = f.e.Secuencia
+	*dest[3].(**uuid.UUID) = f.e.UsuarioID
+	*dest[4].(*string) = f.e.Accion
+	*dest[5].(*string) = f.e.TablaAfectada
+	*dest[6].(**string) = f.e.RegistroID
+	*dest[7].(**string) = f.e.RequestID
+	*dest[8].(**string) = f.e.IP
+	*dest[9].(**string) = texto(f.e.DatosAntes)
+	*dest[10].(**string) = texto(f.e.DatosDespues)
+	*dest[11].(*time.Time) = f.e.Fecha
+	*dest[12].(*string) = f.e.HashAnterior
+	*dest[13].(*string) = f.hash
+	*dest[14].(**uuid.UUID) = f.e.APIKeyID
+	return nil
+}
+
+type fakeTabla struct {
+	filas []filaGuardada
+}
+
+func (t *fakeTabla) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return &fakeRows{filas: t.filas}, nil
+}
+
+// cadenaDePrueba escribe n eslabones con Write y los devuelve como quedarían guardados
+func cadenaDePrueba(t *testing.T, consultorio *uuid.UUID, n int) *fakeTabla {
+	t.Helper()
+	tabla := &fakeTabla{}
+	db := &fakeDB{}
+	for i := 0; i < n; i++ {
+		if err := Write(context.Background(), db, Entry{
+			ConsultorioID: consultorio,
+			Accion:        AccionActualizar,
+			TablaAfectada: "pacientes",
+			RegistroID:    "p1",
+			Antes:         map[string]interface{}{"nombre": "Ana", "edad": i},
+			Despues:       map[string]interface{}{"edad": i + 1, "nombre": "Ana"},
+		}); err != nil {
+			t.Fatalf("Error al escribir auditoría: %v", err)
+		}
+		a := db.args
+		fila := filaGuardada{
+			id: int64(i + 1),
+			e: Eslabon{
+				ConsultorioID: a[9].(*uuid.UUID),
+				Secuencia:     a[10].(int64),
+				UsuarioID:     a[0].(*uuid.UUID),
+				Accion:        a[1].(string),
+				TablaAfectada: a[2].(string),
+				RegistroID:    a[3].(*string),
+				RequestID:     a[4].(*string),
+				IP:            a[5].(*string),
+				DatosAntes:    a[6].([]byte),
+				DatosDespues:  a[7].([]byte),
+				Fecha:         a[8].(time.Time),
+				HashAnterior:  a[11].(string),
+			},
+			hash: a[12].(string),
+		}
+		tabla.filas = append(tabla.filas, fila)
+		db.secuencia, db.hash = fila.e.Secuencia, fila.hash
+	}
+	return tabla
+}
+
+func TestCanonicalJSON_IgnoresKeyOrderAndSpacing(t *testing.T) {
+	a, _ := CanonicalJSON([]byte(`{"b": 1, "a": {"y": 2.50, "x": [1, "<>"]}}`))
+	b, _ := CanonicalJSON([]byte(`{"a":{"x":[1,"<>"],"y":2.50},"b":1}`))
+	if string(a) != string(b) {
+		t.Errorf("Se esperaba la misma forma canónica, obtuvo %s y %s", a, b)
+	}
+}
+
+func TestWrite_ChainsToPreviousEntry(t *testing.T) {
+	consultorio := uuid.New()
+	tabla := cadenaDePrueba(t, &consultorio, 3)
+
+	if tabla.filas[0].e.HashAnterior != GenesisHash {
+		t.Errorf("El primer eslabón debe apuntar al hash génesis, obtuvo %s", tabla.filas[0].e.HashAnterior)
+	}
+	for i := 1; i < 3; i++ {
+		if tabla.filas[i].e.Secuencia != int64(i+1) || tabla.filas[i].e.HashAnterior != tabla.filas[i-1].hash {
+			t.Errorf("El eslabón %d no está encadenado al anterior", i+1)
+		}
+	}
+}
+
+func TestVerificarCadena_Valid(t *testing.T) {
+	consultorio := uuid.New()
+	tabla := cadenaDePrueba(t, &consultorio, 3)
+
+	cab, err := VerificarCadena(context.Background(), tabla, &consultorio, nil)
+	if err != nil {
+		t.Fatalf("Se esperaba cadena válida, obtuvo: %v", err)
+	}
+	if cab.Secuencia != 3 || cab.Hash != tabla.filas[2].hash {
+		t.Errorf("Cabeza inesperada: %+v", cab)
+	}
+}
+
+func TestVerificarCadena_DetectsEditedEntry(t *testing.T) {
+	tabla := cadenaDePrueba(t, nil, 3)
+	// Alguien con acceso a la base cambia la foto posterior del segundo registro
+	tabla.filas[1].e.DatosDespues = []byte(`{"edad": 99, "nombre": "Ana"}`)
+
+	_, err := VerificarCadena(context.Background(), tabla, nil, nil)
+	var ruptura *Ruptura
+	if !errors.As(err, &ruptura) || ruptura.Secuencia != 2 {
+		t.Fatalf("Se esperaba ruptura en la secuencia 2, obtuvo: %v", err)
+	}
+}
+
+func TestVerificarCadena_DetectsDeletedEntry(t *testing.T) {
+	tabla := cadenaDePrueba(t, nil, 3)
+	tabla.filas = append(tabla.filas[:1], tabla.filas[2:]...)
+
+	_, err := VerificarCadena(context.Background(), tabla, nil, nil)
+	var ruptura *Ruptura
+	if !errors.As(err, &ruptura) || ruptura.Secuencia != 3 {
+		t.Fatalf("Se esperaba ruptura en la secuencia 3, obtuvo: %v", err)
+	}
+}
+
+func TestVerificarCadena_DetectsTruncationAgainstCheckpoint(t *testing.T) {
+	tabla := cadenaDePrueba(t, nil, 3)
+	ancla := &Cabeza{Secuencia: 3, Hash: tabla.filas[2].hash}
+	tabla.filas = tabla.filas[:2]
+
+	_, err := VerificarCadena(context.Background(), tabla, nil, ancla)
+	var ruptura *Ruptura
+	if !errors.As(err, &ruptura) {
+		t.Fatalf("Se esperaba detectar el truncamiento, obtuvo: %v", err)
+	}
+}
+
+func TestCheckpoint_SignAndVerify(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	consultorio := uuid.New()
+	cadenas := []Cabeza{{ConsultorioID: &consultorio, Secuencia: 10, Hash: "abc"}, {Secuencia: 2, Hash: "def"}}
+
+	cp, err := FirmarCheckpoint(priv, cadenas, time.Now())
+	if err != nil {
+		t.Fatalf("Error al firmar checkpoint: %v", err)
+	}
+	if err := cp.Verificar(pub); err != nil {
+		t.Errorf("Se esperaba checkpoint válido, obtuvo: %v", err)
+	}
+	if a := cp.Ancla(nil); a == nil || a.Secuencia != 2 {
+		t.Errorf("Se esperaba el ancla de la cadena global, obtuvo %+v", a)
+	}
+
+	otraPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := cp.Verificar(otraPub); err == nil {
+		t.Error("Se esperaba rechazar un checkpoint firmado con otra clave")
+	}
+
+	cp.Cadenas[0].Hash = "xyz"
+	if err := cp.Verificar(pub); err == nil {
+		t.Error("Se esperaba rechazar un checkpoint alterado")
+	}
+}
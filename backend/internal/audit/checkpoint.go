@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Checkpoint es una foto firmada de las cabezas de todas las cadenas. Guardado fuera
+// de la base (otro servidor, almacenamiento WORM, correo al auditor), permite probar
+// que la historia anterior no fue reescrita aunque alguien recalcule todos los hashes.
+type Checkpoint struct {
+	GeneradoEn   time.Time `json:"generado_en"`
+	Cadenas      []Cabeza  `json:"cadenas"`
+	ClavePublica string    `json:"clave_publica"`
+	Firma        string    `json:"firma"`
+}
+
+// contenidoFirmado son los bytes que cubre la firma del checkpoint
+func (cp Checkpoint) contenidoFirmado() ([]byte, error) {
+	return json.Marshal(struct {
+		GeneradoEn   string   `json:"generado_en"`
+		Cadenas      []Cabeza `json:"cadenas"`
+		ClavePublica string   `json:"clave_publica"`
+	}{
+		GeneradoEn:   cp.GeneradoEn.UTC().Format(time.RFC3339Nano),
+		Cadenas:      cp.Cadenas,
+		ClavePublica: cp.ClavePublica,
+	})
+}
+
+// FirmarCheckpoint crea un checkpoint de las cabezas dadas firmado con priv
+func FirmarCheckpoint(priv ed25519.PrivateKey, cadenas []Cabeza, ahora time.Time) (Checkpoint, error) {
+	cp := Checkpoint{
+		GeneradoEn:   ahora.UTC(),
+		Cadenas:      cadenas,
+		ClavePublica: base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)),
+	}
+	msg, err := cp.contenidoFirmado()
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	cp.Firma = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, msg))
+	return cp, nil
+}
+
+// Verificar comprueba la firma del checkpoint. Si esperada no es nil, la clave que lo
+// firmó debe ser esa; sin fijar la clave, cualquiera con acceso a la base podría
+// generar un checkpoint nuevo.
+func (cp Checkpoint) Verificar(esperada ed25519.PublicKey) error {
+	pub, err := base64.StdEncoding.DecodeString(cp.ClavePublica)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("clave pública del checkpoint inválida")
+	}
+	if esperada != nil && !esperada.Equal(ed25519.PublicKey(pub)) {
+		return errors.New("el checkpoint no fue firmado con la clave esperada")
+	}
+	firma, err := base64.StdEncoding.DecodeString(cp.Firma)
+	if err != nil {
+		return fmt.Errorf("firma del checkpoint inválida: %w", err)
+	}
+	msg, err := cp.contenidoFirmado()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), msg, firma) {
+		return errors.New("la firma del checkpoint no es válida")
+	}
+	return nil
+}
+
+// Ancla devuelve la cabeza registrada en el checkpoint para el consultorio, si existe
+func (cp Checkpoint) Ancla(consultorioID *uuid.UUID) *Cabeza {
+	for i := range cp.Cadenas {
+		c := cp.Cadenas[i]
+		if mismoConsultorio(c.ConsultorioID, consultorioID) {
+			return &c
+		}
+	}
+	return nil
+}
+
+func mismoConsultorio(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
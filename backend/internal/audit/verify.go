@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Rows es la parte de un pool que necesita la verificación
+type Rows interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// Cabeza es el último eslabón verificado de una cadena
+type Cabeza struct {
+	ConsultorioID *uuid.UUID `json:"consultorio_id"`
+	Secuencia     int64      `json:"secuencia"`
+	Hash          string     `json:"hash"`
+}
+
+// Ruptura describe el primer eslabón que no coincide con la cadena
+type Ruptura struct {
+	ConsultorioID *uuid.UUID
+	Secuencia     int64
+	AuditoriaID   int64
+	Motivo        string
+}
+
+func (r *Ruptura) Error() string {
+	consultorio := "global"
+	if r.ConsultorioID != nil {
+		consultorio = r.ConsultorioID.String()
+	}
+	return fmt.Sprintf("cadena %s rota en la secuencia %d (auditoría %d): %s", consultorio, r.Secuencia, r.AuditoriaID, r.Motivo)
+}
+
+// Consultorios devuelve las cadenas existentes; nil representa la cadena global
+func Consultorios(ctx context.Context, db Rows) ([]*uuid.UUID, error) {
+	rows, err := db.Query(ctx, `SELECT DISTINCT consultorio_id FROM auditorias WHERE secuencia IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var consultorios []*uuid.UUID
+	for rows.Next() {
+		var id *uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		consultorios = append(consultorios, id)
+	}
+	return consultorios, rows.Err()
+}
+
+// VerificarCadena recorre la cadena del consultorio en orden y recalcula cada hash.
+// Si ancla no es nil (tomada de un checkpoint firmado), además exige que el eslabón
+// de esa secuencia siga existiendo con el mismo hash, lo que detecta que se haya
+// truncado o reescrito la cadena completa. Devuelve la cabeza verificada, o una
+// *Ruptura con el primer eslabón roto.
+func VerificarCadena(ctx context.Context, db Rows, consultorioID *uuid.UUID, ancla *Cabeza) (Cabeza, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, consultorio_id, secuencia, usuario_id, accion, tabla_afectada, registro_id, request_id, ip,
+			datos_antes::text, datos_despues::text, fecha, hash_anterior, hash, api_key_id
+		FROM auditorias
+		WHERE consultorio_id IS NOT DISTINCT FROM $1 AND secuencia IS NOT NULL
+		ORDER BY secuencia
+	`, consultorioID)
+	if err != nil {
+		return Cabeza{}, err
+	}
+	defer rows.Close()
+
+	cab := Cabeza{ConsultorioID: consultorioID, Hash: GenesisHash}
+	for rows.Next() {
+		var (
+			id             int64
+			e              Eslabon
+			antes, despues *string
+			hashAnterior   string
+			hash           string
+		)
+		if err := rows.Scan(&id, &e.ConsultorioID, &e.Secuencia, &e.UsuarioID, &e.Accion, &e.TablaAfectada,
+			&e.RegistroID, &e.RequestID, &e.IP, &antes, &despues, &e.Fecha, &hashAnterior, &hash, &e.APIKeyID); err != nil {
+			return cab, err
+		}
+		if antes != nil {
+			e.DatosAntes = []byte(*antes)
+		}
+		if despues != nil {
+			e.DatosDespues = []byte(*despues)
+		}
+		e.HashAnterior = hashAnterior
+
+		ruptura := func(motivo string) error {
+			return &Ruptura{ConsultorioID: consultorioID, Secuencia: e.Secuencia, AuditoriaID: id, Motivo: motivo}
+		}
+		if e.Secuencia != cab.Secuencia+1 {
+			return cab, ruptura(fmt.Sprintf("se esperaba la secuencia %d; faltan eslabones", cab.Secuencia+1))
+		}
+		if hashAnterior != cab.Hash {
+			return cab, ruptura("hash_anterior no coincide con el hash del eslabón previo")
+		}
+		calculado, err := e.Hash()
+		if err != nil {
+			return cab, ruptura("contenido ilegible: " + err.Error())
+		}
+		if calculado != hash {
+			return cab, ruptura("el contenido fue modificado (hash recalculado distinto)")
+		}
+		if ancla != nil && e.Secuencia == ancla.Secuencia && hash != ancla.Hash {
+			return cab, ruptura("el hash no coincide con el checkpoint firmado")
+		}
+		cab.Secuencia, cab.Hash = e.Secuencia, hash
+	}
+	if err := rows.Err(); err != nil {
+		return cab, err
+	}
+	if ancla != nil && cab.Secuencia < ancla.Secuencia {
+		return cab, &Ruptura{
+			ConsultorioID: consultorioID,
+			Secuencia:     cab.Secuencia + 1,
+			Motivo:        fmt.Sprintf("la cadena termina en %d pero el checkpoint firmado llega a %d", cab.Secuencia, ancla.Secuencia),
+		}
+	}
+	return cab, nil
+}
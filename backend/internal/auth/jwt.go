@@ -2,63 +2,115 @@ package auth
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
-// getJWTSecretKey obtiene la clave secreta de las variables de entorno
-func getJWTSecretKey(logger *zap.Logger) []byte {
-	secret := os.Getenv("JWT_SECRET_KEY")
-	if secret == "" {
-		logger.Warn("JWT_SECRET_KEY no encontrada, usando valor por defecto")
-		return []byte("clave_secreta_por_defecto_muy_segura_12345")
-	}
-	if len(secret) < 32 {
-		logger.Warn("JWT_SECRET_KEY debería tener al menos 32 caracteres para mayor seguridad")
+// Claves con las que se firman y verifican los tokens
+var keySet *KeySet
+
+// AccessTokenTTL es la vida de los access tokens
+const AccessTokenTTL = 24 * time.Hour
+
+// Emisor y tipo (encabezado typ) de los access tokens. Los ID tokens se firman con las
+// mismas claves pero llevan otro typ y el issuer público, así que ValidateToken no los
+// acepta como access tokens.
+const (
+	accessTokenIssuer = "mediapp-backend"
+	accessTokenTyp    = "JWT"
+	idTokenTyp        = "id_token+jwt"
+)
+
+// Init configura las claves de firma. Sin claves (keys nil) genera una clave efímera:
+// los tokens dejan de valer al reiniciar y ningún otro servicio puede verificarlos, así
+// que solo sirve para desarrollo y tests.
+func Init(logger *zap.Logger, keys *KeySet) error {
+	if keys == nil {
+		efimeras, err := GenerateEphemeralKeySet()
+		if err != nil {
+			return err
+		}
+		logger.Warn("Claves JWT no configuradas, usando una clave efímera (solo desarrollo)")
+		keys = efimeras
 	}
-	return []byte(secret)
+	keySet = keys
+	logger.Info("Claves JWT configuradas", zap.String("kid", keys.CurrentKID()), zap.Int("claves_verificacion", len(keys.keys)))
+	return nil
 }
 
-// Variable para la clave secreta
-var jwtSecretKey []byte
-
-// Inicializar el paquete auth
-func Init(logger *zap.Logger) {
-	jwtSecretKey = getJWTSecretKey(logger)
+// CurrentJWKS devuelve las claves públicas de verificación vigentes
+func CurrentJWKS() (JWKS, error) {
+	if keySet == nil {
+		return JWKS{}, fmt.Errorf("JWT no inicializado. Llama a auth.Init() primero")
+	}
+	return keySet.JWKS(), nil
 }
 
 // CustomClaims estructura que incluye claims personalizados y estándar
 type CustomClaims struct {
-	UserID string `json:"user_id"`
-	RolID  int    `json:"rol_id"`
+	UserID        string `json:"user_id"`
+	RolID         int    `json:"rol_id"`
+	ConsultorioID string `json:"consultorio_id,omitempty"`
+	// SesionID identifica el login del que sale el token (services.SessionService)
+	SesionID string `json:"sid,omitempty"`
+	// ClientID y Scope solo están en los tokens emitidos a una app por /oauth/token
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken crea y firma un nuevo token JWT
-func GenerateToken(userID string, rolID int) (string, error) {
-	if jwtSecretKey == nil {
+// GenerateToken crea y firma un nuevo token JWT. consultorioID puede ser vacío
+// para usuarios sin consultorio (solo operan con alcance de super-admin).
+func GenerateToken(userID string, rolID int, consultorioID string) (string, error) {
+	return GenerateSessionToken(userID, rolID, consultorioID, "")
+}
+
+// GenerateSessionToken crea un token de la sesión sesionID: cerrar la sesión invalida
+// todos los tokens que la nombran. sesionID vacío emite un token sin sesión.
+func GenerateSessionToken(userID string, rolID int, consultorioID, sesionID string) (string, error) {
+	return GenerateClientToken(userID, rolID, consultorioID, sesionID, "", "")
+}
+
+// GenerateClientToken crea un token de sesión emitido a la app clientID con los scopes
+// OAuth de scope (separados por espacios). Vacíos equivale a GenerateSessionToken.
+func GenerateClientToken(userID string, rolID int, consultorioID, sesionID, clientID, scope string) (string, error) {
+	if keySet == nil {
 		return "", fmt.Errorf("JWT no inicializado. Llama a auth.Init() primero")
 	}
 
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(AccessTokenTTL)
 
 	claims := &CustomClaims{
-		UserID: userID,
-		RolID:  rolID,
+		UserID:        userID,
+		RolID:         rolID,
+		ConsultorioID: consultorioID,
+		SesionID:      sesionID,
+		ClientID:      clientID,
+		Scope:         scope,
 		RegisteredClaims: jwt.RegisteredClaims{
+			// jti: identifica al token para poder revocarlo (logout)
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Subject:   fmt.Sprintf("user:%s", userID),
-			Issuer:    "mediapp-backend",
+			Issuer:    accessTokenIssuer,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString(jwtSecretKey)
+	return firmar(claims, accessTokenTyp)
+}
+
+// firmar firma claims con la clave actual e indica su kid y el tipo de token en el
+// encabezado
+func firmar(claims jwt.Claims, typ string) (string, error) {
+	token := jwt.NewWithClaims(keySet.current.Method, claims)
+	token.Header["kid"] = keySet.current.KID
+	token.Header["typ"] = typ
+	signedToken, err := token.SignedString(keySet.signer)
 	if err != nil {
 		return "", fmt.Errorf("error al firmar el token: %w", err)
 	}
@@ -66,26 +118,37 @@ func GenerateToken(userID string, rolID int) (string, error) {
 	return signedToken, nil
 }
 
-// ValidateToken valida un token JWT
+// ValidateToken valida un access token de MediApp: rechaza los ID tokens y cualquier token
+// de otro emisor o sin usuario
 func ValidateToken(tokenString string) (*CustomClaims, error) {
-	if jwtSecretKey == nil {
+	if keySet == nil {
 		return nil, fmt.Errorf("JWT no inicializado. Llama a auth.Init() primero")
 	}
 
 	claims := &CustomClaims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if typ, _ := token.Header["typ"].(string); typ != accessTokenTyp {
+			return nil, fmt.Errorf("tipo de token inesperado: %q", typ)
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keySet.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("clave de firma desconocida: %q", kid)
+		}
+		// El algoritmo lo fija la clave, no el encabezado del token
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("método de firma inesperado: %v", token.Header["alg"])
 		}
-		return jwtSecretKey, nil
-	})
+		return key.Public, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(accessTokenIssuer))
 
 	if err != nil {
 		return nil, fmt.Errorf("error al parsear el token: %w", err)
 	}
 
-	if !token.Valid {
+	if !token.Valid || claims.UserID == "" {
 		return nil, fmt.Errorf("token inválido")
 	}
 
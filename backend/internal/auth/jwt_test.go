@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// TestKeyRotation verifica que los tokens firmados con la clave anterior siguen valiendo
+// mientras su pública esté configurada, y que el kid y el algoritmo se respetan
+func TestKeyRotation(t *testing.T) {
+	_, vieja, _ := ed25519.GenerateKey(rand.Reader)
+	nueva, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	antes, _ := NewKeySet(vieja)
+	Init(zap.NewNop(), antes)
+	tokenViejo, err := GenerateToken("u1", 1, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotado, err := NewKeySet(nueva, vieja.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	Init(zap.NewNop(), rotado)
+	tokenNuevo, _ := GenerateToken("u2", 1, "c1")
+	parsed, _, _ := jwt.NewParser().ParseUnverified(tokenNuevo, &CustomClaims{})
+	if parsed.Method.Alg() != "RS256" || parsed.Header["kid"] != rotado.CurrentKID() {
+		t.Errorf("Se esperaba RS256 con el kid actual, obtuvo %v", parsed.Header)
+	}
+	for _, tok := range []string{tokenViejo, tokenNuevo} {
+		if _, err := ValidateToken(tok); err != nil {
+			t.Errorf("Se esperaba un token válido: %v", err)
+		}
+	}
+	if jwks, _ := CurrentJWKS(); len(jwks.Keys) != 2 || jwks.Keys[0].Kid != rotado.CurrentKID() || jwks.Keys[1].Kty != "OKP" {
+		t.Errorf("JWKS inesperado: %+v", jwks)
+	}
+
+	// Retirada la clave vieja, sus tokens dejan de valer
+	final, _ := NewKeySet(nueva)
+	Init(zap.NewNop(), final)
+	if _, err := ValidateToken(tokenViejo); err == nil {
+		t.Error("Se esperaba rechazar un token de una clave retirada")
+	}
+
+	// Un token HS256 firmado con la pública como secreto no debe aceptarse
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, &CustomClaims{UserID: "u3"})
+	hs.Header["kid"] = final.CurrentKID()
+	falso, _ := hs.SignedString([]byte(final.current.JWK().N))
+	if _, err := ValidateToken(falso); err == nil {
+		t.Error("Se esperaba rechazar un token HS256")
+	}
+}
+
+// TestRSAKeyTooSmall verifica que se rechazan claves RSA débiles
+func TestRSAKeyTooSmall(t *testing.T) {
+	chica, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKeySet(chica); err == nil {
+		t.Error("Se esperaba rechazar una clave RSA de 1024 bits")
+	}
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// rsaMinBits es el tamaño mínimo aceptado para claves RSA
+const rsaMinBits = 2048
+
+// VerificationKey es una clave pública con la que se verifican tokens. El kid es el
+// thumbprint RFC 7638 de la clave, así que no hace falta configurarlo.
+type VerificationKey struct {
+	KID    string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+}
+
+// KeySet es la clave de firma actual más las claves públicas que se siguen aceptando.
+// Para rotar se configura la clave nueva como clave de firma y la pública de la anterior
+// como clave de verificación, hasta que venzan los tokens firmados con ella.
+type KeySet struct {
+	signer  crypto.Signer
+	current VerificationKey
+	keys    map[string]VerificationKey
+}
+
+// NewKeySet arma el conjunto con la clave de firma signer (RSA o Ed25519) y las claves
+// públicas anteriores. La pública de signer se agrega sola.
+func NewKeySet(signer crypto.Signer, previous ...crypto.PublicKey) (*KeySet, error) {
+	current, err := newVerificationKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	ks := &KeySet{signer: signer, current: current, keys: map[string]VerificationKey{current.KID: current}}
+	for _, pub := range previous {
+		k, err := newVerificationKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys[k.KID] = k
+	}
+	return ks, nil
+}
+
+// GenerateEphemeralKeySet crea un conjunto con una clave Ed25519 nueva, que se pierde al
+// reiniciar. Solo para desarrollo y tests.
+func GenerateEphemeralKeySet() (*KeySet, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(priv)
+}
+
+// CurrentKID es el kid de la clave con la que se firman los tokens nuevos
+func (ks *KeySet) CurrentKID() string { return ks.current.KID }
+
+// Key busca una clave de verificación por kid
+func (ks *KeySet) Key(kid string) (VerificationKey, bool) {
+	k, ok := ks.keys[kid]
+	return k, ok
+}
+
+func newVerificationKey(pub crypto.PublicKey) (VerificationKey, error) {
+	var method jwt.SigningMethod
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	case *rsa.PublicKey:
+		if k.N.BitLen() < rsaMinBits {
+			return VerificationKey{}, fmt.Errorf("clave RSA de %d bits, el mínimo es %d", k.N.BitLen(), rsaMinBits)
+		}
+		method = jwt.SigningMethodRS256
+	default:
+		return VerificationKey{}, fmt.Errorf("tipo de clave no soportado: %T", pub)
+	}
+	k := VerificationKey{Method: method, Public: pub}
+	k.KID = thumbprint(k.jwk())
+	return k, nil
+}
+
+// JWK es una clave pública en formato JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP (Ed25519)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS es el documento de /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k VerificationKey) jwk() JWK {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.Public.(type) {
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: b64(pub)}
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}
+	}
+	return JWK{}
+}
+
+// JWK devuelve la clave en formato JWK con kid, uso y algoritmo
+func (k VerificationKey) JWK() JWK {
+	j := k.jwk()
+	j.Kid = k.KID
+	j.Use = "sig"
+	j.Alg = k.Method.Alg()
+	return j
+}
+
+// JWKS devuelve todas las claves de verificación, la actual primero
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{ks.current.JWK()}}
+	for kid, k := range ks.keys {
+		if kid != ks.current.KID {
+			set.Keys = append(set.Keys, k.JWK())
+		}
+	}
+	return set
+}
+
+// thumbprint calcula el thumbprint RFC 7638: SHA-256 de los miembros requeridos de la
+// clave, en orden lexicográfico y sin espacios
+func thumbprint(j JWK) string {
+	var miembros []byte
+	switch j.Kty {
+	case "OKP":
+		miembros, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X})
+	case "RSA":
+		miembros, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.Kty, j.N})
+	}
+	sum := sha256.Sum256(miembros)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ParsePrivateKeyPEM lee una clave privada RSA o Ed25519 en PEM (PKCS#8, o PKCS#1 para RSA)
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no se encontró un bloque PEM")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("bloque PEM no soportado: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, nil
+	case *rsa.PrivateKey:
+		return k, nil
+	}
+	return nil, fmt.Errorf("tipo de clave no soportado: %T", key)
+}
+
+// ParsePublicKeysPEM lee cero o más claves públicas RSA o Ed25519 en PEM concatenados
+func ParsePublicKeysPEM(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return keys, nil
+		}
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("bloque PEM no soportado: %s", block.Type)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pub)
+	}
+}
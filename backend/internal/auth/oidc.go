@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Proveedor OpenID Connect mínimo para las apps propias (portal de pacientes, app móvil):
+// Authorization Code con PKCE. Los ID tokens se firman con las mismas claves que los access
+// tokens, así que se verifican con /.well-known/jwks.json; el encabezado typ los distingue
+// para que no sirvan como access tokens.
+
+// IDTokenTTL es la vida de los ID tokens
+const IDTokenTTL = time.Hour
+
+// Scopes que entiende el proveedor. openid es obligatorio; profile y email agregan esos
+// claims al ID token y a /oauth/userinfo.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// ScopesSoportados son los scopes que se pueden pedir y registrar para un cliente
+var ScopesSoportados = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// IDTokenClaims son los claims de un ID token (OpenID Connect Core, sección 2). Los de
+// perfil y email solo se completan si el usuario los autorizó.
+type IDTokenClaims struct {
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateIDToken firma un ID token de issuer para la app clientID. claims trae el sub
+// (ID del usuario) y los claims del usuario; el resto lo completa esta función.
+func GenerateIDToken(issuer, clientID string, claims IDTokenClaims) (string, error) {
+	if keySet == nil {
+		return "", fmt.Errorf("JWT no inicializado. Llama a auth.Init() primero")
+	}
+	ahora := time.Now()
+	claims.ID = uuid.NewString()
+	claims.Issuer = issuer
+	claims.Audience = jwt.ClaimStrings{clientID}
+	claims.IssuedAt = jwt.NewNumericDate(ahora)
+	claims.ExpiresAt = jwt.NewNumericDate(ahora.Add(IDTokenTTL))
+	return firmar(&claims, idTokenTyp)
+}
+
+// VerifyPKCE comprueba el code_verifier contra un code_challenge S256 (RFC 7636): el
+// challenge es BASE64URL(SHA256(verifier)) sin relleno. No se acepta el método plain.
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, r := range verifier {
+		if !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-._~", r) {
+			return false
+		}
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	esperado := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(esperado), []byte(challenge)) == 1
+}
+
+// Discovery es el documento de /.well-known/openid-configuration (OpenID Connect
+// Discovery 1.0)
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	AuthorizationResponseIssParameter bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// CurrentDiscovery arma el documento de descubrimiento de issuer. La autorización la
+// atiende authorizeURL (la página del frontend donde el usuario inicia sesión y da su
+// consentimiento); el resto de los endpoints cuelgan de issuer.
+func CurrentDiscovery(issuer, authorizeURL string) (Discovery, error) {
+	if keySet == nil {
+		return Discovery{}, fmt.Errorf("JWT no inicializado. Llama a auth.Init() primero")
+	}
+	return Discovery{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             authorizeURL,
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ScopesSupported:                   ScopesSoportados,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{keySet.current.Method.Alg()},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+			"preferred_username", "email", "email_verified"},
+		AuthorizationResponseIssParameter: true,
+	}, nil
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := "dBjftJeZ4CVP-mJ92K9qV5p7R3BAWzBiEb4UuPVVAr0"
+	challenge := "lJf1Eh9TKpIAo6CP3Qheaa3ypVj5G9PqvAOmaBuQYew"
+	if !VerifyPKCE(verifier, challenge) {
+		t.Error("Se esperaba aceptar el verifier")
+	}
+	casos := map[string][2]string{
+		"otro verifier":   {verifier[:42] + "x", challenge},
+		"método plain":    {verifier, verifier},
+		"verifier corto":  {"abc", "ungVv5xOt2wN1P2D5c2Tmz_pSjT0dn94S1Y2JxOWlwU"},
+		"caracter ilegal": {strings.Repeat("a", 42) + "+", challenge},
+		"sin challenge":   {verifier, ""},
+	}
+	for nombre, caso := range casos {
+		if VerifyPKCE(caso[0], caso[1]) {
+			t.Errorf("%s: se esperaba rechazar", nombre)
+		}
+	}
+}
+
+// TestGenerateIDToken verifica que el ID token se firma con la clave actual, para el
+// cliente y el emisor indicados, y no sirve como access token
+func TestGenerateIDToken(t *testing.T) {
+	Init(zap.NewNop(), nil)
+	verificado := true
+	token, err := GenerateIDToken("https://api.mediapp.test", "portal", IDTokenClaims{
+		Nonce:            "n-0S6_WzA2Mj",
+		Email:            "ana@mediapp.test",
+		EmailVerified:    &verificado,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "u1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := &IDTokenClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(tok *jwt.Token) (interface{}, error) {
+		key, _ := keySet.Key(tok.Header["kid"].(string))
+		return key.Public, nil
+	}, jwt.WithIssuer("https://api.mediapp.test"), jwt.WithAudience("portal"))
+	if err != nil || !parsed.Valid {
+		t.Fatalf("ID token inválido: %v", err)
+	}
+	if claims.Subject != "u1" || claims.Nonce != "n-0S6_WzA2Mj" || claims.ExpiresAt == nil || claims.ID == "" {
+		t.Errorf("Claims inesperados: %+v", claims)
+	}
+
+	if parsed.Header["typ"] != idTokenTyp {
+		t.Errorf("Se esperaba typ %q, obtuvo %v", idTokenTyp, parsed.Header["typ"])
+	}
+
+	// No sirve como access token, ni siquiera con el issuer de los access tokens
+	if c, err := ValidateToken(token); err == nil {
+		t.Errorf("El ID token no debe servir como access token: %+v", c)
+	}
+	conUsuario, _ := GenerateIDToken("mediapp-backend", "portal", IDTokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "u1"}})
+	if c, err := ValidateToken(conUsuario); err == nil {
+		t.Errorf("Un ID token con el issuer de los access tokens no debe validarse: %+v", c)
+	}
+
+	doc, err := CurrentDiscovery("https://api.mediapp.test", "https://app.mediapp.test/oauth/authorize")
+	if err != nil || doc.TokenEndpoint != "https://api.mediapp.test/oauth/token" ||
+		doc.IDTokenSigningAlgValuesSupported[0] != "EdDSA" {
+		t.Errorf("Documento de descubrimiento inesperado: %+v %v", doc, err)
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/auth"
+)
+
+// ErrJWTKeysNoConfiguradas indica que no se configuró la clave de firma de los JWT
+var ErrJWTKeysNoConfiguradas = errors.New("JWT_SIGNING_KEY no configurada")
+
+// JWTKeys carga la clave privada de firma de los JWT (JWT_SIGNING_KEY en PEM, o
+// JWT_SIGNING_KEY_FILE con la ruta) y las claves públicas anteriores que se siguen
+// aceptando durante una rotación (JWT_VERIFICATION_KEYS o JWT_VERIFICATION_KEYS_FILE,
+// bloques PEM concatenados). Las claves pueden ser Ed25519 (EdDSA) o RSA (RS256).
+func JWTKeys() (*auth.KeySet, error) {
+	privada, err := pemEnv("JWT_SIGNING_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if privada == nil {
+		return nil, ErrJWTKeysNoConfiguradas
+	}
+	signer, err := auth.ParsePrivateKeyPEM(privada)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY inválida: %w", err)
+	}
+	publicas, err := pemEnv("JWT_VERIFICATION_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	anteriores, err := auth.ParsePublicKeysPEM(publicas)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_VERIFICATION_KEYS inválida: %w", err)
+	}
+	return auth.NewKeySet(signer, anteriores...)
+}
+
+// pemEnv lee un PEM de la variable nombre o del archivo indicado en nombre_FILE. En la
+// variable se aceptan saltos de línea escapados como \n, habituales en archivos .env.
+func pemEnv(nombre string) ([]byte, error) {
+	if raw := os.Getenv(nombre); raw != "" {
+		return []byte(strings.ReplaceAll(raw, `\n`, "\n")), nil
+	}
+	ruta := os.Getenv(nombre + "_FILE")
+	if ruta == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(ruta)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer %s_FILE: %w", nombre, err)
+	}
+	return data, nil
+}
+
+// RefreshTokenTTL es la vida de los refresh tokens (REFRESH_TOKEN_TTL, duración de Go como
+// "720h"). Devuelve 0 si no está configurada, y el servicio usa su valor por defecto.
+func RefreshTokenTTL() (time.Duration, error) {
+	raw := os.Getenv("REFRESH_TOKEN_TTL")
+	if raw == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("REFRESH_TOKEN_TTL inválida: %q", raw)
+	}
+	return ttl, nil
+}
+
+// OIDCIssuer es la URL pública del backend que identifica al proveedor OpenID Connect
+// (OIDC_ISSUER, sin barra final). Va en el claim iss de los ID tokens y de ella cuelgan los
+// endpoints del documento de descubrimiento; por defecto es la del backend local.
+func OIDCIssuer() string {
+	if u := os.Getenv("OIDC_ISSUER"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// OIDCAuthorizeURL es la página del frontend donde el usuario inicia sesión y autoriza a
+// la app (OIDC_AUTHORIZE_URL); por defecto FRONTEND_URL/oauth/authorize
+func OIDCAuthorizeURL() string {
+	if u := os.Getenv("OIDC_AUTHORIZE_URL"); u != "" {
+		return u
+	}
+	return FrontendURL() + "/oauth/authorize"
+}
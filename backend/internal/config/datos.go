@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/hashicorp/vault/api"
+)
+
+// DatosPersonalesCipher arma el cifrador de datos_personales. La clave maestra sale de:
+//   - DATOS_VAULT_TRANSIT_KEY: clave del motor transit de Vault (con VAULT_ADDR, VAULT_TOKEN
+//     y opcionalmente DATOS_VAULT_TRANSIT_MOUNT), o si no está definida
+//   - DATOS_MASTER_KEY: 32 bytes en base64, con DATOS_MASTER_KEY_VERSION (por defecto 1).
+//     Después de rotarla, las claves anteriores se listan en DATOS_MASTER_KEYS_ANTERIORES
+//     como "version:base64" separadas por comas, para poder descifrar lo que no se recifró.
+//
+// DATOS_BLIND_INDEX_KEY (al menos 32 bytes en base64) es la clave de los índices ciegos.
+func DatosPersonalesCipher() (*security.FieldCipher, error) {
+	kek, err := datosPersonalesKEK()
+	if err != nil {
+		return nil, err
+	}
+	indexKey, err := base64Env("DATOS_BLIND_INDEX_KEY")
+	if err != nil {
+		return nil, err
+	}
+	return security.NewFieldCipher(kek, indexKey)
+}
+
+func datosPersonalesKEK() (security.KeyWrapper, error) {
+	if transitKey := os.Getenv("DATOS_VAULT_TRANSIT_KEY"); transitKey != "" {
+		client, err := api.NewClient(api.DefaultConfig())
+		if err != nil {
+			return nil, fmt.Errorf("error creando cliente de Vault: %w", err)
+		}
+		return security.NewVaultTransit(client.Logical(), os.Getenv("DATOS_VAULT_TRANSIT_MOUNT"), transitKey), nil
+	}
+
+	key, err := base64Env("DATOS_MASTER_KEY")
+	if err != nil {
+		return nil, err
+	}
+	version := uint64(1)
+	if raw := os.Getenv("DATOS_MASTER_KEY_VERSION"); raw != "" {
+		version, err = strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("DATOS_MASTER_KEY_VERSION inválida: %w", err)
+		}
+	}
+	actual, err := security.NewLocalKEK(uint32(version), key)
+	if err != nil {
+		return nil, err
+	}
+	anteriores, err := clavesAnteriores(os.Getenv("DATOS_MASTER_KEYS_ANTERIORES"))
+	if err != nil {
+		return nil, err
+	}
+	return security.NewKeyRing(actual, anteriores...)
+}
+
+// clavesAnteriores interpreta "1:base64,2:base64"
+func clavesAnteriores(raw string) ([]*security.LocalKEK, error) {
+	var claves []*security.LocalKEK
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		versionRaw, keyRaw, ok := strings.Cut(item, ":")
+		version, err := strconv.ParseUint(versionRaw, 10, 32)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("DATOS_MASTER_KEYS_ANTERIORES inválida: se espera version:base64")
+		}
+		key, err := base64.StdEncoding.DecodeString(keyRaw)
+		if err != nil {
+			return nil, fmt.Errorf("DATOS_MASTER_KEYS_ANTERIORES: la versión %d no es base64 válido: %w", version, err)
+		}
+		k, err := security.NewLocalKEK(uint32(version), key)
+		if err != nil {
+			return nil, fmt.Errorf("DATOS_MASTER_KEYS_ANTERIORES: %w", err)
+		}
+		claves = append(claves, k)
+	}
+	return claves, nil
+}
+
+func base64Env(nombre string) ([]byte, error) {
+	raw := os.Getenv(nombre)
+	if raw == "" {
+		return nil, fmt.Errorf("%s no configurada", nombre)
+	}
+	valor, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s no es base64 válido: %w", nombre, err)
+	}
+	return valor, nil
+}
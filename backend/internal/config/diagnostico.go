@@ -0,0 +1,22 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DiagnosticoHabilitado indica si se exponen los endpoints de diagnóstico. Con
+// DIAGNOSTICO_HABILITADO (true o false) se decide explícitamente; sin ella están
+// habilitados salvo con ENV=production.
+func DiagnosticoHabilitado() (bool, error) {
+	raw := os.Getenv("DIAGNOSTICO_HABILITADO")
+	if raw == "" {
+		return os.Getenv("ENV") != "production", nil
+	}
+	habilitado, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("DIAGNOSTICO_HABILITADO inválida: %q", raw)
+	}
+	return habilitado, nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/FolkodeGroup/mediapp/internal/mailer"
+	"go.uber.org/zap"
+)
+
+// ErrMailerNoConfigurado indica que no hay servidor SMTP en producción
+var ErrMailerNoConfigurado = errors.New("SMTP_HOST no configurado")
+
+// remitentePorDefecto se usa con el mailer de desarrollo si SMTP_FROM no está definido
+const remitentePorDefecto = "MediApp <noreply@mediapp.local>"
+
+// Mailer arma el mailer con SMTP_HOST, SMTP_PORT (587 por defecto), SMTP_USERNAME,
+// SMTP_PASSWORD y SMTP_FROM. Sin SMTP_HOST, fuera de producción los correos se guardan en
+// MAIL_DIR como .eml o, si tampoco está definido, se escriben en el log; en producción
+// devuelve ErrMailerNoConfigurado.
+func Mailer(logger *zap.Logger) (mailer.Mailer, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		if os.Getenv("ENV") == "production" {
+			return nil, ErrMailerNoConfigurado
+		}
+		de := os.Getenv("SMTP_FROM")
+		if de == "" {
+			de = remitentePorDefecto
+		}
+		return mailer.NewFileMailer(os.Getenv("MAIL_DIR"), de, logger), nil
+	}
+
+	port := 587
+	if raw := os.Getenv("SMTP_PORT"); raw != "" {
+		p, err := strconv.Atoi(raw)
+		if err != nil || p <= 0 {
+			return nil, fmt.Errorf("SMTP_PORT inválido: %q", raw)
+		}
+		port = p
+	}
+	de := os.Getenv("SMTP_FROM")
+	if de == "" {
+		return nil, fmt.Errorf("SMTP_FROM no configurado")
+	}
+	return mailer.NewSMTPMailer(mailer.SMTPConfig{
+		Host:     host,
+		Port:     port,
+		Usuario:  os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		De:       de,
+	}), nil
+}
+
+// FrontendURL es la URL pública del frontend, con la que se arman los enlaces de los
+// correos (FRONTEND_URL, por defecto http://localhost:3000)
+func FrontendURL() string {
+	if u := os.Getenv("FRONTEND_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:3000"
+}
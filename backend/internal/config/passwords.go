@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/FolkodeGroup/mediapp/internal/security"
+)
+
+// PoliticaContrasena arma la política de contraseñas a partir de la por defecto:
+// PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH, PASSWORD_MIN_CLASSES (1 a 4),
+// PASSWORD_HISTORY (0 permite repetir) y PASSWORD_BREACHED_PATH, un archivo o directorio
+// de hashes filtrados que se suma a la lista incluida (ver security.ListaFiltradas).
+func PoliticaContrasena() (security.PoliticaContrasena, error) {
+	p := security.PoliticaPorDefecto()
+	enteros := []struct {
+		nombre   string
+		destino  *int
+		min, max int
+	}{
+		{"PASSWORD_MIN_LENGTH", &p.MinLongitud, 1, 1024},
+		{"PASSWORD_MAX_LENGTH", &p.MaxLongitud, 1, 1024},
+		{"PASSWORD_MIN_CLASSES", &p.MinClases, 1, 4},
+		{"PASSWORD_HISTORY", &p.Historial, 0, 100},
+	}
+	for _, e := range enteros {
+		if err := enteroEnv(e.nombre, e.destino, e.min, e.max); err != nil {
+			return p, err
+		}
+	}
+	if p.MaxLongitud < p.MinLongitud {
+		return p, fmt.Errorf("PASSWORD_MAX_LENGTH (%d) menor que PASSWORD_MIN_LENGTH (%d)", p.MaxLongitud, p.MinLongitud)
+	}
+	if ruta := os.Getenv("PASSWORD_BREACHED_PATH"); ruta != "" {
+		lista, err := security.AbrirListaFiltradas(ruta)
+		if err != nil {
+			return p, fmt.Errorf("PASSWORD_BREACHED_PATH inválida: %w", err)
+		}
+		p.Filtradas = append(p.Filtradas, lista)
+	}
+	return p, nil
+}
+
+// HashContrasenas es el algoritmo de las contraseñas nuevas: PASSWORD_HASH (argon2id por
+// defecto, o bcrypt), PASSWORD_BCRYPT_COST y PASSWORD_ARGON2_MEMORY (KiB),
+// PASSWORD_ARGON2_ITERATIONS y PASSWORD_ARGON2_PARALLELISM. Al cambiarlos, cada hash se
+// recalcula la próxima vez que su usuario inicia sesión.
+func HashContrasenas() (security.ConfigHash, error) {
+	c := security.ConfigHashPorDefecto()
+	if alg := os.Getenv("PASSWORD_HASH"); alg != "" {
+		c.Algoritmo = alg
+	}
+	memoria, iteraciones, paralelismo := int(c.Argon2Memoria), int(c.Argon2Iteraciones), int(c.Argon2Paralelismo)
+	enteros := []struct {
+		nombre   string
+		destino  *int
+		min, max int
+	}{
+		{"PASSWORD_BCRYPT_COST", &c.BcryptCost, 10, 31},
+		{"PASSWORD_ARGON2_MEMORY", &memoria, 8 * 1024, 4 * 1024 * 1024},
+		{"PASSWORD_ARGON2_ITERATIONS", &iteraciones, 1, 100},
+		{"PASSWORD_ARGON2_PARALLELISM", &paralelismo, 1, 255},
+	}
+	for _, e := range enteros {
+		if err := enteroEnv(e.nombre, e.destino, e.min, e.max); err != nil {
+			return c, err
+		}
+	}
+	c.Argon2Memoria, c.Argon2Iteraciones, c.Argon2Paralelismo = uint32(memoria), uint32(iteraciones), uint8(paralelismo)
+	return c, c.Validar()
+}
+
+// enteroEnv lee la variable nombre en destino si está definida, dentro de [min, max]
+func enteroEnv(nombre string, destino *int, min, max int) error {
+	raw := os.Getenv(nombre)
+	if raw == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < min || n > max {
+		return fmt.Errorf("%s inválida: %q (entre %d y %d)", nombre, raw, min, max)
+	}
+	*destino = n
+	return nil
+}
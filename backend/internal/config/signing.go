@@ -0,0 +1,56 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// RecetasSigningKey obtiene la clave maestra (32 bytes en base64) que cifra las
+// claves privadas de firma de recetas guardadas en la base de datos
+func RecetasSigningKey() ([]byte, error) {
+	raw := os.Getenv("RECETAS_SIGNING_MASTER_KEY")
+	if raw == "" {
+		return nil, fmt.Errorf("RECETAS_SIGNING_MASTER_KEY no configurada")
+	}
+	key, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("RECETAS_SIGNING_MASTER_KEY no es base64 válido: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("RECETAS_SIGNING_MASTER_KEY debe tener 32 bytes, tiene %d", len(key))
+	}
+	return key, nil
+}
+
+// AuditCheckpointKey obtiene la clave Ed25519 (semilla de 32 bytes en base64) con la que
+// se firman los checkpoints de la cadena de auditoría
+func AuditCheckpointKey() (ed25519.PrivateKey, error) {
+	raw := os.Getenv("AUDIT_CHECKPOINT_KEY")
+	if raw == "" {
+		return nil, fmt.Errorf("AUDIT_CHECKPOINT_KEY no configurada")
+	}
+	seed, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("AUDIT_CHECKPOINT_KEY no es base64 válido: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("AUDIT_CHECKPOINT_KEY debe tener %d bytes, tiene %d", ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// AuditCheckpointPublicKey obtiene la clave pública esperada en los checkpoints
+// (AUDIT_CHECKPOINT_PUBLIC_KEY, base64). Devuelve nil si no está configurada.
+func AuditCheckpointPublicKey() (ed25519.PublicKey, error) {
+	raw := os.Getenv("AUDIT_CHECKPOINT_PUBLIC_KEY")
+	if raw == "" {
+		return nil, nil
+	}
+	pub, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("AUDIT_CHECKPOINT_PUBLIC_KEY inválida")
+	}
+	return ed25519.PublicKey(pub), nil
+}
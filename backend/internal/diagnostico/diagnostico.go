@@ -0,0 +1,334 @@
+// Package diagnostico reporta el estado del backend para los administradores: si el
+// esquema de la base coincide con el que esperan las migraciones, cuántas filas tiene cada
+// tabla, el uso del pool de conexiones y si Redis responde.
+//
+// El reporte no incluye filas ni valores de la base, y los errores se informan solo por su
+// código SQLSTATE o su tipo: los mensajes de Postgres y de Redis pueden contener datos de
+// pacientes, hashes o direcciones internas.
+package diagnostico
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Estados del reporte
+const (
+	EstadoOK        = "ok"
+	EstadoDegradado = "degradado"
+)
+
+// tablaGoose es la tabla de versiones de goose; no es parte del esquema esperado
+const tablaGoose = "goose_db_version"
+
+// Pool es la parte del pool de conexiones que usa el diagnóstico
+type Pool interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Stat() *pgxpool.Stat
+}
+
+// Redis es la parte del cliente de Redis que usa el diagnóstico
+type Redis interface {
+	Ping(ctx context.Context) *redis.StatusCmd
+}
+
+// Esquema es lo que dejan las migraciones al aplicarse todas: sus versiones y las tablas
+// que existen al final
+type Esquema struct {
+	Versiones []int64
+	Tablas    []string
+}
+
+var (
+	versionMigracion = regexp.MustCompile(`^(\d+)_.*\.sql$`)
+	sentenciaTabla   = regexp.MustCompile(`(?i)\b(CREATE|DROP)\s+TABLE\s+(?:IF\s+(?:NOT\s+)?EXISTS\s+)?(?:public\.)?"?([a-z_][a-z0-9_]*)"?`)
+)
+
+// EsquemaEsperado lee las migraciones de goose de la raíz de fsys y reproduce en orden de
+// versión los CREATE TABLE y DROP TABLE de sus secciones Up
+func EsquemaEsperado(fsys fs.FS) (Esquema, error) {
+	nombres, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return Esquema{}, err
+	}
+	type migracion struct {
+		version int64
+		nombre  string
+	}
+	var migraciones []migracion
+	for _, nombre := range nombres {
+		m := versionMigracion.FindStringSubmatch(path.Base(nombre))
+		if m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return Esquema{}, fmt.Errorf("versión inválida en %s: %w", nombre, err)
+		}
+		migraciones = append(migraciones, migracion{version, nombre})
+	}
+	sort.Slice(migraciones, func(i, j int) bool {
+		if migraciones[i].version != migraciones[j].version {
+			return migraciones[i].version < migraciones[j].version
+		}
+		return migraciones[i].nombre < migraciones[j].nombre
+	})
+
+	var esquema Esquema
+	tablas := map[string]bool{}
+	for _, m := range migraciones {
+		if n := len(esquema.Versiones); n == 0 || esquema.Versiones[n-1] != m.version {
+			esquema.Versiones = append(esquema.Versiones, m.version)
+		}
+		raw, err := fs.ReadFile(fsys, m.nombre)
+		if err != nil {
+			return Esquema{}, err
+		}
+		up := string(raw)
+		if i := strings.Index(up, "-- +goose Down"); i >= 0 {
+			up = up[:i]
+		}
+		for _, s := range sentenciaTabla.FindAllStringSubmatch(up, -1) {
+			tablas[strings.ToLower(s[2])] = strings.EqualFold(s[1], "CREATE")
+		}
+	}
+	for tabla, existe := range tablas {
+		if existe {
+			esquema.Tablas = append(esquema.Tablas, tabla)
+		}
+	}
+	sort.Strings(esquema.Tablas)
+	return esquema, nil
+}
+
+// Migraciones compara las versiones aplicadas en goose_db_version y las tablas de la base
+// con el esquema esperado
+type Migraciones struct {
+	Esperadas         int      `json:"esperadas"`
+	Aplicadas         int      `json:"aplicadas"`
+	UltimaAplicada    int64    `json:"ultima_aplicada,omitempty"`
+	Pendientes        []int64  `json:"pendientes"`
+	Desconocidas      []int64  `json:"desconocidas"`
+	TablasFaltantes   []string `json:"tablas_faltantes"`
+	TablasNoEsperadas []string `json:"tablas_no_esperadas"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// Tabla es la cantidad de filas de una tabla esperada que existe en la base
+type Tabla struct {
+	Nombre string `json:"nombre"`
+	Filas  *int64 `json:"filas,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PoolStats son los contadores del pool de conexiones a la base
+type PoolStats struct {
+	Maximo              int32 `json:"maximo"`
+	Total               int32 `json:"total"`
+	EnUso               int32 `json:"en_uso"`
+	Libres              int32 `json:"libres"`
+	Adquisiciones       int64 `json:"adquisiciones"`
+	AdquisicionesEspera int64 `json:"adquisiciones_con_espera"`
+	EsperaTotalMs       int64 `json:"espera_total_ms"`
+}
+
+// EstadoRedis indica si Redis responde y cuánto tarda
+type EstadoRedis struct {
+	Disponible bool   `json:"disponible"`
+	LatenciaMs int64  `json:"latencia_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Reporte es el resultado de un diagnóstico. Estado es EstadoDegradado si hay migraciones
+// pendientes o desconocidas, tablas faltantes, tablas que no se pudieron contar o Redis no
+// responde.
+type Reporte struct {
+	Estado      string      `json:"estado"`
+	Migraciones Migraciones `json:"migraciones"`
+	Tablas      []Tabla     `json:"tablas"`
+	Pool        *PoolStats  `json:"pool,omitempty"`
+	Redis       EstadoRedis `json:"redis"`
+	Generado    time.Time   `json:"generado"`
+}
+
+// Diagnostico arma reportes contra la base y Redis
+type Diagnostico struct {
+	pool     Pool
+	redis    Redis
+	esperado Esquema
+	logger   *zap.Logger
+}
+
+// New crea el diagnóstico. esperado suele salir de EsquemaEsperado sobre las migraciones
+// incluidas en el binario.
+func New(pool Pool, redisClient Redis, esperado Esquema, logger *zap.Logger) *Diagnostico {
+	return &Diagnostico{pool: pool, redis: redisClient, esperado: esperado, logger: logger}
+}
+
+// Reporte consulta la base y Redis. Un componente que falla se informa en el reporte y no
+// impide revisar los demás.
+func (d *Diagnostico) Reporte(ctx context.Context) Reporte {
+	r := Reporte{Estado: EstadoOK, Generado: time.Now().UTC()}
+
+	existentes, err := d.migraciones(ctx, &r.Migraciones)
+	if err != nil {
+		d.logger.Error("Error al comparar el esquema con las migraciones", zap.Error(err))
+		r.Migraciones.Error = redactar(err)
+	}
+	if err != nil || len(r.Migraciones.Pendientes) > 0 || len(r.Migraciones.Desconocidas) > 0 || len(r.Migraciones.TablasFaltantes) > 0 {
+		r.Estado = EstadoDegradado
+	}
+
+	r.Tablas = []Tabla{}
+	for _, tabla := range d.esperado.Tablas {
+		if existentes != nil && !existentes[tabla] {
+			continue
+		}
+		t := Tabla{Nombre: tabla}
+		var filas int64
+		if err := d.pool.QueryRow(ctx, "SELECT COUNT(*) FROM "+pgx.Identifier{tabla}.Sanitize()).Scan(&filas); err != nil {
+			d.logger.Warn("Error al contar filas", zap.String("table", tabla), zap.Error(err))
+			t.Error = redactar(err)
+			r.Estado = EstadoDegradado
+		} else {
+			t.Filas = &filas
+		}
+		r.Tablas = append(r.Tablas, t)
+	}
+
+	if s := d.pool.Stat(); s != nil {
+		r.Pool = &PoolStats{
+			Maximo:              s.MaxConns(),
+			Total:               s.TotalConns(),
+			EnUso:               s.AcquiredConns(),
+			Libres:              s.IdleConns(),
+			Adquisiciones:       s.AcquireCount(),
+			AdquisicionesEspera: s.EmptyAcquireCount(),
+			EsperaTotalMs:       s.AcquireDuration().Milliseconds(),
+		}
+	}
+
+	inicio := time.Now()
+	if err := d.redis.Ping(ctx).Err(); err != nil {
+		d.logger.Warn("Redis no responde", zap.Error(err))
+		r.Redis.Error = redactar(err)
+		r.Estado = EstadoDegradado
+	} else {
+		r.Redis.Disponible = true
+	}
+	r.Redis.LatenciaMs = time.Since(inicio).Milliseconds()
+	return r
+}
+
+// migraciones completa m y devuelve las tablas que existen en la base
+func (d *Diagnostico) migraciones(ctx context.Context, m *Migraciones) (map[string]bool, error) {
+	m.Esperadas = len(d.esperado.Versiones)
+	m.Pendientes, m.Desconocidas = []int64{}, []int64{}
+	m.TablasFaltantes, m.TablasNoEsperadas = []string{}, []string{}
+
+	aplicadas := map[int64]bool{}
+	rows, err := d.pool.Query(ctx, `
+		SELECT DISTINCT version_id FROM `+tablaGoose+`
+		WHERE is_applied AND version_id > 0
+		ORDER BY version_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var v int64
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		aplicadas[v] = true
+		m.UltimaAplicada = v
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	m.Aplicadas = len(aplicadas)
+
+	esperadas := map[int64]bool{}
+	for _, v := range d.esperado.Versiones {
+		esperadas[v] = true
+		if !aplicadas[v] {
+			m.Pendientes = append(m.Pendientes, v)
+		}
+	}
+	for v := range aplicadas {
+		if !esperadas[v] {
+			m.Desconocidas = append(m.Desconocidas, v)
+		}
+	}
+	sort.Slice(m.Desconocidas, func(i, j int) bool { return m.Desconocidas[i] < m.Desconocidas[j] })
+
+	existentes := map[string]bool{}
+	rows, err = d.pool.Query(ctx, `
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+		ORDER BY table_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var tabla string
+		if err := rows.Scan(&tabla); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		existentes[tabla] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	esperada := map[string]bool{tablaGoose: true}
+	for _, tabla := range d.esperado.Tablas {
+		esperada[tabla] = true
+		if !existentes[tabla] {
+			m.TablasFaltantes = append(m.TablasFaltantes, tabla)
+		}
+	}
+	for tabla := range existentes {
+		if !esperada[tabla] {
+			m.TablasNoEsperadas = append(m.TablasNoEsperadas, tabla)
+		}
+	}
+	sort.Strings(m.TablasNoEsperadas)
+	return existentes, nil
+}
+
+// redactar describe err sin su mensaje: el código SQLSTATE para los errores de Postgres
+// y una descripción genérica para el resto
+func redactar(err error) string {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr):
+		return "SQLSTATE " + pgErr.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return "tiempo de espera agotado"
+	case errors.Is(err, context.Canceled):
+		return "consulta cancelada"
+	default:
+		return "error de conexión"
+	}
+}
@@ -0,0 +1,180 @@
+package diagnostico
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/FolkodeGroup/mediapp/migrations"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+func TestEsquemaEsperado(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_inicial.sql":   {Data: []byte("-- +goose Up\nCREATE TABLE users (id INT);\nCREATE TABLE IF NOT EXISTS turnos (id INT);\n-- +goose Down\nDROP TABLE turnos;\nDROP TABLE users;\n")},
+		"002_rehacer.sql":   {Data: []byte("-- +goose Up\nDROP TABLE IF EXISTS users CASCADE;\ncreate table if not exists usuarios (id INT);\n")},
+		"README.md":         {Data: []byte("CREATE TABLE documentada (id INT);")},
+		"old/000_vieja.sql": {Data: []byte("-- +goose Up\nCREATE TABLE vieja (id INT);\n")},
+	}
+	esquema, err := EsquemaEsperado(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(esquema.Versiones) != 2 || esquema.Versiones[0] != 1 || esquema.Versiones[1] != 2 {
+		t.Errorf("Versiones inesperadas: %v", esquema.Versiones)
+	}
+	if strings.Join(esquema.Tablas, ",") != "turnos,usuarios" {
+		t.Errorf("Tablas inesperadas: %v", esquema.Tablas)
+	}
+
+	// Las migraciones del repo se leen del binario
+	esquema, err = EsquemaEsperado(migrations.Archivos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tablas := strings.Join(esquema.Tablas, ",")
+	for _, tabla := range []string{"usuarios", "pacientes", "auditorias", "oauth_clientes"} {
+		if !strings.Contains(","+tablas+",", ","+tabla+",") {
+			t.Errorf("Se esperaba la tabla %s en %v", tabla, esquema.Tablas)
+		}
+	}
+	if strings.Contains(","+tablas+",", ",users,") {
+		t.Errorf("users se elimina en las migraciones: %v", esquema.Tablas)
+	}
+}
+
+// fakePool responde goose_db_version, information_schema.tables y los COUNT(*)
+type fakePool struct {
+	versiones []interface{}
+	tablas    []interface{}
+	filas     map[string]int64
+	fallan    map[string]error
+}
+
+func (p *fakePool) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if strings.Contains(sql, "goose_db_version") {
+		return &fakeRows{valores: p.versiones}, nil
+	}
+	return &fakeRows{valores: p.tablas}, nil
+}
+
+func (p *fakePool) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return fakeRow(func(dest ...interface{}) error {
+		for tabla, err := range p.fallan {
+			if strings.HasSuffix(sql, `"`+tabla+`"`) {
+				return err
+			}
+		}
+		for tabla, n := range p.filas {
+			if strings.HasSuffix(sql, `"`+tabla+`"`) {
+				*dest[0].(*int64) = n
+				return nil
+			}
+		}
+		return errors.New("tabla no esperada en el test")
+	})
+}
+
+func (p *fakePool) Stat() *pgxpool.Stat { return nil }
+
+type fakeRow func(dest ...interface{}) error
+
+func (f fakeRow) Scan(dest ...interface{}) error { return f(dest...) }
+
+// fakeRows devuelve una fila por valor, de una columna
+type fakeRows struct {
+	valores []interface{}
+	i       int
+}
+
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) Values() ([]interface{}, error)               { return nil, nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+func (r *fakeRows) Next() bool {
+	r.i++
+	return r.i <= len(r.valores)
+}
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	switch d := dest[0].(type) {
+	case *int64:
+		*d = r.valores[r.i-1].(int64)
+	case *string:
+		*d = r.valores[r.i-1].(string)
+	}
+	return nil
+}
+
+type fakeRedis struct{ err error }
+
+func (f fakeRedis) Ping(ctx context.Context) *redis.StatusCmd {
+	return redis.NewStatusResult("PONG", f.err)
+}
+
+// TestReporte verifica que el reporte detecta el drift del esquema y no expone los mensajes
+// de error de la base ni de Redis
+func TestReporte(t *testing.T) {
+	esperado := Esquema{Versiones: []int64{1, 2, 3}, Tablas: []string{"pacientes", "turnos", "usuarios"}}
+	pool := &fakePool{
+		versiones: []interface{}{int64(1), int64(2), int64(9)},
+		tablas:    []interface{}{"goose_db_version", "pacientes", "usuarios", "tmp_import"},
+		filas:     map[string]int64{"usuarios": 4},
+		fallan: map[string]error{
+			"pacientes": &pgconn.PgError{Code: "42501", Message: "permiso denegado", Detail: "Key (dni)=(20123456) is duplicated"},
+		},
+	}
+	d := New(pool, fakeRedis{err: errors.New("dial tcp 10.0.0.7:6379: connection refused")}, esperado, zap.NewNop())
+
+	r := d.Reporte(context.Background())
+	if r.Estado != EstadoDegradado {
+		t.Errorf("Se esperaba estado degradado, obtuvo %s", r.Estado)
+	}
+	m := r.Migraciones
+	if m.Esperadas != 3 || m.Aplicadas != 3 || m.UltimaAplicada != 9 {
+		t.Errorf("Conteo de migraciones inesperado: %+v", m)
+	}
+	if len(m.Pendientes) != 1 || m.Pendientes[0] != 3 || len(m.Desconocidas) != 1 || m.Desconocidas[0] != 9 {
+		t.Errorf("Versiones pendientes o desconocidas inesperadas: %+v", m)
+	}
+	if strings.Join(m.TablasFaltantes, ",") != "turnos" || strings.Join(m.TablasNoEsperadas, ",") != "tmp_import" {
+		t.Errorf("Drift de tablas inesperado: %+v", m)
+	}
+
+	// Solo se cuentan las tablas esperadas que existen
+	if len(r.Tablas) != 2 || r.Tablas[0].Nombre != "pacientes" || r.Tablas[1].Filas == nil || *r.Tablas[1].Filas != 4 {
+		t.Errorf("Conteos inesperados: %+v", r.Tablas)
+	}
+	if r.Tablas[0].Error != "SQLSTATE 42501" {
+		t.Errorf("Se esperaba informar solo el SQLSTATE, obtuvo %q", r.Tablas[0].Error)
+	}
+	if r.Redis.Disponible || r.Redis.Error != "error de conexión" {
+		t.Errorf("Estado de Redis inesperado: %+v", r.Redis)
+	}
+
+	raw, _ := json.Marshal(r)
+	for _, filtrado := range []string{"20123456", "permiso denegado", "10.0.0.7"} {
+		if strings.Contains(string(raw), filtrado) {
+			t.Errorf("El reporte expone %q: %s", filtrado, raw)
+		}
+	}
+
+	// Con el esquema al día y Redis respondiendo el estado es ok
+	pool = &fakePool{
+		versiones: []interface{}{int64(1), int64(2), int64(3)},
+		tablas:    []interface{}{"goose_db_version", "pacientes", "turnos", "usuarios"},
+		filas:     map[string]int64{"pacientes": 0, "turnos": 0, "usuarios": 1},
+	}
+	if r := New(pool, fakeRedis{}, esperado, zap.NewNop()).Reporte(context.Background()); r.Estado != EstadoOK || !r.Redis.Disponible {
+		t.Errorf("Se esperaba estado ok: %+v", r)
+	}
+}
@@ -0,0 +1,117 @@
+// Package duplicados estima si dos registros de pacientes corresponden a la misma persona.
+//
+// El puntaje combina señales independientes: similitud del nombre completo (trigramas
+// de pg_trgm, sin acentos), fecha de nacimiento, número de credencial y DNI. Coincidir
+// en un identificador suma mucho; que los dos tengan DNI y sean distintos resta casi
+// todo, porque es la señal más fuerte de que son personas diferentes.
+package duplicados
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// UmbralCandidato es el puntaje mínimo para informar un posible duplicado
+	UmbralCandidato = 0.5
+	// UmbralProbable es el puntaje a partir del cual el alta se rechaza salvo confirmación
+	UmbralProbable = 0.8
+)
+
+// Pesos de cada señal
+const (
+	pesoNombre          = 0.4
+	pesoFechaNacimiento = 0.25
+	pesoCredencial      = 0.35
+	pesoCredencialOtra  = -0.1
+	pesoDNI             = 0.5
+	pesoDNIOtro         = -0.5
+)
+
+// Motivos que explican un puntaje
+const (
+	MotivoNombreSimilar        = "nombre_similar"
+	MotivoMismaFechaNacimiento = "misma_fecha_nacimiento"
+	MotivoMismaCredencial      = "misma_credencial"
+	MotivoMismoDNI             = "mismo_dni"
+	MotivoDNIDistinto          = "dni_distinto"
+)
+
+// Comparacion es el resultado de comparar un identificador opcional
+type Comparacion int
+
+const (
+	// SinDatos indica que al menos uno de los dos no tiene el dato
+	SinDatos Comparacion = iota
+	Igual
+	Distinto
+)
+
+// Senales son las comparaciones entre el paciente nuevo y un registro existente
+type Senales struct {
+	// SimilitudNombre es similarity() de pg_trgm sobre "nombre apellido" sin acentos (0 a 1)
+	SimilitudNombre      float64
+	MismaFechaNacimiento bool
+	Credencial           Comparacion
+	DNI                  Comparacion
+}
+
+// Comparar compara dos identificadores ignorando mayúsculas, espacios y separadores
+// ("20.123.456" y "20123456" son iguales)
+func Comparar(a, b *string) Comparacion {
+	if a == nil || b == nil {
+		return SinDatos
+	}
+	na, nb := normalizar(*a), normalizar(*b)
+	if na == "" || nb == "" {
+		return SinDatos
+	}
+	if na == nb {
+		return Igual
+	}
+	return Distinto
+}
+
+// Puntuar devuelve un puntaje entre 0 y 1 y los motivos que lo explican
+func Puntuar(s Senales) (float64, []string) {
+	var motivos []string
+	puntaje := pesoNombre * s.SimilitudNombre
+	if s.SimilitudNombre >= 0.5 {
+		motivos = append(motivos, MotivoNombreSimilar)
+	}
+	if s.MismaFechaNacimiento {
+		puntaje += pesoFechaNacimiento
+		motivos = append(motivos, MotivoMismaFechaNacimiento)
+	}
+	switch s.Credencial {
+	case Igual:
+		puntaje += pesoCredencial
+		motivos = append(motivos, MotivoMismaCredencial)
+	case Distinto:
+		puntaje += pesoCredencialOtra
+	}
+	switch s.DNI {
+	case Igual:
+		puntaje += pesoDNI
+		motivos = append(motivos, MotivoMismoDNI)
+	case Distinto:
+		puntaje += pesoDNIOtro
+		motivos = append(motivos, MotivoDNIDistinto)
+	}
+	if puntaje < 0 {
+		puntaje = 0
+	}
+	if puntaje > 1 {
+		puntaje = 1
+	}
+	return puntaje, motivos
+}
+
+func normalizar(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,48 @@
+package duplicados
+
+import "testing"
+
+func ptr(s string) *string { return &s }
+
+func TestComparar(t *testing.T) {
+	casos := []struct {
+		a, b *string
+		want Comparacion
+	}{
+		{ptr("20.123.456"), ptr("20123456"), Igual},
+		{ptr("ab-12 C"), ptr("AB12c"), Igual},
+		{ptr("20123456"), ptr("20123457"), Distinto},
+		{nil, ptr("20123456"), SinDatos},
+		{ptr(" - "), ptr("20123456"), SinDatos},
+	}
+	for i, c := range casos {
+		if got := Comparar(c.a, c.b); got != c.want {
+			t.Errorf("caso %d: se esperaba %v, obtuvo %v", i, c.want, got)
+		}
+	}
+}
+
+func TestPuntuar(t *testing.T) {
+	// Error de tipeo en el nombre, misma fecha y misma credencial: duplicado probable
+	p, motivos := Puntuar(Senales{SimilitudNombre: 0.6, MismaFechaNacimiento: true, Credencial: Igual})
+	if p < UmbralProbable || len(motivos) != 3 {
+		t.Errorf("Se esperaba un duplicado probable, obtuvo %.2f %v", p, motivos)
+	}
+
+	// Homónimo con la misma fecha: se informa pero no bloquea
+	p, _ = Puntuar(Senales{SimilitudNombre: 1, MismaFechaNacimiento: true})
+	if p < UmbralCandidato || p >= UmbralProbable {
+		t.Errorf("Se esperaba un candidato no concluyente, obtuvo %.2f", p)
+	}
+
+	// Mismo nombre y fecha pero DNI distinto: son personas diferentes
+	p, motivos = Puntuar(Senales{SimilitudNombre: 1, MismaFechaNacimiento: true, DNI: Distinto})
+	if p >= UmbralCandidato || motivos[len(motivos)-1] != MotivoDNIDistinto {
+		t.Errorf("Se esperaba descartar por DNI distinto, obtuvo %.2f %v", p, motivos)
+	}
+
+	// Todas las señales juntas no superan 1
+	if p, _ := Puntuar(Senales{SimilitudNombre: 1, MismaFechaNacimiento: true, Credencial: Igual, DNI: Igual}); p != 1 {
+		t.Errorf("Se esperaba puntaje 1, obtuvo %.2f", p)
+	}
+}
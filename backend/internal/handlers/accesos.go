@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// AccesoHandler expone el registro de accesos a pacientes, la revisión de los
+// accesos de emergencia y la asignación de profesionales a pacientes
+type AccesoHandler struct {
+	pool   TxPool
+	logger *zap.Logger
+}
+
+// NewAccesoHandler crea una nueva instancia del handler de accesos
+func NewAccesoHandler(pool TxPool, logger *zap.Logger) *AccesoHandler {
+	return &AccesoHandler{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// RevisionAccesoInput es la conclusión de la revisión de un acceso de emergencia
+type RevisionAccesoInput struct {
+	Resultado  string  `json:"resultado" binding:"required,oneof=justificado injustificado"`
+	Comentario *string `json:"comentario"`
+}
+
+// AccesoEmergencia es un acceso de emergencia con los nombres del profesional y del paciente
+type AccesoEmergencia struct {
+	models.AccesoPaciente
+	Profesional string `json:"profesional"`
+	Paciente    string `json:"paciente"`
+}
+
+const accesoColumns = `a.id, a.usuario_id, a.paciente_id, a.consultorio_id, a.recurso, a.ruta, a.motivo, a.ip, a.request_id,
+	a.permitido, a.emergencia, a.justificacion, a.requiere_revision, a.revisado_por, a.revisado_en,
+	a.resultado_revision, a.comentario_revision, a.fecha`
+
+func accesoDest(a *models.AccesoPaciente) []interface{} {
+	return []interface{}{&a.ID, &a.UsuarioID, &a.PacienteID, &a.ConsultorioID, &a.Recurso, &a.Ruta, &a.Motivo, &a.IP, &a.RequestID,
+		&a.Permitido, &a.Emergencia, &a.Justificacion, &a.RequiereRevision, &a.RevisadoPor, &a.RevisadoEn,
+		&a.ResultadoRevision, &a.ComentarioRevision, &a.Fecha}
+}
+
+// GetAccesosEmergencia godoc
+// @Summary      Reporte de accesos de emergencia
+// @Description  Lista los accesos de emergencia ("romper el vidrio"). Por defecto solo los pendientes de revisión.
+// @Tags         accesos
+// @Produce      json
+// @Param        estado  query  string  false  "pendientes (defecto), revisados o todos"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /api/v1/accesos/emergencia [get]
+func (h *AccesoHandler) GetAccesosEmergencia(c *gin.Context) {
+	filtro := ""
+	switch c.DefaultQuery("estado", "pendientes") {
+	case "pendientes":
+		filtro = " AND a.revisado_en IS NULL"
+	case "revisados":
+		filtro = " AND a.revisado_en IS NOT NULL"
+	case "todos":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "estado debe ser pendientes, revisados o todos"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var args []interface{}
+	rows, err := h.pool.Query(ctx, `
+		SELECT `+accesoColumns+`, COALESCE(u.nombre, ''), COALESCE(p.apellido || ', ' || p.nombre, '')
+		FROM accesos_pacientes a
+		LEFT JOIN usuarios u ON u.id = a.usuario_id
+		LEFT JOIN pacientes p ON p.id = a.paciente_id
+		WHERE a.requiere_revision`+filtro+` AND `+scope.Consultorio("a.consultorio_id", &args)+`
+		ORDER BY a.fecha
+	`, args...)
+	if err != nil {
+		h.logger.Error("Error al listar accesos de emergencia", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	accesos := []AccesoEmergencia{}
+	for rows.Next() {
+		var a AccesoEmergencia
+		if err := rows.Scan(append(accesoDest(&a.AccesoPaciente), &a.Profesional, &a.Paciente)...); err != nil {
+			h.logger.Error("Error al escanear acceso", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			return
+		}
+		accesos = append(accesos, a)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"accesos": accesos,
+		"total":   len(accesos),
+	})
+}
+
+// RevisarAcceso godoc
+// @Summary      Revisar acceso de emergencia
+// @Description  Registra la conclusión de la revisión obligatoria de un acceso de emergencia
+// @Tags         accesos
+// @Accept       json
+// @Produce      json
+// @Param        id        path  int                  true  "ID del acceso"
+// @Param        revision  body  RevisionAccesoInput  true  "Resultado de la revisión"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Router       /api/v1/accesos/{id}/revision [post]
+func (h *AccesoHandler) RevisarAcceso(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de acceso inválido"})
+		return
+	}
+	revisorID, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	var input RevisionAccesoInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo registrar la revisión"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var acceso models.AccesoPaciente
+	args := []interface{}{id}
+	err = tx.QueryRow(ctx, `
+		SELECT `+accesoColumns+` FROM accesos_pacientes a
+		WHERE a.id = $1 AND a.requiere_revision AND `+scope.Consultorio("a.consultorio_id", &args)+`
+		FOR UPDATE
+	`, args...).Scan(accesoDest(&acceso)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Acceso de emergencia no encontrado"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al obtener acceso", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo registrar la revisión"})
+		return
+	}
+	if acceso.RevisadoEn != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "El acceso ya fue revisado"})
+		return
+	}
+	if acceso.UsuarioID == revisorID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Un profesional no puede revisar su propio acceso de emergencia"})
+		return
+	}
+
+	antes := acceso
+	ahora := time.Now().UTC()
+	acceso.RevisadoPor = &revisorID
+	acceso.RevisadoEn = &ahora
+	acceso.ResultadoRevision = &input.Resultado
+	acceso.ComentarioRevision = input.Comentario
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE accesos_pacientes
+		SET revisado_por = $2, revisado_en = $3, resultado_revision = $4, comentario_revision = $5
+		WHERE id = $1
+	`, id, revisorID, ahora, input.Resultado, input.Comentario); err != nil {
+		h.logger.Error("Error al registrar revisión", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo registrar la revisión"})
+		return
+	}
+
+	entry := audit.FromRequest(c, audit.AccionActualizar, "accesos_pacientes", strconv.FormatInt(id, 10))
+	entry.Antes = antes
+	entry.Despues = acceso
+	err = audit.Write(ctx, tx, entry)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al confirmar revisión", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo registrar la revisión"})
+		return
+	}
+
+	h.logger.Info("Acceso de emergencia revisado",
+		zap.Int64("acceso_id", id),
+		zap.String("resultado", input.Resultado),
+		zap.String("revisor_id", revisorID.String()))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Revisión registrada",
+		"acceso":  acceso,
+	})
+}
+
+// GetAccesosPaciente godoc
+// @Summary      Accesos a un paciente
+// @Description  Lista quién leyó los datos clínicos del paciente, cuándo, desde dónde y por qué
+// @Tags         accesos
+// @Produce      json
+// @Param        id  path  string  true  "ID del paciente"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/accesos [get]
+func (h *AccesoHandler) GetAccesosPaciente(c *gin.Context) {
+	pacienteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []interface{}{pacienteID}
+	rows, err := h.pool.Query(ctx, `
+		SELECT `+accesoColumns+`
+		FROM accesos_pacientes a
+		WHERE a.paciente_id = $1 AND `+scope.Consultorio("a.consultorio_id", &args)+`
+		ORDER BY a.fecha DESC
+	`, args...)
+	if err != nil {
+		h.logger.Error("Error al listar accesos del paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	accesos := []models.AccesoPaciente{}
+	for rows.Next() {
+		var a models.AccesoPaciente
+		if err := rows.Scan(accesoDest(&a)...); err != nil {
+			h.logger.Error("Error al escanear acceso", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			return
+		}
+		accesos = append(accesos, a)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"accesos": accesos,
+		"total":   len(accesos),
+	})
+}
+
+// parseAsignacionParams valida los IDs de paciente y profesional de la ruta
+func parseAsignacionParams(c *gin.Context) (pacienteID, usuarioID uuid.UUID, ok bool) {
+	pacienteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	usuarioID, err = uuid.Parse(c.Param("usuario_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de profesional inválido"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return pacienteID, usuarioID, true
+}
+
+// AsignarProfesional godoc
+// @Summary      Asignar profesional a paciente
+// @Description  Permite a un profesional de otro consultorio acceder a los datos clínicos del paciente
+// @Tags         accesos
+// @Produce      json
+// @Param        id          path  string  true  "ID del paciente"
+// @Param        usuario_id  path  string  true  "ID del profesional"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/profesionales/{usuario_id} [put]
+func (h *AccesoHandler) AsignarProfesional(c *gin.Context) {
+	pacienteID, usuarioID, ok := parseAsignacionParams(c)
+	if !ok {
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	asignadoPor, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo asignar el profesional"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Solo se asignan profesionales a pacientes del propio consultorio
+	var existe bool
+	args := []interface{}{pacienteID}
+	if err := tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pacientes WHERE id = $1 AND `+scope.Consultorio("consultorio_id", &args)+`)
+	`, args...).Scan(&existe); err != nil {
+		h.logger.Error("Error al verificar paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo asignar el profesional"})
+		return
+	}
+	if !existe {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Paciente no encontrado"})
+		return
+	}
+
+	asignacion := models.PacienteProfesional{PacienteID: pacienteID, UsuarioID: usuarioID, AsignadoPor: &asignadoPor, AsignadoEn: time.Now().UTC()}
+	res, err := tx.Exec(ctx, `
+		INSERT INTO paciente_profesional (paciente_id, usuario_id, asignado_por, asignado_en)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`, asignacion.PacienteID, asignacion.UsuarioID, asignacion.AsignadoPor, asignacion.AsignadoEn)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El paciente o el profesional no existen"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al asignar profesional", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo asignar el profesional"})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "El profesional ya estaba asignado"})
+		return
+	}
+
+	entry := audit.FromRequest(c, audit.AccionCrear, "paciente_profesional", pacienteID.String()+":"+usuarioID.String())
+	entry.Despues = asignacion
+	err = audit.Write(ctx, tx, entry)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al confirmar asignación", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo asignar el profesional"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Profesional asignado exitosamente",
+		"asignacion": asignacion,
+	})
+}
+
+// DesasignarProfesional godoc
+// @Summary      Quitar profesional de un paciente
+// @Description  Revoca la asignación de un profesional a un paciente
+// @Tags         accesos
+// @Produce      json
+// @Param        id          path  string  true  "ID del paciente"
+// @Param        usuario_id  path  string  true  "ID del profesional"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/profesionales/{usuario_id} [delete]
+func (h *AccesoHandler) DesasignarProfesional(c *gin.Context) {
+	pacienteID, usuarioID, ok := parseAsignacionParams(c)
+	if !ok {
+		return
+	}
+	// Las API keys de las integraciones no asignan ni quitan profesionales
+	if _, ok := usuarioActual(c); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo quitar la asignación"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var asignacion models.PacienteProfesional
+	args := []interface{}{pacienteID, usuarioID}
+	err = tx.QueryRow(ctx, `
+		DELETE FROM paciente_profesional
+		WHERE paciente_id = $1 AND usuario_id = $2 AND `+scope.Paciente("paciente_id", &args)+`
+		RETURNING paciente_id, usuario_id, asignado_por, asignado_en
+	`, args...).Scan(&asignacion.PacienteID, &asignacion.UsuarioID, &asignacion.AsignadoPor, &asignacion.AsignadoEn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Asignación no encontrada"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al quitar asignación", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo quitar la asignación"})
+		return
+	}
+
+	entry := audit.FromRequest(c, audit.AccionEliminar, "paciente_profesional", pacienteID.String()+":"+usuarioID.String())
+	entry.Antes = asignacion
+	err = audit.Write(ctx, tx, entry)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al confirmar baja de asignación", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo quitar la asignación"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Asignación eliminada exitosamente"})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// accesoEmergenciaRow simula la fila de accesos_pacientes de un acceso de emergencia
+func accesoEmergenciaRow(usuarioID uuid.UUID, revisadoEn *time.Time) func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			if !strings.Contains(sql, "FROM accesos_pacientes") {
+				return pgx.ErrNoRows
+			}
+			*(dest[0].(*int64)) = 7
+			*(dest[1].(*uuid.UUID)) = usuarioID
+			*(dest[2].(*uuid.UUID)) = uuid.New()
+			*(dest[10].(*bool)) = true
+			*(dest[12].(*bool)) = true
+			*(dest[14].(**time.Time)) = revisadoEn
+			return nil
+		}}
+	}
+}
+
+func runRevisarAcceso(t *testing.T, pool *mockTxPool, revisorID uuid.UUID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	h := NewAccesoHandler(pool, zap.NewNop())
+	router := gin.New()
+	router.POST("/accesos/:id/revision", func(c *gin.Context) {
+		c.Set("user_id", revisorID.String())
+		tenant.Set(c, tenant.Scope{ConsultorioID: consultorioTest})
+		c.Next()
+	}, h.RevisarAcceso)
+
+	req := httptest.NewRequest(http.MethodPost, "/accesos/7/revision", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRevisarAcceso_Success(t *testing.T) {
+	tx := &mockTx{queryRowFunc: accesoEmergenciaRow(uuid.New(), nil)}
+	pool := &mockTxPool{tx: tx}
+
+	w := runRevisarAcceso(t, pool, uuid.New(), `{"resultado":"justificado","comentario":"Guardia nocturna"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	if !tx.committed {
+		t.Error("Se esperaba confirmar la transacción")
+	}
+	var update, auditoria bool
+	for _, sql := range tx.execSQL {
+		update = update || strings.Contains(sql, "UPDATE accesos_pacientes")
+		auditoria = auditoria || strings.Contains(sql, "INSERT INTO auditorias")
+	}
+	if !update || !auditoria {
+		t.Errorf("Se esperaba actualizar el acceso y auditar la revisión, SQL: %v", tx.execSQL)
+	}
+}
+
+func TestRevisarAcceso_YaRevisado(t *testing.T) {
+	revisado := time.Now()
+	pool := &mockTxPool{tx: &mockTx{queryRowFunc: accesoEmergenciaRow(uuid.New(), &revisado)}}
+
+	w := runRevisarAcceso(t, pool, uuid.New(), `{"resultado":"injustificado"}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Se esperaba status 409, obtuvo %d", w.Code)
+	}
+}
+
+func TestRevisarAcceso_PropioAcceso(t *testing.T) {
+	revisor := uuid.New()
+	pool := &mockTxPool{tx: &mockTx{queryRowFunc: accesoEmergenciaRow(revisor, nil)}}
+
+	w := runRevisarAcceso(t, pool, revisor, `{"resultado":"justificado"}`)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Se esperaba status 403, obtuvo %d", w.Code)
+	}
+}
+
+func TestRevisarAcceso_ResultadoInvalido(t *testing.T) {
+	pool := &mockTxPool{tx: &mockTx{}}
+
+	w := runRevisarAcceso(t, pool, uuid.New(), `{"resultado":"quizas"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Se esperaba status 400, obtuvo %d", w.Code)
+	}
+}
+
+// TestAsignarProfesional_ConAPIKey verifica que una API key no asigna ni quita profesionales:
+// no hay un usuario que figure como asignado_por ni en la auditoría
+func TestAsignarProfesional_ConAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tx := &mockTx{}
+	h := NewAccesoHandler(&mockTxPool{tx: tx}, zap.NewNop())
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("api_key_id", uuid.New().String())
+		tenant.Set(c, tenant.Scope{ConsultorioID: consultorioTest})
+		c.Next()
+	})
+	router.PUT("/pacientes/:id/profesionales/:usuario_id", h.AsignarProfesional)
+	router.DELETE("/pacientes/:id/profesionales/:usuario_id", h.DesasignarProfesional)
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/pacientes/"+uuid.New().String()+"/profesionales/"+uuid.New().String(), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: se esperaba 401, obtuvo %d: %s", method, w.Code, w.Body.String())
+		}
+	}
+	if len(tx.execSQL) > 0 || tx.committed {
+		t.Errorf("No se esperaba escribir en la base: %v", tx.execSQL)
+	}
+}
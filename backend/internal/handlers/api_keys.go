@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// APIKeyHandler administra las API keys de las integraciones del consultorio
+type APIKeyHandler struct {
+	pool   TxPool
+	logger *zap.Logger
+}
+
+// NewAPIKeyHandler crea el handler de administración de API keys
+func NewAPIKeyHandler(pool TxPool, logger *zap.Logger) *APIKeyHandler {
+	return &APIKeyHandler{pool: pool, logger: logger}
+}
+
+// APIKeyAdmin es una API key tal como la ve la administración; nunca incluye la clave ni
+// su hash
+type APIKeyAdmin struct {
+	ID              uuid.UUID  `json:"id"`
+	Nombre          string     `json:"nombre"`
+	Prefijo         string     `json:"prefijo"`
+	ConsultorioID   uuid.UUID  `json:"consultorio_id"`
+	Permisos        []string   `json:"permisos"`
+	LimitePorMinuto int        `json:"limite_por_minuto"`
+	ExpiraEn        *time.Time `json:"expira_en"`
+	RevocadaEn      *time.Time `json:"revocada_en"`
+	UltimoUsoEn     *time.Time `json:"ultimo_uso_en"`
+	UltimaIP        *string    `json:"ultima_ip"`
+	CreadaPor       *uuid.UUID `json:"creada_por"`
+	CreadaEn        time.Time  `json:"creada_en"`
+}
+
+// selectAPIKeyAdmin son las columnas de APIKeyAdmin; se completa con el WHERE
+const selectAPIKeyAdmin = `
+		SELECT id, nombre, 'mk_' || prefijo, consultorio_id, permisos, limite_por_minuto,
+			   expira_en, revocada_en, ultimo_uso_en, ultima_ip, creada_por, creada_en
+		FROM api_keys
+`
+
+func scanAPIKeyAdmin(row pgx.Row) (APIKeyAdmin, error) {
+	var k APIKeyAdmin
+	err := row.Scan(&k.ID, &k.Nombre, &k.Prefijo, &k.ConsultorioID, &k.Permisos, &k.LimitePorMinuto,
+		&k.ExpiraEn, &k.RevocadaEn, &k.UltimoUsoEn, &k.UltimaIP, &k.CreadaPor, &k.CreadaEn)
+	return k, err
+}
+
+// GetAPIKeys godoc
+// @Summary      Listar API keys
+// @Description  Lista las API keys del consultorio (o de todos, con alcance global), incluidas las revocadas
+// @Tags         api-keys
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Router       /api/v1/api-keys [get]
+func (h *APIKeyHandler) GetAPIKeys(c *gin.Context) {
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var args []interface{}
+	rows, err := h.pool.Query(ctx, selectAPIKeyAdmin+`
+		WHERE `+scope.Consultorio("consultorio_id", &args)+`
+		ORDER BY creada_en DESC`, args...)
+	if err != nil {
+		h.logger.Error("Error al consultar API keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	keys := make([]APIKeyAdmin, 0)
+	for rows.Next() {
+		k, err := scanAPIKeyAdmin(rows)
+		if err != nil {
+			h.logger.Error("Error al escanear API key", zap.Error(err))
+			continue
+		}
+		keys = append(keys, k)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "api_keys": keys, "total": len(keys)})
+}
+
+// CrearAPIKey godoc
+// @Summary      Crear API key
+// @Description  Crea una API key para una integración. La clave se muestra solo en esta respuesta. Solo se pueden dar permisos que tenga el usuario autenticado.
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Param        req  body  object  true  "nombre, permisos, limite_por_minuto (opcional, 60 por defecto), expira_en (opcional) y consultorio_id (opcional salvo con alcance global)"
+// @Success      201  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Router       /api/v1/api-keys [post]
+func (h *APIKeyHandler) CrearAPIKey(c *gin.Context) {
+	var req struct {
+		Nombre          string     `json:"nombre" binding:"required,max=100"`
+		Permisos        []string   `json:"permisos" binding:"required,min=1,dive,required"`
+		LimitePorMinuto int        `json:"limite_por_minuto" binding:"omitempty,min=1,max=10000"`
+		ExpiraEn        *time.Time `json:"expira_en"`
+		ConsultorioID   string     `json:"consultorio_id" binding:"omitempty,uuid"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Nombre = strings.TrimSpace(req.Nombre)
+	if req.LimitePorMinuto == 0 {
+		req.LimitePorMinuto = services.LimiteAPIKeyDefault
+	}
+	if req.ExpiraEn != nil && !req.ExpiraEn.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expira_en debe ser una fecha futura"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	consultorioID := scope.ConsultorioID
+	if req.ConsultorioID != "" {
+		consultorioID = uuid.MustParse(req.ConsultorioID)
+	} else if scope.Global {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "consultorio_id es obligatorio con alcance global"})
+		return
+	}
+	if !scope.Incluye(&consultorioID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No puede crear API keys de otro consultorio"})
+		return
+	}
+	rolID, ok := rolActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Una key opera sobre un único consultorio: el permiso global no tiene sentido
+	var ajenos []string
+	err := h.pool.QueryRow(ctx, `
+		SELECT COALESCE(array_agg(DISTINCT p), '{}') FROM unnest($1::text[]) p
+		WHERE p = $3 OR p NOT IN (
+			SELECT pe.nombre_permiso FROM rol_permiso rp JOIN permisos pe ON pe.id = rp.permiso_id
+			WHERE rp.rol_id = $2
+		)
+	`, req.Permisos, rolID, tenant.PermisoGlobal).Scan(&ajenos)
+	if err != nil {
+		h.logger.Error("Error al verificar permisos de la API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	if len(ajenos) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No puede dar a una API key permisos que usted no tiene", "permisos": ajenos})
+		return
+	}
+
+	clave, prefijo, hash, err := services.GenerarAPIKey()
+	if err != nil {
+		h.logger.Error("Error al generar API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	k := APIKeyAdmin{
+		ID:              uuid.New(),
+		Nombre:          req.Nombre,
+		Prefijo:         "mk_" + prefijo,
+		ConsultorioID:   consultorioID,
+		Permisos:        req.Permisos,
+		LimitePorMinuto: req.LimitePorMinuto,
+		ExpiraEn:        req.ExpiraEn,
+		CreadaEn:        time.Now(),
+	}
+	if usuarioID, ok := usuarioActual(c); ok {
+		k.CreadaPor = &usuarioID
+	}
+
+	// La clave y su hash nunca se guardan en la auditoría
+	entry := audit.FromRequest(c, audit.AccionCrearAPIKey, "api_keys", k.ID.String())
+	entry.ConsultorioID = &consultorioID
+	entry.Despues = k
+	err = audit.ExecTx(ctx, h.pool, entry, `
+		INSERT INTO api_keys (id, nombre, prefijo, clave_hash, consultorio_id, permisos, limite_por_minuto,
+			expira_en, creada_por, creada_en)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, k.ID, k.Nombre, prefijo, hash, k.ConsultorioID, k.Permisos, k.LimitePorMinuto, k.ExpiraEn, k.CreadaPor, k.CreadaEn)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Consultorio inexistente"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al crear API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	h.logger.Info("API key creada", zap.String("api_key_id", k.ID.String()), zap.String("prefijo", k.Prefijo))
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key creada. Guarde la clave: no se volverá a mostrar",
+		"clave":   clave,
+		"api_key": k,
+	})
+}
+
+// RevocarAPIKey godoc
+// @Summary      Revocar API key
+// @Description  Revoca una API key del consultorio; deja de aceptarse en el siguiente request
+// @Tags         api-keys
+// @Produce      json
+// @Param        id   path      string  true  "ID de la API key"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/api-keys/{id} [delete]
+func (h *APIKeyHandler) RevocarAPIKey(c *gin.Context) {
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de API key inválido"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	var consultorioID uuid.UUID
+	revocadaEn := time.Now()
+	if err == nil {
+		defer tx.Rollback(ctx)
+		args := []interface{}{id, revocadaEn}
+		err = tx.QueryRow(ctx, `
+			UPDATE api_keys SET revocada_en = $2
+			WHERE id = $1 AND revocada_en IS NULL AND `+scope.Consultorio("consultorio_id", &args)+`
+			RETURNING consultorio_id
+		`, args...).Scan(&consultorioID)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key no encontrada o ya revocada"})
+		return
+	}
+	if err == nil {
+		entry := audit.FromRequest(c, audit.AccionRevocarAPIKey, "api_keys", id.String())
+		entry.ConsultorioID = &consultorioID
+		entry.Despues = gin.H{"revocada_en": revocadaEn}
+		err = audit.Write(ctx, tx, entry)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al revocar API key", zap.Error(err), zap.String("api_key_id", id.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	h.logger.Info("API key revocada", zap.String("api_key_id", id.String()))
+	c.JSON(http.StatusOK, gin.H{"message": "API key revocada"})
+}
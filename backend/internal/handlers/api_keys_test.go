@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// TestCrearAPIKey verifica que no se pueden dar permisos ajenos ni crear keys de otro
+// consultorio, y que la clave se muestra una vez y no queda en la auditoría
+func TestCrearAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var auditoria []interface{}
+	tx := &mockTx{execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+		if strings.Contains(sql, "INSERT INTO auditorias") {
+			auditoria = args
+		}
+		return pgconn.NewCommandTag("INSERT 0 1"), nil
+	}}
+	pool := &mockTxPool{tx: tx}
+	pool.queryRowFunc = func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		// El rol 1 tiene turnos:read y pacientes:read
+		var ajenos []string
+		for _, p := range args[0].([]string) {
+			if p != "turnos:read" && p != "pacientes:read" {
+				ajenos = append(ajenos, p)
+			}
+		}
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			*dest[0].(*[]string) = ajenos
+			return nil
+		}}
+	}
+	h := NewAPIKeyHandler(pool, zap.NewNop())
+
+	casos := []struct {
+		nombre string
+		body   gin.H
+		code   int
+	}{
+		{"sin permisos", gin.H{"nombre": "laboratorio", "permisos": []string{}}, http.StatusBadRequest},
+		{"permiso ajeno", gin.H{"nombre": "laboratorio", "permisos": []string{"turnos:read", "usuarios:manage"}}, http.StatusForbidden},
+		{"otro consultorio", gin.H{"nombre": "laboratorio", "permisos": []string{"turnos:read"}, "consultorio_id": uuid.NewString()}, http.StatusForbidden},
+		{"vencida", gin.H{"nombre": "laboratorio", "permisos": []string{"turnos:read"}, "expira_en": "2020-01-01T00:00:00Z"}, http.StatusBadRequest},
+	}
+	for _, caso := range casos {
+		c, w := adminCtx("POST", caso.body, "")
+		h.CrearAPIKey(c)
+		if w.Code != caso.code {
+			t.Errorf("%s: se esperaba %d, obtuvo %d %s", caso.nombre, caso.code, w.Code, w.Body.String())
+		}
+	}
+	if len(tx.execSQL) != 0 {
+		t.Fatalf("No se esperaban altas: %v", tx.execSQL)
+	}
+
+	c, w := adminCtx("POST", gin.H{"nombre": "laboratorio", "permisos": []string{"turnos:read"}}, "")
+	h.CrearAPIKey(c)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Se esperaba 201, obtuvo %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Clave  string      `json:"clave"`
+		APIKey APIKeyAdmin `json:"api_key"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if !strings.HasPrefix(resp.Clave, resp.APIKey.Prefijo+"_") || resp.APIKey.LimitePorMinuto != 60 ||
+		resp.APIKey.ConsultorioID != consultorioTest || resp.APIKey.CreadaPor == nil {
+		t.Errorf("Respuesta inesperada: %+v", resp)
+	}
+	if !tx.committed || !strings.Contains(tx.execSQL[0], "INSERT INTO api_keys") || auditoria == nil {
+		t.Fatalf("Se esperaba el alta auditada: %v", tx.execSQL)
+	}
+	secreto := resp.Clave[strings.LastIndex(resp.Clave, "_")+1:]
+	for _, arg := range auditoria {
+		if b, ok := arg.([]byte); ok && (strings.Contains(string(b), secreto) || strings.Contains(string(b), "hash")) {
+			t.Errorf("La auditoría no debe incluir la clave ni su hash: %s", b)
+		}
+	}
+}
+
+// TestRevocarAPIKey verifica que la revocación se limita al consultorio y queda auditada
+func TestRevocarAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keyID := uuid.New()
+	var revocarArgs []interface{}
+	tx := &mockTx{}
+	tx.queryRowFunc = func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if !strings.Contains(sql, "UPDATE api_keys SET revocada_en") {
+			return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+		}
+		revocarArgs = args
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			if args[0] != keyID {
+				return pgx.ErrNoRows
+			}
+			setDest(dest, 0, consultorioTest)
+			return nil
+		}}
+	}
+	h := NewAPIKeyHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	c, w := adminCtx("DELETE", nil, uuid.NewString())
+	h.RevocarAPIKey(c)
+	if w.Code != http.StatusNotFound || tx.committed {
+		t.Fatalf("Se esperaba 404 para una key de otro consultorio, obtuvo %d", w.Code)
+	}
+
+	c, w = adminCtx("DELETE", nil, keyID.String())
+	h.RevocarAPIKey(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba 200, obtuvo %d %s", w.Code, w.Body.String())
+	}
+	if len(revocarArgs) != 3 || revocarArgs[2] != consultorioTest {
+		t.Errorf("Se esperaba filtrar por consultorio: %v", revocarArgs)
+	}
+	if !tx.committed || !strings.Contains(tx.execSQL[len(tx.execSQL)-1], "INSERT INTO auditorias") {
+		t.Errorf("Se esperaba auditar la revocación: %v", tx.execSQL)
+	}
+}
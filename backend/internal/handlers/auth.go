@@ -5,15 +5,19 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/FolkodeGroup/mediapp/internal/audit"
 	"github.com/FolkodeGroup/mediapp/internal/auth"
 	"github.com/FolkodeGroup/mediapp/internal/models"
 	"github.com/FolkodeGroup/mediapp/internal/security"
 	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/FolkodeGroup/mediapp/internal/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -27,14 +31,46 @@ import (
 type DBTX interface {
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
 	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
 }
 
 type AuthHandler struct {
 	logger         *zap.Logger
 	db             DBTX
-	generateToken  func(userID string, rolID int) (string, error)
+	generateToken  func(userID string, rolID int, consultorioID, sesionID string) (string, error)
 	verifyPassword func(plain, hash string) bool
-	redisService   *services.RedisService
+	limiter        LoginLimiter
+	refresh        RefreshTokens
+	revocador      TokenRevoker
+	mfa            MFA
+	cuenta         Cuenta
+	sesiones       Sesiones
+}
+
+// RefreshTokens emite y rota los refresh tokens (services.RefreshTokenService)
+type RefreshTokens interface {
+	Emitir(ctx context.Context, usuarioID string) (services.RefreshToken, error)
+	Rotar(ctx context.Context, token string) (services.RefreshToken, error)
+	Revocar(ctx context.Context, token, usuarioID string) error
+	RevocarFamilia(ctx context.Context, familia string) error
+}
+
+// LoginLimiter cuenta los fallos de login y aplica bloqueos temporales por cuenta y por IP
+// (services.RedisService)
+type LoginLimiter interface {
+	IncrementLoginAttempts(ctx context.Context, ip string) (int64, error)
+	IsIPBlocked(ctx context.Context, ip string) (bool, time.Duration, error)
+	BlockIP(ctx context.Context, ip string) (time.Duration, error)
+	IncrementAccountAttempts(ctx context.Context, username string) (int64, error)
+	IsAccountBlocked(ctx context.Context, username string) (bool, time.Duration, error)
+	BlockAccount(ctx context.Context, username string) (time.Duration, error)
+	ResetAccountAttempts(ctx context.Context, username string) error
+}
+
+// TokenRevoker revoca access tokens (services.RevocationService)
+type TokenRevoker interface {
+	RevocarToken(ctx context.Context, jti string, expira time.Time) error
+	RevocarUsuario(ctx context.Context, usuarioID string, desde time.Time) error
 }
 
 // NewAuthHandler crea un AuthHandler; opcionalmente se puede pasar un *services.RedisService
@@ -49,13 +85,17 @@ func NewAuthHandler(logger *zap.Logger, db DBTX, redisSvc ...*services.RedisServ
 		rs = redisSvc[0]
 	}
 
-	return &AuthHandler{
+	h := &AuthHandler{
 		logger:         logger,
 		db:             db,
-		generateToken:  auth.GenerateToken,
+		generateToken:  auth.GenerateSessionToken,
 		verifyPassword: security.CheckPasswordHash,
-		redisService:   rs,
 	}
+	// Sin Redis no hay bloqueos temporales (tests y entornos sin Redis)
+	if rs != nil {
+		h.limiter = rs
+	}
+	return h
 }
 
 // NewAuthHandlerWithRedis crea un AuthHandler incluyendo un servicio de Redis.
@@ -66,6 +106,43 @@ func NewAuthHandlerWithRedis(logger *zap.Logger, db DBTX, redisSvc *services.Red
 	return NewAuthHandler(logger, db, redisSvc)
 }
 
+// SetRefresh hace que el login emita refresh tokens y que /refresh los rote. revocador
+// habilita /logout y /logout-all; puede ser nil.
+func (h *AuthHandler) SetRefresh(refresh RefreshTokens, revocador TokenRevoker) {
+	h.refresh = refresh
+	h.revocador = revocador
+}
+
+// SetMFA agrega la autenticación en dos pasos: los usuarios con TOTP activo o cuyo rol
+// lo requiere completan el login en /login/mfa
+func (h *AuthHandler) SetMFA(mfa MFA) {
+	h.mfa = mfa
+}
+
+// SetCuenta agrega los flujos por correo: restablecer la contraseña y verificar el
+// email. Si cuenta es nil esos endpoints responden 501.
+func (h *AuthHandler) SetCuenta(cuenta Cuenta) {
+	h.cuenta = cuenta
+}
+
+// SetSesiones agrega el registro de sesiones: cada login abre una sesión (la familia de
+// sus refresh tokens) que el usuario puede ver y cerrar en /me/sessions. Sin sesiones
+// esos endpoints responden 501.
+func (h *AuthHandler) SetSesiones(sesiones Sesiones) {
+	h.sesiones = sesiones
+}
+
+// selectUsuarioLogin son las columnas que el login lee del usuario, incluido su estado de
+// autenticación en dos pasos; se completa con el WHERE
+const selectUsuarioLogin = `
+		SELECT u.id, u.nombre, u.email, u.contrasena_hash, u.rol_id, u.consultorio_id,
+			   u.activo, u.creado_en, u.intentos_fallidos, u.ultimo_login,
+			   COALESCE(r.mfa_requerido, false), COALESCE(m.confirmado_en IS NOT NULL, false)
+		FROM usuarios u
+		LEFT JOIN roles r ON r.id = u.rol_id
+		LEFT JOIN usuarios_mfa m ON m.usuario_id = u.id
+`
+
 // Login godoc
 // @Summary      Login de usuario
 // @Description  Autenticación de usuario y generación de token JWT
@@ -75,6 +152,8 @@ func NewAuthHandlerWithRedis(logger *zap.Logger, db DBTX, redisSvc *services.Red
 // @Param        loginReq  body  object  true  "Credenciales de acceso"
 // @Success      200  {object}  map[string]interface{}
 // @Failure      400  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      429  {object}  map[string]interface{}
 // @Failure      500  {object}  map[string]interface{}
 // @Router       /login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -104,21 +183,25 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		zap.String("username", loginReq.Username),
 		zap.String("ip", c.ClientIP()))
 
+	// Bloqueos temporales por cuenta y por IP; se verifican antes de buscar el usuario para
+	// responder igual exista o no
+	if !h.verificarBloqueo(c, loginReq.Username) {
+		return
+	}
+
 	var user models.Usuario
 	var passwordHash string
 	var intentosFallidos int
 	var ultimoLogin *time.Time
+	var mfaRequerido, mfaActivo bool
 
 	// ACTUALIZAR la consulta para incluir los nuevos campos
-	err := h.db.QueryRow(c.Request.Context(), `
-		SELECT id, nombre, email, contrasena_hash, rol_id, consultorio_id, 
-			   activo, creado_en, intentos_fallidos, ultimo_login
-		FROM usuarios 
-		WHERE nombre = $1 AND activo = true
+	err := h.db.QueryRow(c.Request.Context(), selectUsuarioLogin+`
+		WHERE u.nombre = $1 AND u.activo = true
 	`, loginReq.Username).Scan(
 		&user.ID, &user.Nombre, &user.Email, &passwordHash, &user.RolID,
 		&user.ConsultorioID, &user.Activo, &user.CreadoEn,
-		&intentosFallidos, &ultimoLogin,
+		&intentosFallidos, &ultimoLogin, &mfaRequerido, &mfaActivo,
 	)
 
 	// LOG TEMPORAL PARA DEPURACIÓN
@@ -132,7 +215,10 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		h.logger.Warn("Intento de login fallido - usuario no encontrado",
 			zap.String("username", loginReq.Username),
 			zap.String("ip", c.ClientIP()))
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciales inválidas"})
+		// Se verifica igual una contraseña para que el tiempo de respuesta no delate que el
+		// usuario no existe, y el fallo cuenta para el bloqueo como cualquier otro
+		h.verifyPassword(loginReq.Password, hashSinUsuario())
+		h.responderFallo(c, loginReq.Username)
 		return
 	} else if err != nil {
 		log.Error("Error al buscar usuario en la base de datos",
@@ -142,69 +228,265 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Verificar si la cuenta está bloqueada por demasiados intentos (5 o más)
+	// Verificar la contraseña (usar verificador inyectable)
+	if !h.verifyPassword(loginReq.Password, passwordHash) {
+		h.registrarFallo(c, log, user, intentosFallidos, "contrasena")
+		return
+	}
+	if security.NecesitaRehash(passwordHash) {
+		h.rehashear(c.Request.Context(), log, user.ID, loginReq.Password, passwordHash)
+	}
 
-	// LOG TEMPORAL
-	log.Info("DEBUG: Verificando bloqueo",
-		zap.Int("intentos_fallidos", intentosFallidos),
-		zap.Bool("esta_bloqueado", intentosFallidos >= 5))
+	// Segundo paso: el login se completa en /login/mfa con un código TOTP
+	if mfaActivo || mfaRequerido {
+		h.desafiarMFA(c, log, user, mfaActivo)
+		return
+	}
 
-	if intentosFallidos >= 5 {
-		log.Warn("Intento de login bloqueado - cuenta temporalmente bloqueada",
-			zap.String("username", loginReq.Username),
-			zap.String("user_id", user.ID.String()),
-			zap.Int("intentos_fallidos", intentosFallidos),
-			zap.String("ip", c.ClientIP()))
+	h.completarLogin(c, log, user, intentosFallidos, ultimoLogin, "", nil)
+}
 
-		c.JSON(http.StatusForbidden, gin.H{
-			"error": "Cuenta temporalmente bloqueada por demasiados intentos fallidos. Contacte al administrador.",
-		})
+// rehashear recalcula el hash de la contraseña con el algoritmo y costo configurados. Se
+// marca la transacción con mediapp.rehash para que el trigger de usuarios no revoque los
+// tokens: la contraseña es la misma. Si falla, el login sigue y se reintenta la próxima vez.
+func (h *AuthHandler) rehashear(ctx context.Context, log *zap.Logger, usuarioID uuid.UUID, password, anterior string) {
+	nuevo, err := security.HashPassword(password)
+	if err != nil {
+		log.Warn("No se pudo recalcular el hash de la contraseña", zap.Error(err), zap.String("user_id", usuarioID.String()))
 		return
 	}
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		log.Error("Error al iniciar transacción para recalcular hash", zap.Error(err))
+		return
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `SELECT set_config('mediapp.rehash', 'on', true)`); err != nil {
+		log.Error("Error al recalcular hash de contraseña", zap.Error(err))
+		return
+	}
+	// Si la contraseña cambió mientras tanto no se pisa
+	if _, err := tx.Exec(ctx, `UPDATE usuarios SET contrasena_hash = $2 WHERE id = $1 AND contrasena_hash = $3`,
+		usuarioID, nuevo, anterior); err != nil {
+		log.Error("Error al recalcular hash de contraseña", zap.Error(err))
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Error("Error al recalcular hash de contraseña", zap.Error(err))
+		return
+	}
+	log.Info("Hash de contraseña recalculado", zap.String("user_id", usuarioID.String()))
+}
 
-	// Verificar la contraseña (usar verificador inyectable)
-	if !h.verifyPassword(loginReq.Password, passwordHash) {
-		// También actualizar en la base de datos (mantener compatibilidad)
-		newAttempts := intentosFallidos + 1
-		_, execErr := h.db.Exec(c.Request.Context(), `
-			UPDATE usuarios 
-			SET intentos_fallidos = $1 
-			WHERE nombre = $2
-		`, newAttempts, loginReq.Username)
-
-		if execErr != nil {
-			log.Error("Error al actualizar intentos fallidos", zap.Error(execErr), zap.String("email", loginReq.Username))
+// hashSinUsuario devuelve un hash con el algoritmo actual contra el que se verifica la
+// contraseña cuando el usuario no existe
+var hashSinUsuario = sync.OnceValue(func() string {
+	hash, _ := security.HashPassword(uuid.NewString())
+	return hash
+})
+
+// verificarBloqueo responde 429 si la IP o la cuenta están bloqueadas. La cuenta es el
+// nombre de usuario ingresado, exista o no, así que la respuesta no revela cuáles existen.
+// Si no se puede consultar Redis responde 503: sin contador no hay límite de intentos.
+func (h *AuthHandler) verificarBloqueo(c *gin.Context, username string) bool {
+	if h.limiter == nil {
+		return true
+	}
+	ctx := c.Request.Context()
+	ipBloqueada, restanteIP, err := h.limiter.IsIPBlocked(ctx, c.ClientIP())
+	if err != nil {
+		h.logger.Error("Error al consultar bloqueo de IP", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Servicio de autenticación no disponible"})
+		return false
+	}
+	cuentaBloqueada, restanteCuenta, err := h.limiter.IsAccountBlocked(ctx, username)
+	if err != nil {
+		h.logger.Error("Error al consultar bloqueo de cuenta", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Servicio de autenticación no disponible"})
+		return false
+	}
+	if !ipBloqueada && !cuentaBloqueada {
+		return true
+	}
+	if restanteCuenta > restanteIP {
+		restanteIP = restanteCuenta
+	}
+	h.logger.Warn("Intento de login bloqueado",
+		zap.String("username", username),
+		zap.String("ip", c.ClientIP()),
+		zap.Bool("ip_bloqueada", ipBloqueada),
+		zap.Bool("cuenta_bloqueada", cuentaBloqueada))
+	responderBloqueo(c, restanteIP)
+	return false
+}
+
+// responderBloqueo responde 429 con el tiempo que falta para poder reintentar
+func responderBloqueo(c *gin.Context, restante time.Duration) {
+	segundos := int(math.Ceil(restante.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(segundos))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":         fmt.Sprintf("Demasiados intentos fallidos. Intente nuevamente en %d minutos.", int(math.Ceil(restante.Minutes()))),
+		"reintentar_en": segundos,
+	})
+}
+
+// responderFallo cuenta el fallo para la cuenta y la IP, bloqueándolas al llegar al límite,
+// y responde 401, o 429 si este fallo produjo el bloqueo de la cuenta
+func (h *AuthHandler) responderFallo(c *gin.Context, username string) {
+	if h.limiter == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciales inválidas"})
+		return
+	}
+	ctx := c.Request.Context()
+	if n, err := h.limiter.IncrementLoginAttempts(ctx, c.ClientIP()); err != nil {
+		h.logger.Error("Error al contar fallo de login por IP", zap.Error(err))
+	} else if n >= services.MaxIPLoginAttempts {
+		if _, err := h.limiter.BlockIP(ctx, c.ClientIP()); err != nil {
+			h.logger.Error("Error al bloquear IP", zap.Error(err))
 		}
+	}
 
-		h.logger.Warn("Intento de login fallido - contraseña incorrecta",
-			zap.String("email", loginReq.Username),
-			zap.String("ip", c.ClientIP()),
-			zap.Int("intentos_fallidos", newAttempts))
+	n, err := h.limiter.IncrementAccountAttempts(ctx, username)
+	if err != nil {
+		h.logger.Error("Error al contar fallo de login por cuenta", zap.Error(err))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciales inválidas"})
+		return
+	}
+	if n >= services.MaxLoginAttempts {
+		duracion, err := h.limiter.BlockAccount(ctx, username)
+		if err != nil {
+			h.logger.Error("Error al bloquear cuenta", zap.Error(err))
+		} else {
+			responderBloqueo(c, duracion)
+			return
+		}
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error":              "Credenciales inválidas",
+		"intentos_restantes": services.MaxLoginAttempts - n,
+	})
+}
 
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error":              "Credenciales inválidas",
-			"intentos_restantes": 5 - newAttempts, // Asumiendo bloqueo después de 5 intentos
-		})
+// registrarFallo suma un intento fallido (contraseña o código de dos pasos) al usuario, lo
+// audita y responde con responderFallo
+func (h *AuthHandler) registrarFallo(c *gin.Context, log *zap.Logger, user models.Usuario, intentosFallidos int, motivo string) {
+	newAttempts := intentosFallidos + 1
+	entry := audit.FromRequest(c, audit.AccionLoginFallido, "usuarios", user.ID.String())
+	entry.ConsultorioID = user.ConsultorioID
+	entry.Antes = gin.H{"intentos_fallidos": intentosFallidos}
+	entry.Despues = gin.H{"intentos_fallidos": newAttempts, "motivo": motivo}
+	execErr := audit.ExecTx(c.Request.Context(), h.db, entry, `
+		UPDATE usuarios 
+		SET intentos_fallidos = $1 
+		WHERE id = $2
+	`, newAttempts, user.ID)
+
+	if execErr != nil {
+		log.Error("Error al actualizar intentos fallidos", zap.Error(execErr), zap.String("user_id", user.ID.String()))
+	}
+
+	h.logger.Warn("Intento de login fallido",
+		zap.String("user_id", user.ID.String()),
+		zap.String("motivo", motivo),
+		zap.String("ip", c.ClientIP()),
+		zap.Int("intentos_fallidos", newAttempts))
+
+	h.responderFallo(c, user.Nombre)
+}
+
+// desafiarMFA responde al paso de la contraseña con un desafío para el segundo paso.
+// Sin servicio de dos pasos el login no puede completarse.
+func (h *AuthHandler) desafiarMFA(c *gin.Context, log *zap.Logger, user models.Usuario, enrolado bool) {
+	if h.mfa == nil {
+		log.Error("Usuario con autenticación en dos pasos sin servicio configurado", zap.String("user_id", user.ID.String()))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Autenticación en dos pasos no disponible"})
+		return
+	}
+	desafio, err := h.mfa.CrearDesafio(c.Request.Context(), user.ID.String())
+	if err != nil {
+		log.Error("Error al crear desafío de dos pasos", zap.Error(err), zap.String("user_id", user.ID.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
 		return
 	}
+	log.Info("Contraseña verificada, falta el segundo paso",
+		zap.String("user_id", user.ID.String()),
+		zap.Bool("mfa_enrolado", enrolado))
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Se requiere autenticación en dos pasos",
+		"mfa_requerido": true,
+		// Sin enrolamiento confirmado el cliente debe pasar por /login/mfa/enrolar
+		"mfa_enrolado": enrolado,
+		"mfa_token":    desafio.Token,
+		"mfa_expires":  desafio.ExpiraEn.Format(time.RFC3339),
+	})
+}
 
+// completarLogin reinicia los intentos fallidos, audita el login y responde con los tokens.
+// metodoMFA indica cómo se completó el segundo paso (vacío si no hubo) y extra se agrega a
+// la respuesta.
+func (h *AuthHandler) completarLogin(c *gin.Context, log *zap.Logger, user models.Usuario, intentosFallidos int, ultimoLogin *time.Time, metodoMFA string, extra gin.H) {
 	// LOGIN EXITOSO - Reiniciar intentos y actualizar último login
 	now := time.Now()
-	_, execErr := h.db.Exec(c.Request.Context(), `
+	entry := audit.FromRequest(c, audit.AccionLogin, "usuarios", user.ID.String())
+	entry.UsuarioID = &user.ID
+	entry.ConsultorioID = user.ConsultorioID
+	entry.Antes = gin.H{"intentos_fallidos": intentosFallidos, "ultimo_login": ultimoLogin}
+	entry.Despues = gin.H{"intentos_fallidos": 0, "ultimo_login": now}
+	if metodoMFA != "" {
+		entry.Despues = gin.H{"intentos_fallidos": 0, "ultimo_login": now, "mfa": metodoMFA}
+	}
+	execErr := audit.ExecTx(c.Request.Context(), h.db, entry, `
 		UPDATE usuarios 
 		SET intentos_fallidos = 0, ultimo_login = $1 
-		WHERE nombre = $2
-	`, now, loginReq.Username)
+		WHERE id = $2
+	`, now, user.ID)
 
 	if execErr != nil {
 		log.Error("Error al actualizar datos de login exitoso",
 			zap.Error(execErr),
-			zap.String("username", loginReq.Username))
+			zap.String("user_id", user.ID.String()))
 		// No retornamos error aquí, solo loggeamos
 	}
+	if h.limiter != nil {
+		if err := h.limiter.ResetAccountAttempts(c.Request.Context(), user.Nombre); err != nil {
+			log.Error("Error al reiniciar intentos de login", zap.Error(err), zap.String("user_id", user.ID.String()))
+		}
+	}
+
+	// Refresh token opaco de una familia nueva; se rota en cada /refresh
+	var refresh *services.RefreshToken
+	if h.refresh != nil {
+		rt, err := h.refresh.Emitir(c.Request.Context(), user.ID.String())
+		if err != nil {
+			log.Error("Error al emitir refresh token",
+				zap.Error(err),
+				zap.String("user_id", user.ID.String()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar token"})
+			return
+		}
+		refresh = &rt
+	}
+
+	// La familia del refresh token identifica la sesión; el access token la lleva en el
+	// claim sid para poder cerrarla desde otro dispositivo
+	sesionID := ""
+	if refresh != nil && h.sesiones != nil {
+		sesionID = refresh.Familia
+		if err := h.sesiones.Registrar(c.Request.Context(), sesionID, user.ID.String(), c.Request.UserAgent(), utils.GetRealIP(c.Request)); err != nil {
+			log.Error("Error al registrar la sesión",
+				zap.Error(err),
+				zap.String("user_id", user.ID.String()))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar token"})
+			return
+		}
+	}
 
 	// Generar token JWT
-	token, tokErr := h.generateToken(user.ID.String(), user.RolID)
+	consultorioID := ""
+	if user.ConsultorioID != nil {
+		consultorioID = user.ConsultorioID.String()
+	}
+	token, tokErr := h.generateToken(user.ID.String(), user.RolID, consultorioID, sesionID)
 	if tokErr != nil {
 		log.Error("Error al generar token",
 			zap.Error(tokErr),
@@ -222,7 +504,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		zap.Int("rol_id", user.RolID))
 
 	// Respuesta exitosa con token y datos del usuario
-	c.JSON(http.StatusOK, gin.H{
+	resp := gin.H{
 		"message": "Login exitoso",
 		"token":   token,
 		"user": gin.H{
@@ -234,141 +516,258 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			"activo":         user.Activo,
 			"creado_en":      user.CreadoEn,
 		},
-		"expires": time.Now().Add(24 * time.Hour).Format(time.RFC3339),
-	})
+		"expires": time.Now().Add(auth.AccessTokenTTL).Format(time.RFC3339),
+	}
+	if refresh != nil {
+		resp["refresh_token"] = refresh.Token
+		resp["refresh_expires"] = refresh.ExpiraEn.Format(time.RFC3339)
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
-// Register godoc
-// @Summary      Registrar nuevo usuario
-// @Description  Crea una nueva cuenta de usuario
+// RefreshToken godoc
+// @Summary      Renovar access token
+// @Description  Canjea un refresh token por un access token nuevo y un refresh token nuevo. Cada refresh token sirve una sola vez: presentar uno ya usado revoca todos los tokens de ese login.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
-// @Param        registerReq  body  object  true  "Datos de registro"
-// @Success      201  {object}  map[string]interface{}
-// @Failure      400  {object}  map[string]interface{}
-// @Failure      500  {object}  map[string]interface{}
-// @Router       /register [post]
-
-// RefreshToken removed: Redis-based refresh token not used in tests.
-
-func (h *AuthHandler) Register(c *gin.Context) {
-	var input struct {
-		Nombre        string `json:"nombre" binding:"required"`
-		Email         string `json:"email" binding:"required,email"`
-		Password      string `json:"password" binding:"required,min=6"`
-		RolID         int    `json:"rol_id" binding:"required"`
-		ConsultorioID string `json:"consultorio_id" binding:"required,uuid"`
-		Activo        bool   `json:"activo"`
+// @Param        refreshReq  body  object  true  "Refresh token"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      501  {object}  map[string]interface{}
+// @Router       /refresh [post]
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	if h.refresh == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Refresh token no disponible en esta instancia"})
+		return
 	}
 
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token requerido"})
 		return
 	}
 
-	consultorioUUID, err := uuid.Parse(input.ConsultorioID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Consultorio ID inválido"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	nuevo, err := h.refresh.Rotar(ctx, req.RefreshToken)
+	switch {
+	case errors.Is(err, services.ErrRefreshReutilizado):
+		h.registrarReutilizacion(c, ctx, nuevo)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token inválido o expirado"})
+		return
+	case errors.Is(err, services.ErrRefreshInvalido), errors.Is(err, services.ErrRefreshRevocado):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token inválido o expirado"})
+		return
+	case err != nil:
+		h.logger.Error("Error al rotar refresh token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno"})
 		return
 	}
 
-	userID := uuid.New()
-
-	hashedPassword, err := security.HashPassword(input.Password)
+	// Un usuario desactivado no puede seguir renovando sus tokens
+	var rolID int
+	var consultorioID *uuid.UUID
+	err = h.db.QueryRow(ctx, `
+	SELECT rol_id, consultorio_id FROM usuarios WHERE id = $1 AND activo = true
+`, nuevo.UsuarioID).Scan(&rolID, &consultorioID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if err := h.refresh.RevocarFamilia(ctx, nuevo.Familia); err != nil {
+			h.logger.Error("Error al revocar refresh tokens", zap.Error(err))
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token inválido o expirado"})
+		return
+	}
 	if err != nil {
-		h.logger.Error("Error al hashear la contraseña", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo obtener el rol del usuario"})
 		return
 	}
 
-	_, err = h.db.Exec(c.Request.Context(), `
-	       INSERT INTO usuarios (id, nombre, email, contrasena_hash, rol_id, consultorio_id, activo, creado_en)
-	       VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-       `, userID, input.Nombre, input.Email, string(hashedPassword), input.RolID, consultorioUUID, input.Activo, time.Now())
-
+	consultorio := ""
+	if consultorioID != nil {
+		consultorio = consultorioID.String()
+	}
+	sesionID := ""
+	if h.sesiones != nil {
+		sesionID = nuevo.Familia
+		if err := h.sesiones.Registrar(ctx, sesionID, nuevo.UsuarioID, c.Request.UserAgent(), utils.GetRealIP(c.Request)); err != nil {
+			h.logger.Error("Error al registrar la sesión", zap.Error(err), zap.String("user_id", nuevo.UsuarioID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar token"})
+			return
+		}
+	}
+	token, err := h.generateToken(nuevo.UsuarioID, rolID, consultorio, sesionID)
 	if err != nil {
-		h.logger.Error("Error al guardar usuario en DB", zap.Error(err))
-		fmt.Printf("[DEBUG SQL ERROR] %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo registrar el usuario", "detalle": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Usuario registrado exitosamente",
-		"id":      userID.String(),
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":    token,
+		"refresh_token":   nuevo.Token,
+		"expires":         time.Now().Add(auth.AccessTokenTTL).Format(time.RFC3339),
+		"refresh_expires": nuevo.ExpiraEn.Format(time.RFC3339),
 	})
 }
 
-// RefreshToken maneja la renovación del access token usando un refresh token almacenado en Redis.
-// Es opcional: si no hay redisService configurado devuelve 501.
-func (h *AuthHandler) RefreshToken(c *gin.Context) {
-	if h.redisService == nil {
-		c.JSON(http.StatusNotImplemented, gin.H{"error": "Refresh token no disponible en esta instancia"})
-		return
+// registrarReutilizacion deja en la auditoría el uso de un refresh token ya rotado: es la
+// señal de que un token pudo haber sido robado
+func (h *AuthHandler) registrarReutilizacion(c *gin.Context, ctx context.Context, rt services.RefreshToken) {
+	h.logger.Warn("Evento de seguridad: refresh token reutilizado",
+		zap.String("user_id", rt.UsuarioID),
+		zap.String("familia", rt.Familia),
+		zap.String("ip", c.ClientIP()))
+
+	entry := audit.FromRequest(c, audit.AccionRefreshReutilizado, "usuarios", rt.UsuarioID)
+	if id, err := uuid.Parse(rt.UsuarioID); err == nil {
+		entry.UsuarioID = &id
+	}
+	entry.Despues = gin.H{"familia_revocada": rt.Familia}
+	if err := h.escribirAuditoria(ctx, entry); err != nil {
+		h.logger.Error("Error al auditar reutilización de refresh token", zap.Error(err))
+	}
+}
+
+// escribirAuditoria escribe una entrada de auditoría sin otros cambios en la base
+func (h *AuthHandler) escribirAuditoria(ctx context.Context, entry audit.Entry) error {
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback(ctx)
+	if err := audit.Write(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
 
+// Logout godoc
+// @Summary      Cerrar sesión
+// @Description  Revoca el access token usado en el pedido hasta su vencimiento y cierra su sesión. Si se envía el refresh token de la sesión también se revoca.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        logoutReq  body  object  false  "Refresh token de la sesión (opcional)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      501  {object}  map[string]interface{}
+// @Router       /logout [post]
+func (h *AuthHandler) Logout(c *gin.Context) {
+	if h.revocador == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Logout no disponible en esta instancia"})
+		return
+	}
 	var req struct {
-		RefreshToken string `json:"refresh_token" binding:"required"`
+		RefreshToken string `json:"refresh_token"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token requerido"})
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	userID := c.GetString("user_id")
+	jti := c.GetString("jti")
+	if jti == "" {
+		// Tokens emitidos antes de que existiera el jti
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El token no se puede revocar individualmente, use /logout-all"})
 		return
 	}
+	expira, _ := c.Get("token_exp")
+	vence, _ := expira.(time.Time)
 
-	ctx := context.Background()
-	userID, err := h.redisService.Client().Get(ctx, "refresh:"+req.RefreshToken).Result()
-	if err == redis.Nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token inválido o expirado"})
-		return
-	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := h.revocador.RevocarToken(ctx, jti, vence); err != nil {
+		h.logger.Error("Error al revocar token", zap.Error(err), zap.String("user_id", userID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo cerrar la sesión"})
 		return
 	}
+	if req.RefreshToken != "" && h.refresh != nil {
+		if err := h.refresh.Revocar(ctx, req.RefreshToken, userID); err != nil {
+			h.logger.Error("Error al revocar refresh token", zap.Error(err), zap.String("user_id", userID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo cerrar la sesión"})
+			return
+		}
+	}
+	// La sesión del token se cierra aunque no se envíe el refresh token
+	if sid := c.GetString("sid"); sid != "" && h.sesiones != nil {
+		if _, err := h.sesiones.Cerrar(ctx, userID, sid); err != nil && !errors.Is(err, services.ErrSesionInexistente) {
+			h.logger.Error("Error al cerrar la sesión", zap.Error(err), zap.String("user_id", userID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo cerrar la sesión"})
+			return
+		}
+	}
 
-	var rolID int
-	err = h.db.QueryRow(ctx, `
-	SELECT rol_id FROM usuarios WHERE id = $1
-`, userID).Scan(&rolID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo obtener el rol del usuario"})
+	entry := audit.FromRequest(c, audit.AccionLogout, "usuarios", userID)
+	entry.Despues = gin.H{"todas": false}
+	if err := h.escribirAuditoria(ctx, entry); err != nil {
+		h.logger.Error("Error al auditar logout", zap.Error(err))
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sesión cerrada"})
+}
+
+// LogoutAll godoc
+// @Summary      Cerrar todas las sesiones
+// @Description  Revoca todos los access y refresh tokens del usuario emitidos hasta ahora, en todos los dispositivos
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      501  {object}  map[string]interface{}
+// @Router       /logout-all [post]
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	if h.revocador == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Logout no disponible en esta instancia"})
 		return
 	}
+	userID := c.GetString("user_id")
 
-	token, err := auth.GenerateToken(userID, rolID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar token"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := h.revocador.RevocarUsuario(ctx, userID, time.Now()); err != nil {
+		h.logger.Error("Error al revocar tokens del usuario", zap.Error(err), zap.String("user_id", userID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron cerrar las sesiones"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"access_token": token})
+	entry := audit.FromRequest(c, audit.AccionLogout, "usuarios", userID)
+	entry.Despues = gin.H{"todas": true}
+	if err := h.escribirAuditoria(ctx, entry); err != nil {
+		h.logger.Error("Error al auditar logout", zap.Error(err))
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Se cerraron todas las sesiones"})
 }
 
-// ProtectedEndpoint ejemplo de endpoint protegido
+// ProtectedEndpoint ejemplo de endpoint protegido. Va detrás del middleware JWT, que
+// rechaza los tokens revocados y los de sesiones cerradas; los claims se leen del contexto.
 func (h *AuthHandler) ProtectedEndpoint(c *gin.Context) {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
+	userID, ok := usuarioActual(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token requerido"})
 		return
 	}
-
-	var tokenString string
-	_, err := fmt.Sscanf(authHeader, "Bearer %s", &tokenString)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Formato de token inválido"})
-		return
+	rolID, _ := rolActual(c)
+	consultorioID, _ := c.Get("consultorio_id")
+
+	resp := gin.H{
+		"message":        "Acceso autorizado",
+		"user_id":        userID.String(),
+		"role":           rolID,
+		"consultorio_id": consultorioID,
 	}
-
-	claims, err := auth.ValidateToken(tokenString)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido: " + err.Error()})
-		return
+	if exp, ok := c.Get("token_exp"); ok {
+		if t, ok := exp.(time.Time); ok {
+			resp["exp"] = t.Format(time.RFC3339)
+		}
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Acceso autorizado",
-		"user_id": claims.UserID,
-		"role":    claims.RolID,
-		"exp":     claims.ExpiresAt.Time.Format(time.RFC3339),
-	})
-}
\ No newline at end of file
+	c.JSON(http.StatusOK, resp)
+}
@@ -13,13 +13,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/FolkodeGroup/mediapp/internal/audit"
 	"github.com/FolkodeGroup/mediapp/internal/auth"
+	"github.com/FolkodeGroup/mediapp/internal/middleware"
 	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/FolkodeGroup/mediapp/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // setDest asigna el valor v al destino dest[i], donde dest[i] es un puntero
@@ -106,6 +110,11 @@ func (m *mockDB) QueryRow(ctx context.Context, sql string, args ...interface{})
 func (m *mockDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
 	return m.execFunc(ctx, sql, args...)
 }
+// Begin devuelve una transacción que comparte execFunc; sus consultas (las de la
+// cadena de auditoría) no encuentran filas
+func (m *mockDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return &mockTx{execFunc: m.execFunc}, nil
+}
 
 // TestLoginSuccess prueba el login exitoso
 func TestLoginSuccess(t *testing.T) {
@@ -142,7 +151,7 @@ func TestLoginSuccess(t *testing.T) {
 
 	logger := zap.NewNop()
 	h := NewAuthHandler(logger, mockdb)
-	h.generateToken = func(uid string, rid int) (string, error) { return "mocktoken", nil }
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "mocktoken", nil }
 	// Inyectar verificador de contraseña para test
 	h.verifyPassword = func(plain, hash string) bool { return plain == password }
 
@@ -199,7 +208,7 @@ func TestLoginInvalidPassword(t *testing.T) {
 
 	logger := zap.NewNop()
 	h := NewAuthHandler(logger, mockdb)
-	h.generateToken = func(uid string, rid int) (string, error) { return "mocktoken", nil }
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "mocktoken", nil }
 	h.verifyPassword = func(plain, hash string) bool { return false } // forzar fallo
 
 	reqBody := map[string]string{"username": "usuario", "password": "wrongpass"}
@@ -222,28 +231,64 @@ func TestLoginInvalidPassword(t *testing.T) {
 	}
 }
 
-// TestLoginUserBlocked prueba login con usuario bloqueado
+// fakeLimiter lleva fallos y bloqueos en memoria
+type fakeLimiter struct {
+	intentos  map[string]int64
+	bloqueos  map[string]time.Duration
+	bloqueada map[string]bool
+}
+
+func newFakeLimiter() *fakeLimiter {
+	return &fakeLimiter{intentos: map[string]int64{}, bloqueos: map[string]time.Duration{}, bloqueada: map[string]bool{}}
+}
+
+func (f *fakeLimiter) IncrementLoginAttempts(ctx context.Context, ip string) (int64, error) {
+	f.intentos["ip:"+ip]++
+	return f.intentos["ip:"+ip], nil
+}
+func (f *fakeLimiter) IsIPBlocked(ctx context.Context, ip string) (bool, time.Duration, error) {
+	return f.bloqueada["ip:"+ip], f.bloqueos["ip:"+ip], nil
+}
+func (f *fakeLimiter) BlockIP(ctx context.Context, ip string) (time.Duration, error) {
+	f.bloqueada["ip:"+ip], f.bloqueos["ip:"+ip] = true, services.BlockDuration
+	return services.BlockDuration, nil
+}
+func (f *fakeLimiter) IncrementAccountAttempts(ctx context.Context, username string) (int64, error) {
+	f.intentos[username]++
+	return f.intentos[username], nil
+}
+func (f *fakeLimiter) IsAccountBlocked(ctx context.Context, username string) (bool, time.Duration, error) {
+	return f.bloqueada[username], f.bloqueos[username], nil
+}
+func (f *fakeLimiter) BlockAccount(ctx context.Context, username string) (time.Duration, error) {
+	f.bloqueada[username], f.bloqueos[username], f.intentos[username] = true, services.BlockDuration, 0
+	return services.BlockDuration, nil
+}
+func (f *fakeLimiter) ResetAccountAttempts(ctx context.Context, username string) error {
+	delete(f.intentos, username)
+	return nil
+}
+
+// TestLoginUserBlocked verifica que el bloqueo es temporal (en Redis, no por
+// usuarios.intentos_fallidos) y que se responde igual exista o no el usuario
 func TestLoginUserBlocked(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	userID := uuid.New()
-	rolID := 2
 	password := "testpass123"
-	hashedPassword, _ := security.HashPassword(password)
 
 	mockdb := &mockDB{
 		queryRowFunc: func(ctx context.Context, _sql string, args ...interface{}) pgx.Row {
 			return mockRow{
 				scanFunc: func(dest ...interface{}) error {
+					if args[0] != "usuario" {
+						return sql.ErrNoRows
+					}
 					setDest(dest, 0, userID)
-					setDest(dest, 1, "Test User")
-					setDest(dest, 2, "test@example.com")
-					setDest(dest, 3, hashedPassword)
-					setDest(dest, 4, rolID)
-					setDest(dest, 5, nil)
+					setDest(dest, 1, "usuario")
+					setDest(dest, 4, 2)
 					setDest(dest, 6, true)
-					setDest(dest, 7, time.Now())
+					// Los fallos históricos ya no bloquean la cuenta
 					setDest(dest, 8, int64(6))
-					setDest(dest, 9, nil)
 					return nil
 				},
 			}
@@ -253,32 +298,54 @@ func TestLoginUserBlocked(t *testing.T) {
 		},
 	}
 
-	logger := zap.NewNop()
-	h := NewAuthHandler(logger, mockdb)
-	h.generateToken = func(uid string, rid int) (string, error) { return "mocktoken", nil }
+	limiter := newFakeLimiter()
+	h := NewAuthHandler(zap.NewNop(), mockdb)
+	h.limiter = limiter
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "mocktoken", nil }
 	h.verifyPassword = func(plain, hash string) bool { return plain == password }
 
-	reqBody := map[string]string{"username": "usuario", "password": password}
-	jsonBody, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonBody))
-	req.Header.Set("Content-Type", "application/json")
-	rec := httptest.NewRecorder()
-	ctx, _ := gin.CreateTestContext(rec)
-	ctx.Request = req
-
-	h.Login(ctx)
+	login := func(username, pass string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		jsonBody, _ := json.Marshal(map[string]string{"username": username, "password": pass})
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(rec)
+		ctx.Request = req
+		h.Login(ctx)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
 
-	if rec.Code != http.StatusForbidden {
-		t.Fatalf("Se esperaba status 403, obtuvo %d", rec.Code)
+	if rec, _ := login("usuario", password); rec.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200 pese a intentos_fallidos históricos, obtuvo %d", rec.Code)
 	}
-	var resp map[string]interface{}
-	json.Unmarshal(rec.Body.Bytes(), &resp)
-	// Solo debe contener el mensaje de bloqueo, no intentos_restantes
-	if resp["error"] == nil {
-		t.Errorf("Se esperaba mensaje de bloqueo, obtuvo %v", resp)
+
+	// Un usuario existente y uno inexistente reciben las mismas respuestas
+	for _, username := range []string{"usuario", "noexiste"} {
+		for i := 1; i < services.MaxLoginAttempts; i++ {
+			rec, resp := login(username, "mala")
+			if rec.Code != http.StatusUnauthorized || resp["intentos_restantes"] != float64(services.MaxLoginAttempts-i) {
+				t.Fatalf("%s: se esperaba 401 con intentos_restantes, obtuvo %d %v", username, rec.Code, resp)
+			}
+		}
+		rec, resp := login(username, "mala")
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "600" {
+			t.Fatalf("%s: se esperaba 429 al llegar al límite, obtuvo %d %v", username, rec.Code, resp)
+		}
+		if _, ok := resp["intentos_restantes"]; ok {
+			t.Errorf("No se esperaba 'intentos_restantes' en la respuesta de usuario bloqueado: %v", resp)
+		}
+		// Bloqueada, ni la contraseña correcta sirve
+		if rec, _ := login(username, password); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: se esperaba 429 durante el bloqueo, obtuvo %d", username, rec.Code)
+		}
 	}
-	if _, ok := resp["intentos_restantes"]; ok {
-		t.Errorf("No se esperaba 'intentos_restantes' en la respuesta de usuario bloqueado: %v", resp)
+
+	// Al vencer el bloqueo se puede volver a ingresar
+	limiter.bloqueada["usuario"] = false
+	if rec, _ := login("usuario", password); rec.Code != http.StatusOK {
+		t.Errorf("Se esperaba status 200 al vencer el bloqueo, obtuvo %d", rec.Code)
 	}
 }
 
@@ -302,7 +369,7 @@ func TestLoginUserNotFound(t *testing.T) {
 
 	logger := zap.NewNop()
 	h := NewAuthHandler(logger, mockdb)
-	h.generateToken = func(uid string, rid int) (string, error) { return "mocktoken", nil }
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "mocktoken", nil }
 	h.verifyPassword = func(plain, hash string) bool { return plain == "irrelevante" }
 
 	reqBody := map[string]string{"username": "usuarionoexistente", "password": "irrelevante"}
@@ -342,7 +409,7 @@ func TestLoginQueryError(t *testing.T) {
 
 	logger := zap.NewNop()
 	h := NewAuthHandler(logger, mockdb)
-	h.generateToken = func(uid string, rid int) (string, error) { return "", nil }
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "", nil }
 	h.verifyPassword = func(plain, hash string) bool { return false }
 
 	reqBody := map[string]string{"username": "x@example.com", "password": "p"}
@@ -415,7 +482,7 @@ func TestLoginTokenGenerationError(t *testing.T) {
 
 	logger := zap.NewNop()
 	h := NewAuthHandler(logger, mockdb)
-	h.generateToken = func(uid string, rid int) (string, error) { return "", errors.New("token fail") }
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "", errors.New("token fail") }
 	h.verifyPassword = func(plain, hash string) bool { return plain == password }
 
 	reqBody := map[string]string{"username": "usuario", "password": password}
@@ -469,7 +536,7 @@ func TestLoginExecUpdateAttemptsError(t *testing.T) {
 
 	logger := zap.NewNop()
 	h := NewAuthHandler(logger, mockdb)
-	h.generateToken = func(uid string, rid int) (string, error) { return "", nil }
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "", nil }
 	h.verifyPassword = func(plain, hash string) bool { return false }
 
 	reqBody := map[string]string{"username": "usuario", "password": "wrong"}
@@ -523,7 +590,7 @@ func TestLoginResetAttemptsExecError(t *testing.T) {
 
 	logger := zap.NewNop()
 	h := NewAuthHandler(logger, mockdb)
-	h.generateToken = func(uid string, rid int) (string, error) { return "mocktoken", nil }
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "mocktoken", nil }
 	h.verifyPassword = func(plain, hash string) bool { return plain == password }
 
 	reqBody := map[string]string{"username": "usuario", "password": password}
@@ -541,138 +608,696 @@ func TestLoginResetAttemptsExecError(t *testing.T) {
 	}
 }
 
-// TestRegisterSuccess prueba registro exitoso
-func TestRegisterSuccess(t *testing.T) {
+// ProtectedEndpoint tests
+func TestProtectedEndpointMissingToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop()
+	h := NewAuthHandler(logger, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "/protected", nil)
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	ctx.Request = req
+
+	h.ProtectedEndpoint(ctx)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Se esperaba status 401, obtuvo %d", rec.Code)
+	}
+}
+
+// revocadosTest marca como revocados todos los tokens
+type revocadosTest struct{}
+
+func (revocadosTest) Revocado(ctx context.Context, jti, usuarioID string, emitido time.Time) (bool, error) {
+	return true, nil
+}
+
+// TestProtectedEndpointDetrasDelMiddleware verifica que /protected usa los claims del
+// middleware JWT: un token válido pasa y uno revocado (logout) ya no
+func TestProtectedEndpointDetrasDelMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := zap.NewNop()
+	auth.Init(logger, nil)
+	h := NewAuthHandler(logger, nil)
+
+	uid := uuid.New()
+	token, err := auth.GenerateToken(uid.String(), 2, uuid.New().String())
+	if err != nil {
+		t.Fatalf("No se pudo generar token en test: %v", err)
+	}
+	pedir := func(jwtAuth gin.HandlerFunc, header string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/protected", jwtAuth, h.ProtectedEndpoint)
+		req, _ := http.NewRequest(http.MethodGet, "/protected", nil)
+		req.Header.Set("Authorization", header)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := pedir(middleware.JWTAuthMiddleware(), "Bearer "+token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", rec.Code)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp["user_id"] != uid.String() || resp["role"] != float64(2) || resp["exp"] == nil {
+		t.Errorf("Claims inesperados: %v", resp)
+	}
+
+	if rec := pedir(middleware.JWTAuthMiddleware(), "Bearer invalidtoken"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Se esperaba status 401 con un token inválido, obtuvo %d", rec.Code)
+	}
+	if rec := pedir(middleware.JWTAuthMiddleware(revocadosTest{}), "Bearer "+token); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Se esperaba status 401 con un token revocado, obtuvo %d", rec.Code)
+	}
+}
+
+// TestLoginSuccessWritesAudit verifica que el login exitoso queda auditado en la misma transacción
+func TestLoginSuccessWritesAudit(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
-	consultorio := uuid.New()
+	userID := uuid.New()
+	var auditoria []interface{}
 	mockdb := &mockDB{
 		queryRowFunc: func(ctx context.Context, _sql string, args ...interface{}) pgx.Row {
-			return mockRow{scanFunc: func(dest ...interface{}) error { return sql.ErrNoRows }}
+			return mockRow{scanFunc: func(dest ...interface{}) error {
+				setDest(dest, 0, userID)
+				setDest(dest, 1, "Test User")
+				setDest(dest, 4, 2)
+				setDest(dest, 6, true)
+				return nil
+			}}
 		},
 		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
-			return pgconn.NewCommandTag("INSERT 1"), nil
+			if strings.Contains(sql, "INSERT INTO auditorias") {
+				auditoria = args
+			}
+			return pgconn.NewCommandTag("UPDATE 1"), nil
 		},
 	}
 
-	logger := zap.NewNop()
-	h := NewAuthHandler(logger, mockdb)
+	h := NewAuthHandler(zap.NewNop(), mockdb)
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "mocktoken", nil }
+	h.verifyPassword = func(plain, hash string) bool { return true }
 
-	reqBody := map[string]interface{}{
-		"nombre":         "Nuevo",
-		"email":          "nuevo@example.com",
-		"password":       "password",
-		"rol_id":         1,
-		"consultorio_id": consultorio.String(),
-		"activo":         true,
-	}
-	jsonBody, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(jsonBody))
+	jsonBody, _ := json.Marshal(map[string]string{"username": "usuario", "password": "x"})
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 	rec := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(rec)
 	ctx.Request = req
+	ctx.Set("request_id", "req-1")
 
-	h.Register(ctx)
+	h.Login(ctx)
 
-	if rec.Code != http.StatusCreated {
-		t.Fatalf("Se esperaba status 201, obtuvo %d", rec.Code)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", rec.Code)
+	}
+	if auditoria == nil {
+		t.Fatal("Se esperaba un registro de auditoría del login")
+	}
+	if *auditoria[0].(*uuid.UUID) != userID || auditoria[1] != "login" || *auditoria[4].(*string) != "req-1" {
+		t.Errorf("Auditoría inesperada: %v", auditoria)
+	}
+	if strings.Contains(string(auditoria[7].([]byte)), "contrasena") {
+		t.Error("La auditoría no debe incluir datos de la contraseña")
 	}
 }
 
-// TestRegisterInvalidConsultorioUUID valida que request con uuid inválido devuelve 400
-func TestRegisterInvalidConsultorioUUID(t *testing.T) {
+// TestLoginRehash verifica que un hash con otro algoritmo se recalcula al iniciar sesión,
+// marcando la transacción para que el trigger no revoque los tokens
+func TestLoginRehash(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
-	logger := zap.NewNop()
-	h := NewAuthHandler(logger, nil)
-
-	reqBody := map[string]interface{}{
-		"nombre":         "Nuevo",
-		"email":          "nuevo@example.com",
-		"password":       "password",
-		"rol_id":         1,
-		"consultorio_id": "not-uuid",
-		"activo":         true,
+	userID := uuid.New()
+	anterior, _ := bcrypt.GenerateFromPassword([]byte("clave-vieja"), bcrypt.MinCost)
+	var marcado bool
+	var nuevo string
+	mockdb := &mockDB{
+		queryRowFunc: func(ctx context.Context, _sql string, args ...interface{}) pgx.Row {
+			return mockRow{scanFunc: func(dest ...interface{}) error {
+				setDest(dest, 0, userID)
+				setDest(dest, 1, "Test User")
+				setDest(dest, 3, string(anterior))
+				setDest(dest, 4, 2)
+				setDest(dest, 6, true)
+				return nil
+			}}
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "mediapp.rehash") {
+				marcado = true
+			}
+			if strings.Contains(sql, "SET contrasena_hash") {
+				if args[2] != string(anterior) {
+					t.Errorf("El UPDATE debe condicionarse al hash anterior: %v", args[2])
+				}
+				nuevo = args[1].(string)
+			}
+			return pgconn.NewCommandTag("UPDATE 1"), nil
+		},
 	}
-	jsonBody, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(jsonBody))
+
+	h := NewAuthHandler(zap.NewNop(), mockdb)
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "mocktoken", nil }
+
+	jsonBody, _ := json.Marshal(map[string]string{"username": "usuario", "password": "clave-vieja"})
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonBody))
 	req.Header.Set("Content-Type", "application/json")
 	rec := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(rec)
 	ctx.Request = req
 
-	h.Register(ctx)
+	h.Login(ctx)
 
-	if rec.Code != http.StatusBadRequest {
-		t.Fatalf("Se esperaba status 400, obtuvo %d", rec.Code)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", rec.Code)
+	}
+	if !marcado || !strings.HasPrefix(nuevo, "$argon2id$") || !security.CheckPasswordHash("clave-vieja", nuevo) {
+		t.Errorf("Se esperaba recalcular el hash con argon2id (marcado=%v): %q", marcado, nuevo)
 	}
 }
 
-// ProtectedEndpoint tests
-func TestProtectedEndpointMissingToken(t *testing.T) {
+// fakeRefresh implementa RefreshTokens con un resultado fijo para Rotar
+type fakeRefresh struct {
+	rotado    services.RefreshToken
+	errRotar  error
+	revocadas []string
+}
+
+func (f *fakeRefresh) Emitir(ctx context.Context, usuarioID string) (services.RefreshToken, error) {
+	return services.RefreshToken{Token: "rt-" + usuarioID, UsuarioID: usuarioID, Familia: "f1", ExpiraEn: time.Now().Add(time.Hour)}, nil
+}
+func (f *fakeRefresh) Rotar(ctx context.Context, token string) (services.RefreshToken, error) {
+	return f.rotado, f.errRotar
+}
+func (f *fakeRefresh) Revocar(ctx context.Context, token, usuarioID string) error {
+	f.revocadas = append(f.revocadas, token)
+	return nil
+}
+func (f *fakeRefresh) RevocarFamilia(ctx context.Context, familia string) error {
+	f.revocadas = append(f.revocadas, familia)
+	return nil
+}
+
+func refreshRequest(h *AuthHandler) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(http.MethodPost, "/refresh", strings.NewReader(`{"refresh_token":"rt"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	ctx.Request = req
+	h.RefreshToken(ctx)
+	return rec
+}
+
+// TestLoginIssuesRefreshToken verifica que el login devuelve un refresh token
+func TestLoginIssuesRefreshToken(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	logger := zap.NewNop()
-	h := NewAuthHandler(logger, nil)
+	userID := uuid.New()
+	mockdb := &mockDB{
+		queryRowFunc: func(ctx context.Context, _sql string, args ...interface{}) pgx.Row {
+			return mockRow{scanFunc: func(dest ...interface{}) error {
+				setDest(dest, 0, userID)
+				setDest(dest, 4, 2)
+				setDest(dest, 6, true)
+				return nil
+			}}
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			return pgconn.NewCommandTag("UPDATE 1"), nil
+		},
+	}
+	h := NewAuthHandler(zap.NewNop(), mockdb)
+	h.SetRefresh(&fakeRefresh{}, nil)
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "mocktoken", nil }
+	h.verifyPassword = func(plain, hash string) bool { return true }
 
-	req, _ := http.NewRequest(http.MethodGet, "/protected", nil)
+	jsonBody, _ := json.Marshal(map[string]string{"username": "usuario", "password": "x"})
+	req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
 	rec := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(rec)
 	ctx.Request = req
+	h.Login(ctx)
 
-	h.ProtectedEndpoint(ctx)
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp["refresh_token"] != "rt-"+userID.String() || resp["refresh_expires"] == nil {
+		t.Errorf("Se esperaba el refresh token en la respuesta, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+}
 
-	if rec.Code != http.StatusUnauthorized {
-		t.Fatalf("Se esperaba status 401, obtuvo %d", rec.Code)
+// TestRefreshTokenRotates verifica que /refresh devuelve access y refresh token nuevos
+func TestRefreshTokenRotates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID := uuid.New()
+	var gotSQL string
+	mockdb := &mockDB{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		gotSQL = sql
+		return mockRow{scanFunc: func(dest ...interface{}) error {
+			setDest(dest, 0, 3)
+			return nil
+		}}
+	}}
+	refresh := &fakeRefresh{rotado: services.RefreshToken{Token: "rt-2", UsuarioID: userID.String(), Familia: "f1", ExpiraEn: time.Now().Add(time.Hour)}}
+	h := NewAuthHandler(zap.NewNop(), mockdb)
+	h.SetRefresh(refresh, nil)
+	var gotRol int
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { gotRol = rid; return "nuevo", nil }
+
+	rec := refreshRequest(h)
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp["access_token"] != "nuevo" || resp["refresh_token"] != "rt-2" || gotRol != 3 {
+		t.Fatalf("Se esperaban tokens nuevos, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(gotSQL, "activo = true") {
+		t.Errorf("Se esperaba exigir un usuario activo: %s", gotSQL)
+	}
+
+	// Usuario desactivado: se revoca la familia
+	mockdb.queryRowFunc = func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return mockRow{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+	}
+	if rec := refreshRequest(h); rec.Code != http.StatusUnauthorized || len(refresh.revocadas) != 1 {
+		t.Errorf("Se esperaba 401 y revocar la familia, obtuvo %d %v", rec.Code, refresh.revocadas)
 	}
 }
 
-func TestProtectedEndpointInvalidToken(t *testing.T) {
+// TestRefreshTokenReuseAudited verifica que reutilizar un token queda auditado como evento de seguridad
+func TestRefreshTokenReuseAudited(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	logger := zap.NewNop()
-	h := NewAuthHandler(logger, nil)
+	userID := uuid.New()
+	var accion interface{}
+	mockdb := &mockDB{execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+		if strings.Contains(sql, "INSERT INTO auditorias") {
+			accion = args[1]
+		}
+		return pgconn.NewCommandTag("INSERT 1"), nil
+	}}
+	refresh := &fakeRefresh{
+		rotado:   services.RefreshToken{UsuarioID: userID.String(), Familia: "f1"},
+		errRotar: services.ErrRefreshReutilizado,
+	}
+	h := NewAuthHandler(zap.NewNop(), mockdb)
+	h.SetRefresh(refresh, nil)
 
-	req, _ := http.NewRequest(http.MethodGet, "/protected", nil)
-	req.Header.Set("Authorization", "Bearer invalidtoken")
-	rec := httptest.NewRecorder()
-	ctx, _ := gin.CreateTestContext(rec)
-	ctx.Request = req
+	if rec := refreshRequest(h); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Se esperaba 401, obtuvo %d", rec.Code)
+	}
+	if accion != "refresh_reutilizado" {
+		t.Errorf("Se esperaba auditar la reutilización, obtuvo %v", accion)
+	}
 
-	h.ProtectedEndpoint(ctx)
+	h = NewAuthHandler(zap.NewNop(), mockdb)
+	if rec := refreshRequest(h); rec.Code != http.StatusNotImplemented {
+		t.Errorf("Se esperaba 501 sin refresh tokens, obtuvo %d", rec.Code)
+	}
+}
 
-	if rec.Code != http.StatusUnauthorized {
-		t.Fatalf("Se esperaba status 401, obtuvo %d", rec.Code)
+// fakeRevoker registra las revocaciones pedidas por los handlers de logout
+type fakeRevoker struct {
+	jtis     map[string]time.Time
+	usuarios []string
+}
+
+func (f *fakeRevoker) RevocarToken(ctx context.Context, jti string, expira time.Time) error {
+	if f.jtis == nil {
+		f.jtis = map[string]time.Time{}
 	}
+	f.jtis[jti] = expira
+	return nil
+}
+func (f *fakeRevoker) RevocarUsuario(ctx context.Context, usuarioID string, desde time.Time) error {
+	f.usuarios = append(f.usuarios, usuarioID)
+	return nil
 }
 
-func TestProtectedEndpointValidToken(t *testing.T) {
+// TestLogoutRevokesTokens verifica que /logout revoca el access token y la familia del refresh
+// token, y que /logout-all revoca todos los tokens del usuario
+func TestLogoutRevokesTokens(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	logger := zap.NewNop()
-	auth.Init(logger)
+	userID := uuid.New().String()
+	var acciones []interface{}
+	mockdb := &mockDB{execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+		if strings.Contains(sql, "INSERT INTO auditorias") {
+			acciones = append(acciones, args[1])
+		}
+		return pgconn.NewCommandTag("INSERT 1"), nil
+	}}
+	refresh := &fakeRefresh{}
+	revocador := &fakeRevoker{}
+	h := NewAuthHandler(zap.NewNop(), mockdb)
+	h.SetRefresh(refresh, revocador)
+	vence := time.Now().Add(time.Hour)
+
+	logout := func(body, jti string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/logout", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(rec)
+		ctx.Request = req
+		ctx.Set("user_id", userID)
+		ctx.Set("jti", jti)
+		ctx.Set("token_exp", vence)
+		handler(ctx)
+		return rec
+	}
 
-	h := NewAuthHandler(logger, nil)
+	if rec := logout(`{"refresh_token":"rt"}`, "jti-1", h.Logout); rec.Code != http.StatusOK {
+		t.Fatalf("Se esperaba 200, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	if !revocador.jtis["jti-1"].Equal(vence) || len(refresh.revocadas) != 1 || refresh.revocadas[0] != "rt" {
+		t.Errorf("Se esperaba revocar el token y su refresh: %v %v", revocador.jtis, refresh.revocadas)
+	}
+	if rec := logout("", "", h.Logout); rec.Code != http.StatusBadRequest {
+		t.Errorf("Se esperaba 400 para un token sin jti, obtuvo %d", rec.Code)
+	}
 
-	uid := uuid.New()
-	token, err := auth.GenerateToken(uid.String(), 2)
-	if err != nil {
-		t.Fatalf("No se pudo generar token en test: %v", err)
+	if rec := logout("", "jti-2", h.LogoutAll); rec.Code != http.StatusOK {
+		t.Fatalf("Se esperaba 200, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(revocador.usuarios) != 1 || revocador.usuarios[0] != userID {
+		t.Errorf("Se esperaba revocar al usuario, obtuvo %v", revocador.usuarios)
+	}
+	if len(acciones) != 2 || acciones[0] != "logout" || acciones[1] != "logout" {
+		t.Errorf("Se esperaba auditar ambos logouts, obtuvo %v", acciones)
 	}
 
-	req, _ := http.NewRequest(http.MethodGet, "/protected", nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-	rec := httptest.NewRecorder()
-	ctx, _ := gin.CreateTestContext(rec)
-	ctx.Request = req
+	h = NewAuthHandler(zap.NewNop(), mockdb)
+	if rec := logout("", "jti-3", h.Logout); rec.Code != http.StatusNotImplemented {
+		t.Errorf("Se esperaba 501 sin revocación configurada, obtuvo %d", rec.Code)
+	}
+}
 
-	h.ProtectedEndpoint(ctx)
+// fakeMFA implementa MFA con un único código válido
+type fakeMFA struct {
+	codigo    string
+	desafios  map[string]string
+	fallos    int
+	confirmar bool
+}
 
-	if rec.Code != http.StatusOK {
-		t.Fatalf("Se esperaba status 200, obtuvo %d", rec.Code)
+func (f *fakeMFA) Estado(ctx context.Context, usuarioID uuid.UUID) (services.EstadoMFA, error) {
+	return services.EstadoMFA{}, nil
+}
+func (f *fakeMFA) Iniciar(ctx context.Context, usuarioID uuid.UUID) (services.EnrolamientoMFA, error) {
+	return services.EnrolamientoMFA{Secreto: "S", URI: "otpauth://totp/x"}, nil
+}
+func (f *fakeMFA) Confirmar(ctx context.Context, usuarioID uuid.UUID, codigo string, entry audit.Entry) ([]string, error) {
+	if codigo != f.codigo {
+		return nil, services.ErrMFACodigoInvalido
+	}
+	f.confirmar = true
+	return []string{"aaaaa-bbbbb"}, nil
+}
+func (f *fakeMFA) Verificar(ctx context.Context, usuarioID uuid.UUID, codigo string) (string, error) {
+	if codigo != f.codigo {
+		return "", services.ErrMFACodigoInvalido
+	}
+	return services.MetodoMFATOTP, nil
+}
+func (f *fakeMFA) RegenerarRecuperacion(ctx context.Context, usuarioID uuid.UUID, codigo string, entry audit.Entry) ([]string, error) {
+	return nil, nil
+}
+func (f *fakeMFA) Desactivar(ctx context.Context, usuarioID uuid.UUID, codigo string, entry audit.Entry) error {
+	return nil
+}
+func (f *fakeMFA) CrearDesafio(ctx context.Context, usuarioID string) (services.DesafioMFA, error) {
+	f.desafios["d-"+usuarioID] = usuarioID
+	return services.DesafioMFA{Token: "d-" + usuarioID, ExpiraEn: time.Now().Add(time.Minute)}, nil
+}
+func (f *fakeMFA) ResolverDesafio(ctx context.Context, token string) (string, error) {
+	if u, ok := f.desafios[token]; ok {
+		return u, nil
 	}
+	return "", services.ErrDesafioMFAInvalido
+}
+func (f *fakeMFA) FalloDesafio(ctx context.Context, token string) error { f.fallos++; return nil }
+func (f *fakeMFA) ConsumirDesafio(ctx context.Context, token string) error {
+	delete(f.desafios, token)
+	return nil
+}
 
-	var resp map[string]interface{}
+// TestLoginMFAFlow verifica que con TOTP activo la contraseña solo entrega un desafío y el
+// token se obtiene en /login/mfa; un código incorrecto cuenta como intento fallido
+func TestLoginMFAFlow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID := uuid.New()
+	mfaRequerido, mfaActivo := false, true
+	var intentos []interface{}
+	mockdb := &mockDB{
+		queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+			return mockRow{scanFunc: func(dest ...interface{}) error {
+				setDest(dest, 0, userID)
+				setDest(dest, 4, 2)
+				setDest(dest, 6, true)
+				setDest(dest, 10, mfaRequerido)
+				setDest(dest, 11, mfaActivo)
+				return nil
+			}}
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "SET intentos_fallidos = $1") {
+				intentos = append(intentos, args[0])
+			}
+			return pgconn.NewCommandTag("UPDATE 1"), nil
+		},
+	}
+	mfa := &fakeMFA{codigo: "123456", desafios: map[string]string{}}
+	h := NewAuthHandler(zap.NewNop(), mockdb)
+	h.SetMFA(mfa)
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { return "mocktoken", nil }
+	h.verifyPassword = func(plain, hash string) bool { return true }
+
+	post := func(handler gin.HandlerFunc, body interface{}) map[string]interface{} {
+		jsonBody, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(rec)
+		ctx.Request = req
+		handler(ctx)
+		var resp map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		resp["_status"] = float64(rec.Code)
+		return resp
+	}
+
+	resp := post(h.Login, map[string]string{"username": "dra", "password": "x"})
+	if resp["token"] != nil || resp["mfa_token"] != "d-"+userID.String() || resp["mfa_enrolado"] != true {
+		t.Fatalf("Se esperaba solo el desafío de dos pasos: %v", resp)
+	}
+	token := resp["mfa_token"]
+
+	resp = post(h.LoginMFA, map[string]interface{}{"mfa_token": token, "codigo": "000000"})
+	if resp["_status"] != float64(http.StatusUnauthorized) || mfa.fallos != 1 || len(intentos) != 1 || intentos[0] != 1 {
+		t.Errorf("Se esperaba 401 y un intento fallido: %v %v", resp, intentos)
+	}
+
+	resp = post(h.LoginMFA, map[string]interface{}{"mfa_token": token, "codigo": "123456"})
+	if resp["_status"] != float64(http.StatusOK) || resp["token"] != "mocktoken" {
+		t.Fatalf("Se esperaba el token tras el segundo paso: %v", resp)
+	}
+	if resp = post(h.LoginMFA, map[string]interface{}{"mfa_token": token, "codigo": "123456"}); resp["_status"] != float64(http.StatusUnauthorized) {
+		t.Errorf("El desafío no debería servir dos veces: %v", resp)
+	}
+
+	// Rol que requiere dos pasos sin enrolamiento: el primer código confirma el enrolamiento
+	mfaRequerido, mfaActivo = true, false
+	resp = post(h.Login, map[string]string{"username": "dra", "password": "x"})
+	token = resp["mfa_token"]
+	if resp["mfa_enrolado"] != false {
+		t.Fatalf("Se esperaba mfa_enrolado false: %v", resp)
+	}
+	if resp = post(h.EnrolarMFALogin, map[string]interface{}{"mfa_token": token}); resp["uri"] == nil {
+		t.Errorf("Se esperaba la URI de enrolamiento: %v", resp)
+	}
+	resp = post(h.LoginMFA, map[string]interface{}{"mfa_token": token, "codigo": "123456"})
+	if resp["token"] != "mocktoken" || !mfa.confirmar || resp["codigos_recuperacion"] == nil {
+		t.Errorf("Se esperaba confirmar el enrolamiento y devolver los códigos: %v", resp)
+	}
+
+	// Sin servicio de dos pasos el login de un usuario que lo requiere no se completa
+	h = NewAuthHandler(zap.NewNop(), mockdb)
+	h.verifyPassword = func(plain, hash string) bool { return true }
+	if resp = post(h.Login, map[string]string{"username": "dra", "password": "x"}); resp["_status"] != float64(http.StatusServiceUnavailable) {
+		t.Errorf("Se esperaba 503 sin servicio de dos pasos: %v", resp)
+	}
+}
+
+// fakeCuenta acepta un único token de restablecimiento
+type fakeCuenta struct {
+	usuarioID   uuid.UUID
+	token       string
+	solicitados []string
+}
+
+func (f *fakeCuenta) SolicitarRestablecimiento(ctx context.Context, email string) error {
+	f.solicitados = append(f.solicitados, email)
+	return nil
+}
+func (f *fakeCuenta) Restablecer(ctx context.Context, token, password string, entry audit.Entry) (uuid.UUID, error) {
+	if token != f.token {
+		return uuid.Nil, services.ErrTokenCuentaInvalido
+	}
+	if password == "usuario" {
+		return uuid.Nil, &security.ErrPolitica{Motivos: []string{"No puede contener el nombre de usuario"}}
+	}
+	return f.usuarioID, nil
+}
+func (f *fakeCuenta) EnviarVerificacion(ctx context.Context, usuarioID uuid.UUID) error { return nil }
+func (f *fakeCuenta) VerificarEmail(ctx context.Context, token string, entry audit.Entry) (uuid.UUID, error) {
+	return uuid.Nil, services.ErrTokenCuentaInvalido
+}
+func (f *fakeCuenta) Activar(ctx context.Context, token, password string, entry audit.Entry) (uuid.UUID, error) {
+	return f.Restablecer(ctx, token, password, entry)
+}
+
+// TestRestablecerContrasena verifica que el pedido no revela si el email existe y que
+// restablecer revoca las sesiones del usuario
+func TestRestablecerContrasena(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cuenta := &fakeCuenta{usuarioID: uuid.New(), token: "tok"}
+	revocador := &fakeRevoker{}
+	h := NewAuthHandler(zap.NewNop(), &mockDB{})
+	h.SetRefresh(nil, revocador)
+	h.SetCuenta(cuenta)
+
+	post := func(handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(rec)
+		ctx.Request, _ = http.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		ctx.Request.Header.Set("Content-Type", "application/json")
+		handler(ctx)
+		return rec
+	}
+
+	if rec := post(h.SolicitarRestablecimiento, `{"email":"nadie@example.com"}`); rec.Code != http.StatusAccepted || len(cuenta.solicitados) != 1 {
+		t.Errorf("Se esperaba 202 para cualquier email, obtuvo %d", rec.Code)
+	}
+	if rec := post(h.RestablecerContrasena, `{"token":"otro","password":"nueva-clave"}`); rec.Code != http.StatusBadRequest || len(revocador.usuarios) != 0 {
+		t.Errorf("Se esperaba 400 con un token inválido, obtuvo %d", rec.Code)
+	}
+	if rec := post(h.RestablecerContrasena, `{"token":"tok","password":"usuario"}`); rec.Code != http.StatusBadRequest ||
+		!strings.Contains(rec.Body.String(), "nombre de usuario") || len(revocador.usuarios) != 0 {
+		t.Errorf("Se esperaba 400 con los motivos de la política, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := post(h.RestablecerContrasena, `{"token":"tok","password":"nueva-clave"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Se esperaba 200, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(revocador.usuarios) != 1 || revocador.usuarios[0] != cuenta.usuarioID.String() {
+		t.Errorf("Se esperaba revocar las sesiones del usuario: %v", revocador.usuarios)
+	}
+
+	// Sin correo configurado los endpoints no están disponibles
+	h = NewAuthHandler(zap.NewNop(), &mockDB{})
+	if rec := post(h.SolicitarRestablecimiento, `{"email":"dra@example.com"}`); rec.Code != http.StatusNotImplemented {
+		t.Errorf("Se esperaba 501 sin correo, obtuvo %d", rec.Code)
+	}
+}
+
+// fakeSesiones es un registro de sesiones en memoria
+type fakeSesiones struct {
+	sesiones map[string]services.Sesion
+}
+
+func (f *fakeSesiones) Registrar(ctx context.Context, id, usuarioID, dispositivo, ip string) error {
+	if f.sesiones == nil {
+		f.sesiones = map[string]services.Sesion{}
+	}
+	f.sesiones[id] = services.Sesion{ID: id, UsuarioID: usuarioID, Dispositivo: dispositivo, IP: ip}
+	return nil
+}
+func (f *fakeSesiones) Listar(ctx context.Context, usuarioID string) ([]services.Sesion, error) {
+	var lista []services.Sesion
+	for _, s := range f.sesiones {
+		if s.UsuarioID == usuarioID {
+			lista = append(lista, s)
+		}
+	}
+	return lista, nil
+}
+func (f *fakeSesiones) Cerrar(ctx context.Context, usuarioID, id string) (services.Sesion, error) {
+	s, ok := f.sesiones[id]
+	if !ok || s.UsuarioID != usuarioID {
+		return services.Sesion{}, services.ErrSesionInexistente
+	}
+	delete(f.sesiones, id)
+	return s, nil
+}
+
+// TestSesionesPropias verifica que /refresh registra la sesión y la pone en el token, y que
+// el usuario ve sus sesiones y cierra solo las propias
+func TestSesionesPropias(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	userID := uuid.New()
+	var acciones []interface{}
+	mockdb := &mockDB{
+		queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+			return mockRow{scanFunc: func(dest ...interface{}) error { setDest(dest, 0, 3); return nil }}
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "INSERT INTO auditorias") {
+				acciones = append(acciones, args[1])
+			}
+			return pgconn.NewCommandTag("INSERT 1"), nil
+		},
+	}
+	refresh := &fakeRefresh{rotado: services.RefreshToken{Token: "rt-2", UsuarioID: userID.String(), Familia: "f1", ExpiraEn: time.Now().Add(time.Hour)}}
+	sesiones := &fakeSesiones{}
+	sesiones.Registrar(context.Background(), "f2", userID.String(), "Safari", "10.0.0.2")
+	sesiones.Registrar(context.Background(), "f3", uuid.NewString(), "Chrome", "10.0.0.3")
+	h := NewAuthHandler(zap.NewNop(), mockdb)
+	h.SetRefresh(refresh, nil)
+	h.SetSesiones(sesiones)
+	var gotSid string
+	h.generateToken = func(uid string, rid int, cid, sid string) (string, error) { gotSid = sid; return "nuevo", nil }
+
+	if rec := refreshRequest(h); rec.Code != http.StatusOK || gotSid != "f1" || sesiones.sesiones["f1"].UsuarioID != userID.String() {
+		t.Fatalf("Se esperaba registrar la sesión f1 y emitir el token con ella, obtuvo %d sid=%q", rec.Code, gotSid)
+	}
+
+	pedido := func(method, id string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(rec)
+		ctx.Request, _ = http.NewRequest(method, "/api/v1/me/sessions/"+id, nil)
+		ctx.Params = gin.Params{{Key: "id", Value: id}}
+		ctx.Set("user_id", userID.String())
+		ctx.Set("sid", "f1")
+		handler(ctx)
+		return rec
+	}
+
+	rec := pedido(http.MethodGet, "", h.GetSesiones)
+	var resp struct {
+		Sesiones []SesionVista `json:"sesiones"`
+	}
 	json.Unmarshal(rec.Body.Bytes(), &resp)
-	if resp["user_id"] != uid.String() {
-		t.Errorf("user_id esperado %s, obtenido %v", uid.String(), resp["user_id"])
+	if rec.Code != http.StatusOK || len(resp.Sesiones) != 2 {
+		t.Fatalf("Se esperaban las dos sesiones del usuario, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, s := range resp.Sesiones {
+		if s.Actual != (s.ID == "f1") {
+			t.Errorf("Se esperaba marcar solo la sesión del token como actual: %+v", s)
+		}
+	}
+
+	if rec := pedido(http.MethodDelete, "f3", h.CerrarSesion); rec.Code != http.StatusNotFound {
+		t.Errorf("Se esperaba 404 para la sesión de otro usuario, obtuvo %d", rec.Code)
 	}
-}
\ No newline at end of file
+	if rec := pedido(http.MethodDelete, "f2", h.CerrarSesion); rec.Code != http.StatusOK {
+		t.Fatalf("Se esperaba 200, obtuvo %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, ok := sesiones.sesiones["f2"]; ok || len(acciones) != 1 || acciones[0] != "cerrar_sesion" {
+		t.Errorf("Se esperaba cerrar la sesión y auditarlo, obtuvo %v", acciones)
+	}
+
+	h = NewAuthHandler(zap.NewNop(), mockdb)
+	h.SetRefresh(refresh, nil)
+	if rec := pedido(http.MethodGet, "", h.GetSesiones); rec.Code != http.StatusNotImplemented {
+		t.Errorf("Se esperaba 501 sin registro de sesiones, obtuvo %d", rec.Code)
+	}
+}
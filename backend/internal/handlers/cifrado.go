@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Recifrador recifra en segundo plano los datos cifrados con versiones anteriores de la clave maestra
+type Recifrador interface {
+	Estado(ctx context.Context) (services.EstadoRecifrado, error)
+	IniciarEnSegundoPlano(ctx context.Context) error
+}
+
+// CifradoHandler expone el estado de la rotación de la clave maestra de datos personales
+type CifradoHandler struct {
+	recifrador Recifrador
+	// trabajos es el contexto de vida del servidor: el recifrado sigue después de responder
+	// y se detiene (reanudable) al apagarse el servidor
+	trabajos context.Context
+	logger   *zap.Logger
+}
+
+// NewCifradoHandler crea el handler. Si recifrador es nil los endpoints responden 503.
+func NewCifradoHandler(recifrador Recifrador, trabajos context.Context, logger *zap.Logger) *CifradoHandler {
+	return &CifradoHandler{
+		recifrador: recifrador,
+		trabajos:   trabajos,
+		logger:     logger,
+	}
+}
+
+func (h *CifradoHandler) disponible(c *gin.Context) bool {
+	if h.recifrador == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "El cifrado de datos personales no está configurado en el servidor"})
+		return false
+	}
+	return true
+}
+
+// GetEstadoCifrado godoc
+// @Summary      Estado del recifrado
+// @Description  Cuenta las filas de datos_personales cifradas con cada versión de la clave maestra y muestra el progreso del último recifrado
+// @Tags         cifrado
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /api/v1/cifrado/estado [get]
+func (h *CifradoHandler) GetEstadoCifrado(c *gin.Context) {
+	if !h.disponible(c) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	estado, err := h.recifrador.Estado(ctx)
+	if err != nil {
+		h.logger.Error("Error al obtener el estado del recifrado", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"estado": estado,
+	})
+}
+
+// Recifrar godoc
+// @Summary      Recifrar datos personales
+// @Description  Inicia en segundo plano el recifrado con la versión vigente de la clave maestra, o reanuda el que quedó en curso. El progreso se consulta en /cifrado/estado.
+// @Tags         cifrado
+// @Produce      json
+// @Success      202  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /api/v1/cifrado/recifrar [post]
+func (h *CifradoHandler) Recifrar(c *gin.Context) {
+	if !h.disponible(c) {
+		return
+	}
+	err := h.recifrador.IniciarEnSegundoPlano(h.trabajos)
+	if errors.Is(err, services.ErrRecifradoEnCurso) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ya hay un recifrado en curso"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al iniciar el recifrado", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	h.logger.Info("Recifrado iniciado", zap.String("usuario_id", c.GetString("user_id")))
+	c.JSON(http.StatusAccepted, gin.H{"message": "Recifrado iniciado"})
+}
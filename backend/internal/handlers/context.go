@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// usuarioActual devuelve el ID del usuario autenticado que JWTAuthMiddleware guarda en el contexto
+func usuarioActual(c *gin.Context) (uuid.UUID, bool) {
+	value, exists := c.Get("user_id")
+	if !exists {
+		return uuid.Nil, false
+	}
+	raw, ok := value.(string)
+	if !ok {
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// rolActual devuelve el rol del usuario autenticado que JWTAuthMiddleware guarda en el contexto
+func rolActual(c *gin.Context) (int, bool) {
+	value, exists := c.Get("role")
+	if !exists {
+		return 0, false
+	}
+	rolID, ok := value.(int)
+	return rolID, ok
+}
+
+// alcanceActual devuelve el consultorio sobre el que opera el request, que guarda
+// TenantScope. Si no hay alcance responde 403: ningún handler consulta sin filtrar.
+func alcanceActual(c *gin.Context) (tenant.Scope, bool) {
+	scope, ok := tenant.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Consultorio no determinado"})
+		return tenant.Scope{}, false
+	}
+	return scope, true
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Cuenta emite y canjea los tokens de restablecimiento de contraseña, verificación de email
+// e invitación (services.CuentaService)
+type Cuenta interface {
+	SolicitarRestablecimiento(ctx context.Context, email string) error
+	Restablecer(ctx context.Context, token, password string, entry audit.Entry) (uuid.UUID, error)
+	EnviarVerificacion(ctx context.Context, usuarioID uuid.UUID) error
+	VerificarEmail(ctx context.Context, token string, entry audit.Entry) (uuid.UUID, error)
+	Activar(ctx context.Context, token, password string, entry audit.Entry) (uuid.UUID, error)
+}
+
+// cuentaDisponible responde 501 si la instancia no tiene correo configurado
+func (h *AuthHandler) cuentaDisponible(c *gin.Context) bool {
+	if h.cuenta == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "El envío de correos no está configurado en esta instancia"})
+		return false
+	}
+	return true
+}
+
+// politicaIncumplida responde 400 con los motivos si err es un rechazo de la política de
+// contraseñas
+func politicaIncumplida(c *gin.Context, err error) bool {
+	var politica *security.ErrPolitica
+	if !errors.As(err, &politica) {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "La contraseña no cumple la política", "motivos": politica.Motivos})
+	return true
+}
+
+// SolicitarRestablecimiento godoc
+// @Summary      Pedir restablecimiento de contraseña
+// @Description  Envía por correo un enlace de un solo uso para elegir una contraseña nueva. Responde igual exista o no el email.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        req  body  object  true  "email"
+// @Success      202  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /password/olvido [post]
+func (h *AuthHandler) SolicitarRestablecimiento(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.cuentaDisponible(c) {
+		return
+	}
+	if err := h.cuenta.SolicitarRestablecimiento(c.Request.Context(), req.Email); err != nil {
+		h.logger.Error("Error al solicitar restablecimiento de contraseña", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Si el email corresponde a un usuario activo, recibirá un enlace para restablecer la contraseña",
+	})
+}
+
+// RestablecerContrasena godoc
+// @Summary      Restablecer contraseña
+// @Description  Canjea el token recibido por correo por una contraseña nueva. Cierra todas las sesiones del usuario.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        req  body  object  true  "token y password"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /password/restablecer [post]
+func (h *AuthHandler) RestablecerContrasena(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.cuentaDisponible(c) {
+		return
+	}
+	ctx := c.Request.Context()
+	entry := audit.FromRequest(c, audit.AccionRestablecerContrasena, "usuarios", "")
+	usuarioID, err := h.cuenta.Restablecer(ctx, req.Token, req.Password, entry)
+	if errors.Is(err, services.ErrTokenCuentaInvalido) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El enlace es inválido o venció, solicite uno nuevo"})
+		return
+	} else if politicaIncumplida(c, err) {
+		return
+	} else if err != nil {
+		h.logger.Error("Error al restablecer contraseña", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	// El trigger de usuarios también avisa por usuarios_revocados; revocar acá hace que las
+	// sesiones se cierren aunque la instancia no esté escuchando
+	if h.revocador != nil {
+		if err := h.revocador.RevocarUsuario(ctx, usuarioID.String(), time.Now()); err != nil {
+			h.logger.Error("Error al revocar sesiones tras restablecer contraseña",
+				zap.Error(err), zap.String("user_id", usuarioID.String()))
+		}
+	}
+	h.logger.Info("Contraseña restablecida", zap.String("user_id", usuarioID.String()))
+	c.JSON(http.StatusOK, gin.H{"message": "Contraseña actualizada, inicie sesión nuevamente"})
+}
+
+// ActivarCuenta godoc
+// @Summary      Activar cuenta invitada
+// @Description  Canjea el token de la invitación recibida por correo por la contraseña elegida. También verifica el email.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        req  body  object  true  "token y password"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /invitacion/aceptar [post]
+func (h *AuthHandler) ActivarCuenta(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.cuentaDisponible(c) {
+		return
+	}
+	entry := audit.FromRequest(c, audit.AccionActivarCuenta, "usuarios", "")
+	usuarioID, err := h.cuenta.Activar(c.Request.Context(), req.Token, req.Password, entry)
+	if errors.Is(err, services.ErrTokenCuentaInvalido) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La invitación es inválida o venció, pida una nueva al administrador"})
+		return
+	} else if politicaIncumplida(c, err) {
+		return
+	} else if err != nil {
+		h.logger.Error("Error al activar cuenta", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	h.logger.Info("Cuenta activada", zap.String("user_id", usuarioID.String()))
+	c.JSON(http.StatusOK, gin.H{"message": "Cuenta activada, ya puede iniciar sesión"})
+}
+
+// VerificarEmail godoc
+// @Summary      Verificar email
+// @Description  Canjea el token de verificación recibido por correo
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        req  body  object  true  "token"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /email/verificar [post]
+func (h *AuthHandler) VerificarEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.cuentaDisponible(c) {
+		return
+	}
+	entry := audit.FromRequest(c, audit.AccionEmailVerificado, "usuarios", "")
+	if _, err := h.cuenta.VerificarEmail(c.Request.Context(), req.Token, entry); errors.Is(err, services.ErrTokenCuentaInvalido) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El enlace es inválido o venció, solicite uno nuevo"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al verificar email", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verificado"})
+}
+
+// ReenviarVerificacion godoc
+// @Summary      Reenviar verificación de email
+// @Description  Envía al usuario autenticado un enlace nuevo para verificar su email
+// @Tags         auth
+// @Produce      json
+// @Success      202  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      429  {object}  map[string]interface{}
+// @Router       /api/v1/me/email/verificacion [post]
+func (h *AuthHandler) ReenviarVerificacion(c *gin.Context) {
+	if !h.cuentaDisponible(c) {
+		return
+	}
+	usuarioID, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	switch err := h.cuenta.EnviarVerificacion(c.Request.Context(), usuarioID); {
+	case errors.Is(err, services.ErrEmailYaVerificado):
+		c.JSON(http.StatusConflict, gin.H{"error": "El email ya está verificado"})
+	case errors.Is(err, services.ErrDemasiadasSolicitudes):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Demasiadas solicitudes, intente más tarde"})
+	case err != nil:
+		h.logger.Error("Error al enviar verificación de email", zap.Error(err), zap.String("user_id", usuarioID.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "Se envió un enlace de verificación a su email"})
+	}
+}
@@ -0,0 +1,493 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Columnas cifradas de datos_personales; forman parte del aad de cada valor
+const (
+	tablaDatosPersonales = "datos_personales"
+	campoDNI             = "dni"
+	campoTelefono        = "telefono"
+)
+
+// DatosPersonalesHandler administra los datos personales de un paciente. DNI y teléfono
+// se guardan cifrados; el DNI además tiene un índice ciego para buscarlo por igualdad.
+type DatosPersonalesHandler struct {
+	pool   TxPool
+	cipher *security.FieldCipher
+	logger *zap.Logger
+}
+
+// NewDatosPersonalesHandler crea el handler. Si cipher es nil los endpoints responden 503.
+func NewDatosPersonalesHandler(pool TxPool, cipher *security.FieldCipher, logger *zap.Logger) *DatosPersonalesHandler {
+	return &DatosPersonalesHandler{
+		pool:   pool,
+		cipher: cipher,
+		logger: logger,
+	}
+}
+
+// DatosPersonalesInput reemplaza los datos personales del paciente; los campos omitidos quedan vacíos
+type DatosPersonalesInput struct {
+	DNI       *string `json:"dni"`
+	Telefono  *string `json:"telefono"`
+	Direccion *string `json:"direccion"`
+}
+
+// DatosPersonales son los datos personales descifrados
+type DatosPersonales struct {
+	ID            string    `json:"id"`
+	PacienteID    string    `json:"paciente_id"`
+	DNI           *string   `json:"dni,omitempty"`
+	Telefono      *string   `json:"telefono,omitempty"`
+	Direccion     *string   `json:"direccion,omitempty"`
+	ActualizadoEn time.Time `json:"actualizado_en"`
+}
+
+// BusquedaDNIInput es el DNI a buscar; va en el body para que no quede en logs de URLs
+type BusquedaDNIInput struct {
+	DNI string `json:"dni" binding:"required"`
+}
+
+// NormalizarDNI deja solo los dígitos ("20.123.456" -> "20123456")
+func NormalizarDNI(dni string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, dni)
+}
+
+func dniValido(dni string) bool {
+	return len(dni) >= 6 && len(dni) <= 10
+}
+
+// NuevoDescifradorDNI adapta el cifrador de datos personales a la detección de duplicados
+func NuevoDescifradorDNI(cipher *security.FieldCipher) DescifradorDNI {
+	return descifradorDNI{cipher: cipher}
+}
+
+type descifradorDNI struct {
+	cipher *security.FieldCipher
+}
+
+func (d descifradorDNI) DescifrarDNI(ctx context.Context, datosPersonalesID string, cifrado []byte) (string, error) {
+	dni, err := d.cipher.Decrypt(ctx, tablaDatosPersonales, datosPersonalesID, campoDNI, cifrado)
+	return string(dni), err
+}
+
+func (d descifradorDNI) IndiceDNI(dni string) []byte {
+	return d.cipher.Index(campoDNI, dni)
+}
+
+// cifradoDisponible responde 503 si el servidor no tiene configurada la clave maestra
+func (h *DatosPersonalesHandler) cifradoDisponible(c *gin.Context) bool {
+	if h.cipher == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "El cifrado de datos personales no está configurado en el servidor"})
+		return false
+	}
+	return true
+}
+
+func (h *DatosPersonalesHandler) cifrar(ctx context.Context, id, campo string, valor *string) ([]byte, error) {
+	if valor == nil {
+		return nil, nil
+	}
+	return h.cipher.Encrypt(ctx, tablaDatosPersonales, id, campo, []byte(*valor))
+}
+
+func (h *DatosPersonalesHandler) descifrar(ctx context.Context, id, campo string, cifrado []byte) (*string, error) {
+	if cifrado == nil {
+		return nil, nil
+	}
+	valor, err := h.cipher.Decrypt(ctx, tablaDatosPersonales, id, campo, cifrado)
+	if err != nil {
+		return nil, err
+	}
+	s := string(valor)
+	return &s, nil
+}
+
+// GetDatosPersonales godoc
+// @Summary      Obtener datos personales
+// @Description  Devuelve DNI, teléfono y dirección del paciente, descifrados. La lectura queda registrada.
+// @Tags         pacientes
+// @Produce      json
+// @Param        id   path      string  true  "ID del paciente"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/datos-personales [get]
+func (h *DatosPersonalesHandler) GetDatosPersonales(c *gin.Context) {
+	if !h.cifradoDisponible(c) {
+		return
+	}
+	pacienteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		id                 uuid.UUID
+		dniCifrado, telCif []byte
+		datos              = DatosPersonales{PacienteID: pacienteID.String()}
+	)
+	args := []interface{}{pacienteID}
+	err = h.pool.QueryRow(ctx, `
+		SELECT id, dni_encriptado, telefono_encriptado, direccion, actualizado_en
+		FROM datos_personales
+		WHERE paciente_id = $1 AND `+scope.Paciente("paciente_id", &args), args...).
+		Scan(&id, &dniCifrado, &telCif, &datos.Direccion, &datos.ActualizadoEn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "El paciente no tiene datos personales"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al obtener datos personales", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	datos.ID = id.String()
+	datos.DNI, err = h.descifrar(ctx, datos.ID, campoDNI, dniCifrado)
+	if err == nil {
+		datos.Telefono, err = h.descifrar(ctx, datos.ID, campoTelefono, telCif)
+	}
+	if err != nil {
+		h.logger.Error("Error al descifrar datos personales", zap.String("datos_personales_id", datos.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron descifrar los datos personales"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":           "success",
+		"datos_personales": datos,
+	})
+}
+
+// PutDatosPersonales godoc
+// @Summary      Guardar datos personales
+// @Description  Crea o reemplaza los datos personales del paciente. DNI y teléfono se cifran antes de guardarse.
+// @Tags         pacientes
+// @Accept       json
+// @Produce      json
+// @Param        id     path  string                true  "ID del paciente"
+// @Param        datos  body  DatosPersonalesInput  true  "Datos personales"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/datos-personales [put]
+func (h *DatosPersonalesHandler) PutDatosPersonales(c *gin.Context) {
+	if !h.cifradoDisponible(c) {
+		return
+	}
+	pacienteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return
+	}
+	var input DatosPersonalesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var indiceDNI []byte
+	if input.DNI != nil {
+		dni := NormalizarDNI(*input.DNI)
+		if !dniValido(dni) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dni inválido, se esperan entre 6 y 10 dígitos"})
+			return
+		}
+		input.DNI = &dni
+		indiceDNI = h.cipher.Index(campoDNI, dni)
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron guardar los datos personales"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Bloquear al paciente serializa las escrituras: el id de la fila forma parte del
+	// aad, así que dos altas concurrentes no pueden pisarse con ids distintos
+	if !h.bloquearPaciente(c, ctx, tx, scope, pacienteID) {
+		return
+	}
+
+	var id uuid.UUID
+	accion := audit.AccionActualizar
+	err = tx.QueryRow(ctx, `SELECT id FROM datos_personales WHERE paciente_id = $1`, pacienteID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		id, accion, err = uuid.New(), audit.AccionCrear, nil
+	}
+	if err != nil {
+		h.logger.Error("Error al leer datos personales", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron guardar los datos personales"})
+		return
+	}
+	var antes interface{}
+	if accion == audit.AccionActualizar {
+		if antes, err = audit.Snapshot(ctx, tx, tablaDatosPersonales, id, false); err != nil {
+			h.logger.Error("Error al leer datos personales", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron guardar los datos personales"})
+			return
+		}
+	}
+
+	dniCifrado, err := h.cifrar(ctx, id.String(), campoDNI, input.DNI)
+	var telCifrado []byte
+	if err == nil {
+		telCifrado, err = h.cifrar(ctx, id.String(), campoTelefono, input.Telefono)
+	}
+	if err != nil {
+		h.logger.Error("Error al cifrar datos personales", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron guardar los datos personales"})
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO datos_personales (id, paciente_id, dni_encriptado, dni_indice, telefono_encriptado, direccion, clave_version, actualizado_en)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (id) DO UPDATE SET
+			dni_encriptado = EXCLUDED.dni_encriptado,
+			dni_indice = EXCLUDED.dni_indice,
+			telefono_encriptado = EXCLUDED.telefono_encriptado,
+			direccion = EXCLUDED.direccion,
+			clave_version = EXCLUDED.clave_version,
+			actualizado_en = EXCLUDED.actualizado_en
+	`, id, pacienteID, dniCifrado, indiceDNI, telCifrado, input.Direccion, claveVersion(dniCifrado, telCifrado)); err != nil {
+		h.logger.Error("Error al guardar datos personales", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron guardar los datos personales"})
+		return
+	}
+
+	despues, err := audit.Snapshot(ctx, tx, tablaDatosPersonales, id, false)
+	if err == nil {
+		entry := audit.FromRequest(c, accion, tablaDatosPersonales, id.String())
+		entry.Antes = antes
+		entry.Despues = despues
+		err = audit.Write(ctx, tx, entry)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al auditar datos personales", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron guardar los datos personales"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Datos personales guardados exitosamente",
+		"id":      id,
+	})
+}
+
+// DeleteDatosPersonales godoc
+// @Summary      Eliminar datos personales
+// @Description  Elimina los datos personales del paciente
+// @Tags         pacientes
+// @Produce      json
+// @Param        id   path      string  true  "ID del paciente"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/datos-personales [delete]
+func (h *DatosPersonalesHandler) DeleteDatosPersonales(c *gin.Context) {
+	pacienteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron eliminar los datos personales"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if !h.bloquearPaciente(c, ctx, tx, scope, pacienteID) {
+		return
+	}
+
+	var id uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT id FROM datos_personales WHERE paciente_id = $1`, pacienteID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "El paciente no tiene datos personales"})
+		return
+	}
+	var antes interface{}
+	if err == nil {
+		antes, err = audit.Snapshot(ctx, tx, tablaDatosPersonales, id, false)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM datos_personales WHERE id = $1`, id)
+	}
+	if err == nil {
+		entry := audit.FromRequest(c, audit.AccionEliminar, tablaDatosPersonales, id.String())
+		entry.Antes = antes
+		err = audit.Write(ctx, tx, entry)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al eliminar datos personales", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudieron eliminar los datos personales"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Datos personales eliminados exitosamente"})
+}
+
+// claveVersion es la versión de la clave maestra de los valores cifrados, o nil si no hay
+// ninguno. Los dos se cifran juntos, así que comparten versión.
+func claveVersion(cifrados ...[]byte) *int64 {
+	for _, c := range cifrados {
+		if v, err := security.KeyVersion(c); err == nil {
+			version := int64(v)
+			return &version
+		}
+	}
+	return nil
+}
+
+// bloquearPaciente bloquea al paciente dentro del alcance o responde 404
+func (h *DatosPersonalesHandler) bloquearPaciente(c *gin.Context, ctx context.Context, tx pgx.Tx, scope tenant.Scope, pacienteID uuid.UUID) bool {
+	args := []interface{}{pacienteID}
+	var id uuid.UUID
+	err := tx.QueryRow(ctx, `SELECT id FROM pacientes WHERE id = $1 AND `+scope.Consultorio("consultorio_id", &args)+` FOR UPDATE`, args...).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Paciente no encontrado"})
+		return false
+	}
+	if err != nil {
+		h.logger.Error("Error al bloquear paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return false
+	}
+	return true
+}
+
+// BuscarPorDNI godoc
+// @Summary      Buscar pacientes por DNI
+// @Description  Busca por DNI exacto usando el índice ciego, sin descifrar la tabla. El DNI va en el body para que no quede en los logs de URLs.
+// @Tags         pacientes
+// @Accept       json
+// @Produce      json
+// @Param        datos  body  BusquedaDNIInput  true  "DNI a buscar"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/buscar-dni [post]
+func (h *DatosPersonalesHandler) BuscarPorDNI(c *gin.Context) {
+	if !h.cifradoDisponible(c) {
+		return
+	}
+	var input BusquedaDNIInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	dni := NormalizarDNI(input.DNI)
+	if !dniValido(dni) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dni inválido, se esperan entre 6 y 10 dígitos"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []interface{}{h.cipher.Index(campoDNI, dni)}
+	rows, err := h.pool.Query(ctx, `
+	       SELECT 
+		       p.id, p.nombre, p.apellido, p.fecha_nacimiento, p.nro_credencial, p.obra_social, p.condicion_iva, p.plan, p.creado_por_usuario, p.consultorio_id, p.creado_en
+	       FROM pacientes p
+	       JOIN datos_personales d ON d.paciente_id = p.id
+	       WHERE d.dni_indice = $1 AND `+scope.Consultorio("p.consultorio_id", &args)+`
+	       ORDER BY p.apellido, p.nombre, p.id`, args...)
+	if err != nil {
+		h.logger.Error("Error al buscar paciente por DNI", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	pacientes := make([]Paciente, 0)
+	for rows.Next() {
+		var (
+			id, creadoPorUsuario, consultorioID           [16]byte
+			nombre, apellido                              string
+			fechaNacimiento, creadoEn                     time.Time
+			nroCredencial, obraSocial, condicionIVA, plan *string
+		)
+		if err := rows.Scan(
+			&id, &nombre, &apellido, &fechaNacimiento, &nroCredencial, &obraSocial, &condicionIVA, &plan, &creadoPorUsuario, &consultorioID, &creadoEn,
+		); err != nil {
+			h.logger.Error("Error al escanear paciente", zap.Error(err))
+			continue
+		}
+		pacientes = append(pacientes, Paciente{
+			ID:               uuid.UUID(id).String(),
+			Nombre:           nombre,
+			Apellido:         apellido,
+			FechaNacimiento:  fechaNacimiento.Format("2006-01-02"),
+			NroCredencial:    nroCredencial,
+			ObraSocial:       obraSocial,
+			CondicionIVA:     condicionIVA,
+			Plan:             plan,
+			CreadoPorUsuario: ptrString(uuid.UUID(creadoPorUsuario).String()),
+			ConsultorioID:    ptrString(uuid.UUID(consultorioID).String()),
+			CreadoEn:         creadoEn.Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"pacientes": pacientes,
+		"total":     len(pacientes),
+	})
+}
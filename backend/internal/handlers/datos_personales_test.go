@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+func testCipher(t *testing.T) *security.FieldCipher {
+	t.Helper()
+	key, indexKey := make([]byte, 32), make([]byte, 32)
+	rand.Read(key)
+	rand.Read(indexKey)
+	kek, _ := security.NewLocalKEK(1, key)
+	cipher, err := security.NewFieldCipher(kek, indexKey)
+	if err != nil {
+		t.Fatalf("Error al crear el cifrador: %v", err)
+	}
+	return cipher
+}
+
+func TestPutDatosPersonales_CifraYAudita(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cipher := testCipher(t)
+	pacienteID := uuid.New()
+	var insertArgs []interface{}
+	var accion string
+	tx := &mockTx{
+		queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				switch {
+				case strings.Contains(sql, "FROM pacientes"):
+					if !strings.Contains(sql, "FOR UPDATE") || args[1] != consultorioTest {
+						t.Errorf("Se esperaba bloquear al paciente del consultorio: %s %v", sql, args)
+					}
+					*(dest[0].(*uuid.UUID)) = pacienteID
+					return nil
+				case strings.Contains(sql, "row_to_json"):
+					*(dest[0].(*[]byte)) = []byte(`{"id":"x"}`)
+					return nil
+				}
+				return pgx.ErrNoRows
+			}}
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "INSERT INTO datos_personales") {
+				insertArgs = args
+			}
+			if strings.Contains(sql, "INSERT INTO auditorias") {
+				accion = args[1].(string)
+			}
+			return pgconn.NewCommandTag("INSERT 1"), nil
+		},
+	}
+	h := NewDatosPersonalesHandler(&mockTxPool{tx: tx}, cipher, zap.NewNop())
+
+	c, w := makeCtx("PUT", "/", []byte(`{"dni":"20.123.456","telefono":"11 5555-1234","direccion":"Calle 1"}`))
+	c.Params = gin.Params{{Key: "id", Value: pacienteID.String()}}
+	h.PutDatosPersonales(c)
+
+	if w.Code != http.StatusOK || !tx.committed {
+		t.Fatalf("Se esperaba 200 y commit, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	id := insertArgs[0].(uuid.UUID).String()
+	dniCifrado := insertArgs[2].([]byte)
+	if bytes.Contains(dniCifrado, []byte("20123456")) {
+		t.Fatal("El DNI se guardó en claro")
+	}
+	dni, err := cipher.Decrypt(context.Background(), "datos_personales", id, "dni", dniCifrado)
+	if err != nil || string(dni) != "20123456" {
+		t.Errorf("Se esperaba el DNI normalizado y cifrado para la fila, obtuvo %q %v", dni, err)
+	}
+	if !bytes.Equal(insertArgs[3].([]byte), cipher.Index("dni", "20123456")) {
+		t.Error("Se esperaba guardar el índice ciego del DNI")
+	}
+	if tel, err := cipher.Decrypt(context.Background(), "datos_personales", id, "telefono", insertArgs[4].([]byte)); err != nil || string(tel) != "11 5555-1234" {
+		t.Errorf("Se esperaba el teléfono cifrado, obtuvo %q %v", tel, err)
+	}
+	if v := insertArgs[6].(*int64); v == nil || *v != 1 {
+		t.Errorf("Se esperaba guardar la versión de la clave maestra, obtuvo %v", insertArgs[6])
+	}
+	if accion != "crear" {
+		t.Errorf("Se esperaba auditar la creación, obtuvo %q", accion)
+	}
+
+	// DNI con formato inválido
+	c, w = makeCtx("PUT", "/", []byte(`{"dni":"12"}`))
+	c.Params = gin.Params{{Key: "id", Value: pacienteID.String()}}
+	h.PutDatosPersonales(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Se esperaba 400 con un DNI inválido, obtuvo %d", w.Code)
+	}
+}
+
+func TestGetDatosPersonales_Descifra(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cipher := testCipher(t)
+	datosID, pacienteID := uuid.New(), uuid.New()
+	dniCifrado, _ := cipher.Encrypt(context.Background(), "datos_personales", datosID.String(), "dni", []byte("20123456"))
+	pool := &mockPoolP{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			*(dest[0].(*uuid.UUID)) = datosID
+			*(dest[1].(*[]byte)) = dniCifrado
+			*(dest[4].(*time.Time)) = time.Now()
+			return nil
+		}}
+	}}
+	h := NewDatosPersonalesHandler(pool, cipher, zap.NewNop())
+
+	c, w := makeCtx("GET", "/", nil)
+	c.Params = gin.Params{{Key: "id", Value: pacienteID.String()}}
+	h.GetDatosPersonales(c)
+
+	var resp struct {
+		Datos DatosPersonales `json:"datos_personales"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Datos.DNI == nil || *resp.Datos.DNI != "20123456" || resp.Datos.Telefono != nil {
+		t.Fatalf("Se esperaba el DNI descifrado, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+
+	// Un valor copiado de otra fila no se descifra
+	otroCifrado, _ := cipher.Encrypt(context.Background(), "datos_personales", uuid.NewString(), "dni", []byte("30999888"))
+	dniCifrado = otroCifrado
+	c, w = makeCtx("GET", "/", nil)
+	c.Params = gin.Params{{Key: "id", Value: pacienteID.String()}}
+	h.GetDatosPersonales(c)
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "30999888") {
+		t.Errorf("Se esperaba 500 sin exponer el valor, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBuscarPorDNI_UsaIndiceCiego(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cipher := testCipher(t)
+	var gotSQL string
+	var gotArgs []interface{}
+	pool := &mockPoolP{queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+		gotSQL, gotArgs = sql, args
+		return &mockRowsP{}, nil
+	}}
+	h := NewDatosPersonalesHandler(pool, cipher, zap.NewNop())
+
+	c, w := makeCtx("POST", "/api/v1/pacientes/buscar-dni", []byte(`{"dni":"20.123.456"}`))
+	h.BuscarPorDNI(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba 200, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(gotSQL, "d.dni_indice = $1") || !strings.Contains(gotSQL, "p.consultorio_id = $2") {
+		t.Errorf("Se esperaba buscar por índice dentro del consultorio: %s", gotSQL)
+	}
+	if !bytes.Equal(gotArgs[0].([]byte), cipher.Index("dni", "20123456")) || gotArgs[1] != consultorioTest {
+		t.Errorf("Argumentos inesperados: %v", gotArgs)
+	}
+}
+
+func TestDatosPersonales_SinCifrado(t *testing.T) {
+	h := NewDatosPersonalesHandler(&mockPoolP{}, nil, zap.NewNop())
+	c, w := makeCtx("PUT", "/", []byte(`{"dni":"20123456"}`))
+	c.Params = gin.Params{{Key: "id", Value: uuid.NewString()}}
+	h.PutDatosPersonales(c)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Se esperaba 503 sin clave maestra, obtuvo %d", w.Code)
+	}
+}
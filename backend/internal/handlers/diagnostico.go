@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/diagnostico"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Diagnosticador arma el reporte de estado del backend
+type Diagnosticador interface {
+	Reporte(ctx context.Context) diagnostico.Reporte
+}
+
+// DiagnosticoHandler expone el diagnóstico del backend a los administradores
+type DiagnosticoHandler struct {
+	diagnostico Diagnosticador
+	logger      *zap.Logger
+}
+
+// NewDiagnosticoHandler crea el handler
+func NewDiagnosticoHandler(d Diagnosticador, logger *zap.Logger) *DiagnosticoHandler {
+	return &DiagnosticoHandler{diagnostico: d, logger: logger}
+}
+
+// GetDiagnostico godoc
+// @Summary      Diagnóstico del backend
+// @Description  Compara el esquema de la base con las migraciones (versiones pendientes o desconocidas, tablas faltantes o no esperadas), cuenta las filas de cada tabla y muestra el pool de conexiones y el estado de Redis. No incluye filas ni mensajes de error de la base. Deshabilitado con ENV=production salvo DIAGNOSTICO_HABILITADO=true.
+// @Tags         diagnostico
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Router       /api/v1/diagnostico [get]
+func (h *DiagnosticoHandler) GetDiagnostico(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	reporte := h.diagnostico.Reporte(ctx)
+	if reporte.Estado != diagnostico.EstadoOK {
+		h.logger.Warn("Diagnóstico degradado",
+			zap.Int("migraciones_pendientes", len(reporte.Migraciones.Pendientes)),
+			zap.Strings("tablas_faltantes", reporte.Migraciones.TablasFaltantes),
+			zap.Bool("redis", reporte.Redis.Disponible))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"diagnostico": reporte,
+	})
+}
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/duplicados"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// maxCandidatosDuplicados acota las filas que se puntúan por cada alta
+const maxCandidatosDuplicados = 50
+
+// DescifradorDNI descifra el DNI guardado en datos_personales.dni_encriptado y calcula el
+// índice ciego de un DNI normalizado, para traer como candidato a quien coincide solo en
+// el DNI. Sin descifrador la detección de duplicados no compara DNI.
+type DescifradorDNI interface {
+	DescifrarDNI(ctx context.Context, datosPersonalesID string, cifrado []byte) (string, error)
+	IndiceDNI(dni string) []byte
+}
+
+// consultaFilas es la parte de un pool o una transacción que usa buscarDuplicados
+type consultaFilas interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// DuplicadoInput son los datos de un paciente a comparar con los existentes
+type DuplicadoInput struct {
+	Nombre          string  `json:"nombre" binding:"required"`
+	Apellido        string  `json:"apellido" binding:"required"`
+	FechaNacimiento string  `json:"fecha_nacimiento"`
+	NroCredencial   *string `json:"nro_credencial,omitempty"`
+	DNI             *string `json:"dni,omitempty"`
+	// ConsultorioID solo se usa con alcance global
+	ConsultorioID *string `json:"consultorio_id,omitempty"`
+}
+
+// CandidatoDuplicado es un paciente existente que podría ser la misma persona
+type CandidatoDuplicado struct {
+	ID              string   `json:"id"`
+	Nombre          string   `json:"nombre"`
+	Apellido        string   `json:"apellido"`
+	FechaNacimiento string   `json:"fecha_nacimiento"`
+	NroCredencial   *string  `json:"nro_credencial,omitempty"`
+	Puntaje         float64  `json:"puntaje"`
+	Probable        bool     `json:"probable"`
+	Motivos         []string `json:"motivos"`
+}
+
+// FusionInput indica el registro duplicado que se fusiona en el paciente de la ruta
+type FusionInput struct {
+	DuplicadoID string `json:"duplicado_id" binding:"required,uuid"`
+	Motivo      string `json:"motivo"`
+}
+
+// tablasFusion son las tablas cuyo paciente_id pasa del duplicado al superviviente
+var tablasFusion = []string{"historias_clinicas", "turnos", "recetas_medicas"}
+
+func hayDuplicadoProbable(candidatos []CandidatoDuplicado) bool {
+	for _, c := range candidatos {
+		if c.Probable {
+			return true
+		}
+	}
+	return false
+}
+
+// buscarDuplicados trae del consultorio los pacientes con nombre parecido, la misma fecha
+// de nacimiento, la misma credencial o el mismo DNI, y devuelve los que superan el umbral de candidato
+// ordenados por puntaje
+func (h *PacienteHandler) buscarDuplicados(ctx context.Context, db consultaFilas, in DuplicadoInput, consultorioID string) ([]CandidatoDuplicado, error) {
+	var fecha interface{}
+	if in.FechaNacimiento != "" {
+		fecha = in.FechaNacimiento
+	}
+	var indiceDNI []byte
+	if in.DNI != nil && h.dni != nil {
+		indiceDNI = h.dni.IndiceDNI(NormalizarDNI(*in.DNI))
+	}
+	query := `
+	       SELECT p.id, p.nombre, p.apellido, p.fecha_nacimiento, p.nro_credencial,
+		       similarity(lower(f_unaccent($1 || ' ' || $2)), lower(f_unaccent(p.nombre || ' ' || p.apellido)))::float8,
+		       COALESCE(p.fecha_nacimiento = $3::date, false),
+		       d.id::text, d.dni_encriptado
+	       FROM pacientes p
+	       LEFT JOIN LATERAL (
+		       SELECT id, dni_encriptado, dni_indice FROM datos_personales
+		       WHERE paciente_id = p.id AND dni_encriptado IS NOT NULL
+		       LIMIT 1
+	       ) d ON TRUE
+	       WHERE p.consultorio_id = $5
+		 AND (lower(f_unaccent($1 || ' ' || $2)) <% ` + busquedaTexto + `
+		      OR p.fecha_nacimiento = $3::date
+		      OR p.nro_credencial = $4
+		      OR d.dni_indice = $6)
+	       ORDER BY 6 DESC
+	       LIMIT ` + strconv.Itoa(maxCandidatosDuplicados)
+
+	rows, err := db.Query(ctx, query, in.Nombre, in.Apellido, fecha, in.NroCredencial, consultorioID, indiceDNI)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidatos := make([]CandidatoDuplicado, 0)
+	for rows.Next() {
+		var (
+			id               [16]byte
+			nombre, apellido string
+			fechaNacimiento  time.Time
+			nroCredencial    *string
+			similitud        float64
+			mismaFecha       bool
+			datosID          *string
+			dniCifrado       []byte
+		)
+		if err := rows.Scan(&id, &nombre, &apellido, &fechaNacimiento, &nroCredencial, &similitud, &mismaFecha, &datosID, &dniCifrado); err != nil {
+			return nil, err
+		}
+		senales := duplicados.Senales{
+			SimilitudNombre:      similitud,
+			MismaFechaNacimiento: mismaFecha,
+			Credencial:           duplicados.Comparar(in.NroCredencial, nroCredencial),
+		}
+		if in.DNI != nil && h.dni != nil && datosID != nil && len(dniCifrado) > 0 {
+			dni, err := h.dni.DescifrarDNI(ctx, *datosID, dniCifrado)
+			if err != nil {
+				// Un DNI ilegible no impide el alta; se compara con el resto de las señales
+				h.logger.Warn("No se pudo descifrar el DNI", zap.String("datos_personales_id", *datosID), zap.Error(err))
+			} else {
+				senales.DNI = duplicados.Comparar(in.DNI, &dni)
+			}
+		}
+		puntaje, motivos := duplicados.Puntuar(senales)
+		if puntaje < duplicados.UmbralCandidato {
+			continue
+		}
+		candidatos = append(candidatos, CandidatoDuplicado{
+			ID:              uuid.UUID(id).String(),
+			Nombre:          nombre,
+			Apellido:        apellido,
+			FechaNacimiento: fechaNacimiento.Format("2006-01-02"),
+			NroCredencial:   nroCredencial,
+			Puntaje:         puntaje,
+			Probable:        puntaje >= duplicados.UmbralProbable,
+			Motivos:         motivos,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(candidatos, func(i, j int) bool { return candidatos[i].Puntaje > candidatos[j].Puntaje })
+	return candidatos, nil
+}
+
+// BuscarDuplicados godoc
+// @Summary      Detectar posibles duplicados
+// @Description  Compara los datos de un paciente con los del consultorio (nombre, fecha de nacimiento, credencial y DNI) y devuelve los candidatos con su puntaje
+// @Tags         pacientes
+// @Accept       json
+// @Produce      json
+// @Param        datos  body  DuplicadoInput  true  "Datos del paciente"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/duplicados [post]
+func (h *PacienteHandler) BuscarDuplicados(c *gin.Context) {
+	var input DuplicadoInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	consultorioID := scope.ConsultorioID.String()
+	if scope.Global {
+		if input.ConsultorioID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "consultorio_id es obligatorio al operar sobre todos los consultorios"})
+			return
+		}
+		consultorioID = *input.ConsultorioID
+	}
+	if input.FechaNacimiento != "" {
+		if _, err := time.Parse("2006-01-02", input.FechaNacimiento); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fecha_nacimiento inválida, se espera YYYY-MM-DD"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	candidatos, err := h.buscarDuplicados(ctx, h.pool, input, consultorioID)
+	if err != nil {
+		h.logger.Error("Error al buscar duplicados", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"candidatos": candidatos,
+		"total":      len(candidatos),
+	})
+}
+
+// datosPersonalesDe devuelve la fila de datos personales del paciente como JSON, bloqueada
+// hasta el fin de la transacción, o nil si no tiene
+func datosPersonalesDe(ctx context.Context, tx pgx.Tx, pacienteID uuid.UUID) (json.RawMessage, error) {
+	var fila []byte
+	err := tx.QueryRow(ctx, `SELECT row_to_json(t) FROM datos_personales t WHERE t.paciente_id = $1 FOR UPDATE`, pacienteID).Scan(&fila)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return json.RawMessage(fila), err
+}
+
+// FusionarPacientes godoc
+// @Summary      Fusionar paciente duplicado
+// @Description  Pasa historias clínicas, turnos, recetas, datos personales y profesionales asignados del duplicado al paciente de la ruta, completa los datos que le falten y elimina el duplicado. Si los dos tienen datos personales responde 409. Queda auditado.
+// @Tags         pacientes
+// @Accept       json
+// @Produce      json
+// @Param        id     path  string       true  "ID del paciente que se conserva"
+// @Param        datos  body  FusionInput  true  "Paciente duplicado"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/fusionar [post]
+func (h *PacienteHandler) FusionarPacientes(c *gin.Context) {
+	supervivienteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return
+	}
+	var input FusionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	duplicadoID := uuid.MustParse(input.DuplicadoID)
+	if duplicadoID == supervivienteID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No se puede fusionar un paciente consigo mismo"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo fusionar los pacientes"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Ambos pacientes quedan bloqueados (en orden de id) hasta el final de la fusión
+	args := []interface{}{[]uuid.UUID{supervivienteID, duplicadoID}}
+	rows, err := tx.Query(ctx, `
+	       SELECT consultorio_id FROM pacientes
+	       WHERE id = ANY($1) AND `+scope.Consultorio("consultorio_id", &args)+`
+	       ORDER BY id
+	       FOR UPDATE`, args...)
+	if err != nil {
+		h.logger.Error("Error al bloquear pacientes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo fusionar los pacientes"})
+		return
+	}
+	var consultorios []*uuid.UUID
+	for rows.Next() {
+		var consultorioID *uuid.UUID
+		if err := rows.Scan(&consultorioID); err != nil {
+			rows.Close()
+			h.logger.Error("Error al leer pacientes", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo fusionar los pacientes"})
+			return
+		}
+		consultorios = append(consultorios, consultorioID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		h.logger.Error("Error al leer pacientes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo fusionar los pacientes"})
+		return
+	}
+	if len(consultorios) != 2 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Paciente no encontrado"})
+		return
+	}
+	if consultorios[0] == nil || consultorios[1] == nil || *consultorios[0] != *consultorios[1] {
+		c.JSON(http.StatusConflict, gin.H{"error": "Los pacientes pertenecen a consultorios distintos"})
+		return
+	}
+
+	antesSuperviviente, err := audit.Snapshot(ctx, tx, "pacientes", supervivienteID, false)
+	var antesDuplicado json.RawMessage
+	if err == nil {
+		antesDuplicado, err = audit.Snapshot(ctx, tx, "pacientes", duplicadoID, false)
+	}
+	// Hay una fila de datos personales por paciente y sus valores cifrados están atados al
+	// id de la fila, así que no se pueden combinar: si los dos tienen, primero se elimina
+	// la que no corresponde (DELETE /pacientes/:id/datos-personales, auditado)
+	var datosSuperviviente, datosDuplicado json.RawMessage
+	if err == nil {
+		datosSuperviviente, err = datosPersonalesDe(ctx, tx, supervivienteID)
+	}
+	if err == nil {
+		datosDuplicado, err = datosPersonalesDe(ctx, tx, duplicadoID)
+	}
+	if err != nil {
+		h.logger.Error("Error al leer pacientes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo fusionar los pacientes"})
+		return
+	}
+	if datosSuperviviente != nil && datosDuplicado != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Los dos pacientes tienen datos personales; elimine los que no correspondan antes de fusionar"})
+		return
+	}
+
+	// Habilita el cambio de paciente en recetas (ver trigger recetas_medicas_inmutable)
+	if _, err := tx.Exec(ctx, `SET LOCAL mediapp.fusion_pacientes = 'on'`); err != nil {
+		h.logger.Error("Error al preparar la fusión", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo fusionar los pacientes"})
+		return
+	}
+
+	movidos := make(map[string]int64, len(tablasFusion)+2)
+	for _, tabla := range tablasFusion {
+		res, err := tx.Exec(ctx, `UPDATE `+tabla+` SET paciente_id = $1 WHERE paciente_id = $2`, supervivienteID, duplicadoID)
+		if err != nil {
+			h.logger.Error("Error al mover registros del duplicado", zap.String("tabla", tabla), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo fusionar los pacientes"})
+			return
+		}
+		movidos[tabla] = res.RowsAffected()
+	}
+
+	// Si solo el duplicado tiene datos personales pasan al superviviente; el cifrado está
+	// atado al id de la fila, no al paciente, así que los valores siguen descifrándose
+	res, err := tx.Exec(ctx, `UPDATE datos_personales SET paciente_id = $1 WHERE paciente_id = $2`, supervivienteID, duplicadoID)
+	if err == nil {
+		movidos["datos_personales"] = res.RowsAffected()
+		// Las asignaciones repetidas se descartan; las del duplicado se borran en cascada
+		res, err = tx.Exec(ctx, `
+	       INSERT INTO paciente_profesional (paciente_id, usuario_id, asignado_por, asignado_en)
+	       SELECT $1, usuario_id, asignado_por, asignado_en FROM paciente_profesional WHERE paciente_id = $2
+	       ON CONFLICT DO NOTHING`, supervivienteID, duplicadoID)
+	}
+	if err == nil {
+		movidos["paciente_profesional"] = res.RowsAffected()
+		// El superviviente conserva sus datos y completa los que le falten con los del duplicado
+		_, err = tx.Exec(ctx, `
+	       UPDATE pacientes s SET
+		       nro_credencial = COALESCE(s.nro_credencial, d.nro_credencial),
+		       obra_social = COALESCE(s.obra_social, d.obra_social),
+		       condicion_iva = COALESCE(s.condicion_iva, d.condicion_iva),
+		       plan = COALESCE(s.plan, d.plan)
+	       FROM pacientes d
+	       WHERE s.id = $1 AND d.id = $2`, supervivienteID, duplicadoID)
+	}
+	if err == nil {
+		_, err = tx.Exec(ctx, `DELETE FROM pacientes WHERE id = $1`, duplicadoID)
+	}
+	if err != nil {
+		h.logger.Error("Error al fusionar pacientes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo fusionar los pacientes"})
+		return
+	}
+
+	despues, err := audit.Snapshot(ctx, tx, "pacientes", supervivienteID, false)
+	if err == nil {
+		entry := audit.FromRequest(c, audit.AccionFusionar, "pacientes", supervivienteID.String())
+		entry.ConsultorioID = consultorios[0]
+		entry.Antes = gin.H{
+			"paciente":                   antesSuperviviente,
+			"duplicado":                  antesDuplicado,
+			"datos_personales":           datosSuperviviente,
+			"datos_personales_duplicado": datosDuplicado,
+		}
+		entry.Despues = gin.H{"paciente": despues, "duplicado_id": duplicadoID, "movidos": movidos, "motivo": input.Motivo}
+		err = audit.Write(ctx, tx, entry)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al auditar fusión de pacientes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo fusionar los pacientes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Pacientes fusionados exitosamente",
+		"paciente_id":  supervivienteID,
+		"duplicado_id": duplicadoID,
+		"movidos":      movidos,
+	})
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// fakeDNI descifra devolviendo el texto tal cual; el índice es el DNI con un prefijo
+type fakeDNI struct{ llamadas int }
+
+func (f *fakeDNI) DescifrarDNI(ctx context.Context, datosPersonalesID string, cifrado []byte) (string, error) {
+	f.llamadas++
+	return string(cifrado), nil
+}
+
+func (f *fakeDNI) IndiceDNI(dni string) []byte { return []byte("indice:" + dni) }
+
+// candidatoRows devuelve un candidato existente con la similitud de nombre y el DNI dados
+func candidatoRows(similitud float64, mismaFecha bool, credencial, dni string) *mockRowsP {
+	rows := &mockRowsP{rowsCount: 1}
+	rows.scanFunc = func(dest ...interface{}) error {
+		id := uuid.New()
+		copy(dest[0].(*[16]byte)[:], id[:])
+		*(dest[1].(*string)) = "Juan"
+		*(dest[2].(*string)) = "Pérez"
+		*(dest[3].(*time.Time)) = time.Date(1980, 5, 10, 0, 0, 0, 0, time.UTC)
+		if credencial != "" {
+			*(dest[4].(**string)) = &credencial
+		}
+		*(dest[5].(*float64)) = similitud
+		*(dest[6].(*bool)) = mismaFecha
+		if dni != "" {
+			datosID := uuid.NewString()
+			*(dest[7].(**string)) = &datosID
+			*(dest[8].(*[]byte)) = []byte(dni)
+		}
+		return nil
+	}
+	return rows
+}
+
+func TestCreatePaciente_DuplicadoProbable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var insertado bool
+	pool := &mockPoolP{
+		queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+			if args[4] != consultorioTest.String() {
+				t.Errorf("Se esperaba buscar duplicados en el consultorio del usuario, obtuvo %v", args[4])
+			}
+			return candidatoRows(0.7, true, "OSDE-123", ""), nil
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "INSERT INTO pacientes") {
+				insertado = true
+			}
+			return pgconn.NewCommandTag("INSERT 1"), nil
+		},
+		queryRowFunc: auditQueryRow(`{"id":"1"}`),
+	}
+	h := NewPacienteHandler(pool, zap.NewNop())
+	body := []byte(`{"nombre":"Juan","apellido":"Perez","fecha_nacimiento":"1980-05-10","nro_credencial":"osde 123"}`)
+
+	c, w := makeCtx("POST", "/api/v1/pacientes", body)
+	h.CreatePaciente(c)
+	if w.Code != http.StatusConflict || insertado {
+		t.Fatalf("Se esperaba 409 sin insertar, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Candidatos []CandidatoDuplicado `json:"candidatos"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Candidatos) != 1 || !resp.Candidatos[0].Probable {
+		t.Fatalf("Se esperaba un candidato probable, obtuvo %s", w.Body.String())
+	}
+
+	// Confirmando el alta se crea igual e informa los candidatos
+	c, w = makeCtx("POST", "/api/v1/pacientes?forzar=true", body)
+	h.CreatePaciente(c)
+	if w.Code != http.StatusCreated || !insertado || !strings.Contains(w.Body.String(), "posibles_duplicados") {
+		t.Fatalf("Se esperaba 201 al forzar, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestCreatePaciente_DuplicadoPorDNI verifica que el alta compara el DNI aunque no coincida
+// ninguna otra señal: el candidato se trae por el índice ciego y se confirma descifrando
+func TestCreatePaciente_DuplicadoPorDNI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dni := &fakeDNI{}
+	pool := &mockPoolP{
+		queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+			if !strings.Contains(sql, "d.dni_indice = $6") || string(args[5].([]byte)) != "indice:20123456" {
+				t.Errorf("Se esperaba buscar candidatos por el índice del DNI normalizado: %v", args)
+			}
+			return candidatoRows(0, false, "", "20123456"), nil
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			return pgconn.NewCommandTag("INSERT 1"), nil
+		},
+		queryRowFunc: auditQueryRow(`{"id":"1"}`),
+	}
+	h := NewPacienteHandlerWithDNI(pool, dni, zap.NewNop())
+
+	c, w := makeCtx("POST", "/api/v1/pacientes", []byte(`{"nombre":"Ana","apellido":"Gómez","fecha_nacimiento":"1990-01-01","dni":"20.123.456"}`))
+	h.CreatePaciente(c)
+	var resp struct {
+		Candidatos []CandidatoDuplicado `json:"posibles_duplicados"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusCreated || len(resp.Candidatos) != 1 || dni.llamadas != 1 {
+		t.Fatalf("Se esperaba informar el candidato por DNI, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	if motivos := strings.Join(resp.Candidatos[0].Motivos, ","); motivos != "mismo_dni" {
+		t.Errorf("Se esperaba solo el motivo mismo_dni, obtuvo %s", motivos)
+	}
+
+	c, w = makeCtx("POST", "/api/v1/pacientes", []byte(`{"nombre":"Ana","apellido":"Gómez","fecha_nacimiento":"1990-01-01","dni":"12-3"}`))
+	h.CreatePaciente(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Se esperaba 400 con un DNI inválido, obtuvo %d", w.Code)
+	}
+}
+
+func TestBuscarDuplicados_ComparaDNI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dni := &fakeDNI{}
+	casos := []struct {
+		dniExistente string
+		esperados    int
+	}{
+		{"20.123.456", 1}, // mismo DNI con otro formato
+		{"30999888", 0},   // DNI distinto: no es la misma persona
+	}
+	for _, caso := range casos {
+		pool := &mockPoolP{queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+			if !strings.Contains(sql, "similarity(") || !strings.Contains(sql, "datos_personales") {
+				t.Errorf("Consulta inesperada: %s", sql)
+			}
+			return candidatoRows(1, true, "", caso.dniExistente), nil
+		}}
+		h := NewPacienteHandlerWithDNI(pool, dni, zap.NewNop())
+		c, w := makeCtx("POST", "/api/v1/pacientes/duplicados", []byte(`{"nombre":"Juan","apellido":"Pérez","fecha_nacimiento":"1980-05-10","dni":"20123456"}`))
+		h.BuscarDuplicados(c)
+
+		var resp struct {
+			Candidatos []CandidatoDuplicado `json:"candidatos"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusOK || len(resp.Candidatos) != caso.esperados {
+			t.Errorf("DNI %s: se esperaban %d candidatos, obtuvo %d: %s", caso.dniExistente, caso.esperados, w.Code, w.Body.String())
+		}
+	}
+	if dni.llamadas != 2 {
+		t.Errorf("Se esperaba descifrar el DNI de cada candidato, llamadas: %d", dni.llamadas)
+	}
+}
+
+func TestFusionarPacientes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	superviviente, duplicado := uuid.New(), uuid.New()
+	consultorios := []uuid.UUID{consultorioTest, consultorioTest}
+	var accion string
+	var antes map[string]json.RawMessage
+	// Solo el duplicado tiene datos personales
+	datos := map[uuid.UUID]string{duplicado: `{"id":"dp-duplicado"}`}
+	tx := &mockTx{
+		queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+			if !strings.Contains(sql, "FOR UPDATE") || !strings.Contains(sql, "consultorio_id = $2") {
+				t.Errorf("Se esperaba bloquear los pacientes del consultorio: %s", sql)
+			}
+			rows := &mockRowsP{rowsCount: len(consultorios)}
+			rows.scanFunc = func(dest ...interface{}) error {
+				id := consultorios[rows.idx-1]
+				*(dest[0].(**uuid.UUID)) = &id
+				return nil
+			}
+			return rows, nil
+		},
+		queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+			if strings.Contains(sql, "FROM datos_personales") {
+				return mockRowP{scanFunc: func(dest ...interface{}) error {
+					fila, ok := datos[args[0].(uuid.UUID)]
+					if !ok {
+						return pgx.ErrNoRows
+					}
+					*(dest[0].(*[]byte)) = []byte(fila)
+					return nil
+				}}
+			}
+			return auditQueryRow(`{"id":"1"}`)(ctx, sql, args...)
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "INSERT INTO auditorias") {
+				accion = args[1].(string)
+				json.Unmarshal(args[6].([]byte), &antes)
+			}
+			return pgconn.NewCommandTag("UPDATE 2"), nil
+		},
+	}
+	h := NewPacienteHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	c, w := makeCtx("POST", "/api/v1/pacientes/"+superviviente.String()+"/fusionar", []byte(`{"duplicado_id":"`+duplicado.String()+`","motivo":"alta repetida"}`))
+	c.Params = gin.Params{{Key: "id", Value: superviviente.String()}}
+	h.FusionarPacientes(c)
+
+	if w.Code != http.StatusOK || !tx.committed {
+		t.Fatalf("Se esperaba 200 y commit, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	sqls := strings.Join(tx.execSQL, "\n")
+	if !strings.Contains(tx.execSQL[0], "mediapp.fusion_pacientes") {
+		t.Errorf("Se esperaba habilitar la fusión antes de mover recetas: %v", tx.execSQL[0])
+	}
+	for _, tabla := range append(tablasFusion, "datos_personales", "paciente_profesional", "DELETE FROM pacientes") {
+		if !strings.Contains(sqls, tabla) {
+			t.Errorf("Se esperaba %q en la fusión", tabla)
+		}
+	}
+	if accion != "fusionar" || string(antes["datos_personales_duplicado"]) != `{"id":"dp-duplicado"}` {
+		t.Errorf("Se esperaba auditar la fusión con los datos personales del duplicado, obtuvo %q %v", accion, antes)
+	}
+
+	// Los dos tienen datos personales: no se descarta ninguno
+	datos[superviviente] = `{"id":"dp-superviviente"}`
+	tx.committed = false
+	c, w = makeCtx("POST", "/", []byte(`{"duplicado_id":"`+duplicado.String()+`"}`))
+	c.Params = gin.Params{{Key: "id", Value: superviviente.String()}}
+	h.FusionarPacientes(c)
+	if w.Code != http.StatusConflict || tx.committed {
+		t.Errorf("Se esperaba 409 sin commit, obtuvo %d", w.Code)
+	}
+	delete(datos, superviviente)
+
+	// Pacientes de consultorios distintos (solo posible con alcance global)
+	consultorios = []uuid.UUID{uuid.New(), uuid.New()}
+	tx.committed = false
+	c, w = makeCtx("POST", "/", []byte(`{"duplicado_id":"`+duplicado.String()+`"}`))
+	c.Params = gin.Params{{Key: "id", Value: superviviente.String()}}
+	h.FusionarPacientes(c)
+	if w.Code != http.StatusConflict || tx.committed {
+		t.Errorf("Se esperaba 409 sin commit, obtuvo %d", w.Code)
+	}
+
+	// Paciente fuera del consultorio
+	consultorios = consultorios[:1]
+	c, w = makeCtx("POST", "/", []byte(`{"duplicado_id":"`+duplicado.String()+`"}`))
+	c.Params = gin.Params{{Key: "id", Value: superviviente.String()}}
+	h.FusionarPacientes(c)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Se esperaba 404, obtuvo %d", w.Code)
+	}
+
+	// Consigo mismo
+	c, w = makeCtx("POST", "/", []byte(`{"duplicado_id":"`+superviviente.String()+`"}`))
+	c.Params = gin.Params{{Key: "id", Value: superviviente.String()}}
+	h.FusionarPacientes(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Se esperaba 400, obtuvo %d", w.Code)
+	}
+}
@@ -0,0 +1,592 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/models"
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// HistoriaHandler maneja las historias clínicas versionadas de los pacientes.
+// Cada cambio agrega una nueva fila en historia_clinica_version; nunca se sobrescribe.
+type HistoriaHandler struct {
+	pool   TxPool
+	logger *zap.Logger
+}
+
+// NewHistoriaHandler crea una nueva instancia del handler de historias clínicas
+func NewHistoriaHandler(pool TxPool, logger *zap.Logger) *HistoriaHandler {
+	return &HistoriaHandler{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// VersionInput es el contenido clínico de una versión. Al agregar una versión,
+// los campos omitidos conservan el valor de la versión anterior.
+type VersionInput struct {
+	MotivoConsulta *string `json:"motivo_consulta"`
+	Antecedentes   *string `json:"antecedentes"`
+	ExamenFisico   *string `json:"examen_fisico"`
+	Diagnostico    *string `json:"diagnostico"`
+	Tratamiento    *string `json:"tratamiento"`
+}
+
+// HistoriaInput son los datos para registrar una nueva consulta
+type HistoriaInput struct {
+	FechaConsulta *time.Time `json:"fecha_consulta"`
+	VersionInput
+}
+
+// HistoriaConVersiones es una consulta junto con su cadena completa de versiones
+type HistoriaConVersiones struct {
+	models.HistoriaClinica
+	Versiones []models.HistoriaClinicaVersion `json:"versiones"`
+}
+
+// ResumenHistoria es una consulta con los datos de su última versión
+type ResumenHistoria struct {
+	models.HistoriaClinica
+	UltimaVersion  int       `json:"ultima_version"`
+	ActualizadoEn  time.Time `json:"actualizado_en"`
+	ActualizadoPor uuid.UUID `json:"actualizado_por"`
+}
+
+// CambioCampo describe la diferencia de un campo clínico entre dos versiones
+type CambioCampo struct {
+	Campo    string  `json:"campo"`
+	Anterior *string `json:"anterior"`
+	Nuevo    *string `json:"nuevo"`
+}
+
+const versionColumns = `id, historia_clinica_id, numero_version, motivo_consulta, antecedentes, examen_fisico, diagnostico, tratamiento, usuario_id, modificado_en`
+
+func scanVersion(row pgx.Row) (models.HistoriaClinicaVersion, error) {
+	var v models.HistoriaClinicaVersion
+	err := row.Scan(&v.ID, &v.HistoriaClinicaID, &v.NumeroVersion, &v.MotivoConsulta, &v.Antecedentes,
+		&v.ExamenFisico, &v.Diagnostico, &v.Tratamiento, &v.UsuarioID, &v.ModificadoEn)
+	return v, err
+}
+
+// camposClinicos devuelve los campos versionados en orden estable
+func camposClinicos(v models.HistoriaClinicaVersion) []CambioCampo {
+	return []CambioCampo{
+		{Campo: "motivo_consulta", Nuevo: v.MotivoConsulta},
+		{Campo: "antecedentes", Nuevo: v.Antecedentes},
+		{Campo: "examen_fisico", Nuevo: v.ExamenFisico},
+		{Campo: "diagnostico", Nuevo: v.Diagnostico},
+		{Campo: "tratamiento", Nuevo: v.Tratamiento},
+	}
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// diffVersiones compara campo a campo dos versiones y devuelve solo los que cambiaron
+func diffVersiones(anterior, nueva models.HistoriaClinicaVersion) []CambioCampo {
+	antes := camposClinicos(anterior)
+	despues := camposClinicos(nueva)
+
+	cambios := make([]CambioCampo, 0)
+	for i := range antes {
+		if !equalStringPtr(antes[i].Nuevo, despues[i].Nuevo) {
+			cambios = append(cambios, CambioCampo{
+				Campo:    antes[i].Campo,
+				Anterior: antes[i].Nuevo,
+				Nuevo:    despues[i].Nuevo,
+			})
+		}
+	}
+	return cambios
+}
+
+// aplicarCambios construye la nueva versión a partir de la anterior, reemplazando
+// solo los campos presentes en el input
+func aplicarCambios(anterior models.HistoriaClinicaVersion, input VersionInput) models.HistoriaClinicaVersion {
+	nueva := anterior
+	if input.MotivoConsulta != nil {
+		nueva.MotivoConsulta = input.MotivoConsulta
+	}
+	if input.Antecedentes != nil {
+		nueva.Antecedentes = input.Antecedentes
+	}
+	if input.ExamenFisico != nil {
+		nueva.ExamenFisico = input.ExamenFisico
+	}
+	if input.Diagnostico != nil {
+		nueva.Diagnostico = input.Diagnostico
+	}
+	if input.Tratamiento != nil {
+		nueva.Tratamiento = input.Tratamiento
+	}
+	return nueva
+}
+
+func insertVersion(ctx context.Context, tx pgx.Tx, v models.HistoriaClinicaVersion) (models.HistoriaClinicaVersion, error) {
+	return scanVersion(tx.QueryRow(ctx, `
+		INSERT INTO historia_clinica_version (id, historia_clinica_id, numero_version, motivo_consulta, antecedentes,
+			examen_fisico, diagnostico, tratamiento, usuario_id, modificado_en)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING `+versionColumns,
+		v.ID, v.HistoriaClinicaID, v.NumeroVersion, v.MotivoConsulta, v.Antecedentes,
+		v.ExamenFisico, v.Diagnostico, v.Tratamiento, v.UsuarioID, v.ModificadoEn,
+	))
+}
+
+// buscarVersion devuelve la versión con el número indicado
+func buscarVersion(versiones []models.HistoriaClinicaVersion, numero int) (models.HistoriaClinicaVersion, bool) {
+	for _, v := range versiones {
+		if v.NumeroVersion == numero {
+			return v, true
+		}
+	}
+	return models.HistoriaClinicaVersion{}, false
+}
+
+// parseHistoriaParams valida los IDs de paciente e historia de la ruta
+func parseHistoriaParams(c *gin.Context) (pacienteID, historiaID uuid.UUID, ok bool) {
+	pacienteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	if raw := c.Param("historia_id"); raw != "" {
+		historiaID, err = uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de historia clínica inválido"})
+			return uuid.Nil, uuid.Nil, false
+		}
+	}
+	return pacienteID, historiaID, true
+}
+
+// CreateHistoria godoc
+// @Summary      Registrar consulta
+// @Description  Crea una historia clínica (consulta) para el paciente con su versión inicial
+// @Tags         historias
+// @Accept       json
+// @Produce      json
+// @Param        id        path  string         true  "ID del paciente"
+// @Param        historia  body  HistoriaInput  true  "Contenido clínico inicial"
+// @Success      201  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/historias [post]
+func (h *HistoriaHandler) CreateHistoria(c *gin.Context) {
+	pacienteID, _, ok := parseHistoriaParams(c)
+	if !ok {
+		return
+	}
+	autorID, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	var input HistoriaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	historia := models.HistoriaClinica{
+		ID:            uuid.New(),
+		PacienteID:    pacienteID,
+		UsuarioID:     autorID,
+		FechaConsulta: now,
+	}
+	if input.FechaConsulta != nil {
+		historia.FechaConsulta = input.FechaConsulta.UTC()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear la historia clínica"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var existe bool
+	args := []interface{}{pacienteID}
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pacientes WHERE id = $1 AND `+scope.Consultorio("consultorio_id", &args)+`)`, args...).Scan(&existe); err != nil {
+		h.logger.Error("Error al verificar paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear la historia clínica"})
+		return
+	}
+	if !existe {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Paciente no encontrado"})
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO historias_clinicas (id, paciente_id, usuario_id, fecha_consulta)
+		VALUES ($1, $2, $3, $4)
+	`, historia.ID, historia.PacienteID, historia.UsuarioID, historia.FechaConsulta); err != nil {
+		h.logger.Error("Error al crear historia clínica", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear la historia clínica"})
+		return
+	}
+
+	version, err := insertVersion(ctx, tx, aplicarCambios(models.HistoriaClinicaVersion{
+		ID:                uuid.New(),
+		HistoriaClinicaID: historia.ID,
+		NumeroVersion:     1,
+		UsuarioID:         autorID,
+		ModificadoEn:      now,
+	}, input.VersionInput))
+	if err != nil {
+		h.logger.Error("Error al crear versión inicial de historia clínica", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear la historia clínica"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		h.logger.Error("Error al confirmar historia clínica", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear la historia clínica"})
+		return
+	}
+
+	h.logger.Info("Historia clínica creada",
+		zap.String("historia_id", historia.ID.String()),
+		zap.String("paciente_id", pacienteID.String()),
+		zap.String("usuario_id", autorID.String()))
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Historia clínica creada exitosamente",
+		"historia": HistoriaConVersiones{HistoriaClinica: historia, Versiones: []models.HistoriaClinicaVersion{version}},
+	})
+}
+
+// AppendVersion godoc
+// @Summary      Agregar versión a una historia clínica
+// @Description  Agrega una nueva versión a la historia; los campos omitidos conservan el valor anterior
+// @Tags         historias
+// @Accept       json
+// @Produce      json
+// @Param        id           path  string        true  "ID del paciente"
+// @Param        historia_id  path  string        true  "ID de la historia clínica"
+// @Param        version      body  VersionInput  true  "Campos modificados"
+// @Success      201  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/historias/{historia_id}/versiones [post]
+func (h *HistoriaHandler) AppendVersion(c *gin.Context) {
+	pacienteID, historiaID, ok := parseHistoriaParams(c)
+	if !ok {
+		return
+	}
+	autorID, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	var input VersionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo agregar la versión"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Bloquear la historia para numerar las versiones sin carreras
+	var bloqueada uuid.UUID
+	args := []interface{}{historiaID, pacienteID}
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM historias_clinicas WHERE id = $1 AND paciente_id = $2 AND `+scope.Paciente("paciente_id", &args)+` FOR UPDATE
+	`, args...).Scan(&bloqueada)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Historia clínica no encontrada"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al bloquear historia clínica", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo agregar la versión"})
+		return
+	}
+
+	anterior, err := scanVersion(tx.QueryRow(ctx, `
+		SELECT `+versionColumns+`
+		FROM historia_clinica_version
+		WHERE historia_clinica_id = $1
+		ORDER BY numero_version DESC
+		LIMIT 1
+	`, historiaID))
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		h.logger.Error("Error al obtener última versión", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo agregar la versión"})
+		return
+	}
+
+	nueva := aplicarCambios(anterior, input)
+	if anterior.NumeroVersion > 0 && len(diffVersiones(anterior, nueva)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La nueva versión no contiene cambios"})
+		return
+	}
+	nueva.ID = uuid.New()
+	nueva.HistoriaClinicaID = historiaID
+	nueva.NumeroVersion = anterior.NumeroVersion + 1
+	nueva.UsuarioID = autorID
+	nueva.ModificadoEn = time.Now().UTC()
+
+	version, err := insertVersion(ctx, tx, nueva)
+	if err != nil {
+		h.logger.Error("Error al insertar versión de historia clínica", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo agregar la versión"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		h.logger.Error("Error al confirmar versión de historia clínica", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo agregar la versión"})
+		return
+	}
+
+	h.logger.Info("Versión de historia clínica agregada",
+		zap.String("historia_id", historiaID.String()),
+		zap.Int("numero_version", version.NumeroVersion),
+		zap.String("usuario_id", autorID.String()))
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Versión agregada exitosamente",
+		"version": version,
+		"cambios": diffVersiones(anterior, version),
+	})
+}
+
+// GetHistorias godoc
+// @Summary      Listar historias clínicas de un paciente
+// @Description  Lista las consultas del paciente con los datos de su última versión
+// @Tags         historias
+// @Produce      json
+// @Param        id  path  string  true  "ID del paciente"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/historias [get]
+func (h *HistoriaHandler) GetHistorias(c *gin.Context) {
+	pacienteID, _, ok := parseHistoriaParams(c)
+	if !ok {
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []interface{}{pacienteID}
+	rows, err := h.pool.Query(ctx, `
+		SELECT hc.id, hc.paciente_id, hc.usuario_id, hc.fecha_consulta,
+		       v.numero_version, v.modificado_en, v.usuario_id
+		FROM historias_clinicas hc
+		JOIN LATERAL (
+			SELECT numero_version, modificado_en, usuario_id
+			FROM historia_clinica_version
+			WHERE historia_clinica_id = hc.id
+			ORDER BY numero_version DESC
+			LIMIT 1
+		) v ON true
+		WHERE hc.paciente_id = $1 AND `+scope.Paciente("hc.paciente_id", &args)+`
+		ORDER BY hc.fecha_consulta DESC
+	`, args...)
+	if err != nil {
+		h.logger.Error("Error al consultar historias clínicas", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	historias := make([]ResumenHistoria, 0)
+	for rows.Next() {
+		var r ResumenHistoria
+		if err := rows.Scan(&r.ID, &r.PacienteID, &r.UsuarioID, &r.FechaConsulta,
+			&r.UltimaVersion, &r.ActualizadoEn, &r.ActualizadoPor); err != nil {
+			h.logger.Error("Error al escanear historia clínica", zap.Error(err))
+			continue
+		}
+		historias = append(historias, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "success",
+		"historias": historias,
+		"total":     len(historias),
+	})
+}
+
+// loadVersiones obtiene la historia y su cadena completa de versiones
+func (h *HistoriaHandler) loadVersiones(ctx context.Context, scope tenant.Scope, pacienteID, historiaID uuid.UUID) (*HistoriaConVersiones, error) {
+	var hc HistoriaConVersiones
+	args := []interface{}{historiaID, pacienteID}
+	err := h.pool.QueryRow(ctx, `
+		SELECT id, paciente_id, usuario_id, fecha_consulta
+		FROM historias_clinicas
+		WHERE id = $1 AND paciente_id = $2 AND `+scope.Paciente("paciente_id", &args)+`
+	`, args...).Scan(&hc.ID, &hc.PacienteID, &hc.UsuarioID, &hc.FechaConsulta)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.pool.Query(ctx, `
+		SELECT `+versionColumns+`
+		FROM historia_clinica_version
+		WHERE historia_clinica_id = $1
+		ORDER BY numero_version
+	`, historiaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hc.Versiones = make([]models.HistoriaClinicaVersion, 0)
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		hc.Versiones = append(hc.Versiones, v)
+	}
+	return &hc, rows.Err()
+}
+
+// GetHistoria godoc
+// @Summary      Obtener historia clínica con versiones
+// @Description  Devuelve la consulta con la cadena completa de versiones, cada una con su autor
+// @Tags         historias
+// @Produce      json
+// @Param        id           path  string  true  "ID del paciente"
+// @Param        historia_id  path  string  true  "ID de la historia clínica"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/historias/{historia_id} [get]
+func (h *HistoriaHandler) GetHistoria(c *gin.Context) {
+	pacienteID, historiaID, ok := parseHistoriaParams(c)
+	if !ok {
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	historia, err := h.loadVersiones(ctx, scope, pacienteID, historiaID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Historia clínica no encontrada"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al consultar historia clínica", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"historia": historia,
+	})
+}
+
+// DiffHistoria godoc
+// @Summary      Comparar versiones de una historia clínica
+// @Description  Devuelve los campos que cambiaron entre dos versiones. Por defecto compara la última con la anterior.
+// @Tags         historias
+// @Produce      json
+// @Param        id           path   string  true   "ID del paciente"
+// @Param        historia_id  path   string  true   "ID de la historia clínica"
+// @Param        desde        query  int     false  "Número de versión base"
+// @Param        hasta        query  int     false  "Número de versión a comparar"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/historias/{historia_id}/diff [get]
+func (h *HistoriaHandler) DiffHistoria(c *gin.Context) {
+	pacienteID, historiaID, ok := parseHistoriaParams(c)
+	if !ok {
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	historia, err := h.loadVersiones(ctx, scope, pacienteID, historiaID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Historia clínica no encontrada"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al consultar historia clínica", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	total := len(historia.Versiones)
+	hasta, errHasta := strconv.Atoi(c.DefaultQuery("hasta", strconv.Itoa(total)))
+	desde, errDesde := strconv.Atoi(c.DefaultQuery("desde", strconv.Itoa(hasta-1)))
+	if errHasta != nil || errDesde != nil || desde < 1 || hasta > total || desde >= hasta {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":           "Rango de versiones inválido",
+			"total_versiones": total,
+		})
+		return
+	}
+
+	base, okBase := buscarVersion(historia.Versiones, desde)
+	comparada, okComparada := buscarVersion(historia.Versiones, hasta)
+	if !okBase || !okComparada {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Versión no encontrada"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"historia_id":   historiaID,
+		"version_desde": base,
+		"version_hasta": comparada,
+		"cambios":       diffVersiones(base, comparada),
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestDiffVersiones(t *testing.T) {
+	anterior := models.HistoriaClinicaVersion{
+		MotivoConsulta: strPtr("Dolor de cabeza"),
+		Diagnostico:    strPtr("Migraña"),
+		Tratamiento:    nil,
+	}
+	nueva := models.HistoriaClinicaVersion{
+		MotivoConsulta: strPtr("Dolor de cabeza"),
+		Diagnostico:    strPtr("Cefalea tensional"),
+		Tratamiento:    strPtr("Ibuprofeno 400mg"),
+	}
+
+	cambios := diffVersiones(anterior, nueva)
+	if len(cambios) != 2 {
+		t.Fatalf("Se esperaban 2 cambios, obtuvo %d: %+v", len(cambios), cambios)
+	}
+	if cambios[0].Campo != "diagnostico" || *cambios[0].Anterior != "Migraña" || *cambios[0].Nuevo != "Cefalea tensional" {
+		t.Errorf("Cambio de diagnóstico incorrecto: %+v", cambios[0])
+	}
+	if cambios[1].Campo != "tratamiento" || cambios[1].Anterior != nil || *cambios[1].Nuevo != "Ibuprofeno 400mg" {
+		t.Errorf("Cambio de tratamiento incorrecto: %+v", cambios[1])
+	}
+}
+
+func TestAplicarCambios_ConservaCamposOmitidos(t *testing.T) {
+	anterior := models.HistoriaClinicaVersion{
+		MotivoConsulta: strPtr("Control"),
+		Antecedentes:   strPtr("HTA"),
+	}
+	nueva := aplicarCambios(anterior, VersionInput{Diagnostico: strPtr("Normal")})
+
+	if nueva.Antecedentes == nil || *nueva.Antecedentes != "HTA" {
+		t.Errorf("Se esperaba conservar antecedentes, obtuvo %v", nueva.Antecedentes)
+	}
+	if nueva.Diagnostico == nil || *nueva.Diagnostico != "Normal" {
+		t.Errorf("Se esperaba diagnóstico nuevo, obtuvo %v", nueva.Diagnostico)
+	}
+}
+
+func historiaCtx(method, path string, body []byte, pacienteID, historiaID uuid.UUID, autor *uuid.UUID) (*gin.Context, *httptest.ResponseRecorder) {
+	c, w := makeCtx(method, path, body)
+	c.Params = gin.Params{{Key: "id", Value: pacienteID.String()}, {Key: "historia_id", Value: historiaID.String()}}
+	if autor != nil {
+		c.Set("user_id", autor.String())
+	}
+	return c, w
+}
+
+func TestCreateHistoria_Unauthenticated(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHistoriaHandler(&mockTxPool{}, zap.NewNop())
+	c, w := historiaCtx("POST", "/api/v1/pacientes/x/historias", []byte(`{}`), uuid.New(), uuid.Nil, nil)
+	h.CreateHistoria(c)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Se esperaba status 401, obtuvo %d", w.Code)
+	}
+}
+
+func TestAppendVersion_StampsAuthorAndIncrements(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pacienteID, historiaID, autor := uuid.New(), uuid.New(), uuid.New()
+	var insertArgs []interface{}
+
+	tx := &mockTx{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		switch {
+		case strings.Contains(sql, "FOR UPDATE"):
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				setDest(dest, 0, historiaID)
+				return nil
+			}}
+		case strings.Contains(sql, "INSERT INTO historia_clinica_version"):
+			insertArgs = args
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				setDest(dest, 0, args[0])
+				setDest(dest, 1, args[1])
+				setDest(dest, 2, args[2])
+				setDest(dest, 6, args[6])
+				setDest(dest, 8, args[8])
+				setDest(dest, 9, args[9])
+				return nil
+			}}
+		default:
+			// Última versión existente: número 2 sin diagnóstico
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				setDest(dest, 0, uuid.New())
+				setDest(dest, 1, historiaID)
+				setDest(dest, 2, 2)
+				setDest(dest, 8, uuid.New())
+				setDest(dest, 9, time.Now())
+				return nil
+			}}
+		}
+	}}
+	h := NewHistoriaHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	body, _ := json.Marshal(map[string]string{"diagnostico": "Faringitis"})
+	c, w := historiaCtx("POST", "/versiones", body, pacienteID, historiaID, &autor)
+	h.AppendVersion(c)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Se esperaba status 201, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+	if insertArgs[2] != 3 {
+		t.Errorf("Se esperaba numero_version 3, obtuvo %v", insertArgs[2])
+	}
+	if insertArgs[8] != autor {
+		t.Errorf("Se esperaba que la versión quedara firmada por el autor %s, obtuvo %v", autor, insertArgs[8])
+	}
+	if !tx.committed {
+		t.Error("Se esperaba confirmar la transacción")
+	}
+}
+
+func TestAppendVersion_WithoutChanges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pacienteID, historiaID, autor := uuid.New(), uuid.New(), uuid.New()
+
+	tx := &mockTx{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if strings.Contains(sql, "INSERT") {
+			t.Fatal("No se esperaba insertar una versión sin cambios")
+		}
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			setDest(dest, 0, historiaID)
+			if len(dest) > 1 {
+				setDest(dest, 2, 1)
+				setDest(dest, 6, strPtr("Faringitis"))
+			}
+			return nil
+		}}
+	}}
+	h := NewHistoriaHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	body, _ := json.Marshal(map[string]string{"diagnostico": "Faringitis"})
+	c, w := historiaCtx("POST", "/versiones", body, pacienteID, historiaID, &autor)
+	h.AppendVersion(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Se esperaba status 400, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestAppendVersion_HistoriaNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	autor := uuid.New()
+	h := NewHistoriaHandler(&mockTxPool{}, zap.NewNop())
+
+	c, w := historiaCtx("POST", "/versiones", []byte(`{"diagnostico":"x"}`), uuid.New(), uuid.New(), &autor)
+	h.AppendVersion(c)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Se esperaba status 404, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/FolkodeGroup/mediapp/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS godoc
+// @Summary      Claves públicas de los JWT
+// @Description  Publica las claves con las que se verifican los access tokens (JWK Set, RFC 7517). Cada token indica en su encabezado kid la clave que lo firmó.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  auth.JWKS
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /.well-known/jwks.json [get]
+func JWKS(c *gin.Context) {
+	set, err := auth.CurrentJWKS()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Claves JWT no configuradas"})
+		return
+	}
+	// Los verificadores pueden cachear el documento; tras una rotación buscan de nuevo
+	// al encontrar un kid desconocido
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
+}
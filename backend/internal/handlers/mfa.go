@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/models"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// MFA es la autenticación en dos pasos con TOTP (services.MFAService)
+type MFA interface {
+	Estado(ctx context.Context, usuarioID uuid.UUID) (services.EstadoMFA, error)
+	Iniciar(ctx context.Context, usuarioID uuid.UUID) (services.EnrolamientoMFA, error)
+	Confirmar(ctx context.Context, usuarioID uuid.UUID, codigo string, entry audit.Entry) ([]string, error)
+	Verificar(ctx context.Context, usuarioID uuid.UUID, codigo string) (string, error)
+	RegenerarRecuperacion(ctx context.Context, usuarioID uuid.UUID, codigo string, entry audit.Entry) ([]string, error)
+	Desactivar(ctx context.Context, usuarioID uuid.UUID, codigo string, entry audit.Entry) error
+	CrearDesafio(ctx context.Context, usuarioID string) (services.DesafioMFA, error)
+	ResolverDesafio(ctx context.Context, token string) (string, error)
+	FalloDesafio(ctx context.Context, token string) error
+	ConsumirDesafio(ctx context.Context, token string) error
+}
+
+// usuarioDesafio carga el usuario del desafío de dos pasos. Responde y devuelve false si
+// el desafío no es válido o el usuario ya no puede ingresar.
+func (h *AuthHandler) usuarioDesafio(c *gin.Context, token string) (user models.Usuario, intentos int, ultimoLogin *time.Time, mfaActivo, ok bool) {
+	if h.mfa == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Autenticación en dos pasos no disponible en esta instancia"})
+		return
+	}
+	ctx := c.Request.Context()
+	usuarioID, err := h.mfa.ResolverDesafio(ctx, token)
+	if errors.Is(err, services.ErrDesafioMFAInvalido) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Desafío inválido o vencido, vuelva a iniciar sesión"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al resolver desafío de dos pasos", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	var passwordHash string
+	var mfaRequerido bool
+	err = h.db.QueryRow(ctx, selectUsuarioLogin+`
+		WHERE u.id = $1 AND u.activo = true
+	`, usuarioID).Scan(
+		&user.ID, &user.Nombre, &user.Email, &passwordHash, &user.RolID,
+		&user.ConsultorioID, &user.Activo, &user.CreadoEn,
+		&intentos, &ultimoLogin, &mfaRequerido, &mfaActivo,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciales inválidas"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al buscar usuario del desafío", zap.Error(err), zap.String("user_id", usuarioID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	if !h.verificarBloqueo(c, user.Nombre) {
+		return
+	}
+	ok = true
+	return
+}
+
+// LoginMFA godoc
+// @Summary      Segundo paso del login
+// @Description  Completa el login con el mfa_token devuelto por /login y un código TOTP o de recuperación. Si el enrolamiento estaba pendiente, el código lo confirma y la respuesta incluye los códigos de recuperación.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        mfaReq  body  object  true  "mfa_token y codigo"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      429  {object}  map[string]interface{}
+// @Router       /login/mfa [post]
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Codigo   string `json:"codigo" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, intentos, ultimoLogin, mfaActivo, ok := h.usuarioDesafio(c, req.MFAToken)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	var metodo string
+	var extra gin.H
+	var err error
+	if mfaActivo {
+		metodo, err = h.mfa.Verificar(ctx, user.ID, req.Codigo)
+	} else {
+		entry := audit.FromRequest(c, audit.AccionMFAActivado, "usuarios_mfa", user.ID.String())
+		entry.UsuarioID = &user.ID
+		entry.ConsultorioID = user.ConsultorioID
+		var codigos []string
+		codigos, err = h.mfa.Confirmar(ctx, user.ID, req.Codigo, entry)
+		metodo = services.MetodoMFATOTP
+		extra = gin.H{"codigos_recuperacion": codigos}
+	}
+	switch {
+	case errors.Is(err, services.ErrMFANoIniciado):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Primero debe iniciar el enrolamiento en /login/mfa/enrolar"})
+		return
+	case errors.Is(err, services.ErrMFACodigoInvalido):
+		if ferr := h.mfa.FalloDesafio(ctx, req.MFAToken); ferr != nil {
+			h.logger.Error("Error al registrar fallo del desafío", zap.Error(ferr))
+		}
+		h.registrarFallo(c, h.logger, user, intentos, "mfa")
+		return
+	case err != nil:
+		h.logger.Error("Error al verificar código de dos pasos", zap.Error(err), zap.String("user_id", user.ID.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	if err := h.mfa.ConsumirDesafio(ctx, req.MFAToken); err != nil {
+		h.logger.Error("Error al descartar desafío de dos pasos", zap.Error(err))
+	}
+	h.completarLogin(c, h.logger, user, intentos, ultimoLogin, metodo, extra)
+}
+
+// EnrolarMFALogin godoc
+// @Summary      Enrolar TOTP durante el login
+// @Description  Para usuarios cuyo rol requiere autenticación en dos pasos y todavía no la configuraron: genera el secreto con el mfa_token de /login. El login se completa en /login/mfa con el primer código.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        mfaReq  body  object  true  "mfa_token"
+// @Success      200  {object}  services.EnrolamientoMFA
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Router       /login/mfa/enrolar [post]
+func (h *AuthHandler) EnrolarMFALogin(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, _, _, mfaActivo, ok := h.usuarioDesafio(c, req.MFAToken)
+	if !ok {
+		return
+	}
+	if mfaActivo {
+		c.JSON(http.StatusConflict, gin.H{"error": "La autenticación en dos pasos ya está activa"})
+		return
+	}
+	enrolamiento, err := h.mfa.Iniciar(c.Request.Context(), user.ID)
+	if err != nil {
+		h.logger.Error("Error al iniciar enrolamiento de dos pasos", zap.Error(err), zap.String("user_id", user.ID.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	c.JSON(http.StatusOK, enrolamiento)
+}
+
+// MFAHandler permite a cada usuario administrar su propia autenticación en dos pasos
+type MFAHandler struct {
+	mfa    MFA
+	logger *zap.Logger
+}
+
+// NewMFAHandler crea el handler. Si mfa es nil los endpoints responden 503.
+func NewMFAHandler(mfa MFA, logger *zap.Logger) *MFAHandler {
+	return &MFAHandler{mfa: mfa, logger: logger}
+}
+
+// usuario devuelve el usuario autenticado; responde y devuelve false si no se puede operar
+func (h *MFAHandler) usuario(c *gin.Context) (uuid.UUID, bool) {
+	if h.mfa == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "La autenticación en dos pasos no está configurada en el servidor"})
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// responderError traduce los errores del servicio de dos pasos
+func (h *MFAHandler) responderError(c *gin.Context, usuarioID uuid.UUID, err error) {
+	switch {
+	case errors.Is(err, services.ErrMFACodigoInvalido):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Código inválido"})
+	case errors.Is(err, services.ErrMFANoIniciado):
+		c.JSON(http.StatusNotFound, gin.H{"error": "La autenticación en dos pasos no está configurada"})
+	case errors.Is(err, services.ErrMFAActivo):
+		c.JSON(http.StatusConflict, gin.H{"error": "La autenticación en dos pasos ya está activa"})
+	case errors.Is(err, services.ErrMFARequerido):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Su rol requiere autenticación en dos pasos"})
+	default:
+		h.logger.Error("Error en autenticación en dos pasos", zap.Error(err), zap.String("user_id", usuarioID.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+	}
+}
+
+type codigoMFARequest struct {
+	Codigo string `json:"codigo" binding:"required"`
+}
+
+// GetEstadoMFA godoc
+// @Summary      Estado de la autenticación en dos pasos
+// @Description  Indica si el usuario autenticado tiene TOTP activo o pendiente, si su rol lo requiere y cuántos códigos de recuperación le quedan
+// @Tags         mfa
+// @Produce      json
+// @Success      200  {object}  services.EstadoMFA
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /api/v1/me/mfa [get]
+func (h *MFAHandler) GetEstadoMFA(c *gin.Context) {
+	id, ok := h.usuario(c)
+	if !ok {
+		return
+	}
+	estado, err := h.mfa.Estado(c.Request.Context(), id)
+	if err != nil {
+		h.responderError(c, id, err)
+		return
+	}
+	c.JSON(http.StatusOK, estado)
+}
+
+// IniciarMFA godoc
+// @Summary      Iniciar enrolamiento TOTP
+// @Description  Genera un secreto TOTP y la URI otpauth:// para mostrar como código QR. Queda pendiente hasta confirmarlo con un código.
+// @Tags         mfa
+// @Produce      json
+// @Success      200  {object}  services.EnrolamientoMFA
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /api/v1/me/mfa [post]
+func (h *MFAHandler) IniciarMFA(c *gin.Context) {
+	id, ok := h.usuario(c)
+	if !ok {
+		return
+	}
+	enrolamiento, err := h.mfa.Iniciar(c.Request.Context(), id)
+	if err != nil {
+		h.responderError(c, id, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrolamiento)
+}
+
+// ConfirmarMFA godoc
+// @Summary      Confirmar enrolamiento TOTP
+// @Description  Activa la autenticación en dos pasos con el primer código de la aplicación y devuelve los códigos de recuperación, que solo se muestran esta vez
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Param        codigo  body  object  true  "Código TOTP"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/me/mfa/confirmar [post]
+func (h *MFAHandler) ConfirmarMFA(c *gin.Context) {
+	id, ok := h.usuario(c)
+	if !ok {
+		return
+	}
+	var req codigoMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entry := audit.FromRequest(c, audit.AccionMFAActivado, "usuarios_mfa", id.String())
+	codigos, err := h.mfa.Confirmar(c.Request.Context(), id, req.Codigo, entry)
+	if err != nil {
+		h.responderError(c, id, err)
+		return
+	}
+	h.logger.Info("Autenticación en dos pasos activada", zap.String("user_id", id.String()))
+	c.JSON(http.StatusOK, gin.H{"message": "Autenticación en dos pasos activada", "codigos_recuperacion": codigos})
+}
+
+// RegenerarCodigosMFA godoc
+// @Summary      Regenerar códigos de recuperación
+// @Description  Invalida los códigos de recuperación anteriores y devuelve otros nuevos. Requiere un código TOTP o de recuperación vigente.
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Param        codigo  body  object  true  "Código TOTP o de recuperación"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Router       /api/v1/me/mfa/recuperacion [post]
+func (h *MFAHandler) RegenerarCodigosMFA(c *gin.Context) {
+	id, ok := h.usuario(c)
+	if !ok {
+		return
+	}
+	var req codigoMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entry := audit.FromRequest(c, audit.AccionMFARecuperacion, "usuarios_mfa", id.String())
+	codigos, err := h.mfa.RegenerarRecuperacion(c.Request.Context(), id, req.Codigo, entry)
+	if err != nil {
+		h.responderError(c, id, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"codigos_recuperacion": codigos})
+}
+
+// DesactivarMFA godoc
+// @Summary      Desactivar la autenticación en dos pasos
+// @Description  Quita el secreto TOTP y los códigos de recuperación. Requiere un código vigente y no se permite si el rol del usuario la requiere.
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Param        codigo  body  object  true  "Código TOTP o de recuperación"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Router       /api/v1/me/mfa [delete]
+func (h *MFAHandler) DesactivarMFA(c *gin.Context) {
+	id, ok := h.usuario(c)
+	if !ok {
+		return
+	}
+	var req codigoMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entry := audit.FromRequest(c, audit.AccionMFADesactivado, "usuarios_mfa", id.String())
+	if err := h.mfa.Desactivar(c.Request.Context(), id, req.Codigo, entry); err != nil {
+		h.responderError(c, id, err)
+		return
+	}
+	h.logger.Info("Autenticación en dos pasos desactivada", zap.String("user_id", id.String()))
+	c.JSON(http.StatusOK, gin.H{"message": "Autenticación en dos pasos desactivada"})
+}
@@ -0,0 +1,680 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/auth"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/FolkodeGroup/mediapp/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// OAuthProvider guarda los clientes, consentimientos y códigos del proveedor OpenID
+// Connect (services.OAuthService)
+type OAuthProvider interface {
+	Cliente(ctx context.Context, clientID string) (services.ClienteOAuth, error)
+	Consentimiento(ctx context.Context, usuarioID, clientID string) ([]string, error)
+	Consentir(ctx context.Context, usuarioID, clientID string, scopes []string, entry audit.Entry) error
+	EmitirCodigo(ctx context.Context, a services.Autorizacion) (string, error)
+	Canjear(ctx context.Context, codigo, clientID, redirectURI, verifier string) (services.Autorizacion, services.RefreshToken, error)
+	Renovar(ctx context.Context, token, clientID string) (services.Autorizacion, services.RefreshToken, error)
+}
+
+// OAuthHandler es el proveedor OpenID Connect de las apps propias (portal de pacientes, app
+// móvil): Authorization Code con PKCE sobre los usuarios de MediApp, y la administración de
+// las apps registradas. El usuario inicia sesión y da su consentimiento en el frontend, que
+// llama a /oauth/authorize con su token.
+type OAuthHandler struct {
+	pool         TxPool
+	oauth        OAuthProvider
+	sesiones     Sesiones
+	issuer       string
+	authorizeURL string
+	logger       *zap.Logger
+}
+
+// NewOAuthHandler crea el handler. issuer es la URL pública del backend y authorizeURL la
+// página de consentimiento del frontend. Con sesiones, cada app autorizada aparece como una
+// sesión del usuario en /me/sessions.
+func NewOAuthHandler(pool TxPool, oauth OAuthProvider, sesiones Sesiones, issuer, authorizeURL string, logger *zap.Logger) *OAuthHandler {
+	return &OAuthHandler{pool: pool, oauth: oauth, sesiones: sesiones, issuer: issuer, authorizeURL: authorizeURL, logger: logger}
+}
+
+// Discovery godoc
+// @Summary      Configuración OpenID Connect
+// @Description  Documento de descubrimiento de OpenID Connect: endpoints, scopes y algoritmos del proveedor
+// @Tags         oauth
+// @Produce      json
+// @Success      200  {object}  auth.Discovery
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /.well-known/openid-configuration [get]
+func (h *OAuthHandler) Discovery(c *gin.Context) {
+	doc, err := auth.CurrentDiscovery(h.issuer, h.authorizeURL)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Claves JWT no configuradas"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, doc)
+}
+
+// solicitudAutorizacion son los parámetros del pedido de autorización (RFC 6749, sección
+// 4.1.1, con PKCE de RFC 7636). El GET los recibe en la query y el POST en JSON.
+type solicitudAutorizacion struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientID            string `form:"client_id" json:"client_id"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	Nonce               string `form:"nonce" json:"nonce"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+	// Aprobar es la respuesta del usuario a la pantalla de consentimiento (solo POST)
+	Aprobar bool `form:"-" json:"aprobar"`
+}
+
+// GetAutorizacion godoc
+// @Summary      Validar pedido de autorización
+// @Description  Valida el pedido de autorización de una app con los parámetros de OAuth2 en la query y devuelve lo que la pantalla de consentimiento debe mostrar. Requiere el token del usuario.
+// @Tags         oauth
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /oauth/authorize [get]
+func (h *OAuthHandler) GetAutorizacion(c *gin.Context) {
+	var req solicitudAutorizacion
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	usuarioID, ok := h.usuarioAutorizante(c)
+	if !ok {
+		return
+	}
+	cliente, scopes, ok := h.validarAutorizacion(c, req)
+	if !ok {
+		return
+	}
+	otorgados, err := h.oauth.Consentimiento(c.Request.Context(), usuarioID.String(), cliente.ClientID)
+	if err != nil {
+		h.logger.Error("Error al consultar consentimiento OAuth", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"cliente":                  gin.H{"client_id": cliente.ClientID, "nombre": cliente.Nombre},
+		"scopes":                   scopes,
+		"consentimiento_requerido": !incluidos(scopes, otorgados),
+	})
+}
+
+// Autorizar godoc
+// @Summary      Autorizar una app
+// @Description  Registra la respuesta del usuario a la pantalla de consentimiento. Devuelve en redirect_to la URL de la app, con el código de autorización (válido 2 minutos y de un solo uso) o con error=access_denied.
+// @Tags         oauth
+// @Accept       json
+// @Produce      json
+// @Param        req  body  object  true  "Parámetros del pedido de autorización y aprobar"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Router       /oauth/authorize [post]
+func (h *OAuthHandler) Autorizar(c *gin.Context) {
+	var req solicitudAutorizacion
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+	usuarioID, ok := h.usuarioAutorizante(c)
+	if !ok {
+		return
+	}
+	cliente, scopes, ok := h.validarAutorizacion(c, req)
+	if !ok {
+		return
+	}
+	if !req.Aprobar {
+		c.JSON(http.StatusOK, gin.H{"redirect_to": h.redireccion(req.RedirectURI, req.State, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"El usuario rechazó la autorización"},
+		})})
+		return
+	}
+
+	ctx := c.Request.Context()
+	otorgados, err := h.oauth.Consentimiento(ctx, usuarioID.String(), cliente.ClientID)
+	if err == nil && !incluidos(scopes, otorgados) {
+		entry := audit.FromRequest(c, audit.AccionAutorizarOAuth, "oauth_clientes", cliente.ClientID)
+		entry.UsuarioID = &usuarioID
+		entry.Antes = gin.H{"scopes": otorgados}
+		entry.Despues = gin.H{"scopes": scopes}
+		err = h.oauth.Consentir(ctx, usuarioID.String(), cliente.ClientID, scopes, entry)
+	}
+	var codigo string
+	if err == nil {
+		// auth_time es el login del que sale el token con el que se autoriza
+		autenticado := time.Now()
+		if iat, ok := c.Get("token_iat"); ok {
+			if t, ok := iat.(time.Time); ok {
+				autenticado = t
+			}
+		}
+		codigo, err = h.oauth.EmitirCodigo(ctx, services.Autorizacion{
+			ClientID:      cliente.ClientID,
+			UsuarioID:     usuarioID.String(),
+			RedirectURI:   req.RedirectURI,
+			Scopes:        scopes,
+			Nonce:         req.Nonce,
+			CodeChallenge: req.CodeChallenge,
+			AutenticadoEn: autenticado,
+		})
+	}
+	if err != nil {
+		h.logger.Error("Error al autorizar app OAuth", zap.Error(err), zap.String("client_id", cliente.ClientID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	h.logger.Info("App OAuth autorizada", zap.String("client_id", cliente.ClientID), zap.String("user_id", usuarioID.String()))
+	c.JSON(http.StatusOK, gin.H{"redirect_to": h.redireccion(req.RedirectURI, req.State, url.Values{"code": {codigo}})})
+}
+
+// usuarioAutorizante devuelve el usuario del token. Un token emitido a otra app no sirve
+// para autorizar: solo el login propio de MediApp.
+func (h *OAuthHandler) usuarioAutorizante(c *gin.Context) (uuid.UUID, bool) {
+	usuarioID, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return uuid.Nil, false
+	}
+	if _, deApp := c.Get("oauth_client_id"); deApp {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Un token emitido a una app no puede autorizar otras"})
+		return uuid.Nil, false
+	}
+	return usuarioID, true
+}
+
+// validarAutorizacion valida el pedido y devuelve el cliente y los scopes pedidos. Si el
+// cliente o la redirect_uri no son válidos se responde 400 sin redirección (RFC 6749,
+// sección 4.1.2.1); los demás errores incluyen en redirect_to la respuesta para la app.
+func (h *OAuthHandler) validarAutorizacion(c *gin.Context, req solicitudAutorizacion) (services.ClienteOAuth, []string, bool) {
+	cliente, err := h.oauth.Cliente(c.Request.Context(), req.ClientID)
+	if errors.Is(err, services.ErrClienteOAuthInvalido) || (err == nil && !cliente.RedirectURIValida(req.RedirectURI)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "client_id o redirect_uri no registrados"})
+		return services.ClienteOAuth{}, nil, false
+	} else if err != nil {
+		h.logger.Error("Error al buscar cliente OAuth", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return services.ClienteOAuth{}, nil, false
+	}
+
+	fallo := func(codigo, descripcion string) (services.ClienteOAuth, []string, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             codigo,
+			"error_description": descripcion,
+			"redirect_to": h.redireccion(req.RedirectURI, req.State, url.Values{
+				"error":             {codigo},
+				"error_description": {descripcion},
+			}),
+		})
+		return services.ClienteOAuth{}, nil, false
+	}
+	if req.ResponseType != "code" {
+		return fallo("unsupported_response_type", "Solo se admite response_type=code")
+	}
+	if req.CodeChallengeMethod != "S256" || len(req.CodeChallenge) != 43 {
+		return fallo("invalid_request", "PKCE obligatorio: code_challenge con code_challenge_method=S256")
+	}
+	scopes, ok := scopesPedidos(req.Scope, cliente.Scopes)
+	if !ok {
+		return fallo("invalid_scope", "Se requiere el scope openid y solo los registrados para la app")
+	}
+	return cliente, scopes, true
+}
+
+// scopesPedidos separa scope (separado por espacios) sin repetidos. Debe incluir openid y
+// solo scopes permitidos.
+func scopesPedidos(scope string, permitidos []string) ([]string, bool) {
+	scopes := []string{}
+	for _, s := range strings.Fields(scope) {
+		if !incluidos([]string{s}, permitidos) {
+			return nil, false
+		}
+		if !incluidos([]string{s}, scopes) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, incluidos([]string{auth.ScopeOpenID}, scopes)
+}
+
+// incluidos indica si todos los elementos de a están en b
+func incluidos(a, b []string) bool {
+	for _, x := range a {
+		encontrado := false
+		for _, y := range b {
+			if x == y {
+				encontrado = true
+				break
+			}
+		}
+		if !encontrado {
+			return false
+		}
+	}
+	return true
+}
+
+// redireccion arma la URL de vuelta a la app con params, el state y el iss (RFC 9207) para
+// que la app verifique quién responde
+func (h *OAuthHandler) redireccion(redirectURI, state string, params url.Values) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if state != "" {
+		q.Set("state", state)
+	}
+	q.Set("iss", h.issuer)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// Token godoc
+// @Summary      Emitir tokens OAuth
+// @Description  Canjea un código de autorización (grant_type=authorization_code, con code_verifier de PKCE) o un refresh token (grant_type=refresh_token) por un access token, un refresh token y un ID token. Los clientes confidenciales se autentican con su secreto por HTTP Basic o client_secret.
+// @Tags         oauth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Router       /oauth/token [post]
+func (h *OAuthHandler) Token(c *gin.Context) {
+	// RFC 6749, sección 5.1: la respuesta no se cachea
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cliente, ok := h.autenticarCliente(c, ctx)
+	if !ok {
+		return
+	}
+
+	var a services.Autorizacion
+	var rt services.RefreshToken
+	var err error
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		a, rt, err = h.oauth.Canjear(ctx, c.PostForm("code"), cliente.ClientID, c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+	case "refresh_token":
+		a, rt, err = h.oauth.Renovar(ctx, c.PostForm("refresh_token"), cliente.ClientID)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type", "error_description": "Solo se admiten authorization_code y refresh_token"})
+		return
+	}
+	if errors.Is(err, services.ErrGrantInvalido) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "Código o refresh token inválido, vencido o ya usado"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al canjear grant OAuth", zap.Error(err), zap.String("client_id", cliente.ClientID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	u, err := h.usuario(ctx, a.UsuarioID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "Usuario inexistente o desactivado"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al buscar usuario OAuth", zap.Error(err), zap.String("user_id", a.UsuarioID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	// La familia de refresh tokens es la sesión de la app, con su nombre como dispositivo
+	if h.sesiones != nil {
+		if err := h.sesiones.Registrar(ctx, rt.Familia, a.UsuarioID, cliente.Nombre, utils.GetRealIP(c.Request)); err != nil {
+			h.logger.Error("Error al registrar la sesión", zap.Error(err), zap.String("user_id", a.UsuarioID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+	}
+
+	consultorio := ""
+	if u.ConsultorioID != nil {
+		consultorio = u.ConsultorioID.String()
+	}
+	scope := strings.Join(a.Scopes, " ")
+	accessToken, err := auth.GenerateClientToken(a.UsuarioID, u.RolID, consultorio, rt.Familia, cliente.ClientID, scope)
+	var idToken string
+	if err == nil {
+		claims := u.claims(a.Scopes)
+		claims.Subject = a.UsuarioID
+		claims.Nonce = a.Nonce
+		claims.AuthTime = a.AutenticadoEn.Unix()
+		idToken, err = auth.GenerateIDToken(h.issuer, cliente.ClientID, claims)
+	}
+	if err != nil {
+		h.logger.Error("Error al firmar tokens OAuth", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(auth.AccessTokenTTL.Seconds()),
+		"refresh_token": rt.Token,
+		"id_token":      idToken,
+		"scope":         scope,
+	})
+}
+
+// autenticarCliente identifica al cliente por HTTP Basic o por client_id y client_secret
+// en el formulario. Un cliente público no presenta secreto; uno confidencial debe hacerlo.
+func (h *OAuthHandler) autenticarCliente(c *gin.Context, ctx context.Context) (services.ClienteOAuth, bool) {
+	clientID, secreto, basic := c.Request.BasicAuth()
+	if !basic {
+		clientID, secreto = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	cliente, err := h.oauth.Cliente(ctx, clientID)
+	if err != nil && !errors.Is(err, services.ErrClienteOAuthInvalido) {
+		h.logger.Error("Error al buscar cliente OAuth", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return services.ClienteOAuth{}, false
+	}
+	if err != nil || cliente.Confidencial() != (secreto != "") ||
+		(cliente.Confidencial() && !cliente.VerificarSecreto(secreto)) {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="mediapp"`)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client", "error_description": "Cliente desconocido o credenciales inválidas"})
+		return services.ClienteOAuth{}, false
+	}
+	return cliente, true
+}
+
+// UserInfo godoc
+// @Summary      Datos del usuario (OpenID Connect)
+// @Description  Devuelve los claims del usuario según los scopes autorizados a la app. Requiere un access token emitido por /oauth/token con el scope openid.
+// @Tags         oauth
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Router       /oauth/userinfo [get]
+func (h *OAuthHandler) UserInfo(c *gin.Context) {
+	valor, _ := c.Get("oauth_scope")
+	scope, _ := valor.(string)
+	scopes := strings.Fields(scope)
+	if !incluidos([]string{auth.ScopeOpenID}, scopes) {
+		c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		c.JSON(http.StatusForbidden, gin.H{"error": "insufficient_scope", "error_description": "Se requiere un token de una app con el scope openid"})
+		return
+	}
+	usuarioID, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	u, err := h.usuario(c.Request.Context(), usuarioID.String())
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al buscar usuario OAuth", zap.Error(err), zap.String("user_id", usuarioID.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	claims := u.claims(scopes)
+	info := gin.H{"sub": usuarioID.String()}
+	if claims.PreferredUsername != "" {
+		info["preferred_username"] = claims.PreferredUsername
+	}
+	if claims.EmailVerified != nil {
+		info["email"] = claims.Email
+		info["email_verified"] = *claims.EmailVerified
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// usuarioOIDC son los datos del usuario que necesitan los tokens de las apps
+type usuarioOIDC struct {
+	Nombre          string
+	Email           string
+	EmailVerificado bool
+	RolID           int
+	ConsultorioID   *uuid.UUID
+}
+
+// usuario busca un usuario activo; pgx.ErrNoRows si no existe o está desactivado
+func (h *OAuthHandler) usuario(ctx context.Context, id string) (usuarioOIDC, error) {
+	var u usuarioOIDC
+	err := h.pool.QueryRow(ctx, `
+		SELECT nombre, email, email_verificado_en IS NOT NULL, rol_id, consultorio_id
+		FROM usuarios WHERE id = $1 AND activo = true
+	`, id).Scan(&u.Nombre, &u.Email, &u.EmailVerificado, &u.RolID, &u.ConsultorioID)
+	return u, err
+}
+
+// claims devuelve los claims de perfil y email que permiten los scopes
+func (u usuarioOIDC) claims(scopes []string) auth.IDTokenClaims {
+	var claims auth.IDTokenClaims
+	if incluidos([]string{auth.ScopeProfile}, scopes) {
+		claims.PreferredUsername = u.Nombre
+	}
+	if incluidos([]string{auth.ScopeEmail}, scopes) {
+		verificado := u.EmailVerificado
+		claims.Email = u.Email
+		claims.EmailVerified = &verificado
+	}
+	return claims
+}
+
+// ClienteOAuthAdmin es una app registrada tal como la ve la administración; nunca incluye
+// el secreto ni su hash
+type ClienteOAuthAdmin struct {
+	ClientID     string     `json:"client_id"`
+	Nombre       string     `json:"nombre"`
+	RedirectURIs []string   `json:"redirect_uris"`
+	Scopes       []string   `json:"scopes"`
+	Confidencial bool       `json:"confidencial"`
+	CreadoPor    *uuid.UUID `json:"creado_por"`
+	CreadoEn     time.Time  `json:"creado_en"`
+	RevocadoEn   *time.Time `json:"revocado_en"`
+}
+
+// GetClientesOAuth godoc
+// @Summary      Listar apps OAuth
+// @Description  Lista las apps registradas en el proveedor OpenID Connect, incluidas las revocadas
+// @Tags         oauth
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Router       /api/v1/oauth/clientes [get]
+func (h *OAuthHandler) GetClientesOAuth(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.pool.Query(ctx, `
+		SELECT client_id, nombre, redirect_uris, scopes, secreto_hash IS NOT NULL, creado_por, creado_en, revocado_en
+		FROM oauth_clientes ORDER BY creado_en DESC
+	`)
+	if err != nil {
+		h.logger.Error("Error al consultar clientes OAuth", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	clientes := make([]ClienteOAuthAdmin, 0)
+	for rows.Next() {
+		var k ClienteOAuthAdmin
+		if err := rows.Scan(&k.ClientID, &k.Nombre, &k.RedirectURIs, &k.Scopes, &k.Confidencial,
+			&k.CreadoPor, &k.CreadoEn, &k.RevocadoEn); err != nil {
+			h.logger.Error("Error al escanear cliente OAuth", zap.Error(err))
+			continue
+		}
+		clientes = append(clientes, k)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "clientes": clientes, "total": len(clientes)})
+}
+
+// CrearClienteOAuth godoc
+// @Summary      Registrar app OAuth
+// @Description  Registra una app. Las redirect_uris deben ser https, http a localhost o un esquema propio de app móvil (com.ejemplo.app:/callback). Una app confidencial recibe un secreto que se muestra solo en esta respuesta.
+// @Tags         oauth
+// @Accept       json
+// @Produce      json
+// @Param        req  body  object  true  "nombre, redirect_uris, scopes (opcional, todos por defecto) y confidencial"
+// @Success      201  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /api/v1/oauth/clientes [post]
+func (h *OAuthHandler) CrearClienteOAuth(c *gin.Context) {
+	var req struct {
+		Nombre       string   `json:"nombre" binding:"required,max=100"`
+		RedirectURIs []string `json:"redirect_uris" binding:"required,min=1,dive,required"`
+		Scopes       []string `json:"scopes"`
+		Confidencial bool     `json:"confidencial"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, uri := range req.RedirectURIs {
+		if !redirectURIValida(uri) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "redirect_uri inválida: " + uri})
+			return
+		}
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = auth.ScopesSoportados
+	}
+	if !incluidos(req.Scopes, auth.ScopesSoportados) || !incluidos([]string{auth.ScopeOpenID}, req.Scopes) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Scopes inválidos: se requiere openid", "scopes_soportados": auth.ScopesSoportados})
+		return
+	}
+
+	clientID, secreto, hash, err := services.GenerarClienteOAuth(req.Confidencial)
+	if err != nil {
+		h.logger.Error("Error al generar cliente OAuth", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	k := ClienteOAuthAdmin{
+		ClientID:     clientID,
+		Nombre:       strings.TrimSpace(req.Nombre),
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		Confidencial: req.Confidencial,
+		CreadoEn:     time.Now(),
+	}
+	if usuarioID, ok := usuarioActual(c); ok {
+		k.CreadoPor = &usuarioID
+	}
+	var secretoHash *string
+	if req.Confidencial {
+		secretoHash = &hash
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// El secreto y su hash nunca se guardan en la auditoría
+	entry := audit.FromRequest(c, audit.AccionCrearClienteOAuth, "oauth_clientes", k.ClientID)
+	entry.Despues = k
+	if err := audit.ExecTx(ctx, h.pool, entry, `
+		INSERT INTO oauth_clientes (client_id, nombre, redirect_uris, scopes, secreto_hash, creado_por, creado_en)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, k.ClientID, k.Nombre, k.RedirectURIs, k.Scopes, secretoHash, k.CreadoPor, k.CreadoEn); err != nil {
+		h.logger.Error("Error al crear cliente OAuth", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	h.logger.Info("Cliente OAuth creado", zap.String("client_id", k.ClientID), zap.String("nombre", k.Nombre))
+	resp := gin.H{"message": "App registrada", "cliente": k}
+	if req.Confidencial {
+		resp["message"] = "App registrada. Guarde el secreto: no se volverá a mostrar"
+		resp["client_secret"] = secreto
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// redirectURIValida acepta URLs https, http solo a localhost (desarrollo y apps de
+// escritorio) y esquemas propios con nombre de dominio invertido para apps móviles
+// (RFC 8252). Sin fragmento.
+func redirectURIValida(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Fragment != "" {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	case "":
+		return false
+	}
+	return strings.Contains(u.Scheme, ".")
+}
+
+// RevocarClienteOAuth godoc
+// @Summary      Revocar app OAuth
+// @Description  Revoca una app: deja de poder autorizar usuarios y renovar tokens. Los access tokens ya emitidos valen hasta su vencimiento salvo que se cierren sus sesiones.
+// @Tags         oauth
+// @Produce      json
+// @Param        client_id  path  string  true  "client_id de la app"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/oauth/clientes/{client_id} [delete]
+func (h *OAuthHandler) RevocarClienteOAuth(c *gin.Context) {
+	clientID := c.Param("client_id")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	revocadoEn := time.Now()
+	entry := audit.FromRequest(c, audit.AccionRevocarClienteOAuth, "oauth_clientes", clientID)
+	entry.Despues = gin.H{"revocado_en": revocadoEn}
+	tx, err := h.pool.Begin(ctx)
+	if err == nil {
+		defer tx.Rollback(ctx)
+		err = tx.QueryRow(ctx, `
+			UPDATE oauth_clientes SET revocado_en = $2
+			WHERE client_id = $1 AND revocado_en IS NULL
+			RETURNING client_id
+		`, clientID, revocadoEn).Scan(&clientID)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "App no encontrada o ya revocada"})
+		return
+	}
+	if err == nil {
+		err = audit.Write(ctx, tx, entry)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al revocar cliente OAuth", zap.Error(err), zap.String("client_id", clientID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	h.logger.Info("Cliente OAuth revocado", zap.String("client_id", clientID))
+	c.JSON(http.StatusOK, gin.H{"message": "App revocada"})
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/auth"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// fakeOAuth tiene un único cliente público y guarda los códigos en memoria
+type fakeOAuth struct {
+	cliente    services.ClienteOAuth
+	otorgados  []string
+	auditorias []audit.Entry
+	codigos    map[string]services.Autorizacion
+}
+
+func (f *fakeOAuth) Cliente(ctx context.Context, clientID string) (services.ClienteOAuth, error) {
+	if clientID != f.cliente.ClientID {
+		return services.ClienteOAuth{}, services.ErrClienteOAuthInvalido
+	}
+	return f.cliente, nil
+}
+func (f *fakeOAuth) Consentimiento(ctx context.Context, usuarioID, clientID string) ([]string, error) {
+	return f.otorgados, nil
+}
+func (f *fakeOAuth) Consentir(ctx context.Context, usuarioID, clientID string, scopes []string, entry audit.Entry) error {
+	f.otorgados = scopes
+	f.auditorias = append(f.auditorias, entry)
+	return nil
+}
+func (f *fakeOAuth) EmitirCodigo(ctx context.Context, a services.Autorizacion) (string, error) {
+	codigo := uuid.NewString()
+	f.codigos[codigo] = a
+	return codigo, nil
+}
+func (f *fakeOAuth) Canjear(ctx context.Context, codigo, clientID, redirectURI, verifier string) (services.Autorizacion, services.RefreshToken, error) {
+	a, ok := f.codigos[codigo]
+	delete(f.codigos, codigo)
+	if !ok || a.ClientID != clientID || a.RedirectURI != redirectURI || !auth.VerifyPKCE(verifier, a.CodeChallenge) {
+		return services.Autorizacion{}, services.RefreshToken{}, services.ErrGrantInvalido
+	}
+	return a, services.RefreshToken{Token: "rt-" + codigo, UsuarioID: a.UsuarioID, Familia: "familia-" + codigo}, nil
+}
+func (f *fakeOAuth) Renovar(ctx context.Context, token, clientID string) (services.Autorizacion, services.RefreshToken, error) {
+	return services.Autorizacion{}, services.RefreshToken{}, services.ErrGrantInvalido
+}
+
+// TestOAuthAuthorizationCode recorre el flujo completo: consentimiento, código, canje con
+// PKCE, ID token y userinfo
+func TestOAuthAuthorizationCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.Init(zap.NewNop(), nil)
+
+	usuarioID := uuid.New()
+	consultorio := uuid.New()
+	oauth := &fakeOAuth{
+		cliente: services.ClienteOAuth{
+			ClientID:     "portal",
+			Nombre:       "Portal de pacientes",
+			RedirectURIs: []string{"https://portal.mediapp.test/callback"},
+			Scopes:       []string{"openid", "email"},
+		},
+		codigos: map[string]services.Autorizacion{},
+	}
+	pool := &mockTxPool{}
+	pool.queryRowFunc = func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			if args[0] != usuarioID.String() {
+				return pgx.ErrNoRows
+			}
+			setDest(dest, 0, "ana")
+			setDest(dest, 1, "ana@mediapp.test")
+			setDest(dest, 2, true)
+			setDest(dest, 3, 2)
+			*dest[4].(**uuid.UUID) = &consultorio
+			return nil
+		}}
+	}
+	sesiones := &fakeSesiones{}
+	h := NewOAuthHandler(pool, oauth, sesiones, "https://api.mediapp.test", "https://app.mediapp.test/oauth/authorize", zap.NewNop())
+
+	// El token del usuario lo valida el middleware JWT; aquí se simulan sus claims
+	scopeApp := ""
+	router := gin.New()
+	usuario := func(c *gin.Context) {
+		c.Set("user_id", usuarioID.String())
+		c.Set("token_iat", time.Now().Add(-time.Minute))
+		if scopeApp != "" {
+			c.Set("oauth_client_id", "portal")
+			c.Set("oauth_scope", scopeApp)
+		}
+	}
+	router.GET("/oauth/authorize", usuario, h.GetAutorizacion)
+	router.POST("/oauth/authorize", usuario, h.Autorizar)
+	router.POST("/oauth/token", h.Token)
+	router.GET("/oauth/userinfo", usuario, h.UserInfo)
+
+	pedir := func(req *http.Request) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var body map[string]interface{}
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"portal"},
+		"redirect_uri":          {"https://portal.mediapp.test/callback"},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {"lJf1Eh9TKpIAo6CP3Qheaa3ypVj5G9PqvAOmaBuQYew"},
+		"code_challenge_method": {"S256"},
+	}
+	autorizar := func(p url.Values, aprobar bool) (int, map[string]interface{}) {
+		body := gin.H{"aprobar": aprobar}
+		for k := range p {
+			body[k] = p.Get(k)
+		}
+		raw, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", "/oauth/authorize", strings.NewReader(string(raw)))
+		req.Header.Set("Content-Type", "application/json")
+		return pedir(req)
+	}
+	con := func(k, v string) url.Values {
+		p := url.Values{}
+		for clave, valor := range params {
+			p[clave] = valor
+		}
+		p.Set(k, v)
+		return p
+	}
+
+	// Una redirect_uri no registrada no se usa para redirigir
+	req, _ := http.NewRequest("GET", "/oauth/authorize?"+con("redirect_uri", "https://evil.test/cb").Encode(), nil)
+	if code, body := pedir(req); code != http.StatusBadRequest || body["redirect_to"] != nil {
+		t.Errorf("Se esperaba 400 sin redirección, obtuvo %d %v", code, body)
+	}
+	for _, p := range []url.Values{con("code_challenge_method", "plain"), con("scope", "email"), con("scope", "openid profile")} {
+		req, _ = http.NewRequest("GET", "/oauth/authorize?"+p.Encode(), nil)
+		code, body := pedir(req)
+		destino, _ := body["redirect_to"].(string)
+		if code != http.StatusBadRequest || !strings.Contains(destino, "state=xyz") || !strings.Contains(destino, "error=") {
+			t.Errorf("Se esperaba 400 con error para la app, obtuvo %d %v", code, body)
+		}
+	}
+	req, _ = http.NewRequest("GET", "/oauth/authorize?"+params.Encode(), nil)
+	if code, body := pedir(req); code != http.StatusOK || body["consentimiento_requerido"] != true {
+		t.Fatalf("Se esperaba pedir consentimiento, obtuvo %d %v", code, body)
+	}
+
+	if _, body := autorizar(params, false); !strings.Contains(body["redirect_to"].(string), "error=access_denied") {
+		t.Errorf("Se esperaba access_denied al rechazar, obtuvo %v", body)
+	}
+	code, body := autorizar(params, true)
+	destino, _ := url.Parse(body["redirect_to"].(string))
+	if code != http.StatusOK || destino.Query().Get("state") != "xyz" || destino.Query().Get("iss") != "https://api.mediapp.test" {
+		t.Fatalf("Redirección inesperada: %d %v", code, body)
+	}
+	if len(oauth.auditorias) != 1 || oauth.auditorias[0].Accion != audit.AccionAutorizarOAuth {
+		t.Errorf("Se esperaba auditar el consentimiento: %+v", oauth.auditorias)
+	}
+
+	canjear := func(form url.Values) (int, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return pedir(req)
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {destino.Query().Get("code")},
+		"redirect_uri":  {"https://portal.mediapp.test/callback"},
+		"client_id":     {"portal"},
+		"code_verifier": {"dBjftJeZ4CVP-mJ92K9qV5p7R3BAWzBiEb4UuPVVAr0"},
+	}
+	conSecreto := url.Values{"client_secret": {"secreto"}}
+	for k, v := range form {
+		conSecreto[k] = v
+	}
+	if code, body := canjear(conSecreto); code != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Errorf("Un cliente público no presenta secreto: %d %v", code, body)
+	}
+
+	code, body = canjear(form)
+	if code != http.StatusOK {
+		t.Fatalf("Se esperaba 200, obtuvo %d %v", code, body)
+	}
+	access, err := auth.ValidateToken(body["access_token"].(string))
+	if err != nil || access.UserID != usuarioID.String() || access.ClientID != "portal" ||
+		access.Scope != "openid email" || access.ConsultorioID != consultorio.String() {
+		t.Errorf("Access token inesperado: %+v %v", access, err)
+	}
+	idToken := &auth.IDTokenClaims{}
+	jwt.NewParser().ParseUnverified(body["id_token"].(string), idToken)
+	if idToken.Subject != usuarioID.String() || idToken.Nonce != "n-0S6_WzA2Mj" || idToken.Email != "ana@mediapp.test" ||
+		idToken.PreferredUsername != "" || idToken.Audience[0] != "portal" {
+		t.Errorf("ID token inesperado: %+v", idToken)
+	}
+	if s := sesiones.sesiones[access.SesionID]; s.Dispositivo != "Portal de pacientes" {
+		t.Errorf("Se esperaba registrar la sesión de la app: %+v", sesiones.sesiones)
+	}
+	if code, body := canjear(form); code != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("Se esperaba invalid_grant al reutilizar el código, obtuvo %d %v", code, body)
+	}
+
+	// userinfo solo con un token de app con openid, y solo los claims autorizados
+	req, _ = http.NewRequest("GET", "/oauth/userinfo", nil)
+	if code, _ := pedir(req); code != http.StatusForbidden {
+		t.Errorf("Se esperaba 403 con un token sin scopes, obtuvo %d", code)
+	}
+	scopeApp = access.Scope
+	if code, body := pedir(req); code != http.StatusOK || body["email"] != "ana@mediapp.test" || body["preferred_username"] != nil {
+		t.Errorf("userinfo inesperado: %d %v", code, body)
+	}
+	// Un token de una app no autoriza otras
+	if code, _ := autorizar(params, true); code != http.StatusForbidden {
+		t.Errorf("Se esperaba 403 al autorizar con un token de app, obtuvo %d", code)
+	}
+}
+
+func TestRedirectURIValida(t *testing.T) {
+	validas := []string{"https://portal.mediapp.test/callback", "http://localhost:5173/cb", "http://127.0.0.1/cb", "com.mediapp.app:/oauth"}
+	invalidas := []string{"http://portal.mediapp.test/cb", "https://portal.mediapp.test/cb#x", "javascript:alert(1)", "/callback", "https:///cb"}
+	for _, uri := range validas {
+		if !redirectURIValida(uri) {
+			t.Errorf("%s: se esperaba válida", uri)
+		}
+	}
+	for _, uri := range invalidas {
+		if redirectURIValida(uri) {
+			t.Errorf("%s: se esperaba inválida", uri)
+		}
+	}
+}
@@ -2,9 +2,18 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/pagination"
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -13,23 +22,84 @@ import (
 	"go.uber.org/zap"
 )
 
+// PacienteAlta son los datos del alta. El DNI solo se usa para detectar duplicados; se
+// guarda cifrado con PUT /pacientes/:id/datos-personales.
+type PacienteAlta struct {
+	Paciente
+	DNI *string `json:"dni,omitempty"`
+}
+
 func (h *PacienteHandler) CreatePaciente(c *gin.Context) {
-	var input Paciente
+	var input PacienteAlta
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if input.DNI != nil {
+		dni := NormalizarDNI(*input.DNI)
+		if !dniValido(dni) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dni inválido, se esperan entre 6 y 10 dígitos"})
+			return
+		}
+		input.DNI = &dni
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	// El paciente pertenece al consultorio del request; solo en alcance global
+	// se toma el consultorio del body
+	if !scope.Global {
+		input.ConsultorioID = ptrString(scope.ConsultorioID.String())
+	} else if input.ConsultorioID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "consultorio_id es obligatorio al operar sobre todos los consultorios"})
+		return
+	}
+	// El creador es el usuario autenticado, no un valor del body; con una API key queda vacío
+	input.CreadoPorUsuario = nil
+	if usuarioID, ok := usuarioActual(c); ok {
+		input.CreadoPorUsuario = ptrString(usuarioID.String())
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	id := uuid.New().String()
 	creadoEn := time.Now().UTC().Format(time.RFC3339)
 
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear el paciente"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Un duplicado probable frena el alta salvo que se confirme con ?forzar=true
+	candidatos, err := h.buscarDuplicados(ctx, tx, DuplicadoInput{
+		Nombre:          input.Nombre,
+		Apellido:        input.Apellido,
+		FechaNacimiento: input.FechaNacimiento,
+		NroCredencial:   input.NroCredencial,
+		DNI:             input.DNI,
+	}, *input.ConsultorioID)
+	if err != nil {
+		h.logger.Error("Error al buscar duplicados", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear el paciente"})
+		return
+	}
+	if c.Query("forzar") != "true" && hayDuplicadoProbable(candidatos) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Posible paciente duplicado; revise los candidatos o confirme con forzar=true",
+			"candidatos": candidatos,
+		})
+		return
+	}
+
 	query := `
 	       INSERT INTO pacientes (id, nombre, apellido, fecha_nacimiento, nro_credencial, obra_social, condicion_iva, plan, creado_por_usuario, consultorio_id, creado_en)
 	       VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
        `
-	_, err := h.pool.Exec(ctx, query,
+	_, err = tx.Exec(ctx, query,
 		id,
 		input.Nombre,
 		input.Apellido,
@@ -44,13 +114,29 @@ func (h *PacienteHandler) CreatePaciente(c *gin.Context) {
 	)
 	if err != nil {
 		h.logger.Error("Error al crear paciente", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear el paciente", "detalle": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear el paciente"})
+		return
+	}
+
+	despues, err := audit.Snapshot(ctx, tx, "pacientes", id, false)
+	if err == nil {
+		entry := audit.FromRequest(c, audit.AccionCrear, "pacientes", id)
+		entry.Despues = despues
+		err = audit.Write(ctx, tx, entry)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al auditar creación de paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo crear el paciente"})
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "Paciente creado exitosamente",
-		"id":      id,
+		"message":             "Paciente creado exitosamente",
+		"id":                  id,
+		"posibles_duplicados": candidatos,
 	})
 }
 
@@ -68,21 +154,48 @@ func (h *PacienteHandler) CreatePaciente(c *gin.Context) {
 // @Failure      500  {object}  map[string]interface{}
 // @Router       /api/v1/pacientes/{id} [put]
 func (h *PacienteHandler) UpdatePaciente(c *gin.Context) {
-	id := c.Param("id")
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return
+	}
 	var input Paciente
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	// Solo el alcance global puede mover un paciente a otro consultorio
+	if !scope.Global {
+		input.ConsultorioID = nil
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	query := `
-	       UPDATE pacientes SET nombre=$1, apellido=$2, fecha_nacimiento=$3, nro_credencial=$4, obra_social=$5, condicion_iva=$6, plan=$7, creado_por_usuario=$8, consultorio_id=$9
-	       WHERE id=$10
-       `
-	res, err := h.pool.Exec(ctx, query,
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo actualizar el paciente"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	antes, err := snapshotPaciente(ctx, tx, scope, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Paciente no encontrado"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al leer paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo actualizar el paciente"})
+		return
+	}
+
+	args := []interface{}{
 		input.Nombre,
 		input.Apellido,
 		input.FechaNacimiento,
@@ -90,19 +203,40 @@ func (h *PacienteHandler) UpdatePaciente(c *gin.Context) {
 		input.ObraSocial,
 		input.CondicionIVA,
 		input.Plan,
-		input.CreadoPorUsuario,
 		input.ConsultorioID,
 		id,
-	)
+	}
+	// creado_por_usuario no se modifica: lo fija el alta con el usuario autenticado
+	query := `
+	       UPDATE pacientes SET nombre=$1, apellido=$2, fecha_nacimiento=$3, nro_credencial=$4, obra_social=$5, condicion_iva=$6, plan=$7, consultorio_id=COALESCE($8, consultorio_id)
+	       WHERE id=$9 AND ` + scope.Consultorio("consultorio_id", &args) + `
+       `
+	res, err := tx.Exec(ctx, query, args...)
 	if err != nil {
 		h.logger.Error("Error al actualizar paciente", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo actualizar el paciente", "detalle": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo actualizar el paciente"})
 		return
 	}
 	if res.RowsAffected() == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Paciente no encontrado"})
 		return
 	}
+
+	despues, err := audit.Snapshot(ctx, tx, "pacientes", id, false)
+	if err == nil {
+		entry := audit.FromRequest(c, audit.AccionActualizar, "pacientes", id.String())
+		entry.Antes = antes
+		entry.Despues = despues
+		err = audit.Write(ctx, tx, entry)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al auditar actualización de paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo actualizar el paciente"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Paciente actualizado exitosamente"})
 }
 
@@ -113,28 +247,83 @@ func (h *PacienteHandler) UpdatePaciente(c *gin.Context) {
 // @Produce      json
 // @Param        id   path      string  true  "ID del paciente"
 // @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
 // @Failure      404  {object}  map[string]interface{}
 // @Failure      500  {object}  map[string]interface{}
 // @Router       /api/v1/pacientes/{id} [delete]
 func (h *PacienteHandler) DeletePaciente(c *gin.Context) {
-	id := c.Param("id")
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	query := `DELETE FROM pacientes WHERE id=$1`
-	res, err := h.pool.Exec(ctx, query, id)
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo eliminar el paciente"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	antes, err := snapshotPaciente(ctx, tx, scope, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Paciente no encontrado"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al leer paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo eliminar el paciente"})
+		return
+	}
+
+	args := []interface{}{id}
+	query := `DELETE FROM pacientes WHERE id=$1 AND ` + scope.Consultorio("consultorio_id", &args)
+	res, err := tx.Exec(ctx, query, args...)
 	if err != nil {
 		h.logger.Error("Error al eliminar paciente", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo eliminar el paciente", "detalle": err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo eliminar el paciente"})
 		return
 	}
 	if res.RowsAffected() == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Paciente no encontrado"})
 		return
 	}
+
+	entry := audit.FromRequest(c, audit.AccionEliminar, "pacientes", id.String())
+	entry.Antes = antes
+	err = audit.Write(ctx, tx, entry)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al auditar eliminación de paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo eliminar el paciente"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Paciente eliminado exitosamente"})
 }
 
+// snapshotPaciente lee y bloquea la fila del paciente para auditarla, solo si es del
+// consultorio activo: un request de otro consultorio no llega a bloquearla
+func snapshotPaciente(ctx context.Context, tx pgx.Tx, scope tenant.Scope, id uuid.UUID) (json.RawMessage, error) {
+	args := []interface{}{id}
+	var snapshot []byte
+	err := tx.QueryRow(ctx, `
+		SELECT row_to_json(t) FROM pacientes t WHERE t.id = $1 AND `+scope.Consultorio("t.consultorio_id", &args)+` FOR UPDATE
+	`, args...).Scan(&snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(snapshot), nil
+}
+
 // PoolTX define los métodos mínimos que el handler de pacientes necesita de un pool
 type PoolTX interface {
 	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
@@ -146,18 +335,27 @@ type PoolTX interface {
 
 // PacienteHandler maneja las operaciones relacionadas con pacientes
 type PacienteHandler struct {
-	pool   PoolTX
+	pool   TxPool
+	dni    DescifradorDNI
 	logger *zap.Logger
 }
 
 // NewPacienteHandler crea una nueva instancia del handler de pacientes
-func NewPacienteHandler(pool PoolTX, logger *zap.Logger) *PacienteHandler {
+func NewPacienteHandler(pool TxPool, logger *zap.Logger) *PacienteHandler {
 	return &PacienteHandler{
 		pool:   pool,
 		logger: logger,
 	}
 }
 
+// NewPacienteHandlerWithDNI crea el handler con un descifrador de DNI, que la
+// detección de duplicados usa para comparar el DNI de datos_personales
+func NewPacienteHandlerWithDNI(pool TxPool, dni DescifradorDNI, logger *zap.Logger) *PacienteHandler {
+	h := NewPacienteHandler(pool, logger)
+	h.dni = dni
+	return h
+}
+
 // Paciente representa la estructura de la tabla 'pacientes' normalizada
 type Paciente struct {
 	ID               string  `json:"id" db:"id"`
@@ -173,14 +371,98 @@ type Paciente struct {
 	CreadoEn         string  `json:"creado_en" db:"creado_en"`
 }
 
+// pacientesPaginacion define los campos de orden permitidos en GET /pacientes
+var pacientesPaginacion = pagination.Config{
+	Ordenes: map[string]pagination.Orden{
+		"creado_en":        {Columna: "creado_en", Tipo: pagination.TipoTimestamp},
+		"apellido":         {Columna: "apellido", Tipo: pagination.TipoText},
+		"nombre":           {Columna: "nombre", Tipo: pagination.TipoText},
+		"fecha_nacimiento": {Columna: "fecha_nacimiento", Tipo: pagination.TipoDate},
+	},
+	OrdenDefault: "-creado_en",
+	ColumnaID:    "id",
+}
+
+// filtrosPacientes traduce los filtros de la query a condiciones SQL
+func filtrosPacientes(c *gin.Context, args *[]interface{}) ([]string, error) {
+	var conds []string
+	agregar := func(cond string, v interface{}) {
+		*args = append(*args, v)
+		conds = append(conds, fmt.Sprintf(cond, len(*args)))
+	}
+
+	for _, campo := range []string{"obra_social", "plan"} {
+		if v := c.Query(campo); v != "" {
+			agregar(campo+" = $%d", v)
+		}
+	}
+	if v := c.Query("consultorio_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("consultorio_id inválido")
+		}
+		agregar("consultorio_id = $%d", id)
+	}
+
+	fechas := []struct {
+		param, cond, layout string
+	}{
+		{"nacimiento_desde", "fecha_nacimiento >= $%d", "2006-01-02"},
+		{"nacimiento_hasta", "fecha_nacimiento <= $%d", "2006-01-02"},
+		{"creado_desde", "creado_en >= $%d", time.RFC3339},
+		{"creado_hasta", "creado_en < $%d", time.RFC3339},
+	}
+	for _, f := range fechas {
+		raw := c.Query(f.param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(f.layout, raw)
+		if err != nil {
+			return nil, fmt.Errorf("parámetro '%s' inválido, se espera %s", f.param, f.layout)
+		}
+		agregar(f.cond, t.UTC())
+	}
+	return conds, nil
+}
+
 // GetPacientes godoc
 // @Summary      Obtener lista de pacientes
-// @Description  Obtiene todos los pacientes desde Supabase
+// @Description  Lista paginada (por cursor) de los pacientes del consultorio del usuario (o de todos, con alcance global)
 // @Tags         pacientes
 // @Produce      json
+// @Param        limit             query  int     false  "Tamaño de página (1-200, por defecto 50)"
+// @Param        cursor            query  string  false  "next_cursor de la página anterior"
+// @Param        sort              query  string  false  "creado_en, apellido, nombre o fecha_nacimiento; con '-' descendente (por defecto -creado_en)"
+// @Param        obra_social       query  string  false  "Obra social"
+// @Param        plan              query  string  false  "Plan"
+// @Param        consultorio_id    query  string  false  "Consultorio (útil con alcance global)"
+// @Param        nacimiento_desde  query  string  false  "Fecha de nacimiento mínima (YYYY-MM-DD)"
+// @Param        nacimiento_hasta  query  string  false  "Fecha de nacimiento máxima (YYYY-MM-DD)"
+// @Param        creado_desde      query  string  false  "Alta desde (RFC3339)"
+// @Param        creado_hasta      query  string  false  "Alta hasta, exclusivo (RFC3339)"
 // @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
 // @Router       /api/v1/pacientes [get]
 func (h *PacienteHandler) GetPacientes(c *gin.Context) {
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	pag, err := pagination.Parse(c, pacientesPaginacion)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var args []interface{}
+	conds, err := filtrosPacientes(c, &args)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	conds = append(conds, scope.Consultorio("consultorio_id", &args), pag.Condicion(&args))
+	args = append(args, pag.Fetch())
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -188,10 +470,11 @@ func (h *PacienteHandler) GetPacientes(c *gin.Context) {
 	       SELECT 
 		       id, nombre, apellido, fecha_nacimiento, nro_credencial, obra_social, condicion_iva, plan, creado_por_usuario, consultorio_id, creado_en
 	       FROM pacientes 
-	       ORDER BY creado_en DESC
-       `
+	       WHERE ` + strings.Join(conds, " AND ") + `
+	       ORDER BY ` + pag.OrderBy() + `
+	       LIMIT $` + strconv.Itoa(len(args))
 
-	rows, err := h.pool.Query(ctx, query)
+	rows, err := h.pool.Query(ctx, query, args...)
 	if err != nil {
 		h.logger.Error("Error al consultar pacientes", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -201,20 +484,28 @@ func (h *PacienteHandler) GetPacientes(c *gin.Context) {
 	}
 	defer rows.Close()
 
-	pacientes := make([]Paciente, 0)
+	// El cursor necesita los valores de orden sin el formateo de la respuesta
+	type fila struct {
+		paciente                  Paciente
+		fechaNacimiento, creadoEn time.Time
+	}
+	filas := make([]fila, 0)
 	for rows.Next() {
 		var (
-			id, creadoPorUsuario, consultorioID           [16]byte
+			id                                            [16]byte
+			creadoPorUsuario, consultorioID               *uuid.UUID
 			nombre, apellido                              string
 			fechaNacimiento, creadoEn                     time.Time
 			nroCredencial, obraSocial, condicionIVA, plan *string
 		)
+		// Una fila salteada cortaría la paginación: con limit+1 filas se decide si hay otra página
 		err := rows.Scan(
 			&id, &nombre, &apellido, &fechaNacimiento, &nroCredencial, &obraSocial, &condicionIVA, &plan, &creadoPorUsuario, &consultorioID, &creadoEn,
 		)
 		if err != nil {
 			h.logger.Error("Error al escanear paciente", zap.Error(err))
-			continue
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			return
 		}
 		p := Paciente{
 			ID:               uuid.UUID(id).String(),
@@ -225,17 +516,180 @@ func (h *PacienteHandler) GetPacientes(c *gin.Context) {
 			ObraSocial:       obraSocial,
 			CondicionIVA:     condicionIVA,
 			Plan:             plan,
-			CreadoPorUsuario: ptrString(uuid.UUID(creadoPorUsuario).String()),
-			ConsultorioID:    ptrString(uuid.UUID(consultorioID).String()),
+			CreadoPorUsuario: uuidString(creadoPorUsuario),
+			ConsultorioID:    uuidString(consultorioID),
 			CreadoEn:         creadoEn.Format(time.RFC3339),
 		}
-		pacientes = append(pacientes, p)
+		filas = append(filas, fila{paciente: p, fechaNacimiento: fechaNacimiento, creadoEn: creadoEn})
+	}
+	if err := rows.Err(); err != nil {
+		h.logger.Error("Error al recorrer pacientes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	filas, next := pagination.Pagina(pag, filas, func(f fila) (interface{}, string) {
+		switch pag.Campo() {
+		case "creado_en":
+			return f.creadoEn, f.paciente.ID
+		case "fecha_nacimiento":
+			return f.fechaNacimiento, f.paciente.ID
+		case "nombre":
+			return f.paciente.Nombre, f.paciente.ID
+		}
+		return f.paciente.Apellido, f.paciente.ID
+	})
+	pacientes := make([]Paciente, 0, len(filas))
+	for _, f := range filas {
+		pacientes = append(pacientes, f.paciente)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"pacientes":   pacientes,
+		"total":       len(pacientes),
+		"limit":       pag.Limit,
+		"next_cursor": next,
+	})
+}
+
+// Parámetros de la búsqueda aproximada de pacientes
+const (
+	busquedaMinCaracteres = 2
+	busquedaLimitDefault  = 20
+	busquedaLimitMax      = 50
+	// Umbral de word_similarity para el operador <%; el de pg_trgm (0.6) deja afuera errores de tipeo comunes
+	busquedaUmbralSimilitud = "0.4"
+	// Debe coincidir con la expresión de los índices de la migración 202610180010_pacientes_busqueda
+	busquedaTexto = "pacientes_texto_busqueda(nombre, apellido, nro_credencial)"
+)
+
+// PacienteBusqueda es un resultado de la búsqueda con su relevancia
+type PacienteBusqueda struct {
+	Paciente
+	Relevancia float64 `json:"relevancia"`
+}
+
+// SearchPacientes godoc
+// @Summary      Buscar pacientes
+// @Description  Búsqueda aproximada por nombre, apellido y número de credencial, tolerante a acentos y errores de tipeo. Resultados ordenados por relevancia.
+// @Tags         pacientes
+// @Produce      json
+// @Param        q      query  string  true   "Texto a buscar (mínimo 2 caracteres)"
+// @Param        limit  query  int     false  "Cantidad máxima de resultados (1-50, por defecto 20)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/search [get]
+func (h *PacienteHandler) SearchPacientes(c *gin.Context) {
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	q := strings.TrimSpace(c.Query("q"))
+	if utf8.RuneCountInString(q) < busquedaMinCaracteres {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("El parámetro 'q' debe tener al menos %d caracteres", busquedaMinCaracteres)})
+		return
+	}
+	limit := busquedaLimitDefault
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > busquedaLimitMax {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("parámetro 'limit' inválido, debe estar entre 1 y %d", busquedaLimitMax)})
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// El umbral se fija con SET LOCAL, así que la consulta va en su propia transacción
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción de búsqueda", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SET LOCAL pg_trgm.word_similarity_threshold = "+busquedaUmbralSimilitud); err != nil {
+		h.logger.Error("Error al configurar la búsqueda", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	// Relevancia: coincidencia de términos completos (ts_rank) + similitud por trigramas,
+	// con prioridad absoluta para el número de credencial exacto
+	args := []interface{}{q}
+	condScope := scope.Consultorio("consultorio_id", &args)
+	args = append(args, limit)
+	query := `
+	       SELECT 
+		       id, nombre, apellido, fecha_nacimiento, nro_credencial, obra_social, condicion_iva, plan, creado_por_usuario, consultorio_id, creado_en,
+		       (ts_rank(to_tsvector('simple', ` + busquedaTexto + `), plainto_tsquery('simple', b.texto))
+		        + word_similarity(b.texto, ` + busquedaTexto + `)
+		        + CASE WHEN lower(nro_credencial) = b.texto THEN 1 ELSE 0 END)::float8 AS relevancia
+	       FROM pacientes, (SELECT lower(f_unaccent($1)) AS texto) b
+	       WHERE (to_tsvector('simple', ` + busquedaTexto + `) @@ plainto_tsquery('simple', b.texto)
+		      OR b.texto <% ` + busquedaTexto + `)
+		 AND ` + condScope + `
+	       ORDER BY relevancia DESC, apellido, id
+	       LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		h.logger.Error("Error al buscar pacientes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	resultados := make([]PacienteBusqueda, 0)
+	for rows.Next() {
+		var (
+			id                                            [16]byte
+			creadoPorUsuario, consultorioID               *uuid.UUID
+			nombre, apellido                              string
+			fechaNacimiento, creadoEn                     time.Time
+			nroCredencial, obraSocial, condicionIVA, plan *string
+			relevancia                                    float64
+		)
+		err := rows.Scan(
+			&id, &nombre, &apellido, &fechaNacimiento, &nroCredencial, &obraSocial, &condicionIVA, &plan, &creadoPorUsuario, &consultorioID, &creadoEn, &relevancia,
+		)
+		if err != nil {
+			h.logger.Error("Error al escanear paciente", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			return
+		}
+		resultados = append(resultados, PacienteBusqueda{
+			Paciente: Paciente{
+				ID:               uuid.UUID(id).String(),
+				Nombre:           nombre,
+				Apellido:         apellido,
+				FechaNacimiento:  fechaNacimiento.Format("2006-01-02"),
+				NroCredencial:    nroCredencial,
+				ObraSocial:       obraSocial,
+				CondicionIVA:     condicionIVA,
+				Plan:             plan,
+				CreadoPorUsuario: uuidString(creadoPorUsuario),
+				ConsultorioID:    uuidString(consultorioID),
+				CreadoEn:         creadoEn.Format(time.RFC3339),
+			},
+			Relevancia: relevancia,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		h.logger.Error("Error al recorrer resultados de búsqueda", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":    "success",
-		"pacientes": pacientes,
-		"total":     len(pacientes),
+		"q":         q,
+		"pacientes": resultados,
+		"total":     len(resultados),
 	})
 }
 
@@ -244,43 +698,67 @@ func ptrString(s string) *string {
 	return &s
 }
 
+// uuidString convierte una columna UUID que admite NULL a *string
+func uuidString(u *uuid.UUID) *string {
+	if u == nil {
+		return nil
+	}
+	return ptrString(u.String())
+}
+
 // GetPaciente godoc
 // @Summary      Obtener paciente por ID
 // @Description  Obtiene un paciente específico por su ID
 // @Tags         pacientes
 // @Produce      json
-// @Param        id   path      int  true  "Patient ID"
+// @Param        id   path      string  true  "ID del paciente"
 // @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
 // @Router       /api/v1/pacientes/{id} [get]
 func (h *PacienteHandler) GetPaciente(c *gin.Context) {
-	idParam := c.Param("id")
+	pacienteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	args := []interface{}{pacienteID}
 	query := `
 	       SELECT 
 		       id, nombre, apellido, fecha_nacimiento, nro_credencial, obra_social, condicion_iva, plan, creado_por_usuario, consultorio_id, creado_en
 	       FROM pacientes 
-	       WHERE id = $1
+	       WHERE id = $1 AND ` + scope.Paciente("id", &args) + `
        `
 
 	var (
-		id, creadoPorUsuario, consultorioID           [16]byte
+		id                                            [16]byte
+		creadoPorUsuario, consultorioID               *uuid.UUID
 		nombre, apellido                              string
 		fechaNacimiento, creadoEn                     time.Time
 		nroCredencial, obraSocial, condicionIVA, plan *string
 	)
-	err := h.pool.QueryRow(ctx, query, idParam).Scan(
+	err = h.pool.QueryRow(ctx, query, args...).Scan(
 		&id, &nombre, &apellido, &fechaNacimiento, &nroCredencial, &obraSocial, &condicionIVA, &plan, &creadoPorUsuario, &consultorioID, &creadoEn,
 	)
-	if err != nil {
-		h.logger.Error("Error al consultar paciente", zap.Error(err))
+	if errors.Is(err, pgx.ErrNoRows) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"error": "Paciente no encontrado",
 		})
 		return
 	}
+	if err != nil {
+		h.logger.Error("Error al consultar paciente", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
 	p := Paciente{
 		ID:               uuid.UUID(id).String(),
 		Nombre:           nombre,
@@ -290,8 +768,8 @@ func (h *PacienteHandler) GetPaciente(c *gin.Context) {
 		ObraSocial:       obraSocial,
 		CondicionIVA:     condicionIVA,
 		Plan:             plan,
-		CreadoPorUsuario: ptrString(uuid.UUID(creadoPorUsuario).String()),
-		ConsultorioID:    ptrString(uuid.UUID(consultorioID).String()),
+		CreadoPorUsuario: uuidString(creadoPorUsuario),
+		ConsultorioID:    uuidString(consultorioID),
 		CreadoEn:         creadoEn.Format(time.RFC3339),
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -299,317 +777,3 @@ func (h *PacienteHandler) GetPaciente(c *gin.Context) {
 		"paciente": p,
 	})
 }
-
-// TestSupabaseConnection godoc
-// @Summary      Probar conexión con Supabase
-// @Description  Prueba la conexión con las tablas existentes en Supabase
-// @Tags         test
-// @Produce      json
-// @Success      200  {object}  map[string]interface{}
-// @Router       /api/v1/test/supabase [get]
-func (h *PacienteHandler) TestSupabaseConnection(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Probar conectividad básica
-	if err := h.pool.Ping(ctx); err != nil {
-		h.logger.Error("Error de ping a Supabase", zap.Error(err))
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"status": "error",
-			"error":  "No se puede conectar a Supabase",
-		})
-		return
-	}
-
-	// Contar registros en todas las tablas de Supabase (nombres exactos)
-	tables := []string{
-		"auditorias",
-		"consultorios",
-		"datos_personales",
-		"historia_clinica_version", // Corregido: singular
-		"historias_clinicas",
-		"pacientes",
-		"permisos",
-		"recetas_medicas",
-		"rol_permiso",
-		"roles",
-		"turnos",
-		"usuarios",
-	}
-	counts := make(map[string]int)
-	tableDetails := make(map[string]map[string]interface{})
-
-	for _, table := range tables {
-		var count int
-		query := "SELECT COUNT(*) FROM " + table
-		err := h.pool.QueryRow(ctx, query).Scan(&count)
-		if err != nil {
-			h.logger.Warn("Error al consultar tabla", zap.String("table", table), zap.Error(err))
-			counts[table] = -1
-			tableDetails[table] = map[string]interface{}{
-				"count": -1,
-				"error": err.Error(),
-			}
-		} else {
-			counts[table] = count
-			tableDetails[table] = map[string]interface{}{
-				"count":  count,
-				"status": "accessible",
-			}
-		}
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"status":           "success",
-		"database":         "connected",
-		"supabase_project": "mediapp-db",
-		"tables_count":     counts,
-		"table_details":    tableDetails,
-		"total_tables":     len(tables),
-		"connection_pool":  h.pool.Stat(),
-		"timestamp":        time.Now(),
-	})
-}
-
-// InspectTables godoc
-// @Summary      Inspeccionar estructura de tablas
-// @Description  Obtiene la estructura de las tablas para verificar columnas
-// @Tags         test
-// @Produce      json
-// @Param        table   query     string  false  "Nombre de la tabla a inspeccionar (default: pacientes)"
-// @Success      200  {object}  map[string]interface{}
-// @Router       /api/v1/inspect/tables [get]
-func (h *PacienteHandler) InspectTables(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Obtener tabla a inspeccionar desde query parameter
-	tableName := c.DefaultQuery("table", "pacientes")
-
-	// Lista de tablas permitidas por seguridad (nombres exactos de Supabase)
-	allowedTables := map[string]bool{
-		"auditorias":               true,
-		"consultorios":             true,
-		"datos_personales":         true,
-		"historia_clinica_version": true, // Corregido: singular
-		"historias_clinicas":       true,
-		"pacientes":                true,
-		"permisos":                 true,
-		"recetas_medicas":          true,
-		"rol_permiso":              true,
-		"roles":                    true,
-		"turnos":                   true,
-		"usuarios":                 true,
-	}
-
-	if !allowedTables[tableName] {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Tabla no permitida",
-			"allowed_tables": []string{
-				"auditorias", "consultorios", "datos_personales",
-				"historia_clinica_version", "historias_clinicas", "pacientes",
-				"permisos", "recetas_medicas", "rol_permiso", "roles", "turnos", "usuarios",
-			},
-		})
-		return
-	}
-
-	// Obtener columnas de la tabla especificada
-	query := `
-		SELECT column_name, data_type, is_nullable, column_default
-		FROM information_schema.columns 
-		WHERE table_name = $1
-		ORDER BY ordinal_position
-	`
-
-	rows, err := h.pool.Query(ctx, query, tableName)
-	if err != nil {
-		h.logger.Error("Error al consultar estructura de tabla",
-			zap.String("table", tableName), zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Error interno del servidor",
-			"table": tableName,
-		})
-		return
-	}
-	defer rows.Close()
-
-	var columns []map[string]interface{}
-	for rows.Next() {
-		var columnName, dataType, isNullable string
-		var columnDefault *string
-
-		err := rows.Scan(&columnName, &dataType, &isNullable, &columnDefault)
-		if err != nil {
-			h.logger.Error("Error al escanear columna", zap.Error(err))
-			continue
-		}
-
-		column := map[string]interface{}{
-			"name":     columnName,
-			"type":     dataType,
-			"nullable": isNullable == "YES",
-		}
-		if columnDefault != nil {
-			column["default"] = *columnDefault
-		}
-		columns = append(columns, column)
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "success",
-		"table":   tableName,
-		"columns": columns,
-		"count":   len(columns),
-	})
-}
-
-// ConnectAllTables godoc
-// @Summary      Conectar y verificar todas las tablas
-// @Description  Conecta con todas las tablas de Supabase y obtiene un resumen completo
-// @Tags         test
-// @Produce      json
-// @Success      200  {object}  map[string]interface{}
-// @Router       /api/v1/connect/all-tables [get]
-func (h *PacienteHandler) ConnectAllTables(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	// Lista completa de tablas en Supabase (nombres exactos)
-	tables := []string{
-		"auditorias",
-		"consultorios",
-		"datos_personales",
-		"historia_clinica_version", // Corregido: singular, no plural
-		"historias_clinicas",
-		"pacientes",
-		"permisos",
-		"recetas_medicas",
-		"rol_permiso",
-		"roles",
-		"turnos",
-		"usuarios",
-	}
-
-	allTablesData := make(map[string]interface{})
-	successfulConnections := 0
-	failedConnections := 0
-
-	for _, tableName := range tables {
-		tableData := make(map[string]interface{})
-
-		// 1. Contar registros
-		var count int
-		countQuery := "SELECT COUNT(*) FROM " + tableName
-		err := h.pool.QueryRow(ctx, countQuery).Scan(&count)
-		if err != nil {
-			h.logger.Warn("Error al consultar tabla",
-				zap.String("table", tableName), zap.Error(err))
-			tableData["status"] = "error"
-			tableData["error"] = err.Error()
-			tableData["count"] = -1
-			failedConnections++
-		} else {
-			tableData["status"] = "connected"
-			tableData["count"] = count
-			successfulConnections++
-
-			// 2. Obtener estructura de tabla (si la conexión fue exitosa)
-			columnsQuery := `
-				SELECT column_name, data_type, is_nullable, column_default
-				FROM information_schema.columns 
-				WHERE table_name = $1
-				ORDER BY ordinal_position
-			`
-
-			rows, err := h.pool.Query(ctx, columnsQuery, tableName)
-			if err != nil {
-				h.logger.Warn("Error al consultar estructura",
-					zap.String("table", tableName), zap.Error(err))
-				tableData["columns_error"] = err.Error()
-			} else {
-				var columns []map[string]interface{}
-				for rows.Next() {
-					var columnName, dataType, isNullable string
-					var columnDefault *string
-
-					err := rows.Scan(&columnName, &dataType, &isNullable, &columnDefault)
-					if err != nil {
-						continue
-					}
-
-					column := map[string]interface{}{
-						"name":     columnName,
-						"type":     dataType,
-						"nullable": isNullable == "YES",
-					}
-					if columnDefault != nil {
-						column["default"] = *columnDefault
-					}
-					columns = append(columns, column)
-				}
-				rows.Close()
-				tableData["columns"] = columns
-				tableData["columns_count"] = len(columns)
-			}
-
-			// 3. Obtener muestra de datos (primeros 3 registros si existen)
-			if count > 0 {
-				sampleQuery := "SELECT * FROM " + tableName + " LIMIT 3"
-				sampleRows, err := h.pool.Query(ctx, sampleQuery)
-				if err != nil {
-					tableData["sample_error"] = err.Error()
-				} else {
-					// Convertir a slice de maps genérico
-					var samples []map[string]interface{}
-					for sampleRows.Next() {
-						// Obtener nombres de columnas
-						fieldDescriptions := sampleRows.FieldDescriptions()
-						columnNames := make([]string, len(fieldDescriptions))
-						for i, desc := range fieldDescriptions {
-							columnNames[i] = string(desc.Name)
-						}
-
-						// Crear slice de interfaces para escanear
-						values := make([]interface{}, len(columnNames))
-						valuePtrs := make([]interface{}, len(columnNames))
-						for i := range values {
-							valuePtrs[i] = &values[i]
-						}
-
-						if err := sampleRows.Scan(valuePtrs...); err == nil {
-							row := make(map[string]interface{})
-							for i, colName := range columnNames {
-								row[colName] = values[i]
-							}
-							samples = append(samples, row)
-						}
-					}
-					sampleRows.Close()
-					tableData["sample_data"] = samples
-					tableData["sample_count"] = len(samples)
-				}
-			}
-		}
-
-		allTablesData[tableName] = tableData
-	}
-
-	// Resumen final
-	summary := map[string]interface{}{
-		"status":                 "success",
-		"total_tables":           len(tables),
-		"successful_connections": successfulConnections,
-		"failed_connections":     failedConnections,
-		"connection_rate":        float64(successfulConnections) / float64(len(tables)) * 100,
-		"database":               "Supabase PostgreSQL",
-		"project":                "mediapp-db",
-		"timestamp":              time.Now(),
-		"pool_stats":             h.pool.Stat(),
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"summary": summary,
-		"tables":  allTablesData,
-	})
-}
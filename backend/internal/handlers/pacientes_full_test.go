@@ -3,12 +3,14 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -51,6 +53,11 @@ func (m *mockPoolP) Ping(ctx context.Context) error {
 }
 func (m *mockPoolP) Stat() *pgxpool.Stat { return &pgxpool.Stat{} }
 
+// Begin devuelve una transacción que delega en las mismas funciones del pool
+func (m *mockPoolP) Begin(ctx context.Context) (pgx.Tx, error) {
+	return &mockTx{execFunc: m.execFunc, queryFunc: m.queryFunc, queryRowFunc: m.queryRowFunc}, nil
+}
+
 // mockRowsP implements pgx.Rows for our tests
 type mockRowsP struct {
 	idx       int
@@ -141,6 +148,7 @@ func TestGetPacientes_Success(t *testing.T) {
 	req, _ := http.NewRequest(http.MethodGet, "/api/v1/pacientes", nil)
 	rec := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(rec)
+	tenant.Set(ctx, tenant.Scope{ConsultorioID: consultorioTest})
 	ctx.Request = req
 
 	h.GetPacientes(ctx)
@@ -186,13 +194,13 @@ func TestGetPaciente_Success(t *testing.T) {
 		if p, ok := dest[7].(**string); ok {
 			*p = nil
 		}
-		if p, ok := dest[8].(*[16]byte); ok {
+		if p, ok := dest[8].(**uuid.UUID); ok {
 			u := uuid.New()
-			copy(p[:], u[:])
+			*p = &u
 		}
-		if p, ok := dest[9].(*[16]byte); ok {
+		if p, ok := dest[9].(**uuid.UUID); ok {
 			u := uuid.New()
-			copy(p[:], u[:])
+			*p = &u
 		}
 		if p, ok := dest[10].(*time.Time); ok {
 			*p = now
@@ -204,10 +212,13 @@ func TestGetPaciente_Success(t *testing.T) {
 	logger := zap.NewNop()
 	h := NewPacienteHandler(pool, logger)
 
-	req, _ := http.NewRequest(http.MethodGet, "/api/v1/pacientes/1", nil)
+	id := uuid.New().String()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/pacientes/"+id, nil)
 	rec := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(rec)
+	tenant.Set(ctx, tenant.Scope{ConsultorioID: consultorioTest})
 	ctx.Request = req
+	ctx.Params = gin.Params{{Key: "id", Value: id}}
 
 	h.GetPaciente(ctx)
 	if rec.Code != http.StatusOK {
@@ -215,16 +226,86 @@ func TestGetPaciente_Success(t *testing.T) {
 	}
 }
 
+// TestGetPaciente_ColumnasNulas verifica que un paciente sin consultorio ni creador (filas
+// viejas o vistas con alcance global) se devuelve sin esos campos, y que solo un
+// paciente inexistente responde 404
+func TestGetPaciente_ColumnasNulas(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var errConsulta error
+	pool := &mockPoolP{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			if errConsulta != nil {
+				return errConsulta
+			}
+			*dest[1].(*string) = "Ana"
+			*dest[3].(*time.Time) = time.Now()
+			*dest[8].(**uuid.UUID) = nil
+			*dest[9].(**uuid.UUID) = nil
+			return nil
+		}}
+	}}
+	h := NewPacienteHandler(pool, zap.NewNop())
+	pedir := func(id string) *httptest.ResponseRecorder {
+		c, w := makeCtx(http.MethodGet, "/api/v1/pacientes/"+id, nil)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		h.GetPaciente(c)
+		return w
+	}
+
+	w := pedir(uuid.New().String())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Paciente map[string]interface{} `json:"paciente"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Paciente["consultorio_id"] != nil || resp.Paciente["creado_por_usuario"] != nil || resp.Paciente["nombre"] != "Ana" {
+		t.Errorf("No se esperaban consultorio_id ni creado_por_usuario: %v", resp.Paciente)
+	}
+
+	if w := pedir("no-es-un-uuid"); w.Code != http.StatusBadRequest {
+		t.Errorf("Se esperaba status 400 con un ID inválido, obtuvo %d", w.Code)
+	}
+	errConsulta = pgx.ErrNoRows
+	if w := pedir(uuid.New().String()); w.Code != http.StatusNotFound {
+		t.Errorf("Se esperaba status 404 para un paciente inexistente, obtuvo %d", w.Code)
+	}
+	errConsulta = errors.New("conexión perdida")
+	if w := pedir(uuid.New().String()); w.Code != http.StatusInternalServerError {
+		t.Errorf("Se esperaba status 500 si falla la consulta, obtuvo %d", w.Code)
+	}
+}
+
 // TestCreateUpdateDeletePaciente flow
+// auditQueryRow responde a audit.Snapshot con la foto dada; las consultas de la
+// cadena de auditoría no encuentran filas
+func auditQueryRow(snapshot string) func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			if !strings.Contains(sql, "row_to_json") {
+				return pgx.ErrNoRows
+			}
+			*(dest[0].(*[]byte)) = []byte(snapshot)
+			return nil
+		}}
+	}
+}
+
 func TestCreateUpdateDeletePacienteFlow(t *testing.T) {
 	gin.SetMode(gin.TestMode)
+	var auditorias []string
 	pool := &mockPoolP{
 		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "INSERT INTO auditorias") {
+				auditorias = append(auditorias, args[1].(string))
+			}
 			if strings.HasPrefix(strings.TrimSpace(strings.ToUpper(sql)), "INSERT") {
 				return pgconn.NewCommandTag("INSERT 1"), nil
 			}
 			return pgconn.NewCommandTag("UPDATE 1"), nil
 		},
+		queryRowFunc: auditQueryRow(`{"id":"1","nombre":"X"}`),
 	}
 	logger := zap.NewNop()
 	h := NewPacienteHandler(pool, logger)
@@ -236,6 +317,7 @@ func TestCreateUpdateDeletePacienteFlow(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	rec := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(rec)
+	tenant.Set(ctx, tenant.Scope{ConsultorioID: consultorioTest})
 	ctx.Request = req
 	h.CreatePaciente(ctx)
 	if rec.Code != http.StatusCreated {
@@ -243,23 +325,283 @@ func TestCreateUpdateDeletePacienteFlow(t *testing.T) {
 	}
 
 	// Update
-	req2, _ := http.NewRequest(http.MethodPut, "/api/v1/pacientes/1", strings.NewReader(string(b)))
+	pacienteID := uuid.New().String()
+	req2, _ := http.NewRequest(http.MethodPut, "/api/v1/pacientes/"+pacienteID, strings.NewReader(string(b)))
 	req2.Header.Set("Content-Type", "application/json")
 	rec2 := httptest.NewRecorder()
 	ctx2, _ := gin.CreateTestContext(rec2)
+	tenant.Set(ctx2, tenant.Scope{ConsultorioID: consultorioTest})
 	ctx2.Request = req2
+	ctx2.Params = gin.Params{{Key: "id", Value: pacienteID}}
 	h.UpdatePaciente(ctx2)
 	if rec2.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", rec2.Code)
 	}
 
 	// Delete
-	req3, _ := http.NewRequest(http.MethodDelete, "/api/v1/pacientes/1", nil)
+	req3, _ := http.NewRequest(http.MethodDelete, "/api/v1/pacientes/"+pacienteID, nil)
 	rec3 := httptest.NewRecorder()
 	ctx3, _ := gin.CreateTestContext(rec3)
+	tenant.Set(ctx3, tenant.Scope{ConsultorioID: consultorioTest})
 	ctx3.Request = req3
+	ctx3.Params = gin.Params{{Key: "id", Value: pacienteID}}
 	h.DeletePaciente(ctx3)
 	if rec3.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", rec3.Code)
 	}
+
+	if strings.Join(auditorias, ",") != "crear,actualizar,eliminar" {
+		t.Errorf("Se esperaban auditorías crear, actualizar y eliminar, obtuvo %v", auditorias)
+	}
+}
+
+func TestDeletePaciente_AuditFailureRollsBack(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tx := &mockTx{
+		queryRowFunc: auditQueryRow(`{"id":"1"}`),
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "INSERT INTO auditorias") {
+				return pgconn.CommandTag{}, errors.New("auditoría no disponible")
+			}
+			return pgconn.NewCommandTag("DELETE 1"), nil
+		},
+	}
+	h := NewPacienteHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	req, _ := http.NewRequest(http.MethodDelete, "/api/v1/pacientes/1", nil)
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	tenant.Set(ctx, tenant.Scope{ConsultorioID: consultorioTest})
+	ctx.Request = req
+	ctx.Params = gin.Params{{Key: "id", Value: uuid.New().String()}}
+	h.DeletePaciente(ctx)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Se esperaba status 500, obtuvo %d", rec.Code)
+	}
+	if tx.committed || !tx.rolledBack {
+		t.Error("Se esperaba revertir la eliminación si no se pudo auditar")
+	}
+}
+
+func TestUpdatePaciente_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewPacienteHandler(&mockPoolP{}, zap.NewNop())
+
+	b, _ := json.Marshal(map[string]string{"nombre": "X", "apellido": "Y", "fecha_nacimiento": "2000-01-01"})
+	req, _ := http.NewRequest(http.MethodPut, "/api/v1/pacientes/1", strings.NewReader(string(b)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	tenant.Set(ctx, tenant.Scope{ConsultorioID: consultorioTest})
+	ctx.Request = req
+	ctx.Params = gin.Params{{Key: "id", Value: uuid.New().String()}}
+	h.UpdatePaciente(ctx)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Se esperaba status 404, obtuvo %d", rec.Code)
+	}
+}
+
+// TestUpdateYDeletePaciente_IDInvalido verifica que un ID mal formado responde 400 sin
+// consultar la base ni exponer el error de Postgres
+func TestUpdateYDeletePaciente_IDInvalido(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pool := &mockTxPool{mockPoolP: mockPoolP{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		t.Errorf("No se esperaba consultar la base: %s", sql)
+		return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+	}}}
+	h := NewPacienteHandler(pool, zap.NewNop())
+	b, _ := json.Marshal(map[string]string{"nombre": "X", "apellido": "Y", "fecha_nacimiento": "2000-01-01"})
+
+	for nombre, accionar := range map[string]func(*gin.Context){"update": h.UpdatePaciente, "delete": h.DeletePaciente} {
+		c, w := makeCtx(http.MethodPut, "/api/v1/pacientes/1", b)
+		c.Params = gin.Params{{Key: "id", Value: "1"}}
+		accionar(c)
+		if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), "detalle") {
+			t.Errorf("%s: se esperaba status 400 sin detalle, obtuvo %d: %s", nombre, w.Code, w.Body.String())
+		}
+	}
+}
+
+// TestCreatePaciente_CreadorAutenticado verifica que creado_por_usuario es el usuario del
+// request y no el valor enviado en el body
+func TestCreatePaciente_CreadorAutenticado(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var creador interface{}
+	pool := &mockPoolP{
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "INSERT INTO pacientes") {
+				creador = args[8]
+			}
+			return pgconn.NewCommandTag("INSERT 0 1"), nil
+		},
+		queryRowFunc: auditQueryRow(`{}`),
+	}
+	h := NewPacienteHandler(pool, zap.NewNop())
+	usuario := uuid.New()
+	b, _ := json.Marshal(map[string]string{"nombre": "X", "apellido": "Y", "fecha_nacimiento": "2000-01-01", "creado_por_usuario": uuid.New().String()})
+	c, w := makeCtx(http.MethodPost, "/api/v1/pacientes", b)
+	c.Set("user_id", usuario.String())
+	h.CreatePaciente(c)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Se esperaba status 201, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	if p, ok := creador.(*string); !ok || p == nil || *p != usuario.String() {
+		t.Errorf("Se esperaba el usuario autenticado como creador, obtuvo %v", creador)
+	}
+}
+
+func TestGetPacientes_PaginacionYFiltros(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var gotSQL string
+	var gotArgs []interface{}
+	rows := &mockRowsP{rowsCount: 2}
+	rows.scanFunc = func(dest ...interface{}) error {
+		u := uuid.New()
+		copy(dest[0].(*[16]byte)[:], u[:])
+		*(dest[10].(*time.Time)) = time.Now()
+		return nil
+	}
+	pool := &mockPoolP{queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+		gotSQL, gotArgs = sql, args
+		return rows, nil
+	}}
+	h := NewPacienteHandler(pool, zap.NewNop())
+
+	c, w := makeCtx("GET", "/api/v1/pacientes?limit=1&obra_social=OSDE&nacimiento_desde=1980-01-01", nil)
+	h.GetPacientes(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Pacientes  []Paciente `json:"pacientes"`
+		NextCursor *string    `json:"next_cursor"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Pacientes) != 1 || resp.NextCursor == nil {
+		t.Fatalf("Se esperaba una página de 1 con next_cursor, obtuvo %s", w.Body.String())
+	}
+	if resp.Pacientes[0].CreadoPorUsuario != nil || resp.Pacientes[0].ConsultorioID != nil {
+		t.Errorf("Se esperaba null para las columnas NULL: %s", w.Body.String())
+	}
+	if !strings.Contains(gotSQL, "obra_social = $1") || !strings.Contains(gotSQL, "fecha_nacimiento >= $2") {
+		t.Errorf("Se esperaban los filtros en la consulta: %s", gotSQL)
+	}
+	if !strings.Contains(gotSQL, "ORDER BY creado_en DESC, id DESC") || gotArgs[len(gotArgs)-1] != 2 {
+		t.Errorf("Se esperaba pedir limit+1 filas ordenadas, SQL: %s args: %v", gotSQL, gotArgs)
+	}
+}
+
+// TestGetPacientes_ErrorDeEscaneo verifica que una fila que no se puede leer no se saltea:
+// la página quedaría corta y sin next_cursor
+func TestGetPacientes_ErrorDeEscaneo(t *testing.T) {
+	rows := &mockRowsP{rowsCount: 3}
+	rows.scanFunc = func(dest ...interface{}) error {
+		if rows.idx == 2 {
+			return errors.New("can't scan into dest[8]")
+		}
+		*(dest[10].(*time.Time)) = time.Now()
+		return nil
+	}
+	pool := &mockPoolP{queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+		return rows, nil
+	}}
+	h := NewPacienteHandler(pool, zap.NewNop())
+
+	c, w := makeCtx("GET", "/api/v1/pacientes?limit=2", nil)
+	h.GetPacientes(c)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Se esperaba status 500, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGetPacientes_ParametrosInvalidos(t *testing.T) {
+	h := NewPacienteHandler(&mockPoolP{}, zap.NewNop())
+	for _, query := range []string{"sort=contrasena_hash", "limit=1000", "creado_desde=ayer", "cursor=xyz"} {
+		c, w := makeCtx("GET", "/api/v1/pacientes?"+query, nil)
+		h.GetPacientes(c)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: se esperaba status 400, obtuvo %d", query, w.Code)
+		}
+	}
+}
+
+func TestSearchPacientes_RankeaPorRelevancia(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var gotSQL string
+	var gotArgs []interface{}
+	rows := &mockRowsP{rowsCount: 1}
+	rows.scanFunc = func(dest ...interface{}) error {
+		*(dest[1].(*string)) = "Juan"
+		*(dest[2].(*string)) = "Pérez"
+		*(dest[11].(*float64)) = 0.8
+		return nil
+	}
+	tx := &mockTx{queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+		gotSQL, gotArgs = sql, args
+		return rows, nil
+	}}
+	h := NewPacienteHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	c, w := makeCtx("GET", "/api/v1/pacientes/search?q=+perez+&limit=5", nil)
+	h.SearchPacientes(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Pacientes []PacienteBusqueda `json:"pacientes"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Pacientes) != 1 || resp.Pacientes[0].Apellido != "Pérez" || resp.Pacientes[0].Relevancia != 0.8 {
+		t.Fatalf("Resultado inesperado: %s", w.Body.String())
+	}
+	if resp.Pacientes[0].CreadoPorUsuario != nil {
+		t.Errorf("Se esperaba null para creado_por_usuario NULL: %s", w.Body.String())
+	}
+	if len(tx.execSQL) != 1 || !strings.Contains(tx.execSQL[0], "word_similarity_threshold") {
+		t.Errorf("Se esperaba fijar el umbral de similitud en la transacción: %v", tx.execSQL)
+	}
+	for _, frag := range []string{"f_unaccent($1)", busquedaTexto, "<%", "plainto_tsquery", "ORDER BY relevancia DESC", "consultorio_id = $2"} {
+		if !strings.Contains(gotSQL, frag) {
+			t.Errorf("Se esperaba %q en la consulta: %s", frag, gotSQL)
+		}
+	}
+	if gotArgs[0] != "perez" || gotArgs[1] != consultorioTest || gotArgs[2] != 5 {
+		t.Errorf("Argumentos inesperados: %v", gotArgs)
+	}
+}
+
+func TestSearchPacientes_ErrorDeEscaneo(t *testing.T) {
+	rows := &mockRowsP{rowsCount: 2}
+	rows.scanFunc = func(dest ...interface{}) error {
+		if rows.idx == 2 {
+			return errors.New("can't scan into dest[8]")
+		}
+		return nil
+	}
+	tx := &mockTx{queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+		return rows, nil
+	}}
+	h := NewPacienteHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	c, w := makeCtx("GET", "/api/v1/pacientes/search?q=perez", nil)
+	h.SearchPacientes(c)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Se esperaba status 500, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSearchPacientes_ParametrosInvalidos(t *testing.T) {
+	h := NewPacienteHandler(&mockPoolP{}, zap.NewNop())
+	for _, query := range []string{"", "q=a", "q=+%C3%A9+", "q=perez&limit=0", "q=perez&limit=100"} {
+		c, w := makeCtx("GET", "/api/v1/pacientes/search?"+query, nil)
+		h.SearchPacientes(c)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: se esperaba status 400, obtuvo %d", query, w.Code)
+		}
+	}
 }
@@ -8,14 +8,20 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"go.uber.org/zap"
 )
 
+// consultorioTest es el consultorio del usuario de los tests
+var consultorioTest = uuid.MustParse("6f1c2d3e-4b5a-4c6d-8e7f-9a0b1c2d3e4f")
+
 func makeCtx(method, path string, body []byte) (*gin.Context, *httptest.ResponseRecorder) {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
+	tenant.Set(c, tenant.Scope{ConsultorioID: consultorioTest})
 	req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
@@ -33,18 +39,6 @@ func TestCreatePaciente_BindError(t *testing.T) {
 	}
 }
 
-func TestInspectTables_NotAllowed(t *testing.T) {
-	c, w := makeCtx("GET", "/api/v1/inspect/tables?table=hack", nil)
-	q := c.Request.URL.Query()
-	q.Add("table", "hack")
-	c.Request.URL.RawQuery = q.Encode()
-	h := NewPacienteHandler(&mockPoolP{}, zap.NewNop())
-	h.InspectTables(c)
-	if w.Code != http.StatusBadRequest {
-		t.Fatalf("expected 400 got %d body=%s", w.Code, w.Body.String())
-	}
-}
-
 func TestGetPacientes_NoPanic(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	// provide a mock pool that returns an error on Query to avoid nil deref
@@ -55,6 +49,7 @@ func TestGetPacientes_NoPanic(t *testing.T) {
 	req, _ := http.NewRequest(http.MethodGet, "/api/v1/pacientes", nil)
 	rec := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(rec)
+	tenant.Set(ctx, tenant.Scope{ConsultorioID: consultorioTest})
 	ctx.Request = req
 	h.GetPacientes(ctx)
 	// test passes if handler doesn't panic
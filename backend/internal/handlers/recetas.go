@@ -0,0 +1,818 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/models"
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// recetaFormatoVersion identifica el formato de la serialización canónica firmada
+const recetaFormatoVersion = 1
+
+// RecetaHandler maneja la emisión, revocación y verificación de recetas firmadas.
+// Cada profesional firma con su propia clave Ed25519, que se guarda cifrada con masterKey.
+type RecetaHandler struct {
+	pool      TxPool
+	masterKey []byte
+	logger    *zap.Logger
+}
+
+// NewRecetaHandler crea una nueva instancia del handler de recetas.
+// Si masterKey es nil la verificación sigue disponible pero no se pueden emitir recetas.
+func NewRecetaHandler(pool TxPool, masterKey []byte, logger *zap.Logger) *RecetaHandler {
+	return &RecetaHandler{
+		pool:      pool,
+		masterKey: masterKey,
+		logger:    logger,
+	}
+}
+
+// RecetaInput son los datos para emitir una receta
+type RecetaInput struct {
+	PacienteID string `json:"paciente_id" binding:"required,uuid"`
+	Contenido  string `json:"contenido" binding:"required"`
+}
+
+// ReemitirRecetaInput son los datos para reemplazar una receta por otra nueva
+type ReemitirRecetaInput struct {
+	Contenido string `json:"contenido" binding:"required"`
+	Motivo    string `json:"motivo" binding:"required"`
+}
+
+// RevocarRecetaInput indica el motivo de la revocación
+type RevocarRecetaInput struct {
+	Motivo string `json:"motivo" binding:"required"`
+}
+
+// PacienteFirmado son los datos del paciente tal como figuraban al firmar
+type PacienteFirmado struct {
+	ID              string  `json:"id"`
+	Nombre          string  `json:"nombre"`
+	Apellido        string  `json:"apellido"`
+	FechaNacimiento string  `json:"fecha_nacimiento"`
+	NroCredencial   *string `json:"nro_credencial"`
+}
+
+// ProfesionalFirmado identifica al profesional que firmó
+type ProfesionalFirmado struct {
+	ID     string `json:"id"`
+	Nombre string `json:"nombre"`
+}
+
+// RecetaFirmada es la serialización canónica de una receta. El orden de los campos
+// es fijo, por lo que los mismos datos producen siempre los mismos bytes.
+type RecetaFirmada struct {
+	Version           int                `json:"version"`
+	ID                string             `json:"id"`
+	Paciente          PacienteFirmado    `json:"paciente"`
+	Profesional       ProfesionalFirmado `json:"profesional"`
+	Contenido         string             `json:"contenido"`
+	FechaEmision      string             `json:"fecha_emision"`
+	ReemplazaID       *string            `json:"reemplaza_id"`
+	HuellaCertificado string             `json:"huella_certificado"`
+}
+
+var (
+	errPacienteNoEncontrado = errors.New("paciente no encontrado")
+	errProfesionalInactivo  = errors.New("profesional inexistente o inactivo")
+	errRecetaDeOtroAutor    = errors.New("la receta la firmó otro profesional")
+)
+
+const recetaColumns = `id, paciente_id, usuario_id, contenido, fecha_emision, firma_digital, firma, huella_certificado, estado, revocada_en, motivo_revocacion, reemplaza_id, contenido_firmado`
+
+func scanReceta(row pgx.Row) (models.RecetaMedica, error) {
+	var r models.RecetaMedica
+	err := row.Scan(&r.ID, &r.PacienteID, &r.UsuarioID, &r.Contenido, &r.FechaEmision, &r.FirmaDigital,
+		&r.Firma, &r.HuellaCertificado, &r.Estado, &r.RevocadaEn, &r.MotivoRevocacion, &r.ReemplazaID, &r.ContenidoFirmado)
+	r.HashDocumento = hashDocumento(r.ContenidoFirmado)
+	return r, err
+}
+
+// hashDocumento es el SHA-256 en hexadecimal del documento firmado, o vacío si no hay
+func hashDocumento(contenidoFirmado []byte) string {
+	if len(contenidoFirmado) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(contenidoFirmado)
+	return hex.EncodeToString(sum[:])
+}
+
+// formatoFechaFirma serializa las fechas con la precisión que guarda PostgreSQL
+func formatoFechaFirma(t time.Time) string {
+	return t.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano)
+}
+
+// serializarReceta devuelve los bytes canónicos que se firman
+func serializarReceta(doc RecetaFirmada) ([]byte, error) {
+	return json.Marshal(doc)
+}
+
+// claveDeFirma devuelve la clave activa del profesional, generándola en el primer uso
+func (h *RecetaHandler) claveDeFirma(ctx context.Context, tx pgx.Tx, usuarioID uuid.UUID, nombre string) (uuid.UUID, *security.SigningIdentity, error) {
+	// Evita que dos emisiones simultáneas generen dos claves para el mismo profesional
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, "claves_firma:"+usuarioID.String()); err != nil {
+		return uuid.Nil, nil, err
+	}
+
+	var (
+		claveID     uuid.UUID
+		certificado []byte
+		cifrada     []byte
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT id, certificado, clave_privada_cifrada
+		FROM claves_firma
+		WHERE usuario_id = $1 AND revocada_en IS NULL
+	`, usuarioID).Scan(&claveID, &certificado, &cifrada)
+	if err == nil {
+		priv, err := security.OpenPrivateKey(h.masterKey, cifrada, claveID[:])
+		if err != nil {
+			return uuid.Nil, nil, err
+		}
+		return claveID, &security.SigningIdentity{PrivateKey: priv, Certificate: certificado}, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, nil, err
+	}
+
+	identidad, err := security.GenerateSigningIdentity(nombre, usuarioID.String())
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	claveID = uuid.New()
+	cifrada, err = security.SealPrivateKey(h.masterKey, identidad.PrivateKey, claveID[:])
+	if err != nil {
+		return uuid.Nil, nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO claves_firma (id, usuario_id, certificado, huella, clave_privada_cifrada)
+		VALUES ($1, $2, $3, $4, $5)
+	`, claveID, usuarioID, identidad.Certificate, security.CertificateFingerprint(identidad.Certificate), cifrada); err != nil {
+		return uuid.Nil, nil, err
+	}
+	h.logger.Info("Clave de firma generada",
+		zap.String("usuario_id", usuarioID.String()),
+		zap.String("clave_id", claveID.String()))
+	return claveID, identidad, nil
+}
+
+// emitirReceta arma la serialización canónica, la firma con la clave del autor y la guarda
+func (h *RecetaHandler) emitirReceta(ctx context.Context, tx pgx.Tx, scope tenant.Scope, autorID, pacienteID uuid.UUID, contenido string, reemplazaID *uuid.UUID) (models.RecetaMedica, error) {
+	var paciente PacienteFirmado
+	var fechaNacimiento time.Time
+	args := []interface{}{pacienteID}
+	err := tx.QueryRow(ctx, `
+		SELECT nombre, apellido, fecha_nacimiento, nro_credencial FROM pacientes WHERE id = $1 AND `+scope.Consultorio("consultorio_id", &args)+`
+	`, args...).Scan(&paciente.Nombre, &paciente.Apellido, &fechaNacimiento, &paciente.NroCredencial)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.RecetaMedica{}, errPacienteNoEncontrado
+	}
+	if err != nil {
+		return models.RecetaMedica{}, err
+	}
+	paciente.ID = pacienteID.String()
+	paciente.FechaNacimiento = fechaNacimiento.Format("2006-01-02")
+
+	var nombreProfesional string
+	err = tx.QueryRow(ctx, `SELECT nombre FROM usuarios WHERE id = $1 AND activo = true`, autorID).Scan(&nombreProfesional)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.RecetaMedica{}, errProfesionalInactivo
+	}
+	if err != nil {
+		return models.RecetaMedica{}, err
+	}
+
+	claveID, identidad, err := h.claveDeFirma(ctx, tx, autorID, nombreProfesional)
+	if err != nil {
+		return models.RecetaMedica{}, err
+	}
+	huella := security.CertificateFingerprint(identidad.Certificate)
+
+	receta := models.RecetaMedica{
+		ID:                uuid.New(),
+		PacienteID:        pacienteID,
+		UsuarioID:         autorID,
+		Contenido:         &contenido,
+		FechaEmision:      time.Now().UTC().Truncate(time.Microsecond),
+		FirmaDigital:      true,
+		ClaveFirmaID:      &claveID,
+		HuellaCertificado: &huella,
+		Estado:            models.RecetaEmitida,
+		ReemplazaID:       reemplazaID,
+	}
+	doc := RecetaFirmada{
+		Version:           recetaFormatoVersion,
+		ID:                receta.ID.String(),
+		Paciente:          paciente,
+		Profesional:       ProfesionalFirmado{ID: autorID.String(), Nombre: nombreProfesional},
+		Contenido:         contenido,
+		FechaEmision:      formatoFechaFirma(receta.FechaEmision),
+		HuellaCertificado: huella,
+	}
+	if reemplazaID != nil {
+		id := reemplazaID.String()
+		doc.ReemplazaID = &id
+	}
+
+	receta.ContenidoFirmado, err = serializarReceta(doc)
+	if err != nil {
+		return models.RecetaMedica{}, err
+	}
+	receta.Firma = ed25519.Sign(identidad.PrivateKey, receta.ContenidoFirmado)
+	receta.HashDocumento = hashDocumento(receta.ContenidoFirmado)
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO recetas_medicas (id, paciente_id, usuario_id, contenido, fecha_emision, firma_digital,
+			contenido_firmado, firma, clave_firma_id, huella_certificado, estado, reemplaza_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, receta.ID, receta.PacienteID, receta.UsuarioID, receta.Contenido, receta.FechaEmision, receta.FirmaDigital,
+		receta.ContenidoFirmado, receta.Firma, receta.ClaveFirmaID, receta.HuellaCertificado, receta.Estado, receta.ReemplazaID); err != nil {
+		return models.RecetaMedica{}, err
+	}
+	return receta, nil
+}
+
+// respondEmisionError traduce los errores de emitirReceta a respuestas HTTP
+func (h *RecetaHandler) respondEmisionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errPacienteNoEncontrado):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Paciente no encontrado"})
+	case errors.Is(err, errProfesionalInactivo):
+		c.JSON(http.StatusForbidden, gin.H{"error": "El profesional no está habilitado para firmar recetas"})
+	default:
+		h.logger.Error("Error al emitir receta", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo emitir la receta"})
+	}
+}
+
+// firmaDisponible responde 503 si el servidor no tiene configurada la clave maestra
+func (h *RecetaHandler) firmaDisponible(c *gin.Context) bool {
+	if len(h.masterKey) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "La firma de recetas no está configurada en el servidor"})
+		return false
+	}
+	return true
+}
+
+// CreateReceta godoc
+// @Summary      Emitir receta
+// @Description  Emite una receta firmada digitalmente con la clave del profesional autenticado
+// @Tags         recetas
+// @Accept       json
+// @Produce      json
+// @Param        receta  body  RecetaInput  true  "Datos de la receta"
+// @Success      201  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /api/v1/recetas [post]
+func (h *RecetaHandler) CreateReceta(c *gin.Context) {
+	if !h.firmaDisponible(c) {
+		return
+	}
+	autorID, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	var input RecetaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pacienteID := uuid.MustParse(input.PacienteID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo emitir la receta"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	receta, err := h.emitirReceta(ctx, tx, scope, autorID, pacienteID, input.Contenido, nil)
+	if err != nil {
+		h.respondEmisionError(c, err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		h.logger.Error("Error al confirmar receta", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo emitir la receta"})
+		return
+	}
+
+	h.logger.Info("Receta emitida",
+		zap.String("receta_id", receta.ID.String()),
+		zap.String("paciente_id", pacienteID.String()),
+		zap.String("usuario_id", autorID.String()))
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Receta emitida exitosamente",
+		"receta":  receta,
+	})
+}
+
+// GetReceta godoc
+// @Summary      Obtener receta
+// @Description  Obtiene una receta por su ID
+// @Tags         recetas
+// @Produce      json
+// @Param        id  path  string  true  "ID de la receta"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/recetas/{id} [get]
+func (h *RecetaHandler) GetReceta(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de receta inválido"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []interface{}{id}
+	receta, err := scanReceta(h.pool.QueryRow(ctx, `
+		SELECT `+recetaColumns+` FROM recetas_medicas WHERE id = $1 AND `+scope.Paciente("paciente_id", &args), args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receta no encontrada"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al obtener receta", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"receta": receta})
+}
+
+// PacienteDeReceta resuelve el paciente de la receta de la ruta, para controlar el
+// acceso antes de GetReceta. Devuelve uuid.Nil si la receta no existe.
+func (h *RecetaHandler) PacienteDeReceta(c *gin.Context) (uuid.UUID, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return uuid.Nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var pacienteID uuid.UUID
+	err = h.pool.QueryRow(ctx, `SELECT paciente_id FROM recetas_medicas WHERE id = $1`, id).Scan(&pacienteID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	return pacienteID, err
+}
+
+// GetRecetasByPaciente godoc
+// @Summary      Listar recetas de un paciente
+// @Description  Lista las recetas del paciente, de la más reciente a la más antigua
+// @Tags         recetas
+// @Produce      json
+// @Param        id  path  string  true  "ID del paciente"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /api/v1/pacientes/{id}/recetas [get]
+func (h *RecetaHandler) GetRecetasByPaciente(c *gin.Context) {
+	pacienteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de paciente inválido"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []interface{}{pacienteID}
+	rows, err := h.pool.Query(ctx, `
+		SELECT `+recetaColumns+`
+		FROM recetas_medicas
+		WHERE paciente_id = $1 AND `+scope.Paciente("paciente_id", &args)+`
+		ORDER BY fecha_emision DESC
+	`, args...)
+	if err != nil {
+		h.logger.Error("Error al listar recetas", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	recetas := []models.RecetaMedica{}
+	for rows.Next() {
+		r, err := scanReceta(rows)
+		if err != nil {
+			h.logger.Error("Error al escanear receta", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			return
+		}
+		recetas = append(recetas, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recetas": recetas,
+		"total":   len(recetas),
+	})
+}
+
+// revocar marca la receta como revocada; devuelve pgx.ErrNoRows si no existe
+// (o es de otro consultorio), errRecetaDeOtroAutor si no la firmó autorID y ok=false si
+// ya estaba revocada
+func revocar(ctx context.Context, tx pgx.Tx, scope tenant.Scope, id, autorID uuid.UUID, motivo string) (receta models.RecetaMedica, ok bool, err error) {
+	var (
+		estado     string
+		firmadaPor uuid.UUID
+	)
+	args := []interface{}{id}
+	if err := tx.QueryRow(ctx, `
+		SELECT estado, usuario_id FROM recetas_medicas WHERE id = $1 AND `+scope.Paciente("paciente_id", &args)+` FOR UPDATE
+	`, args...).Scan(&estado, &firmadaPor); err != nil {
+		return models.RecetaMedica{}, false, err
+	}
+	// Solo quien firmó la receta puede revocarla o reemplazarla con su firma
+	if firmadaPor != autorID {
+		return models.RecetaMedica{}, false, errRecetaDeOtroAutor
+	}
+	if estado != models.RecetaEmitida {
+		return models.RecetaMedica{}, false, nil
+	}
+	receta, err = scanReceta(tx.QueryRow(ctx, `
+		UPDATE recetas_medicas
+		SET estado = $2, revocada_en = NOW(), motivo_revocacion = $3
+		WHERE id = $1
+		RETURNING `+recetaColumns, id, models.RecetaRevocada, motivo))
+	return receta, err == nil, err
+}
+
+// entradaRevocacion arma la auditoría de la revocación de receta; al reemitir,
+// reemplazadaPor es la receta nueva
+func entradaRevocacion(c *gin.Context, accion string, receta models.RecetaMedica, reemplazadaPor *uuid.UUID) audit.Entry {
+	entry := audit.FromRequest(c, accion, "recetas_medicas", receta.ID.String())
+	entry.Antes = gin.H{"estado": models.RecetaEmitida}
+	despues := gin.H{
+		"estado":            receta.Estado,
+		"revocada_en":       receta.RevocadaEn,
+		"motivo_revocacion": receta.MotivoRevocacion,
+	}
+	if reemplazadaPor != nil {
+		despues["reemplazada_por"] = reemplazadaPor
+	}
+	entry.Despues = despues
+	return entry
+}
+
+// respondRevocacionError traduce los errores de revocar a respuestas HTTP
+func (h *RecetaHandler) respondRevocacionError(c *gin.Context, err error, mensaje string) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receta no encontrada"})
+	case errors.Is(err, errRecetaDeOtroAutor):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Solo el profesional que firmó la receta puede revocarla o reemitirla"})
+	default:
+		h.logger.Error("Error al revocar receta", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": mensaje})
+	}
+}
+
+// RevokeReceta godoc
+// @Summary      Revocar receta
+// @Description  Revoca una receta firmada por el profesional autenticado. Las recetas no pueden editarse: para corregirla debe reemitirse.
+// @Tags         recetas
+// @Accept       json
+// @Produce      json
+// @Param        id      path  string              true  "ID de la receta"
+// @Param        motivo  body  RevocarRecetaInput  true  "Motivo de la revocación"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      401  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Router       /api/v1/recetas/{id}/revocar [post]
+func (h *RecetaHandler) RevokeReceta(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de receta inválido"})
+		return
+	}
+	autorID, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	var input RevocarRecetaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo revocar la receta"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	receta, ok, err := revocar(ctx, tx, scope, id, autorID, input.Motivo)
+	if err != nil {
+		h.respondRevocacionError(c, err, "No se pudo revocar la receta")
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "La receta ya fue revocada"})
+		return
+	}
+	err = audit.Write(ctx, tx, entradaRevocacion(c, audit.AccionRevocarReceta, receta, nil))
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al confirmar revocación", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo revocar la receta"})
+		return
+	}
+
+	h.logger.Info("Receta revocada", zap.String("receta_id", id.String()), zap.String("usuario_id", autorID.String()))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Receta revocada exitosamente",
+		"receta":  receta,
+	})
+}
+
+// ReissueReceta godoc
+// @Summary      Reemitir receta
+// @Description  Revoca una receta firmada por el profesional autenticado y emite una nueva que la reemplaza, para el mismo paciente
+// @Tags         recetas
+// @Accept       json
+// @Produce      json
+// @Param        id      path  string               true  "ID de la receta a reemplazar"
+// @Param        receta  body  ReemitirRecetaInput  true  "Nuevo contenido y motivo"
+// @Success      201  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /api/v1/recetas/{id}/reemitir [post]
+func (h *RecetaHandler) ReissueReceta(c *gin.Context) {
+	if !h.firmaDisponible(c) {
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de receta inválido"})
+		return
+	}
+	autorID, ok := usuarioActual(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	var input ReemitirRecetaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo reemitir la receta"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	anterior, ok, err := revocar(ctx, tx, scope, id, autorID, input.Motivo)
+	if err != nil {
+		h.respondRevocacionError(c, err, "No se pudo reemitir la receta")
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "La receta ya fue revocada"})
+		return
+	}
+
+	nueva, err := h.emitirReceta(ctx, tx, scope, autorID, anterior.PacienteID, input.Contenido, &anterior.ID)
+	if err != nil {
+		h.respondEmisionError(c, err)
+		return
+	}
+	err = audit.Write(ctx, tx, entradaRevocacion(c, audit.AccionReemitirReceta, anterior, &nueva.ID))
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al confirmar reemisión", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo reemitir la receta"})
+		return
+	}
+
+	h.logger.Info("Receta reemitida",
+		zap.String("receta_id", nueva.ID.String()),
+		zap.String("reemplaza_id", anterior.ID.String()),
+		zap.String("usuario_id", autorID.String()))
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Receta reemitida exitosamente",
+		"receta":   nueva,
+		"revocada": anterior,
+	})
+}
+
+// VerifyReceta godoc
+// @Summary      Verificar receta
+// @Description  Endpoint público para farmacias: verifica la firma, el certificado del profesional y que los datos guardados coincidan con lo firmado. El documento firmado (con los datos del paciente) solo se devuelve si se presenta su hash.
+// @Tags         recetas
+// @Produce      json
+// @Param        id    path   string  true   "ID de la receta"
+// @Param        hash  query  string  false  "SHA-256 en hexadecimal del documento firmado"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/recetas/{id}/verify [get]
+func (h *RecetaHandler) VerifyReceta(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de receta inválido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var (
+		receta models.RecetaMedica
+		clave  claveVerificacion
+	)
+	err = h.pool.QueryRow(ctx, `
+		SELECT r.id, r.usuario_id, r.contenido, r.fecha_emision, r.contenido_firmado, r.firma,
+			r.huella_certificado, r.estado, r.revocada_en, r.motivo_revocacion, r.reemplaza_id,
+			k.certificado, k.huella, k.revocada_en
+		FROM recetas_medicas r
+		LEFT JOIN claves_firma k ON k.id = r.clave_firma_id
+		WHERE r.id = $1
+	`, id).Scan(&receta.ID, &receta.UsuarioID, &receta.Contenido, &receta.FechaEmision, &receta.ContenidoFirmado,
+		&receta.Firma, &receta.HuellaCertificado, &receta.Estado, &receta.RevocadaEn, &receta.MotivoRevocacion,
+		&receta.ReemplazaID, &clave.certificado, &clave.huella, &clave.revocadaEn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Receta no encontrada"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Error al obtener receta para verificar", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	doc, motivo := verificarReceta(receta, clave, time.Now())
+	valida := motivo == ""
+
+	// Sin autenticación solo se informa la validez: el documento firmado tiene los datos del
+	// paciente y el contenido de la receta
+	respuesta := gin.H{
+		"receta_id":     receta.ID,
+		"valida":        valida,
+		"vigente":       valida && receta.Estado == models.RecetaEmitida,
+		"estado":        receta.Estado,
+		"fecha_emision": receta.FechaEmision,
+	}
+	if !valida {
+		respuesta["motivo"] = motivo
+		h.logger.Warn("Verificación de receta fallida",
+			zap.String("receta_id", receta.ID.String()),
+			zap.String("motivo", motivo))
+	}
+	if doc != nil {
+		respuesta["profesional"] = doc.Profesional
+	}
+	if receta.Estado == models.RecetaRevocada {
+		respuesta["revocada_en"] = receta.RevocadaEn
+	}
+	if len(clave.certificado) > 0 {
+		respuesta["huella_certificado"] = clave.huella
+	}
+
+	// Quien presenta el hash del documento (impreso en la receta) ya tiene su contenido:
+	// recibe el documento, el certificado y la firma para verificarla por su cuenta
+	hash := strings.ToLower(c.Query("hash"))
+	esperado := hashDocumento(receta.ContenidoFirmado)
+	if hash != "" && esperado != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(esperado)) == 1 {
+		if doc != nil {
+			respuesta["receta"] = doc
+		}
+		if receta.Estado == models.RecetaRevocada {
+			respuesta["motivo_revocacion"] = receta.MotivoRevocacion
+		}
+		if len(clave.certificado) > 0 {
+			respuesta["certificado"] = security.CertificatePEM(clave.certificado)
+			respuesta["firma"] = base64.StdEncoding.EncodeToString(receta.Firma)
+		}
+	}
+	c.JSON(http.StatusOK, respuesta)
+}
+
+// claveVerificacion es la clave de firma registrada para una receta
+type claveVerificacion struct {
+	certificado []byte
+	huella      *string
+	revocadaEn  *time.Time
+}
+
+// verificarReceta comprueba la firma, que la clave no esté revocada ni su certificado
+// vencido a la fecha ahora, y que la fila coincida con el documento firmado. Devuelve el
+// documento firmado (si puede decodificarse) y el motivo del rechazo, vacío si la receta
+// es válida.
+func verificarReceta(receta models.RecetaMedica, clave claveVerificacion, ahora time.Time) (*RecetaFirmada, string) {
+	certificado, huellaClave := clave.certificado, clave.huella
+	if len(receta.Firma) == 0 || len(receta.ContenidoFirmado) == 0 || len(certificado) == 0 {
+		return nil, "La receta no tiene firma digital"
+	}
+	if huellaClave == nil || security.CertificateFingerprint(certificado) != *huellaClave ||
+		receta.HuellaCertificado == nil || *receta.HuellaCertificado != *huellaClave {
+		return nil, "El certificado no coincide con el registrado para la receta"
+	}
+	if clave.revocadaEn != nil {
+		return nil, "La clave de firma del profesional fue revocada"
+	}
+	cert, err := x509.ParseCertificate(certificado)
+	if err != nil {
+		return nil, "El certificado no coincide con el registrado para la receta"
+	}
+	if ahora.After(cert.NotAfter) || receta.FechaEmision.Before(cert.NotBefore) {
+		return nil, "El certificado del profesional no está vigente"
+	}
+	if err := security.VerifySignature(certificado, receta.ContenidoFirmado, receta.Firma); err != nil {
+		return nil, "La firma no corresponde al contenido de la receta"
+	}
+
+	var doc RecetaFirmada
+	if err := json.Unmarshal(receta.ContenidoFirmado, &doc); err != nil {
+		return nil, "El contenido firmado no tiene un formato válido"
+	}
+	// Re-serializar garantiza que lo firmado está en forma canónica
+	if canonico, err := serializarReceta(doc); err != nil || !bytes.Equal(canonico, receta.ContenidoFirmado) {
+		return &doc, "El contenido firmado no está en forma canónica"
+	}
+
+	var reemplazaID *string
+	if receta.ReemplazaID != nil {
+		id := receta.ReemplazaID.String()
+		reemplazaID = &id
+	}
+	if doc.ID != receta.ID.String() ||
+		doc.Profesional.ID != receta.UsuarioID.String() ||
+		receta.Contenido == nil || doc.Contenido != *receta.Contenido ||
+		doc.FechaEmision != formatoFechaFirma(receta.FechaEmision) ||
+		doc.HuellaCertificado != *huellaClave ||
+		!equalStringPtr(doc.ReemplazaID, reemplazaID) {
+		return &doc, "Los datos guardados no coinciden con los firmados"
+	}
+	return &doc, ""
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/models"
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+var recetasMasterKey = []byte("0123456789abcdef0123456789abcdef")
+
+// recetaEmitida guarda lo que el handler insertó al emitir una receta
+type recetaEmitida struct {
+	args        []interface{}
+	certificado []byte
+	huella      string
+}
+
+// emisionTx simula la transacción de emisión: paciente y profesional existentes,
+// sin clave de firma previa
+func emisionTx(emitida *recetaEmitida) *mockTx {
+	return &mockTx{
+		queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+			switch {
+			case strings.Contains(sql, "FROM pacientes"):
+				return mockRowP{scanFunc: func(dest ...interface{}) error {
+					setDest(dest, 0, "Ana")
+					setDest(dest, 1, "García")
+					setDest(dest, 2, time.Date(1980, 5, 17, 0, 0, 0, 0, time.UTC))
+					setDest(dest, 3, strPtr("OS-123"))
+					return nil
+				}}
+			case strings.Contains(sql, "FROM usuarios"):
+				return mockRowP{scanFunc: func(dest ...interface{}) error {
+					setDest(dest, 0, "Dra. Pérez")
+					return nil
+				}}
+			}
+			return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			switch {
+			case strings.Contains(sql, "INSERT INTO claves_firma"):
+				emitida.certificado = args[2].([]byte)
+				emitida.huella = args[3].(string)
+			case strings.Contains(sql, "INSERT INTO recetas_medicas"):
+				emitida.args = args
+			}
+			return pgconn.NewCommandTag("INSERT 0 1"), nil
+		},
+	}
+}
+
+func recetaCtx(method, path string, body []byte, id uuid.UUID, autor *uuid.UUID) (*gin.Context, *httptest.ResponseRecorder) {
+	c, w := makeCtx(method, path, body)
+	if id != uuid.Nil {
+		c.Params = gin.Params{{Key: "id", Value: id.String()}}
+	}
+	if autor != nil {
+		c.Set("user_id", autor.String())
+	}
+	return c, w
+}
+
+// verifyRow simula la consulta de VerifyReceta a partir de una receta emitida
+func verifyRow(receta models.RecetaMedica, certificado []byte, huella string) mockRowP {
+	return mockRowP{scanFunc: func(dest ...interface{}) error {
+		setDest(dest, 0, receta.ID)
+		setDest(dest, 1, receta.UsuarioID)
+		setDest(dest, 2, receta.Contenido)
+		setDest(dest, 3, receta.FechaEmision)
+		setDest(dest, 4, receta.ContenidoFirmado)
+		setDest(dest, 5, receta.Firma)
+		setDest(dest, 6, receta.HuellaCertificado)
+		setDest(dest, 7, receta.Estado)
+		if receta.ReemplazaID != nil {
+			setDest(dest, 10, *receta.ReemplazaID)
+		}
+		setDest(dest, 11, certificado)
+		setDest(dest, 12, &huella)
+		return nil
+	}}
+}
+
+// emitirRecetaDePrueba emite una receta con el handler y devuelve la fila tal como se guardó
+func emitirRecetaDePrueba(t *testing.T) (models.RecetaMedica, []byte, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	pacienteID := uuid.New()
+	autor := uuid.New()
+	emitida := &recetaEmitida{}
+	pool := &mockTxPool{tx: emisionTx(emitida)}
+	h := NewRecetaHandler(pool, recetasMasterKey, zap.NewNop())
+
+	body := []byte(`{"paciente_id":"` + pacienteID.String() + `","contenido":"Amoxicilina 500 mg c/8h por 7 días"}`)
+	c, w := recetaCtx("POST", "/api/v1/recetas", body, uuid.Nil, &autor)
+	h.CreateReceta(c)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Se esperaba status 201, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	if !pool.tx.committed {
+		t.Fatal("Se esperaba que la transacción se confirmara")
+	}
+	if emitida.args == nil || emitida.certificado == nil {
+		t.Fatal("Se esperaba que se insertaran la clave de firma y la receta")
+	}
+
+	a := emitida.args
+	receta := models.RecetaMedica{
+		ID:                a[0].(uuid.UUID),
+		PacienteID:        a[1].(uuid.UUID),
+		UsuarioID:         a[2].(uuid.UUID),
+		Contenido:         a[3].(*string),
+		FechaEmision:      a[4].(time.Time),
+		FirmaDigital:      a[5].(bool),
+		ContenidoFirmado:  a[6].([]byte),
+		Firma:             a[7].([]byte),
+		HuellaCertificado: a[9].(*string),
+		Estado:            a[10].(string),
+		ReemplazaID:       a[11].(*uuid.UUID),
+	}
+	return receta, emitida.certificado, emitida.huella
+}
+
+func TestCreateReceta_SignsCanonicalDocument(t *testing.T) {
+	receta, certificado, huella := emitirRecetaDePrueba(t)
+
+	if !receta.FirmaDigital || receta.Estado != models.RecetaEmitida {
+		t.Errorf("Se esperaba receta emitida con firma digital, obtuvo firma=%v estado=%s", receta.FirmaDigital, receta.Estado)
+	}
+	if receta.HuellaCertificado == nil || *receta.HuellaCertificado != huella || huella != security.CertificateFingerprint(certificado) {
+		t.Error("Se esperaba que la receta guardara la huella del certificado del profesional")
+	}
+	if err := security.VerifySignature(certificado, receta.ContenidoFirmado, receta.Firma); err != nil {
+		t.Fatalf("Se esperaba firma válida sobre el contenido firmado: %v", err)
+	}
+
+	var doc RecetaFirmada
+	if err := json.Unmarshal(receta.ContenidoFirmado, &doc); err != nil {
+		t.Fatalf("El contenido firmado no es JSON válido: %v", err)
+	}
+	if doc.Paciente.Apellido != "García" || doc.Paciente.FechaNacimiento != "1980-05-17" ||
+		doc.Profesional.Nombre != "Dra. Pérez" || doc.HuellaCertificado != huella {
+		t.Errorf("Documento firmado inesperado: %+v", doc)
+	}
+	if _, motivo := verificarReceta(receta, claveVerificacion{certificado: certificado, huella: &huella}, time.Now()); motivo != "" {
+		t.Errorf("Se esperaba que la receta recién emitida verificara, motivo: %s", motivo)
+	}
+}
+
+func TestCreateReceta_WithoutMasterKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	autor := uuid.New()
+	h := NewRecetaHandler(&mockTxPool{}, nil, zap.NewNop())
+	c, w := recetaCtx("POST", "/api/v1/recetas", []byte(`{}`), uuid.Nil, &autor)
+	h.CreateReceta(c)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Se esperaba status 503, obtuvo %d", w.Code)
+	}
+}
+
+func TestCreateReceta_PacienteNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	autor := uuid.New()
+	pool := &mockTxPool{tx: &mockTx{}}
+	h := NewRecetaHandler(pool, recetasMasterKey, zap.NewNop())
+	body := []byte(`{"paciente_id":"` + uuid.New().String() + `","contenido":"Ibuprofeno"}`)
+	c, w := recetaCtx("POST", "/api/v1/recetas", body, uuid.Nil, &autor)
+	h.CreateReceta(c)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Se esperaba status 404, obtuvo %d", w.Code)
+	}
+	if pool.tx.committed {
+		t.Error("No se esperaba confirmar la transacción")
+	}
+}
+
+func TestVerifyReceta_Valid(t *testing.T) {
+	receta, certificado, huella := emitirRecetaDePrueba(t)
+	pool := &mockTxPool{mockPoolP: mockPoolP{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return verifyRow(receta, certificado, huella)
+	}}}
+	h := NewRecetaHandler(pool, nil, zap.NewNop())
+	c, w := recetaCtx("GET", "/api/v1/recetas/x/verify", nil, receta.ID, nil)
+	h.VerifyReceta(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", w.Code)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["valida"] != true || resp["vigente"] != true || resp["huella_certificado"] != huella {
+		t.Errorf("Se esperaba receta válida y vigente, obtuvo %v", resp)
+	}
+	if resp["profesional"].(map[string]interface{})["nombre"] != "Dra. Pérez" {
+		t.Errorf("Se esperaba el profesional firmante, obtuvo %v", resp["profesional"])
+	}
+	// Sin el hash del documento no se exponen el paciente ni el contenido
+	for _, campo := range []string{"receta", "certificado", "firma"} {
+		if _, ok := resp[campo]; ok {
+			t.Errorf("No se esperaba %q sin el hash del documento", campo)
+		}
+	}
+	for _, dato := range []string{"García", "OS-123", "1980-05-17", "Amoxicilina"} {
+		if strings.Contains(w.Body.String(), dato) {
+			t.Errorf("La verificación pública expone %q: %s", dato, w.Body.String())
+		}
+	}
+
+	// Con el hash del documento impreso se devuelve todo para verificar la firma
+	c, w = recetaCtx("GET", "/api/v1/recetas/x/verify?hash="+hashDocumento(receta.ContenidoFirmado), nil, receta.ID, nil)
+	h.VerifyReceta(c)
+	resp = nil
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["receta"] == nil || !strings.Contains(resp["certificado"].(string), "BEGIN CERTIFICATE") {
+		t.Errorf("Se esperaban el documento y el certificado en PEM, obtuvo %v", resp)
+	}
+
+	c, w = recetaCtx("GET", "/api/v1/recetas/x/verify?hash="+strings.Repeat("0", 64), nil, receta.ID, nil)
+	h.VerifyReceta(c)
+	if strings.Contains(w.Body.String(), "García") {
+		t.Errorf("Un hash incorrecto no debe exponer el documento: %s", w.Body.String())
+	}
+}
+
+func TestVerifyReceta_TamperedContent(t *testing.T) {
+	receta, certificado, huella := emitirRecetaDePrueba(t)
+	// Alguien modifica el contenido directamente en la base
+	receta.Contenido = strPtr("Amoxicilina 875 mg c/8h por 7 días")
+
+	pool := &mockTxPool{mockPoolP: mockPoolP{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return verifyRow(receta, certificado, huella)
+	}}}
+	h := NewRecetaHandler(pool, nil, zap.NewNop())
+	c, w := recetaCtx("GET", "/api/v1/recetas/x/verify", nil, receta.ID, nil)
+	h.VerifyReceta(c)
+
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["valida"] != false || resp["vigente"] != false {
+		t.Errorf("Se esperaba receta inválida, obtuvo %v", resp)
+	}
+}
+
+func TestVerificarReceta_RejectsForgeries(t *testing.T) {
+	receta, certificado, huella := emitirRecetaDePrueba(t)
+
+	firmaAlterada := append([]byte(nil), receta.Firma...)
+	firmaAlterada[0] ^= 0xff
+	conFirmaAlterada := receta
+	conFirmaAlterada.Firma = firmaAlterada
+	if _, motivo := verificarReceta(conFirmaAlterada, claveVerificacion{certificado: certificado, huella: &huella}, time.Now()); motivo == "" {
+		t.Error("Se esperaba rechazar una firma alterada")
+	}
+
+	otro, _ := security.GenerateSigningIdentity("Otro", "x")
+	otraHuella := security.CertificateFingerprint(otro.Certificate)
+	if _, motivo := verificarReceta(receta, claveVerificacion{certificado: otro.Certificate, huella: &otraHuella}, time.Now()); motivo == "" {
+		t.Error("Se esperaba rechazar un certificado distinto al registrado")
+	}
+
+	sinFirma := receta
+	sinFirma.Firma = nil
+	if _, motivo := verificarReceta(sinFirma, claveVerificacion{}, time.Now()); motivo == "" {
+		t.Error("Se esperaba rechazar una receta sin firma")
+	}
+
+	revocada := time.Now()
+	if _, motivo := verificarReceta(receta, claveVerificacion{certificado: certificado, huella: &huella, revocadaEn: &revocada}, time.Now()); motivo == "" {
+		t.Error("Se esperaba rechazar una receta firmada con una clave revocada")
+	}
+	if _, motivo := verificarReceta(receta, claveVerificacion{certificado: certificado, huella: &huella}, time.Now().Add(security.SigningCertValidity+time.Hour)); motivo == "" {
+		t.Error("Se esperaba rechazar una receta con el certificado vencido")
+	}
+}
+
+func TestRevokeReceta_AlreadyRevoked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	autor := uuid.New()
+	tx := &mockTx{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			setDest(dest, 0, models.RecetaRevocada)
+			setDest(dest, 1, autor)
+			return nil
+		}}
+	}}
+	h := NewRecetaHandler(&mockTxPool{tx: tx}, recetasMasterKey, zap.NewNop())
+	c, w := recetaCtx("POST", "/api/v1/recetas/x/revocar", []byte(`{"motivo":"error de dosis"}`), uuid.New(), &autor)
+	h.RevokeReceta(c)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Se esperaba status 409, obtuvo %d", w.Code)
+	}
+	if tx.committed {
+		t.Error("No se esperaba confirmar la transacción")
+	}
+}
+
+func TestRevokeReceta_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	autor := uuid.New()
+	h := NewRecetaHandler(&mockTxPool{tx: &mockTx{}}, recetasMasterKey, zap.NewNop())
+	c, w := recetaCtx("POST", "/api/v1/recetas/x/revocar", []byte(`{"motivo":"error"}`), uuid.New(), &autor)
+	h.RevokeReceta(c)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Se esperaba status 404, obtuvo %d", w.Code)
+	}
+}
+
+// revocacionTx simula una receta emitida por firmante y guarda la acción auditada
+func revocacionTx(firmante uuid.UUID, accion *string) *mockTx {
+	return &mockTx{
+		queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				switch {
+				case strings.Contains(sql, "SELECT estado, usuario_id"):
+					setDest(dest, 0, models.RecetaEmitida)
+					setDest(dest, 1, firmante)
+					return nil
+				case strings.Contains(sql, "UPDATE recetas_medicas"):
+					setDest(dest, 0, args[0].(uuid.UUID))
+					setDest(dest, 8, models.RecetaRevocada)
+					return nil
+				}
+				return pgx.ErrNoRows
+			}}
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "INSERT INTO auditorias") {
+				*accion = args[1].(string)
+			}
+			return pgconn.NewCommandTag("INSERT 0 1"), nil
+		},
+	}
+}
+
+// TestRevokeReceta_SoloElFirmante verifica que la revocación queda auditada y que otro
+// profesional del consultorio no puede revocar ni reemitir la receta
+func TestRevokeReceta_SoloElFirmante(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	firmante := uuid.New()
+	body := []byte(`{"motivo":"error de dosis","contenido":"Amoxicilina 875 mg"}`)
+
+	var accion string
+	tx := revocacionTx(firmante, &accion)
+	h := NewRecetaHandler(&mockTxPool{tx: tx}, recetasMasterKey, zap.NewNop())
+	c, w := recetaCtx("POST", "/api/v1/recetas/x/revocar", body, uuid.New(), &firmante)
+	h.RevokeReceta(c)
+	if w.Code != http.StatusOK || !tx.committed {
+		t.Fatalf("Se esperaba status 200 y la revocación confirmada, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	if accion != audit.AccionRevocarReceta {
+		t.Errorf("Se esperaba auditar %q, obtuvo %q", audit.AccionRevocarReceta, accion)
+	}
+
+	otro := uuid.New()
+	for nombre, accionar := range map[string]func(*RecetaHandler, *gin.Context){
+		"revocar":  (*RecetaHandler).RevokeReceta,
+		"reemitir": (*RecetaHandler).ReissueReceta,
+	} {
+		accion = ""
+		tx := revocacionTx(firmante, &accion)
+		h := NewRecetaHandler(&mockTxPool{tx: tx}, recetasMasterKey, zap.NewNop())
+		c, w := recetaCtx("POST", "/api/v1/recetas/x/"+nombre, body, uuid.New(), &otro)
+		accionar(h, c)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: se esperaba status 403, obtuvo %d", nombre, w.Code)
+		}
+		if tx.committed || len(tx.execSQL) > 0 {
+			t.Errorf("%s: no se esperaba escribir en la base: %v", nombre, tx.execSQL)
+		}
+	}
+
+	c, w = recetaCtx("POST", "/api/v1/recetas/x/revocar", body, uuid.New(), nil)
+	h.RevokeReceta(c)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Se esperaba status 401 sin usuario, obtuvo %d", w.Code)
+	}
+}
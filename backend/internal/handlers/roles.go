@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// PermissionCache permite invalidar los permisos cacheados de un rol
+type PermissionCache interface {
+	Invalidate(rolID int)
+}
+
+// RolHandler administra la asignación de permisos a roles
+type RolHandler struct {
+	pool   TxPool
+	perms  PermissionCache
+	logger *zap.Logger
+}
+
+// NewRolHandler crea una nueva instancia del handler de roles
+func NewRolHandler(pool TxPool, perms PermissionCache, logger *zap.Logger) *RolHandler {
+	return &RolHandler{
+		pool:   pool,
+		perms:  perms,
+		logger: logger,
+	}
+}
+
+// RolConPermisos representa un rol junto con los nombres de sus permisos
+type RolConPermisos struct {
+	ID        int      `json:"id"`
+	NombreRol string   `json:"nombre_rol"`
+	Permisos  []string `json:"permisos"`
+	// MFARequerido obliga a los usuarios del rol a usar autenticación en dos pasos
+	MFARequerido bool `json:"mfa_requerido"`
+}
+
+// GetRoles godoc
+// @Summary      Listar roles
+// @Description  Lista los roles con sus permisos asignados
+// @Tags         roles
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/roles [get]
+func (h *RolHandler) GetRoles(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.pool.Query(ctx, `
+		SELECT r.id, r.nombre_rol, COALESCE(array_agg(p.nombre_permiso ORDER BY p.nombre_permiso)
+			FILTER (WHERE p.nombre_permiso IS NOT NULL), '{}'), r.mfa_requerido
+		FROM roles r
+		LEFT JOIN rol_permiso rp ON rp.rol_id = r.id
+		LEFT JOIN permisos p ON p.id = rp.permiso_id
+		GROUP BY r.id, r.nombre_rol, r.mfa_requerido
+		ORDER BY r.id
+	`)
+	if err != nil {
+		h.logger.Error("Error al consultar roles", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	roles := make([]RolConPermisos, 0)
+	for rows.Next() {
+		var r RolConPermisos
+		if err := rows.Scan(&r.ID, &r.NombreRol, &r.Permisos, &r.MFARequerido); err != nil {
+			h.logger.Error("Error al escanear rol", zap.Error(err))
+			continue
+		}
+		roles = append(roles, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"roles":  roles,
+	})
+}
+
+// AddPermisoToRol godoc
+// @Summary      Asignar permiso a rol
+// @Description  Asigna un permiso existente a un rol e invalida la caché de permisos. El cambio queda auditado.
+// @Tags         roles
+// @Produce      json
+// @Param        id       path  int     true  "ID del rol"
+// @Param        permiso  path  string  true  "Nombre del permiso (ej: pacientes:write)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/roles/{id}/permisos/{permiso} [put]
+func (h *RolHandler) AddPermisoToRol(c *gin.Context) {
+	rolID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de rol inválido"})
+		return
+	}
+	permiso := c.Param("permiso")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo asignar el permiso"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx, `
+		INSERT INTO rol_permiso (rol_id, permiso_id)
+		SELECT r.id, p.id
+		FROM roles r, permisos p
+		WHERE r.id = $1 AND p.nombre_permiso = $2
+		ON CONFLICT DO NOTHING
+	`, rolID, permiso)
+	if err != nil {
+		h.logger.Error("Error al asignar permiso", zap.Error(err), zap.Int("rol_id", rolID), zap.String("permiso", permiso))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo asignar el permiso"})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		var existe bool
+		err := tx.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM rol_permiso rp JOIN permisos p ON p.id = rp.permiso_id
+				WHERE rp.rol_id = $1 AND p.nombre_permiso = $2
+			)
+		`, rolID, permiso).Scan(&existe)
+		if err != nil || !existe {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Rol o permiso no encontrado"})
+			return
+		}
+		// Ya estaba asignado: no hay cambio que auditar
+		c.JSON(http.StatusOK, gin.H{"message": "Permiso asignado exitosamente"})
+		return
+	}
+
+	if err := h.auditarYConfirmar(ctx, tx, c, audit.AccionAsignarPermiso, rolID, permiso); err != nil {
+		h.logger.Error("Error al auditar asignación de permiso", zap.Error(err), zap.Int("rol_id", rolID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo asignar el permiso"})
+		return
+	}
+
+	h.perms.Invalidate(rolID)
+	h.logger.Info("Permiso asignado a rol", zap.Int("rol_id", rolID), zap.String("permiso", permiso))
+	c.JSON(http.StatusOK, gin.H{"message": "Permiso asignado exitosamente"})
+}
+
+// RemovePermisoFromRol godoc
+// @Summary      Quitar permiso a rol
+// @Description  Quita un permiso de un rol e invalida la caché de permisos. El cambio queda auditado.
+// @Tags         roles
+// @Produce      json
+// @Param        id       path  int     true  "ID del rol"
+// @Param        permiso  path  string  true  "Nombre del permiso (ej: pacientes:write)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/roles/{id}/permisos/{permiso} [delete]
+func (h *RolHandler) RemovePermisoFromRol(c *gin.Context) {
+	rolID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de rol inválido"})
+		return
+	}
+	permiso := c.Param("permiso")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al iniciar transacción", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo quitar el permiso"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	res, err := tx.Exec(ctx, `
+		DELETE FROM rol_permiso
+		WHERE rol_id = $1
+		  AND permiso_id = (SELECT id FROM permisos WHERE nombre_permiso = $2)
+	`, rolID, permiso)
+	if err != nil {
+		h.logger.Error("Error al quitar permiso", zap.Error(err), zap.Int("rol_id", rolID), zap.String("permiso", permiso))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo quitar el permiso"})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "El rol no tiene asignado ese permiso"})
+		return
+	}
+
+	if err := h.auditarYConfirmar(ctx, tx, c, audit.AccionQuitarPermiso, rolID, permiso); err != nil {
+		h.logger.Error("Error al auditar baja de permiso", zap.Error(err), zap.Int("rol_id", rolID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo quitar el permiso"})
+		return
+	}
+
+	h.perms.Invalidate(rolID)
+	h.logger.Info("Permiso quitado de rol", zap.Int("rol_id", rolID), zap.String("permiso", permiso))
+	c.JSON(http.StatusOK, gin.H{"message": "Permiso quitado exitosamente"})
+}
+
+// auditarYConfirmar registra el cambio de permisos del rol en la transacción y la confirma
+func (h *RolHandler) auditarYConfirmar(ctx context.Context, tx pgx.Tx, c *gin.Context, accion string, rolID int, permiso string) error {
+	entry := audit.FromRequest(c, accion, "rol_permiso", strconv.Itoa(rolID)+":"+permiso)
+	cambio := gin.H{"rol_id": rolID, "permiso": permiso}
+	if accion == audit.AccionQuitarPermiso {
+		entry.Antes = cambio
+	} else {
+		entry.Despues = cambio
+	}
+	if err := audit.Write(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SetMFARequerido godoc
+// @Summary      Exigir autenticación en dos pasos a un rol
+// @Description  Marca si los usuarios del rol deben usar TOTP. Los usuarios sin enrolar lo configuran en su próximo login; las sesiones abiertas no se cierran.
+// @Tags         roles
+// @Accept       json
+// @Produce      json
+// @Param        id    path  int     true  "ID del rol"
+// @Param        mfaReq  body  object  true  "Campo requerido (bool)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/roles/{id}/mfa [put]
+func (h *RolHandler) SetMFARequerido(c *gin.Context) {
+	rolID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de rol inválido"})
+		return
+	}
+	var req struct {
+		Requerido *bool `json:"requerido" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := h.pool.Exec(ctx, `UPDATE roles SET mfa_requerido = $2 WHERE id = $1`, rolID, *req.Requerido)
+	if err != nil {
+		h.logger.Error("Error al actualizar MFA del rol", zap.Error(err), zap.Int("rol_id", rolID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo actualizar el rol"})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rol no encontrado"})
+		return
+	}
+
+	h.logger.Info("MFA del rol actualizado", zap.Int("rol_id", rolID), zap.Bool("mfa_requerido", *req.Requerido))
+	c.JSON(http.StatusOK, gin.H{"message": "Rol actualizado exitosamente", "mfa_requerido": *req.Requerido})
+}
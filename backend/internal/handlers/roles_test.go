@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+type fakePermCache struct{ invalidados []int }
+
+func (f *fakePermCache) Invalidate(rolID int) { f.invalidados = append(f.invalidados, rolID) }
+
+func runPermisoRol(t *testing.T, pool *mockTxPool, perms *fakePermCache, method string, handler func(*RolHandler) gin.HandlerFunc) int {
+	t.Helper()
+	h := NewRolHandler(pool, perms, zap.NewNop())
+	c, w := makeCtx(method, "/api/v1/roles/3/permisos/pacientes:write", nil)
+	c.Params = gin.Params{{Key: "id", Value: "3"}, {Key: "permiso", Value: "pacientes:write"}}
+	handler(h)(c)
+	return w.Code
+}
+
+func auditoRol(sqls []string) bool {
+	for _, sql := range sqls {
+		if strings.Contains(sql, "INSERT INTO auditorias") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPermisosRol_Auditados(t *testing.T) {
+	casos := []struct {
+		nombre  string
+		method  string
+		handler func(*RolHandler) gin.HandlerFunc
+		tag     string
+	}{
+		{"asignar", http.MethodPost, func(h *RolHandler) gin.HandlerFunc { return h.AddPermisoToRol }, "INSERT 0 1"},
+		{"quitar", http.MethodDelete, func(h *RolHandler) gin.HandlerFunc { return h.RemovePermisoFromRol }, "DELETE 1"},
+	}
+	for _, tc := range casos {
+		t.Run(tc.nombre, func(t *testing.T) {
+			tx := &mockTx{execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+				if strings.Contains(sql, "rol_permiso") {
+					return pgconn.NewCommandTag(tc.tag), nil
+				}
+				return pgconn.NewCommandTag("INSERT 0 1"), nil
+			}}
+			perms := &fakePermCache{}
+
+			if code := runPermisoRol(t, &mockTxPool{tx: tx}, perms, tc.method, tc.handler); code != http.StatusOK {
+				t.Fatalf("Se esperaba 200, se obtuvo %d", code)
+			}
+			if !auditoRol(tx.execSQL) || !tx.committed {
+				t.Errorf("Se esperaba auditar el cambio en la misma transacción, SQL: %v", tx.execSQL)
+			}
+			if len(perms.invalidados) != 1 || perms.invalidados[0] != 3 {
+				t.Errorf("Se esperaba invalidar la caché del rol 3, se invalidó %v", perms.invalidados)
+			}
+		})
+	}
+}
+
+func TestRemovePermisoFromRol_NoAsignadoNoAudita(t *testing.T) {
+	tx := &mockTx{execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+		return pgconn.NewCommandTag("DELETE 0"), nil
+	}}
+	perms := &fakePermCache{}
+
+	code := runPermisoRol(t, &mockTxPool{tx: tx}, perms, http.MethodDelete, func(h *RolHandler) gin.HandlerFunc { return h.RemovePermisoFromRol })
+	if code != http.StatusNotFound {
+		t.Fatalf("Se esperaba 404, se obtuvo %d", code)
+	}
+	if auditoRol(tx.execSQL) || tx.committed || len(perms.invalidados) != 0 {
+		t.Errorf("Sin cambios no debe auditarse ni invalidarse la caché, SQL: %v", tx.execSQL)
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Sesiones es el registro de sesiones abiertas por dispositivo (services.SessionService)
+type Sesiones interface {
+	Registrar(ctx context.Context, id, usuarioID, dispositivo, ip string) error
+	Listar(ctx context.Context, usuarioID string) ([]services.Sesion, error)
+	Cerrar(ctx context.Context, usuarioID, id string) (services.Sesion, error)
+}
+
+// SesionVista es una sesión tal como se lista; Actual marca la del token del pedido
+type SesionVista struct {
+	services.Sesion
+	Actual bool `json:"actual"`
+}
+
+func vistasSesiones(sesiones []services.Sesion, actual string) []SesionVista {
+	vistas := make([]SesionVista, len(sesiones))
+	for i, s := range sesiones {
+		vistas[i] = SesionVista{Sesion: s, Actual: actual != "" && s.ID == actual}
+	}
+	return vistas
+}
+
+// entradaCierreSesion arma la auditoría del cierre de la sesión s de usuarioID
+func entradaCierreSesion(c *gin.Context, usuarioID string, s services.Sesion) audit.Entry {
+	entry := audit.FromRequest(c, audit.AccionCerrarSesion, "usuarios", usuarioID)
+	entry.Antes = gin.H{"sesion": s.ID, "dispositivo": s.Dispositivo, "ip": s.IP, "creada_en": s.CreadaEn}
+	entry.Despues = gin.H{"sesion": s.ID, "cerrada": true}
+	return entry
+}
+
+// GetSesiones godoc
+// @Summary      Sesiones abiertas
+// @Description  Lista los dispositivos en los que el usuario autenticado tiene la sesión abierta, con la IP, el inicio y la última actividad de cada uno. La sesión del token usado se marca como actual.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      501  {object}  map[string]interface{}
+// @Router       /api/v1/me/sessions [get]
+func (h *AuthHandler) GetSesiones(c *gin.Context) {
+	if h.sesiones == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Registro de sesiones no disponible en esta instancia"})
+		return
+	}
+	userID := c.GetString("user_id")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sesiones, err := h.sesiones.Listar(ctx, userID)
+	if err != nil {
+		h.logger.Error("Error al listar sesiones", zap.Error(err), zap.String("user_id", userID))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudieron consultar las sesiones"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sesiones": vistasSesiones(sesiones, c.GetString("sid"))})
+}
+
+// CerrarSesion godoc
+// @Summary      Cerrar una sesión
+// @Description  Cierra una sesión del usuario autenticado, por ejemplo la de una computadora compartida: revoca sus refresh tokens y rechaza sus access tokens desde ese momento
+// @Tags         auth
+// @Produce      json
+// @Param        id   path      string  true  "ID de la sesión"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      501  {object}  map[string]interface{}
+// @Router       /api/v1/me/sessions/{id} [delete]
+func (h *AuthHandler) CerrarSesion(c *gin.Context) {
+	if h.sesiones == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Registro de sesiones no disponible en esta instancia"})
+		return
+	}
+	userID := c.GetString("user_id")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sesion, err := h.sesiones.Cerrar(ctx, userID, c.Param("id"))
+	if errors.Is(err, services.ErrSesionInexistente) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sesión no encontrada"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al cerrar la sesión", zap.Error(err), zap.String("user_id", userID))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudo cerrar la sesión"})
+		return
+	}
+
+	if err := h.escribirAuditoria(ctx, entradaCierreSesion(c, userID, sesion)); err != nil {
+		h.logger.Error("Error al auditar cierre de sesión", zap.Error(err))
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sesión cerrada", "actual": sesion.ID == c.GetString("sid")})
+}
+
+// GetSesionesUsuario godoc
+// @Summary      Sesiones abiertas de un usuario
+// @Description  Lista los dispositivos en los que el usuario tiene la sesión abierta, con la IP, el inicio y la última actividad de cada uno
+// @Tags         usuarios
+// @Produce      json
+// @Param        id   path      string  true  "ID del usuario"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      501  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/sessions [get]
+func (h *UsuarioHandler) GetSesionesUsuario(c *gin.Context) {
+	if !h.sesionesDisponibles(c) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u, ok := h.usuarioDelAlcance(c, ctx)
+	if !ok {
+		return
+	}
+	sesiones, err := h.sesiones.Listar(ctx, u.id.String())
+	if err != nil {
+		h.logger.Error("Error al listar sesiones", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudieron consultar las sesiones"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"usuario_id": u.id, "sesiones": vistasSesiones(sesiones, c.GetString("sid"))})
+}
+
+// CerrarSesionUsuario godoc
+// @Summary      Cerrar una sesión de un usuario
+// @Description  Cierra una sesión del usuario: revoca sus refresh tokens y rechaza sus access tokens desde ese momento. El cierre queda auditado.
+// @Tags         usuarios
+// @Produce      json
+// @Param        id      path      string  true  "ID del usuario"
+// @Param        sesion  path      string  true  "ID de la sesión"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      501  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/sessions/{sesion} [delete]
+func (h *UsuarioHandler) CerrarSesionUsuario(c *gin.Context) {
+	if !h.sesionesDisponibles(c) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u, ok := h.usuarioDelAlcance(c, ctx)
+	if !ok || !h.gestionable(c, ctx, u) {
+		return
+	}
+	sesion, err := h.sesiones.Cerrar(ctx, u.id.String(), c.Param("sesion"))
+	if errors.Is(err, services.ErrSesionInexistente) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sesión no encontrada"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al cerrar la sesión", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudo cerrar la sesión"})
+		return
+	}
+
+	entry := entradaCierreSesion(c, u.id.String(), sesion)
+	entry.ConsultorioID = u.consultorioID
+	if err := audit.ExecTx(ctx, h.pool, entry, ""); err != nil {
+		h.logger.Error("Error al auditar cierre de sesión", zap.Error(err), zap.String("usuario_id", u.id.String()))
+	}
+	h.logger.Info("Sesión cerrada por un administrador", zap.String("usuario_id", u.id.String()), zap.String("sesion", sesion.ID))
+	c.JSON(http.StatusOK, gin.H{"message": "Sesión cerrada"})
+}
+
+// sesionesDisponibles responde 501 si la instancia no lleva el registro de sesiones
+func (h *UsuarioHandler) sesionesDisponibles(c *gin.Context) bool {
+	if h.sesiones == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Registro de sesiones no disponible en esta instancia"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+var consultorioParam = regexp.MustCompile(`consultorio_id = \$(\d+)`)
+
+// fakePacientes es una tabla de pacientes en memoria que aplica el filtro por
+// consultorio de las consultas. Una consulta sin filtro hace fallar el test.
+type fakePacientes struct {
+	t         *testing.T
+	pacientes map[uuid.UUID]uuid.UUID // paciente -> consultorio
+}
+
+func (f *fakePacientes) consultorio(sql string, args []interface{}) uuid.UUID {
+	f.t.Helper()
+	m := consultorioParam.FindStringSubmatch(sql)
+	if m == nil {
+		f.t.Fatalf("Consulta sin filtro por consultorio: %s", sql)
+	}
+	n, _ := strconv.Atoi(m[1])
+	return args[n-1].(uuid.UUID)
+}
+
+func (f *fakePacientes) visible(id interface{}, consultorio uuid.UUID) bool {
+	pid, err := uuid.Parse(fmt.Sprint(id))
+	return err == nil && f.pacientes[pid] == consultorio
+}
+
+func (f *fakePacientes) pool() *mockPoolP {
+	return &mockPoolP{
+		queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+			consultorio := f.consultorio(sql, args)
+			var ids []uuid.UUID
+			for id, c := range f.pacientes {
+				if c == consultorio {
+					ids = append(ids, id)
+				}
+			}
+			rows := &mockRowsP{rowsCount: len(ids)}
+			rows.scanFunc = func(dest ...interface{}) error {
+				id := ids[rows.idx-1]
+				copy(dest[0].(*[16]byte)[:], id[:])
+				*dest[9].(**uuid.UUID) = &consultorio
+				return nil
+			}
+			return rows, nil
+		},
+		queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				switch {
+				case strings.Contains(sql, "row_to_json"):
+					// La foto previa a UPDATE/DELETE se toma (y bloquea) solo dentro del
+					// consultorio; la posterior al UPDATE ya no necesita el filtro
+					if strings.Contains(sql, "FOR UPDATE") {
+						if !f.visible(args[0], f.consultorio(sql, args)) {
+							return pgx.ErrNoRows
+						}
+					} else if _, ok := f.pacientes[uuid.MustParse(fmt.Sprint(args[0]))]; !ok {
+						return pgx.ErrNoRows
+					}
+					*(dest[0].(*[]byte)) = []byte(`{}`)
+					return nil
+				case strings.Contains(sql, "FROM pacientes"):
+					if !f.visible(args[0], f.consultorio(sql, args)) {
+						return pgx.ErrNoRows
+					}
+					*(dest[3].(*time.Time)) = time.Now()
+					return nil
+				}
+				return pgx.ErrNoRows
+			}}
+		},
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "UPDATE pacientes") || strings.Contains(sql, "DELETE FROM pacientes") {
+				id := args[0]
+				if strings.Contains(sql, "UPDATE") {
+					id = args[8]
+				}
+				if !f.visible(id, f.consultorio(sql, args)) {
+					return pgconn.NewCommandTag("UPDATE 0"), nil
+				}
+				return pgconn.NewCommandTag("UPDATE 1"), nil
+			}
+			return pgconn.NewCommandTag("INSERT 0 1"), nil
+		},
+	}
+}
+
+func dosConsultorios(t *testing.T) (f *fakePacientes, a, b, pacienteA, pacienteB uuid.UUID) {
+	a, b = uuid.New(), uuid.New()
+	pacienteA, pacienteB = uuid.New(), uuid.New()
+	f = &fakePacientes{t: t, pacientes: map[uuid.UUID]uuid.UUID{pacienteA: a, pacienteB: b}}
+	return f, a, b, pacienteA, pacienteB
+}
+
+func ctxConsultorio(method, path string, body []byte, consultorio uuid.UUID) (*gin.Context, *httptest.ResponseRecorder) {
+	c, w := makeCtx(method, path, body)
+	tenant.Set(c, tenant.Scope{ConsultorioID: consultorio})
+	return c, w
+}
+
+func TestTenant_GetPacientesSoloDelConsultorio(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f, a, b, pacienteA, pacienteB := dosConsultorios(t)
+	h := NewPacienteHandler(f.pool(), zap.NewNop())
+
+	for consultorio, esperado := range map[uuid.UUID]uuid.UUID{a: pacienteA, b: pacienteB} {
+		c, w := ctxConsultorio("GET", "/api/v1/pacientes", nil, consultorio)
+		h.GetPacientes(c)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Se esperaba status 200, obtuvo %d", w.Code)
+		}
+		var resp struct {
+			Pacientes []Paciente `json:"pacientes"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Pacientes) != 1 || resp.Pacientes[0].ID != esperado.String() {
+			t.Fatalf("El consultorio %s vio pacientes ajenos: %+v", consultorio, resp.Pacientes)
+		}
+	}
+}
+
+func TestTenant_GetPacienteDeOtroConsultorio(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f, a, _, pacienteA, pacienteB := dosConsultorios(t)
+	h := NewPacienteHandler(f.pool(), zap.NewNop())
+
+	c, w := ctxConsultorio("GET", "/api/v1/pacientes/"+pacienteB.String(), nil, a)
+	c.Params = gin.Params{{Key: "id", Value: pacienteB.String()}}
+	h.GetPaciente(c)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Se esperaba status 404 para un paciente de otro consultorio, obtuvo %d", w.Code)
+	}
+
+	c, w = ctxConsultorio("GET", "/api/v1/pacientes/"+pacienteA.String(), nil, a)
+	c.Params = gin.Params{{Key: "id", Value: pacienteA.String()}}
+	h.GetPaciente(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200 para un paciente propio, obtuvo %d", w.Code)
+	}
+}
+
+func TestTenant_UpdateYDeleteDeOtroConsultorio(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	f, a, _, _, pacienteB := dosConsultorios(t)
+	h := NewPacienteHandler(f.pool(), zap.NewNop())
+
+	body := []byte(`{"nombre":"X","apellido":"Y","fecha_nacimiento":"2000-01-01"}`)
+	c, w := ctxConsultorio("PUT", "/api/v1/pacientes/"+pacienteB.String(), body, a)
+	c.Params = gin.Params{{Key: "id", Value: pacienteB.String()}}
+	h.UpdatePaciente(c)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Se esperaba status 404 al actualizar un paciente ajeno, obtuvo %d", w.Code)
+	}
+
+	c, w = ctxConsultorio("DELETE", "/api/v1/pacientes/"+pacienteB.String(), nil, a)
+	c.Params = gin.Params{{Key: "id", Value: pacienteB.String()}}
+	h.DeletePaciente(c)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Se esperaba status 404 al eliminar un paciente ajeno, obtuvo %d", w.Code)
+	}
+}
+
+func TestTenant_CreatePacienteUsaConsultorioDelToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a, b := uuid.New(), uuid.New()
+	var insertado interface{}
+	pool := &mockPoolP{
+		execFunc: func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+			if strings.Contains(sql, "INSERT INTO pacientes") {
+				insertado = args[9]
+			}
+			return pgconn.NewCommandTag("INSERT 0 1"), nil
+		},
+		queryRowFunc: auditQueryRow(`{}`),
+	}
+	h := NewPacienteHandler(pool, zap.NewNop())
+
+	body := []byte(`{"nombre":"X","apellido":"Y","fecha_nacimiento":"2000-01-01","consultorio_id":"` + b.String() + `"}`)
+	c, w := ctxConsultorio("POST", "/api/v1/pacientes", body, a)
+	h.CreatePaciente(c)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Se esperaba status 201, obtuvo %d: %s", w.Code, w.Body.String())
+	}
+	if p, ok := insertado.(*string); !ok || *p != a.String() {
+		t.Fatalf("Se esperaba crear el paciente en el consultorio del token %s, obtuvo %v", a, insertado)
+	}
+}
+
+func TestTenant_SinAlcanceNoConsulta(t *testing.T) {
+	pool := &mockPoolP{queryFunc: func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+		t.Fatal("No se esperaba consultar sin alcance de consultorio")
+		return nil, nil
+	}}
+	h := NewPacienteHandler(pool, zap.NewNop())
+
+	c, w := makeCtx("GET", "/api/v1/pacientes", nil)
+	c.Keys = nil
+	h.GetPacientes(c)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Se esperaba status 403, obtuvo %d", w.Code)
+	}
+}
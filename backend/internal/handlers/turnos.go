@@ -0,0 +1,469 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+const (
+	// duracionTurnoDefault se usa cuando el alta no indica duración
+	duracionTurnoDefault = 30
+
+	// Códigos SQLSTATE de PostgreSQL que el handler traduce a errores de negocio
+	pgExclusionViolation  = "23P01"
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
+)
+
+// TxPool extiende PoolTX con soporte de transacciones
+type TxPool interface {
+	PoolTX
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// TurnoHandler maneja la agenda de turnos de los profesionales
+type TurnoHandler struct {
+	pool   TxPool
+	logger *zap.Logger
+}
+
+// NewTurnoHandler crea una nueva instancia del handler de turnos
+func NewTurnoHandler(pool TxPool, logger *zap.Logger) *TurnoHandler {
+	return &TurnoHandler{
+		pool:   pool,
+		logger: logger,
+	}
+}
+
+// TurnoInput son los datos para reservar un turno
+type TurnoInput struct {
+	PacienteID      string    `json:"paciente_id" binding:"required,uuid"`
+	UsuarioID       string    `json:"usuario_id" binding:"required,uuid"`
+	Fecha           time.Time `json:"fecha" binding:"required"`
+	DuracionMinutos int       `json:"duracion_minutos" binding:"omitempty,min=5,max=480"`
+	Motivo          *string   `json:"motivo"`
+}
+
+// ReprogramarTurnoInput son los datos para mover un turno a otro horario
+type ReprogramarTurnoInput struct {
+	Fecha           time.Time `json:"fecha" binding:"required"`
+	DuracionMinutos int       `json:"duracion_minutos" binding:"omitempty,min=5,max=480"`
+}
+
+// errTurnoSolapado indica que el profesional ya tiene un turno en ese horario
+var errTurnoSolapado = errors.New("turno solapado")
+
+const turnoColumns = `id, paciente_id, usuario_id, fecha, duracion_minutos, motivo, estado, motivo_cancelacion, cancelado_en, creado_en`
+
+func scanTurno(row pgx.Row) (models.Turno, error) {
+	var t models.Turno
+	err := row.Scan(&t.ID, &t.PacienteID, &t.UsuarioID, &t.Fecha, &t.DuracionMinutos, &t.Motivo,
+		&t.Estado, &t.MotivoCancelacion, &t.CanceladoEn, &t.CreadoEn)
+	return t, err
+}
+
+// reservarFranja bloquea la agenda del profesional hasta el fin de la transacción y
+// devuelve el turno que se superpone con [inicio, inicio+duracion), si existe.
+// excluirID permite ignorar el propio turno al reprogramar.
+func reservarFranja(ctx context.Context, tx pgx.Tx, usuarioID uuid.UUID, inicio time.Time, duracion int, excluirID *uuid.UUID) (*models.Turno, error) {
+	// El advisory lock serializa las reservas del mismo profesional, de modo que
+	// dos recepcionistas no puedan pasar la verificación a la vez.
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, "turnos:"+usuarioID.String()); err != nil {
+		return nil, err
+	}
+
+	fin := inicio.Add(time.Duration(duracion) * time.Minute)
+	conflicto, err := scanTurno(tx.QueryRow(ctx, `
+		SELECT `+turnoColumns+`
+		FROM turnos
+		WHERE usuario_id = $1
+		  AND estado <> 'cancelado'
+		  AND fecha < $3
+		  AND fecha + make_interval(mins => duracion_minutos) > $2
+		  AND ($4::uuid IS NULL OR id <> $4)
+		ORDER BY fecha
+		LIMIT 1
+	`, usuarioID, inicio, fin, excluirID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conflicto, nil
+}
+
+// respondTurnoError traduce errores de PostgreSQL a respuestas HTTP
+func (h *TurnoHandler) respondTurnoError(c *gin.Context, err error, msg string) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case pgExclusionViolation:
+			c.JSON(http.StatusConflict, gin.H{"error": "El profesional ya tiene un turno en ese horario"})
+			return
+		case pgForeignKeyViolation:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Paciente o profesional inexistente"})
+			return
+		}
+	}
+	h.logger.Error(msg, zap.Error(err))
+	c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+}
+
+// CreateTurno godoc
+// @Summary      Reservar turno
+// @Description  Reserva un turno para un paciente con un profesional, rechazando horarios superpuestos
+// @Tags         turnos
+// @Accept       json
+// @Produce      json
+// @Param        turno  body  TurnoInput  true  "Datos del turno"
+// @Success      201  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/turnos [post]
+func (h *TurnoHandler) CreateTurno(c *gin.Context) {
+	var input TurnoInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.DuracionMinutos == 0 {
+		input.DuracionMinutos = duracionTurnoDefault
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	usuarioID := uuid.MustParse(input.UsuarioID)
+	pacienteID := uuid.MustParse(input.PacienteID)
+	inicio := input.Fecha.UTC()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.respondTurnoError(c, err, "No se pudo reservar el turno")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Paciente y profesional tienen que ser del consultorio del request
+	var pacienteOK, profesionalOK bool
+	args := []interface{}{pacienteID, usuarioID}
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pacientes WHERE id = $1 AND `+scope.Consultorio("consultorio_id", &args)+`),
+		       EXISTS (SELECT 1 FROM usuarios WHERE id = $2 AND `+scope.Consultorio("consultorio_id", &args)+`)
+	`, args...).Scan(&pacienteOK, &profesionalOK)
+	if err != nil {
+		h.respondTurnoError(c, err, "No se pudo reservar el turno")
+		return
+	}
+	if !pacienteOK || !profesionalOK {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Paciente o profesional inexistente"})
+		return
+	}
+
+	conflicto, err := reservarFranja(ctx, tx, usuarioID, inicio, input.DuracionMinutos, nil)
+	if err != nil {
+		h.respondTurnoError(c, err, "No se pudo reservar el turno")
+		return
+	}
+	if conflicto != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "El profesional ya tiene un turno en ese horario",
+			"conflicto": conflicto,
+		})
+		return
+	}
+
+	turno, err := scanTurno(tx.QueryRow(ctx, `
+		INSERT INTO turnos (id, paciente_id, usuario_id, fecha, duracion_minutos, motivo, estado, creado_en)
+		VALUES ($1, $2, $3, $4, $5, $6, 'programado', $7)
+		RETURNING `+turnoColumns,
+		uuid.New(), pacienteID, usuarioID, inicio, input.DuracionMinutos, input.Motivo, time.Now().UTC(),
+	))
+	if err != nil {
+		h.respondTurnoError(c, err, "No se pudo reservar el turno")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		h.respondTurnoError(c, err, "No se pudo reservar el turno")
+		return
+	}
+
+	h.logger.Info("Turno reservado",
+		zap.String("turno_id", turno.ID.String()),
+		zap.String("usuario_id", usuarioID.String()),
+		zap.Time("fecha", inicio))
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Turno reservado exitosamente",
+		"turno":   turno,
+	})
+}
+
+// RescheduleTurno godoc
+// @Summary      Reprogramar turno
+// @Description  Cambia el horario (y opcionalmente la duración) de un turno programado
+// @Tags         turnos
+// @Accept       json
+// @Produce      json
+// @Param        id     path  string                 true  "ID del turno"
+// @Param        turno  body  ReprogramarTurnoInput  true  "Nuevo horario"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/turnos/{id} [put]
+func (h *TurnoHandler) RescheduleTurno(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de turno inválido"})
+		return
+	}
+	var input ReprogramarTurnoInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	inicio := input.Fecha.UTC()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.respondTurnoError(c, err, "No se pudo reprogramar el turno")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	args := []interface{}{id}
+	actual, err := scanTurno(tx.QueryRow(ctx, `
+		SELECT `+turnoColumns+` FROM turnos WHERE id = $1 AND `+scope.Paciente("paciente_id", &args)+` FOR UPDATE
+	`, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Turno no encontrado"})
+		return
+	}
+	if err != nil {
+		h.respondTurnoError(c, err, "No se pudo reprogramar el turno")
+		return
+	}
+	if actual.Estado == models.TurnoCancelado {
+		c.JSON(http.StatusConflict, gin.H{"error": "No se puede reprogramar un turno cancelado"})
+		return
+	}
+	if input.DuracionMinutos == 0 {
+		input.DuracionMinutos = actual.DuracionMinutos
+	}
+
+	conflicto, err := reservarFranja(ctx, tx, actual.UsuarioID, inicio, input.DuracionMinutos, &id)
+	if err != nil {
+		h.respondTurnoError(c, err, "No se pudo reprogramar el turno")
+		return
+	}
+	if conflicto != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "El profesional ya tiene un turno en ese horario",
+			"conflicto": conflicto,
+		})
+		return
+	}
+
+	turno, err := scanTurno(tx.QueryRow(ctx, `
+		UPDATE turnos SET fecha = $1, duracion_minutos = $2
+		WHERE id = $3
+		RETURNING `+turnoColumns,
+		inicio, input.DuracionMinutos, id,
+	))
+	if err != nil {
+		h.respondTurnoError(c, err, "No se pudo reprogramar el turno")
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		h.respondTurnoError(c, err, "No se pudo reprogramar el turno")
+		return
+	}
+
+	h.logger.Info("Turno reprogramado", zap.String("turno_id", id.String()), zap.Time("fecha", inicio))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Turno reprogramado exitosamente",
+		"turno":   turno,
+	})
+}
+
+// CancelTurno godoc
+// @Summary      Cancelar turno
+// @Description  Cancela un turno programado, liberando el horario del profesional
+// @Tags         turnos
+// @Accept       json
+// @Produce      json
+// @Param        id      path  string  true   "ID del turno"
+// @Param        motivo  body  object  false  "Motivo de la cancelación"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/turnos/{id}/cancelar [post]
+func (h *TurnoHandler) CancelTurno(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de turno inválido"})
+		return
+	}
+	var input struct {
+		Motivo *string `json:"motivo"`
+	}
+	// El cuerpo es opcional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []interface{}{input.Motivo, time.Now().UTC(), id}
+	turno, err := scanTurno(h.pool.QueryRow(ctx, `
+		UPDATE turnos SET estado = 'cancelado', motivo_cancelacion = $1, cancelado_en = $2
+		WHERE id = $3 AND estado <> 'cancelado' AND `+scope.Paciente("paciente_id", &args)+`
+		RETURNING `+turnoColumns,
+		args...,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Turno no encontrado o ya cancelado"})
+		return
+	}
+	if err != nil {
+		h.respondTurnoError(c, err, "No se pudo cancelar el turno")
+		return
+	}
+
+	h.logger.Info("Turno cancelado", zap.String("turno_id", id.String()))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Turno cancelado exitosamente",
+		"turno":   turno,
+	})
+}
+
+// GetTurnosByProfesional godoc
+// @Summary      Agenda de un profesional
+// @Description  Lista los turnos de un profesional, opcionalmente entre dos fechas (RFC3339)
+// @Tags         turnos
+// @Produce      json
+// @Param        usuario_id          path   string  true   "ID del profesional"
+// @Param        desde               query  string  false  "Fecha inicial (RFC3339)"
+// @Param        hasta               query  string  false  "Fecha final (RFC3339)"
+// @Param        incluir_cancelados  query  bool    false  "Incluir turnos cancelados"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/turnos/profesional/{usuario_id} [get]
+func (h *TurnoHandler) GetTurnosByProfesional(c *gin.Context) {
+	h.listTurnos(c, "usuario_id", c.Param("usuario_id"))
+}
+
+// GetTurnosByPaciente godoc
+// @Summary      Turnos de un paciente
+// @Description  Lista los turnos de un paciente, opcionalmente entre dos fechas (RFC3339)
+// @Tags         turnos
+// @Produce      json
+// @Param        paciente_id         path   string  true   "ID del paciente"
+// @Param        desde               query  string  false  "Fecha inicial (RFC3339)"
+// @Param        hasta               query  string  false  "Fecha final (RFC3339)"
+// @Param        incluir_cancelados  query  bool    false  "Incluir turnos cancelados"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      500  {object}  map[string]interface{}
+// @Router       /api/v1/turnos/paciente/{paciente_id} [get]
+func (h *TurnoHandler) GetTurnosByPaciente(c *gin.Context) {
+	h.listTurnos(c, "paciente_id", c.Param("paciente_id"))
+}
+
+// listTurnos lista turnos filtrando por la columna indicada (usuario_id o paciente_id)
+func (h *TurnoHandler) listTurnos(c *gin.Context, column, value string) {
+	id, err := uuid.Parse(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	var desde, hasta *time.Time
+	for param, dest := range map[string]**time.Time{"desde": &desde, "hasta": &hasta} {
+		if raw := c.Query(param); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Parámetro '" + param + "' inválido, se espera RFC3339"})
+				return
+			}
+			t = t.UTC()
+			*dest = &t
+		}
+	}
+	incluirCancelados := c.Query("incluir_cancelados") == "true"
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []interface{}{id, desde, hasta, incluirCancelados}
+	rows, err := h.pool.Query(ctx, `
+		SELECT `+turnoColumns+`
+		FROM turnos
+		WHERE `+column+` = $1
+		  AND ($2::timestamp IS NULL OR fecha >= $2)
+		  AND ($3::timestamp IS NULL OR fecha < $3)
+		  AND ($4 OR estado <> 'cancelado')
+		  AND `+scope.Paciente("paciente_id", &args)+`
+		ORDER BY fecha
+	`, args...)
+	if err != nil {
+		h.logger.Error("Error al consultar turnos", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	turnos := make([]models.Turno, 0)
+	for rows.Next() {
+		t, err := scanTurno(rows)
+		if err != nil {
+			h.logger.Error("Error al escanear turno", zap.Error(err))
+			continue
+		}
+		turnos = append(turnos, t)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"turnos": turnos,
+		"total":  len(turnos),
+	})
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// mockTx implementa pgx.Tx para los tests; los métodos no configurados
+// provienen de la interfaz embebida y no deben invocarse.
+type mockTx struct {
+	pgx.Tx
+	execFunc     func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	queryFunc    func(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	queryRowFunc func(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	execSQL      []string
+	committed    bool
+	rolledBack   bool
+}
+
+func (t *mockTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	t.execSQL = append(t.execSQL, sql)
+	if t.execFunc != nil {
+		return t.execFunc(ctx, sql, args...)
+	}
+	return pgconn.NewCommandTag("OK"), nil
+}
+func (t *mockTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if t.queryFunc != nil {
+		return t.queryFunc(ctx, sql, args...)
+	}
+	return &mockRowsP{}, nil
+}
+func (t *mockTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	if t.queryRowFunc != nil {
+		return t.queryRowFunc(ctx, sql, args...)
+	}
+	return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+}
+func (t *mockTx) Commit(ctx context.Context) error {
+	t.committed = true
+	return nil
+}
+func (t *mockTx) Rollback(ctx context.Context) error {
+	if !t.committed {
+		t.rolledBack = true
+	}
+	return nil
+}
+
+// mockTxPool agrega Begin a mockPoolP
+type mockTxPool struct {
+	mockPoolP
+	tx *mockTx
+}
+
+func (m *mockTxPool) Begin(ctx context.Context) (pgx.Tx, error) {
+	if m.tx == nil {
+		m.tx = &mockTx{}
+	}
+	return m.tx, nil
+}
+
+// turnoRow simula una fila de turnos con las columnas de turnoColumns
+func turnoRow(id, usuarioID uuid.UUID, fecha time.Time, duracion int, estado string) mockRowP {
+	return mockRowP{scanFunc: func(dest ...interface{}) error {
+		setDest(dest, 0, id)
+		setDest(dest, 1, uuid.New())
+		setDest(dest, 2, usuarioID)
+		setDest(dest, 3, fecha)
+		setDest(dest, 4, duracion)
+		setDest(dest, 6, estado)
+		setDest(dest, 9, time.Now())
+		return nil
+	}}
+}
+
+func turnoBody(usuarioID uuid.UUID, fecha time.Time) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"paciente_id":      uuid.New().String(),
+		"usuario_id":       usuarioID.String(),
+		"fecha":            fecha.Format(time.RFC3339),
+		"duracion_minutos": 30,
+	})
+	return body
+}
+
+// enConsultorio responde la verificación de que paciente y profesional son del
+// consultorio del request y delega el resto de las consultas en next
+func enConsultorio(pacienteOK, profesionalOK bool, next func(ctx context.Context, sql string, args ...interface{}) pgx.Row) func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if strings.Contains(sql, "SELECT EXISTS") {
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				*(dest[0].(*bool)) = pacienteOK
+				*(dest[1].(*bool)) = profesionalOK
+				return nil
+			}}
+		}
+		return next(ctx, sql, args...)
+	}
+}
+
+func TestCreateTurno_Success(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	usuarioID := uuid.New()
+	fecha := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+
+	tx := &mockTx{queryRowFunc: enConsultorio(true, true, func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if strings.Contains(sql, "INSERT INTO turnos") {
+			return turnoRow(args[0].(uuid.UUID), usuarioID, fecha, 30, "programado")
+		}
+		// Sin turnos superpuestos
+		return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+	})}
+	pool := &mockTxPool{tx: tx}
+	h := NewTurnoHandler(pool, zap.NewNop())
+
+	c, w := makeCtx("POST", "/api/v1/turnos", turnoBody(usuarioID, fecha))
+	h.CreateTurno(c)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Se esperaba status 201, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+	if !tx.committed {
+		t.Error("Se esperaba que la transacción se confirmara")
+	}
+	if len(tx.execSQL) == 0 || !strings.Contains(tx.execSQL[0], "pg_advisory_xact_lock") {
+		t.Errorf("Se esperaba bloquear la agenda del profesional antes de verificar, exec=%v", tx.execSQL)
+	}
+}
+
+func TestCreateTurno_Overlap(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	usuarioID := uuid.New()
+	fecha := time.Date(2026, 3, 10, 10, 0, 0, 0, time.UTC)
+
+	tx := &mockTx{queryRowFunc: enConsultorio(true, true, func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if strings.Contains(sql, "INSERT INTO turnos") {
+			t.Fatal("No se esperaba insertar un turno superpuesto")
+		}
+		// Turno existente de 10:15 a 10:45
+		return turnoRow(uuid.New(), usuarioID, fecha.Add(15*time.Minute), 30, "programado")
+	})}
+	pool := &mockTxPool{tx: tx}
+	h := NewTurnoHandler(pool, zap.NewNop())
+
+	c, w := makeCtx("POST", "/api/v1/turnos", turnoBody(usuarioID, fecha))
+	h.CreateTurno(c)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Se esperaba status 409, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+	if tx.committed || !tx.rolledBack {
+		t.Error("Se esperaba revertir la transacción")
+	}
+}
+
+func TestCreateTurno_ExclusionConstraint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	usuarioID := uuid.New()
+
+	tx := &mockTx{queryRowFunc: enConsultorio(true, true, func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if strings.Contains(sql, "INSERT INTO turnos") {
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				return &pgconn.PgError{Code: pgExclusionViolation}
+			}}
+		}
+		return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+	})}
+	h := NewTurnoHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	c, w := makeCtx("POST", "/api/v1/turnos", turnoBody(usuarioID, time.Now()))
+	h.CreateTurno(c)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Se esperaba status 409, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateTurno_PacienteDeOtroConsultorio(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tx := &mockTx{queryRowFunc: enConsultorio(false, true, func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		t.Fatalf("No se esperaba consultar la agenda: %s", sql)
+		return nil
+	})}
+	h := NewTurnoHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	c, w := makeCtx("POST", "/api/v1/turnos", turnoBody(uuid.New(), time.Now()))
+	h.CreateTurno(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Se esperaba status 400, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+	if len(tx.execSQL) != 0 {
+		t.Errorf("No se esperaba bloquear la agenda, exec=%v", tx.execSQL)
+	}
+}
+
+func TestCreateTurno_BindError(t *testing.T) {
+	h := NewTurnoHandler(&mockTxPool{}, zap.NewNop())
+	c, w := makeCtx("POST", "/api/v1/turnos", []byte(`{"paciente_id":"x"}`))
+	h.CreateTurno(c)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Se esperaba status 400, obtuvo %d", w.Code)
+	}
+}
+
+func TestRescheduleTurno_Cancelled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	id := uuid.New()
+	tx := &mockTx{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		return turnoRow(id, uuid.New(), time.Now(), 30, "cancelado")
+	}}
+	h := NewTurnoHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	body, _ := json.Marshal(map[string]string{"fecha": time.Now().Add(time.Hour).Format(time.RFC3339)})
+	c, w := makeCtx("PUT", "/api/v1/turnos/"+id.String(), body)
+	c.Params = gin.Params{{Key: "id", Value: id.String()}}
+	h.RescheduleTurno(c)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Se esperaba status 409, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestRescheduleTurno_ExcludesItself(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	id := uuid.New()
+	usuarioID := uuid.New()
+	var excluido interface{}
+	tx := &mockTx{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		switch {
+		case strings.Contains(sql, "FOR UPDATE"):
+			return turnoRow(id, usuarioID, time.Now(), 30, "programado")
+		case strings.Contains(sql, "UPDATE turnos"):
+			return turnoRow(id, usuarioID, args[0].(time.Time), 30, "programado")
+		default:
+			excluido = args[3]
+			return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+		}
+	}}
+	h := NewTurnoHandler(&mockTxPool{tx: tx}, zap.NewNop())
+
+	body, _ := json.Marshal(map[string]string{"fecha": time.Now().Add(time.Hour).Format(time.RFC3339)})
+	c, w := makeCtx("PUT", "/api/v1/turnos/"+id.String(), body)
+	c.Params = gin.Params{{Key: "id", Value: id.String()}}
+	h.RescheduleTurno(c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+	if p, ok := excluido.(*uuid.UUID); !ok || *p != id {
+		t.Errorf("Se esperaba excluir el propio turno de la verificación, obtuvo %v", excluido)
+	}
+}
+
+func TestCancelTurno_NotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	id := uuid.New()
+	h := NewTurnoHandler(&mockTxPool{}, zap.NewNop())
+
+	c, w := makeCtx("POST", "/api/v1/turnos/"+id.String()+"/cancelar", nil)
+	c.Params = gin.Params{{Key: "id", Value: id.String()}}
+	h.CancelTurno(c)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Se esperaba status 404, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestGetTurnosByProfesional_InvalidRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewTurnoHandler(&mockTxPool{}, zap.NewNop())
+	id := uuid.New().String()
+
+	c, w := makeCtx("GET", "/api/v1/turnos/profesional/"+id+"?desde=ayer", nil)
+	c.Params = gin.Params{{Key: "usuario_id", Value: id}}
+	h.GetTurnosByProfesional(c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Se esperaba status 400, obtuvo %d body=%s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,831 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/pagination"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// BloqueosLogin consulta y levanta los bloqueos temporales de login de una cuenta
+// (services.RedisService)
+type BloqueosLogin interface {
+	IsAccountBlocked(ctx context.Context, username string) (bool, time.Duration, error)
+	GetAccountAttempts(ctx context.Context, username string) (int64, error)
+	UnblockAccount(ctx context.Context, username string) error
+}
+
+// CorreosUsuario envía las invitaciones y los restablecimientos de contraseña forzados
+// (services.CuentaService)
+type CorreosUsuario interface {
+	Invitar(ctx context.Context, usuarioID uuid.UUID) error
+	EnviarRestablecimiento(ctx context.Context, usuarioID uuid.UUID) error
+}
+
+// UsuarioHandler administra los usuarios del consultorio
+type UsuarioHandler struct {
+	pool      TxPool
+	bloqueos  BloqueosLogin
+	correos   CorreosUsuario
+	revocador TokenRevoker
+	sesiones  Sesiones
+	logger    *zap.Logger
+}
+
+// NewUsuarioHandler crea el handler de administración de usuarios. Sin correos las
+// invitaciones y los restablecimientos forzados responden 501, y sin sesiones lo hacen los
+// endpoints de sesiones; revocador puede ser nil (el trigger de usuarios igual revoca los
+// tokens).
+func NewUsuarioHandler(pool TxPool, bloqueos BloqueosLogin, correos CorreosUsuario, revocador TokenRevoker, sesiones Sesiones, logger *zap.Logger) *UsuarioHandler {
+	return &UsuarioHandler{pool: pool, bloqueos: bloqueos, correos: correos, revocador: revocador, sesiones: sesiones, logger: logger}
+}
+
+// UsuarioAdmin es un usuario tal como lo ve la administración; nunca incluye el hash de
+// la contraseña
+type UsuarioAdmin struct {
+	ID            uuid.UUID  `json:"id"`
+	Nombre        string     `json:"nombre"`
+	Email         string     `json:"email"`
+	RolID         int        `json:"rol_id"`
+	Rol           string     `json:"rol"`
+	ConsultorioID *uuid.UUID `json:"consultorio_id"`
+	Activo        bool       `json:"activo"`
+	// Pendiente indica que el usuario todavía no eligió contraseña (invitación sin aceptar
+	// o restablecimiento forzado)
+	Pendiente       bool       `json:"pendiente"`
+	EmailVerificado bool       `json:"email_verificado"`
+	UltimoLogin     *time.Time `json:"ultimo_login"`
+	CreadoEn        time.Time  `json:"creado_en"`
+}
+
+// selectUsuarioAdmin son las columnas de UsuarioAdmin; se completa con el WHERE
+const selectUsuarioAdmin = `
+		SELECT u.id, u.nombre, u.email, u.rol_id, COALESCE(r.nombre_rol, ''), u.consultorio_id,
+			   u.activo, u.contrasena_hash = '', u.email_verificado_en IS NOT NULL, u.ultimo_login, u.creado_en
+		FROM usuarios u
+		LEFT JOIN roles r ON r.id = u.rol_id
+`
+
+func scanUsuarioAdmin(row pgx.Row) (UsuarioAdmin, error) {
+	var u UsuarioAdmin
+	err := row.Scan(&u.ID, &u.Nombre, &u.Email, &u.RolID, &u.Rol, &u.ConsultorioID,
+		&u.Activo, &u.Pendiente, &u.EmailVerificado, &u.UltimoLogin, &u.CreadoEn)
+	return u, err
+}
+
+// usuariosPaginacion define los campos de orden permitidos en GET /usuarios
+var usuariosPaginacion = pagination.Config{
+	Ordenes: map[string]pagination.Orden{
+		"nombre":    {Columna: "u.nombre", Tipo: pagination.TipoText},
+		"email":     {Columna: "u.email", Tipo: pagination.TipoText},
+		"creado_en": {Columna: "u.creado_en", Tipo: pagination.TipoTimestamp},
+	},
+	OrdenDefault: "nombre",
+	ColumnaID:    "u.id",
+}
+
+// usuarioObjetivo es el usuario de :id sobre el que opera un endpoint de administración
+type usuarioObjetivo struct {
+	id            uuid.UUID
+	nombre        string
+	consultorioID *uuid.UUID
+	rolID         int
+	activo        bool
+	pendiente     bool
+}
+
+// usuarioDelAlcance busca el usuario de :id dentro del consultorio del request. Responde
+// y devuelve false si no se puede operar.
+func (h *UsuarioHandler) usuarioDelAlcance(c *gin.Context, ctx context.Context) (usuarioObjetivo, bool) {
+	var u usuarioObjetivo
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return u, false
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario inválido"})
+		return u, false
+	}
+	u.id = id
+	args := []interface{}{id}
+	err = h.pool.QueryRow(ctx, `
+		SELECT nombre, consultorio_id, rol_id, activo, contrasena_hash = ''
+		FROM usuarios WHERE id = $1 AND `+scope.Consultorio("consultorio_id", &args),
+		args...).Scan(&u.nombre, &u.consultorioID, &u.rolID, &u.activo, &u.pendiente)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
+		return u, false
+	} else if err != nil {
+		h.logger.Error("Error al buscar usuario", zap.Error(err), zap.String("usuario_id", id.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return u, false
+	}
+	return u, true
+}
+
+// rolPermitido indica si rolID existe y si sus permisos están incluidos en los del rol
+// del usuario autenticado. Así nadie puede dar ni administrar más permisos que los propios.
+func (h *UsuarioHandler) rolPermitido(c *gin.Context, ctx context.Context, rolID int) (existe, permitido bool, err error) {
+	actor, ok := rolActual(c)
+	if !ok {
+		return false, false, errors.New("rol del usuario autenticado no disponible")
+	}
+	err = h.pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM roles WHERE id = $1),
+			NOT EXISTS (
+				SELECT 1 FROM rol_permiso
+				WHERE rol_id = $1 AND permiso_id NOT IN (SELECT permiso_id FROM rol_permiso WHERE rol_id = $2)
+			)
+	`, rolID, actor).Scan(&existe, &permitido)
+	return existe, permitido, err
+}
+
+// gestionable responde 403 si el usuario objetivo tiene permisos que el autenticado no tiene
+func (h *UsuarioHandler) gestionable(c *gin.Context, ctx context.Context, u usuarioObjetivo) bool {
+	_, permitido, err := h.rolPermitido(c, ctx, u.rolID)
+	if err != nil {
+		h.logger.Error("Error al verificar el rol del usuario", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return false
+	}
+	if !permitido {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No puede administrar un usuario con permisos que usted no tiene"})
+		return false
+	}
+	return true
+}
+
+// asignable responde si rolID no existe o tiene permisos que el autenticado no tiene
+func (h *UsuarioHandler) asignable(c *gin.Context, ctx context.Context, rolID int) bool {
+	existe, permitido, err := h.rolPermitido(c, ctx, rolID)
+	switch {
+	case err != nil:
+		h.logger.Error("Error al verificar el rol", zap.Error(err), zap.Int("rol_id", rolID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+	case !existe:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rol inexistente"})
+	case !permitido:
+		c.JSON(http.StatusForbidden, gin.H{"error": "No puede asignar un rol con permisos que usted no tiene"})
+	default:
+		return true
+	}
+	return false
+}
+
+// otroUsuario responde 409 si el objetivo es el propio usuario autenticado, para que nadie
+// se quite el acceso a sí mismo
+func otroUsuario(c *gin.Context, u usuarioObjetivo, msg string) bool {
+	if actual, ok := usuarioActual(c); ok && actual == u.id {
+		c.JSON(http.StatusConflict, gin.H{"error": msg})
+		return false
+	}
+	return true
+}
+
+// correosDisponibles responde 501 si la instancia no tiene correo configurado
+func (h *UsuarioHandler) correosDisponibles(c *gin.Context) bool {
+	if h.correos == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "El envío de correos no está configurado en esta instancia"})
+		return false
+	}
+	return true
+}
+
+// GetUsuarios godoc
+// @Summary      Listar usuarios
+// @Description  Lista paginada (por cursor) de los usuarios del consultorio (o de todos, con alcance global)
+// @Tags         usuarios
+// @Produce      json
+// @Param        limit           query  int     false  "Tamaño de página (1-200, por defecto 50)"
+// @Param        cursor          query  string  false  "next_cursor de la página anterior"
+// @Param        sort            query  string  false  "nombre, email o creado_en; con '-' descendente (por defecto nombre)"
+// @Param        q               query  string  false  "Texto contenido en el nombre o el email"
+// @Param        rol_id          query  int     false  "Rol"
+// @Param        activo          query  bool    false  "Activos (true) o desactivados (false)"
+// @Param        consultorio_id  query  string  false  "Consultorio (útil con alcance global)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios [get]
+func (h *UsuarioHandler) GetUsuarios(c *gin.Context) {
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	pag, err := pagination.Parse(c, usuariosPaginacion)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	conds := []string{scope.Consultorio("u.consultorio_id", &args)}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		p := arg(q)
+		conds = append(conds, "(strpos(lower(u.nombre), lower("+p+")) > 0 OR strpos(lower(u.email), lower("+p+")) > 0)")
+	}
+	if raw := c.Query("rol_id"); raw != "" {
+		rolID, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parámetro 'rol_id' inválido"})
+			return
+		}
+		conds = append(conds, "u.rol_id = "+arg(rolID))
+	}
+	if raw := c.Query("activo"); raw != "" {
+		activo, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parámetro 'activo' inválido, se espera true o false"})
+			return
+		}
+		conds = append(conds, "u.activo = "+arg(activo))
+	}
+	if raw := c.Query("consultorio_id"); raw != "" {
+		consultorioID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parámetro 'consultorio_id' inválido"})
+			return
+		}
+		conds = append(conds, "u.consultorio_id = "+arg(consultorioID))
+	}
+	conds = append(conds, pag.Condicion(&args))
+	args = append(args, pag.Fetch())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := h.pool.Query(ctx, selectUsuarioAdmin+`
+		WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY `+pag.OrderBy()+`
+		LIMIT $`+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		h.logger.Error("Error al consultar usuarios", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer rows.Close()
+
+	usuarios := make([]UsuarioAdmin, 0)
+	for rows.Next() {
+		u, err := scanUsuarioAdmin(rows)
+		if err != nil {
+			h.logger.Error("Error al escanear usuario", zap.Error(err))
+			continue
+		}
+		usuarios = append(usuarios, u)
+	}
+
+	usuarios, next := pagination.Pagina(pag, usuarios, func(u UsuarioAdmin) (interface{}, string) {
+		switch pag.Campo() {
+		case "email":
+			return u.Email, u.ID.String()
+		case "creado_en":
+			return u.CreadoEn, u.ID.String()
+		}
+		return u.Nombre, u.ID.String()
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"usuarios":    usuarios,
+		"total":       len(usuarios),
+		"limit":       pag.Limit,
+		"next_cursor": next,
+	})
+}
+
+// GetUsuario godoc
+// @Summary      Obtener usuario
+// @Description  Devuelve un usuario del consultorio
+// @Tags         usuarios
+// @Produce      json
+// @Param        id   path      string  true  "ID del usuario"
+// @Success      200  {object}  UsuarioAdmin
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id} [get]
+func (h *UsuarioHandler) GetUsuario(c *gin.Context) {
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario inválido"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := []interface{}{id}
+	u, err := scanUsuarioAdmin(h.pool.QueryRow(ctx, selectUsuarioAdmin+`
+		WHERE u.id = $1 AND `+scope.Consultorio("u.consultorio_id", &args), args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al buscar usuario", zap.Error(err), zap.String("usuario_id", id.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	c.JSON(http.StatusOK, u)
+}
+
+// InvitarUsuario godoc
+// @Summary      Invitar usuario
+// @Description  Da de alta un usuario sin contraseña y le envía por correo un enlace de un solo uso para elegirla. Solo se pueden asignar roles cuyos permisos tenga el usuario autenticado.
+// @Tags         usuarios
+// @Accept       json
+// @Produce      json
+// @Param        req  body  object  true  "nombre, email, rol_id y consultorio_id (opcional salvo con alcance global)"
+// @Success      201  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      501  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios [post]
+func (h *UsuarioHandler) InvitarUsuario(c *gin.Context) {
+	var req struct {
+		Nombre        string `json:"nombre" binding:"required,max=100"`
+		Email         string `json:"email" binding:"required,email,max=255"`
+		RolID         int    `json:"rol_id" binding:"required"`
+		ConsultorioID string `json:"consultorio_id" binding:"omitempty,uuid"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Nombre = strings.TrimSpace(req.Nombre)
+	if !h.correosDisponibles(c) {
+		return
+	}
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	consultorioID := scope.ConsultorioID
+	if req.ConsultorioID != "" {
+		consultorioID = uuid.MustParse(req.ConsultorioID)
+	} else if scope.Global {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "consultorio_id es obligatorio con alcance global"})
+		return
+	}
+	if !scope.Incluye(&consultorioID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No puede dar de alta usuarios en otro consultorio"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !h.asignable(c, ctx, req.RolID) {
+		return
+	}
+	// El login es por nombre: no puede repetirse, sin distinguir mayúsculas
+	var nombreUsado, emailUsado bool
+	err := h.pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM usuarios WHERE lower(nombre) = lower($1)),
+			EXISTS (SELECT 1 FROM usuarios WHERE lower(email) = lower($2))
+	`, req.Nombre, req.Email).Scan(&nombreUsado, &emailUsado)
+	if err != nil {
+		h.logger.Error("Error al verificar usuario duplicado", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	if nombreUsado || emailUsado {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ya existe un usuario con ese nombre o email"})
+		return
+	}
+
+	usuarioID := uuid.New()
+	creadoEn := time.Now()
+	entry := audit.FromRequest(c, audit.AccionInvitar, "usuarios", usuarioID.String())
+	entry.ConsultorioID = &consultorioID
+	entry.Despues = gin.H{
+		"id":             usuarioID,
+		"nombre":         req.Nombre,
+		"email":          req.Email,
+		"rol_id":         req.RolID,
+		"consultorio_id": consultorioID,
+		"activo":         true,
+		"creado_en":      creadoEn,
+	}
+	err = audit.ExecTx(ctx, h.pool, entry, `
+		INSERT INTO usuarios (id, nombre, email, contrasena_hash, rol_id, consultorio_id, activo, creado_en)
+		VALUES ($1, $2, $3, '', $4, $5, true, $6)
+	`, usuarioID, req.Nombre, req.Email, req.RolID, consultorioID, creadoEn)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ya existe un usuario con ese nombre o email"})
+		return
+	} else if errors.As(err, &pgErr) && pgErr.Code == pgForeignKeyViolation {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Consultorio inexistente"})
+		return
+	} else if err != nil {
+		h.logger.Error("Error al dar de alta usuario", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	// El alta no depende del correo: si falla, se puede reenviar la invitación
+	enviada := true
+	if err := h.correos.Invitar(ctx, usuarioID); err != nil {
+		enviada = false
+		h.logger.Error("Error al enviar invitación", zap.Error(err), zap.String("usuario_id", usuarioID.String()))
+	}
+	h.logger.Info("Usuario invitado", zap.String("usuario_id", usuarioID.String()), zap.Int("rol_id", req.RolID))
+	c.JSON(http.StatusCreated, gin.H{
+		"message":            "Usuario invitado",
+		"id":                 usuarioID,
+		"invitacion_enviada": enviada,
+	})
+}
+
+// ReenviarInvitacion godoc
+// @Summary      Reenviar invitación
+// @Description  Envía otro enlace de activación a un usuario que todavía no eligió contraseña
+// @Tags         usuarios
+// @Produce      json
+// @Param        id   path      string  true  "ID del usuario"
+// @Success      202  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      429  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/invitacion [post]
+func (h *UsuarioHandler) ReenviarInvitacion(c *gin.Context) {
+	if !h.correosDisponibles(c) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u, ok := h.usuarioDelAlcance(c, ctx)
+	if !ok || !h.gestionable(c, ctx, u) {
+		return
+	}
+	if !u.activo || !u.pendiente {
+		c.JSON(http.StatusConflict, gin.H{"error": "El usuario no tiene una invitación pendiente"})
+		return
+	}
+	if !h.enviarCorreo(c, u, h.correos.Invitar(ctx, u.id)) {
+		return
+	}
+	entry := audit.FromRequest(c, audit.AccionInvitar, "usuarios", u.id.String())
+	entry.ConsultorioID = u.consultorioID
+	entry.Despues = gin.H{"reenviada": true}
+	if err := audit.ExecTx(ctx, h.pool, entry, ""); err != nil {
+		h.logger.Error("Error al auditar invitación", zap.Error(err), zap.String("usuario_id", u.id.String()))
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Invitación reenviada"})
+}
+
+// CambiarRolUsuario godoc
+// @Summary      Cambiar rol
+// @Description  Asigna otro rol al usuario y cierra sus sesiones. Solo se pueden asignar roles cuyos permisos tenga el usuario autenticado.
+// @Tags         usuarios
+// @Accept       json
+// @Produce      json
+// @Param        id   path  string  true  "ID del usuario"
+// @Param        req  body  object  true  "rol_id"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/rol [put]
+func (h *UsuarioHandler) CambiarRolUsuario(c *gin.Context) {
+	var req struct {
+		RolID int `json:"rol_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u, ok := h.usuarioDelAlcance(c, ctx)
+	if !ok || !otroUsuario(c, u, "No puede cambiar su propio rol") || !h.gestionable(c, ctx, u) || !h.asignable(c, ctx, req.RolID) {
+		return
+	}
+	var antes int
+	if !h.cambiarCampo(c, ctx, u, audit.AccionCambiarRol, "rol_id", req.RolID, &antes) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Rol actualizado", "rol_id": req.RolID})
+}
+
+// CambiarConsultorioUsuario godoc
+// @Summary      Mover usuario de consultorio
+// @Description  Pasa el usuario a otro consultorio y cierra sus sesiones. Requiere alcance sobre ambos consultorios (en la práctica, alcance global).
+// @Tags         usuarios
+// @Accept       json
+// @Produce      json
+// @Param        id   path  string  true  "ID del usuario"
+// @Param        req  body  object  true  "consultorio_id"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/consultorio [put]
+func (h *UsuarioHandler) CambiarConsultorioUsuario(c *gin.Context) {
+	var req struct {
+		ConsultorioID string `json:"consultorio_id" binding:"required,uuid"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	destino := uuid.MustParse(req.ConsultorioID)
+	scope, ok := alcanceActual(c)
+	if !ok {
+		return
+	}
+	if !scope.Incluye(&destino) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No puede mover usuarios a otro consultorio"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u, ok := h.usuarioDelAlcance(c, ctx)
+	if !ok || !otroUsuario(c, u, "No puede cambiar su propio consultorio") || !h.gestionable(c, ctx, u) {
+		return
+	}
+	var antes *uuid.UUID
+	if !h.cambiarCampo(c, ctx, u, audit.AccionCambiarConsultorio, "consultorio_id", destino, &antes) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Consultorio actualizado", "consultorio_id": destino})
+}
+
+// DesactivarUsuario godoc
+// @Summary      Desactivar usuario
+// @Description  Impide el login del usuario y cierra sus sesiones; sus datos y su historial se conservan
+// @Tags         usuarios
+// @Produce      json
+// @Param        id   path      string  true  "ID del usuario"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/desactivar [post]
+func (h *UsuarioHandler) DesactivarUsuario(c *gin.Context) {
+	h.cambiarActivo(c, false)
+}
+
+// ReactivarUsuario godoc
+// @Summary      Reactivar usuario
+// @Description  Vuelve a habilitar el login de un usuario desactivado
+// @Tags         usuarios
+// @Produce      json
+// @Param        id   path      string  true  "ID del usuario"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/reactivar [post]
+func (h *UsuarioHandler) ReactivarUsuario(c *gin.Context) {
+	h.cambiarActivo(c, true)
+}
+
+func (h *UsuarioHandler) cambiarActivo(c *gin.Context, activo bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u, ok := h.usuarioDelAlcance(c, ctx)
+	if !ok || !otroUsuario(c, u, "No puede desactivar su propio usuario") || !h.gestionable(c, ctx, u) {
+		return
+	}
+	accion, msg := audit.AccionReactivar, "Usuario reactivado"
+	if !activo {
+		accion, msg = audit.AccionDesactivar, "Usuario desactivado"
+	}
+	if u.activo == activo {
+		c.JSON(http.StatusConflict, gin.H{"error": "El usuario ya está en ese estado"})
+		return
+	}
+	var antes bool
+	if !h.cambiarCampo(c, ctx, u, accion, "activo", activo, &antes) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": msg, "activo": activo})
+}
+
+// ForzarRestablecimiento godoc
+// @Summary      Forzar restablecimiento de contraseña
+// @Description  Invalida la contraseña actual, cierra las sesiones del usuario y le envía por correo un enlace para elegir otra
+// @Tags         usuarios
+// @Produce      json
+// @Param        id   path      string  true  "ID del usuario"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      403  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Failure      409  {object}  map[string]interface{}
+// @Failure      429  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/restablecer-contrasena [post]
+func (h *UsuarioHandler) ForzarRestablecimiento(c *gin.Context) {
+	if !h.correosDisponibles(c) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u, ok := h.usuarioDelAlcance(c, ctx)
+	if !ok || !h.gestionable(c, ctx, u) {
+		return
+	}
+	if !u.activo {
+		c.JSON(http.StatusConflict, gin.H{"error": "El usuario está desactivado"})
+		return
+	}
+	// Primero el correo: si se alcanzó el límite no se toca la contraseña
+	if !h.enviarCorreo(c, u, h.correos.EnviarRestablecimiento(ctx, u.id)) {
+		return
+	}
+	// El hash nunca se guarda en la auditoría
+	entry := audit.FromRequest(c, audit.AccionForzarRestablecimiento, "usuarios", u.id.String())
+	entry.ConsultorioID = u.consultorioID
+	entry.Despues = gin.H{"pendiente": true}
+	if err := audit.ExecTx(ctx, h.pool, entry, `UPDATE usuarios SET contrasena_hash = '' WHERE id = $1`, u.id); err != nil {
+		h.logger.Error("Error al forzar restablecimiento", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	h.revocarSesiones(ctx, u)
+	h.logger.Info("Restablecimiento de contraseña forzado", zap.String("usuario_id", u.id.String()))
+	c.JSON(http.StatusOK, gin.H{"message": "Se envió al usuario un enlace para elegir una contraseña nueva"})
+}
+
+// enviarCorreo traduce el error de un envío; devuelve false si ya respondió
+func (h *UsuarioHandler) enviarCorreo(c *gin.Context, u usuarioObjetivo, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrDemasiadasSolicitudes):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Demasiadas solicitudes, intente más tarde"})
+	case err != nil:
+		h.logger.Error("Error al enviar correo al usuario", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+	default:
+		return true
+	}
+	return false
+}
+
+// cambiarCampo asigna valor a la columna del usuario, audita el valor anterior (que deja en
+// antes) y el nuevo en la misma transacción y cierra las sesiones del usuario. Devuelve
+// false si ya respondió con un error. columna nunca viene del request.
+func (h *UsuarioHandler) cambiarCampo(c *gin.Context, ctx context.Context, u usuarioObjetivo, accion, columna string, valor, antes interface{}) bool {
+	tx, err := h.pool.Begin(ctx)
+	if err == nil {
+		defer tx.Rollback(ctx)
+		err = tx.QueryRow(ctx, `
+			UPDATE usuarios u SET `+columna+` = $2
+			FROM (SELECT id, `+columna+` FROM usuarios WHERE id = $1 FOR UPDATE) antes
+			WHERE u.id = antes.id
+			RETURNING antes.`+columna, u.id, valor).Scan(antes)
+	}
+	if err == nil {
+		entry := audit.FromRequest(c, accion, "usuarios", u.id.String())
+		entry.ConsultorioID = u.consultorioID
+		entry.Antes = gin.H{columna: antes}
+		entry.Despues = gin.H{columna: valor}
+		err = audit.Write(ctx, tx, entry)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al actualizar usuario", zap.Error(err),
+			zap.String("usuario_id", u.id.String()), zap.String("accion", accion))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return false
+	}
+	if accion != audit.AccionReactivar {
+		h.revocarSesiones(ctx, u)
+	}
+	h.logger.Info("Usuario actualizado", zap.String("usuario_id", u.id.String()), zap.String("accion", accion))
+	return true
+}
+
+// revocarSesiones cierra las sesiones del usuario. El trigger de usuarios también avisa por
+// usuarios_revocados; revocar acá hace que se cierren aunque la instancia no esté escuchando.
+func (h *UsuarioHandler) revocarSesiones(ctx context.Context, u usuarioObjetivo) {
+	if h.revocador == nil {
+		return
+	}
+	if err := h.revocador.RevocarUsuario(ctx, u.id.String(), time.Now()); err != nil {
+		h.logger.Error("Error al revocar sesiones del usuario", zap.Error(err), zap.String("usuario_id", u.id.String()))
+	}
+}
+
+// GetBloqueoUsuario godoc
+// @Summary      Estado de bloqueo de login
+// @Description  Indica si la cuenta está bloqueada temporalmente por intentos fallidos, por cuánto tiempo más y cuántos fallos lleva en la ventana actual
+// @Tags         usuarios
+// @Produce      json
+// @Param        id   path      string  true  "ID del usuario"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/bloqueo [get]
+func (h *UsuarioHandler) GetBloqueoUsuario(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u, ok := h.usuarioDelAlcance(c, ctx)
+	if !ok {
+		return
+	}
+	bloqueada, restante, err := h.bloqueos.IsAccountBlocked(ctx, u.nombre)
+	if err != nil {
+		h.logger.Error("Error al consultar bloqueo", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudo consultar el bloqueo"})
+		return
+	}
+	intentos, err := h.bloqueos.GetAccountAttempts(ctx, u.nombre)
+	if err != nil {
+		h.logger.Error("Error al consultar intentos", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudo consultar el bloqueo"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"usuario_id":    u.id,
+		"bloqueada":     bloqueada,
+		"reintentar_en": int(math.Ceil(restante.Seconds())),
+		"intentos":      intentos,
+	})
+}
+
+// DesbloquearUsuario godoc
+// @Summary      Desbloquear cuenta
+// @Description  Levanta el bloqueo temporal de login de la cuenta y borra sus intentos fallidos. El desbloqueo queda auditado.
+// @Tags         usuarios
+// @Produce      json
+// @Param        id   path      string  true  "ID del usuario"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  map[string]interface{}
+// @Router       /api/v1/usuarios/{id}/bloqueo [delete]
+func (h *UsuarioHandler) DesbloquearUsuario(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u, ok := h.usuarioDelAlcance(c, ctx)
+	if !ok {
+		return
+	}
+	bloqueada, _, err := h.bloqueos.IsAccountBlocked(ctx, u.nombre)
+	if err == nil {
+		err = h.bloqueos.UnblockAccount(ctx, u.nombre)
+	}
+	if err != nil {
+		h.logger.Error("Error al desbloquear cuenta", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudo desbloquear la cuenta"})
+		return
+	}
+
+	tx, err := h.pool.Begin(ctx)
+	if err != nil {
+		h.logger.Error("Error al desbloquear cuenta", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+	defer tx.Rollback(ctx)
+	var intentosAntes int
+	err = tx.QueryRow(ctx, `
+		UPDATE usuarios u SET intentos_fallidos = 0
+		FROM (SELECT id, intentos_fallidos FROM usuarios WHERE id = $1 FOR UPDATE) antes
+		WHERE u.id = antes.id
+		RETURNING antes.intentos_fallidos
+	`, u.id).Scan(&intentosAntes)
+	if err == nil {
+		entry := audit.FromRequest(c, audit.AccionDesbloquear, "usuarios", u.id.String())
+		entry.ConsultorioID = u.consultorioID
+		entry.Antes = gin.H{"bloqueada": bloqueada, "intentos_fallidos": intentosAntes}
+		entry.Despues = gin.H{"bloqueada": false, "intentos_fallidos": 0}
+		err = audit.Write(ctx, tx, entry)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		h.logger.Error("Error al auditar desbloqueo", zap.Error(err), zap.String("usuario_id", u.id.String()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+		return
+	}
+
+	h.logger.Info("Cuenta desbloqueada", zap.String("usuario_id", u.id.String()), zap.Bool("estaba_bloqueada", bloqueada))
+	c.JSON(http.StatusOK, gin.H{"message": "Cuenta desbloqueada", "estaba_bloqueada": bloqueada})
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// fakeBloqueos implementa BloqueosLogin en memoria
+type fakeBloqueos struct {
+	bloqueadas map[string]bool
+}
+
+func (f *fakeBloqueos) IsAccountBlocked(ctx context.Context, username string) (bool, time.Duration, error) {
+	if f.bloqueadas[username] {
+		return true, 5 * time.Minute, nil
+	}
+	return false, 0, nil
+}
+func (f *fakeBloqueos) GetAccountAttempts(ctx context.Context, username string) (int64, error) {
+	return 0, nil
+}
+func (f *fakeBloqueos) UnblockAccount(ctx context.Context, username string) error {
+	delete(f.bloqueadas, username)
+	return nil
+}
+
+// TestDesbloquearUsuario verifica que el desbloqueo se limita al consultorio, levanta el
+// bloqueo de Redis, pone en cero los intentos y queda auditado
+func TestDesbloquearUsuario(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	usuarioID := uuid.New()
+	bloqueos := &fakeBloqueos{bloqueadas: map[string]bool{"dra": true}}
+	tx := &mockTx{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if strings.Contains(sql, "UPDATE usuarios u SET intentos_fallidos = 0") {
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				setDest(dest, 0, 7)
+				return nil
+			}}
+		}
+		return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+	}}
+	var consultaArgs []interface{}
+	pool := &mockTxPool{tx: tx}
+	pool.queryRowFunc = func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		consultaArgs = args
+		if args[0] != usuarioID {
+			return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+		}
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			setDest(dest, 0, "dra")
+			setDest(dest, 1, consultorioTest)
+			return nil
+		}}
+	}
+	h := NewUsuarioHandler(pool, bloqueos, nil, nil, nil, zap.NewNop())
+
+	c, w := makeCtx("DELETE", "/", nil)
+	c.Params = gin.Params{{Key: "id", Value: uuid.NewString()}}
+	h.DesbloquearUsuario(c)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Se esperaba 404 para un usuario de otro consultorio, obtuvo %d", w.Code)
+	}
+
+	c, w = makeCtx("DELETE", "/", nil)
+	c.Params = gin.Params{{Key: "id", Value: usuarioID.String()}}
+	h.DesbloquearUsuario(c)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"estaba_bloqueada":true`) {
+		t.Fatalf("Se esperaba 200, obtuvo %d %s", w.Code, w.Body.String())
+	}
+	if len(consultaArgs) != 2 || consultaArgs[1] != consultorioTest {
+		t.Errorf("Se esperaba filtrar por consultorio: %v", consultaArgs)
+	}
+	if bloqueos.bloqueadas["dra"] {
+		t.Error("Se esperaba levantar el bloqueo")
+	}
+	auditado := false
+	for _, sql := range tx.execSQL {
+		auditado = auditado || strings.Contains(sql, "INSERT INTO auditorias")
+	}
+	if !tx.committed || !auditado {
+		t.Errorf("Se esperaba auditar el desbloqueo: %v", tx.execSQL)
+	}
+}
+
+// fakeCorreos registra las invitaciones y los restablecimientos enviados
+type fakeCorreos struct {
+	invitados, restablecidos []uuid.UUID
+}
+
+func (f *fakeCorreos) Invitar(ctx context.Context, usuarioID uuid.UUID) error {
+	f.invitados = append(f.invitados, usuarioID)
+	return nil
+}
+func (f *fakeCorreos) EnviarRestablecimiento(ctx context.Context, usuarioID uuid.UUID) error {
+	f.restablecidos = append(f.restablecidos, usuarioID)
+	return nil
+}
+
+// rolesTest simula la comparación de permisos entre roles: el rol 1 (admin) puede asignar
+// los roles 1 y 2 pero no el 9 (superadmin), y el rol 7 no existe
+func rolesTest(sql string, args []interface{}) (mockRowP, bool) {
+	if !strings.Contains(sql, "SELECT EXISTS (SELECT 1 FROM roles") {
+		return mockRowP{}, false
+	}
+	rolID := args[0].(int)
+	return mockRowP{scanFunc: func(dest ...interface{}) error {
+		setDest(dest, 0, rolID != 7)
+		setDest(dest, 1, rolID == 1 || rolID == 2)
+		return nil
+	}}, true
+}
+
+func adminCtx(method string, body interface{}, id string) (*gin.Context, *httptest.ResponseRecorder) {
+	raw, _ := json.Marshal(body)
+	c, w := makeCtx(method, "/", raw)
+	c.Set("role", 1)
+	c.Set("user_id", usuarioAdminTest.String())
+	if id != "" {
+		c.Params = gin.Params{{Key: "id", Value: id}}
+	}
+	return c, w
+}
+
+var usuarioAdminTest = uuid.New()
+
+// TestInvitarUsuario verifica que el alta por invitación no permite escalar permisos, ni
+// repetir nombres, ni dar de alta en otro consultorio, y que queda auditada
+func TestInvitarUsuario(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tx := &mockTx{}
+	pool := &mockTxPool{tx: tx}
+	pool.queryRowFunc = func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if row, ok := rolesTest(sql, args); ok {
+			return row
+		}
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			setDest(dest, 0, strings.EqualFold(args[0].(string), "dra"))
+			setDest(dest, 1, false)
+			return nil
+		}}
+	}
+	correos := &fakeCorreos{}
+	h := NewUsuarioHandler(pool, nil, correos, nil, nil, zap.NewNop())
+
+	casos := []struct {
+		nombre string
+		body   gin.H
+		code   int
+	}{
+		{"rol con más permisos", gin.H{"nombre": "nuevo", "email": "n@example.com", "rol_id": 9}, http.StatusForbidden},
+		{"rol inexistente", gin.H{"nombre": "nuevo", "email": "n@example.com", "rol_id": 7}, http.StatusBadRequest},
+		{"nombre repetido", gin.H{"nombre": "DRA", "email": "n@example.com", "rol_id": 2}, http.StatusConflict},
+		{"otro consultorio", gin.H{"nombre": "nuevo", "email": "n@example.com", "rol_id": 2, "consultorio_id": uuid.NewString()}, http.StatusForbidden},
+	}
+	for _, caso := range casos {
+		c, w := adminCtx("POST", caso.body, "")
+		h.InvitarUsuario(c)
+		if w.Code != caso.code {
+			t.Errorf("%s: se esperaba %d, obtuvo %d %s", caso.nombre, caso.code, w.Code, w.Body.String())
+		}
+	}
+	if len(tx.execSQL) != 0 || len(correos.invitados) != 0 {
+		t.Fatalf("No se esperaban altas: %v", tx.execSQL)
+	}
+
+	c, w := adminCtx("POST", gin.H{"nombre": "nuevo", "email": "n@example.com", "rol_id": 2}, "")
+	h.InvitarUsuario(c)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"invitacion_enviada":true`) {
+		t.Fatalf("Se esperaba 201, obtuvo %d %s", w.Code, w.Body.String())
+	}
+	if len(correos.invitados) != 1 || !tx.committed {
+		t.Fatalf("Se esperaba el alta y la invitación")
+	}
+	if !strings.Contains(tx.execSQL[0], "INSERT INTO usuarios") || !strings.Contains(tx.execSQL[0], "''") ||
+		!strings.Contains(tx.execSQL[len(tx.execSQL)-1], "INSERT INTO auditorias") {
+		t.Errorf("Se esperaba un alta sin contraseña y auditada: %v", tx.execSQL)
+	}
+}
+
+// TestCambiarRolUsuario verifica que no se puede cambiar el propio rol ni administrar o
+// asignar roles con más permisos, y que el cambio se audita y cierra las sesiones
+func TestCambiarRolUsuario(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	objetivo, superadmin := uuid.New(), uuid.New()
+	tx := &mockTx{queryRowFunc: func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if strings.Contains(sql, "UPDATE usuarios u SET rol_id = $2") {
+			return mockRowP{scanFunc: func(dest ...interface{}) error {
+				setDest(dest, 0, 2)
+				return nil
+			}}
+		}
+		return mockRowP{scanFunc: func(dest ...interface{}) error { return pgx.ErrNoRows }}
+	}}
+	pool := &mockTxPool{tx: tx}
+	pool.queryRowFunc = func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if row, ok := rolesTest(sql, args); ok {
+			return row
+		}
+		rol := 2
+		if args[0] == superadmin {
+			rol = 9
+		}
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			setDest(dest, 0, "usuario")
+			setDest(dest, 1, consultorioTest)
+			setDest(dest, 2, rol)
+			setDest(dest, 3, true)
+			return nil
+		}}
+	}
+	revocador := &fakeRevoker{}
+	h := NewUsuarioHandler(pool, nil, nil, revocador, nil, zap.NewNop())
+
+	casos := []struct {
+		nombre string
+		id     uuid.UUID
+		rolID  int
+		code   int
+	}{
+		{"propio rol", usuarioAdminTest, 2, http.StatusConflict},
+		{"usuario con más permisos", superadmin, 2, http.StatusForbidden},
+		{"rol con más permisos", objetivo, 9, http.StatusForbidden},
+	}
+	for _, caso := range casos {
+		c, w := adminCtx("PUT", gin.H{"rol_id": caso.rolID}, caso.id.String())
+		h.CambiarRolUsuario(c)
+		if w.Code != caso.code {
+			t.Errorf("%s: se esperaba %d, obtuvo %d %s", caso.nombre, caso.code, w.Code, w.Body.String())
+		}
+	}
+	if tx.committed || len(revocador.usuarios) != 0 {
+		t.Fatal("No se esperaban cambios")
+	}
+
+	c, w := adminCtx("PUT", gin.H{"rol_id": 1}, objetivo.String())
+	h.CambiarRolUsuario(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba 200, obtuvo %d %s", w.Code, w.Body.String())
+	}
+	if !tx.committed || len(tx.execSQL) == 0 || !strings.Contains(tx.execSQL[len(tx.execSQL)-1], "INSERT INTO auditorias") {
+		t.Errorf("Se esperaba el cambio auditado: %v", tx.execSQL)
+	}
+	if len(revocador.usuarios) != 1 || revocador.usuarios[0] != objetivo.String() {
+		t.Errorf("Se esperaba revocar las sesiones del usuario: %v", revocador.usuarios)
+	}
+}
+
+// TestCerrarSesionUsuario verifica que un administrador cierra sesiones solo de usuarios
+// que puede administrar y que el cierre queda auditado
+func TestCerrarSesionUsuario(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	usuarioID, superadminID := uuid.New(), uuid.New()
+	tx := &mockTx{}
+	pool := &mockTxPool{tx: tx}
+	pool.queryRowFunc = func(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+		if row, ok := rolesTest(sql, args); ok {
+			return row
+		}
+		return mockRowP{scanFunc: func(dest ...interface{}) error {
+			setDest(dest, 0, "dra")
+			setDest(dest, 1, consultorioTest)
+			if args[0] == superadminID {
+				setDest(dest, 2, 9)
+			} else {
+				setDest(dest, 2, 2)
+			}
+			setDest(dest, 3, true)
+			return nil
+		}}
+	}
+	sesiones := &fakeSesiones{}
+	sesiones.Registrar(context.Background(), "s1", usuarioID.String(), "Firefox", "10.0.0.1")
+	sesiones.Registrar(context.Background(), "s2", superadminID.String(), "Firefox", "10.0.0.1")
+	h := NewUsuarioHandler(pool, nil, nil, nil, sesiones, zap.NewNop())
+
+	cerrar := func(id uuid.UUID, sesion string) *httptest.ResponseRecorder {
+		c, w := adminCtx("DELETE", nil, id.String())
+		c.Params = append(c.Params, gin.Param{Key: "sesion", Value: sesion})
+		h.CerrarSesionUsuario(c)
+		return w
+	}
+
+	if w := cerrar(superadminID, "s2"); w.Code != http.StatusForbidden {
+		t.Fatalf("Se esperaba 403 para un usuario con más permisos, obtuvo %d", w.Code)
+	}
+	if w := cerrar(usuarioID, "s2"); w.Code != http.StatusNotFound {
+		t.Fatalf("Se esperaba 404 para la sesión de otro usuario, obtuvo %d", w.Code)
+	}
+	if w := cerrar(usuarioID, "s1"); w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba 200, obtuvo %d %s", w.Code, w.Body.String())
+	}
+	if _, ok := sesiones.sesiones["s1"]; ok || !tx.committed {
+		t.Errorf("Se esperaba cerrar la sesión y auditarlo: %v", tx.execSQL)
+	}
+
+	c, w := adminCtx("GET", nil, usuarioID.String())
+	h.GetSesionesUsuario(c)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"sesiones":[]`) {
+		t.Errorf("Se esperaba el listado vacío, obtuvo %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// FileMailer guarda cada mensaje como un archivo .eml en dir, o lo escribe en el log si dir
+// está vacío. Es para desarrollo y tests: los enlaces de los correos quedan a la vista, así
+// que no se usa en producción.
+type FileMailer struct {
+	dir    string
+	de     string
+	logger *zap.Logger
+}
+
+// NewFileMailer crea el mailer de archivos; de es el remitente que figura en los mensajes
+func NewFileMailer(dir, de string, logger *zap.Logger) *FileMailer {
+	return &FileMailer{dir: dir, de: de, logger: logger}
+}
+
+// Enviar guarda m
+func (f *FileMailer) Enviar(ctx context.Context, m Mensaje) error {
+	ahora := time.Now()
+	msg, err := armar(f.de, m, ahora)
+	if err != nil {
+		return err
+	}
+	if f.dir == "" {
+		f.logger.Info("Correo no enviado (mailer de desarrollo)",
+			zap.String("para", m.Para),
+			zap.String("asunto", m.Asunto),
+			zap.String("texto", m.Texto))
+		return nil
+	}
+	if err := os.MkdirAll(f.dir, 0o700); err != nil {
+		return err
+	}
+	nombre := filepath.Join(f.dir, fmt.Sprintf("%s-%d.eml", ahora.UTC().Format("20060102T150405"), ahora.UnixNano()))
+	return os.WriteFile(nombre, msg, 0o600)
+}
@@ -0,0 +1,74 @@
+// Package mailer envía los correos de la aplicación (restablecimiento de contraseña,
+// verificación de email). La implementación SMTP es la de producción; la de archivos sirve
+// para desarrollo y tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Mensaje es un correo de texto plano
+type Mensaje struct {
+	Para   string
+	Asunto string
+	Texto  string
+}
+
+// Mailer entrega mensajes
+type Mailer interface {
+	Enviar(ctx context.Context, m Mensaje) error
+}
+
+// ErrDestinatarioInvalido indica una dirección que no se puede usar como destinatario
+var ErrDestinatarioInvalido = errors.New("destinatario inválido")
+
+// armar genera el mensaje RFC 5322 completo. Rechaza saltos de línea en los encabezados
+// para que un dato del usuario no pueda agregar encabezados.
+func armar(de string, m Mensaje, ahora time.Time) ([]byte, error) {
+	para, err := mail.ParseAddress(m.Para)
+	if err != nil || strings.ContainsAny(m.Para, "\r\n") {
+		return nil, ErrDestinatarioInvalido
+	}
+	if strings.ContainsAny(m.Asunto, "\r\n") {
+		return nil, errors.New("el asunto no puede tener saltos de línea")
+	}
+	remitente, err := mail.ParseAddress(de)
+	if err != nil {
+		return nil, fmt.Errorf("remitente inválido: %w", err)
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	dominio := remitente.Address[strings.LastIndex(remitente.Address, "@")+1:]
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", remitente.String())
+	fmt.Fprintf(&b, "To: %s\r\n", para.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Asunto))
+	fmt.Fprintf(&b, "Date: %s\r\n", ahora.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), dominio)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Texto, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes(), nil
+}
+
+// direccion devuelve solo la dirección de correo (sin nombre) para el sobre SMTP
+func direccion(s string) (string, error) {
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+	return a.Address, nil
+}
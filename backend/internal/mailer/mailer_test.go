@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestArmarMensaje(t *testing.T) {
+	msg, err := armar("MediApp <noreply@mediapp.com>", Mensaje{
+		Para:   "dra@example.com",
+		Asunto: "Restablecer contraseña",
+		Texto:  "Hola\nEnlace: https://app/x",
+	}, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(msg)
+	for _, esperado := range []string{
+		"From: \"MediApp\" <noreply@mediapp.com>\r\n",
+		"To: <dra@example.com>\r\n",
+		"Subject: =?utf-8?q?Restablecer_contrase=C3=B1a?=\r\n",
+		"@mediapp.com>\r\n",
+		"\r\n\r\nHola\r\nEnlace: https://app/x",
+	} {
+		if !strings.Contains(s, esperado) {
+			t.Errorf("Falta %q en:\n%s", esperado, s)
+		}
+	}
+
+	// Un destinatario o asunto con saltos de línea no puede agregar encabezados
+	if _, err := armar("noreply@mediapp.com", Mensaje{Para: "a@b.com\r\nBcc: c@d.com"}, time.Now()); err != ErrDestinatarioInvalido {
+		t.Errorf("Se esperaba ErrDestinatarioInvalido, obtuvo %v", err)
+	}
+	if _, err := armar("noreply@mediapp.com", Mensaje{Para: "a@b.com", Asunto: "x\nBcc: c@d.com"}, time.Now()); err == nil {
+		t.Error("Se esperaba rechazar el asunto con salto de línea")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := NewFileMailer(dir, "noreply@mediapp.com", zap.NewNop())
+	if err := m.Enviar(context.Background(), Mensaje{Para: "dra@example.com", Asunto: "Hola", Texto: "token-123"}); err != nil {
+		t.Fatal(err)
+	}
+	archivos, _ := os.ReadDir(dir)
+	if len(archivos) != 1 || !strings.HasSuffix(archivos[0].Name(), ".eml") {
+		t.Fatalf("Se esperaba un .eml, hay %v", archivos)
+	}
+	contenido, _ := os.ReadFile(dir + "/" + archivos[0].Name())
+	if !strings.Contains(string(contenido), "token-123") {
+		t.Errorf("El archivo no tiene el cuerpo: %s", contenido)
+	}
+}
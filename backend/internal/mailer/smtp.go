@@ -0,0 +1,106 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// smtpTimeout limita cada envío cuando el contexto no trae un plazo
+const smtpTimeout = 30 * time.Second
+
+// SMTPConfig son los datos del servidor de correo saliente
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Usuario  string
+	Password string
+	// De es el remitente, por ejemplo "MediApp <noreply@mediapp.com>"
+	De string
+}
+
+// SMTPMailer envía por SMTP. En el puerto 465 usa TLS implícito; en los demás usa
+// STARTTLS si el servidor lo ofrece. net/smtp no manda credenciales sin TLS salvo a
+// localhost.
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+// NewSMTPMailer crea el mailer SMTP
+func NewSMTPMailer(cfg SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{cfg: cfg}
+}
+
+// Enviar entrega m al servidor SMTP
+func (s *SMTPMailer) Enviar(ctx context.Context, m Mensaje) error {
+	msg, err := armar(s.cfg.De, m, time.Now())
+	if err != nil {
+		return err
+	}
+	de, err := direccion(s.cfg.De)
+	if err != nil {
+		return err
+	}
+	para, err := direccion(m.Para)
+	if err != nil {
+		return ErrDestinatarioInvalido
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, smtpTimeout)
+		defer cancel()
+	}
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	if s.cfg.Port == 465 {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && s.cfg.Port != 465 {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Usuario != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Usuario, s.cfg.Password, s.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(de); err != nil {
+		return err
+	}
+	if err := c.Rcpt(para); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/logger"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/FolkodeGroup/mediapp/internal/utils"
+	"github.com/didip/tollbooth"
+	"github.com/didip/tollbooth/limiter"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// HeaderAPIKey lleva la API key de una integración
+const HeaderAPIKey = "X-API-Key"
+
+// APIKeyAuthenticator valida una API key (services.APIKeyService)
+type APIKeyAuthenticator interface {
+	Autenticar(ctx context.Context, clave, ip string) (services.APIKey, error)
+}
+
+// APIKeyOrJWT acepta una API key en HeaderAPIKey y, si no la hay, delega en jwt (el
+// middleware de JWTAuthMiddleware). Una key opera sobre su consultorio con sus permisos:
+// guarda en el contexto api_key_id, consultorio_id y los permisos, pero no user_id ni role.
+// Cada key tiene su propio límite de requests por minuto.
+func APIKeyOrJWT(keys APIKeyAuthenticator, jwt gin.HandlerFunc) gin.HandlerFunc {
+	limites := &limitesAPIKey{porLimite: map[int]*limiter.Limiter{}}
+	return func(c *gin.Context) {
+		clave := strings.TrimSpace(c.GetHeader(HeaderAPIKey))
+		if clave == "" {
+			jwt(c)
+			return
+		}
+
+		k, err := keys.Autenticar(c.Request.Context(), clave, utils.GetRealIP(c.Request))
+		if errors.Is(err, services.ErrAPIKeyInvalida) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "API key inválida, revocada o vencida"})
+			c.Abort()
+			return
+		} else if err != nil {
+			logger.FromContext(c.Request.Context()).Error("Error al verificar API key", zap.Error(err))
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudo verificar la API key"})
+			c.Abort()
+			return
+		}
+
+		if tollbooth.LimitByKeys(limites.de(k.LimitePorMinuto), []string{k.ID.String()}) != nil {
+			c.Header("Retry-After", strconv.Itoa(int(time.Minute.Seconds())/k.LimitePorMinuto+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "La API key superó su límite de requests por minuto"})
+			c.Abort()
+			return
+		}
+
+		permisos := make(map[string]struct{}, len(k.Permisos))
+		for _, p := range k.Permisos {
+			permisos[p] = struct{}{}
+		}
+		c.Set("api_key_id", k.ID.String())
+		c.Set("api_key_permisos", permisos)
+		c.Set("consultorio_id", k.ConsultorioID.String())
+		c.Next()
+	}
+}
+
+// permisosAPIKey devuelve los permisos de la API key del request, si se autenticó con una
+func permisosAPIKey(c *gin.Context) (map[string]struct{}, bool) {
+	value, exists := c.Get("api_key_permisos")
+	if !exists {
+		return nil, false
+	}
+	permisos, ok := value.(map[string]struct{})
+	return permisos, ok
+}
+
+// limitesAPIKey tiene un limitador por cada límite por minuto en uso; dentro de cada uno
+// las keys tienen su propio balde. Los contadores son de esta instancia: con N réplicas
+// detrás del balanceador una key puede hacer hasta N veces su límite por minuto.
+type limitesAPIKey struct {
+	mu        sync.Mutex
+	porLimite map[int]*limiter.Limiter
+}
+
+func (l *limitesAPIKey) de(porMinuto int) *limiter.Limiter {
+	if porMinuto <= 0 {
+		porMinuto = services.LimiteAPIKeyDefault
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lmt, ok := l.porLimite[porMinuto]
+	if !ok {
+		// tollbooth cuenta por segundo; el balde admite el minuto completo de una vez
+		lmt = tollbooth.NewLimiter(float64(porMinuto)/60, &limiter.ExpirableOptions{
+			DefaultExpirationTTL: time.Hour,
+		}).SetBurst(porMinuto)
+		l.porLimite[porMinuto] = lmt
+	}
+	return lmt
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// fakeAPIKeys acepta una sola clave
+type fakeAPIKeys struct {
+	clave string
+	key   services.APIKey
+}
+
+func (f fakeAPIKeys) Autenticar(ctx context.Context, clave, ip string) (services.APIKey, error) {
+	if clave != f.clave {
+		return services.APIKey{}, services.ErrAPIKeyInvalida
+	}
+	return f.key, nil
+}
+
+// TestAPIKeyOrJWT verifica que una API key opera con sus permisos sobre su consultorio,
+// no lee datos clínicos y respeta su límite por minuto
+func TestAPIKeyOrJWT(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	consultorio := uuid.New()
+	keys := fakeAPIKeys{clave: "mk_abc_secreto", key: services.APIKey{
+		ID: uuid.New(), ConsultorioID: consultorio, Permisos: []string{"turnos:read"}, LimitePorMinuto: 3,
+	}}
+	jwt := func(c *gin.Context) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token requerido"})
+		c.Abort()
+	}
+	checker := fakeChecker{}
+	router := gin.New()
+	router.Use(APIKeyOrJWT(keys, jwt), TenantScope(checker, nil))
+	router.GET("/turnos", RequirePermission(checker, "turnos:read"), func(c *gin.Context) {
+		scope, _ := tenant.FromContext(c)
+		c.JSON(http.StatusOK, gin.H{"consultorio": scope.ConsultorioID})
+	})
+	router.POST("/turnos", RequirePermission(checker, "turnos:write"), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+	router.GET("/pacientes/:id", RequirePatientAccess(&fakeAccessChecker{}, "paciente", PacienteFromParam("id")), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	pedir := func(method, path, clave, consultorioHeader string) int {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		if clave != "" {
+			req.Header.Set(HeaderAPIKey, clave)
+		}
+		if consultorioHeader != "" {
+			req.Header.Set(tenant.HeaderConsultorio, consultorioHeader)
+		}
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := pedir("GET", "/turnos", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("Sin key se esperaba el middleware JWT (401), obtuvo %d", code)
+	}
+	if code := pedir("GET", "/turnos", "mk_abc_otro", ""); code != http.StatusUnauthorized {
+		t.Errorf("Se esperaba 401 con una key inválida, obtuvo %d", code)
+	}
+	if code := pedir("GET", "/turnos", keys.clave, ""); code != http.StatusOK {
+		t.Errorf("Se esperaba 200 con el permiso de la key, obtuvo %d", code)
+	}
+	if code := pedir("POST", "/turnos", keys.clave, ""); code != http.StatusForbidden {
+		t.Errorf("Se esperaba 403 sin el permiso en la key, obtuvo %d", code)
+	}
+	if code := pedir("GET", "/pacientes/"+uuid.NewString(), keys.clave, ""); code != http.StatusForbidden {
+		t.Errorf("Se esperaba 403 al leer datos clínicos con una key, obtuvo %d", code)
+	}
+	// Tres requests por minuto: el cuarto supera el límite
+	if code := pedir("GET", "/turnos", keys.clave, uuid.NewString()); code != http.StatusTooManyRequests {
+		t.Errorf("Se esperaba 429 al superar el límite de la key, obtuvo %d", code)
+	}
+
+	otra := fakeAPIKeys{clave: "mk_def_secreto", key: services.APIKey{
+		ID: uuid.New(), ConsultorioID: consultorio, Permisos: []string{"turnos:read"}, LimitePorMinuto: 10,
+	}}
+	router = gin.New()
+	router.Use(APIKeyOrJWT(otra, jwt), TenantScope(checker, nil))
+	router.GET("/turnos", func(c *gin.Context) { c.Status(http.StatusOK) })
+	if code := pedir("GET", "/turnos", otra.clave, uuid.NewString()); code != http.StatusForbidden {
+		t.Errorf("Se esperaba 403 al pedir otro consultorio con una key, obtuvo %d", code)
+	}
+	if code := pedir("GET", "/turnos", otra.clave, consultorio.String()); code != http.StatusOK {
+		t.Errorf("Se esperaba 200 con el consultorio propio en el encabezado, obtuvo %d", code)
+	}
+}
@@ -1,15 +1,57 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/FolkodeGroup/mediapp/internal/auth"
+	"github.com/FolkodeGroup/mediapp/internal/logger"
+	"github.com/FolkodeGroup/mediapp/internal/utils"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
-// JWTAuthMiddleware protege rutas y extrae claims del token JWT
-func JWTAuthMiddleware() gin.HandlerFunc {
+// RevocationChecker indica si un token fue revocado (services.RevocationService)
+type RevocationChecker interface {
+	Revocado(ctx context.Context, jti, usuarioID string, emitido time.Time) (bool, error)
+}
+
+// SessionChecker indica si la sesión de un token sigue abierta y registra su actividad
+// (services.SessionService)
+type SessionChecker interface {
+	Activa(ctx context.Context, sesionID, usuarioID, ip string) (bool, error)
+}
+
+// JWTAuthMiddleware protege rutas y extrae claims del token JWT. Con un RevocationChecker
+// además rechaza los tokens revocados (logout, usuario desactivado o cambio de contraseña);
+// si no se puede consultar la lista de revocados responde 503 en lugar de dejar pasar.
+func JWTAuthMiddleware(revocados ...RevocationChecker) gin.HandlerFunc {
+	var checker RevocationChecker
+	if len(revocados) > 0 {
+		checker = revocados[0]
+	}
+	return JWTAuthMiddlewareWithSesiones(checker, nil)
+}
+
+// JWTAuthMiddlewareWithSesiones además rechaza los tokens de sesiones cerradas desde
+// /me/sessions o por un administrador, y registra la última actividad de la sesión. Los
+// tokens sin sesión (emitidos antes del registro de sesiones) no se controlan.
+//
+// Los tokens emitidos a una app por /oauth/token responden 403: llevan el rol y el
+// consultorio del usuario, pero la app solo fue autorizada para los scopes de OpenID.
+func JWTAuthMiddlewareWithSesiones(checker RevocationChecker, sesiones SessionChecker) gin.HandlerFunc {
+	return jwtAuth(checker, sesiones, false)
+}
+
+// JWTAuthMiddlewareParaApps es JWTAuthMiddlewareWithSesiones pero también acepta los
+// tokens emitidos a una app. Es solo para /oauth/userinfo, que responde según el scope.
+func JWTAuthMiddlewareParaApps(checker RevocationChecker, sesiones SessionChecker) gin.HandlerFunc {
+	return jwtAuth(checker, sesiones, true)
+}
+
+func jwtAuth(checker RevocationChecker, sesiones SessionChecker, aceptarApps bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -33,9 +75,63 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if claims.ClientID != "" && !aceptarApps {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Un token emitido a una app solo sirve para /oauth/userinfo"})
+			c.Abort()
+			return
+		}
+
+		if checker != nil {
+			var emitido time.Time
+			if claims.IssuedAt != nil {
+				emitido = claims.IssuedAt.Time
+			}
+			revocado, err := checker.Revocado(c.Request.Context(), claims.ID, claims.UserID, emitido)
+			if err != nil {
+				logger.FromContext(c.Request.Context()).Error("Error al consultar tokens revocados", zap.Error(err))
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudo verificar el token"})
+				c.Abort()
+				return
+			}
+			if revocado {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revocado"})
+				c.Abort()
+				return
+			}
+		}
+
+		if sesiones != nil && claims.SesionID != "" {
+			activa, err := sesiones.Activa(c.Request.Context(), claims.SesionID, claims.UserID, utils.GetRealIP(c.Request))
+			if err != nil {
+				logger.FromContext(c.Request.Context()).Error("Error al consultar la sesión", zap.Error(err))
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No se pudo verificar el token"})
+				c.Abort()
+				return
+			}
+			if !activa {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Sesión cerrada"})
+				c.Abort()
+				return
+			}
+		}
+
 		// Guardar claims en el contexto
 		c.Set("user_id", claims.UserID)
 		c.Set("role", claims.RolID)
+		c.Set("consultorio_id", claims.ConsultorioID)
+		c.Set("jti", claims.ID)
+		c.Set("sid", claims.SesionID)
+		if claims.ExpiresAt != nil {
+			c.Set("token_exp", claims.ExpiresAt.Time)
+		}
+		if claims.IssuedAt != nil {
+			c.Set("token_iat", claims.IssuedAt.Time)
+		}
+		// Tokens emitidos a una app por /oauth/token
+		if claims.ClientID != "" {
+			c.Set("oauth_client_id", claims.ClientID)
+			c.Set("oauth_scope", claims.Scope)
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+type fakeRevocados struct {
+	revocado bool
+	err      error
+	jti      string
+}
+
+func (f *fakeRevocados) Revocado(ctx context.Context, jti, usuarioID string, emitido time.Time) (bool, error) {
+	f.jti = jti
+	return f.revocado, f.err
+}
+
+// TestJWTAuthMiddlewareRevocation verifica que se rechazan los tokens revocados y que un
+// error de la lista de revocados no deja pasar el pedido
+func TestJWTAuthMiddlewareRevocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.Init(zap.NewNop(), nil)
+	token, err := auth.GenerateToken("u1", 1, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	casos := []struct {
+		nombre  string
+		checker *fakeRevocados
+		want    int
+	}{
+		{"vigente", &fakeRevocados{}, http.StatusOK},
+		{"revocado", &fakeRevocados{revocado: true}, http.StatusUnauthorized},
+		{"redis caído", &fakeRevocados{err: errors.New("sin conexión")}, http.StatusServiceUnavailable},
+	}
+	for _, tc := range casos {
+		r := gin.New()
+		var jti string
+		r.GET("/", JWTAuthMiddleware(tc.checker), func(c *gin.Context) {
+			jti = c.GetString("jti")
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: se esperaba %d, obtuvo %d", tc.nombre, tc.want, rec.Code)
+		}
+		if tc.checker.jti == "" {
+			t.Errorf("%s: se esperaba consultar el jti del token", tc.nombre)
+		}
+		if tc.want == http.StatusOK && jti != tc.checker.jti {
+			t.Errorf("%s: se esperaba el jti en el contexto, obtuvo %q", tc.nombre, jti)
+		}
+	}
+}
+
+type fakeSesiones struct {
+	abiertas map[string]string
+	err      error
+	ip       string
+}
+
+func (f *fakeSesiones) Activa(ctx context.Context, sesionID, usuarioID, ip string) (bool, error) {
+	f.ip = ip
+	return f.abiertas[sesionID] == usuarioID, f.err
+}
+
+// TestJWTAuthMiddlewareSesiones verifica que se rechazan los tokens de sesiones cerradas y
+// que los tokens sin sesión no se controlan
+func TestJWTAuthMiddlewareSesiones(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.Init(zap.NewNop(), nil)
+	abierta, _ := auth.GenerateSessionToken("u1", 1, "c1", "s1")
+	cerrada, _ := auth.GenerateSessionToken("u1", 1, "c1", "s2")
+	sinSesion, _ := auth.GenerateToken("u1", 1, "c1")
+
+	casos := []struct {
+		nombre   string
+		token    string
+		sesiones *fakeSesiones
+		want     int
+	}{
+		{"abierta", abierta, &fakeSesiones{abiertas: map[string]string{"s1": "u1"}}, http.StatusOK},
+		{"cerrada", cerrada, &fakeSesiones{abiertas: map[string]string{"s1": "u1"}}, http.StatusUnauthorized},
+		{"sin sesión", sinSesion, &fakeSesiones{}, http.StatusOK},
+		{"redis caído", abierta, &fakeSesiones{err: errors.New("sin conexión")}, http.StatusServiceUnavailable},
+	}
+	for _, tc := range casos {
+		r := gin.New()
+		var sid string
+		r.GET("/", JWTAuthMiddlewareWithSesiones(&fakeRevocados{}, tc.sesiones), func(c *gin.Context) {
+			sid = c.GetString("sid")
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		req.Header.Set("X-Real-IP", "10.0.0.7")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: se esperaba %d, obtuvo %d", tc.nombre, tc.want, rec.Code)
+		}
+		if tc.nombre == "abierta" && (sid != "s1" || tc.sesiones.ip != "10.0.0.7") {
+			t.Errorf("%s: se esperaba la sesión en el contexto y la IP del cliente, obtuvo %q %q", tc.nombre, sid, tc.sesiones.ip)
+		}
+	}
+}
+
+// TestJWTAuthMiddlewareTokensDeApp verifica que un token emitido a una app OAuth no pasa
+// por las rutas de la API aunque lleve el rol y el consultorio del usuario, y que sí sirve
+// para /oauth/userinfo. El ID token de la app no sirve en ninguna.
+func TestJWTAuthMiddlewareTokensDeApp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth.Init(zap.NewNop(), nil)
+	deApp, err := auth.GenerateClientToken("u1", 1, "c1", "s1", "portal", "openid profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	propio, _ := auth.GenerateSessionToken("u1", 1, "c1", "s1")
+	idToken, err := auth.GenerateIDToken("https://api.mediapp.test", "portal", auth.IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "u1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sesiones := &fakeSesiones{abiertas: map[string]string{"s1": "u1"}}
+
+	r := gin.New()
+	jwt := JWTAuthMiddlewareWithSesiones(&fakeRevocados{}, sesiones)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/api/v1/pacientes", APIKeyOrJWT(fakeAPIKeys{}, jwt), ok)
+	r.GET("/oauth/userinfo", JWTAuthMiddlewareParaApps(&fakeRevocados{}, sesiones), func(c *gin.Context) {
+		if c.GetString("oauth_client_id") != "portal" || c.GetString("oauth_scope") != "openid profile" {
+			t.Errorf("Se esperaba la app y el scope en el contexto")
+		}
+		c.Status(http.StatusOK)
+	})
+
+	casos := []struct {
+		nombre string
+		ruta   string
+		token  string
+		want   int
+	}{
+		{"app en la API", "/api/v1/pacientes", deApp, http.StatusForbidden},
+		{"login propio en la API", "/api/v1/pacientes", propio, http.StatusOK},
+		{"app en userinfo", "/oauth/userinfo", deApp, http.StatusOK},
+		{"ID token en la API", "/api/v1/pacientes", idToken, http.StatusUnauthorized},
+		{"ID token en userinfo", "/oauth/userinfo", idToken, http.StatusUnauthorized},
+	}
+	for _, tc := range casos {
+		req := httptest.NewRequest(http.MethodGet, tc.ruta, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: se esperaba %d, obtuvo %d", tc.nombre, tc.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/FolkodeGroup/mediapp/internal/logger"
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/FolkodeGroup/mediapp/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	// HeaderAccesoEmergencia lleva la justificación de un acceso de emergencia ("romper el vidrio")
+	HeaderAccesoEmergencia = "X-Acceso-Emergencia"
+	// HeaderMotivoAcceso indica opcionalmente el motivo de una lectura habitual
+	HeaderMotivoAcceso = "X-Motivo-Acceso"
+
+	// MinJustificacionEmergencia es el largo mínimo de la justificación
+	MinJustificacionEmergencia = 20
+)
+
+// PatientAccessChecker decide y registra los accesos a datos clínicos
+type PatientAccessChecker interface {
+	EvaluarAcceso(ctx context.Context, usuarioID, pacienteID uuid.UUID) (services.EvaluacionAcceso, error)
+	RegistrarAcceso(ctx context.Context, a services.Acceso) error
+}
+
+// PacienteResolver obtiene el paciente al que apunta el request. Devuelve uuid.Nil
+// si no hay paciente que controlar (ID inválido o inexistente); el handler responde.
+type PacienteResolver func(c *gin.Context) (uuid.UUID, error)
+
+// PacienteFromParam toma el paciente de un parámetro de la ruta
+func PacienteFromParam(param string) PacienteResolver {
+	return func(c *gin.Context) (uuid.UUID, error) {
+		id, err := uuid.Parse(c.Param(param))
+		if err != nil {
+			return uuid.Nil, nil
+		}
+		return id, nil
+	}
+}
+
+// RequirePatientAccess registra cada lectura de datos clínicos y solo la permite si el
+// profesional está asignado al paciente o a su consultorio. Fuera de esos casos exige
+// un acceso de emergencia con justificación, que queda marcado para revisión.
+// Debe usarse después de JWTAuthMiddleware. Las API keys no son profesionales: no leen
+// datos clínicos.
+func RequirePatientAccess(checker PatientAccessChecker, recurso string, resolver PacienteResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := logger.FromContext(c.Request.Context())
+
+		if _, ok := permisosAPIKey(c); ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Las API keys no tienen acceso a datos clínicos de pacientes"})
+			c.Abort()
+			return
+		}
+
+		usuarioID, err := uuid.Parse(c.GetString("user_id"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+			c.Abort()
+			return
+		}
+
+		pacienteID, err := resolver(c)
+		if err != nil {
+			log.Error("Error al resolver paciente", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			c.Abort()
+			return
+		}
+		if pacienteID == uuid.Nil {
+			c.Next()
+			return
+		}
+
+		ev, err := checker.EvaluarAcceso(c.Request.Context(), usuarioID, pacienteID)
+		if err != nil {
+			log.Error("Error al evaluar acceso a paciente", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			c.Abort()
+			return
+		}
+		if !ev.PacienteExiste {
+			c.Next()
+			return
+		}
+		// Un super-admin que ya eligió (y auditó) operar sobre el consultorio del
+		// paciente no necesita romper el vidrio
+		if scope, ok := tenant.FromContext(c); ok && scope.CrossTenant && scope.Incluye(ev.ConsultorioID) {
+			ev.Autorizado = true
+		}
+
+		acceso := services.Acceso{
+			UsuarioID:     usuarioID,
+			PacienteID:    pacienteID,
+			ConsultorioID: ev.ConsultorioID,
+			Recurso:       recurso,
+			Ruta:          c.FullPath(),
+			Motivo:        headerOpcional(c, HeaderMotivoAcceso),
+			IP:            utils.GetRealIP(c.Request),
+			RequestID:     c.GetString("request_id"),
+			Permitido:     ev.Autorizado,
+		}
+
+		if !ev.Autorizado {
+			justificacion := headerOpcional(c, HeaderAccesoEmergencia)
+			if justificacion != nil && utf8.RuneCountInString(*justificacion) < MinJustificacionEmergencia {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "La justificación del acceso de emergencia es demasiado corta",
+				})
+				c.Abort()
+				return
+			}
+			if justificacion != nil {
+				acceso.Permitido = true
+				acceso.Emergencia = true
+				acceso.Justificacion = justificacion
+			}
+		}
+
+		// Si no se puede registrar el acceso, no se muestran los datos
+		if err := checker.RegistrarAcceso(c.Request.Context(), acceso); err != nil {
+			log.Error("Error al registrar acceso a paciente", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			c.Abort()
+			return
+		}
+
+		if !acceso.Permitido {
+			log.Warn("Acceso a paciente denegado",
+				zap.String("usuario_id", usuarioID.String()),
+				zap.String("paciente_id", pacienteID.String()),
+				zap.String("recurso", recurso))
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":      "No está asignado a este paciente ni a su consultorio",
+				"emergencia": "Para un acceso de emergencia reenvíe la solicitud con el encabezado " + HeaderAccesoEmergencia + " y una justificación; quedará sujeto a revisión",
+			})
+			c.Abort()
+			return
+		}
+
+		// El paciente puede ser de otro consultorio (asignación o emergencia):
+		// se habilita solo para este request
+		tenant.HabilitarPaciente(c, pacienteID)
+		c.Set("acceso_emergencia", acceso.Emergencia)
+		c.Next()
+	}
+}
+
+func headerOpcional(c *gin.Context, nombre string) *string {
+	v := strings.TrimSpace(c.GetHeader(nombre))
+	if v == "" {
+		return nil
+	}
+	return &v
+}
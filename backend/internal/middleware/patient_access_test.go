@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FolkodeGroup/mediapp/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type fakeAccessChecker struct {
+	ev          services.EvaluacionAcceso
+	registrados []services.Acceso
+	errLog      error
+}
+
+func (f *fakeAccessChecker) EvaluarAcceso(ctx context.Context, usuarioID, pacienteID uuid.UUID) (services.EvaluacionAcceso, error) {
+	return f.ev, nil
+}
+
+func (f *fakeAccessChecker) RegistrarAcceso(ctx context.Context, a services.Acceso) error {
+	if f.errLog != nil {
+		return f.errLog
+	}
+	f.registrados = append(f.registrados, a)
+	return nil
+}
+
+func runPatientAccess(t *testing.T, checker PatientAccessChecker, justificacion string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/pacientes/:id", func(c *gin.Context) {
+		c.Set("user_id", uuid.New().String())
+		c.Next()
+	}, RequirePatientAccess(checker, "paciente", PacienteFromParam("id")), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"emergencia": c.GetBool("acceso_emergencia")})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/pacientes/"+uuid.New().String(), nil)
+	if justificacion != "" {
+		req.Header.Set(HeaderAccesoEmergencia, justificacion)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequirePatientAccess_AsignadoRegistraLectura(t *testing.T) {
+	checker := &fakeAccessChecker{ev: services.EvaluacionAcceso{PacienteExiste: true, Autorizado: true}}
+	w := runPatientAccess(t, checker, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", w.Code)
+	}
+	if len(checker.registrados) != 1 || !checker.registrados[0].Permitido || checker.registrados[0].Emergencia {
+		t.Fatalf("Se esperaba un acceso permitido registrado, obtuvo %+v", checker.registrados)
+	}
+}
+
+func TestRequirePatientAccess_NoAsignadoDenegadoYRegistrado(t *testing.T) {
+	checker := &fakeAccessChecker{ev: services.EvaluacionAcceso{PacienteExiste: true}}
+	w := runPatientAccess(t, checker, "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Se esperaba status 403, obtuvo %d", w.Code)
+	}
+	if len(checker.registrados) != 1 || checker.registrados[0].Permitido {
+		t.Fatalf("Se esperaba registrar el intento denegado, obtuvo %+v", checker.registrados)
+	}
+}
+
+func TestRequirePatientAccess_EmergenciaConJustificacion(t *testing.T) {
+	checker := &fakeAccessChecker{ev: services.EvaluacionAcceso{PacienteExiste: true}}
+	w := runPatientAccess(t, checker, "Paciente inconsciente en guardia, sin médico de cabecera")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"emergencia":true`) {
+		t.Errorf("Se esperaba marcar el acceso de emergencia en el contexto, obtuvo %s", w.Body.String())
+	}
+	a := checker.registrados[0]
+	if !a.Permitido || !a.Emergencia || a.Justificacion == nil {
+		t.Fatalf("Se esperaba un acceso de emergencia con justificación, obtuvo %+v", a)
+	}
+}
+
+func TestRequirePatientAccess_JustificacionCorta(t *testing.T) {
+	checker := &fakeAccessChecker{ev: services.EvaluacionAcceso{PacienteExiste: true}}
+	w := runPatientAccess(t, checker, "urgente")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Se esperaba status 400, obtuvo %d", w.Code)
+	}
+	if len(checker.registrados) != 0 {
+		t.Errorf("No se esperaba registrar el acceso, obtuvo %+v", checker.registrados)
+	}
+}
+
+func TestRequirePatientAccess_FallaRegistroNoMuestraDatos(t *testing.T) {
+	checker := &fakeAccessChecker{
+		ev:     services.EvaluacionAcceso{PacienteExiste: true, Autorizado: true},
+		errLog: errors.New("db caída"),
+	}
+	w := runPatientAccess(t, checker, "")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Se esperaba status 500, obtuvo %d", w.Code)
+	}
+}
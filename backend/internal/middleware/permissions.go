@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/FolkodeGroup/mediapp/internal/logger"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// PermissionChecker resuelve si un rol tiene un permiso determinado
+type PermissionChecker interface {
+	HasPermission(ctx context.Context, rolID int, permiso string) (bool, error)
+}
+
+// RequirePermission exige que el rol del usuario autenticado tenga el permiso indicado.
+// Debe usarse después de JWTAuthMiddleware, que es quien guarda el rol en el contexto. Con
+// una API key (APIKeyOrJWT) el permiso tiene que estar entre los de la key.
+func RequirePermission(checker PermissionChecker, permiso string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if permisos, ok := permisosAPIKey(c); ok {
+			if _, permitido := permisos[permiso]; !permitido {
+				logger.FromContext(c.Request.Context()).Warn("Acceso denegado por falta de permiso de la API key",
+					zap.String("api_key_id", c.GetString("api_key_id")),
+					zap.String("permiso", permiso),
+					zap.String("path", c.Request.URL.Path))
+				c.JSON(http.StatusForbidden, gin.H{"error": "La API key no tiene permisos para realizar esta acción"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		value, exists := c.Get("role")
+		rolID, ok := value.(int)
+		if !exists || !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+			c.Abort()
+			return
+		}
+
+		allowed, err := checker.HasPermission(c.Request.Context(), rolID, permiso)
+		if err != nil {
+			logger.FromContext(c.Request.Context()).Error("Error al verificar permisos",
+				zap.Error(err), zap.Int("rol_id", rolID), zap.String("permiso", permiso))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			c.Abort()
+			return
+		}
+
+		if !allowed {
+			logger.FromContext(c.Request.Context()).Warn("Acceso denegado por falta de permiso",
+				zap.Int("rol_id", rolID),
+				zap.String("permiso", permiso),
+				zap.String("path", c.Request.URL.Path))
+			c.JSON(http.StatusForbidden, gin.H{"error": "No tiene permisos para realizar esta acción"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fakeChecker struct {
+	permisos map[int][]string
+	err      error
+}
+
+func (f fakeChecker) HasPermission(ctx context.Context, rolID int, permiso string) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	for _, p := range f.permisos[rolID] {
+		if p == permiso {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func runRequirePermission(t *testing.T, checker PermissionChecker, role interface{}, permiso string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/recurso", func(c *gin.Context) {
+		if role != nil {
+			c.Set("role", role)
+		}
+		c.Next()
+	}, RequirePermission(checker, permiso), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/recurso", nil)
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequirePermission_Allowed(t *testing.T) {
+	checker := fakeChecker{permisos: map[int][]string{2: {"pacientes:read", "pacientes:write"}}}
+	rec := runRequirePermission(t, checker, 2, "pacientes:write")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", rec.Code)
+	}
+}
+
+func TestRequirePermission_Forbidden(t *testing.T) {
+	// Recepcionista sin permiso para borrar pacientes
+	checker := fakeChecker{permisos: map[int][]string{3: {"pacientes:read", "turnos:write"}}}
+	rec := runRequirePermission(t, checker, 3, "pacientes:delete")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Se esperaba status 403, obtuvo %d", rec.Code)
+	}
+}
+
+func TestRequirePermission_WithoutRole(t *testing.T) {
+	rec := runRequirePermission(t, fakeChecker{}, nil, "pacientes:read")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Se esperaba status 401, obtuvo %d", rec.Code)
+	}
+}
+
+func TestRequirePermission_CheckerError(t *testing.T) {
+	rec := runRequirePermission(t, fakeChecker{err: errors.New("db caída")}, 1, "pacientes:read")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Se esperaba status 500, obtuvo %d", rec.Code)
+	}
+}
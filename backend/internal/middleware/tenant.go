@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/logger"
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// TxBeginner abre la transacción en la que se audita el acceso entre consultorios
+type TxBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// TenantScope fija el consultorio sobre el que opera el request. Por defecto es el
+// del token; un rol con el permiso tenant.PermisoGlobal puede elegir otro (o todos)
+// con el encabezado tenant.HeaderConsultorio, y cada uno de esos requests queda
+// auditado antes de llegar al handler. Debe usarse después de JWTAuthMiddleware. Una API
+// key opera solo sobre su consultorio.
+func TenantScope(checker PermissionChecker, db TxBeginner) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := logger.FromContext(c.Request.Context())
+
+		if _, ok := permisosAPIKey(c); ok {
+			propio, err := uuid.Parse(c.GetString("consultorio_id"))
+			solicitado := strings.TrimSpace(c.GetHeader(tenant.HeaderConsultorio))
+			if err != nil || (solicitado != "" && solicitado != propio.String()) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Una API key solo opera sobre su consultorio"})
+				c.Abort()
+				return
+			}
+			tenant.Set(c, tenant.Scope{ConsultorioID: propio})
+			c.Next()
+			return
+		}
+
+		value, exists := c.Get("role")
+		rolID, ok := value.(int)
+		if !exists || !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
+			c.Abort()
+			return
+		}
+		propio, errPropio := uuid.Parse(c.GetString("consultorio_id"))
+
+		solicitado := strings.TrimSpace(c.GetHeader(tenant.HeaderConsultorio))
+		if solicitado == "" || (errPropio == nil && solicitado == propio.String()) {
+			if errPropio != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": "El usuario no tiene un consultorio asignado"})
+				c.Abort()
+				return
+			}
+			tenant.Set(c, tenant.Scope{ConsultorioID: propio})
+			c.Next()
+			return
+		}
+
+		scope := tenant.Scope{Global: solicitado == tenant.Todos, CrossTenant: true}
+		if !scope.Global {
+			destino, err := uuid.Parse(solicitado)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Consultorio inválido en " + tenant.HeaderConsultorio})
+				c.Abort()
+				return
+			}
+			scope.ConsultorioID = destino
+		}
+
+		permitido, err := checker.HasPermission(c.Request.Context(), rolID, tenant.PermisoGlobal)
+		if err != nil {
+			log.Error("Error al verificar permisos", zap.Error(err), zap.Int("rol_id", rolID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			c.Abort()
+			return
+		}
+		if !permitido {
+			log.Warn("Acceso a otro consultorio denegado",
+				zap.Int("rol_id", rolID),
+				zap.String("consultorio", solicitado),
+				zap.String("path", c.Request.URL.Path))
+			c.JSON(http.StatusForbidden, gin.H{"error": "No tiene permisos para operar sobre otro consultorio"})
+			c.Abort()
+			return
+		}
+
+		// Sin registro de auditoría no se habilita el acceso
+		if err := auditarAccesoConsultorio(c, db, scope, solicitado); err != nil {
+			log.Error("Error al auditar acceso entre consultorios", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error interno del servidor"})
+			c.Abort()
+			return
+		}
+
+		tenant.Set(c, scope)
+		c.Next()
+	}
+}
+
+func auditarAccesoConsultorio(c *gin.Context, db TxBeginner, scope tenant.Scope, solicitado string) error {
+	ctx := c.Request.Context()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	entry := audit.FromRequest(c, audit.AccionAccesoConsultorio, "consultorios", solicitado)
+	if !scope.Global {
+		// Queda en la cadena del consultorio accedido
+		entry.ConsultorioID = &scope.ConsultorioID
+	}
+	entry.Despues = gin.H{
+		"metodo": c.Request.Method,
+		"ruta":   c.FullPath(),
+		"path":   c.Request.URL.Path,
+	}
+	if err := audit.Write(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
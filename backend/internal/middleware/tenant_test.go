@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FolkodeGroup/mediapp/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeAuditTx registra los INSERT de auditoría; la cadena siempre está vacía
+type fakeAuditTx struct {
+	pgx.Tx
+	auditorias []string
+	committed  bool
+}
+
+func (f *fakeAuditTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if strings.Contains(sql, "INSERT INTO auditorias") {
+		f.auditorias = append(f.auditorias, args[1].(string)+":"+*args[3].(*string))
+	}
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+func (f *fakeAuditTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return noRow{}
+}
+
+func (f *fakeAuditTx) Commit(ctx context.Context) error {
+	f.committed = true
+	return nil
+}
+
+func (f *fakeAuditTx) Rollback(ctx context.Context) error { return nil }
+
+type noRow struct{}
+
+func (noRow) Scan(dest ...interface{}) error { return pgx.ErrNoRows }
+
+type fakeBeginner struct{ tx *fakeAuditTx }
+
+func (f *fakeBeginner) Begin(ctx context.Context) (pgx.Tx, error) { return f.tx, nil }
+
+func runTenantScope(t *testing.T, checker PermissionChecker, db TxBeginner, consultorio, header string) (*httptest.ResponseRecorder, *tenant.Scope) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	var scope *tenant.Scope
+	router := gin.New()
+	router.GET("/pacientes", func(c *gin.Context) {
+		c.Set("user_id", uuid.New().String())
+		c.Set("role", 1)
+		c.Set("consultorio_id", consultorio)
+		c.Next()
+	}, TenantScope(checker, db), func(c *gin.Context) {
+		s, _ := tenant.FromContext(c)
+		scope = &s
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/pacientes", nil)
+	if header != "" {
+		req.Header.Set(tenant.HeaderConsultorio, header)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w, scope
+}
+
+func TestTenantScope_ConsultorioDelToken(t *testing.T) {
+	propio := uuid.New()
+	w, scope := runTenantScope(t, fakeChecker{}, &fakeBeginner{}, propio.String(), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", w.Code)
+	}
+	if scope.ConsultorioID != propio || scope.Global || scope.CrossTenant {
+		t.Fatalf("Alcance inesperado: %+v", scope)
+	}
+}
+
+func TestTenantScope_TokenSinConsultorio(t *testing.T) {
+	w, _ := runTenantScope(t, fakeChecker{}, &fakeBeginner{}, "", "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Se esperaba status 403, obtuvo %d", w.Code)
+	}
+}
+
+func TestTenantScope_OtroConsultorioSinPermiso(t *testing.T) {
+	tx := &fakeAuditTx{}
+	w, _ := runTenantScope(t, fakeChecker{}, &fakeBeginner{tx: tx}, uuid.New().String(), uuid.New().String())
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Se esperaba status 403, obtuvo %d", w.Code)
+	}
+	if len(tx.auditorias) != 0 {
+		t.Errorf("No se esperaba auditar un acceso denegado, obtuvo %v", tx.auditorias)
+	}
+}
+
+func TestTenantScope_SuperAdminAuditado(t *testing.T) {
+	checker := fakeChecker{permisos: map[int][]string{1: {tenant.PermisoGlobal}}}
+	destino := uuid.New()
+	tx := &fakeAuditTx{}
+
+	w, scope := runTenantScope(t, checker, &fakeBeginner{tx: tx}, uuid.New().String(), destino.String())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", w.Code)
+	}
+	if scope.ConsultorioID != destino || !scope.CrossTenant {
+		t.Fatalf("Se esperaba operar sobre el consultorio %s, obtuvo %+v", destino, scope)
+	}
+	if !tx.committed || len(tx.auditorias) != 1 || tx.auditorias[0] != "acceso_consultorio:"+destino.String() {
+		t.Fatalf("Se esperaba auditar el acceso, obtuvo %v", tx.auditorias)
+	}
+}
+
+func TestTenantScope_SuperAdminGlobal(t *testing.T) {
+	checker := fakeChecker{permisos: map[int][]string{1: {tenant.PermisoGlobal}}}
+	tx := &fakeAuditTx{}
+
+	w, scope := runTenantScope(t, checker, &fakeBeginner{tx: tx}, "", tenant.Todos)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Se esperaba status 200, obtuvo %d", w.Code)
+	}
+	if !scope.Global || len(tx.auditorias) != 1 {
+		t.Fatalf("Se esperaba alcance global auditado, obtuvo %+v %v", scope, tx.auditorias)
+	}
+}
+
+func TestTenantScope_ConsultorioInvalido(t *testing.T) {
+	w, _ := runTenantScope(t, fakeChecker{}, &fakeBeginner{}, uuid.New().String(), "otro")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Se esperaba status 400, obtuvo %d", w.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -71,6 +72,7 @@ type HistoriaClinica struct {
 type HistoriaClinicaVersion struct {
 	ID                uuid.UUID `json:"id" db:"id"`
 	HistoriaClinicaID uuid.UUID `json:"historia_clinica_id" db:"historia_clinica_id"`
+	NumeroVersion     int       `json:"numero_version" db:"numero_version"`
 	MotivoConsulta    *string   `json:"motivo_consulta,omitempty" db:"motivo_consulta"`
 	Antecedentes      *string   `json:"antecedentes,omitempty" db:"antecedentes"`
 	ExamenFisico      *string   `json:"examen_fisico,omitempty" db:"examen_fisico"`
@@ -86,33 +88,115 @@ type DatosPersonales struct {
 	PacienteID         uuid.UUID `json:"paciente_id" db:"paciente_id"`
 	TelefonoEncriptado *[]byte   `json:"telefono_encriptado,omitempty" db:"telefono_encriptado"`
 	DNIEncriptado      *[]byte   `json:"dni_encriptado,omitempty" db:"dni_encriptado"`
+	DNIIndice          *[]byte   `json:"-" db:"dni_indice"`
 	Direccion          *string   `json:"direccion,omitempty" db:"direccion"`
+	ClaveVersion       *int      `json:"clave_version,omitempty" db:"clave_version"`
+	ActualizadoEn      time.Time `json:"actualizado_en" db:"actualizado_en"`
 }
 
 // RecetaMedica representa la tabla 'recetas_medicas'
 type RecetaMedica struct {
-	ID           uuid.UUID `json:"id" db:"id"`
-	PacienteID   uuid.UUID `json:"paciente_id" db:"paciente_id"`
-	UsuarioID    uuid.UUID `json:"usuario_id" db:"usuario_id"`
-	Contenido    *string   `json:"contenido,omitempty" db:"contenido"`
-	FechaEmision time.Time `json:"fecha_emision" db:"fecha_emision"`
-	FirmaDigital bool      `json:"firma_digital" db:"firma_digital"`
+	ID                uuid.UUID  `json:"id" db:"id"`
+	PacienteID        uuid.UUID  `json:"paciente_id" db:"paciente_id"`
+	UsuarioID         uuid.UUID  `json:"usuario_id" db:"usuario_id"`
+	Contenido         *string    `json:"contenido,omitempty" db:"contenido"`
+	FechaEmision      time.Time  `json:"fecha_emision" db:"fecha_emision"`
+	FirmaDigital      bool       `json:"firma_digital" db:"firma_digital"`
+	ContenidoFirmado  []byte     `json:"-" db:"contenido_firmado"`
+	Firma             []byte     `json:"firma,omitempty" db:"firma"`
+	ClaveFirmaID      *uuid.UUID `json:"-" db:"clave_firma_id"`
+	HuellaCertificado *string    `json:"huella_certificado,omitempty" db:"huella_certificado"`
+	Estado            string     `json:"estado" db:"estado"`
+	RevocadaEn        *time.Time `json:"revocada_en,omitempty" db:"revocada_en"`
+	MotivoRevocacion  *string    `json:"motivo_revocacion,omitempty" db:"motivo_revocacion"`
+	ReemplazaID       *uuid.UUID `json:"reemplaza_id,omitempty" db:"reemplaza_id"`
+	// HashDocumento es el SHA-256 de ContenidoFirmado; la verificación pública solo
+	// devuelve el documento completo a quien lo presenta
+	HashDocumento string `json:"hash_documento,omitempty" db:"-"`
+}
+
+// Estados posibles de una receta
+const (
+	RecetaEmitida  = "emitida"
+	RecetaRevocada = "revocada"
+)
+
+// ClaveFirma representa la tabla 'claves_firma'
+type ClaveFirma struct {
+	ID                  uuid.UUID  `json:"id" db:"id"`
+	UsuarioID           uuid.UUID  `json:"usuario_id" db:"usuario_id"`
+	Certificado         []byte     `json:"-" db:"certificado"`
+	Huella              string     `json:"huella" db:"huella"`
+	ClavePrivadaCifrada []byte     `json:"-" db:"clave_privada_cifrada"`
+	CreadoEn            time.Time  `json:"creado_en" db:"creado_en"`
+	RevocadaEn          *time.Time `json:"revocada_en,omitempty" db:"revocada_en"`
 }
 
 // Turno representa la tabla 'turnos'
 type Turno struct {
-	ID         uuid.UUID `json:"id" db:"id"`
-	PacienteID uuid.UUID `json:"paciente_id" db:"paciente_id"`
-	UsuarioID  uuid.UUID `json:"usuario_id" db:"usuario_id"`
-	Fecha      time.Time `json:"fecha" db:"fecha"`
-	Motivo     *string   `json:"motivo,omitempty" db:"motivo"`
+	ID                uuid.UUID  `json:"id" db:"id"`
+	PacienteID        uuid.UUID  `json:"paciente_id" db:"paciente_id"`
+	UsuarioID         uuid.UUID  `json:"usuario_id" db:"usuario_id"`
+	Fecha             time.Time  `json:"fecha" db:"fecha"`
+	DuracionMinutos   int        `json:"duracion_minutos" db:"duracion_minutos"`
+	Motivo            *string    `json:"motivo,omitempty" db:"motivo"`
+	Estado            string     `json:"estado" db:"estado"`
+	MotivoCancelacion *string    `json:"motivo_cancelacion,omitempty" db:"motivo_cancelacion"`
+	CanceladoEn       *time.Time `json:"cancelado_en,omitempty" db:"cancelado_en"`
+	CreadoEn          time.Time  `json:"creado_en" db:"creado_en"`
 }
 
+// Estados posibles de un turno
+const (
+	TurnoProgramado = "programado"
+	TurnoCancelado  = "cancelado"
+)
+
 // Auditoria representa la tabla 'auditorias'
 type Auditoria struct {
-	ID            int       `json:"id" db:"id"`
-	UsuarioID     uuid.UUID `json:"usuario_id" db:"usuario_id"`
-	Accion        string    `json:"accion" db:"accion"`
-	TablaAfectada string    `json:"tabla_afectada" db:"tabla_afectada"`
-	Fecha         time.Time `json:"fecha" db:"fecha"`
+	ID            int             `json:"id" db:"id"`
+	UsuarioID     *uuid.UUID      `json:"usuario_id,omitempty" db:"usuario_id"`
+	Accion        string          `json:"accion" db:"accion"`
+	TablaAfectada string          `json:"tabla_afectada" db:"tabla_afectada"`
+	RegistroID    *string         `json:"registro_id,omitempty" db:"registro_id"`
+	RequestID     *string         `json:"request_id,omitempty" db:"request_id"`
+	IP            *string         `json:"ip,omitempty" db:"ip"`
+	DatosAntes    json.RawMessage `json:"datos_antes,omitempty" db:"datos_antes"`
+	DatosDespues  json.RawMessage `json:"datos_despues,omitempty" db:"datos_despues"`
+	Fecha         time.Time       `json:"fecha" db:"fecha"`
+	ConsultorioID *uuid.UUID      `json:"consultorio_id,omitempty" db:"consultorio_id"`
+	Secuencia     *int64          `json:"secuencia,omitempty" db:"secuencia"`
+	HashAnterior  *string         `json:"hash_anterior,omitempty" db:"hash_anterior"`
+	Hash          *string         `json:"hash,omitempty" db:"hash"`
+	APIKeyID      *uuid.UUID      `json:"api_key_id,omitempty" db:"api_key_id"`
+}
+
+// PacienteProfesional representa la tabla 'paciente_profesional'
+type PacienteProfesional struct {
+	PacienteID  uuid.UUID  `json:"paciente_id" db:"paciente_id"`
+	UsuarioID   uuid.UUID  `json:"usuario_id" db:"usuario_id"`
+	AsignadoPor *uuid.UUID `json:"asignado_por,omitempty" db:"asignado_por"`
+	AsignadoEn  time.Time  `json:"asignado_en" db:"asignado_en"`
+}
+
+// AccesoPaciente representa la tabla 'accesos_pacientes'
+type AccesoPaciente struct {
+	ID                 int64      `json:"id" db:"id"`
+	UsuarioID          uuid.UUID  `json:"usuario_id" db:"usuario_id"`
+	PacienteID         uuid.UUID  `json:"paciente_id" db:"paciente_id"`
+	ConsultorioID      *uuid.UUID `json:"consultorio_id,omitempty" db:"consultorio_id"`
+	Recurso            string     `json:"recurso" db:"recurso"`
+	Ruta               *string    `json:"ruta,omitempty" db:"ruta"`
+	Motivo             *string    `json:"motivo,omitempty" db:"motivo"`
+	IP                 *string    `json:"ip,omitempty" db:"ip"`
+	RequestID          *string    `json:"request_id,omitempty" db:"request_id"`
+	Permitido          bool       `json:"permitido" db:"permitido"`
+	Emergencia         bool       `json:"emergencia" db:"emergencia"`
+	Justificacion      *string    `json:"justificacion,omitempty" db:"justificacion"`
+	RequiereRevision   bool       `json:"requiere_revision" db:"requiere_revision"`
+	RevisadoPor        *uuid.UUID `json:"revisado_por,omitempty" db:"revisado_por"`
+	RevisadoEn         *time.Time `json:"revisado_en,omitempty" db:"revisado_en"`
+	ResultadoRevision  *string    `json:"resultado_revision,omitempty" db:"resultado_revision"`
+	ComentarioRevision *string    `json:"comentario_revision,omitempty" db:"comentario_revision"`
+	Fecha              time.Time  `json:"fecha" db:"fecha"`
 }
@@ -0,0 +1,204 @@
+// Package pagination implementa la paginación por cursor (keyset) de los listados.
+//
+// Convenciones para los endpoints de listado:
+//   - limit: tamaño de página, entre 1 y Config.MaxLimit (por defecto Config.DefaultLimit)
+//   - sort: campo de orden permitido, con "-" adelante para orden descendente
+//   - cursor: valor opaco devuelto como next_cursor en la página anterior
+//
+// La respuesta incluye next_cursor, que es null en la última página. Los filtros
+// deben repetirse en cada página; el cursor solo recuerda el orden y la última fila.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultLimit es el tamaño de página si la configuración no indica otro
+	DefaultLimit = 50
+	// MaxLimit es el tamaño de página máximo si la configuración no indica otro
+	MaxLimit = 200
+)
+
+// Tipos de columna ordenable; definen cómo se serializa el valor en el cursor
+const (
+	TipoTimestamp = "timestamp"
+	TipoDate      = "date"
+	TipoText      = "text"
+)
+
+var (
+	ErrLimitInvalido  = errors.New("limit inválido")
+	ErrOrdenInvalido  = errors.New("campo de orden no permitido")
+	ErrCursorInvalido = errors.New("cursor inválido")
+)
+
+// Orden es un campo por el que se permite ordenar. La columna no debe admitir NULL.
+type Orden struct {
+	Columna string
+	Tipo    string
+}
+
+// Config describe la paginación de un listado
+type Config struct {
+	// Ordenes son los campos de orden permitidos, por nombre público
+	Ordenes map[string]Orden
+	// OrdenDefault es el orden si no se indica, por ejemplo "-creado_en"
+	OrdenDefault string
+	// ColumnaID desempata filas con el mismo valor de orden; debe ser única
+	ColumnaID    string
+	DefaultLimit int
+	MaxLimit     int
+}
+
+// Params es la página pedida
+type Params struct {
+	Limit int
+	Sort  string
+	Desc  bool
+
+	orden  Orden
+	idCol  string
+	cursor *cursor
+}
+
+type cursor struct {
+	Sort  string `json:"s"`
+	Valor string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// Parse lee limit, sort y cursor de la query
+func Parse(c *gin.Context, cfg Config) (Params, error) {
+	defaultLimit, maxLimit := cfg.DefaultLimit, cfg.MaxLimit
+	if defaultLimit == 0 {
+		defaultLimit = DefaultLimit
+	}
+	if maxLimit == 0 {
+		maxLimit = MaxLimit
+	}
+
+	p := Params{Limit: defaultLimit, Sort: c.DefaultQuery("sort", cfg.OrdenDefault), idCol: cfg.ColumnaID}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxLimit {
+			return Params{}, fmt.Errorf("%w: debe estar entre 1 y %d", ErrLimitInvalido, maxLimit)
+		}
+		p.Limit = n
+	}
+
+	campo := strings.TrimPrefix(p.Sort, "-")
+	orden, ok := cfg.Ordenes[campo]
+	if !ok {
+		return Params{}, fmt.Errorf("%w: %q", ErrOrdenInvalido, campo)
+	}
+	p.orden = orden
+	p.Desc = strings.HasPrefix(p.Sort, "-")
+
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := decodeCursor(raw, orden.Tipo)
+		if err != nil {
+			return Params{}, err
+		}
+		if cur.Sort != p.Sort {
+			return Params{}, fmt.Errorf("%w: fue generado para otro orden", ErrCursorInvalido)
+		}
+		p.cursor = cur
+	}
+	return p, nil
+}
+
+// Campo devuelve el nombre del campo de orden, sin el signo
+func (p Params) Campo() string {
+	return strings.TrimPrefix(p.Sort, "-")
+}
+
+// Condicion devuelve la condición keyset que salta las filas ya devueltas y
+// agrega sus argumentos a args. Sin cursor devuelve "TRUE".
+func (p Params) Condicion(args *[]interface{}) string {
+	if p.cursor == nil {
+		return "TRUE"
+	}
+	op := ">"
+	if p.Desc {
+		op = "<"
+	}
+	*args = append(*args, p.cursor.Valor, p.cursor.ID)
+	n := len(*args)
+	return fmt.Sprintf("(%s, %s) %s ($%d::%s, $%d::uuid)", p.orden.Columna, p.idCol, op, n-1, p.orden.Tipo, n)
+}
+
+// OrderBy devuelve la cláusula ORDER BY (sin la palabra clave)
+func (p Params) OrderBy() string {
+	dir := "ASC"
+	if p.Desc {
+		dir = "DESC"
+	}
+	return p.orden.Columna + " " + dir + ", " + p.idCol + " " + dir
+}
+
+// Fetch es la cantidad de filas a pedir: una más que la página para saber si hay otra
+func (p Params) Fetch() int {
+	return p.Limit + 1
+}
+
+// Pagina recorta las filas obtenidas con LIMIT Fetch() y devuelve el cursor de la
+// página siguiente (nil si no hay más). clave devuelve el valor de orden y el ID de una fila.
+func Pagina[T any](p Params, filas []T, clave func(T) (interface{}, string)) ([]T, *string) {
+	if len(filas) <= p.Limit {
+		return filas, nil
+	}
+	filas = filas[:p.Limit]
+	valor, id := clave(filas[len(filas)-1])
+	next := encodeCursor(cursor{Sort: p.Sort, Valor: formatear(valor, p.orden.Tipo), ID: id})
+	return filas, &next
+}
+
+func formatear(v interface{}, tipo string) string {
+	switch t := v.(type) {
+	case time.Time:
+		if tipo == TipoDate {
+			return t.Format("2006-01-02")
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func encodeCursor(cur cursor) string {
+	raw, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s, tipo string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrCursorInvalido
+	}
+	var cur cursor
+	if err := json.Unmarshal(raw, &cur); err != nil || cur.ID == "" {
+		return nil, ErrCursorInvalido
+	}
+	// Validar acá evita que un cursor manipulado termine en un error de SQL
+	var errValor error
+	switch tipo {
+	case TipoTimestamp:
+		_, errValor = time.Parse(time.RFC3339Nano, cur.Valor)
+	case TipoDate:
+		_, errValor = time.Parse("2006-01-02", cur.Valor)
+	}
+	if _, err := uuid.Parse(cur.ID); err != nil || errValor != nil {
+		return nil, ErrCursorInvalido
+	}
+	return &cur, nil
+}
@@ -0,0 +1,123 @@
+package pagination
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var testConfig = Config{
+	Ordenes: map[string]Orden{
+		"creado_en": {Columna: "creado_en", Tipo: TipoTimestamp},
+		"apellido":  {Columna: "apellido", Tipo: TipoText},
+	},
+	OrdenDefault: "-creado_en",
+	ColumnaID:    "id",
+	MaxLimit:     100,
+}
+
+func parseQuery(t *testing.T, query string) (Params, error) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/pacientes?"+query, nil)
+	return Parse(c, testConfig)
+}
+
+func TestParseDefaults(t *testing.T) {
+	p, err := parseQuery(t, "")
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	if p.Limit != DefaultLimit || p.Sort != "-creado_en" || !p.Desc {
+		t.Fatalf("Valores por defecto inesperados: %+v", p)
+	}
+	if got := p.OrderBy(); got != "creado_en DESC, id DESC" {
+		t.Errorf("ORDER BY inesperado: %s", got)
+	}
+	var args []interface{}
+	if cond := p.Condicion(&args); cond != "TRUE" || len(args) != 0 {
+		t.Errorf("Sin cursor no se esperaba condición, obtuvo %q", cond)
+	}
+}
+
+func TestParseRechazaParametrosInvalidos(t *testing.T) {
+	casos := map[string]error{
+		"limit=0":             ErrLimitInvalido,
+		"limit=101":           ErrLimitInvalido,
+		"limit=abc":           ErrLimitInvalido,
+		"sort=contrasena":     ErrOrdenInvalido,
+		"cursor=no-es-base64": ErrCursorInvalido,
+	}
+	for query, esperado := range casos {
+		if _, err := parseQuery(t, query); !errors.Is(err, esperado) {
+			t.Errorf("%s: se esperaba %v, obtuvo %v", query, esperado, err)
+		}
+	}
+}
+
+func TestCursorRecorreLasPaginas(t *testing.T) {
+	p, _ := parseQuery(t, "limit=2&sort=apellido")
+	filas := []string{"Alvarez", "Benitez", "Castro"}
+	ids := map[string]string{}
+	for _, f := range filas {
+		ids[f] = uuid.NewString()
+	}
+
+	pagina, next := Pagina(p, filas, func(f string) (interface{}, string) { return f, ids[f] })
+	if len(pagina) != 2 || next == nil {
+		t.Fatalf("Se esperaba una página de 2 con cursor, obtuvo %v %v", pagina, next)
+	}
+
+	p2, err := parseQuery(t, "limit=2&sort=apellido&cursor="+*next)
+	if err != nil {
+		t.Fatalf("El cursor generado no es válido: %v", err)
+	}
+	var args []interface{}
+	cond := p2.Condicion(&args)
+	if cond != "(apellido, id) > ($1::text, $2::uuid)" {
+		t.Errorf("Condición inesperada: %s", cond)
+	}
+	if args[0] != "Benitez" || args[1] != ids["Benitez"] {
+		t.Errorf("Argumentos inesperados: %v", args)
+	}
+
+	if _, next := Pagina(p2, filas[2:], func(f string) (interface{}, string) { return f, ids[f] }); next != nil {
+		t.Error("No se esperaba cursor en la última página")
+	}
+}
+
+func TestCursorDeOtroOrden(t *testing.T) {
+	p, _ := parseQuery(t, "limit=1")
+	_, next := Pagina(p, []int{1, 2}, func(int) (interface{}, string) {
+		return time.Date(2026, 1, 2, 3, 4, 5, 123456000, time.UTC), uuid.NewString()
+	})
+	if next == nil {
+		t.Fatal("Se esperaba cursor")
+	}
+	if _, err := parseQuery(t, "sort=apellido&cursor="+*next); !errors.Is(err, ErrCursorInvalido) {
+		t.Fatalf("Se esperaba rechazar un cursor de otro orden, obtuvo %v", err)
+	}
+
+	p2, err := parseQuery(t, "cursor="+*next)
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	var args []interface{}
+	if cond := p2.Condicion(&args); !strings.Contains(cond, "<") || args[0] != "2026-01-02T03:04:05.123456Z" {
+		t.Errorf("Se esperaba conservar los microsegundos en orden descendente, obtuvo %s %v", cond, args)
+	}
+}
+
+func TestCursorManipulado(t *testing.T) {
+	// {"s":"-creado_en","v":"'; DROP TABLE pacientes","id":"x"}
+	cursor := "eyJzIjoiLWNyZWFkb19lbiIsInYiOiInOyBEUk9QIFRBQkxFIHBhY2llbnRlcyIsImlkIjoieCJ9"
+	if _, err := parseQuery(t, "cursor="+cursor); !errors.Is(err, ErrCursorInvalido) {
+		t.Fatalf("Se esperaba rechazar el cursor, obtuvo %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package security
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Formato del texto cifrado por Envelope:
+//
+//	[1 byte formato][4 bytes versión de la clave maestra][2 bytes largo de la DEK envuelta]
+//	[DEK envuelta][nonce][datos cifrados + tag]
+//
+// Cada valor se cifra con una clave de datos (DEK) AES-256-GCM propia, que a su vez se
+// guarda envuelta por la clave maestra (KEK). La versión de la KEK viaja en el texto
+// cifrado para poder descifrar con claves anteriores después de una rotación.
+const (
+	formatoEnvelopeV1  = 0x01
+	cabeceraEnvelopeV1 = 1 + 4 + 2
+	dekSize            = 32
+)
+
+// ErrCiphertextInvalido indica que el texto cifrado está truncado o no tiene un formato conocido
+var ErrCiphertextInvalido = errors.New("texto cifrado inválido")
+
+// KeyWrapper envuelve y desenvuelve claves de datos con una clave maestra versionada.
+// Puede ser una clave local (LocalKEK) o un servicio externo (VaultTransit).
+type KeyWrapper interface {
+	// Wrap envuelve la DEK con la versión vigente de la clave maestra y devuelve esa versión
+	Wrap(ctx context.Context, dek []byte) (wrapped []byte, version uint32, err error)
+	// Unwrap recupera una DEK envuelta con la versión indicada
+	Unwrap(ctx context.Context, version uint32, wrapped []byte) ([]byte, error)
+	// CurrentVersion devuelve la versión con la que Wrap envuelve las claves nuevas
+	CurrentVersion(ctx context.Context) (uint32, error)
+}
+
+// Envelope cifra valores con envelope encryption
+type Envelope struct {
+	kek KeyWrapper
+}
+
+// NewEnvelope crea un Envelope que envuelve las claves de datos con kek
+func NewEnvelope(kek KeyWrapper) *Envelope {
+	return &Envelope{kek: kek}
+}
+
+// Encrypt cifra plaintext. aad vincula el texto cifrado a su contexto (por ejemplo tabla,
+// fila y columna) para que no pueda copiarse a otro lugar y descifrarse.
+func (e *Envelope) Encrypt(ctx context.Context, plaintext, aad []byte) ([]byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	wrapped, version, err := e.kek.Wrap(ctx, dek)
+	if err != nil {
+		return nil, fmt.Errorf("error envolviendo la clave de datos: %w", err)
+	}
+	if len(wrapped) > 0xFFFF {
+		return nil, fmt.Errorf("clave de datos envuelta demasiado larga (%d bytes)", len(wrapped))
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, cabeceraEnvelopeV1, cabeceraEnvelopeV1+len(wrapped)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out[0] = formatoEnvelopeV1
+	binary.BigEndian.PutUint32(out[1:5], version)
+	binary.BigEndian.PutUint16(out[5:7], uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	out = append(out, nonce...)
+	// La cabecera también se autentica: cambiar la versión o la DEK invalida el valor
+	return gcm.Seal(out, nonce, plaintext, append(out[:len(out):len(out)], aad...)), nil
+}
+
+// Decrypt descifra un valor cifrado con Encrypt usando el mismo aad
+func (e *Envelope) Decrypt(ctx context.Context, ciphertext, aad []byte) ([]byte, error) {
+	version, wrapped, cuerpo, err := partesEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := e.kek.Unwrap(ctx, version, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("error desenvolviendo la clave de datos (versión %d): %w", version, err)
+	}
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	if len(cuerpo) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrCiphertextInvalido
+	}
+	cabecera := ciphertext[:len(ciphertext)-len(cuerpo)+gcm.NonceSize()]
+	nonce, datos := cuerpo[:gcm.NonceSize()], cuerpo[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, datos, append(cabecera[:len(cabecera):len(cabecera)], aad...))
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo descifrar el valor: %w", err)
+	}
+	return plaintext, nil
+}
+
+// KeyVersion devuelve la versión de la clave maestra con la que se cifró el valor
+func KeyVersion(ciphertext []byte) (uint32, error) {
+	version, _, _, err := partesEnvelope(ciphertext)
+	return version, err
+}
+
+func partesEnvelope(ciphertext []byte) (version uint32, wrapped, cuerpo []byte, err error) {
+	if len(ciphertext) < cabeceraEnvelopeV1 || ciphertext[0] != formatoEnvelopeV1 {
+		return 0, nil, nil, ErrCiphertextInvalido
+	}
+	version = binary.BigEndian.Uint32(ciphertext[1:5])
+	largo := int(binary.BigEndian.Uint16(ciphertext[5:7]))
+	if len(ciphertext) < cabeceraEnvelopeV1+largo {
+		return 0, nil, nil, ErrCiphertextInvalido
+	}
+	wrapped = ciphertext[cabeceraEnvelopeV1 : cabeceraEnvelopeV1+largo]
+	return version, wrapped, ciphertext[cabeceraEnvelopeV1+largo:], nil
+}
+
+// LocalKEK es una clave maestra AES-256 local (configuración o variable de entorno)
+type LocalKEK struct {
+	version uint32
+	key     []byte
+}
+
+// NewLocalKEK crea una clave maestra local de 32 bytes con la versión indicada
+func NewLocalKEK(version uint32, key []byte) (*LocalKEK, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("la clave maestra debe tener 32 bytes, tiene %d", len(key))
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("la versión de la clave maestra debe ser mayor a 0")
+	}
+	return &LocalKEK{version: version, key: key}, nil
+}
+
+// Wrap implementa KeyWrapper
+func (k *LocalKEK) Wrap(ctx context.Context, dek []byte) ([]byte, uint32, error) {
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return nil, 0, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, 0, err
+	}
+	return gcm.Seal(nonce, nonce, dek, versionAAD(k.version)), k.version, nil
+}
+
+// CurrentVersion implementa KeyWrapper
+func (k *LocalKEK) CurrentVersion(ctx context.Context) (uint32, error) {
+	return k.version, nil
+}
+
+// Unwrap implementa KeyWrapper
+func (k *LocalKEK) Unwrap(ctx context.Context, version uint32, wrapped []byte) ([]byte, error) {
+	if version != k.version {
+		return nil, fmt.Errorf("versión de clave maestra %d no disponible", version)
+	}
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, ErrCiphertextInvalido
+	}
+	return gcm.Open(nil, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], versionAAD(version))
+}
+
+func versionAAD(version uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, version)
+	return b
+}
+
+// BlindIndex calcula un HMAC-SHA256 de value con key. Permite buscar por igualdad exacta
+// sobre un campo cifrado sin descifrarlo; value debe normalizarse antes de llamarla.
+// key tiene que ser distinta de la clave maestra.
+func BlindIndex(key []byte, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
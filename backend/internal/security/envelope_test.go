@@ -0,0 +1,178 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func nuevaKEK(t *testing.T, version uint32) *LocalKEK {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	kek, err := NewLocalKEK(version, key)
+	if err != nil {
+		t.Fatalf("Error al crear la clave maestra: %v", err)
+	}
+	return kek
+}
+
+func TestEnvelope_CifraYDescifra(t *testing.T) {
+	ctx := context.Background()
+	env := NewEnvelope(nuevaKEK(t, 3))
+	aad := []byte("datos_personales:1:dni")
+
+	cifrado, err := env.Encrypt(ctx, []byte("20123456"), aad)
+	if err != nil {
+		t.Fatalf("Error al cifrar: %v", err)
+	}
+	if bytes.Contains(cifrado, []byte("20123456")) {
+		t.Fatal("El texto cifrado contiene el valor en claro")
+	}
+	if v, err := KeyVersion(cifrado); err != nil || v != 3 {
+		t.Errorf("Se esperaba la versión 3 en el texto cifrado, obtuvo %d %v", v, err)
+	}
+
+	plano, err := env.Decrypt(ctx, cifrado, aad)
+	if err != nil || string(plano) != "20123456" {
+		t.Fatalf("Se esperaba descifrar el valor, obtuvo %q %v", plano, err)
+	}
+
+	// Otro contexto (por ejemplo copiar el DNI de otra fila) no descifra
+	if _, err := env.Decrypt(ctx, cifrado, []byte("datos_personales:2:dni")); err == nil {
+		t.Error("Se esperaba error con otro aad")
+	}
+
+	// Alterar cualquier byte invalida el valor
+	alterado := append([]byte(nil), cifrado...)
+	alterado[len(alterado)-1] ^= 1
+	if _, err := env.Decrypt(ctx, alterado, aad); err == nil {
+		t.Error("Se esperaba error con el texto cifrado alterado")
+	}
+
+	if _, err := env.Decrypt(ctx, cifrado[:5], aad); !errors.Is(err, ErrCiphertextInvalido) {
+		t.Errorf("Se esperaba ErrCiphertextInvalido para un valor truncado, obtuvo %v", err)
+	}
+
+	// Con otra clave maestra no se puede desenvolver la DEK
+	if _, err := NewEnvelope(nuevaKEK(t, 3)).Decrypt(ctx, cifrado, aad); err == nil {
+		t.Error("Se esperaba error con otra clave maestra")
+	}
+}
+
+func TestLocalKEK_Validaciones(t *testing.T) {
+	if _, err := NewLocalKEK(1, make([]byte, 16)); err == nil {
+		t.Error("Se esperaba error con una clave de 16 bytes")
+	}
+	if _, err := NewLocalKEK(0, make([]byte, 32)); err == nil {
+		t.Error("Se esperaba error con la versión 0")
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	a := BlindIndex([]byte("clave"), "20123456")
+	if !bytes.Equal(a, BlindIndex([]byte("clave"), "20123456")) {
+		t.Error("Se esperaba un índice determinístico")
+	}
+	if bytes.Equal(a, BlindIndex([]byte("otra"), "20123456")) || bytes.Equal(a, BlindIndex([]byte("clave"), "20123457")) {
+		t.Error("Se esperaban índices distintos para otra clave u otro valor")
+	}
+}
+
+// fakeTransit simula el motor transit: "cifra" con base64 y prefijo de versión
+type fakeTransit struct{ version string }
+
+func (f *fakeTransit) ReadWithContext(ctx context.Context, path string) (*api.Secret, error) {
+	if !strings.HasSuffix(path, "/keys/pacientes") {
+		return nil, errors.New("ruta inesperada " + path)
+	}
+	return &api.Secret{Data: map[string]interface{}{"latest_version": json.Number(strings.TrimPrefix(f.version, "v"))}}, nil
+}
+
+func (f *fakeTransit) WriteWithContext(ctx context.Context, path string, data map[string]interface{}) (*api.Secret, error) {
+	switch {
+	case strings.HasSuffix(path, "/encrypt/pacientes"):
+		return &api.Secret{Data: map[string]interface{}{"ciphertext": "vault:" + f.version + ":" + data["plaintext"].(string)}}, nil
+	case strings.HasSuffix(path, "/decrypt/pacientes"):
+		partes := strings.SplitN(data["ciphertext"].(string), ":", 3)
+		return &api.Secret{Data: map[string]interface{}{"plaintext": partes[2]}}, nil
+	}
+	return nil, errors.New("ruta inesperada " + path)
+}
+
+func TestVaultTransit(t *testing.T) {
+	ctx := context.Background()
+	transit := NewVaultTransit(&fakeTransit{version: "v2"}, "", "pacientes")
+	env := NewEnvelope(transit)
+
+	cifrado, err := env.Encrypt(ctx, []byte("1155551234"), nil)
+	if err != nil {
+		t.Fatalf("Error al cifrar: %v", err)
+	}
+	if v, _ := KeyVersion(cifrado); v != 2 {
+		t.Errorf("Se esperaba la versión de transit (2), obtuvo %d", v)
+	}
+	if plano, err := env.Decrypt(ctx, cifrado, nil); err != nil || string(plano) != "1155551234" {
+		t.Errorf("Se esperaba descifrar con transit, obtuvo %q %v", plano, err)
+	}
+	if v, err := transit.CurrentVersion(ctx); err != nil || v != 2 {
+		t.Errorf("Se esperaba la última versión de la clave de transit (2), obtuvo %d %v", v, err)
+	}
+
+	if _, err := transit.Unwrap(ctx, 1, []byte("vault:v2:"+base64.StdEncoding.EncodeToString([]byte("x")))); err == nil {
+		t.Error("Se esperaba error si la versión no coincide con la de la clave envuelta")
+	}
+}
+
+func TestKeyRing_CifraConLaVigenteYDescifraAnteriores(t *testing.T) {
+	ctx := context.Background()
+	v1, v2 := nuevaKEK(t, 1), nuevaKEK(t, 2)
+	indexKey := make([]byte, MinIndexKeySize)
+
+	antes, _ := NewFieldCipher(v1, indexKey)
+	cifrado, err := antes.Encrypt(ctx, "datos_personales", "1", "dni", []byte("20123456"))
+	if err != nil {
+		t.Fatalf("Error al cifrar: %v", err)
+	}
+
+	anillo, err := NewKeyRing(v2, v1)
+	if err != nil {
+		t.Fatalf("Error al crear el anillo: %v", err)
+	}
+	despues, _ := NewFieldCipher(anillo, indexKey)
+	if v, _ := despues.CurrentVersion(ctx); v != 2 {
+		t.Errorf("Se esperaba la versión vigente 2, obtuvo %d", v)
+	}
+	if plano, err := despues.Decrypt(ctx, "datos_personales", "1", "dni", cifrado); err != nil || string(plano) != "20123456" {
+		t.Fatalf("Se esperaba descifrar con la versión anterior, obtuvo %q %v", plano, err)
+	}
+
+	recifrado, err := despues.Reencrypt(ctx, "datos_personales", "1", "dni", cifrado)
+	if err != nil {
+		t.Fatalf("Error al recifrar: %v", err)
+	}
+	if v, _ := KeyVersion(recifrado); v != 2 {
+		t.Errorf("Se esperaba recifrar con la versión 2, obtuvo %d", v)
+	}
+
+	// Sin la versión 1 en el anillo el valor anterior ya no se puede leer, el recifrado sí
+	soloV2, _ := NewKeyRing(v2)
+	sinAnterior, _ := NewFieldCipher(soloV2, indexKey)
+	if _, err := sinAnterior.Decrypt(ctx, "datos_personales", "1", "dni", cifrado); err == nil {
+		t.Error("Se esperaba error al descifrar una versión que no está en el anillo")
+	}
+	if plano, err := sinAnterior.Decrypt(ctx, "datos_personales", "1", "dni", recifrado); err != nil || string(plano) != "20123456" {
+		t.Errorf("Se esperaba descifrar el valor recifrado, obtuvo %q %v", plano, err)
+	}
+
+	if _, err := NewKeyRing(v2, nuevaKEK(t, 2)); err == nil {
+		t.Error("Se esperaba error con una versión repetida")
+	}
+}
@@ -0,0 +1,59 @@
+package security
+
+import (
+	"context"
+	"fmt"
+)
+
+// MinIndexKeySize es el largo mínimo de la clave de los índices ciegos
+const MinIndexKeySize = 32
+
+// FieldCipher cifra columnas sensibles con Envelope y calcula sus índices ciegos.
+// Cada valor queda vinculado a su tabla, fila y columna mediante el aad.
+type FieldCipher struct {
+	envelope *Envelope
+	indexKey []byte
+}
+
+// NewFieldCipher crea el cifrador de columnas. indexKey es la clave HMAC de los índices
+// ciegos y no debe ser la clave maestra.
+func NewFieldCipher(kek KeyWrapper, indexKey []byte) (*FieldCipher, error) {
+	if len(indexKey) < MinIndexKeySize {
+		return nil, fmt.Errorf("la clave de índices ciegos debe tener al menos %d bytes, tiene %d", MinIndexKeySize, len(indexKey))
+	}
+	return &FieldCipher{envelope: NewEnvelope(kek), indexKey: indexKey}, nil
+}
+
+// Encrypt cifra el valor de la columna campo de la fila id de tabla
+func (f *FieldCipher) Encrypt(ctx context.Context, tabla, id, campo string, plaintext []byte) ([]byte, error) {
+	return f.envelope.Encrypt(ctx, plaintext, fieldAAD(tabla, id, campo))
+}
+
+// Decrypt descifra un valor cifrado con Encrypt para la misma tabla, fila y columna
+func (f *FieldCipher) Decrypt(ctx context.Context, tabla, id, campo string, ciphertext []byte) ([]byte, error) {
+	return f.envelope.Decrypt(ctx, ciphertext, fieldAAD(tabla, id, campo))
+}
+
+// Reencrypt descifra un valor y lo vuelve a cifrar con la versión vigente de la clave maestra
+func (f *FieldCipher) Reencrypt(ctx context.Context, tabla, id, campo string, ciphertext []byte) ([]byte, error) {
+	plaintext, err := f.Decrypt(ctx, tabla, id, campo, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return f.Encrypt(ctx, tabla, id, campo, plaintext)
+}
+
+// CurrentVersion devuelve la versión de la clave maestra con la que se cifran los valores nuevos
+func (f *FieldCipher) CurrentVersion(ctx context.Context) (uint32, error) {
+	return f.envelope.kek.CurrentVersion(ctx)
+}
+
+// Index devuelve el índice ciego de valor para la columna campo. El nombre de la columna
+// entra en el HMAC para que valores iguales en columnas distintas no coincidan.
+func (f *FieldCipher) Index(campo, valor string) []byte {
+	return BlindIndex(f.indexKey, campo+":"+valor)
+}
+
+func fieldAAD(tabla, id, campo string) []byte {
+	return []byte(tabla + ":" + id + ":" + campo)
+}
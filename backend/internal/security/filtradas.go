@@ -0,0 +1,117 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//go:embed filtradas.txt
+var filtradasIncluidas string
+
+// ListaFiltradas es una lista local de contraseñas filtradas, guardadas como SHA-1 e
+// indexadas como la API de rangos de Have I Been Pwned: por los primeros 5 caracteres del
+// hash (k-anonimato). Se consulta sin salir a la red.
+//
+// Hay dos formatos:
+//   - un archivo con un hash completo por línea, opcionalmente seguido de ":apariciones"
+//     (el volcado de Have I Been Pwned); se carga entero en memoria
+//   - un directorio con un archivo por prefijo (00000, 00001, ... o con extensión .txt) y
+//     en cada uno los sufijos de 35 caracteres, "SUFIJO:apariciones", tal como los devuelve
+//     la API de rangos; en cada consulta se lee solo el archivo del prefijo
+type ListaFiltradas struct {
+	rangos map[string]map[string]struct{}
+	dir    string
+}
+
+// ListaFiltradasIncluida devuelve la lista de contraseñas comunes incluida en el binario
+var ListaFiltradasIncluida = sync.OnceValue(func() *ListaFiltradas {
+	l, err := CargarListaFiltradas(strings.NewReader(filtradasIncluidas))
+	if err != nil {
+		panic(err)
+	}
+	return l
+})
+
+// CargarListaFiltradas lee una lista de hashes completos, uno por línea. Las líneas vacías
+// y las que empiezan con # se ignoran.
+func CargarListaFiltradas(r io.Reader) (*ListaFiltradas, error) {
+	l := &ListaFiltradas{rangos: map[string]map[string]struct{}{}}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		linea := strings.TrimSpace(sc.Text())
+		if linea == "" || strings.HasPrefix(linea, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(linea, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != 40 || !esHex(hash) {
+			return nil, fmt.Errorf("línea %d: se esperaba un SHA-1 en hexadecimal", n)
+		}
+		prefijo, sufijo := hash[:5], hash[5:]
+		if l.rangos[prefijo] == nil {
+			l.rangos[prefijo] = map[string]struct{}{}
+		}
+		l.rangos[prefijo][sufijo] = struct{}{}
+	}
+	return l, sc.Err()
+}
+
+// AbrirListaFiltradas abre la lista de ruta: un archivo de hashes completos o un directorio
+// de archivos por prefijo
+func AbrirListaFiltradas(ruta string) (*ListaFiltradas, error) {
+	info, err := os.Stat(ruta)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &ListaFiltradas{dir: ruta}, nil
+	}
+	f, err := os.Open(ruta)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return CargarListaFiltradas(f)
+}
+
+// Contiene indica si password está en la lista. Un archivo de prefijo que no existe o no se
+// puede leer cuenta como que no está: la lista nunca impide elegir una contraseña por un
+// error propio.
+func (l *ListaFiltradas) Contiene(password string) bool {
+	suma := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(suma[:]))
+	prefijo, sufijo := hash[:5], hash[5:]
+	if l.dir == "" {
+		_, ok := l.rangos[prefijo][sufijo]
+		return ok
+	}
+	for _, nombre := range []string{prefijo, prefijo + ".txt"} {
+		f, err := os.Open(filepath.Join(l.dir, nombre))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			s, _, _ := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+			if strings.EqualFold(s, sufijo) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func esHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,49 @@
+package security
+
+import (
+	"context"
+	"fmt"
+)
+
+// KeyRing agrupa varias versiones de la clave maestra local: envuelve con la vigente y
+// desenvuelve con cualquiera de las cargadas. Permite rotar la clave sin dejar de leer
+// los valores cifrados antes de la rotación.
+type KeyRing struct {
+	actual *LocalKEK
+	claves map[uint32]*LocalKEK
+}
+
+// NewKeyRing crea un anillo cuya versión vigente es actual; anteriores son las versiones
+// que solo se usan para descifrar
+func NewKeyRing(actual *LocalKEK, anteriores ...*LocalKEK) (*KeyRing, error) {
+	if actual == nil {
+		return nil, fmt.Errorf("falta la clave maestra vigente")
+	}
+	r := &KeyRing{actual: actual, claves: map[uint32]*LocalKEK{actual.version: actual}}
+	for _, k := range anteriores {
+		if _, repetida := r.claves[k.version]; repetida {
+			return nil, fmt.Errorf("versión de clave maestra %d repetida", k.version)
+		}
+		r.claves[k.version] = k
+	}
+	return r, nil
+}
+
+// Wrap implementa KeyWrapper
+func (r *KeyRing) Wrap(ctx context.Context, dek []byte) ([]byte, uint32, error) {
+	return r.actual.Wrap(ctx, dek)
+}
+
+// Unwrap implementa KeyWrapper
+func (r *KeyRing) Unwrap(ctx context.Context, version uint32, wrapped []byte) ([]byte, error) {
+	k, ok := r.claves[version]
+	if !ok {
+		return nil, fmt.Errorf("versión de clave maestra %d no disponible", version)
+	}
+	return k.Unwrap(ctx, version, wrapped)
+}
+
+// CurrentVersion implementa KeyWrapper
+func (r *KeyRing) CurrentVersion(ctx context.Context) (uint32, error) {
+	return r.actual.version, nil
+}
@@ -1,25 +1,192 @@
 package security
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 
-// HashPassword hashea una contraseña usando bcrypt con el costo por defecto
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algoritmos de hash de contraseñas
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+// ConfigHash elige el algoritmo y el costo con que se hashean las contraseñas nuevas. Los
+// hashes existentes se siguen verificando con el algoritmo y los parámetros con que se
+// crearon; NecesitaRehash indica cuáles conviene recalcular.
+type ConfigHash struct {
+	Algoritmo  string
+	BcryptCost int
+	// Parámetros de argon2id: memoria en KiB, iteraciones y paralelismo
+	Argon2Memoria     uint32
+	Argon2Iteraciones uint32
+	Argon2Paralelismo uint8
+}
+
+// ConfigHashPorDefecto usa argon2id con los parámetros mínimos que recomienda OWASP
+func ConfigHashPorDefecto() ConfigHash {
+	return ConfigHash{
+		Algoritmo:         HashArgon2id,
+		BcryptCost:        12,
+		Argon2Memoria:     19 * 1024,
+		Argon2Iteraciones: 2,
+		Argon2Paralelismo: 1,
+	}
+}
+
+// Validar verifica que la configuración sea utilizable
+func (c ConfigHash) Validar() error {
+	switch c.Algoritmo {
+	case HashBcrypt:
+		if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("costo de bcrypt fuera de rango: %d", c.BcryptCost)
+		}
+	case HashArgon2id:
+		if c.Argon2Memoria < 8*uint32(c.Argon2Paralelismo) || c.Argon2Iteraciones == 0 || c.Argon2Paralelismo == 0 {
+			return fmt.Errorf("parámetros de argon2id inválidos: m=%d t=%d p=%d", c.Argon2Memoria, c.Argon2Iteraciones, c.Argon2Paralelismo)
+		}
+	default:
+		return fmt.Errorf("algoritmo de hash desconocido: %q", c.Algoritmo)
+	}
+	return nil
+}
+
+var (
+	configHashMu sync.RWMutex
+	configHash   = ConfigHashPorDefecto()
+)
+
+// ConfigurarHash cambia el algoritmo de las contraseñas nuevas. Se llama al iniciar.
+func ConfigurarHash(c ConfigHash) error {
+	if err := c.Validar(); err != nil {
+		return err
+	}
+	configHashMu.Lock()
+	defer configHashMu.Unlock()
+	configHash = c
+	return nil
+}
+
+func configActual() ConfigHash {
+	configHashMu.RLock()
+	defer configHashMu.RUnlock()
+	return configHash
+}
+
+// ErrContrasenaLarga indica una contraseña de más de 72 bytes con bcrypt, que no las admite
+var ErrContrasenaLarga = errors.New("la contraseña supera los 72 bytes que admite bcrypt")
+
+// LongitudMaximaBytes devuelve el máximo de bytes que admite el algoritmo configurado, o 0
+// si no tiene límite
+func LongitudMaximaBytes() int {
+	if configActual().Algoritmo == HashBcrypt {
+		return 72
+	}
+	return 0
+}
+
+// HashPassword hashea una contraseña con el algoritmo configurado (argon2id por defecto)
 func HashPassword(password string) (string, error) {
-    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-    if err != nil {
-        return "", err
-    }
-    return string(hashedPassword), nil
+	c := configActual()
+	if c.Algoritmo == HashBcrypt {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), c.BcryptCost)
+		if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+			return "", ErrContrasenaLarga
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(hashedPassword), nil
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	clave := argon2.IDKey([]byte(password), salt, c.Argon2Iteraciones, c.Argon2Memoria, c.Argon2Paralelismo, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		c.Argon2Memoria, c.Argon2Iteraciones, c.Argon2Paralelismo,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(clave)), nil
 }
 
-// CheckPasswordHash verifica si una contraseña coincide con su hash
-// Retorna true si coinciden, false en caso contrario
+// CheckPasswordHash verifica si una contraseña coincide con su hash, sea bcrypt o argon2id.
+// Retorna true si coinciden, false en caso contrario (también si el hash está vacío o no se
+// reconoce).
 func CheckPasswordHash(password, hash string) bool {
-    err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-    return err == nil
+	if strings.HasPrefix(hash, "$argon2id$") {
+		a, err := parseArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		clave := argon2.IDKey([]byte(password), a.salt, a.iteraciones, a.memoria, a.paralelismo, uint32(len(a.clave)))
+		return subtle.ConstantTimeCompare(clave, a.clave) == 1
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
 }
 
 // CheckPassword mantiene la interfaz anterior para compatibilidad
 // Retorna nil si coinciden, error si no
 func CheckPassword(hash, password string) error {
-    return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-}
\ No newline at end of file
+	if !CheckPasswordHash(password, hash) {
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+	return nil
+}
+
+// NecesitaRehash indica si hash se creó con otro algoritmo o con otros parámetros que los
+// configurados. Al iniciar sesión, con la contraseña en mano, se recalcula. Un hash vacío
+// o irreconocible no se recalcula.
+func NecesitaRehash(hash string) bool {
+	c := configActual()
+	if strings.HasPrefix(hash, "$argon2id$") {
+		a, err := parseArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		return c.Algoritmo != HashArgon2id || a.memoria != c.Argon2Memoria ||
+			a.iteraciones != c.Argon2Iteraciones || a.paralelismo != c.Argon2Paralelismo
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false
+	}
+	return c.Algoritmo != HashBcrypt || cost != c.BcryptCost
+}
+
+type hashArgon2id struct {
+	memoria, iteraciones uint32
+	paralelismo          uint8
+	salt, clave          []byte
+}
+
+// parseArgon2id lee un hash en formato PHC: $argon2id$v=19$m=...,t=...,p=...$salt$clave
+func parseArgon2id(hash string) (hashArgon2id, error) {
+	var a hashArgon2id
+	partes := strings.Split(hash, "$")
+	if len(partes) != 6 || partes[1] != HashArgon2id {
+		return a, errors.New("hash argon2id inválido")
+	}
+	var version int
+	if _, err := fmt.Sscanf(partes[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return a, errors.New("versión de argon2id no soportada")
+	}
+	if _, err := fmt.Sscanf(partes[3], "m=%d,t=%d,p=%d", &a.memoria, &a.iteraciones, &a.paralelismo); err != nil {
+		return a, fmt.Errorf("parámetros de argon2id inválidos: %w", err)
+	}
+	var err error
+	if a.salt, err = base64.RawStdEncoding.DecodeString(partes[4]); err != nil {
+		return a, err
+	}
+	if a.clave, err = base64.RawStdEncoding.DecodeString(partes[5]); err != nil || len(a.clave) == 0 {
+		return a, errors.New("hash argon2id inválido")
+	}
+	return a, nil
+}
@@ -0,0 +1,104 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PoliticaContrasena son las reglas que debe cumplir una contraseña nueva
+type PoliticaContrasena struct {
+	MinLongitud int
+	MaxLongitud int
+	// MinClases es la cantidad mínima de clases de caracteres distintas (minúsculas,
+	// mayúsculas, dígitos y otros símbolos)
+	MinClases int
+	// Historial es la cantidad de contraseñas anteriores que no se pueden repetir; lo
+	// controla quien guarda la contraseña, que es quien tiene los hashes anteriores
+	Historial int
+	// Filtradas rechaza las contraseñas que aparecen en alguna de estas listas
+	Filtradas []*ListaFiltradas
+}
+
+// PoliticaPorDefecto exige 8 caracteres, no repetir las últimas 5 contraseñas y no usar
+// contraseñas de la lista incluida de filtraciones
+func PoliticaPorDefecto() PoliticaContrasena {
+	return PoliticaContrasena{
+		MinLongitud: 8,
+		MaxLongitud: 128,
+		MinClases:   1,
+		Historial:   5,
+		Filtradas:   []*ListaFiltradas{ListaFiltradasIncluida()},
+	}
+}
+
+// ErrPolitica indica que la contraseña no cumple la política; Motivos explica cada regla
+// incumplida en un texto apto para mostrar al usuario
+type ErrPolitica struct {
+	Motivos []string
+}
+
+func (e *ErrPolitica) Error() string {
+	return "la contraseña no cumple la política: " + strings.Join(e.Motivos, "; ")
+}
+
+// Validar verifica password contra la política. nombre y email son los del usuario: la
+// contraseña no puede contenerlos. No controla el historial.
+func (p PoliticaContrasena) Validar(password, nombre, email string) error {
+	var motivos []string
+	largo := utf8.RuneCountInString(password)
+	if largo < p.MinLongitud {
+		motivos = append(motivos, fmt.Sprintf("Debe tener al menos %d caracteres", p.MinLongitud))
+	}
+	if p.MaxLongitud > 0 && largo > p.MaxLongitud {
+		motivos = append(motivos, fmt.Sprintf("Debe tener como máximo %d caracteres", p.MaxLongitud))
+	} else if b := LongitudMaximaBytes(); b > 0 && len(password) > b {
+		motivos = append(motivos, fmt.Sprintf("Debe ocupar como máximo %d bytes (los acentos y símbolos ocupan más de uno)", b))
+	}
+	if clases := clasesDeCaracteres(password); clases < p.MinClases {
+		motivos = append(motivos, fmt.Sprintf("Debe combinar al menos %d de estos tipos de caracteres: minúsculas, mayúsculas, números y símbolos", p.MinClases))
+	}
+	minuscula := strings.ToLower(password)
+	if n := strings.ToLower(strings.TrimSpace(nombre)); len(n) >= 3 && strings.Contains(minuscula, n) {
+		motivos = append(motivos, "No puede contener el nombre de usuario")
+	}
+	local, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	if len(local) >= 3 && strings.Contains(minuscula, local) {
+		motivos = append(motivos, "No puede contener el email")
+	}
+	for _, lista := range p.Filtradas {
+		if lista.Contiene(password) {
+			motivos = append(motivos, "Aparece en filtraciones de contraseñas conocidas, elija otra")
+			break
+		}
+	}
+	if len(motivos) > 0 {
+		return &ErrPolitica{Motivos: motivos}
+	}
+	return nil
+}
+
+// clasesDeCaracteres cuenta cuántas clases distintas usa password
+func clasesDeCaracteres(password string) int {
+	var minus, mayus, digito, otro bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			minus = true
+		case unicode.IsUpper(r):
+			mayus = true
+		case unicode.IsDigit(r):
+			digito = true
+		default:
+			otro = true
+		}
+	}
+	n := 0
+	for _, b := range []bool{minus, mayus, digito, otro} {
+		if b {
+			n++
+		}
+	}
+	return n
+}
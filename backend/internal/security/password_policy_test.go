@@ -0,0 +1,65 @@
+package security
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPoliticaContrasena verifica cada regla de la política
+func TestPoliticaContrasena(t *testing.T) {
+	p := PoliticaContrasena{MinLongitud: 10, MaxLongitud: 20, MinClases: 3,
+		Filtradas: []*ListaFiltradas{ListaFiltradasIncluida()}}
+
+	casos := []struct {
+		password string
+		motivo   string
+	}{
+		{"Corta1!", "al menos 10 caracteres"},
+		{"solominusculas", "tipos de caracteres"},
+		{"Muuuuuuuuuuuuy-larga-1", "como máximo 20"},
+		{"Xx-Martina-2024", "nombre de usuario"},
+		{"Xx-mgomez-2024", "email"},
+		{"Password123", "filtraciones"},
+	}
+	for _, c := range casos {
+		err := p.Validar(c.password, "martina", "mgomez@example.com")
+		var politica *ErrPolitica
+		if !errors.As(err, &politica) || !strings.Contains(strings.Join(politica.Motivos, " "), c.motivo) {
+			t.Errorf("%q: se esperaba el motivo %q, obtuvo %v", c.password, c.motivo, err)
+		}
+	}
+	if err := p.Validar("Tres-Clases-ok9", "martina", "mgomez@example.com"); err != nil {
+		t.Errorf("Se esperaba aceptar la contraseña: %v", err)
+	}
+}
+
+// TestListaFiltradasDirectorio verifica el formato de un archivo por prefijo
+func TestListaFiltradasDirectorio(t *testing.T) {
+	suma := sha1.Sum([]byte("clave-filtrada"))
+	hash := strings.ToUpper(hex.EncodeToString(suma[:]))
+
+	dir := t.TempDir()
+	contenido := "0000000000000000000000000000000000A:3\n" + strings.ToLower(hash[5:]) + ":12\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(contenido), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	lista, err := AbrirListaFiltradas(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lista.Contiene("clave-filtrada") {
+		t.Error("Se esperaba encontrar la contraseña en el archivo de su prefijo")
+	}
+	if lista.Contiene("otra-clave") {
+		t.Error("No se esperaba encontrar una contraseña sin archivo de prefijo")
+	}
+
+	if _, err := CargarListaFiltradas(strings.NewReader("no-es-un-hash\n")); err == nil {
+		t.Error("Se esperaba error con una línea inválida")
+	}
+}
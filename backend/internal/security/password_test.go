@@ -43,4 +43,49 @@ func TestCheckPasswordHash(t *testing.T) {
 	if CheckPasswordHash(wrongPassword, hashedPassword) {
 		t.Error("Se esperaba que la contraseña incorrecta NO pasara la verificación")
 	}
-}
\ No newline at end of file
+}
+
+// TestNecesitaRehash verifica que los hashes bcrypt y los argon2id con otros parámetros se
+// recalculan, y que los nuevos siguen verificando los anteriores
+func TestNecesitaRehash(t *testing.T) {
+	defer ConfigurarHash(ConfigHashPorDefecto())
+
+	if err := ConfigurarHash(ConfigHash{Algoritmo: HashBcrypt, BcryptCost: 10}); err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := HashPassword("mypassword123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NecesitaRehash(bcryptHash) {
+		t.Error("Un hash con la configuración actual no necesita recalcularse")
+	}
+
+	ConfigurarHash(ConfigHashPorDefecto())
+	if !NecesitaRehash(bcryptHash) {
+		t.Error("Se esperaba recalcular un hash bcrypt al configurar argon2id")
+	}
+	if !CheckPasswordHash("mypassword123", bcryptHash) {
+		t.Error("Los hashes bcrypt existentes deben seguir verificando")
+	}
+	argonHash, _ := HashPassword("mypassword123")
+	if NecesitaRehash(argonHash) {
+		t.Error("Un hash con la configuración actual no necesita recalcularse")
+	}
+
+	c := ConfigHashPorDefecto()
+	c.Argon2Iteraciones = 3
+	ConfigurarHash(c)
+	if !NecesitaRehash(argonHash) || !CheckPasswordHash("mypassword123", argonHash) {
+		t.Error("Se esperaba recalcular un hash argon2id con otros parámetros y que siga verificando")
+	}
+
+	for _, hash := range []string{"", "texto-plano", "$argon2id$v=19$m=x$y$z"} {
+		if NecesitaRehash(hash) || CheckPasswordHash("texto-plano", hash) {
+			t.Errorf("Un hash irreconocible no se recalcula ni verifica: %q", hash)
+		}
+	}
+	if err := ConfigurarHash(ConfigHash{Algoritmo: "md5"}); err == nil {
+		t.Error("Se esperaba error con un algoritmo desconocido")
+	}
+}
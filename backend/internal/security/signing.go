@@ -0,0 +1,134 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// SigningCertValidity es la vigencia de los certificados de firma de los profesionales
+const SigningCertValidity = 5 * 365 * 24 * time.Hour
+
+// ErrInvalidSignature indica que la firma no corresponde al contenido o al certificado
+var ErrInvalidSignature = errors.New("firma inválida")
+
+// SigningIdentity es el par de claves Ed25519 de un profesional junto con su
+// certificado X.509 autofirmado
+type SigningIdentity struct {
+	PrivateKey  ed25519.PrivateKey
+	Certificate []byte // DER
+}
+
+// GenerateSigningIdentity crea un par de claves Ed25519 y un certificado autofirmado
+// cuyo sujeto identifica al profesional
+func GenerateSigningIdentity(commonName, serial string) (*SigningIdentity, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generando clave de firma: %w", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generando número de serie: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			SerialNumber: serial,
+			Organization: []string{"MediApp"},
+		},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(SigningCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		return nil, fmt.Errorf("error creando certificado de firma: %w", err)
+	}
+
+	return &SigningIdentity{PrivateKey: priv, Certificate: der}, nil
+}
+
+// CertificateFingerprint devuelve la huella SHA-256 del certificado en formato "SHA256:<hex>"
+func CertificateFingerprint(certDER []byte) string {
+	sum := sha256.Sum256(certDER)
+	return "SHA256:" + hex.EncodeToString(sum[:])
+}
+
+// CertificatePEM codifica el certificado en formato PEM
+func CertificatePEM(certDER []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
+}
+
+// VerifySignature verifica una firma Ed25519 usando la clave pública del certificado
+func VerifySignature(certDER, message, signature []byte) error {
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return fmt.Errorf("certificado inválido: %w", err)
+	}
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("el certificado no contiene una clave Ed25519")
+	}
+	if !ed25519.Verify(pub, message, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// SealPrivateKey cifra la clave privada con AES-256-GCM usando la clave maestra.
+// aad vincula el texto cifrado a su dueño para que no pueda reutilizarse en otra fila.
+func SealPrivateKey(masterKey []byte, priv ed25519.PrivateKey, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, priv.Seed(), aad), nil
+}
+
+// OpenPrivateKey descifra una clave privada cifrada con SealPrivateKey
+func OpenPrivateKey(masterKey, sealed, aad []byte) (ed25519.PrivateKey, error) {
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("clave privada cifrada inválida")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	seed, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo descifrar la clave privada: %w", err)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("la clave debe tener 32 bytes, tiene %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,83 @@
+package security
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSigningIdentity_SignAndVerify(t *testing.T) {
+	identidad, err := GenerateSigningIdentity("Dra. Pérez", "1234")
+	if err != nil {
+		t.Fatalf("Error al generar identidad de firma: %v", err)
+	}
+
+	mensaje := []byte(`{"contenido":"Amoxicilina 500mg"}`)
+	firma := ed25519.Sign(identidad.PrivateKey, mensaje)
+
+	if err := VerifySignature(identidad.Certificate, mensaje, firma); err != nil {
+		t.Errorf("Se esperaba firma válida, obtuvo: %v", err)
+	}
+
+	alterado := bytes.Replace(mensaje, []byte("500"), []byte("875"), 1)
+	if err := VerifySignature(identidad.Certificate, alterado, firma); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Se esperaba ErrInvalidSignature para contenido alterado, obtuvo: %v", err)
+	}
+
+	otra, _ := GenerateSigningIdentity("Otro", "5678")
+	if err := VerifySignature(otra.Certificate, mensaje, firma); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Se esperaba ErrInvalidSignature con otro certificado, obtuvo: %v", err)
+	}
+}
+
+func TestCertificateFingerprint(t *testing.T) {
+	identidad, _ := GenerateSigningIdentity("Dr. Gómez", "1")
+	huella := CertificateFingerprint(identidad.Certificate)
+	if !strings.HasPrefix(huella, "SHA256:") || len(huella) != len("SHA256:")+64 {
+		t.Errorf("Huella con formato inesperado: %s", huella)
+	}
+	if huella != CertificateFingerprint(identidad.Certificate) {
+		t.Error("Se esperaba que la huella fuera determinística")
+	}
+	if !strings.Contains(CertificatePEM(identidad.Certificate), "BEGIN CERTIFICATE") {
+		t.Error("Se esperaba un certificado en formato PEM")
+	}
+}
+
+func TestSealAndOpenPrivateKey(t *testing.T) {
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	aad := []byte("clave-1")
+
+	sealed, err := SealPrivateKey(masterKey, priv, aad)
+	if err != nil {
+		t.Fatalf("Error al cifrar clave privada: %v", err)
+	}
+	if bytes.Contains(sealed, priv.Seed()) {
+		t.Fatal("La clave cifrada no debe contener la semilla en claro")
+	}
+
+	abierta, err := OpenPrivateKey(masterKey, sealed, aad)
+	if err != nil {
+		t.Fatalf("Error al descifrar clave privada: %v", err)
+	}
+	if !priv.Equal(abierta) {
+		t.Error("Se esperaba recuperar la misma clave privada")
+	}
+
+	if _, err := OpenPrivateKey(masterKey, sealed, []byte("clave-2")); err == nil {
+		t.Error("Se esperaba error al descifrar con otro aad")
+	}
+	otraClave := make([]byte, 32)
+	rand.Read(otraClave)
+	if _, err := OpenPrivateKey(otraClave, sealed, aad); err == nil {
+		t.Error("Se esperaba error al descifrar con otra clave maestra")
+	}
+	if _, err := SealPrivateKey(masterKey[:16], priv, aad); err == nil {
+		t.Error("Se esperaba error con una clave maestra de 16 bytes")
+	}
+}
@@ -0,0 +1,119 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parámetros TOTP (RFC 6238) compatibles con las aplicaciones autenticadoras habituales
+const (
+	totpPeriodo = 30
+	totpDigitos = 6
+	// totpVentana es la cantidad de pasos aceptados antes y después del actual, para
+	// tolerar relojes desfasados
+	totpVentana = 1
+	// totpSecretoBytes es el tamaño del secreto (160 bits, lo recomendado para SHA-1)
+	totpSecretoBytes = 20
+)
+
+var base32SinRelleno = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerarSecretoTOTP crea un secreto TOTP aleatorio
+func GenerarSecretoTOTP() ([]byte, error) {
+	secreto := make([]byte, totpSecretoBytes)
+	if _, err := rand.Read(secreto); err != nil {
+		return nil, err
+	}
+	return secreto, nil
+}
+
+// SecretoTOTPBase32 codifica el secreto como lo cargan a mano las aplicaciones autenticadoras
+func SecretoTOTPBase32(secreto []byte) string {
+	return base32SinRelleno.EncodeToString(secreto)
+}
+
+// URITOTP arma la URI otpauth:// que se muestra como código QR al enrolar
+func URITOTP(emisor, cuenta string, secreto []byte) string {
+	v := url.Values{}
+	v.Set("secret", SecretoTOTPBase32(secreto))
+	v.Set("issuer", emisor)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigitos))
+	v.Set("period", fmt.Sprint(totpPeriodo))
+	etiqueta := url.PathEscape(emisor) + ":" + url.PathEscape(cuenta)
+	return "otpauth://totp/" + etiqueta + "?" + v.Encode()
+}
+
+// PasoTOTP es el número de período de 30 segundos que contiene t
+func PasoTOTP(t time.Time) int64 {
+	return t.Unix() / totpPeriodo
+}
+
+// CodigoTOTP calcula el código del paso indicado (HOTP de RFC 4226 con SHA-1)
+func CodigoTOTP(secreto []byte, paso int64) string {
+	var contador [8]byte
+	binary.BigEndian.PutUint64(contador[:], uint64(paso))
+	mac := hmac.New(sha1.New, secreto)
+	mac.Write(contador[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	valor := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigitos, valor%1000000)
+}
+
+// VerificarTOTP comprueba codigo contra los pasos cercanos a ahora y devuelve el paso que
+// coincidió. Solo acepta pasos posteriores a ultimoPaso, para que un código no se pueda
+// usar dos veces (0 si nunca se usó uno).
+func VerificarTOTP(secreto []byte, codigo string, ahora time.Time, ultimoPaso int64) (int64, bool) {
+	codigo = strings.ReplaceAll(strings.TrimSpace(codigo), " ", "")
+	if len(codigo) != totpDigitos {
+		return 0, false
+	}
+	actual := PasoTOTP(ahora)
+	for paso := actual - totpVentana; paso <= actual+totpVentana; paso++ {
+		if paso <= ultimoPaso {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(CodigoTOTP(secreto, paso)), []byte(codigo)) == 1 {
+			return paso, true
+		}
+	}
+	return 0, false
+}
+
+// CodigosRecuperacionCantidad es la cantidad de códigos de recuperación que se emiten juntos
+const CodigosRecuperacionCantidad = 10
+
+// GenerarCodigosRecuperacion crea códigos de un solo uso con el formato xxxxx-xxxxx
+// (50 bits aleatorios cada uno)
+func GenerarCodigosRecuperacion() ([]string, error) {
+	codigos := make([]string, CodigosRecuperacionCantidad)
+	for i := range codigos {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(base32SinRelleno.EncodeToString(b))[:10]
+		codigos[i] = s[:5] + "-" + s[5:]
+	}
+	return codigos, nil
+}
+
+// HashCodigoRecuperacion es el hash con el que se guarda un código de recuperación. Los
+// códigos tienen entropía suficiente para que alcance con SHA-256 sin sal; se normalizan
+// mayúsculas, espacios y guiones.
+func HashCodigoRecuperacion(codigo string) string {
+	normalizado := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(codigo)))
+	sum := sha256.Sum256([]byte(normalizado))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,62 @@
+package security
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTOTP_VectoresRFC6238 usa los vectores de prueba SHA-1 del RFC 6238 (últimos 6 dígitos)
+func TestTOTP_VectoresRFC6238(t *testing.T) {
+	secreto := []byte("12345678901234567890")
+	casos := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for segundos, want := range casos {
+		if got := CodigoTOTP(secreto, PasoTOTP(time.Unix(segundos, 0))); got != want {
+			t.Errorf("t=%d: se esperaba %s, obtuvo %s", segundos, want, got)
+		}
+	}
+}
+
+func TestTOTP_VentanaYReutilizacion(t *testing.T) {
+	secreto, _ := GenerarSecretoTOTP()
+	ahora := time.Now()
+	anterior := CodigoTOTP(secreto, PasoTOTP(ahora)-1)
+
+	paso, ok := VerificarTOTP(secreto, anterior, ahora, 0)
+	if !ok || paso != PasoTOTP(ahora)-1 {
+		t.Fatal("Se esperaba aceptar el código del paso anterior")
+	}
+	if _, ok := VerificarTOTP(secreto, anterior, ahora, paso); ok {
+		t.Error("Un código ya usado no debería aceptarse de nuevo")
+	}
+	if _, ok := VerificarTOTP(secreto, CodigoTOTP(secreto, PasoTOTP(ahora)-3), ahora, 0); ok {
+		t.Error("Un código fuera de la ventana no debería aceptarse")
+	}
+
+	uri := URITOTP("MediApp", "dra@example.com", secreto)
+	if !strings.HasPrefix(uri, "otpauth://totp/MediApp:dra@example.com?") || !strings.Contains(uri, "secret="+SecretoTOTPBase32(secreto)) {
+		t.Errorf("URI inesperada: %s", uri)
+	}
+}
+
+func TestCodigosRecuperacion(t *testing.T) {
+	codigos, err := GenerarCodigosRecuperacion()
+	if err != nil || len(codigos) != CodigosRecuperacionCantidad {
+		t.Fatalf("Se esperaban %d códigos: %v", CodigosRecuperacionCantidad, err)
+	}
+	vistos := map[string]bool{}
+	for _, c := range codigos {
+		if len(c) != 11 || c[5] != '-' || vistos[c] {
+			t.Errorf("Código inesperado o repetido: %s", c)
+		}
+		vistos[c] = true
+	}
+	if HashCodigoRecuperacion(codigos[0]) != HashCodigoRecuperacion(" "+strings.ToUpper(strings.Replace(codigos[0], "-", "", 1))) {
+		t.Error("El hash debería ignorar mayúsculas, espacios y guiones")
+	}
+}
@@ -0,0 +1,122 @@
+package security
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// VaultLogical es la parte del cliente de Vault (api.Logical) que usa VaultTransit
+type VaultLogical interface {
+	ReadWithContext(ctx context.Context, path string) (*api.Secret, error)
+	WriteWithContext(ctx context.Context, path string, data map[string]interface{}) (*api.Secret, error)
+}
+
+// VaultTransit envuelve las claves de datos con el motor transit de Vault, de modo que la
+// clave maestra nunca sale de Vault. Las versiones son las de la clave de transit
+// ("vault:v3:..." es la versión 3).
+type VaultTransit struct {
+	client VaultLogical
+	mount  string
+	key    string
+}
+
+// NewVaultTransit usa la clave key del motor transit montado en mount (por defecto "transit")
+func NewVaultTransit(client VaultLogical, mount, key string) *VaultTransit {
+	if mount == "" {
+		mount = "transit"
+	}
+	return &VaultTransit{client: client, mount: strings.Trim(mount, "/"), key: key}
+}
+
+// Wrap implementa KeyWrapper
+func (v *VaultTransit) Wrap(ctx context.Context, dek []byte) ([]byte, uint32, error) {
+	secret, err := v.client.WriteWithContext(ctx, v.mount+"/encrypt/"+v.key, map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(dek),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("error en vault transit encrypt: %w", err)
+	}
+	ciphertext, err := campoSecreto(secret, "ciphertext")
+	if err != nil {
+		return nil, 0, err
+	}
+	version, err := versionTransit(ciphertext)
+	if err != nil {
+		return nil, 0, err
+	}
+	return []byte(ciphertext), version, nil
+}
+
+// Unwrap implementa KeyWrapper
+func (v *VaultTransit) Unwrap(ctx context.Context, version uint32, wrapped []byte) ([]byte, error) {
+	if got, err := versionTransit(string(wrapped)); err != nil || got != version {
+		return nil, fmt.Errorf("la clave envuelta no corresponde a la versión %d", version)
+	}
+	secret, err := v.client.WriteWithContext(ctx, v.mount+"/decrypt/"+v.key, map[string]interface{}{
+		"ciphertext": string(wrapped),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error en vault transit decrypt: %w", err)
+	}
+	plaintext, err := campoSecreto(secret, "plaintext")
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(plaintext)
+}
+
+// CurrentVersion implementa KeyWrapper: es la última versión de la clave de transit
+// (rotarla en Vault hace que los valores nuevos usen la versión siguiente)
+func (v *VaultTransit) CurrentVersion(ctx context.Context) (uint32, error) {
+	secret, err := v.client.ReadWithContext(ctx, v.mount+"/keys/"+v.key)
+	if err != nil {
+		return 0, fmt.Errorf("error leyendo la clave de vault transit: %w", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return 0, fmt.Errorf("vault transit no devolvió la clave %s", v.key)
+	}
+	var n int64
+	switch valor := secret.Data["latest_version"].(type) {
+	case json.Number:
+		n, err = valor.Int64()
+	case float64:
+		n = int64(valor)
+	default:
+		err = fmt.Errorf("tipo %T", valor)
+	}
+	if err != nil || n <= 0 || n > math.MaxUint32 {
+		return 0, fmt.Errorf("vault transit devolvió una latest_version inválida")
+	}
+	return uint32(n), nil
+}
+
+func campoSecreto(secret *api.Secret, campo string) (string, error) {
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("vault transit no devolvió datos")
+	}
+	valor, ok := secret.Data[campo].(string)
+	if !ok || valor == "" {
+		return "", fmt.Errorf("vault transit no devolvió %s", campo)
+	}
+	return valor, nil
+}
+
+// versionTransit extrae la versión de un texto cifrado "vault:vN:..."
+func versionTransit(ciphertext string) (uint32, error) {
+	partes := strings.SplitN(ciphertext, ":", 3)
+	if len(partes) != 3 || partes[0] != "vault" || !strings.HasPrefix(partes[1], "v") {
+		return 0, fmt.Errorf("texto cifrado de vault transit inválido")
+	}
+	n, err := strconv.ParseUint(partes[1][1:], 10, 32)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("versión de vault transit inválida: %s", partes[1])
+	}
+	return uint32(n), nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// AccesoQuerier define lo mínimo que AccesoService necesita de la base de datos
+type AccesoQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// EvaluacionAcceso es el resultado de comprobar si un profesional puede ver a un paciente
+type EvaluacionAcceso struct {
+	PacienteExiste bool
+	// Autorizado indica que el profesional está asignado al paciente o pertenece a su consultorio
+	Autorizado    bool
+	ConsultorioID *uuid.UUID
+}
+
+// Acceso es una lectura de datos clínicos de un paciente
+type Acceso struct {
+	UsuarioID     uuid.UUID
+	PacienteID    uuid.UUID
+	ConsultorioID *uuid.UUID
+	Recurso       string
+	Ruta          string
+	Motivo        *string
+	IP            string
+	RequestID     string
+	Permitido     bool
+	Emergencia    bool
+	Justificacion *string
+}
+
+// AccesoService decide y registra los accesos a datos clínicos de pacientes
+type AccesoService struct {
+	db     AccesoQuerier
+	logger *zap.Logger
+}
+
+// NewAccesoService crea un AccesoService
+func NewAccesoService(db AccesoQuerier, logger *zap.Logger) *AccesoService {
+	return &AccesoService{db: db, logger: logger}
+}
+
+// EvaluarAcceso comprueba si el usuario está asignado al paciente (paciente_profesional)
+// o pertenece al mismo consultorio que el paciente
+func (s *AccesoService) EvaluarAcceso(ctx context.Context, usuarioID, pacienteID uuid.UUID) (EvaluacionAcceso, error) {
+	var ev EvaluacionAcceso
+	err := s.db.QueryRow(ctx, `
+		SELECT p.consultorio_id,
+			(p.consultorio_id IS NOT NULL AND p.consultorio_id = u.consultorio_id)
+			OR EXISTS (
+				SELECT 1 FROM paciente_profesional pp
+				WHERE pp.paciente_id = p.id AND pp.usuario_id = $2
+			)
+		FROM pacientes p
+		LEFT JOIN usuarios u ON u.id = $2
+		WHERE p.id = $1
+	`, pacienteID, usuarioID).Scan(&ev.ConsultorioID, &ev.Autorizado)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return EvaluacionAcceso{}, nil
+	}
+	if err != nil {
+		return EvaluacionAcceso{}, err
+	}
+	ev.PacienteExiste = true
+	return ev, nil
+}
+
+// RegistrarAcceso guarda el acceso en accesos_pacientes. Los accesos de emergencia
+// quedan marcados para revisión obligatoria.
+func (s *AccesoService) RegistrarAcceso(ctx context.Context, a Acceso) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO accesos_pacientes (usuario_id, paciente_id, consultorio_id, recurso, ruta, motivo, ip, request_id,
+			permitido, emergencia, justificacion, requiere_revision, fecha)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW())
+	`, a.UsuarioID, a.PacienteID, a.ConsultorioID, a.Recurso, a.Ruta, a.Motivo, a.IP, a.RequestID,
+		a.Permitido, a.Emergencia, a.Justificacion, a.Emergencia && a.Permitido)
+	if err != nil {
+		return err
+	}
+	if a.Emergencia {
+		s.logger.Warn("Acceso de emergencia a paciente",
+			zap.String("usuario_id", a.UsuarioID.String()),
+			zap.String("paciente_id", a.PacienteID.String()),
+			zap.String("recurso", a.Recurso))
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+const (
+	// prefijoAPIKey identifica las claves de MediApp (por ejemplo en escáneres de secretos)
+	prefijoAPIKey = "mk_"
+	// usoMinimoAPIKey es cada cuánto se registra el último uso de una key, salvo que cambie
+	// la IP, para no escribir en cada request
+	usoMinimoAPIKey = time.Minute
+	// LimiteAPIKeyDefault es el límite de requests por minuto de una key si no se elige otro
+	LimiteAPIKeyDefault = 60
+)
+
+// ErrAPIKeyInvalida indica una API key inexistente, revocada o vencida
+var ErrAPIKeyInvalida = errors.New("API key inválida, revocada o vencida")
+
+// APIKeyDB es la parte del pool que usa APIKeyService
+type APIKeyDB interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// APIKey es una key autenticada: opera sobre su consultorio con sus permisos
+type APIKey struct {
+	ID              uuid.UUID
+	Nombre          string
+	ConsultorioID   uuid.UUID
+	Permisos        []string
+	LimitePorMinuto int
+}
+
+// APIKeyService autentica las API keys de las integraciones (tabla api_keys)
+type APIKeyService struct {
+	db     APIKeyDB
+	logger *zap.Logger
+	ahora  func() time.Time
+}
+
+// NewAPIKeyService crea el servicio sobre db
+func NewAPIKeyService(db APIKeyDB, logger *zap.Logger) *APIKeyService {
+	return &APIKeyService{db: db, logger: logger, ahora: time.Now}
+}
+
+// GenerarAPIKey crea una clave nueva mk_<prefijo>_<secreto>. Devuelve la clave, que se
+// muestra una sola vez, su prefijo y el hash que se guarda.
+func GenerarAPIKey() (clave, prefijo, hash string, err error) {
+	b := make([]byte, 6+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	prefijo = hex.EncodeToString(b[:6])
+	clave = prefijoAPIKey + prefijo + "_" + base64.RawURLEncoding.EncodeToString(b[6:])
+	return clave, prefijo, hashToken(clave), nil
+}
+
+// Autenticar busca la key por su prefijo y verifica el resto. Registra el último uso y la
+// IP; si eso falla la key igual se acepta.
+func (s *APIKeyService) Autenticar(ctx context.Context, clave, ip string) (APIKey, error) {
+	var k APIKey
+	resto, ok := strings.CutPrefix(clave, prefijoAPIKey)
+	if !ok {
+		return k, ErrAPIKeyInvalida
+	}
+	prefijo, _, ok := strings.Cut(resto, "_")
+	if !ok || prefijo == "" {
+		return k, ErrAPIKeyInvalida
+	}
+
+	var (
+		hash      string
+		expiraEn  *time.Time
+		revocada  bool
+		ultimoUso *time.Time
+		ultimaIP  *string
+	)
+	err := s.db.QueryRow(ctx, `
+		SELECT id, nombre, clave_hash, consultorio_id, permisos, limite_por_minuto,
+			   expira_en, revocada_en IS NOT NULL, ultimo_uso_en, ultima_ip
+		FROM api_keys WHERE prefijo = $1
+	`, prefijo).Scan(&k.ID, &k.Nombre, &hash, &k.ConsultorioID, &k.Permisos, &k.LimitePorMinuto,
+		&expiraEn, &revocada, &ultimoUso, &ultimaIP)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return k, ErrAPIKeyInvalida
+	} else if err != nil {
+		return k, err
+	}
+	ahora := s.ahora()
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(clave))) != 1 ||
+		revocada || (expiraEn != nil && !ahora.Before(*expiraEn)) {
+		return APIKey{}, ErrAPIKeyInvalida
+	}
+
+	if ultimoUso == nil || ahora.Sub(*ultimoUso) >= usoMinimoAPIKey || ultimaIP == nil || *ultimaIP != ip {
+		if _, err := s.db.Exec(ctx, `UPDATE api_keys SET ultimo_uso_en = $2, ultima_ip = $3 WHERE id = $1`,
+			k.ID, ahora, ip); err != nil {
+			s.logger.Warn("No se pudo registrar el uso de la API key", zap.Error(err), zap.String("api_key_id", k.ID.String()))
+		}
+	}
+	return k, nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// fakeAPIKeyDB guarda una sola key y registra sus usos
+type fakeAPIKeyDB struct {
+	prefijo, hash string
+	expiraEn      *time.Time
+	revocada      bool
+	ultimoUso     *time.Time
+	ultimaIP      *string
+	usos          int
+}
+
+func (f *fakeAPIKeyDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return scanFunc(func(dest ...interface{}) error {
+		if args[0] != f.prefijo {
+			return pgx.ErrNoRows
+		}
+		*dest[0].(*uuid.UUID) = uuid.New()
+		*dest[1].(*string) = "laboratorio"
+		*dest[2].(*string) = f.hash
+		*dest[4].(*[]string) = []string{"turnos:read"}
+		*dest[5].(*int) = 30
+		*dest[6].(**time.Time) = f.expiraEn
+		*dest[7].(*bool) = f.revocada
+		*dest[8].(**time.Time) = f.ultimoUso
+		*dest[9].(**string) = f.ultimaIP
+		return nil
+	})
+}
+
+func (f *fakeAPIKeyDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ahora, ip := args[1].(time.Time), args[2].(string)
+	f.ultimoUso, f.ultimaIP = &ahora, &ip
+	f.usos++
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func TestAPIKey_Autenticar(t *testing.T) {
+	ctx := context.Background()
+	clave, prefijo, hash, err := GenerarAPIKey()
+	if err != nil || !strings.HasPrefix(clave, "mk_"+prefijo+"_") || hash == clave {
+		t.Fatalf("Clave inesperada %q (prefijo %q): %v", clave, prefijo, err)
+	}
+	ahora := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	db := &fakeAPIKeyDB{prefijo: prefijo, hash: hash}
+	s := &APIKeyService{db: db, logger: zap.NewNop(), ahora: func() time.Time { return ahora }}
+
+	k, err := s.Autenticar(ctx, clave, "10.0.0.1")
+	if err != nil || k.LimitePorMinuto != 30 || len(k.Permisos) != 1 {
+		t.Fatalf("Se esperaba autenticar la key, obtuvo %+v %v", k, err)
+	}
+
+	// El uso se registra a lo sumo una vez por minuto, salvo que cambie la IP
+	ahora = ahora.Add(30 * time.Second)
+	s.Autenticar(ctx, clave, "10.0.0.1")
+	if db.usos != 1 {
+		t.Errorf("Se esperaba un solo registro de uso, hubo %d", db.usos)
+	}
+	s.Autenticar(ctx, clave, "10.0.0.2")
+	if db.usos != 2 || *db.ultimaIP != "10.0.0.2" {
+		t.Errorf("Se esperaba registrar la IP nueva: %d %v", db.usos, *db.ultimaIP)
+	}
+
+	for _, otra := range []string{"", "mk_", prefijo, "mk_" + prefijo + "_otro-secreto", "mk_000000000000_" + clave[len(clave)-10:]} {
+		if _, err := s.Autenticar(ctx, otra, "10.0.0.1"); !errors.Is(err, ErrAPIKeyInvalida) {
+			t.Errorf("%q: se esperaba ErrAPIKeyInvalida, obtuvo %v", otra, err)
+		}
+	}
+
+	vencida := ahora
+	db.expiraEn = &vencida
+	if _, err := s.Autenticar(ctx, clave, "10.0.0.1"); !errors.Is(err, ErrAPIKeyInvalida) {
+		t.Errorf("Se esperaba rechazar una key vencida, obtuvo %v", err)
+	}
+	db.expiraEn, db.revocada = nil, true
+	if _, err := s.Autenticar(ctx, clave, "10.0.0.1"); !errors.Is(err, ErrAPIKeyInvalida) {
+		t.Errorf("Se esperaba rechazar una key revocada, obtuvo %v", err)
+	}
+}
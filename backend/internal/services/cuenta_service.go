@@ -0,0 +1,410 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/mailer"
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Tipos de token de cuenta (usuarios_tokens.tipo)
+const (
+	TokenRestablecer     = "restablecer"
+	TokenVerificarEmail  = "verificar_email"
+	TokenInvitacion      = "invitacion"
+	RestablecerTTL       = time.Hour
+	VerificacionEmailTTL = 48 * time.Hour
+	InvitacionTTL        = 7 * 24 * time.Hour
+	// tokensCuentaPorHora limita los correos de un mismo tipo que recibe un usuario
+	tokensCuentaPorHora = 3
+	// envioCorreoTimeout limita el envío en segundo plano
+	envioCorreoTimeout = time.Minute
+)
+
+var (
+	// ErrTokenCuentaInvalido indica un token inexistente, vencido o ya usado
+	ErrTokenCuentaInvalido = errors.New("token inválido o vencido")
+	// ErrEmailYaVerificado indica que el email del usuario ya está verificado
+	ErrEmailYaVerificado = errors.New("el email ya está verificado")
+	// ErrDemasiadasSolicitudes indica que se alcanzó el límite de correos por hora
+	ErrDemasiadasSolicitudes = errors.New("demasiadas solicitudes, intente más tarde")
+)
+
+// CuentaService emite y canjea los tokens de un solo uso que se envían por correo para
+// restablecer la contraseña, verificar el email y activar una cuenta invitada. Del token
+// solo se guarda el hash.
+type CuentaService struct {
+	db       MFADB
+	mailer   mailer.Mailer
+	urlBase  string
+	politica security.PoliticaContrasena
+	logger   *zap.Logger
+	ahora    func() time.Time
+	// segundoPlano ejecuta los envíos de correo; los tests lo reemplazan para esperarlos
+	segundoPlano func(func())
+}
+
+// NewCuentaService crea el servicio. urlBase es la URL del frontend con la que se arman los
+// enlaces (urlBase/restablecer-contrasena?token=..., urlBase/verificar-email?token=... y
+// urlBase/activar-cuenta?token=...).
+func NewCuentaService(db MFADB, m mailer.Mailer, urlBase string, logger *zap.Logger) *CuentaService {
+	return &CuentaService{
+		db:           db,
+		mailer:       m,
+		urlBase:      urlBase,
+		politica:     security.PoliticaPorDefecto(),
+		logger:       logger,
+		ahora:        time.Now,
+		segundoPlano: func(f func()) { go f() },
+	}
+}
+
+// SetPolitica cambia la política que deben cumplir las contraseñas nuevas
+func (s *CuentaService) SetPolitica(p security.PoliticaContrasena) {
+	s.politica = p
+}
+
+// SetSincronico hace que los correos se envíen antes de volver, para los comandos de
+// consola que terminan apenas responde el servicio
+func (s *CuentaService) SetSincronico() {
+	s.segundoPlano = func(f func()) { f() }
+}
+
+// SolicitarRestablecimiento envía un enlace para restablecer la contraseña si email
+// pertenece a un usuario activo. No informa si el email existe: devuelve nil igual, y el
+// correo se envía en segundo plano para que el tiempo de respuesta tampoco lo delate.
+func (s *CuentaService) SolicitarRestablecimiento(ctx context.Context, email string) error {
+	var usuarioID uuid.UUID
+	var nombre, destino string
+	err := s.db.QueryRow(ctx, `
+		SELECT id, nombre, email FROM usuarios WHERE lower(email) = lower($1) AND activo
+	`, email).Scan(&usuarioID, &nombre, &destino)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.logger.Info("Restablecimiento solicitado para un email desconocido")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.enviarRestablecimiento(ctx, usuarioID, nombre, destino)
+	if errors.Is(err, ErrDemasiadasSolicitudes) {
+		s.logger.Warn("Límite de restablecimientos alcanzado", zap.String("usuario_id", usuarioID.String()))
+		return nil
+	}
+	return err
+}
+
+// EnviarRestablecimiento envía al usuario activo usuarioID un enlace para elegir una
+// contraseña nueva (restablecimiento forzado por un administrador)
+func (s *CuentaService) EnviarRestablecimiento(ctx context.Context, usuarioID uuid.UUID) error {
+	var nombre, email string
+	err := s.db.QueryRow(ctx, `
+		SELECT nombre, email FROM usuarios WHERE id = $1 AND activo
+	`, usuarioID).Scan(&nombre, &email)
+	if err != nil {
+		return err
+	}
+	return s.enviarRestablecimiento(ctx, usuarioID, nombre, email)
+}
+
+func (s *CuentaService) enviarRestablecimiento(ctx context.Context, usuarioID uuid.UUID, nombre, destino string) error {
+	token, err := s.emitir(ctx, usuarioID, TokenRestablecer, destino, RestablecerTTL)
+	if err != nil {
+		return err
+	}
+	s.enviar(usuarioID, mailer.Mensaje{
+		Para:   destino,
+		Asunto: "Restablecer contraseña de MediApp",
+		Texto: fmt.Sprintf("Hola %s:\n\n"+
+			"Recibimos un pedido para restablecer tu contraseña. Para elegir una nueva, abrí este enlace:\n\n"+
+			"%s\n\n"+
+			"El enlace vence en %d minutos y sirve una sola vez. Si no lo pediste, ignorá este correo: tu contraseña no cambia.\n",
+			nombre, s.enlace("restablecer-contrasena", token), int(RestablecerTTL.Minutes())),
+	})
+	return nil
+}
+
+// Restablecer cambia la contraseña del usuario del token, pone en cero sus intentos
+// fallidos e invalida los demás tokens de restablecimiento pendientes. Como el enlace llegó
+// al correo, también da por verificado el email si no cambió. Devuelve el usuario para que
+// el llamador revoque sus sesiones; el trigger de usuarios además avisa por
+// usuarios_revocados. La auditoría entry se escribe en la misma transacción.
+func (s *CuentaService) Restablecer(ctx context.Context, token, password string, entry audit.Entry) (uuid.UUID, error) {
+	return s.asignarContrasena(ctx, token, TokenRestablecer, password, entry)
+}
+
+// Invitar envía al usuario activo usuarioID, recién dado de alta sin contraseña, un enlace
+// para elegirla y activar la cuenta
+func (s *CuentaService) Invitar(ctx context.Context, usuarioID uuid.UUID) error {
+	var nombre, email string
+	err := s.db.QueryRow(ctx, `
+		SELECT nombre, email FROM usuarios WHERE id = $1 AND activo
+	`, usuarioID).Scan(&nombre, &email)
+	if err != nil {
+		return err
+	}
+	token, err := s.emitir(ctx, usuarioID, TokenInvitacion, email, InvitacionTTL)
+	if err != nil {
+		return err
+	}
+	s.enviar(usuarioID, mailer.Mensaje{
+		Para:   email,
+		Asunto: "Invitación a MediApp",
+		Texto: fmt.Sprintf("Hola %s:\n\n"+
+			"Te dieron de alta en MediApp con el usuario %q. Para elegir tu contraseña y activar la cuenta, abrí este enlace:\n\n"+
+			"%s\n\n"+
+			"El enlace vence en %d días y sirve una sola vez.\n",
+			nombre, nombre, s.enlace("activar-cuenta", token), int(InvitacionTTL.Hours()/24)),
+	})
+	return nil
+}
+
+// Activar canjea un token de invitación: asigna la contraseña elegida y verifica el email,
+// igual que Restablecer
+func (s *CuentaService) Activar(ctx context.Context, token, password string, entry audit.Entry) (uuid.UUID, error) {
+	return s.asignarContrasena(ctx, token, TokenInvitacion, password, entry)
+}
+
+// asignarContrasena canjea un token de tipo (restablecimiento o invitación) por una
+// contraseña nueva. Si la contraseña no cumple la política devuelve *security.ErrPolitica
+// y el token sigue sirviendo para elegir otra.
+func (s *CuentaService) asignarContrasena(ctx context.Context, token, tipo, password string, entry audit.Entry) (uuid.UUID, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	ahora := s.ahora()
+	usuarioID, email, err := s.canjear(ctx, tx, token, tipo, ahora)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	// El usuario queda bloqueado hasta el commit, así dos canjes simultáneos no se saltean
+	// el historial
+	var nombre, emailActual, hashActual string
+	var anteriores []string
+	err = tx.QueryRow(ctx, `
+		SELECT u.nombre, u.email, u.contrasena_hash,
+			COALESCE((SELECT array_agg(h.contrasena_hash ORDER BY h.creado_en DESC, h.id DESC)
+				FROM (SELECT id, contrasena_hash, creado_en FROM usuarios_contrasenas
+					WHERE usuario_id = u.id ORDER BY creado_en DESC, id DESC LIMIT $2) h), '{}')
+		FROM usuarios u WHERE u.id = $1
+		FOR UPDATE OF u
+	`, usuarioID, s.politica.Historial).Scan(&nombre, &emailActual, &hashActual, &anteriores)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := s.politica.Validar(password, nombre, emailActual); err != nil {
+		return uuid.Nil, err
+	}
+	if s.politica.Historial > 0 && repetida(password, hashActual, anteriores) {
+		return uuid.Nil, &security.ErrPolitica{Motivos: []string{
+			fmt.Sprintf("No puede repetir ninguna de sus últimas %d contraseñas", s.politica.Historial),
+		}}
+	}
+	hash, err := security.HashPassword(password)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE usuarios_tokens SET usado_en = $3
+		WHERE usuario_id = $1 AND tipo = $2 AND usado_en IS NULL
+	`, usuarioID, tipo, ahora); err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE usuarios
+		SET contrasena_hash = $2, intentos_fallidos = 0,
+			email_verificado_en = CASE WHEN email = $3 THEN COALESCE(email_verificado_en, $4) ELSE email_verificado_en END
+		WHERE id = $1
+	`, usuarioID, hash, email, ahora); err != nil {
+		return uuid.Nil, err
+	}
+	if s.politica.Historial > 0 {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO usuarios_contrasenas (usuario_id, contrasena_hash, creado_en) VALUES ($1, $2, $3)
+		`, usuarioID, hash, ahora); err != nil {
+			return uuid.Nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+			DELETE FROM usuarios_contrasenas
+			WHERE usuario_id = $1 AND id NOT IN (
+				SELECT id FROM usuarios_contrasenas WHERE usuario_id = $1
+				ORDER BY creado_en DESC, id DESC LIMIT $2
+			)
+		`, usuarioID, s.politica.Historial); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	// La contraseña nueva nunca se guarda en la auditoría
+	entry.RegistroID = usuarioID.String()
+	if entry.UsuarioID == nil {
+		entry.UsuarioID = &usuarioID
+	}
+	entry.Despues = map[string]interface{}{"intentos_fallidos": 0}
+	if err := audit.Write(ctx, tx, entry); err != nil {
+		return uuid.Nil, err
+	}
+	return usuarioID, tx.Commit(ctx)
+}
+
+// repetida indica si password coincide con la contraseña actual o con alguna anterior. Un
+// usuario invitado no tiene contraseña actual (hash vacío).
+func repetida(password, actual string, anteriores []string) bool {
+	if actual != "" && security.CheckPasswordHash(password, actual) {
+		return true
+	}
+	for _, h := range anteriores {
+		if h != actual && security.CheckPasswordHash(password, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// EnviarVerificacion envía al usuario un enlace para verificar su email
+func (s *CuentaService) EnviarVerificacion(ctx context.Context, usuarioID uuid.UUID) error {
+	var nombre, email string
+	var verificado *time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT nombre, email, email_verificado_en FROM usuarios WHERE id = $1
+	`, usuarioID).Scan(&nombre, &email, &verificado)
+	if err != nil {
+		return err
+	}
+	if verificado != nil {
+		return ErrEmailYaVerificado
+	}
+	token, err := s.emitir(ctx, usuarioID, TokenVerificarEmail, email, VerificacionEmailTTL)
+	if err != nil {
+		return err
+	}
+	s.enviar(usuarioID, mailer.Mensaje{
+		Para:   email,
+		Asunto: "Verificá tu email en MediApp",
+		Texto: fmt.Sprintf("Hola %s:\n\n"+
+			"Para confirmar que esta dirección es tuya, abrí este enlace:\n\n"+
+			"%s\n\n"+
+			"El enlace vence en %d horas.\n",
+			nombre, s.enlace("verificar-email", token), int(VerificacionEmailTTL.Hours())),
+	})
+	return nil
+}
+
+// VerificarEmail marca como verificado el email del usuario del token, siempre que sea la
+// misma dirección a la que se envió
+func (s *CuentaService) VerificarEmail(ctx context.Context, token string, entry audit.Entry) (uuid.UUID, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	ahora := s.ahora()
+	usuarioID, email, err := s.canjear(ctx, tx, token, TokenVerificarEmail, ahora)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	res, err := tx.Exec(ctx, `
+		UPDATE usuarios SET email_verificado_en = COALESCE(email_verificado_en, $3)
+		WHERE id = $1 AND email = $2
+	`, usuarioID, email, ahora)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if res.RowsAffected() == 0 {
+		return uuid.Nil, ErrTokenCuentaInvalido
+	}
+
+	entry.RegistroID = usuarioID.String()
+	if entry.UsuarioID == nil {
+		entry.UsuarioID = &usuarioID
+	}
+	entry.Despues = map[string]interface{}{"email": email, "email_verificado_en": ahora}
+	if err := audit.Write(ctx, tx, entry); err != nil {
+		return uuid.Nil, err
+	}
+	return usuarioID, tx.Commit(ctx)
+}
+
+// emitir guarda el hash de un token nuevo de tipo para usuarioID y devuelve el token
+func (s *CuentaService) emitir(ctx context.Context, usuarioID uuid.UUID, tipo, email string, ttl time.Duration) (string, error) {
+	ahora := s.ahora()
+	var recientes int
+	err := s.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM usuarios_tokens WHERE usuario_id = $1 AND tipo = $2 AND creado_en > $3
+	`, usuarioID, tipo, ahora.Add(-time.Hour)).Scan(&recientes)
+	if err != nil {
+		return "", err
+	}
+	if recientes >= tokensCuentaPorHora {
+		return "", ErrDemasiadasSolicitudes
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	_, err = s.db.Exec(ctx, `
+		INSERT INTO usuarios_tokens (usuario_id, tipo, token_hash, email, expira_en, creado_en)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, usuarioID, tipo, hashToken(token), email, ahora.Add(ttl), ahora)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// canjear marca como usado el token de tipo si sigue vigente y su usuario está activo
+func (s *CuentaService) canjear(ctx context.Context, tx pgx.Tx, token, tipo string, ahora time.Time) (uuid.UUID, string, error) {
+	var usuarioID uuid.UUID
+	var email string
+	err := tx.QueryRow(ctx, `
+		UPDATE usuarios_tokens t SET usado_en = $3
+		FROM usuarios u
+		WHERE t.token_hash = $1 AND t.tipo = $2 AND t.usado_en IS NULL AND t.expira_en > $3
+		  AND u.id = t.usuario_id AND u.activo
+		RETURNING t.usuario_id, t.email
+	`, hashToken(token), tipo, ahora).Scan(&usuarioID, &email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, "", ErrTokenCuentaInvalido
+	}
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return usuarioID, email, nil
+}
+
+func (s *CuentaService) enlace(ruta, token string) string {
+	return s.urlBase + "/" + ruta + "?token=" + url.QueryEscape(token)
+}
+
+// enviar entrega m en segundo plano; un fallo solo queda en el log, el usuario puede
+// volver a pedir el correo
+func (s *CuentaService) enviar(usuarioID uuid.UUID, m mailer.Mensaje) {
+	s.segundoPlano(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), envioCorreoTimeout)
+		defer cancel()
+		if err := s.mailer.Enviar(ctx, m); err != nil {
+			s.logger.Error("No se pudo enviar el correo",
+				zap.Error(err),
+				zap.String("usuario_id", usuarioID.String()),
+				zap.String("asunto", m.Asunto))
+		}
+	})
+}
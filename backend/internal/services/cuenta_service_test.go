@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/mailer"
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+type tokenCuenta struct {
+	usuarioID uuid.UUID
+	tipo      string
+	email     string
+	expira    time.Time
+	usado     bool
+	creado    time.Time
+}
+
+// fakeCuentaDB simula usuarios_tokens y un único usuario activo
+type fakeCuentaDB struct {
+	usuarioID  uuid.UUID
+	email      string
+	hash       string
+	intentos   int
+	verificado bool
+	historial  []string
+	tokens     map[string]*tokenCuenta
+	auditorias []string
+}
+
+type fakeCuentaTx struct {
+	pgx.Tx
+	db *fakeCuentaDB
+}
+
+func (db *fakeCuentaDB) Begin(ctx context.Context) (pgx.Tx, error) { return &fakeCuentaTx{db: db}, nil }
+
+func (tx *fakeCuentaTx) Commit(ctx context.Context) error   { return nil }
+func (tx *fakeCuentaTx) Rollback(ctx context.Context) error { return nil }
+func (tx *fakeCuentaTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return tx.db.Exec(ctx, sql, args...)
+}
+func (tx *fakeCuentaTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return tx.db.QueryRow(ctx, sql, args...)
+}
+
+func (db *fakeCuentaDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	switch {
+	case strings.Contains(sql, "INSERT INTO usuarios_tokens"):
+		db.tokens[args[2].(string)] = &tokenCuenta{
+			usuarioID: args[0].(uuid.UUID), tipo: args[1].(string), email: args[3].(string),
+			expira: args[4].(time.Time), creado: args[5].(time.Time),
+		}
+	case strings.Contains(sql, "UPDATE usuarios_tokens SET usado_en"):
+		for _, t := range db.tokens {
+			if t.usuarioID == args[0] && t.tipo == args[1] {
+				t.usado = true
+			}
+		}
+	case strings.Contains(sql, "SET contrasena_hash"):
+		db.hash, db.intentos = args[1].(string), 0
+		db.verificado = db.verificado || args[2] == db.email
+	case strings.Contains(sql, "SET email_verificado_en"):
+		if args[1] != db.email {
+			return pgconn.NewCommandTag("UPDATE 0"), nil
+		}
+		db.verificado = true
+	case strings.Contains(sql, "INSERT INTO usuarios_contrasenas"):
+		db.historial = append([]string{args[1].(string)}, db.historial...)
+	case strings.Contains(sql, "DELETE FROM usuarios_contrasenas"):
+		if n := args[1].(int); len(db.historial) > n {
+			db.historial = db.historial[:n]
+		}
+	case strings.Contains(sql, "INSERT INTO auditorias"):
+		db.auditorias = append(db.auditorias, args[1].(string))
+	case strings.Contains(sql, "pg_advisory_xact_lock"):
+	default:
+		return pgconn.CommandTag{}, errors.New("exec inesperado: " + sql)
+	}
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (db *fakeCuentaDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	switch {
+	case strings.Contains(sql, "SELECT id, nombre, email FROM usuarios"):
+		return scanFunc(func(dest ...interface{}) error {
+			if !strings.EqualFold(args[0].(string), db.email) {
+				return pgx.ErrNoRows
+			}
+			*(dest[0].(*uuid.UUID)), *(dest[1].(*string)), *(dest[2].(*string)) = db.usuarioID, "Dra", db.email
+			return nil
+		})
+	case strings.Contains(sql, "SELECT u.nombre, u.email, u.contrasena_hash"):
+		return scanFunc(func(dest ...interface{}) error {
+			*(dest[0].(*string)), *(dest[1].(*string)), *(dest[2].(*string)) = "Dra", db.email, db.hash
+			anteriores := db.historial
+			if n := args[1].(int); len(anteriores) > n {
+				anteriores = anteriores[:n]
+			}
+			*(dest[3].(*[]string)) = anteriores
+			return nil
+		})
+	case strings.Contains(sql, "SELECT nombre, email FROM usuarios"):
+		return scanFunc(func(dest ...interface{}) error {
+			*(dest[0].(*string)), *(dest[1].(*string)) = "Dra", db.email
+			return nil
+		})
+	case strings.Contains(sql, "SELECT nombre, email, email_verificado_en"):
+		return scanFunc(func(dest ...interface{}) error {
+			*(dest[0].(*string)), *(dest[1].(*string)) = "Dra", db.email
+			if db.verificado {
+				ahora := time.Now()
+				*(dest[2].(**time.Time)) = &ahora
+			}
+			return nil
+		})
+	case strings.Contains(sql, "SELECT COUNT(*) FROM usuarios_tokens"):
+		return scanFunc(func(dest ...interface{}) error {
+			n := 0
+			for _, t := range db.tokens {
+				if t.usuarioID == args[0] && t.tipo == args[1] && t.creado.After(args[2].(time.Time)) {
+					n++
+				}
+			}
+			*(dest[0].(*int)) = n
+			return nil
+		})
+	case strings.Contains(sql, "UPDATE usuarios_tokens t"):
+		return scanFunc(func(dest ...interface{}) error {
+			t, ok := db.tokens[args[0].(string)]
+			if !ok || t.tipo != args[1] || t.usado || !t.expira.After(args[2].(time.Time)) {
+				return pgx.ErrNoRows
+			}
+			t.usado = true
+			*(dest[0].(*uuid.UUID)), *(dest[1].(*string)) = t.usuarioID, t.email
+			return nil
+		})
+	}
+	// Cabeza de la cadena de auditoría vacía
+	return scanFunc(func(dest ...interface{}) error { return pgx.ErrNoRows })
+}
+
+// buzon guarda los mensajes enviados
+type buzon []mailer.Mensaje
+
+func (b *buzon) Enviar(ctx context.Context, m mailer.Mensaje) error {
+	*b = append(*b, m)
+	return nil
+}
+
+// tokenDelEnlace extrae el token del enlace del último mensaje
+func (b buzon) tokenDelEnlace(t *testing.T) string {
+	t.Helper()
+	if len(b) == 0 {
+		t.Fatal("No se envió ningún correo")
+	}
+	texto := b[len(b)-1].Texto
+	i := strings.Index(texto, "?token=")
+	if i < 0 {
+		t.Fatalf("El correo no tiene enlace: %s", texto)
+	}
+	return strings.Fields(texto[i+len("?token="):])[0]
+}
+
+func TestCuenta_RestablecerContrasena(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeCuentaDB{usuarioID: uuid.New(), email: "dra@example.com", intentos: 5, tokens: map[string]*tokenCuenta{}}
+	correo := &buzon{}
+	ahora := time.Now()
+	s := NewCuentaService(db, correo, "https://app.mediapp.com", zap.NewNop())
+	s.ahora = func() time.Time { return ahora }
+	s.segundoPlano = func(f func()) { f() }
+
+	// Un email desconocido no da error ni envía nada
+	if err := s.SolicitarRestablecimiento(ctx, "otro@example.com"); err != nil || len(*correo) != 0 {
+		t.Fatalf("No se esperaba correo: %v %v", err, *correo)
+	}
+	if err := s.SolicitarRestablecimiento(ctx, "DRA@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if (*correo)[0].Para != "dra@example.com" || !strings.Contains((*correo)[0].Texto, "https://app.mediapp.com/restablecer-contrasena?token=") {
+		t.Fatalf("Correo inesperado: %+v", (*correo)[0])
+	}
+	token := correo.tokenDelEnlace(t)
+	if _, ok := db.tokens[token]; ok {
+		t.Fatal("El token no debería guardarse en claro")
+	}
+
+	if _, err := s.Restablecer(ctx, "otro-token", "nueva-clave", audit.Entry{}); err != ErrTokenCuentaInvalido {
+		t.Errorf("Se esperaba ErrTokenCuentaInvalido, obtuvo %v", err)
+	}
+	// Un token de verificación no sirve para restablecer
+	if err := s.EnviarVerificacion(ctx, db.usuarioID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Restablecer(ctx, correo.tokenDelEnlace(t), "nueva-clave", audit.Entry{}); err != ErrTokenCuentaInvalido {
+		t.Errorf("Un token de verificación no debería restablecer, obtuvo %v", err)
+	}
+
+	id, err := s.Restablecer(ctx, token, "nueva-clave", audit.Entry{Accion: audit.AccionRestablecerContrasena})
+	if err != nil || id != db.usuarioID {
+		t.Fatalf("Se esperaba restablecer: %v", err)
+	}
+	if !security.CheckPasswordHash("nueva-clave", db.hash) || db.intentos != 0 || !db.verificado {
+		t.Errorf("Se esperaba contraseña nueva, intentos en cero y email verificado")
+	}
+	if len(db.auditorias) != 1 || db.auditorias[0] != audit.AccionRestablecerContrasena {
+		t.Errorf("Auditoría inesperada: %v", db.auditorias)
+	}
+	if _, err := s.Restablecer(ctx, token, "otra-clave", audit.Entry{}); err != ErrTokenCuentaInvalido {
+		t.Errorf("El token no debería servir dos veces, obtuvo %v", err)
+	}
+
+	// Un token vencido no sirve
+	s.SolicitarRestablecimiento(ctx, "dra@example.com")
+	token = correo.tokenDelEnlace(t)
+	s.ahora = func() time.Time { return ahora.Add(RestablecerTTL + time.Second) }
+	if _, err := s.Restablecer(ctx, token, "nueva-clave", audit.Entry{}); err != ErrTokenCuentaInvalido {
+		t.Errorf("Se esperaba el token vencido, obtuvo %v", err)
+	}
+
+	// Límite de correos por hora
+	s.ahora = func() time.Time { return ahora }
+	enviados := len(*correo)
+	for i := 0; i < tokensCuentaPorHora; i++ {
+		s.SolicitarRestablecimiento(ctx, "dra@example.com")
+	}
+	if len(*correo) != enviados+tokensCuentaPorHora-2 {
+		t.Errorf("Se esperaban %d correos más, hubo %d", tokensCuentaPorHora-2, len(*correo)-enviados)
+	}
+}
+
+func TestCuenta_VerificarEmail(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeCuentaDB{usuarioID: uuid.New(), email: "dra@example.com", tokens: map[string]*tokenCuenta{}}
+	correo := &buzon{}
+	s := NewCuentaService(db, correo, "https://app.mediapp.com", zap.NewNop())
+	s.segundoPlano = func(f func()) { f() }
+
+	if err := s.EnviarVerificacion(ctx, db.usuarioID); err != nil {
+		t.Fatal(err)
+	}
+	token := correo.tokenDelEnlace(t)
+
+	// Si el email cambió después del envío, el token ya no lo verifica
+	db.email = "nuevo@example.com"
+	if _, err := s.VerificarEmail(ctx, token, audit.Entry{}); err != ErrTokenCuentaInvalido || db.verificado {
+		t.Errorf("Se esperaba rechazar el token del email anterior, obtuvo %v", err)
+	}
+
+	db.email = "dra@example.com"
+	s.EnviarVerificacion(ctx, db.usuarioID)
+	if _, err := s.VerificarEmail(ctx, correo.tokenDelEnlace(t), audit.Entry{Accion: audit.AccionEmailVerificado}); err != nil || !db.verificado {
+		t.Fatalf("Se esperaba verificar el email: %v", err)
+	}
+}
+
+func TestCuenta_Invitacion(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeCuentaDB{usuarioID: uuid.New(), email: "dra@example.com", tokens: map[string]*tokenCuenta{}}
+	correo := &buzon{}
+	s := NewCuentaService(db, correo, "https://app.mediapp.com", zap.NewNop())
+	s.segundoPlano = func(f func()) { f() }
+
+	if err := s.Invitar(ctx, db.usuarioID); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains((*correo)[0].Texto, "https://app.mediapp.com/activar-cuenta?token=") {
+		t.Fatalf("Correo inesperado: %+v", (*correo)[0])
+	}
+	token := correo.tokenDelEnlace(t)
+	// La invitación no sirve como token de restablecimiento
+	if _, err := s.Restablecer(ctx, token, "clave-elegida", audit.Entry{}); err != ErrTokenCuentaInvalido {
+		t.Errorf("Se esperaba ErrTokenCuentaInvalido, obtuvo %v", err)
+	}
+	id, err := s.Activar(ctx, token, "clave-elegida", audit.Entry{Accion: audit.AccionActivarCuenta})
+	if err != nil || id != db.usuarioID {
+		t.Fatalf("Se esperaba activar la cuenta: %v", err)
+	}
+	if !security.CheckPasswordHash("clave-elegida", db.hash) || !db.verificado {
+		t.Error("Se esperaba la contraseña elegida y el email verificado")
+	}
+	if _, err := s.Activar(ctx, token, "otra-clave", audit.Entry{}); err != ErrTokenCuentaInvalido {
+		t.Errorf("La invitación no debería servir dos veces, obtuvo %v", err)
+	}
+}
+
+func TestCuenta_PoliticaEHistorial(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeCuentaDB{usuarioID: uuid.New(), email: "dra.perez@example.com", tokens: map[string]*tokenCuenta{}}
+	correo := &buzon{}
+	ahora := time.Now()
+	s := NewCuentaService(db, correo, "https://app.mediapp.com", zap.NewNop())
+	s.ahora = func() time.Time { return ahora }
+	s.segundoPlano = func(f func()) { f() }
+	politica := security.PoliticaPorDefecto()
+	politica.Historial = 2
+	s.SetPolitica(politica)
+
+	restablecer := func(password string) error {
+		t.Helper()
+		ahora = ahora.Add(2 * time.Hour)
+		if err := s.EnviarRestablecimiento(ctx, db.usuarioID); err != nil {
+			t.Fatal(err)
+		}
+		_, err := s.Restablecer(ctx, correo.tokenDelEnlace(t), password, audit.Entry{})
+		return err
+	}
+	rechazada := func(password, motivo string) {
+		t.Helper()
+		var errPolitica *security.ErrPolitica
+		err := restablecer(password)
+		if !errors.As(err, &errPolitica) || !strings.Contains(strings.Join(errPolitica.Motivos, "|"), motivo) {
+			t.Errorf("%q: se esperaba el motivo %q, obtuvo %v", password, motivo, err)
+		}
+	}
+
+	rechazada("corta", "al menos 8 caracteres")
+	rechazada("password123", "filtraciones")
+	rechazada("clave-de-dra.perez", "email")
+	for _, p := range []string{"primera-clave", "segunda-clave", "tercera-clave"} {
+		if err := restablecer(p); err != nil {
+			t.Fatalf("%q: %v", p, err)
+		}
+	}
+	// Se recuerdan las últimas 2: la actual y la anterior
+	rechazada("tercera-clave", "últimas 2")
+	rechazada("segunda-clave", "últimas 2")
+	if err := restablecer("primera-clave"); err != nil {
+		t.Errorf("Se esperaba poder repetir una contraseña fuera del historial: %v", err)
+	}
+	if len(db.historial) != 2 || !security.CheckPasswordHash("primera-clave", db.historial[0]) {
+		t.Errorf("Se esperaba conservar solo las últimas 2 en el historial, obtuvo %d", len(db.historial))
+	}
+}
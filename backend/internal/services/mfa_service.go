@@ -0,0 +1,434 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+const (
+	// MFAEmisor es el nombre con el que la cuenta aparece en la aplicación autenticadora
+	MFAEmisor = "MediApp"
+	// DesafioMFATTL es el tiempo para completar el segundo paso del login
+	DesafioMFATTL = 5 * time.Minute
+	// desafioMFAIntentos es la cantidad de códigos que se pueden probar con un desafío
+	desafioMFAIntentos = 5
+)
+
+// Métodos con los que se completa el segundo paso
+const (
+	MetodoMFATOTP         = "totp"
+	MetodoMFARecuperacion = "recuperacion"
+)
+
+var (
+	// ErrMFAActivo indica que el usuario ya tiene la autenticación en dos pasos confirmada
+	ErrMFAActivo = errors.New("la autenticación en dos pasos ya está activa")
+	// ErrMFANoIniciado indica que no hay un enrolamiento (pendiente o confirmado)
+	ErrMFANoIniciado = errors.New("la autenticación en dos pasos no está configurada")
+	// ErrMFACodigoInvalido indica un código TOTP o de recuperación incorrecto o ya usado
+	ErrMFACodigoInvalido = errors.New("código inválido")
+	// ErrMFARequerido indica que el rol del usuario no permite desactivar la autenticación en dos pasos
+	ErrMFARequerido = errors.New("el rol del usuario requiere autenticación en dos pasos")
+	// ErrDesafioMFAInvalido indica un desafío inexistente, vencido o sin intentos restantes
+	ErrDesafioMFAInvalido = errors.New("desafío inválido o vencido")
+)
+
+// MFADB define lo mínimo que MFAService necesita de la base de datos
+type MFADB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// EnrolamientoMFA es el secreto recién generado, para cargar en la aplicación autenticadora.
+// URI es la otpauth:// que el cliente muestra como código QR.
+type EnrolamientoMFA struct {
+	Secreto string `json:"secreto"`
+	URI     string `json:"uri"`
+}
+
+// EstadoMFA resume la configuración de dos pasos de un usuario
+type EstadoMFA struct {
+	Activo           bool `json:"activo"`
+	Pendiente        bool `json:"pendiente"`
+	Requerido        bool `json:"requerido"`
+	CodigosRestantes int  `json:"codigos_recuperacion_restantes"`
+}
+
+// DesafioMFA es el token de corta duración que entrega el paso de la contraseña
+type DesafioMFA struct {
+	Token    string
+	ExpiraEn time.Time
+}
+
+type registroDesafio struct {
+	UsuarioID string    `json:"usuario_id"`
+	Intentos  int       `json:"intentos"`
+	ExpiraEn  time.Time `json:"expira_en"`
+}
+
+// MFAService administra la autenticación en dos pasos con TOTP: enrolamiento, códigos de
+// recuperación (usuarios_mfa y usuarios_mfa_recuperacion) y los desafíos del login, que
+// viven en Redis como mfa_desafio:<hash> hasta que se usan o vencen.
+type MFAService struct {
+	db     MFADB
+	cipher *security.FieldCipher
+	kv     kvStore
+	logger *zap.Logger
+	ahora  func() time.Time
+}
+
+// NewMFAService crea el servicio. Los secretos TOTP se cifran con cipher.
+func NewMFAService(db MFADB, cipher *security.FieldCipher, client *redis.Client, logger *zap.Logger) *MFAService {
+	return &MFAService{db: db, cipher: cipher, kv: redisKV{client: client}, logger: logger, ahora: time.Now}
+}
+
+// Estado devuelve la configuración de dos pasos del usuario
+func (s *MFAService) Estado(ctx context.Context, usuarioID uuid.UUID) (EstadoMFA, error) {
+	var e EstadoMFA
+	var confirmado, pendiente bool
+	err := s.db.QueryRow(ctx, `
+		SELECT COALESCE(r.mfa_requerido, false),
+			   COALESCE(m.confirmado_en IS NOT NULL, false),
+			   COALESCE(m.confirmado_en IS NULL AND m.usuario_id IS NOT NULL, false),
+			   (SELECT COUNT(*) FROM usuarios_mfa_recuperacion c WHERE c.usuario_id = u.id AND c.usado_en IS NULL)
+		FROM usuarios u
+		LEFT JOIN roles r ON r.id = u.rol_id
+		LEFT JOIN usuarios_mfa m ON m.usuario_id = u.id
+		WHERE u.id = $1
+	`, usuarioID).Scan(&e.Requerido, &confirmado, &pendiente, &e.CodigosRestantes)
+	e.Activo, e.Pendiente = confirmado, pendiente
+	return e, err
+}
+
+// Iniciar genera un secreto nuevo para el usuario. El enrolamiento queda pendiente hasta
+// que Confirmar reciba un código válido; reiniciarlo reemplaza el secreto anterior.
+func (s *MFAService) Iniciar(ctx context.Context, usuarioID uuid.UUID) (EnrolamientoMFA, error) {
+	// La cuenta es el email, que es lo que el usuario reconoce en la aplicación autenticadora
+	var cuenta string
+	if err := s.db.QueryRow(ctx, `SELECT email FROM usuarios WHERE id = $1`, usuarioID).Scan(&cuenta); err != nil {
+		return EnrolamientoMFA{}, err
+	}
+	secreto, err := security.GenerarSecretoTOTP()
+	if err != nil {
+		return EnrolamientoMFA{}, err
+	}
+	cifrado, err := s.cipher.Encrypt(ctx, "usuarios_mfa", usuarioID.String(), "secreto", secreto)
+	if err != nil {
+		return EnrolamientoMFA{}, err
+	}
+	res, err := s.db.Exec(ctx, `
+		INSERT INTO usuarios_mfa (usuario_id, secreto_cifrado)
+		VALUES ($1, $2)
+		ON CONFLICT (usuario_id) DO UPDATE
+		SET secreto_cifrado = EXCLUDED.secreto_cifrado, ultimo_paso = 0, creado_en = NOW()
+		WHERE usuarios_mfa.confirmado_en IS NULL
+	`, usuarioID, cifrado)
+	if err != nil {
+		return EnrolamientoMFA{}, err
+	}
+	if res.RowsAffected() == 0 {
+		return EnrolamientoMFA{}, ErrMFAActivo
+	}
+	return EnrolamientoMFA{
+		Secreto: security.SecretoTOTPBase32(secreto),
+		URI:     security.URITOTP(MFAEmisor, cuenta, secreto),
+	}, nil
+}
+
+// Confirmar activa el enrolamiento pendiente si codigo es válido y devuelve los códigos de
+// recuperación, que solo se muestran esta vez. La auditoría entry se escribe en la misma
+// transacción.
+func (s *MFAService) Confirmar(ctx context.Context, usuarioID uuid.UUID, codigo string, entry audit.Entry) ([]string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var cifrado []byte
+	var confirmado *time.Time
+	var ultimoPaso int64
+	err = tx.QueryRow(ctx, `
+		SELECT secreto_cifrado, confirmado_en, ultimo_paso FROM usuarios_mfa WHERE usuario_id = $1 FOR UPDATE
+	`, usuarioID).Scan(&cifrado, &confirmado, &ultimoPaso)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMFANoIniciado
+	} else if err != nil {
+		return nil, err
+	}
+	if confirmado != nil {
+		return nil, ErrMFAActivo
+	}
+	secreto, err := s.cipher.Decrypt(ctx, "usuarios_mfa", usuarioID.String(), "secreto", cifrado)
+	if err != nil {
+		return nil, err
+	}
+	paso, ok := security.VerificarTOTP(secreto, codigo, s.ahora(), ultimoPaso)
+	if !ok {
+		return nil, ErrMFACodigoInvalido
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE usuarios_mfa SET confirmado_en = NOW(), ultimo_paso = $2 WHERE usuario_id = $1
+	`, usuarioID, paso); err != nil {
+		return nil, err
+	}
+	codigos, err := reemplazarCodigos(ctx, tx, usuarioID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.Write(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	return codigos, tx.Commit(ctx)
+}
+
+// Verificar comprueba un código TOTP o, si no tiene ese formato, un código de recuperación,
+// que queda usado. Devuelve el método con el que se verificó.
+func (s *MFAService) Verificar(ctx context.Context, usuarioID uuid.UUID, codigo string) (string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	metodo, err := s.verificar(ctx, tx, usuarioID, codigo)
+	if err != nil {
+		return "", err
+	}
+	return metodo, tx.Commit(ctx)
+}
+
+func (s *MFAService) verificar(ctx context.Context, tx pgx.Tx, usuarioID uuid.UUID, codigo string) (string, error) {
+	var cifrado []byte
+	var ultimoPaso int64
+	err := tx.QueryRow(ctx, `
+		SELECT secreto_cifrado, ultimo_paso FROM usuarios_mfa
+		WHERE usuario_id = $1 AND confirmado_en IS NOT NULL
+		FOR UPDATE
+	`, usuarioID).Scan(&cifrado, &ultimoPaso)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrMFANoIniciado
+	} else if err != nil {
+		return "", err
+	}
+
+	if !esCodigoTOTP(codigo) {
+		res, err := tx.Exec(ctx, `
+			UPDATE usuarios_mfa_recuperacion SET usado_en = NOW()
+			WHERE usuario_id = $1 AND codigo_hash = $2 AND usado_en IS NULL
+		`, usuarioID, security.HashCodigoRecuperacion(codigo))
+		if err != nil {
+			return "", err
+		}
+		if res.RowsAffected() == 0 {
+			return "", ErrMFACodigoInvalido
+		}
+		return MetodoMFARecuperacion, nil
+	}
+
+	secreto, err := s.cipher.Decrypt(ctx, "usuarios_mfa", usuarioID.String(), "secreto", cifrado)
+	if err != nil {
+		return "", err
+	}
+	paso, ok := security.VerificarTOTP(secreto, codigo, s.ahora(), ultimoPaso)
+	if !ok {
+		return "", ErrMFACodigoInvalido
+	}
+	// Aprovechar para pasar el secreto a la versión vigente de la clave maestra, así una
+	// rotación no deja secretos atados a la clave anterior
+	nuevo := cifrado
+	if s.desactualizado(ctx, cifrado) {
+		if recifrado, err := s.cipher.Reencrypt(ctx, "usuarios_mfa", usuarioID.String(), "secreto", cifrado); err == nil {
+			nuevo = recifrado
+		} else {
+			s.logger.Warn("No se pudo recifrar el secreto TOTP", zap.String("user_id", usuarioID.String()), zap.Error(err))
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE usuarios_mfa SET ultimo_paso = $2, secreto_cifrado = $3 WHERE usuario_id = $1
+	`, usuarioID, paso, nuevo); err != nil {
+		return "", err
+	}
+	return MetodoMFATOTP, nil
+}
+
+func (s *MFAService) desactualizado(ctx context.Context, cifrado []byte) bool {
+	version, err := security.KeyVersion(cifrado)
+	if err != nil {
+		return false
+	}
+	actual, err := s.cipher.CurrentVersion(ctx)
+	return err == nil && version != actual
+}
+
+// RegenerarRecuperacion reemplaza los códigos de recuperación, previa verificación de
+// codigo, y devuelve los nuevos
+func (s *MFAService) RegenerarRecuperacion(ctx context.Context, usuarioID uuid.UUID, codigo string, entry audit.Entry) ([]string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := s.verificar(ctx, tx, usuarioID, codigo); err != nil {
+		return nil, err
+	}
+	codigos, err := reemplazarCodigos(ctx, tx, usuarioID)
+	if err != nil {
+		return nil, err
+	}
+	if err := audit.Write(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	return codigos, tx.Commit(ctx)
+}
+
+// Desactivar quita la autenticación en dos pasos, previa verificación de codigo. No se
+// permite si el rol del usuario la requiere.
+func (s *MFAService) Desactivar(ctx context.Context, usuarioID uuid.UUID, codigo string, entry audit.Entry) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var requerido bool
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE(r.mfa_requerido, false)
+		FROM usuarios u LEFT JOIN roles r ON r.id = u.rol_id
+		WHERE u.id = $1
+	`, usuarioID).Scan(&requerido)
+	if err != nil {
+		return err
+	}
+	if requerido {
+		return ErrMFARequerido
+	}
+	if _, err := s.verificar(ctx, tx, usuarioID, codigo); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM usuarios_mfa_recuperacion WHERE usuario_id = $1`, usuarioID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM usuarios_mfa WHERE usuario_id = $1`, usuarioID); err != nil {
+		return err
+	}
+	if err := audit.Write(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func reemplazarCodigos(ctx context.Context, tx pgx.Tx, usuarioID uuid.UUID) ([]string, error) {
+	codigos, err := security.GenerarCodigosRecuperacion()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM usuarios_mfa_recuperacion WHERE usuario_id = $1`, usuarioID); err != nil {
+		return nil, err
+	}
+	for _, c := range codigos {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO usuarios_mfa_recuperacion (usuario_id, codigo_hash) VALUES ($1, $2)
+		`, usuarioID, security.HashCodigoRecuperacion(c)); err != nil {
+			return nil, err
+		}
+	}
+	return codigos, nil
+}
+
+// esCodigoTOTP distingue un código TOTP (seis dígitos) de uno de recuperación
+func esCodigoTOTP(codigo string) bool {
+	codigo = strings.ReplaceAll(strings.TrimSpace(codigo), " ", "")
+	if len(codigo) != 6 {
+		return false
+	}
+	for _, r := range codigo {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// CrearDesafio emite el token que habilita el segundo paso del login de usuarioID
+func (s *MFAService) CrearDesafio(ctx context.Context, usuarioID string) (DesafioMFA, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return DesafioMFA{}, err
+	}
+	d := DesafioMFA{Token: base64.RawURLEncoding.EncodeToString(b), ExpiraEn: s.ahora().Add(DesafioMFATTL)}
+	if err := s.guardarDesafio(ctx, d.Token, registroDesafio{UsuarioID: usuarioID, ExpiraEn: d.ExpiraEn}); err != nil {
+		return DesafioMFA{}, err
+	}
+	return d, nil
+}
+
+// ResolverDesafio devuelve el usuario del desafío
+func (s *MFAService) ResolverDesafio(ctx context.Context, token string) (string, error) {
+	reg, err := s.desafio(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	return reg.UsuarioID, nil
+}
+
+// FalloDesafio registra un código incorrecto; agotados los intentos el desafío se descarta
+// y hay que volver a ingresar la contraseña
+func (s *MFAService) FalloDesafio(ctx context.Context, token string) error {
+	reg, err := s.desafio(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrDesafioMFAInvalido) {
+			return nil
+		}
+		return err
+	}
+	reg.Intentos++
+	if reg.Intentos >= desafioMFAIntentos {
+		return s.ConsumirDesafio(ctx, token)
+	}
+	return s.guardarDesafio(ctx, token, reg)
+}
+
+// ConsumirDesafio descarta el desafío una vez completado el login
+func (s *MFAService) ConsumirDesafio(ctx context.Context, token string) error {
+	return s.kv.del(ctx, "mfa_desafio:"+hashToken(token))
+}
+
+func (s *MFAService) desafio(ctx context.Context, token string) (registroDesafio, error) {
+	raw, ok, err := s.kv.get(ctx, "mfa_desafio:"+hashToken(token))
+	if err != nil {
+		return registroDesafio{}, err
+	}
+	var reg registroDesafio
+	if !ok || json.Unmarshal([]byte(raw), &reg) != nil || !s.ahora().Before(reg.ExpiraEn) {
+		return registroDesafio{}, ErrDesafioMFAInvalido
+	}
+	return reg, nil
+}
+
+func (s *MFAService) guardarDesafio(ctx context.Context, token string, reg registroDesafio) error {
+	ttl := reg.ExpiraEn.Sub(s.ahora())
+	if ttl <= 0 {
+		return s.ConsumirDesafio(ctx, token)
+	}
+	raw, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return s.kv.set(ctx, "mfa_desafio:"+hashToken(token), string(raw), ttl)
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// fakeMFADB simula usuarios_mfa y usuarios_mfa_recuperacion para un único usuario
+type fakeMFADB struct {
+	secreto    []byte
+	confirmado bool
+	ultimoPaso int64
+	// codigos guarda hash -> usado
+	codigos    map[string]bool
+	auditorias []string
+}
+
+type fakeMFATx struct {
+	pgx.Tx
+	db *fakeMFADB
+}
+
+func (db *fakeMFADB) Begin(ctx context.Context) (pgx.Tx, error) { return &fakeMFATx{db: db}, nil }
+
+func (tx *fakeMFATx) Commit(ctx context.Context) error   { return nil }
+func (tx *fakeMFATx) Rollback(ctx context.Context) error { return nil }
+func (tx *fakeMFATx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return tx.db.Exec(ctx, sql, args...)
+}
+func (tx *fakeMFATx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return tx.db.QueryRow(ctx, sql, args...)
+}
+
+func (db *fakeMFADB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	switch {
+	case strings.Contains(sql, "INSERT INTO usuarios_mfa ("):
+		if db.confirmado {
+			return pgconn.NewCommandTag("INSERT 0 0"), nil
+		}
+		db.secreto, db.ultimoPaso = args[1].([]byte), 0
+	case strings.Contains(sql, "SET confirmado_en"):
+		db.confirmado, db.ultimoPaso = true, args[1].(int64)
+	case strings.Contains(sql, "SET ultimo_paso"):
+		db.ultimoPaso, db.secreto = args[1].(int64), args[2].([]byte)
+	case strings.Contains(sql, "DELETE FROM usuarios_mfa_recuperacion"):
+		db.codigos = map[string]bool{}
+	case strings.Contains(sql, "INSERT INTO usuarios_mfa_recuperacion"):
+		db.codigos[args[1].(string)] = false
+	case strings.Contains(sql, "UPDATE usuarios_mfa_recuperacion"):
+		usado, ok := db.codigos[args[1].(string)]
+		if !ok || usado {
+			return pgconn.NewCommandTag("UPDATE 0"), nil
+		}
+		db.codigos[args[1].(string)] = true
+	case strings.Contains(sql, "INSERT INTO auditorias"):
+		db.auditorias = append(db.auditorias, args[1].(string))
+	case strings.Contains(sql, "pg_advisory_xact_lock"):
+	default:
+		return pgconn.CommandTag{}, errors.New("exec inesperado: " + sql)
+	}
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (db *fakeMFADB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	switch {
+	case strings.Contains(sql, "SELECT email"):
+		return scanFunc(func(dest ...interface{}) error {
+			*(dest[0].(*string)) = "dra@example.com"
+			return nil
+		})
+	case strings.Contains(sql, "SELECT secreto_cifrado, confirmado_en"):
+		return scanFunc(func(dest ...interface{}) error {
+			if db.secreto == nil {
+				return pgx.ErrNoRows
+			}
+			*(dest[0].(*[]byte)) = db.secreto
+			if db.confirmado {
+				ahora := time.Now()
+				*(dest[1].(**time.Time)) = &ahora
+			}
+			*(dest[2].(*int64)) = db.ultimoPaso
+			return nil
+		})
+	case strings.Contains(sql, "SELECT secreto_cifrado, ultimo_paso"):
+		return scanFunc(func(dest ...interface{}) error {
+			if !db.confirmado {
+				return pgx.ErrNoRows
+			}
+			*(dest[0].(*[]byte)), *(dest[1].(*int64)) = db.secreto, db.ultimoPaso
+			return nil
+		})
+	}
+	// Cabeza de la cadena de auditoría vacía
+	return scanFunc(func(dest ...interface{}) error { return pgx.ErrNoRows })
+}
+
+// TestMFA_EnrolaYVerifica recorre el enrolamiento, el uso de códigos TOTP (sin reutilización)
+// y de recuperación (de un solo uso)
+func TestMFA_EnrolaYVerifica(t *testing.T) {
+	ctx := context.Background()
+	cipher, _ := security.NewFieldCipher(nuevaClave(t, 1), make([]byte, security.MinIndexKeySize))
+	db := &fakeMFADB{codigos: map[string]bool{}}
+	ahora := time.Now()
+	s := &MFAService{db: db, cipher: cipher, kv: memKV{}, logger: zap.NewNop(), ahora: func() time.Time { return ahora }}
+	id := uuid.New()
+
+	if _, err := s.Verificar(ctx, id, "123456"); err != ErrMFANoIniciado {
+		t.Fatalf("Se esperaba ErrMFANoIniciado, obtuvo %v", err)
+	}
+	enrolamiento, err := s.Iniciar(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(enrolamiento.URI, "MediApp:dra@example.com") {
+		t.Errorf("URI inesperada: %s", enrolamiento.URI)
+	}
+	secreto, _ := cipher.Decrypt(ctx, "usuarios_mfa", id.String(), "secreto", db.secreto)
+	if security.SecretoTOTPBase32(secreto) != enrolamiento.Secreto {
+		t.Fatal("El secreto guardado no coincide con el entregado")
+	}
+
+	if _, err := s.Confirmar(ctx, id, "000000", audit.Entry{}); err != ErrMFACodigoInvalido {
+		t.Errorf("Se esperaba ErrMFACodigoInvalido, obtuvo %v", err)
+	}
+	anterior := security.CodigoTOTP(secreto, security.PasoTOTP(ahora)-1)
+	codigos, err := s.Confirmar(ctx, id, anterior, audit.Entry{Accion: audit.AccionMFAActivado})
+	if err != nil || len(codigos) != security.CodigosRecuperacionCantidad || len(db.auditorias) != 1 {
+		t.Fatalf("Se esperaba confirmar con códigos y auditoría: %v %v", err, db.auditorias)
+	}
+	if _, err := s.Iniciar(ctx, id); err != ErrMFAActivo {
+		t.Errorf("Se esperaba ErrMFAActivo al reiniciar, obtuvo %v", err)
+	}
+
+	// El código usado para confirmar no vuelve a servir, el siguiente sí
+	if _, err := s.Verificar(ctx, id, anterior); err != ErrMFACodigoInvalido {
+		t.Errorf("Un código ya usado no debería aceptarse, obtuvo %v", err)
+	}
+	actual := security.CodigoTOTP(secreto, security.PasoTOTP(ahora))
+	if metodo, err := s.Verificar(ctx, id, actual); err != nil || metodo != MetodoMFATOTP {
+		t.Errorf("Se esperaba verificar el código actual: %v", err)
+	}
+
+	if metodo, err := s.Verificar(ctx, id, strings.ToUpper(codigos[0])); err != nil || metodo != MetodoMFARecuperacion {
+		t.Errorf("Se esperaba aceptar un código de recuperación: %v", err)
+	}
+	if _, err := s.Verificar(ctx, id, codigos[0]); err != ErrMFACodigoInvalido {
+		t.Errorf("Un código de recuperación no debería servir dos veces, obtuvo %v", err)
+	}
+}
+
+// TestMFA_DesafioAgotaIntentos verifica que un desafío se descarta tras varios códigos
+// incorrectos
+func TestMFA_DesafioAgotaIntentos(t *testing.T) {
+	ctx := context.Background()
+	s := &MFAService{kv: memKV{}, logger: zap.NewNop(), ahora: time.Now}
+
+	d, err := s.CrearDesafio(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usuario, err := s.ResolverDesafio(ctx, d.Token); err != nil || usuario != "u1" {
+		t.Fatalf("Se esperaba resolver el desafío: %v", err)
+	}
+	for i := 0; i < desafioMFAIntentos; i++ {
+		if err := s.FalloDesafio(ctx, d.Token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.ResolverDesafio(ctx, d.Token); err != ErrDesafioMFAInvalido {
+		t.Errorf("Se esperaba el desafío descartado, obtuvo %v", err)
+	}
+
+	// Un desafío vencido no sirve aunque siga en Redis
+	d, _ = s.CrearDesafio(ctx, "u1")
+	s.ahora = func() time.Time { return time.Now().Add(DesafioMFATTL + time.Second) }
+	if _, err := s.ResolverDesafio(ctx, d.Token); err != ErrDesafioMFAInvalido {
+		t.Errorf("Se esperaba el desafío vencido, obtuvo %v", err)
+	}
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/audit"
+	"github.com/FolkodeGroup/mediapp/internal/auth"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// CodigoOAuthTTL es el tiempo para canjear un código de autorización
+const CodigoOAuthTTL = 2 * time.Minute
+
+var (
+	// ErrClienteOAuthInvalido indica un cliente OAuth inexistente o revocado
+	ErrClienteOAuthInvalido = errors.New("cliente OAuth inexistente o revocado")
+	// ErrGrantInvalido indica un código o refresh token inexistente, vencido, ya usado o
+	// emitido a otro cliente (invalid_grant de RFC 6749)
+	ErrGrantInvalido = errors.New("código o refresh token inválido")
+)
+
+// OAuthDB es la parte del pool que usa OAuthService
+type OAuthDB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// ClienteOAuth es una app registrada que autentica usuarios contra MediApp (tabla
+// oauth_clientes). Los clientes públicos (app móvil, SPA) no tienen secreto; todos usan PKCE.
+type ClienteOAuth struct {
+	ClientID     string
+	Nombre       string
+	RedirectURIs []string
+	Scopes       []string
+	secretoHash  *string
+}
+
+// Confidencial indica si el cliente debe autenticarse con su secreto en /oauth/token
+func (c ClienteOAuth) Confidencial() bool { return c.secretoHash != nil }
+
+// RedirectURIValida indica si uri es una de las registradas; la comparación es exacta
+func (c ClienteOAuth) RedirectURIValida(uri string) bool {
+	for _, registrada := range c.RedirectURIs {
+		if uri == registrada {
+			return true
+		}
+	}
+	return false
+}
+
+// VerificarSecreto compara secreto con el del cliente. Un cliente público no tiene secreto
+// y no acepta ninguno.
+func (c ClienteOAuth) VerificarSecreto(secreto string) bool {
+	if c.secretoHash == nil || secreto == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(*c.secretoHash), []byte(hashToken(secreto))) == 1
+}
+
+// Autorizacion es lo que el usuario aprobó para un cliente: viaja con el código y después
+// con la familia de refresh tokens que sale de canjearlo
+type Autorizacion struct {
+	ClientID      string    `json:"client_id"`
+	UsuarioID     string    `json:"usuario_id"`
+	RedirectURI   string    `json:"redirect_uri,omitempty"`
+	Scopes        []string  `json:"scopes"`
+	Nonce         string    `json:"nonce,omitempty"`
+	CodeChallenge string    `json:"code_challenge,omitempty"`
+	AutenticadoEn time.Time `json:"autenticado_en"`
+}
+
+// OAuthService guarda los clientes y consentimientos del proveedor OpenID Connect en la
+// base, y los códigos de autorización en Redis.
+//
+// Claves:
+//   - oauth_codigo:<hash> con la Autorizacion, hasta CodigoOAuthTTL
+//   - oauth_codigo_usado:<hash> marca el código canjeado y guarda la familia de refresh
+//     tokens que se emitió con él: un segundo canje la revoca (RFC 6749, sección 4.1.2)
+//   - oauth_familia:<familia> es la Autorizacion de una familia de refresh tokens emitida
+//     por /oauth/token, para renovarla solo desde el mismo cliente
+type OAuthService struct {
+	db      OAuthDB
+	kv      kvStore
+	refresh *RefreshTokenService
+	logger  *zap.Logger
+}
+
+// NewOAuthService crea el servicio. Las familias de refresh tokens las emite refresh, así
+// que las sesiones de las apps se ven y se cierran como cualquier otra.
+func NewOAuthService(db OAuthDB, client *redis.Client, refresh *RefreshTokenService, logger *zap.Logger) *OAuthService {
+	return &OAuthService{db: db, kv: redisKV{client: client}, refresh: refresh, logger: logger}
+}
+
+// GenerarClienteOAuth crea un client_id y, para un cliente confidencial, su secreto. El
+// secreto se muestra una sola vez; se guarda su hash.
+func GenerarClienteOAuth(confidencial bool) (clientID, secreto, hash string, err error) {
+	b := make([]byte, 16+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	clientID = hex.EncodeToString(b[:16])
+	if confidencial {
+		secreto = base64.RawURLEncoding.EncodeToString(b[16:])
+		hash = hashToken(secreto)
+	}
+	return clientID, secreto, hash, nil
+}
+
+// Cliente busca un cliente vigente
+func (s *OAuthService) Cliente(ctx context.Context, clientID string) (ClienteOAuth, error) {
+	var c ClienteOAuth
+	err := s.db.QueryRow(ctx, `
+		SELECT client_id, nombre, redirect_uris, scopes, secreto_hash
+		FROM oauth_clientes WHERE client_id = $1 AND revocado_en IS NULL
+	`, clientID).Scan(&c.ClientID, &c.Nombre, &c.RedirectURIs, &c.Scopes, &c.secretoHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ClienteOAuth{}, ErrClienteOAuthInvalido
+	}
+	return c, err
+}
+
+// Consentimiento devuelve los scopes que usuarioID ya autorizó al cliente (ninguno si
+// nunca lo autorizó)
+func (s *OAuthService) Consentimiento(ctx context.Context, usuarioID, clientID string) ([]string, error) {
+	var scopes []string
+	err := s.db.QueryRow(ctx, `
+		SELECT scopes FROM oauth_consentimientos WHERE usuario_id = $1 AND client_id = $2
+	`, usuarioID, clientID).Scan(&scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return scopes, err
+}
+
+// Consentir registra que usuarioID autorizó scopes al cliente, que se suman a los
+// anteriores, y escribe entry en la auditoría en la misma transacción
+func (s *OAuthService) Consentir(ctx context.Context, usuarioID, clientID string, scopes []string, entry audit.Entry) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO oauth_consentimientos (usuario_id, client_id, scopes, otorgado_en)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (usuario_id, client_id) DO UPDATE
+		SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_consentimientos.scopes || EXCLUDED.scopes)),
+			otorgado_en = NOW()
+	`, usuarioID, clientID, scopes); err != nil {
+		return err
+	}
+	if err := audit.Write(ctx, tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// EmitirCodigo crea el código de autorización de a, de un solo uso
+func (s *OAuthService) EmitirCodigo(ctx context.Context, a Autorizacion) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	codigo := base64.RawURLEncoding.EncodeToString(b)
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	if err := s.kv.set(ctx, "oauth_codigo:"+hashToken(codigo), string(raw), CodigoOAuthTTL); err != nil {
+		return "", err
+	}
+	return codigo, nil
+}
+
+// Canjear canjea el código por una familia nueva de refresh tokens. El código sirve una
+// sola vez aunque el canje falle; si se presenta otra vez se revoca lo emitido con él.
+// clientID, redirectURI y verifier deben coincidir con los de la autorización.
+func (s *OAuthService) Canjear(ctx context.Context, codigo, clientID, redirectURI, verifier string) (Autorizacion, RefreshToken, error) {
+	hash := hashToken(codigo)
+	raw, ok, err := s.kv.get(ctx, "oauth_codigo:"+hash)
+	if err != nil {
+		return Autorizacion{}, RefreshToken{}, err
+	}
+	if !ok {
+		return Autorizacion{}, RefreshToken{}, s.reutilizado(ctx, hash)
+	}
+	primero, err := s.kv.setNX(ctx, "oauth_codigo_usado:"+hash, "", CodigoOAuthTTL)
+	if err != nil {
+		return Autorizacion{}, RefreshToken{}, err
+	}
+	if !primero {
+		return Autorizacion{}, RefreshToken{}, s.reutilizado(ctx, hash)
+	}
+	if err := s.kv.del(ctx, "oauth_codigo:"+hash); err != nil {
+		return Autorizacion{}, RefreshToken{}, err
+	}
+
+	var a Autorizacion
+	if err := json.Unmarshal([]byte(raw), &a); err != nil ||
+		a.ClientID != clientID || a.RedirectURI != redirectURI || !auth.VerifyPKCE(verifier, a.CodeChallenge) {
+		return Autorizacion{}, RefreshToken{}, ErrGrantInvalido
+	}
+
+	rt, err := s.refresh.Emitir(ctx, a.UsuarioID)
+	if err != nil {
+		return Autorizacion{}, RefreshToken{}, err
+	}
+	if err := s.vincular(ctx, rt.Familia, a); err != nil {
+		return Autorizacion{}, RefreshToken{}, err
+	}
+	if err := s.kv.set(ctx, "oauth_codigo_usado:"+hash, rt.Familia, CodigoOAuthTTL); err != nil {
+		return Autorizacion{}, RefreshToken{}, err
+	}
+	return a, rt, nil
+}
+
+// reutilizado revoca la familia emitida con un código ya canjeado, si la hay
+func (s *OAuthService) reutilizado(ctx context.Context, hash string) error {
+	familia, ok, err := s.kv.get(ctx, "oauth_codigo_usado:"+hash)
+	if err != nil {
+		return err
+	}
+	if ok && familia != "" {
+		if err := s.refresh.RevocarFamilia(ctx, familia); err != nil {
+			return err
+		}
+		s.logger.Warn("Código de autorización reutilizado, familia revocada", zap.String("familia", familia))
+	}
+	return ErrGrantInvalido
+}
+
+// Renovar rota el refresh token de una familia emitida al cliente clientID. Un token de
+// otro cliente o de un login directo no se acepta, y su familia se revoca.
+func (s *OAuthService) Renovar(ctx context.Context, token, clientID string) (Autorizacion, RefreshToken, error) {
+	rt, err := s.refresh.Rotar(ctx, token)
+	if errors.Is(err, ErrRefreshInvalido) || errors.Is(err, ErrRefreshReutilizado) || errors.Is(err, ErrRefreshRevocado) {
+		return Autorizacion{}, RefreshToken{}, ErrGrantInvalido
+	} else if err != nil {
+		return Autorizacion{}, RefreshToken{}, err
+	}
+
+	raw, ok, err := s.kv.get(ctx, "oauth_familia:"+rt.Familia)
+	if err != nil {
+		return Autorizacion{}, RefreshToken{}, err
+	}
+	var a Autorizacion
+	if !ok || json.Unmarshal([]byte(raw), &a) != nil || a.ClientID != clientID || a.UsuarioID != rt.UsuarioID {
+		if err := s.refresh.RevocarFamilia(ctx, rt.Familia); err != nil {
+			return Autorizacion{}, RefreshToken{}, err
+		}
+		return Autorizacion{}, RefreshToken{}, ErrGrantInvalido
+	}
+	if err := s.vincular(ctx, rt.Familia, a); err != nil {
+		return Autorizacion{}, RefreshToken{}, err
+	}
+	return a, rt, nil
+}
+
+// vincular asocia la familia de refresh tokens a la autorización; vence con la familia
+func (s *OAuthService) vincular(ctx context.Context, familia string, a Autorizacion) error {
+	a.RedirectURI, a.CodeChallenge, a.Nonce = "", "", ""
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return s.kv.set(ctx, "oauth_familia:"+familia, string(raw), s.refresh.ttl)
+}
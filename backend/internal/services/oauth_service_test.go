@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestOAuth_CanjeYRenovacion verifica que el código es de un solo uso y exige el cliente,
+// la redirect_uri y el verifier de PKCE, y que los refresh tokens solo se renuevan desde
+// la app a la que se emitieron
+func TestOAuth_CanjeYRenovacion(t *testing.T) {
+	ctx := context.Background()
+	kv := memKV{}
+	refresh := &RefreshTokenService{kv: kv, ttl: time.Hour, logger: zap.NewNop()}
+	s := &OAuthService{kv: kv, refresh: refresh, logger: zap.NewNop()}
+
+	verifier := "dBjftJeZ4CVP-mJ92K9qV5p7R3BAWzBiEb4UuPVVAr0"
+	a := Autorizacion{
+		ClientID:      "portal",
+		UsuarioID:     "u1",
+		RedirectURI:   "https://portal.mediapp.test/callback",
+		Scopes:        []string{"openid", "email"},
+		Nonce:         "n-0S6_WzA2Mj",
+		CodeChallenge: "lJf1Eh9TKpIAo6CP3Qheaa3ypVj5G9PqvAOmaBuQYew",
+	}
+	emitir := func() string {
+		codigo, err := s.EmitirCodigo(ctx, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return codigo
+	}
+
+	// Un canje fallido consume el código
+	codigo := emitir()
+	if _, _, err := s.Canjear(ctx, codigo, "portal", a.RedirectURI, verifier[:42]+"x"); !errors.Is(err, ErrGrantInvalido) {
+		t.Errorf("Se esperaba rechazar otro verifier, obtuvo %v", err)
+	}
+	if _, _, err := s.Canjear(ctx, codigo, "portal", a.RedirectURI, verifier); !errors.Is(err, ErrGrantInvalido) {
+		t.Errorf("Se esperaba rechazar un código ya usado, obtuvo %v", err)
+	}
+	if _, _, err := s.Canjear(ctx, emitir(), "otra-app", a.RedirectURI, verifier); !errors.Is(err, ErrGrantInvalido) {
+		t.Errorf("Se esperaba rechazar otro cliente, obtuvo %v", err)
+	}
+	if _, _, err := s.Canjear(ctx, emitir(), "portal", "https://evil.test/callback", verifier); !errors.Is(err, ErrGrantInvalido) {
+		t.Errorf("Se esperaba rechazar otra redirect_uri, obtuvo %v", err)
+	}
+
+	codigo = emitir()
+	got, rt, err := s.Canjear(ctx, codigo, "portal", a.RedirectURI, verifier)
+	if err != nil || got.UsuarioID != "u1" || got.Nonce != a.Nonce || rt.Token == "" {
+		t.Fatalf("Se esperaba canjear el código: %+v %v", got, err)
+	}
+	// Presentar otra vez el código revoca lo emitido con él
+	if _, _, err := s.Canjear(ctx, codigo, "portal", a.RedirectURI, verifier); !errors.Is(err, ErrGrantInvalido) {
+		t.Errorf("Se esperaba rechazar el código reutilizado, obtuvo %v", err)
+	}
+	if _, err := refresh.Rotar(ctx, rt.Token); !errors.Is(err, ErrRefreshRevocado) {
+		t.Errorf("Se esperaba revocar la familia del código reutilizado, obtuvo %v", err)
+	}
+
+	_, rt, _ = s.Canjear(ctx, emitir(), "portal", a.RedirectURI, verifier)
+	renovada, nuevo, err := s.Renovar(ctx, rt.Token, "portal")
+	if err != nil || nuevo.Familia != rt.Familia || renovada.Nonce != "" || len(renovada.Scopes) != 2 {
+		t.Fatalf("Se esperaba renovar en la misma familia sin nonce: %+v %v", renovada, err)
+	}
+	if _, _, err := s.Renovar(ctx, nuevo.Token, "otra-app"); !errors.Is(err, ErrGrantInvalido) {
+		t.Errorf("Se esperaba rechazar un refresh token de otra app, obtuvo %v", err)
+	}
+	if vigente, _ := refresh.familiaVigente(ctx, rt.Familia, "u1"); vigente {
+		t.Error("Se esperaba revocar la familia presentada por otra app")
+	}
+
+	// Los refresh tokens del login directo no se renuevan por OAuth
+	login, _ := refresh.Emitir(ctx, "u1")
+	if _, _, err := s.Renovar(ctx, login.Token, "portal"); !errors.Is(err, ErrGrantInvalido) {
+		t.Errorf("Se esperaba rechazar un refresh token del login, obtuvo %v", err)
+	}
+}
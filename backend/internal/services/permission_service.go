@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	// PermissionCacheTTL es el tiempo máximo que un conjunto de permisos queda en caché
+	// aunque no llegue ninguna notificación de cambio.
+	PermissionCacheTTL = 5 * time.Minute
+
+	// permisosChannel es el canal de LISTEN/NOTIFY que disparan los triggers de rol_permiso
+	permisosChannel = "rol_permiso_cambios"
+)
+
+// PermisosQuerier define lo mínimo que PermissionService necesita de la base de datos
+type PermisosQuerier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+type cachedPermisos struct {
+	permisos map[string]struct{}
+	expira   time.Time
+}
+
+// PermissionService resuelve los permisos de un rol a partir de las tablas
+// roles, permisos y rol_permiso, manteniendo una caché en memoria por rol.
+type PermissionService struct {
+	db     PermisosQuerier
+	logger *zap.Logger
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu    sync.RWMutex
+	cache map[int]cachedPermisos
+}
+
+// NewPermissionService crea un PermissionService con el TTL por defecto
+func NewPermissionService(db PermisosQuerier, logger *zap.Logger) *PermissionService {
+	return &PermissionService{
+		db:     db,
+		logger: logger,
+		ttl:    PermissionCacheTTL,
+		now:    time.Now,
+		cache:  make(map[int]cachedPermisos),
+	}
+}
+
+// Permisos devuelve el conjunto de permisos del rol, usando la caché si está vigente
+func (s *PermissionService) Permisos(ctx context.Context, rolID int) (map[string]struct{}, error) {
+	s.mu.RLock()
+	entry, ok := s.cache[rolID]
+	s.mu.RUnlock()
+	if ok && s.now().Before(entry.expira) {
+		return entry.permisos, nil
+	}
+
+	permisos, err := s.load(ctx, rolID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[rolID] = cachedPermisos{permisos: permisos, expira: s.now().Add(s.ttl)}
+	s.mu.Unlock()
+
+	return permisos, nil
+}
+
+// HasPermission indica si el rol tiene asignado el permiso indicado
+func (s *PermissionService) HasPermission(ctx context.Context, rolID int, permiso string) (bool, error) {
+	permisos, err := s.Permisos(ctx, rolID)
+	if err != nil {
+		return false, err
+	}
+	_, ok := permisos[permiso]
+	return ok, nil
+}
+
+// Invalidate descarta los permisos cacheados de un rol
+func (s *PermissionService) Invalidate(rolID int) {
+	s.mu.Lock()
+	delete(s.cache, rolID)
+	s.mu.Unlock()
+}
+
+// InvalidateAll descarta toda la caché de permisos
+func (s *PermissionService) InvalidateAll() {
+	s.mu.Lock()
+	s.cache = make(map[int]cachedPermisos)
+	s.mu.Unlock()
+}
+
+func (s *PermissionService) load(ctx context.Context, rolID int) (map[string]struct{}, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT p.nombre_permiso
+		FROM rol_permiso rp
+		JOIN permisos p ON p.id = rp.permiso_id
+		WHERE rp.rol_id = $1
+	`, rolID)
+	if err != nil {
+		s.logger.Error("Error al consultar permisos del rol", zap.Error(err), zap.Int("rol_id", rolID))
+		return nil, err
+	}
+	defer rows.Close()
+
+	permisos := make(map[string]struct{})
+	for rows.Next() {
+		var nombre string
+		if err := rows.Scan(&nombre); err != nil {
+			s.logger.Error("Error al escanear permiso", zap.Error(err), zap.Int("rol_id", rolID))
+			return nil, err
+		}
+		permisos[nombre] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return permisos, nil
+}
+
+// handleNotification invalida la caché según el payload enviado por los triggers:
+// el rol_id afectado o "*" cuando el cambio afecta a todos los roles.
+func (s *PermissionService) handleNotification(payload string) {
+	rolID, err := strconv.Atoi(payload)
+	if err != nil {
+		s.InvalidateAll()
+		return
+	}
+	s.Invalidate(rolID)
+}
+
+// Listen escucha las notificaciones de cambios en rol_permiso e invalida la caché.
+// Bloquea hasta que se cancele el contexto; ante errores de conexión reintenta y
+// vacía la caché porque pudieron perderse notificaciones mientras tanto.
+func (s *PermissionService) Listen(ctx context.Context, pool *pgxpool.Pool) {
+	for {
+		err := s.listenOnce(ctx, pool)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Warn("Conexión de LISTEN de permisos interrumpida, reintentando", zap.Error(err))
+		s.InvalidateAll()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (s *PermissionService) listenOnce(ctx context.Context, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+permisosChannel); err != nil {
+		return err
+	}
+	s.logger.Info("Escuchando cambios de permisos", zap.String("canal", permisosChannel))
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		s.logger.Info("Cambio de permisos detectado, invalidando caché",
+			zap.String("payload", notification.Payload))
+		s.handleNotification(notification.Payload)
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+// fakeRows implementa pgx.Rows devolviendo una lista de strings
+type fakeRows struct {
+	values []string
+	idx    int
+}
+
+func (r *fakeRows) Next() bool {
+	if r.idx < len(r.values) {
+		r.idx++
+		return true
+	}
+	return false
+}
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	*(dest[0].(*string)) = r.values[r.idx-1]
+	return nil
+}
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) Values() ([]interface{}, error)               { return nil, nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.NewCommandTag("SELECT") }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+type fakePermisosDB struct {
+	permisos map[int][]string
+	queries  int
+}
+
+func (f *fakePermisosDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	f.queries++
+	return &fakeRows{values: f.permisos[args[0].(int)]}, nil
+}
+
+func TestPermissionService_CachesPermisos(t *testing.T) {
+	db := &fakePermisosDB{permisos: map[int][]string{2: {"historias:read"}}}
+	s := NewPermissionService(db, zap.NewNop())
+
+	for i := 0; i < 3; i++ {
+		ok, err := s.HasPermission(context.Background(), 2, "historias:read")
+		if err != nil || !ok {
+			t.Fatalf("Se esperaba permiso concedido, obtuvo ok=%v err=%v", ok, err)
+		}
+	}
+	if db.queries != 1 {
+		t.Errorf("Se esperaba 1 consulta a la base, obtuvo %d", db.queries)
+	}
+}
+
+func TestPermissionService_InvalidateOnNotification(t *testing.T) {
+	db := &fakePermisosDB{permisos: map[int][]string{3: {"historias:read"}}}
+	s := NewPermissionService(db, zap.NewNop())
+
+	if ok, _ := s.HasPermission(context.Background(), 3, "historias:read"); !ok {
+		t.Fatal("Se esperaba permiso concedido antes del cambio")
+	}
+
+	// Se quita el permiso en rol_permiso y llega la notificación del trigger
+	db.permisos[3] = nil
+	s.handleNotification("3")
+
+	if ok, _ := s.HasPermission(context.Background(), 3, "historias:read"); ok {
+		t.Error("Se esperaba permiso denegado tras la invalidación")
+	}
+}
+
+func TestPermissionService_InvalidateAllOnWildcard(t *testing.T) {
+	db := &fakePermisosDB{permisos: map[int][]string{1: {"a"}, 2: {"b"}}}
+	s := NewPermissionService(db, zap.NewNop())
+	s.Permisos(context.Background(), 1)
+	s.Permisos(context.Background(), 2)
+
+	s.handleNotification("*")
+	s.Permisos(context.Background(), 1)
+	s.Permisos(context.Background(), 2)
+
+	if db.queries != 4 {
+		t.Errorf("Se esperaban 4 consultas tras invalidar todo, obtuvo %d", db.queries)
+	}
+}
+
+func TestPermissionService_ExpiresAfterTTL(t *testing.T) {
+	db := &fakePermisosDB{permisos: map[int][]string{1: {"a"}}}
+	s := NewPermissionService(db, zap.NewNop())
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	s.Permisos(context.Background(), 1)
+	now = now.Add(PermissionCacheTTL + time.Second)
+	s.Permisos(context.Background(), 1)
+
+	if db.queries != 2 {
+		t.Errorf("Se esperaba recargar tras el TTL, consultas=%d", db.queries)
+	}
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+// Tabla y columnas que recifra el servicio; los nombres de campo forman parte del aad y
+// deben coincidir con los de handlers/datos_personales.go
+const (
+	tablaRecifrado    = "datos_personales"
+	campoRecifradoDNI = "dni"
+	campoRecifradoTel = "telefono"
+)
+
+// RecifradoLoteDefault es la cantidad de filas que se recifran por transacción
+const RecifradoLoteDefault = 200
+
+// Estados de recifrado_trabajos
+const (
+	TrabajoEnCurso    = "en_curso"
+	TrabajoCompletado = "completado"
+	TrabajoCancelado  = "cancelado"
+)
+
+// ErrRecifradoEnCurso indica que este proceso ya está recifrando
+var ErrRecifradoEnCurso = errors.New("ya hay un recifrado en curso")
+
+// errTrabajoCancelado indica que otro proceso reemplazó el trabajo (por ejemplo tras otra rotación)
+var errTrabajoCancelado = errors.New("el trabajo de recifrado fue cancelado")
+
+// RecifradoDB define lo mínimo que RecifradoService necesita de la base de datos
+type RecifradoDB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// TrabajoRecifrado es el progreso de un recifrado (tabla recifrado_trabajos)
+type TrabajoRecifrado struct {
+	ID             int64      `json:"id"`
+	Tabla          string     `json:"tabla"`
+	VersionDestino uint32     `json:"version_destino"`
+	Estado         string     `json:"estado"`
+	UltimoID       *uuid.UUID `json:"ultimo_id,omitempty"`
+	Procesadas     int64      `json:"procesadas"`
+	Errores        int64      `json:"errores"`
+	UltimoError    *string    `json:"ultimo_error,omitempty"`
+	IniciadoEn     time.Time  `json:"iniciado_en"`
+	ActualizadoEn  time.Time  `json:"actualizado_en"`
+	FinalizadoEn   *time.Time `json:"finalizado_en,omitempty"`
+}
+
+// FilasPorVersion cuenta las filas cifradas con una versión de la clave maestra.
+// Version es nil para filas con un formato de cifrado desconocido.
+type FilasPorVersion struct {
+	Version *int  `json:"version"`
+	Filas   int64 `json:"filas"`
+}
+
+// EstadoRecifrado resume cuántas filas quedan en cada versión de la clave maestra
+type EstadoRecifrado struct {
+	Tabla         string            `json:"tabla"`
+	VersionActual uint32            `json:"version_actual"`
+	Versiones     []FilasPorVersion `json:"versiones"`
+	Pendientes    int64             `json:"pendientes"`
+	// EnCurso indica si este proceso está recifrando en este momento
+	EnCurso bool              `json:"en_curso"`
+	Trabajo *TrabajoRecifrado `json:"ultimo_trabajo,omitempty"`
+}
+
+// RecifradoService vuelve a cifrar con la versión vigente de la clave maestra las filas
+// que quedaron cifradas con versiones anteriores. Avanza por lotes ordenados por id y
+// guarda el cursor en recifrado_trabajos después de cada lote, así que un trabajo
+// interrumpido se reanuda donde quedó.
+type RecifradoService struct {
+	db      RecifradoDB
+	cipher  *security.FieldCipher
+	lote    int
+	logger  *zap.Logger
+	enCurso atomic.Bool
+}
+
+// NewRecifradoService crea un RecifradoService con lotes de RecifradoLoteDefault filas
+func NewRecifradoService(db RecifradoDB, cipher *security.FieldCipher, logger *zap.Logger) *RecifradoService {
+	return &RecifradoService{db: db, cipher: cipher, lote: RecifradoLoteDefault, logger: logger}
+}
+
+// SetLote cambia la cantidad de filas por lote
+func (s *RecifradoService) SetLote(lote int) {
+	if lote > 0 {
+		s.lote = lote
+	}
+}
+
+// Ejecutar recifra hasta terminar o hasta que se cancele ctx. progreso, si no es nil,
+// se llama después de cada lote.
+func (s *RecifradoService) Ejecutar(ctx context.Context, progreso func(TrabajoRecifrado)) (TrabajoRecifrado, error) {
+	if !s.enCurso.CompareAndSwap(false, true) {
+		return TrabajoRecifrado{}, ErrRecifradoEnCurso
+	}
+	defer s.enCurso.Store(false)
+	return s.ejecutar(ctx, progreso)
+}
+
+// IniciarEnSegundoPlano lanza Ejecutar en una goroutine que termina al cancelarse ctx
+func (s *RecifradoService) IniciarEnSegundoPlano(ctx context.Context) error {
+	if !s.enCurso.CompareAndSwap(false, true) {
+		return ErrRecifradoEnCurso
+	}
+	go func() {
+		defer s.enCurso.Store(false)
+		trabajo, err := s.ejecutar(ctx, nil)
+		if err != nil {
+			s.logger.Error("Recifrado interrumpido", zap.Int64("trabajo_id", trabajo.ID), zap.Error(err))
+			return
+		}
+		s.logger.Info("Recifrado completado",
+			zap.Int64("trabajo_id", trabajo.ID),
+			zap.Uint32("version", trabajo.VersionDestino),
+			zap.Int64("procesadas", trabajo.Procesadas),
+			zap.Int64("errores", trabajo.Errores),
+		)
+	}()
+	return nil
+}
+
+// Reanudar continúa en segundo plano un trabajo que quedó en curso (por ejemplo por un reinicio)
+func (s *RecifradoService) Reanudar(ctx context.Context) error {
+	var pendiente bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM recifrado_trabajos WHERE tabla = $1 AND estado = $2)`,
+		tablaRecifrado, TrabajoEnCurso).Scan(&pendiente)
+	if err != nil || !pendiente {
+		return err
+	}
+	return s.IniciarEnSegundoPlano(ctx)
+}
+
+func (s *RecifradoService) ejecutar(ctx context.Context, progreso func(TrabajoRecifrado)) (TrabajoRecifrado, error) {
+	destino, err := s.cipher.CurrentVersion(ctx)
+	if err != nil {
+		return TrabajoRecifrado{}, fmt.Errorf("error obteniendo la versión vigente de la clave maestra: %w", err)
+	}
+	trabajo, err := s.prepararTrabajo(ctx, destino)
+	if err != nil {
+		return trabajo, err
+	}
+	for trabajo.Estado == TrabajoEnCurso {
+		if err := ctx.Err(); err != nil {
+			return trabajo, err
+		}
+		if err := s.procesarLote(ctx, &trabajo); err != nil {
+			return trabajo, err
+		}
+		if progreso != nil {
+			progreso(trabajo)
+		}
+	}
+	return trabajo, nil
+}
+
+const columnasTrabajo = `id, tabla, version_destino, estado, ultimo_id, procesadas, errores, ultimo_error,
+	iniciado_en, actualizado_en, finalizado_en`
+
+func scanTrabajo(row pgx.Row) (TrabajoRecifrado, error) {
+	var t TrabajoRecifrado
+	var version int64
+	err := row.Scan(&t.ID, &t.Tabla, &version, &t.Estado, &t.UltimoID, &t.Procesadas, &t.Errores, &t.UltimoError,
+		&t.IniciadoEn, &t.ActualizadoEn, &t.FinalizadoEn)
+	t.VersionDestino = uint32(version)
+	return t, err
+}
+
+// bloquearTrabajos serializa los lotes de todos los procesos que recifran la tabla
+func bloquearTrabajos(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('recifrado:' || $1))`, tablaRecifrado)
+	return err
+}
+
+// prepararTrabajo devuelve el trabajo en curso hacia destino o crea uno nuevo. Un trabajo
+// en curso hacia otra versión (la clave se rotó de nuevo) se cancela y se empieza de cero.
+func (s *RecifradoService) prepararTrabajo(ctx context.Context, destino uint32) (TrabajoRecifrado, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return TrabajoRecifrado{}, err
+	}
+	defer tx.Rollback(ctx)
+	if err := bloquearTrabajos(ctx, tx); err != nil {
+		return TrabajoRecifrado{}, err
+	}
+
+	trabajo, err := scanTrabajo(tx.QueryRow(ctx, `SELECT `+columnasTrabajo+`
+		FROM recifrado_trabajos WHERE tabla = $1 AND estado = $2`, tablaRecifrado, TrabajoEnCurso))
+	switch {
+	case err == nil && trabajo.VersionDestino == destino:
+		s.logger.Info("Reanudando recifrado", zap.Int64("trabajo_id", trabajo.ID), zap.Int64("procesadas", trabajo.Procesadas))
+		return trabajo, tx.Commit(ctx)
+	case err == nil:
+		if _, err := tx.Exec(ctx, `
+			UPDATE recifrado_trabajos SET estado = $2, actualizado_en = NOW(), finalizado_en = NOW()
+			WHERE id = $1`, trabajo.ID, TrabajoCancelado); err != nil {
+			return TrabajoRecifrado{}, err
+		}
+	case !errors.Is(err, pgx.ErrNoRows):
+		return TrabajoRecifrado{}, err
+	}
+
+	trabajo, err = scanTrabajo(tx.QueryRow(ctx, `
+		INSERT INTO recifrado_trabajos (tabla, version_destino) VALUES ($1, $2)
+		RETURNING `+columnasTrabajo, tablaRecifrado, int64(destino)))
+	if err != nil {
+		return TrabajoRecifrado{}, err
+	}
+	s.logger.Info("Recifrado iniciado", zap.Int64("trabajo_id", trabajo.ID), zap.Uint32("version", destino))
+	return trabajo, tx.Commit(ctx)
+}
+
+type filaRecifrado struct {
+	id       uuid.UUID
+	dni, tel []byte
+}
+
+// procesarLote recifra el siguiente lote y avanza el cursor en la misma transacción.
+// Si no quedan filas marca el trabajo como completado.
+func (s *RecifradoService) procesarLote(ctx context.Context, trabajo *TrabajoRecifrado) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := bloquearTrabajos(ctx, tx); err != nil {
+		return err
+	}
+
+	// El cursor se relee bajo el bloqueo: otro proceso pudo haber avanzado el mismo trabajo
+	actual, err := scanTrabajo(tx.QueryRow(ctx, `SELECT `+columnasTrabajo+` FROM recifrado_trabajos WHERE id = $1`, trabajo.ID))
+	if err != nil {
+		return err
+	}
+	*trabajo = actual
+	switch trabajo.Estado {
+	case TrabajoCompletado:
+		return nil
+	case TrabajoCancelado:
+		return errTrabajoCancelado
+	}
+
+	filas, err := s.leerLote(ctx, tx, trabajo)
+	if err != nil {
+		return err
+	}
+	if len(filas) == 0 {
+		trabajo.Estado = TrabajoCompletado
+		if _, err := tx.Exec(ctx, `
+			UPDATE recifrado_trabajos SET estado = $2, actualizado_en = NOW(), finalizado_en = NOW()
+			WHERE id = $1`, trabajo.ID, TrabajoCompletado); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	var procesadas, errores int64
+	var ultimoError *string
+	for _, f := range filas {
+		version, err := s.recifrarFila(ctx, tx, f, trabajo.VersionDestino)
+		if err != nil {
+			// La fila queda con su versión anterior y sigue contando como pendiente
+			s.logger.Error("Error al recifrar fila", zap.String("id", f.id.String()), zap.Error(err))
+			msg := f.id.String() + ": " + err.Error()
+			errores, ultimoError = errores+1, &msg
+			continue
+		}
+		if version != trabajo.VersionDestino {
+			s.logger.Warn("La fila quedó cifrada con otra versión", zap.String("id", f.id.String()), zap.Uint32("version", version))
+		}
+		procesadas++
+	}
+
+	ultimoID := filas[len(filas)-1].id
+	err = tx.QueryRow(ctx, `
+		UPDATE recifrado_trabajos
+		SET ultimo_id = $2, procesadas = procesadas + $3, errores = errores + $4,
+			ultimo_error = COALESCE($5, ultimo_error), actualizado_en = NOW()
+		WHERE id = $1
+		RETURNING procesadas, errores, ultimo_error, actualizado_en
+	`, trabajo.ID, ultimoID, procesadas, errores, ultimoError).
+		Scan(&trabajo.Procesadas, &trabajo.Errores, &trabajo.UltimoError, &trabajo.ActualizadoEn)
+	if err != nil {
+		return err
+	}
+	trabajo.UltimoID = &ultimoID
+	return tx.Commit(ctx)
+}
+
+func (s *RecifradoService) leerLote(ctx context.Context, tx pgx.Tx, trabajo *TrabajoRecifrado) ([]filaRecifrado, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, dni_encriptado, telefono_encriptado
+		FROM datos_personales
+		WHERE ($1::uuid IS NULL OR id > $1)
+			AND clave_version IS DISTINCT FROM $2
+			AND (dni_encriptado IS NOT NULL OR telefono_encriptado IS NOT NULL)
+		ORDER BY id
+		LIMIT $3
+		FOR UPDATE
+	`, trabajo.UltimoID, int64(trabajo.VersionDestino), s.lote)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var filas []filaRecifrado
+	for rows.Next() {
+		var f filaRecifrado
+		if err := rows.Scan(&f.id, &f.dni, &f.tel); err != nil {
+			return nil, err
+		}
+		filas = append(filas, f)
+	}
+	return filas, rows.Err()
+}
+
+// recifrarFila recifra las columnas de la fila que no estén ya en la versión destino y
+// devuelve la versión con la que quedó
+func (s *RecifradoService) recifrarFila(ctx context.Context, tx pgx.Tx, f filaRecifrado, destino uint32) (uint32, error) {
+	dni, err := s.recifrar(ctx, f.id, campoRecifradoDNI, f.dni, destino)
+	if err != nil {
+		return 0, err
+	}
+	tel, err := s.recifrar(ctx, f.id, campoRecifradoTel, f.tel, destino)
+	if err != nil {
+		return 0, err
+	}
+	cifrado := dni
+	if cifrado == nil {
+		cifrado = tel
+	}
+	version, err := security.KeyVersion(cifrado)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE datos_personales SET dni_encriptado = $2, telefono_encriptado = $3, clave_version = $4
+		WHERE id = $1
+	`, f.id, dni, tel, int64(version))
+	return version, err
+}
+
+func (s *RecifradoService) recifrar(ctx context.Context, id uuid.UUID, campo string, cifrado []byte, destino uint32) ([]byte, error) {
+	if cifrado == nil {
+		return nil, nil
+	}
+	if v, err := security.KeyVersion(cifrado); err == nil && v == destino {
+		return cifrado, nil
+	}
+	nuevo, err := s.cipher.Reencrypt(ctx, tablaRecifrado, id.String(), campo, cifrado)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", campo, err)
+	}
+	return nuevo, nil
+}
+
+// Estado cuenta las filas cifradas por versión de la clave maestra y devuelve el último trabajo
+func (s *RecifradoService) Estado(ctx context.Context) (EstadoRecifrado, error) {
+	estado := EstadoRecifrado{Tabla: tablaRecifrado, Versiones: []FilasPorVersion{}, EnCurso: s.enCurso.Load()}
+	var err error
+	if estado.VersionActual, err = s.cipher.CurrentVersion(ctx); err != nil {
+		return estado, fmt.Errorf("error obteniendo la versión vigente de la clave maestra: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT clave_version, COUNT(*)
+		FROM datos_personales
+		WHERE dni_encriptado IS NOT NULL OR telefono_encriptado IS NOT NULL
+		GROUP BY clave_version
+		ORDER BY clave_version NULLS LAST
+	`)
+	if err != nil {
+		return estado, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f FilasPorVersion
+		if err := rows.Scan(&f.Version, &f.Filas); err != nil {
+			return estado, err
+		}
+		if f.Version == nil || uint32(*f.Version) != estado.VersionActual {
+			estado.Pendientes += f.Filas
+		}
+		estado.Versiones = append(estado.Versiones, f)
+	}
+	if err := rows.Err(); err != nil {
+		return estado, err
+	}
+
+	trabajo, err := scanTrabajo(s.db.QueryRow(ctx, `SELECT `+columnasTrabajo+`
+		FROM recifrado_trabajos WHERE tabla = $1 ORDER BY id DESC LIMIT 1`, tablaRecifrado))
+	if err == nil {
+		estado.Trabajo = &trabajo
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return estado, err
+	}
+	return estado, nil
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/FolkodeGroup/mediapp/internal/security"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+)
+
+type filaFake struct {
+	id       uuid.UUID
+	dni, tel []byte
+	version  *int
+}
+
+// fakeRecifradoDB simula datos_personales y recifrado_trabajos en memoria. Las
+// transacciones aplican los cambios en el momento; alcanza para seguir el cursor.
+type fakeRecifradoDB struct {
+	filas    []*filaFake
+	trabajos []*TrabajoRecifrado
+}
+
+type fakeRecifradoTx struct {
+	pgx.Tx
+	db *fakeRecifradoDB
+}
+
+func (db *fakeRecifradoDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return &fakeRecifradoTx{db: db}, nil
+}
+
+func (tx *fakeRecifradoTx) Commit(ctx context.Context) error   { return nil }
+func (tx *fakeRecifradoTx) Rollback(ctx context.Context) error { return nil }
+func (tx *fakeRecifradoTx) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return tx.db.exec(sql, args...)
+}
+func (tx *fakeRecifradoTx) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return tx.db.Query(ctx, sql, args...)
+}
+func (tx *fakeRecifradoTx) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return tx.db.QueryRow(ctx, sql, args...)
+}
+
+func (db *fakeRecifradoDB) exec(sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	switch {
+	case strings.Contains(sql, "pg_advisory_xact_lock"):
+	case strings.Contains(sql, "UPDATE recifrado_trabajos SET estado"):
+		for _, t := range db.trabajos {
+			if t.ID == args[0].(int64) {
+				t.Estado = args[1].(string)
+			}
+		}
+	case strings.Contains(sql, "UPDATE datos_personales"):
+		for _, f := range db.filas {
+			if f.id == args[0].(uuid.UUID) {
+				v := int(args[3].(int64))
+				f.dni, f.tel, f.version = args[1].([]byte), args[2].([]byte), &v
+			}
+		}
+	default:
+		return pgconn.CommandTag{}, errors.New("exec inesperado: " + sql)
+	}
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+// scanFunc adapta una función a pgx.Row
+type scanFunc func(dest ...interface{}) error
+
+func (f scanFunc) Scan(dest ...interface{}) error { return f(dest...) }
+
+func scanTrabajoFake(t *TrabajoRecifrado) pgx.Row {
+	return scanFunc(func(dest ...interface{}) error {
+		if t == nil {
+			return pgx.ErrNoRows
+		}
+		*(dest[0].(*int64)) = t.ID
+		*(dest[1].(*string)) = t.Tabla
+		*(dest[2].(*int64)) = int64(t.VersionDestino)
+		*(dest[3].(*string)) = t.Estado
+		*(dest[4].(**uuid.UUID)) = t.UltimoID
+		*(dest[5].(*int64)) = t.Procesadas
+		*(dest[6].(*int64)) = t.Errores
+		*(dest[7].(**string)) = t.UltimoError
+		return nil
+	})
+}
+
+func (db *fakeRecifradoDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	switch {
+	case strings.Contains(sql, "INSERT INTO recifrado_trabajos"):
+		t := &TrabajoRecifrado{ID: int64(len(db.trabajos) + 1), Tabla: args[0].(string), VersionDestino: uint32(args[1].(int64)), Estado: TrabajoEnCurso}
+		db.trabajos = append(db.trabajos, t)
+		return scanTrabajoFake(t)
+	case strings.Contains(sql, "UPDATE recifrado_trabajos"):
+		t := db.trabajos[args[0].(int64)-1]
+		id := args[1].(uuid.UUID)
+		t.UltimoID = &id
+		t.Procesadas += args[2].(int64)
+		t.Errores += args[3].(int64)
+		if msg := args[4].(*string); msg != nil {
+			t.UltimoError = msg
+		}
+		return scanFunc(func(dest ...interface{}) error {
+			*(dest[0].(*int64)), *(dest[1].(*int64)), *(dest[2].(**string)) = t.Procesadas, t.Errores, t.UltimoError
+			return nil
+		})
+	case strings.Contains(sql, "WHERE id = $1"):
+		return scanTrabajoFake(db.trabajos[args[0].(int64)-1])
+	case strings.Contains(sql, "estado = $2"):
+		for _, t := range db.trabajos {
+			if t.Estado == TrabajoEnCurso {
+				return scanTrabajoFake(t)
+			}
+		}
+		return scanTrabajoFake(nil)
+	case strings.Contains(sql, "ORDER BY id DESC LIMIT 1"):
+		if len(db.trabajos) == 0 {
+			return scanTrabajoFake(nil)
+		}
+		return scanTrabajoFake(db.trabajos[len(db.trabajos)-1])
+	}
+	return scanFunc(func(dest ...interface{}) error { return errors.New("consulta inesperada: " + sql) })
+}
+
+// filasFake implementa pgx.Rows sobre una lista de funciones de scan
+type filasFake struct {
+	scans []scanFunc
+	idx   int
+}
+
+func (r *filasFake) Next() bool                                   { r.idx++; return r.idx <= len(r.scans) }
+func (r *filasFake) Scan(dest ...interface{}) error               { return r.scans[r.idx-1](dest...) }
+func (r *filasFake) Close()                                       {}
+func (r *filasFake) Err() error                                   { return nil }
+func (r *filasFake) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *filasFake) Values() ([]interface{}, error)               { return nil, nil }
+func (r *filasFake) RawValues() [][]byte                          { return nil }
+func (r *filasFake) CommandTag() pgconn.CommandTag                { return pgconn.NewCommandTag("SELECT") }
+func (r *filasFake) Conn() *pgx.Conn                              { return nil }
+
+func (db *fakeRecifradoDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	rows := &filasFake{}
+	if strings.Contains(sql, "GROUP BY clave_version") {
+		cuentas := map[int]int64{}
+		var sinVersion int64
+		for _, f := range db.filas {
+			if f.version == nil {
+				sinVersion++
+			} else {
+				cuentas[*f.version]++
+			}
+		}
+		for v, n := range cuentas {
+			v, n := v, n
+			rows.scans = append(rows.scans, func(dest ...interface{}) error {
+				*(dest[0].(**int)), *(dest[1].(*int64)) = &v, n
+				return nil
+			})
+		}
+		if sinVersion > 0 {
+			rows.scans = append(rows.scans, func(dest ...interface{}) error {
+				*(dest[1].(*int64)) = sinVersion
+				return nil
+			})
+		}
+		return rows, nil
+	}
+
+	cursor, destino, limite := args[0].(*uuid.UUID), int(args[1].(int64)), args[2].(int)
+	for _, f := range db.filas {
+		if len(rows.scans) == limite {
+			break
+		}
+		if (cursor != nil && f.id.String() <= cursor.String()) || (f.version != nil && *f.version == destino) {
+			continue
+		}
+		f := f
+		rows.scans = append(rows.scans, func(dest ...interface{}) error {
+			*(dest[0].(*uuid.UUID)), *(dest[1].(*[]byte)), *(dest[2].(*[]byte)) = f.id, f.dni, f.tel
+			return nil
+		})
+	}
+	return rows, nil
+}
+
+func nuevaClave(t *testing.T, version uint32) *security.LocalKEK {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	k, err := security.NewLocalKEK(version, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRecifrado_ReanudaYCompleta(t *testing.T) {
+	ctx := context.Background()
+	indexKey := make([]byte, security.MinIndexKeySize)
+	v1, v2 := nuevaClave(t, 1), nuevaClave(t, 2)
+	viejo, _ := security.NewFieldCipher(v1, indexKey)
+	anillo, _ := security.NewKeyRing(v2, v1)
+	nuevo, _ := security.NewFieldCipher(anillo, indexKey)
+
+	db := &fakeRecifradoDB{}
+	uno, dos := 1, 2
+	for i := 0; i < 4; i++ {
+		f := &filaFake{id: uuid.New(), version: &uno}
+		cifrador := viejo
+		if i == 3 {
+			cifrador, f.version = nuevo, &dos
+		}
+		f.dni, _ = cifrador.Encrypt(ctx, "datos_personales", f.id.String(), "dni", []byte("20123456"))
+		f.tel, _ = cifrador.Encrypt(ctx, "datos_personales", f.id.String(), "telefono", []byte("1155551234"))
+		db.filas = append(db.filas, f)
+	}
+	// Un valor con formato desconocido no frena el trabajo: se cuenta como error
+	db.filas = append(db.filas, &filaFake{id: uuid.New(), dni: []byte("legado")})
+	sort.Slice(db.filas, func(i, j int) bool { return db.filas[i].id.String() < db.filas[j].id.String() })
+
+	s := NewRecifradoService(db, nuevo, zap.NewNop())
+	s.SetLote(2)
+
+	// Se interrumpe después del primer lote
+	ctxCorte, cortar := context.WithCancel(ctx)
+	trabajo, err := s.Ejecutar(ctxCorte, func(TrabajoRecifrado) { cortar() })
+	if !errors.Is(err, context.Canceled) || trabajo.Estado != TrabajoEnCurso || trabajo.UltimoID == nil {
+		t.Fatalf("Se esperaba un trabajo en curso con cursor, obtuvo %+v %v", trabajo, err)
+	}
+	primerLote := trabajo.Procesadas + trabajo.Errores
+	if primerLote != 2 {
+		t.Fatalf("Se esperaban 2 filas en el primer lote, obtuvo %d", primerLote)
+	}
+
+	// La segunda ejecución reanuda el mismo trabajo desde el cursor
+	trabajo, err = s.Ejecutar(ctx, nil)
+	if err != nil {
+		t.Fatalf("Error al reanudar: %v", err)
+	}
+	if trabajo.ID != 1 || len(db.trabajos) != 1 || trabajo.Estado != TrabajoCompletado {
+		t.Fatalf("Se esperaba completar el trabajo 1, obtuvo %+v (%d trabajos)", trabajo, len(db.trabajos))
+	}
+	if trabajo.Procesadas != 3 || trabajo.Errores != 1 || trabajo.UltimoError == nil {
+		t.Errorf("Se esperaban 3 filas recifradas y 1 error, obtuvo %+v", trabajo)
+	}
+
+	soloV2, _ := security.NewKeyRing(v2)
+	lector, _ := security.NewFieldCipher(soloV2, indexKey)
+	for _, f := range db.filas {
+		if f.version == nil {
+			continue
+		}
+		if *f.version != 2 {
+			t.Errorf("La fila %s quedó en la versión %d", f.id, *f.version)
+		}
+		if dni, err := lector.Decrypt(ctx, "datos_personales", f.id.String(), "dni", f.dni); err != nil || string(dni) != "20123456" {
+			t.Errorf("Se esperaba leer el DNI con la versión 2, obtuvo %q %v", dni, err)
+		}
+	}
+
+	estado, err := s.Estado(ctx)
+	if err != nil {
+		t.Fatalf("Error al obtener el estado: %v", err)
+	}
+	if estado.VersionActual != 2 || estado.Pendientes != 1 || estado.Trabajo == nil || estado.Trabajo.Estado != TrabajoCompletado {
+		t.Errorf("Estado inesperado: %+v", estado)
+	}
+}
+
+func TestRecifrado_CancelaTrabajoHaciaOtraVersion(t *testing.T) {
+	indexKey := make([]byte, security.MinIndexKeySize)
+	cipher, _ := security.NewFieldCipher(nuevaClave(t, 3), indexKey)
+	db := &fakeRecifradoDB{trabajos: []*TrabajoRecifrado{{ID: 1, Tabla: "datos_personales", VersionDestino: 2, Estado: TrabajoEnCurso}}}
+
+	trabajo, err := NewRecifradoService(db, cipher, zap.NewNop()).Ejecutar(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Error al recifrar: %v", err)
+	}
+	if db.trabajos[0].Estado != TrabajoCancelado || trabajo.ID != 2 || trabajo.VersionDestino != 3 || trabajo.Estado != TrabajoCompletado {
+		t.Errorf("Se esperaba cancelar el trabajo hacia la versión 2 y completar uno nuevo, obtuvo %+v", trabajo)
+	}
+}
+
+func TestRecifrado_UnoPorProceso(t *testing.T) {
+	s := NewRecifradoService(&fakeRecifradoDB{}, nil, zap.NewNop())
+	s.enCurso.Store(true)
+	if err := s.IniciarEnSegundoPlano(context.Background()); !errors.Is(err, ErrRecifradoEnCurso) {
+		t.Errorf("Se esperaba ErrRecifradoEnCurso, obtuvo %v", err)
+	}
+	if _, err := s.Ejecutar(context.Background(), nil); !errors.Is(err, ErrRecifradoEnCurso) {
+		t.Errorf("Se esperaba ErrRecifradoEnCurso, obtuvo %v", err)
+	}
+}
@@ -2,7 +2,8 @@ package services
 
 import (
 	"context"
-	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -10,108 +11,184 @@ import (
 )
 
 const (
+	// MaxLoginAttempts es la cantidad de fallos de una cuenta que la bloquean
 	MaxLoginAttempts = 5
-	BlockDuration    = 10 * time.Minute // Bloquear por 10 minutos
+	// MaxIPLoginAttempts es la cantidad de fallos desde una IP (con cualquier usuario) que
+	// la bloquean
+	MaxIPLoginAttempts = 20
+	// LoginAttemptsWindow es el período en el que se cuentan los fallos
+	LoginAttemptsWindow = 15 * time.Minute
+	// BlockDuration es la duración del primer bloqueo; cada bloqueo siguiente dentro de
+	// blockHistoryTTL dura el doble, hasta MaxBlockDuration
+	BlockDuration    = 10 * time.Minute
+	MaxBlockDuration = 24 * time.Hour
+	// blockHistoryTTL es cuánto se recuerdan los bloqueos anteriores para escalar
+	blockHistoryTTL = 24 * time.Hour
 )
 
+// Tipos de bloqueo de login
+const (
+	bloqueoIP     = "ip"
+	bloqueoCuenta = "usuario"
+)
+
+// RedisService lleva los intentos de login fallidos y los bloqueos temporales por cuenta
+// y por IP.
+//
+// Claves (<tipo> es "ip" o "usuario"):
+//   - login_attempts:<tipo>:<id> cuenta los fallos dentro de LoginAttemptsWindow
+//   - login_blocked:<tipo>:<id> existe mientras dura el bloqueo; guarda su vencimiento
+//   - login_blocks:<tipo>:<id> cuenta los bloqueos de las últimas 24 h, para escalar
+//
+// La cuenta se identifica por el nombre de usuario ingresado, exista o no, para que el
+// bloqueo no revele qué usuarios existen.
 type RedisService struct {
 	client *redis.Client
+	kv     kvStore
 	logger *zap.Logger
+	ahora  func() time.Time
 }
 
 func NewRedisService(client *redis.Client, logger *zap.Logger) *RedisService {
 	return &RedisService{
 		client: client,
+		kv:     redisKV{client: client},
 		logger: logger,
+		ahora:  time.Now,
 	}
 }
 
-// IncrementLoginAttempts incrementa el contador de intentos fallidos
-func (r *RedisService) IncrementLoginAttempts(ip string) (int64, error) {
-	ctx := context.Background()
-	key := fmt.Sprintf("login_attempts:%s", ip)
-	
-	// Incrementar el contador
-	attempts, err := r.client.Incr(ctx, key).Result()
-	if err != nil {
-		r.logger.Error("Error incrementando intentos de login", zap.Error(err), zap.String("ip", ip))
-		return 0, err
-	}
+// IncrementLoginAttempts suma un fallo de login desde ip y devuelve el total de la ventana
+func (r *RedisService) IncrementLoginAttempts(ctx context.Context, ip string) (int64, error) {
+	return r.incrementar(ctx, bloqueoIP, ip)
+}
 
-	// Establecer expiración si es la primera vez
-	if attempts == 1 {
-		err = r.client.Expire(ctx, key, BlockDuration).Err()
-		if err != nil {
-			r.logger.Error("Error estableciendo expiración para intentos de login", zap.Error(err), zap.String("ip", ip))
-		}
-	}
+// IsIPBlocked indica si ip está bloqueada y por cuánto tiempo más
+func (r *RedisService) IsIPBlocked(ctx context.Context, ip string) (bool, time.Duration, error) {
+	return r.bloqueado(ctx, bloqueoIP, ip)
+}
+
+// BlockIP bloquea ip y devuelve la duración del bloqueo
+func (r *RedisService) BlockIP(ctx context.Context, ip string) (time.Duration, error) {
+	return r.bloquear(ctx, bloqueoIP, ip)
+}
+
+// ResetLoginAttempts borra los fallos de ip (no el bloqueo)
+func (r *RedisService) ResetLoginAttempts(ctx context.Context, ip string) error {
+	return r.kv.del(ctx, clave("login_attempts", bloqueoIP, ip))
+}
+
+// GetLoginAttempts devuelve los fallos de ip dentro de la ventana
+func (r *RedisService) GetLoginAttempts(ctx context.Context, ip string) (int64, error) {
+	return r.intentos(ctx, bloqueoIP, ip)
+}
+
+// IncrementAccountAttempts suma un fallo de login de username y devuelve el total de la
+// ventana
+func (r *RedisService) IncrementAccountAttempts(ctx context.Context, username string) (int64, error) {
+	return r.incrementar(ctx, bloqueoCuenta, normalizarUsuario(username))
+}
+
+// IsAccountBlocked indica si username está bloqueado y por cuánto tiempo más
+func (r *RedisService) IsAccountBlocked(ctx context.Context, username string) (bool, time.Duration, error) {
+	return r.bloqueado(ctx, bloqueoCuenta, normalizarUsuario(username))
+}
+
+// BlockAccount bloquea username y devuelve la duración del bloqueo
+func (r *RedisService) BlockAccount(ctx context.Context, username string) (time.Duration, error) {
+	return r.bloquear(ctx, bloqueoCuenta, normalizarUsuario(username))
+}
 
-	return attempts, nil
+// GetAccountAttempts devuelve los fallos de username dentro de la ventana
+func (r *RedisService) GetAccountAttempts(ctx context.Context, username string) (int64, error) {
+	return r.intentos(ctx, bloqueoCuenta, normalizarUsuario(username))
 }
 
-// IsIPBlocked verifica si una IP está bloqueada
-func (r *RedisService) IsIPBlocked(ip string) (bool, error) {
-	ctx := context.Background()
-	key := fmt.Sprintf("blocked_ip:%s", ip)
-	
-	exists, err := r.client.Exists(ctx, key).Result()
+// ResetAccountAttempts borra los fallos de username tras un login correcto. El historial
+// de bloqueos se mantiene para que alternar aciertos no frene la escalada.
+func (r *RedisService) ResetAccountAttempts(ctx context.Context, username string) error {
+	return r.kv.del(ctx, clave("login_attempts", bloqueoCuenta, normalizarUsuario(username)))
+}
+
+// UnblockAccount levanta el bloqueo de username y borra sus fallos y su historial
+// (desbloqueo de un administrador)
+func (r *RedisService) UnblockAccount(ctx context.Context, username string) error {
+	id := normalizarUsuario(username)
+	return r.kv.del(ctx,
+		clave("login_attempts", bloqueoCuenta, id),
+		clave("login_blocked", bloqueoCuenta, id),
+		clave("login_blocks", bloqueoCuenta, id))
+}
+
+func (r *RedisService) incrementar(ctx context.Context, tipo, id string) (int64, error) {
+	n, err := r.kv.incr(ctx, clave("login_attempts", tipo, id), LoginAttemptsWindow)
 	if err != nil {
-		r.logger.Error("Error verificando si IP está bloqueada", zap.Error(err), zap.String("ip", ip))
-		return false, err
+		r.logger.Error("Error incrementando intentos de login", zap.Error(err), zap.String("tipo", tipo))
 	}
-	
-	return exists > 0, nil
+	return n, err
 }
 
-// BlockIP bloquea una IP por el tiempo especificado
-func (r *RedisService) BlockIP(ip string) error {
-	ctx := context.Background()
-	key := fmt.Sprintf("blocked_ip:%s", ip)
-	
-	err := r.client.Set(ctx, key, "blocked", BlockDuration).Err()
-	if err != nil {
-		r.logger.Error("Error bloqueando IP", zap.Error(err), zap.String("ip", ip))
-		return err
+func (r *RedisService) intentos(ctx context.Context, tipo, id string) (int64, error) {
+	raw, ok, err := r.kv.get(ctx, clave("login_attempts", tipo, id))
+	if err != nil || !ok {
+		return 0, err
 	}
+	return strconv.ParseInt(raw, 10, 64)
+}
 
-	// Limpiar los intentos fallidos ya que ya está bloqueada
-	attemptsKey := fmt.Sprintf("login_attempts:%s", ip)
-	r.client.Del(ctx, attemptsKey)
-	
-	r.logger.Info("IP bloqueada", zap.String("ip", ip), zap.Duration("duration", BlockDuration))
-	return nil
+func (r *RedisService) bloqueado(ctx context.Context, tipo, id string) (bool, time.Duration, error) {
+	raw, ok, err := r.kv.get(ctx, clave("login_blocked", tipo, id))
+	if err != nil || !ok {
+		return false, 0, err
+	}
+	hasta, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return false, 0, err
+	}
+	restante := time.Unix(hasta, 0).Sub(r.ahora())
+	if restante <= 0 {
+		return false, 0, nil
+	}
+	return true, restante, nil
 }
 
-// ResetLoginAttempts reinicia el contador de intentos fallidos
-func (r *RedisService) ResetLoginAttempts(ip string) error {
-	ctx := context.Background()
-	key := fmt.Sprintf("login_attempts:%s", ip)
-	
-	err := r.client.Del(ctx, key).Err()
+// bloquear aplica un bloqueo que dura el doble que el anterior y borra los fallos
+func (r *RedisService) bloquear(ctx context.Context, tipo, id string) (time.Duration, error) {
+	previos, err := r.kv.incr(ctx, clave("login_blocks", tipo, id), blockHistoryTTL)
 	if err != nil {
-		r.logger.Error("Error reiniciando intentos de login", zap.Error(err), zap.String("ip", ip))
-		return err
+		return 0, err
+	}
+	duracion := BlockDuration
+	for i := int64(1); i < previos && duracion < MaxBlockDuration; i++ {
+		duracion *= 2
+	}
+	if duracion > MaxBlockDuration {
+		duracion = MaxBlockDuration
+	}
+	hasta := r.ahora().Add(duracion).Unix()
+	if err := r.kv.set(ctx, clave("login_blocked", tipo, id), strconv.FormatInt(hasta, 10), duracion); err != nil {
+		r.logger.Error("Error bloqueando login", zap.Error(err), zap.String("tipo", tipo))
+		return 0, err
 	}
-	
-	return nil
-}
-
-// GetLoginAttempts obtiene el número actual de intentos fallidos
-func (r *RedisService) GetLoginAttempts(ip string) (int64, error) {
-	ctx := context.Background()
-	key := fmt.Sprintf("login_attempts:%s", ip)
-	
-	attempts, err := r.client.Get(ctx, key).Int64()
-	if err == redis.Nil {
-		return 0, nil // No hay intentos registrados
-	} else if err != nil {
-		r.logger.Error("Error obteniendo intentos de login", zap.Error(err), zap.String("ip", ip))
+	if err := r.kv.del(ctx, clave("login_attempts", tipo, id)); err != nil {
 		return 0, err
 	}
-	
-	return attempts, nil
+	r.logger.Warn("Login bloqueado temporalmente",
+		zap.String("tipo", tipo),
+		zap.Duration("duracion", duracion),
+		zap.Int64("bloqueos_24h", previos))
+	return duracion, nil
+}
+
+func clave(prefijo, tipo, id string) string {
+	return prefijo + ":" + tipo + ":" + id
+}
+
+// normalizarUsuario evita que variantes de mayúsculas o espacios esquiven el bloqueo
+func normalizarUsuario(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
 }
 
 func (r *RedisService) Client() *redis.Client {
-    return r.client
-}
\ No newline at end of file
+	return r.client
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestBloqueoLogin_Progresivo verifica que cada bloqueo dura el doble que el anterior hasta
+// el máximo, que vence solo y que el desbloqueo manual reinicia la escalada
+func TestBloqueoLogin_Progresivo(t *testing.T) {
+	ctx := context.Background()
+	// El vencimiento se guarda en segundos
+	ahora := time.Now().Truncate(time.Second)
+	r := &RedisService{kv: memKV{}, logger: zap.NewNop(), ahora: func() time.Time { return ahora }}
+
+	for i := int64(1); i < MaxLoginAttempts; i++ {
+		if n, _ := r.IncrementAccountAttempts(ctx, "Dra "); n != i {
+			t.Fatalf("Se esperaban %d intentos, hay %d", i, n)
+		}
+	}
+	// Mayúsculas y espacios no esquivan el contador
+	if n, _ := r.GetAccountAttempts(ctx, "dra"); n != MaxLoginAttempts-1 {
+		t.Fatalf("Se esperaban %d intentos para la cuenta normalizada, hay %d", MaxLoginAttempts-1, n)
+	}
+
+	for i, esperado := range []time.Duration{BlockDuration, 2 * BlockDuration, 4 * BlockDuration} {
+		duracion, err := r.BlockAccount(ctx, "dra")
+		if err != nil || duracion != esperado {
+			t.Fatalf("Bloqueo %d: se esperaba %v, obtuvo %v (%v)", i+1, esperado, duracion, err)
+		}
+	}
+	if n, _ := r.GetAccountAttempts(ctx, "dra"); n != 0 {
+		t.Errorf("El bloqueo debería borrar los intentos, hay %d", n)
+	}
+	if bloqueada, restante, _ := r.IsAccountBlocked(ctx, "DRA"); !bloqueada || restante != 4*BlockDuration {
+		t.Errorf("Se esperaba la cuenta bloqueada %v, obtuvo %v %v", 4*BlockDuration, bloqueada, restante)
+	}
+	for i := 0; i < 20; i++ {
+		r.BlockAccount(ctx, "dra")
+	}
+	if _, restante, _ := r.IsAccountBlocked(ctx, "dra"); restante != MaxBlockDuration {
+		t.Errorf("Se esperaba el bloqueo máximo, obtuvo %v", restante)
+	}
+
+	// Vencido el plazo la cuenta queda libre aunque la clave siga en Redis
+	r.ahora = func() time.Time { return ahora.Add(MaxBlockDuration + time.Second) }
+	if bloqueada, _, _ := r.IsAccountBlocked(ctx, "dra"); bloqueada {
+		t.Error("El bloqueo debería haber vencido")
+	}
+
+	r.ahora = func() time.Time { return ahora }
+	if err := r.UnblockAccount(ctx, "dra"); err != nil {
+		t.Fatal(err)
+	}
+	if bloqueada, _, _ := r.IsAccountBlocked(ctx, "dra"); bloqueada {
+		t.Error("Se esperaba la cuenta desbloqueada")
+	}
+	if duracion, _ := r.BlockAccount(ctx, "dra"); duracion != BlockDuration {
+		t.Errorf("Tras el desbloqueo manual la escalada debería reiniciarse, obtuvo %v", duracion)
+	}
+
+	// Las IP se bloquean aparte
+	if bloqueada, _, _ := r.IsIPBlocked(ctx, "10.0.0.1"); bloqueada {
+		t.Error("La IP no debería estar bloqueada")
+	}
+	r.BlockIP(ctx, "10.0.0.1")
+	if bloqueada, _, _ := r.IsIPBlocked(ctx, "10.0.0.1"); !bloqueada {
+		t.Error("Se esperaba la IP bloqueada")
+	}
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RefreshTokenTTLDefault es la vida de un refresh token si no se configura otra
+const RefreshTokenTTLDefault = 30 * 24 * time.Hour
+
+var (
+	// ErrRefreshInvalido indica un refresh token inexistente o expirado
+	ErrRefreshInvalido = errors.New("refresh token inválido o expirado")
+	// ErrRefreshReutilizado indica que se presentó un refresh token ya rotado: la familia
+	// completa queda revocada porque el token pudo haber sido robado
+	ErrRefreshReutilizado = errors.New("refresh token reutilizado")
+	// ErrRefreshRevocado indica que la familia del token fue revocada
+	ErrRefreshRevocado = errors.New("refresh token revocado")
+)
+
+// RefreshToken es un refresh token emitido. Token solo se conoce al emitirlo: en Redis se
+// guarda su hash.
+type RefreshToken struct {
+	Token     string
+	UsuarioID string
+	// Familia agrupa los tokens que salen de un mismo login por rotaciones sucesivas
+	Familia  string
+	ExpiraEn time.Time
+}
+
+type registroRefresh struct {
+	UsuarioID string    `json:"usuario_id"`
+	Familia   string    `json:"familia"`
+	EmitidoEn time.Time `json:"emitido_en"`
+}
+
+// kvStore son las operaciones de Redis que usan los servicios de tokens
+type kvStore interface {
+	get(ctx context.Context, key string) (string, bool, error)
+	set(ctx context.Context, key, value string, ttl time.Duration) error
+	setNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	del(ctx context.Context, keys ...string) error
+	// sadd agrega member al conjunto key y renueva su vencimiento
+	sadd(ctx context.Context, key, member string, ttl time.Duration) error
+	smembers(ctx context.Context, key string) ([]string, error)
+	srem(ctx context.Context, key string, members ...string) error
+	// incr suma uno al contador key; al crearlo le pone vencimiento ttl
+	incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// RefreshTokenService emite y rota refresh tokens opacos guardados en Redis.
+//
+// Claves:
+//   - refresh:<hash> con el usuario y la familia del token, hasta que expira
+//   - refresh_rotado:<hash> marca que el token ya se usó; un segundo uso es reutilización
+//   - refresh_familia:<familia> existe mientras la familia esté vigente; se renueva en
+//     cada rotación y borrarla revoca todos sus tokens
+//   - refresh_usuario:<usuario> es el conjunto de familias del usuario, para revocarlas todas
+type RefreshTokenService struct {
+	kv     kvStore
+	ttl    time.Duration
+	logger *zap.Logger
+}
+
+// NewRefreshTokenService crea el servicio sobre client. ttl <= 0 usa RefreshTokenTTLDefault.
+func NewRefreshTokenService(client *redis.Client, ttl time.Duration, logger *zap.Logger) *RefreshTokenService {
+	if ttl <= 0 {
+		ttl = RefreshTokenTTLDefault
+	}
+	return &RefreshTokenService{kv: redisKV{client: client}, ttl: ttl, logger: logger}
+}
+
+// Emitir crea un refresh token para usuarioID en una familia nueva (un login)
+func (s *RefreshTokenService) Emitir(ctx context.Context, usuarioID string) (RefreshToken, error) {
+	return s.emitir(ctx, usuarioID, uuid.NewString())
+}
+
+func (s *RefreshTokenService) emitir(ctx context.Context, usuarioID, familia string) (RefreshToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return RefreshToken{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	ahora := time.Now()
+	registro, err := json.Marshal(registroRefresh{UsuarioID: usuarioID, Familia: familia, EmitidoEn: ahora})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if err := s.kv.set(ctx, "refresh_familia:"+familia, usuarioID, s.ttl); err != nil {
+		return RefreshToken{}, err
+	}
+	if err := s.kv.sadd(ctx, "refresh_usuario:"+usuarioID, familia, s.ttl); err != nil {
+		return RefreshToken{}, err
+	}
+	if err := s.kv.set(ctx, "refresh:"+hashToken(token), string(registro), s.ttl); err != nil {
+		return RefreshToken{}, err
+	}
+	return RefreshToken{Token: token, UsuarioID: usuarioID, Familia: familia, ExpiraEn: ahora.Add(s.ttl)}, nil
+}
+
+// Rotar canjea token por uno nuevo de la misma familia. Cada token sirve una sola vez:
+// si se presenta uno ya rotado se revoca la familia y se devuelve ErrRefreshReutilizado
+// junto con el usuario y la familia afectados.
+func (s *RefreshTokenService) Rotar(ctx context.Context, token string) (RefreshToken, error) {
+	hash := hashToken(token)
+	raw, ok, err := s.kv.get(ctx, "refresh:"+hash)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if !ok {
+		return RefreshToken{}, ErrRefreshInvalido
+	}
+	var reg registroRefresh
+	if err := json.Unmarshal([]byte(raw), &reg); err != nil {
+		return RefreshToken{}, ErrRefreshInvalido
+	}
+
+	primero, err := s.kv.setNX(ctx, "refresh_rotado:"+hash, "1", s.ttl)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if !primero {
+		if err := s.RevocarFamilia(ctx, reg.Familia); err != nil {
+			return RefreshToken{}, err
+		}
+		s.logger.Warn("Refresh token reutilizado, familia revocada",
+			zap.String("user_id", reg.UsuarioID),
+			zap.String("familia", reg.Familia))
+		return RefreshToken{UsuarioID: reg.UsuarioID, Familia: reg.Familia}, ErrRefreshReutilizado
+	}
+
+	vigente, err := s.familiaVigente(ctx, reg.Familia, reg.UsuarioID)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if !vigente {
+		return RefreshToken{UsuarioID: reg.UsuarioID, Familia: reg.Familia}, ErrRefreshRevocado
+	}
+	return s.emitir(ctx, reg.UsuarioID, reg.Familia)
+}
+
+// RevocarFamilia invalida todos los tokens de la familia
+func (s *RefreshTokenService) RevocarFamilia(ctx context.Context, familia string) error {
+	return s.kv.del(ctx, "refresh_familia:"+familia)
+}
+
+// familiaVigente indica si la familia de usuarioID no fue revocada ni venció
+func (s *RefreshTokenService) familiaVigente(ctx context.Context, familia, usuarioID string) (bool, error) {
+	titular, ok, err := s.kv.get(ctx, "refresh_familia:"+familia)
+	return ok && titular == usuarioID, err
+}
+
+// Revocar revoca la familia de token si pertenece a usuarioID (logout de una sesión).
+// Un token inexistente o de otro usuario se ignora.
+func (s *RefreshTokenService) Revocar(ctx context.Context, token, usuarioID string) error {
+	raw, ok, err := s.kv.get(ctx, "refresh:"+hashToken(token))
+	if err != nil || !ok {
+		return err
+	}
+	var reg registroRefresh
+	if err := json.Unmarshal([]byte(raw), &reg); err != nil || reg.UsuarioID != usuarioID {
+		return nil
+	}
+	return s.RevocarFamilia(ctx, reg.Familia)
+}
+
+// RevocarUsuario revoca todas las familias de refresh tokens del usuario
+func (s *RefreshTokenService) RevocarUsuario(ctx context.Context, usuarioID string) error {
+	familias, err := s.kv.smembers(ctx, "refresh_usuario:"+usuarioID)
+	if err != nil {
+		return err
+	}
+	claves := []string{"refresh_usuario:" + usuarioID}
+	for _, f := range familias {
+		claves = append(claves, "refresh_familia:"+f)
+	}
+	return s.kv.del(ctx, claves...)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type redisKV struct {
+	client *redis.Client
+}
+
+func (r redisKV) get(ctx context.Context, key string) (string, bool, error) {
+	v, err := r.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return v, err == nil, err
+}
+
+func (r redisKV) set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return r.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (r redisKV) setNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (r redisKV) del(ctx context.Context, keys ...string) error {
+	return r.client.Del(ctx, keys...).Err()
+}
+
+func (r redisKV) sadd(ctx context.Context, key, member string, ttl time.Duration) error {
+	pipe := r.client.TxPipeline()
+	pipe.SAdd(ctx, key, member)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r redisKV) incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := r.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		if err := r.client.Expire(ctx, key, ttl).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (r redisKV) smembers(ctx context.Context, key string) ([]string, error) {
+	return r.client.SMembers(ctx, key).Result()
+}
+
+func (r redisKV) srem(ctx context.Context, key string, members ...string) error {
+	args := make([]interface{}, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	return r.client.SRem(ctx, key, args...).Err()
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// memKV implementa kvStore en memoria (sin vencimientos); los conjuntos se guardan como
+// miembros separados por comas
+type memKV map[string]string
+
+func (m memKV) get(ctx context.Context, key string) (string, bool, error) {
+	v, ok := m[key]
+	return v, ok, nil
+}
+func (m memKV) set(ctx context.Context, key, value string, ttl time.Duration) error {
+	m[key] = value
+	return nil
+}
+func (m memKV) setNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	if _, ok := m[key]; ok {
+		return false, nil
+	}
+	m[key] = value
+	return true, nil
+}
+func (m memKV) del(ctx context.Context, keys ...string) error {
+	for _, k := range keys {
+		delete(m, k)
+	}
+	return nil
+}
+
+func (m memKV) sadd(ctx context.Context, key, member string, ttl time.Duration) error {
+	if m[key] == "" {
+		m[key] = member
+	} else {
+		m[key] += "," + member
+	}
+	return nil
+}
+func (m memKV) incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, _ := strconv.ParseInt(m[key], 10, 64)
+	n++
+	m[key] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+func (m memKV) smembers(ctx context.Context, key string) ([]string, error) {
+	if m[key] == "" {
+		return nil, nil
+	}
+	return strings.Split(m[key], ","), nil
+}
+func (m memKV) srem(ctx context.Context, key string, members ...string) error {
+	actuales, _ := m.smembers(ctx, key)
+	var quedan []string
+	for _, a := range actuales {
+		quitar := false
+		for _, b := range members {
+			quitar = quitar || a == b
+		}
+		if !quitar {
+			quedan = append(quedan, a)
+		}
+	}
+	if len(quedan) == 0 {
+		delete(m, key)
+	} else {
+		m[key] = strings.Join(quedan, ",")
+	}
+	return nil
+}
+
+func TestRefreshToken_RotaYDetectaReutilizacion(t *testing.T) {
+	ctx := context.Background()
+	kv := memKV{}
+	s := &RefreshTokenService{kv: kv, ttl: time.Hour, logger: zap.NewNop()}
+
+	primero, err := s.Emitir(ctx, "u1")
+	if err != nil || primero.Token == "" {
+		t.Fatalf("Error al emitir: %v", err)
+	}
+	for k, v := range kv {
+		if strings.Contains(k, primero.Token) || strings.Contains(v, primero.Token) {
+			t.Fatal("El refresh token se guardó en claro")
+		}
+	}
+
+	segundo, err := s.Rotar(ctx, primero.Token)
+	if err != nil {
+		t.Fatalf("Error al rotar: %v", err)
+	}
+	if segundo.Token == primero.Token || segundo.Familia != primero.Familia || segundo.UsuarioID != "u1" {
+		t.Fatalf("Se esperaba un token nuevo de la misma familia, obtuvo %+v", segundo)
+	}
+
+	// Reusar el token ya rotado revoca la familia, incluido el token vigente
+	reuso, err := s.Rotar(ctx, primero.Token)
+	if !errors.Is(err, ErrRefreshReutilizado) || reuso.UsuarioID != "u1" || reuso.Familia != primero.Familia {
+		t.Fatalf("Se esperaba ErrRefreshReutilizado con la familia, obtuvo %+v %v", reuso, err)
+	}
+	if _, err := s.Rotar(ctx, segundo.Token); !errors.Is(err, ErrRefreshRevocado) {
+		t.Errorf("Se esperaba ErrRefreshRevocado para el token vigente de la familia, obtuvo %v", err)
+	}
+
+	// Otros logins del usuario no se ven afectados
+	otro, _ := s.Emitir(ctx, "u1")
+	if _, err := s.Rotar(ctx, otro.Token); err != nil {
+		t.Errorf("Se esperaba rotar un token de otra familia, obtuvo %v", err)
+	}
+
+	if _, err := s.Rotar(ctx, "inexistente"); !errors.Is(err, ErrRefreshInvalido) {
+		t.Errorf("Se esperaba ErrRefreshInvalido, obtuvo %v", err)
+	}
+}
+
+func TestRefreshToken_Revocar(t *testing.T) {
+	ctx := context.Background()
+	s := &RefreshTokenService{kv: memKV{}, ttl: time.Hour, logger: zap.NewNop()}
+	a, _ := s.Emitir(ctx, "u1")
+	b, _ := s.Emitir(ctx, "u1")
+	c, _ := s.Emitir(ctx, "u2")
+
+	// El token de otro usuario no se revoca
+	s.Revocar(ctx, c.Token, "u1")
+	c2, err := s.Rotar(ctx, c.Token)
+	if err != nil {
+		t.Fatalf("No se esperaba revocar el token de otro usuario: %v", err)
+	}
+
+	s.Revocar(ctx, a.Token, "u1")
+	if _, err := s.Rotar(ctx, a.Token); !errors.Is(err, ErrRefreshRevocado) {
+		t.Errorf("Se esperaba ErrRefreshRevocado tras el logout, obtuvo %v", err)
+	}
+	b2, err := s.Rotar(ctx, b.Token)
+	if err != nil {
+		t.Fatalf("Se esperaba que la otra sesión siga vigente: %v", err)
+	}
+
+	// Revocar al usuario alcanza a todas sus familias, no a las de otros usuarios
+	s.RevocarUsuario(ctx, "u1")
+	if _, err := s.Rotar(ctx, b2.Token); !errors.Is(err, ErrRefreshRevocado) {
+		t.Errorf("Se esperaba ErrRefreshRevocado tras revocar al usuario, obtuvo %v", err)
+	}
+	if _, err := s.Rotar(ctx, c2.Token); err != nil {
+		t.Errorf("Se esperaba que la sesión de u2 siga vigente, obtuvo %v", err)
+	}
+}
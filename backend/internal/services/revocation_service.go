@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FolkodeGroup/mediapp/internal/auth"
+	"github.com/go-redis/redis/v8"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// revocacionesChannel es el canal de NOTIFY del trigger de usuarios: se dispara al
+// desactivar un usuario o cambiar su contraseña, con payload "<usuario_id>:<epoch>"
+const revocacionesChannel = "usuarios_revocados"
+
+// RevocationService mantiene en Redis la lista de access tokens revocados. Cada entrada
+// vence junto con los tokens que revoca, así que la lista no crece sin límite.
+//
+// Claves:
+//   - revocado:jti:<jti> revoca un token (logout); vence cuando vence el token
+//   - revocado:usuario:<usuario> guarda el instante (epoch) desde el que se revocan todos
+//     los tokens del usuario emitidos antes; dura lo mismo que un access token
+type RevocationService struct {
+	kv      kvStore
+	refresh *RefreshTokenService
+	logger  *zap.Logger
+}
+
+// NewRevocationService crea el servicio. refresh, si no es nil, se usa para revocar también
+// los refresh tokens al revocar a un usuario.
+func NewRevocationService(client *redis.Client, refresh *RefreshTokenService, logger *zap.Logger) *RevocationService {
+	return &RevocationService{kv: redisKV{client: client}, refresh: refresh, logger: logger}
+}
+
+// RevocarToken revoca el access token jti hasta su vencimiento
+func (s *RevocationService) RevocarToken(ctx context.Context, jti string, expira time.Time) error {
+	ttl := time.Until(expira)
+	if jti == "" || ttl <= 0 {
+		return nil
+	}
+	return s.kv.set(ctx, "revocado:jti:"+jti, "1", ttl)
+}
+
+// RevocarUsuario revoca todos los tokens del usuario emitidos antes de desde, y todos sus
+// refresh tokens. La marca tiene resolución de segundos: un token emitido en el mismo
+// segundo que desde sigue siendo válido, para no rechazar un login inmediato posterior.
+func (s *RevocationService) RevocarUsuario(ctx context.Context, usuarioID string, desde time.Time) error {
+	clave := "revocado:usuario:" + usuarioID
+	// Nunca retroceder la marca (una notificación vieja reprocesada tras una reconexión)
+	if actual, ok, err := s.kv.get(ctx, clave); err != nil {
+		return err
+	} else if ok {
+		if marca, err := strconv.ParseInt(actual, 10, 64); err == nil && marca >= desde.Unix() {
+			return s.revocarRefresh(ctx, usuarioID)
+		}
+	}
+	if err := s.kv.set(ctx, clave, strconv.FormatInt(desde.Unix(), 10), auth.AccessTokenTTL); err != nil {
+		return err
+	}
+	return s.revocarRefresh(ctx, usuarioID)
+}
+
+func (s *RevocationService) revocarRefresh(ctx context.Context, usuarioID string) error {
+	if s.refresh == nil {
+		return nil
+	}
+	return s.refresh.RevocarUsuario(ctx, usuarioID)
+}
+
+// Revocado indica si el token jti del usuario, emitido en emitido, fue revocado
+func (s *RevocationService) Revocado(ctx context.Context, jti, usuarioID string, emitido time.Time) (bool, error) {
+	if jti != "" {
+		if _, ok, err := s.kv.get(ctx, "revocado:jti:"+jti); err != nil || ok {
+			return ok, err
+		}
+	}
+	actual, ok, err := s.kv.get(ctx, "revocado:usuario:"+usuarioID)
+	if err != nil || !ok {
+		return false, err
+	}
+	marca, err := strconv.ParseInt(actual, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	return emitido.Unix() < marca, nil
+}
+
+// handleNotification procesa el payload "<usuario_id>:<epoch>" del trigger de usuarios
+func (s *RevocationService) handleNotification(ctx context.Context, payload string) {
+	usuarioID, epoch, ok := strings.Cut(payload, ":")
+	segundos, err := strconv.ParseInt(epoch, 10, 64)
+	if !ok || err != nil {
+		s.logger.Warn("Notificación de revocación inválida", zap.String("payload", payload))
+		return
+	}
+	if err := s.RevocarUsuario(ctx, usuarioID, time.Unix(segundos, 0)); err != nil {
+		s.logger.Error("Error al revocar tokens del usuario", zap.String("user_id", usuarioID), zap.Error(err))
+		return
+	}
+	s.logger.Info("Tokens del usuario revocados", zap.String("user_id", usuarioID))
+}
+
+// Listen escucha las desactivaciones y cambios de contraseña de usuarios y revoca sus
+// tokens. Bloquea hasta que se cancele el contexto. Al (re)conectar repasa los cambios de
+// las últimas 24 horas (la vida de un access token) por si se perdieron notificaciones.
+func (s *RevocationService) Listen(ctx context.Context, pool *pgxpool.Pool) {
+	for {
+		err := s.listenOnce(ctx, pool)
+		if ctx.Err() != nil {
+			return
+		}
+		s.logger.Warn("Conexión de LISTEN de revocaciones interrumpida, reintentando", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (s *RevocationService) listenOnce(ctx context.Context, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+revocacionesChannel); err != nil {
+		return err
+	}
+	s.logger.Info("Escuchando revocaciones de usuarios", zap.String("canal", revocacionesChannel))
+	if err := s.sincronizar(ctx, pool); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		s.handleNotification(ctx, notification.Payload)
+	}
+}
+
+// sincronizar vuelve a aplicar las revocaciones recientes registradas en usuarios
+func (s *RevocationService) sincronizar(ctx context.Context, pool *pgxpool.Pool) error {
+	rows, err := pool.Query(ctx, `
+		SELECT id::text || ':' || floor(extract(epoch FROM credenciales_cambiadas_en))::bigint
+		FROM usuarios
+		WHERE credenciales_cambiadas_en > NOW() - make_interval(secs => $1)
+	`, auth.AccessTokenTTL.Seconds())
+	if err != nil {
+		return err
+	}
+	var payloads []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			rows.Close()
+			return err
+		}
+		payloads = append(payloads, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, p := range payloads {
+		s.handleNotification(ctx, p)
+	}
+	return nil
+}
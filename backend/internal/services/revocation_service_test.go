@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestRevocation_TokenYUsuario verifica la revocación por jti y por usuario, y que la marca
+// del usuario no retrocede ni rechaza tokens emitidos en el mismo segundo
+func TestRevocation_TokenYUsuario(t *testing.T) {
+	ctx := context.Background()
+	kv := memKV{}
+	refresh := &RefreshTokenService{kv: kv, ttl: time.Hour, logger: zap.NewNop()}
+	s := &RevocationService{kv: kv, refresh: refresh, logger: zap.NewNop()}
+	ahora := time.Now().Truncate(time.Second)
+
+	if err := s.RevocarToken(ctx, "jti-1", ahora.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Revocado(ctx, "jti-1", "u1", ahora); !ok {
+		t.Error("Se esperaba el jti revocado")
+	}
+	if ok, _ := s.Revocado(ctx, "jti-2", "u1", ahora); ok {
+		t.Error("Otro jti no debería estar revocado")
+	}
+	// Un token ya vencido no necesita entrada
+	s.RevocarToken(ctx, "jti-viejo", ahora.Add(-time.Minute))
+	if _, ok := kv["revocado:jti:jti-viejo"]; ok {
+		t.Error("No se esperaba revocar un token vencido")
+	}
+
+	rt, _ := refresh.Emitir(ctx, "u1")
+	if err := s.RevocarUsuario(ctx, "u1", ahora); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Revocado(ctx, "jti-3", "u1", ahora.Add(-time.Second)); !ok {
+		t.Error("Se esperaba revocado un token emitido antes")
+	}
+	if ok, _ := s.Revocado(ctx, "jti-4", "u1", ahora.Add(500*time.Millisecond)); ok {
+		t.Error("Un token emitido en el mismo segundo debería seguir válido")
+	}
+	if _, err := refresh.Rotar(ctx, rt.Token); err != ErrRefreshRevocado {
+		t.Errorf("Se esperaba el refresh token revocado, obtuvo %v", err)
+	}
+
+	// Una notificación vieja no retrocede la marca
+	s.handleNotification(ctx, "u1:1")
+	if ok, _ := s.Revocado(ctx, "jti-5", "u1", ahora.Add(-time.Second)); !ok {
+		t.Error("La marca del usuario no debería retroceder")
+	}
+	s.handleNotification(ctx, "inválido")
+}
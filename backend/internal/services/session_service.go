@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// actividadMinima es cada cuánto se registra la actividad de una sesión: los pedidos más
+// seguidos solo la consultan, para no escribir en Redis en cada request
+const actividadMinima = time.Minute
+
+// ErrSesionInexistente indica una sesión cerrada, vencida o de otro usuario
+var ErrSesionInexistente = errors.New("sesión inexistente")
+
+// Sesion es un login del usuario en un dispositivo. Su ID es la familia de refresh tokens
+// del login y viaja en el access token (claim sid).
+type Sesion struct {
+	ID              string    `json:"id"`
+	UsuarioID       string    `json:"usuario_id"`
+	Dispositivo     string    `json:"dispositivo"`
+	IP              string    `json:"ip"`
+	CreadaEn        time.Time `json:"creada_en"`
+	UltimaActividad time.Time `json:"ultima_actividad"`
+}
+
+// SessionService lleva en Redis el registro de sesiones abiertas por usuario, con el
+// dispositivo (user agent), la IP, el inicio y la última actividad de cada una.
+//
+// Claves:
+//   - sesion:<id> con los datos de la sesión; vence con la familia de refresh tokens y se
+//     renueva con la actividad
+//   - sesiones_usuario:<usuario> es el conjunto de sesiones del usuario
+//
+// Cerrar una sesión borra su registro y revoca su familia de refresh tokens; los access
+// tokens que la nombran se rechazan desde ese momento (ver Activa).
+type SessionService struct {
+	kv      kvStore
+	refresh *RefreshTokenService
+	ttl     time.Duration
+	logger  *zap.Logger
+	ahora   func() time.Time
+}
+
+// NewSessionService crea el servicio. Las sesiones duran lo mismo que los refresh tokens
+// de refresh; si refresh es nil duran RefreshTokenTTLDefault.
+func NewSessionService(client *redis.Client, refresh *RefreshTokenService, logger *zap.Logger) *SessionService {
+	ttl := RefreshTokenTTLDefault
+	if refresh != nil {
+		ttl = refresh.ttl
+	}
+	return &SessionService{kv: redisKV{client: client}, refresh: refresh, ttl: ttl, logger: logger, ahora: time.Now}
+}
+
+// Registrar registra la sesión id de usuarioID desde dispositivo e ip: al iniciarla en el
+// login y en cada /refresh, que cuenta como actividad. Una sesión sin registro (login
+// anterior al registro de sesiones) se registra con el inicio en ese momento.
+func (s *SessionService) Registrar(ctx context.Context, id, usuarioID, dispositivo, ip string) error {
+	ahora := s.ahora()
+	sesion, ok, err := s.cargar(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok || sesion.UsuarioID != usuarioID {
+		sesion = Sesion{ID: id, UsuarioID: usuarioID, CreadaEn: ahora}
+	}
+	if dispositivo != "" {
+		sesion.Dispositivo = dispositivo
+	}
+	sesion.IP = ip
+	sesion.UltimaActividad = ahora
+	if err := s.guardar(ctx, sesion); err != nil {
+		return err
+	}
+	return s.kv.sadd(ctx, "sesiones_usuario:"+usuarioID, id, s.ttl)
+}
+
+// Activa indica si la sesión id de usuarioID sigue abierta y, si pasó actividadMinima
+// desde la última vez, registra la actividad desde ip
+func (s *SessionService) Activa(ctx context.Context, id, usuarioID, ip string) (bool, error) {
+	sesion, ok, err := s.cargar(ctx, id)
+	if err != nil || !ok || sesion.UsuarioID != usuarioID {
+		return false, err
+	}
+	if vigente, err := s.familiaVigente(ctx, sesion); err != nil || !vigente {
+		return false, err
+	}
+	ahora := s.ahora()
+	if ahora.Sub(sesion.UltimaActividad) < actividadMinima && sesion.IP == ip {
+		return true, nil
+	}
+	sesion.UltimaActividad = ahora
+	sesion.IP = ip
+	if err := s.guardar(ctx, sesion); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Listar devuelve las sesiones abiertas de usuarioID, de la más reciente a la más antigua.
+// Las que cerró un logout o vencieron se quitan del registro.
+func (s *SessionService) Listar(ctx context.Context, usuarioID string) ([]Sesion, error) {
+	ids, err := s.kv.smembers(ctx, "sesiones_usuario:"+usuarioID)
+	if err != nil {
+		return nil, err
+	}
+	sesiones := []Sesion{}
+	var cerradas []string
+	for _, id := range ids {
+		sesion, ok, err := s.cargar(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok && sesion.UsuarioID == usuarioID {
+			vigente, err := s.familiaVigente(ctx, sesion)
+			if err != nil {
+				return nil, err
+			}
+			if vigente {
+				sesiones = append(sesiones, sesion)
+				continue
+			}
+		}
+		cerradas = append(cerradas, id)
+	}
+	if len(cerradas) > 0 {
+		if err := s.quitar(ctx, usuarioID, cerradas...); err != nil {
+			s.logger.Warn("Error al limpiar sesiones cerradas", zap.Error(err), zap.String("user_id", usuarioID))
+		}
+	}
+	sort.Slice(sesiones, func(i, j int) bool {
+		return sesiones[i].UltimaActividad.After(sesiones[j].UltimaActividad)
+	})
+	return sesiones, nil
+}
+
+// Cerrar cierra la sesión id de usuarioID: revoca su familia de refresh tokens y borra el
+// registro. Devuelve la sesión cerrada, o ErrSesionInexistente si no está abierta o es de
+// otro usuario.
+func (s *SessionService) Cerrar(ctx context.Context, usuarioID, id string) (Sesion, error) {
+	sesion, ok, err := s.cargar(ctx, id)
+	if err != nil {
+		return Sesion{}, err
+	}
+	if !ok || sesion.UsuarioID != usuarioID {
+		return Sesion{}, ErrSesionInexistente
+	}
+	if s.refresh != nil {
+		if err := s.refresh.RevocarFamilia(ctx, id); err != nil {
+			return Sesion{}, err
+		}
+	}
+	return sesion, s.quitar(ctx, usuarioID, id)
+}
+
+func (s *SessionService) cargar(ctx context.Context, id string) (Sesion, bool, error) {
+	raw, ok, err := s.kv.get(ctx, "sesion:"+id)
+	if err != nil || !ok {
+		return Sesion{}, false, err
+	}
+	var sesion Sesion
+	if err := json.Unmarshal([]byte(raw), &sesion); err != nil {
+		return Sesion{}, false, nil
+	}
+	return sesion, true, nil
+}
+
+func (s *SessionService) guardar(ctx context.Context, sesion Sesion) error {
+	raw, err := json.Marshal(sesion)
+	if err != nil {
+		return err
+	}
+	return s.kv.set(ctx, "sesion:"+sesion.ID, string(raw), s.ttl)
+}
+
+// quitar borra los registros de las sesiones ids y las saca del conjunto del usuario
+func (s *SessionService) quitar(ctx context.Context, usuarioID string, ids ...string) error {
+	claves := make([]string, 0, len(ids))
+	for _, id := range ids {
+		claves = append(claves, "sesion:"+id)
+	}
+	if err := s.kv.del(ctx, claves...); err != nil {
+		return err
+	}
+	return s.kv.srem(ctx, "sesiones_usuario:"+usuarioID, ids...)
+}
+
+// familiaVigente indica si la familia de refresh tokens de la sesión sigue vigente: un
+// logout, un logout-all o la reutilización de un refresh token la revocan y con ella se
+// cierra la sesión
+func (s *SessionService) familiaVigente(ctx context.Context, sesion Sesion) (bool, error) {
+	if s.refresh == nil {
+		return true, nil
+	}
+	return s.refresh.familiaVigente(ctx, sesion.ID, sesion.UsuarioID)
+}
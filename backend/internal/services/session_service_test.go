@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSesiones_RegistroYCierre(t *testing.T) {
+	ctx := context.Background()
+	kv := memKV{}
+	ahora := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	refresh := &RefreshTokenService{kv: kv, ttl: time.Hour, logger: zap.NewNop()}
+	s := &SessionService{kv: kv, refresh: refresh, ttl: time.Hour, logger: zap.NewNop(), ahora: func() time.Time { return ahora }}
+
+	consultorio, _ := refresh.Emitir(ctx, "u1")
+	casa, _ := refresh.Emitir(ctx, "u1")
+	if err := s.Registrar(ctx, consultorio.Familia, "u1", "Firefox", "10.0.0.1"); err != nil {
+		t.Fatalf("Error al registrar: %v", err)
+	}
+
+	// La actividad se registra a lo sumo una vez por minuto, salvo que cambie la IP
+	ahora = ahora.Add(30 * time.Second)
+	if activa, err := s.Activa(ctx, consultorio.Familia, "u1", "10.0.0.1"); err != nil || !activa {
+		t.Fatalf("Se esperaba la sesión activa, obtuvo %v %v", activa, err)
+	}
+	s.Registrar(ctx, casa.Familia, "u1", "Safari", "10.0.0.2")
+	sesiones, _ := s.Listar(ctx, "u1")
+	if len(sesiones) != 2 || sesiones[0].ID != casa.Familia {
+		t.Fatalf("Se esperaban dos sesiones con la más reciente primero, obtuvo %+v", sesiones)
+	}
+	ahora = ahora.Add(time.Minute)
+	s.Activa(ctx, consultorio.Familia, "u1", "10.0.0.1")
+	sesiones, _ = s.Listar(ctx, "u1")
+	if sesiones[0].ID != consultorio.Familia || !sesiones[0].UltimaActividad.Equal(ahora) || sesiones[0].Dispositivo != "Firefox" {
+		t.Fatalf("Se esperaba registrar la actividad, obtuvo %+v", sesiones[0])
+	}
+
+	// Un /refresh cuenta como actividad y conserva el inicio de la sesión
+	s.Registrar(ctx, consultorio.Familia, "u1", "", "10.0.0.3")
+	sesiones, _ = s.Listar(ctx, "u1")
+	if sesiones[0].IP != "10.0.0.3" || sesiones[0].Dispositivo != "Firefox" || sesiones[0].CreadaEn.Equal(sesiones[0].UltimaActividad) {
+		t.Fatalf("Se esperaba actualizar la sesión existente, obtuvo %+v", sesiones[0])
+	}
+
+	// Otro usuario no puede cerrar ni usar la sesión
+	if _, err := s.Cerrar(ctx, "u2", consultorio.Familia); !errors.Is(err, ErrSesionInexistente) {
+		t.Fatalf("Se esperaba ErrSesionInexistente, obtuvo %v", err)
+	}
+	if activa, _ := s.Activa(ctx, consultorio.Familia, "u2", "10.0.0.1"); activa {
+		t.Fatal("La sesión no debe valer para otro usuario")
+	}
+
+	cerrada, err := s.Cerrar(ctx, "u1", consultorio.Familia)
+	if err != nil || cerrada.Dispositivo != "Firefox" {
+		t.Fatalf("Error al cerrar: %+v %v", cerrada, err)
+	}
+	if activa, _ := s.Activa(ctx, consultorio.Familia, "u1", "10.0.0.1"); activa {
+		t.Error("Se esperaba la sesión cerrada")
+	}
+	if _, err := refresh.Rotar(ctx, consultorio.Token); !errors.Is(err, ErrRefreshRevocado) {
+		t.Errorf("Se esperaba revocar los refresh tokens de la sesión, obtuvo %v", err)
+	}
+
+	// Un logout-all revoca las familias: las sesiones dejan de valer y salen del listado
+	refresh.RevocarUsuario(ctx, "u1")
+	if activa, _ := s.Activa(ctx, casa.Familia, "u1", "10.0.0.2"); activa {
+		t.Error("Se esperaba la sesión cerrada tras revocar al usuario")
+	}
+	if sesiones, _ := s.Listar(ctx, "u1"); len(sesiones) != 0 {
+		t.Errorf("No se esperaban sesiones abiertas, obtuvo %+v", sesiones)
+	}
+	if _, ok := kv["sesion:"+casa.Familia]; ok {
+		t.Error("Se esperaba limpiar el registro de la sesión revocada")
+	}
+}
@@ -0,0 +1,102 @@
+// Package tenant limita las consultas de los handlers al consultorio del usuario.
+//
+// TenantScope (middleware) guarda un Scope en el contexto de gin a partir del JWT.
+// Los handlers lo obtienen con FromContext y agregan a cada consulta la condición
+// que devuelven Consultorio o Paciente, de modo que un consultorio nunca lee ni
+// modifica filas de otro.
+package tenant
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// PermisoGlobal habilita el acceso a otros consultorios (super-admin)
+	PermisoGlobal = "consultorios:global"
+
+	// HeaderConsultorio indica el consultorio sobre el que actúa un super-admin,
+	// o "*" para operar sobre todos
+	HeaderConsultorio = "X-Consultorio-ID"
+
+	// Todos es el valor de HeaderConsultorio para el alcance global
+	Todos = "*"
+
+	contextKey = "tenant_scope"
+)
+
+// Scope es el conjunto de filas visibles para el request
+type Scope struct {
+	// ConsultorioID es el consultorio activo; uuid.Nil solo en alcance global
+	ConsultorioID uuid.UUID
+	// Global indica un super-admin operando sobre todos los consultorios
+	Global bool
+	// CrossTenant indica que el consultorio activo no es el del usuario
+	CrossTenant bool
+
+	// pacientes habilitados fuera del consultorio por asignación o acceso de emergencia
+	pacientes []uuid.UUID
+}
+
+// Set guarda el alcance en el contexto
+func Set(c *gin.Context, s Scope) {
+	c.Set(contextKey, s)
+}
+
+// FromContext devuelve el alcance guardado por TenantScope
+func FromContext(c *gin.Context) (Scope, bool) {
+	v, ok := c.Get(contextKey)
+	if !ok {
+		return Scope{}, false
+	}
+	s, ok := v.(Scope)
+	return s, ok
+}
+
+// HabilitarPaciente agrega al alcance un paciente de otro consultorio. Lo usa
+// RequirePatientAccess cuando el acceso está permitido por asignación o emergencia.
+func HabilitarPaciente(c *gin.Context, pacienteID uuid.UUID) {
+	s, ok := FromContext(c)
+	if !ok {
+		return
+	}
+	s.pacientes = append(append([]uuid.UUID(nil), s.pacientes...), pacienteID)
+	Set(c, s)
+}
+
+// Incluye indica si una fila del consultorio dado es visible
+func (s Scope) Incluye(consultorioID *uuid.UUID) bool {
+	if s.Global {
+		return true
+	}
+	return consultorioID != nil && *consultorioID == s.ConsultorioID
+}
+
+// Consultorio devuelve la condición SQL que limita la columna al consultorio
+// activo y agrega su argumento a args
+func (s Scope) Consultorio(columna string, args *[]interface{}) string {
+	if s.Global {
+		return "TRUE"
+	}
+	return columna + " = " + arg(args, s.ConsultorioID)
+}
+
+// Paciente devuelve la condición SQL que limita una columna paciente_id a los
+// pacientes del consultorio activo y a los habilitados explícitamente
+func (s Scope) Paciente(columna string, args *[]interface{}) string {
+	if s.Global {
+		return "TRUE"
+	}
+	cond := columna + " IN (SELECT id FROM pacientes WHERE consultorio_id = " + arg(args, s.ConsultorioID) + ")"
+	if len(s.pacientes) > 0 {
+		cond = "(" + cond + " OR " + columna + " = ANY(" + arg(args, s.pacientes) + "))"
+	}
+	return cond
+}
+
+func arg(args *[]interface{}, v interface{}) string {
+	*args = append(*args, v)
+	return "$" + strconv.Itoa(len(*args))
+}
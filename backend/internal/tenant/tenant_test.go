@@ -0,0 +1,53 @@
+package tenant
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestScopeConsultorio(t *testing.T) {
+	consultorio := uuid.New()
+	s := Scope{ConsultorioID: consultorio}
+
+	args := []interface{}{"x"}
+	cond := s.Consultorio("p.consultorio_id", &args)
+	if cond != "p.consultorio_id = $2" {
+		t.Errorf("Condición inesperada: %s", cond)
+	}
+	if len(args) != 2 || args[1] != consultorio {
+		t.Errorf("Se esperaba agregar el consultorio a los argumentos, obtuvo %v", args)
+	}
+}
+
+func TestScopeGlobalNoFiltra(t *testing.T) {
+	s := Scope{Global: true}
+	var args []interface{}
+	if cond := s.Paciente("paciente_id", &args); cond != "TRUE" || len(args) != 0 {
+		t.Errorf("Se esperaba no filtrar en alcance global, obtuvo %q %v", cond, args)
+	}
+	if !s.Incluye(nil) {
+		t.Error("Se esperaba que el alcance global incluya cualquier consultorio")
+	}
+}
+
+func TestScopeIncluye(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	s := Scope{ConsultorioID: a}
+	if !s.Incluye(&a) {
+		t.Error("Se esperaba incluir el propio consultorio")
+	}
+	if s.Incluye(&b) || s.Incluye(nil) {
+		t.Error("No se esperaba incluir otro consultorio")
+	}
+}
+
+func TestScopePacienteHabilitado(t *testing.T) {
+	s := Scope{ConsultorioID: uuid.New(), pacientes: []uuid.UUID{uuid.New()}}
+	var args []interface{}
+	cond := s.Paciente("h.paciente_id", &args)
+	want := "(h.paciente_id IN (SELECT id FROM pacientes WHERE consultorio_id = $1) OR h.paciente_id = ANY($2))"
+	if cond != want {
+		t.Errorf("Condición inesperada:\n%s\n%s", cond, want)
+	}
+}
@@ -0,0 +1,101 @@
+-- +goose Up
+-- Roles base del sistema
+INSERT INTO roles (nombre_rol) VALUES
+    ('admin'),
+    ('medico'),
+    ('recepcionista')
+ON CONFLICT (nombre_rol) DO NOTHING;
+
+-- Permisos con formato "recurso:accion"
+INSERT INTO permisos (nombre_permiso) VALUES
+    ('pacientes:read'),
+    ('pacientes:write'),
+    ('pacientes:delete'),
+    ('historias:read'),
+    ('historias:write'),
+    ('turnos:read'),
+    ('turnos:write'),
+    ('recetas:read'),
+    ('recetas:write'),
+    ('roles:manage'),
+    ('sistema:diagnostico')
+ON CONFLICT (nombre_permiso) DO NOTHING;
+
+-- admin: todos los permisos
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r CROSS JOIN permisos p
+WHERE r.nombre_rol = 'admin'
+ON CONFLICT DO NOTHING;
+
+-- medico: datos clínicos completos, sin administración
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r JOIN permisos p ON p.nombre_permiso IN (
+    'pacientes:read', 'pacientes:write',
+    'historias:read', 'historias:write',
+    'turnos:read', 'turnos:write',
+    'recetas:read', 'recetas:write'
+)
+WHERE r.nombre_rol = 'medico'
+ON CONFLICT DO NOTHING;
+
+-- recepcionista: agenda y datos administrativos del paciente, sin datos clínicos
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r JOIN permisos p ON p.nombre_permiso IN (
+    'pacientes:read', 'pacientes:write',
+    'turnos:read', 'turnos:write'
+)
+WHERE r.nombre_rol = 'recepcionista'
+ON CONFLICT DO NOTHING;
+
+-- Notificar cambios en rol_permiso para invalidar la caché de permisos
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notificar_cambio_rol_permiso() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('rol_permiso_cambios', OLD.rol_id::text);
+    ELSE
+        PERFORM pg_notify('rol_permiso_cambios', NEW.rol_id::text);
+        IF TG_OP = 'UPDATE' AND OLD.rol_id <> NEW.rol_id THEN
+            PERFORM pg_notify('rol_permiso_cambios', OLD.rol_id::text);
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS rol_permiso_cambios ON rol_permiso;
+CREATE TRIGGER rol_permiso_cambios
+AFTER INSERT OR UPDATE OR DELETE ON rol_permiso
+FOR EACH ROW EXECUTE FUNCTION notificar_cambio_rol_permiso();
+
+-- Los cambios en permisos (renombres o borrados) afectan a todos los roles
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION notificar_cambio_permisos() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('rol_permiso_cambios', '*');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS permisos_cambios ON permisos;
+CREATE TRIGGER permisos_cambios
+AFTER UPDATE OR DELETE ON permisos
+FOR EACH STATEMENT EXECUTE FUNCTION notificar_cambio_permisos();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS permisos_cambios ON permisos;
+DROP FUNCTION IF EXISTS notificar_cambio_permisos();
+DROP TRIGGER IF EXISTS rol_permiso_cambios ON rol_permiso;
+DROP FUNCTION IF EXISTS notificar_cambio_rol_permiso();
+DELETE FROM permisos WHERE nombre_permiso IN (
+    'pacientes:read', 'pacientes:write', 'pacientes:delete',
+    'historias:read', 'historias:write',
+    'turnos:read', 'turnos:write',
+    'recetas:read', 'recetas:write',
+    'roles:manage', 'sistema:diagnostico'
+);
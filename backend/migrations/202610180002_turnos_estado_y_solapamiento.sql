@@ -0,0 +1,35 @@
+-- +goose Up
+-- Duración y estado de los turnos
+ALTER TABLE turnos
+    ADD COLUMN IF NOT EXISTS duracion_minutos INT NOT NULL DEFAULT 30 CHECK (duracion_minutos > 0),
+    ADD COLUMN IF NOT EXISTS estado VARCHAR(20) NOT NULL DEFAULT 'programado'
+        CHECK (estado IN ('programado', 'cancelado')),
+    ADD COLUMN IF NOT EXISTS motivo_cancelacion TEXT,
+    ADD COLUMN IF NOT EXISTS cancelado_en TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS creado_en TIMESTAMP NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_turnos_usuario_fecha ON turnos (usuario_id, fecha);
+CREATE INDEX IF NOT EXISTS idx_turnos_paciente_fecha ON turnos (paciente_id, fecha);
+
+-- Un profesional no puede tener dos turnos activos que se superpongan.
+-- El handler ya lo verifica dentro de una transacción; esta restricción es la
+-- garantía final frente a escrituras concurrentes.
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+ALTER TABLE turnos
+    ADD CONSTRAINT turnos_sin_solapamiento
+    EXCLUDE USING gist (
+        usuario_id WITH =,
+        tsrange(fecha, fecha + make_interval(mins => duracion_minutos)) WITH &&
+    ) WHERE (estado <> 'cancelado');
+
+-- +goose Down
+ALTER TABLE turnos DROP CONSTRAINT IF EXISTS turnos_sin_solapamiento;
+DROP INDEX IF EXISTS idx_turnos_paciente_fecha;
+DROP INDEX IF EXISTS idx_turnos_usuario_fecha;
+ALTER TABLE turnos
+    DROP COLUMN IF EXISTS creado_en,
+    DROP COLUMN IF EXISTS cancelado_en,
+    DROP COLUMN IF EXISTS motivo_cancelacion,
+    DROP COLUMN IF EXISTS estado,
+    DROP COLUMN IF EXISTS duracion_minutos;
@@ -0,0 +1,46 @@
+-- +goose Up
+-- Número correlativo de versión dentro de cada historia clínica
+ALTER TABLE historia_clinica_version
+    ADD COLUMN IF NOT EXISTS numero_version INT;
+
+UPDATE historia_clinica_version v
+SET numero_version = n.numero
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY historia_clinica_id ORDER BY modificado_en, id) AS numero
+    FROM historia_clinica_version
+) n
+WHERE v.id = n.id AND v.numero_version IS NULL;
+
+ALTER TABLE historia_clinica_version
+    ALTER COLUMN numero_version SET NOT NULL,
+    ADD CONSTRAINT historia_clinica_version_numero_unico UNIQUE (historia_clinica_id, numero_version);
+
+CREATE INDEX IF NOT EXISTS idx_historias_clinicas_paciente ON historias_clinicas (paciente_id, fecha_consulta DESC);
+
+-- Las versiones son de solo inserción: no se pueden modificar ni borrar directamente.
+-- Los borrados en cascada (pg_trigger_depth() > 0) se permiten para no bloquear
+-- la eliminación de la historia o del paciente.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION historia_clinica_version_inmutable() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' AND pg_trigger_depth() > 1 THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'historia_clinica_version es de solo inserción (% no permitido)', TG_OP
+        USING ERRCODE = 'insufficient_privilege';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS historia_clinica_version_inmutable ON historia_clinica_version;
+CREATE TRIGGER historia_clinica_version_inmutable
+BEFORE UPDATE OR DELETE ON historia_clinica_version
+FOR EACH ROW EXECUTE FUNCTION historia_clinica_version_inmutable();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS historia_clinica_version_inmutable ON historia_clinica_version;
+DROP FUNCTION IF EXISTS historia_clinica_version_inmutable();
+DROP INDEX IF EXISTS idx_historias_clinicas_paciente;
+ALTER TABLE historia_clinica_version
+    DROP CONSTRAINT IF EXISTS historia_clinica_version_numero_unico,
+    DROP COLUMN IF EXISTS numero_version;
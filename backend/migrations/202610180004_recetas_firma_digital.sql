@@ -0,0 +1,89 @@
+-- +goose Up
+-- Claves de firma por profesional. La clave privada se guarda cifrada con la
+-- clave maestra RECETAS_SIGNING_MASTER_KEY; el certificado es público.
+CREATE TABLE IF NOT EXISTS claves_firma (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    usuario_id UUID NOT NULL REFERENCES usuarios(id),
+    certificado BYTEA NOT NULL,
+    huella VARCHAR(100) NOT NULL UNIQUE,
+    clave_privada_cifrada BYTEA NOT NULL,
+    creado_en TIMESTAMP NOT NULL DEFAULT NOW(),
+    revocada_en TIMESTAMP
+);
+
+-- Un profesional tiene como máximo una clave activa
+CREATE UNIQUE INDEX IF NOT EXISTS idx_claves_firma_activa
+    ON claves_firma (usuario_id) WHERE revocada_en IS NULL;
+
+ALTER TABLE recetas_medicas
+    ADD COLUMN IF NOT EXISTS contenido_firmado BYTEA,
+    ADD COLUMN IF NOT EXISTS firma BYTEA,
+    ADD COLUMN IF NOT EXISTS clave_firma_id UUID REFERENCES claves_firma(id),
+    ADD COLUMN IF NOT EXISTS huella_certificado VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS estado VARCHAR(20) NOT NULL DEFAULT 'emitida'
+        CHECK (estado IN ('emitida', 'revocada')),
+    ADD COLUMN IF NOT EXISTS revocada_en TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS motivo_revocacion TEXT,
+    ADD COLUMN IF NOT EXISTS reemplaza_id UUID REFERENCES recetas_medicas(id);
+
+CREATE INDEX IF NOT EXISTS idx_recetas_medicas_paciente ON recetas_medicas (paciente_id, fecha_emision DESC);
+
+-- Una receta emitida no puede editarse ni borrarse: solo puede pasar a revocada.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION recetas_medicas_inmutable() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF pg_trigger_depth() > 1 THEN
+            RETURN OLD;
+        END IF;
+        RAISE EXCEPTION 'las recetas no pueden borrarse, solo revocarse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    IF NEW.paciente_id IS DISTINCT FROM OLD.paciente_id
+       OR NEW.usuario_id IS DISTINCT FROM OLD.usuario_id
+       OR NEW.contenido IS DISTINCT FROM OLD.contenido
+       OR NEW.fecha_emision IS DISTINCT FROM OLD.fecha_emision
+       OR NEW.firma_digital IS DISTINCT FROM OLD.firma_digital
+       OR NEW.contenido_firmado IS DISTINCT FROM OLD.contenido_firmado
+       OR NEW.firma IS DISTINCT FROM OLD.firma
+       OR NEW.clave_firma_id IS DISTINCT FROM OLD.clave_firma_id
+       OR NEW.huella_certificado IS DISTINCT FROM OLD.huella_certificado
+       OR NEW.reemplaza_id IS DISTINCT FROM OLD.reemplaza_id THEN
+        RAISE EXCEPTION 'las recetas emitidas no pueden modificarse, solo revocarse y reemitirse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    IF OLD.estado = 'revocada' AND (
+        NEW.estado <> 'revocada'
+        OR NEW.revocada_en IS DISTINCT FROM OLD.revocada_en
+        OR NEW.motivo_revocacion IS DISTINCT FROM OLD.motivo_revocacion) THEN
+        RAISE EXCEPTION 'una receta revocada no puede modificarse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS recetas_medicas_inmutable ON recetas_medicas;
+CREATE TRIGGER recetas_medicas_inmutable
+BEFORE UPDATE OR DELETE ON recetas_medicas
+FOR EACH ROW EXECUTE FUNCTION recetas_medicas_inmutable();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS recetas_medicas_inmutable ON recetas_medicas;
+DROP FUNCTION IF EXISTS recetas_medicas_inmutable();
+DROP INDEX IF EXISTS idx_recetas_medicas_paciente;
+ALTER TABLE recetas_medicas
+    DROP COLUMN IF EXISTS reemplaza_id,
+    DROP COLUMN IF EXISTS motivo_revocacion,
+    DROP COLUMN IF EXISTS revocada_en,
+    DROP COLUMN IF EXISTS estado,
+    DROP COLUMN IF EXISTS huella_certificado,
+    DROP COLUMN IF EXISTS clave_firma_id,
+    DROP COLUMN IF EXISTS firma,
+    DROP COLUMN IF EXISTS contenido_firmado;
+DROP INDEX IF EXISTS idx_claves_firma_activa;
+DROP TABLE IF EXISTS claves_firma;
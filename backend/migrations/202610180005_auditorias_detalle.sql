@@ -0,0 +1,25 @@
+-- +goose Up
+-- Detalle de auditoría: fila afectada, request, IP y fotos antes/después.
+-- usuario_id pasa a ser opcional para registrar acciones anónimas (registro, login fallido).
+ALTER TABLE auditorias
+    ALTER COLUMN usuario_id DROP NOT NULL,
+    ADD COLUMN IF NOT EXISTS registro_id VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS request_id VARCHAR(100),
+    ADD COLUMN IF NOT EXISTS ip VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS datos_antes JSONB,
+    ADD COLUMN IF NOT EXISTS datos_despues JSONB;
+
+CREATE INDEX IF NOT EXISTS idx_auditorias_registro ON auditorias (tabla_afectada, registro_id);
+CREATE INDEX IF NOT EXISTS idx_auditorias_usuario_fecha ON auditorias (usuario_id, fecha);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_auditorias_usuario_fecha;
+DROP INDEX IF EXISTS idx_auditorias_registro;
+ALTER TABLE auditorias
+    DROP COLUMN IF EXISTS datos_despues,
+    DROP COLUMN IF EXISTS datos_antes,
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS request_id,
+    DROP COLUMN IF EXISTS registro_id;
+DELETE FROM auditorias WHERE usuario_id IS NULL;
+ALTER TABLE auditorias ALTER COLUMN usuario_id SET NOT NULL;
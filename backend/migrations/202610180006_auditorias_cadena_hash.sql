@@ -0,0 +1,45 @@
+-- +goose Up
+-- Cadena de hashes por consultorio: cada registro guarda el hash del anterior de su
+-- cadena y el hash de su propio contenido (calculado por internal/audit).
+-- Los registros previos a esta migración quedan fuera de la cadena (secuencia NULL).
+ALTER TABLE auditorias
+    ADD COLUMN IF NOT EXISTS consultorio_id UUID,
+    ADD COLUMN IF NOT EXISTS secuencia BIGINT,
+    ADD COLUMN IF NOT EXISTS hash_anterior CHAR(64),
+    ADD COLUMN IF NOT EXISTS hash CHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_auditorias_cadena
+    ON auditorias ((COALESCE(consultorio_id, '00000000-0000-0000-0000-000000000000'::uuid)), secuencia)
+    WHERE secuencia IS NOT NULL;
+
+-- La cadena detecta cualquier cambio, pero además se impide modificar o borrar
+-- registros encadenados desde la aplicación
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION auditorias_inmutable() RETURNS trigger AS $$
+BEGIN
+    IF OLD.secuencia IS NOT NULL THEN
+        RAISE EXCEPTION 'auditorias es de solo inserción (% no permitido)', TG_OP
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS auditorias_inmutable ON auditorias;
+CREATE TRIGGER auditorias_inmutable
+BEFORE UPDATE OR DELETE ON auditorias
+FOR EACH ROW EXECUTE FUNCTION auditorias_inmutable();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS auditorias_inmutable ON auditorias;
+DROP FUNCTION IF EXISTS auditorias_inmutable();
+DROP INDEX IF EXISTS idx_auditorias_cadena;
+ALTER TABLE auditorias
+    DROP COLUMN IF EXISTS hash,
+    DROP COLUMN IF EXISTS hash_anterior,
+    DROP COLUMN IF EXISTS secuencia,
+    DROP COLUMN IF EXISTS consultorio_id;
@@ -0,0 +1,56 @@
+-- +goose Up
+-- Profesionales asignados a un paciente fuera de su consultorio (interconsultas, derivaciones)
+CREATE TABLE IF NOT EXISTS paciente_profesional (
+    paciente_id UUID NOT NULL REFERENCES pacientes(id) ON DELETE CASCADE,
+    usuario_id UUID NOT NULL REFERENCES usuarios(id),
+    asignado_por UUID REFERENCES usuarios(id),
+    asignado_en TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (paciente_id, usuario_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_paciente_profesional_usuario ON paciente_profesional (usuario_id);
+
+-- Registro de lecturas de datos clínicos. paciente_id no tiene FK para que el
+-- registro sobreviva al borrado o la fusión del paciente.
+CREATE TABLE IF NOT EXISTS accesos_pacientes (
+    id BIGSERIAL PRIMARY KEY,
+    usuario_id UUID NOT NULL REFERENCES usuarios(id),
+    paciente_id UUID NOT NULL,
+    consultorio_id UUID,
+    recurso VARCHAR(50) NOT NULL,
+    ruta VARCHAR(200),
+    motivo TEXT,
+    ip VARCHAR(64),
+    request_id VARCHAR(100),
+    permitido BOOLEAN NOT NULL,
+    emergencia BOOLEAN NOT NULL DEFAULT false,
+    justificacion TEXT,
+    requiere_revision BOOLEAN NOT NULL DEFAULT false,
+    revisado_por UUID REFERENCES usuarios(id),
+    revisado_en TIMESTAMP,
+    resultado_revision VARCHAR(20) CHECK (resultado_revision IN ('justificado', 'injustificado')),
+    comentario_revision TEXT,
+    fecha TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (NOT emergencia OR justificacion IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_accesos_pacientes_paciente ON accesos_pacientes (paciente_id, fecha DESC);
+CREATE INDEX IF NOT EXISTS idx_accesos_pacientes_usuario ON accesos_pacientes (usuario_id, fecha DESC);
+CREATE INDEX IF NOT EXISTS idx_accesos_pacientes_pendientes
+    ON accesos_pacientes (fecha) WHERE requiere_revision AND revisado_en IS NULL;
+
+-- Permiso para revisar accesos de emergencia
+INSERT INTO permisos (nombre_permiso) VALUES ('accesos:revisar')
+ON CONFLICT (nombre_permiso) DO NOTHING;
+
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r JOIN permisos p ON p.nombre_permiso = 'accesos:revisar'
+WHERE r.nombre_rol = 'admin'
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM rol_permiso WHERE permiso_id IN (SELECT id FROM permisos WHERE nombre_permiso = 'accesos:revisar');
+DELETE FROM permisos WHERE nombre_permiso = 'accesos:revisar';
+DROP TABLE IF EXISTS accesos_pacientes;
+DROP TABLE IF EXISTS paciente_profesional;
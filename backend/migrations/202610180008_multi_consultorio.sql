@@ -0,0 +1,26 @@
+-- +goose Up
+-- Los handlers filtran pacientes y usuarios por consultorio en cada consulta
+CREATE INDEX IF NOT EXISTS idx_pacientes_consultorio ON pacientes (consultorio_id, creado_en DESC);
+CREATE INDEX IF NOT EXISTS idx_usuarios_consultorio ON usuarios (consultorio_id);
+
+-- Acceso a otros consultorios (encabezado X-Consultorio-ID), solo para super-admin.
+-- El rol admin sigue limitado a su propio consultorio.
+INSERT INTO permisos (nombre_permiso) VALUES ('consultorios:global')
+ON CONFLICT (nombre_permiso) DO NOTHING;
+
+INSERT INTO roles (nombre_rol) VALUES ('superadmin')
+ON CONFLICT (nombre_rol) DO NOTHING;
+
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r CROSS JOIN permisos p
+WHERE r.nombre_rol = 'superadmin'
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM rol_permiso WHERE rol_id IN (SELECT id FROM roles WHERE nombre_rol = 'superadmin');
+DELETE FROM roles WHERE nombre_rol = 'superadmin';
+DELETE FROM rol_permiso WHERE permiso_id IN (SELECT id FROM permisos WHERE nombre_permiso = 'consultorios:global');
+DELETE FROM permisos WHERE nombre_permiso = 'consultorios:global';
+DROP INDEX IF EXISTS idx_usuarios_consultorio;
+DROP INDEX IF EXISTS idx_pacientes_consultorio;
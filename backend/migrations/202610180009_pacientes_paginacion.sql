@@ -0,0 +1,14 @@
+-- +goose Up
+-- Índices para la paginación por cursor de GET /pacientes: (consultorio, campo de orden, id)
+DROP INDEX IF EXISTS idx_pacientes_consultorio;
+CREATE INDEX IF NOT EXISTS idx_pacientes_consultorio_creado ON pacientes (consultorio_id, creado_en DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_pacientes_consultorio_apellido ON pacientes (consultorio_id, apellido, id);
+CREATE INDEX IF NOT EXISTS idx_pacientes_consultorio_nombre ON pacientes (consultorio_id, nombre, id);
+CREATE INDEX IF NOT EXISTS idx_pacientes_consultorio_nacimiento ON pacientes (consultorio_id, fecha_nacimiento, id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_pacientes_consultorio_nacimiento;
+DROP INDEX IF EXISTS idx_pacientes_consultorio_nombre;
+DROP INDEX IF EXISTS idx_pacientes_consultorio_apellido;
+DROP INDEX IF EXISTS idx_pacientes_consultorio_creado;
+CREATE INDEX IF NOT EXISTS idx_pacientes_consultorio ON pacientes (consultorio_id, creado_en DESC);
@@ -0,0 +1,31 @@
+-- +goose Up
+-- Búsqueda aproximada de pacientes (GET /pacientes/search): texto completo y trigramas sin acentos
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- unaccent() es STABLE porque depende del diccionario configurado; fijándolo se puede usar en índices
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION f_unaccent(texto text) RETURNS text
+LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT AS $$
+    SELECT public.unaccent('public.unaccent'::regdictionary, texto)
+$$;
+-- +goose StatementEnd
+
+-- Texto normalizado sobre el que se busca. El handler usa exactamente esta expresión para que apliquen los índices.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION pacientes_texto_busqueda(nombre text, apellido text, nro_credencial text) RETURNS text
+LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+    SELECT lower(f_unaccent(coalesce(nombre, '') || ' ' || coalesce(apellido, '') || ' ' || coalesce(nro_credencial, '')))
+$$;
+-- +goose StatementEnd
+
+CREATE INDEX IF NOT EXISTS idx_pacientes_busqueda_trgm ON pacientes
+    USING gin (pacientes_texto_busqueda(nombre, apellido, nro_credencial) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_pacientes_busqueda_fts ON pacientes
+    USING gin (to_tsvector('simple', pacientes_texto_busqueda(nombre, apellido, nro_credencial)));
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_pacientes_busqueda_fts;
+DROP INDEX IF EXISTS idx_pacientes_busqueda_trgm;
+DROP FUNCTION IF EXISTS pacientes_texto_busqueda(text, text, text);
+DROP FUNCTION IF EXISTS f_unaccent(text);
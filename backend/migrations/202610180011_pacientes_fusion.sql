@@ -0,0 +1,104 @@
+-- +goose Up
+-- Permiso para fusionar pacientes duplicados (POST /pacientes/:id/fusionar)
+INSERT INTO permisos (nombre_permiso) VALUES ('pacientes:fusionar')
+ON CONFLICT (nombre_permiso) DO NOTHING;
+
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r JOIN permisos p ON p.nombre_permiso = 'pacientes:fusionar'
+WHERE r.nombre_rol IN ('admin', 'superadmin')
+ON CONFLICT DO NOTHING;
+
+-- Candidatos a duplicado por credencial y búsqueda del DNI de cada candidato
+CREATE INDEX IF NOT EXISTS idx_pacientes_consultorio_credencial ON pacientes (consultorio_id, nro_credencial)
+    WHERE nro_credencial IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_datos_personales_paciente ON datos_personales (paciente_id);
+
+-- La fusión mueve las recetas del duplicado al paciente que queda. El cambio de paciente_id
+-- se permite únicamente con mediapp.fusion_pacientes = 'on' (SET LOCAL dentro de la
+-- transacción de la fusión), ya que el paciente firmado queda en contenido_firmado.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION recetas_medicas_inmutable() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF pg_trigger_depth() > 1 THEN
+            RETURN OLD;
+        END IF;
+        RAISE EXCEPTION 'las recetas no pueden borrarse, solo revocarse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    IF NEW.paciente_id IS DISTINCT FROM OLD.paciente_id
+       AND COALESCE(current_setting('mediapp.fusion_pacientes', true), '') <> 'on' THEN
+        RAISE EXCEPTION 'el paciente de una receta no puede modificarse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    IF NEW.usuario_id IS DISTINCT FROM OLD.usuario_id
+       OR NEW.contenido IS DISTINCT FROM OLD.contenido
+       OR NEW.fecha_emision IS DISTINCT FROM OLD.fecha_emision
+       OR NEW.firma_digital IS DISTINCT FROM OLD.firma_digital
+       OR NEW.contenido_firmado IS DISTINCT FROM OLD.contenido_firmado
+       OR NEW.firma IS DISTINCT FROM OLD.firma
+       OR NEW.clave_firma_id IS DISTINCT FROM OLD.clave_firma_id
+       OR NEW.huella_certificado IS DISTINCT FROM OLD.huella_certificado
+       OR NEW.reemplaza_id IS DISTINCT FROM OLD.reemplaza_id THEN
+        RAISE EXCEPTION 'las recetas emitidas no pueden modificarse, solo revocarse y reemitirse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    IF OLD.estado = 'revocada' AND (
+        NEW.estado <> 'revocada'
+        OR NEW.revocada_en IS DISTINCT FROM OLD.revocada_en
+        OR NEW.motivo_revocacion IS DISTINCT FROM OLD.motivo_revocacion) THEN
+        RAISE EXCEPTION 'una receta revocada no puede modificarse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION recetas_medicas_inmutable() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        IF pg_trigger_depth() > 1 THEN
+            RETURN OLD;
+        END IF;
+        RAISE EXCEPTION 'las recetas no pueden borrarse, solo revocarse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    IF NEW.paciente_id IS DISTINCT FROM OLD.paciente_id
+       OR NEW.usuario_id IS DISTINCT FROM OLD.usuario_id
+       OR NEW.contenido IS DISTINCT FROM OLD.contenido
+       OR NEW.fecha_emision IS DISTINCT FROM OLD.fecha_emision
+       OR NEW.firma_digital IS DISTINCT FROM OLD.firma_digital
+       OR NEW.contenido_firmado IS DISTINCT FROM OLD.contenido_firmado
+       OR NEW.firma IS DISTINCT FROM OLD.firma
+       OR NEW.clave_firma_id IS DISTINCT FROM OLD.clave_firma_id
+       OR NEW.huella_certificado IS DISTINCT FROM OLD.huella_certificado
+       OR NEW.reemplaza_id IS DISTINCT FROM OLD.reemplaza_id THEN
+        RAISE EXCEPTION 'las recetas emitidas no pueden modificarse, solo revocarse y reemitirse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    IF OLD.estado = 'revocada' AND (
+        NEW.estado <> 'revocada'
+        OR NEW.revocada_en IS DISTINCT FROM OLD.revocada_en
+        OR NEW.motivo_revocacion IS DISTINCT FROM OLD.motivo_revocacion) THEN
+        RAISE EXCEPTION 'una receta revocada no puede modificarse'
+            USING ERRCODE = 'insufficient_privilege';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+DROP INDEX IF EXISTS idx_datos_personales_paciente;
+DROP INDEX IF EXISTS idx_pacientes_consultorio_credencial;
+DELETE FROM rol_permiso WHERE permiso_id IN (SELECT id FROM permisos WHERE nombre_permiso = 'pacientes:fusionar');
+DELETE FROM permisos WHERE nombre_permiso = 'pacientes:fusionar';
@@ -0,0 +1,37 @@
+-- +goose Up
+-- Cifrado por campo de datos_personales: DNI y teléfono con envelope encryption
+-- (internal/security) e índice ciego (HMAC) del DNI para buscar por igualdad
+ALTER TABLE datos_personales
+    ADD COLUMN IF NOT EXISTS dni_indice BYTEA,
+    ADD COLUMN IF NOT EXISTS actualizado_en TIMESTAMP NOT NULL DEFAULT NOW();
+
+-- Una fila por paciente. Si hay pacientes con más de una fila la migración se aborta sin
+-- tocar nada: cuál conservar lo decide una persona, los datos personales no se descartan.
+-- +goose StatementBegin
+DO $$
+DECLARE
+    repetidos INTEGER;
+BEGIN
+    SELECT COUNT(*) INTO repetidos FROM (
+        SELECT paciente_id FROM datos_personales GROUP BY paciente_id HAVING COUNT(*) > 1
+    ) d;
+    IF repetidos > 0 THEN
+        RAISE EXCEPTION 'datos_personales tiene % pacientes con más de una fila', repetidos
+            USING HINT = 'Unificar las filas de cada paciente (SELECT paciente_id FROM datos_personales GROUP BY paciente_id HAVING COUNT(*) > 1) y volver a migrar';
+    END IF;
+END
+$$;
+-- +goose StatementEnd
+
+DROP INDEX IF EXISTS idx_datos_personales_paciente;
+CREATE UNIQUE INDEX IF NOT EXISTS datos_personales_paciente_unico ON datos_personales (paciente_id);
+CREATE INDEX IF NOT EXISTS idx_datos_personales_dni_indice ON datos_personales (dni_indice)
+    WHERE dni_indice IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_datos_personales_dni_indice;
+DROP INDEX IF EXISTS datos_personales_paciente_unico;
+CREATE INDEX IF NOT EXISTS idx_datos_personales_paciente ON datos_personales (paciente_id);
+ALTER TABLE datos_personales
+    DROP COLUMN IF EXISTS actualizado_en,
+    DROP COLUMN IF EXISTS dni_indice;
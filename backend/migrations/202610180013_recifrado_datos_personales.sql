@@ -0,0 +1,50 @@
+-- +goose Up
+-- Versión de la clave maestra con la que están cifrados DNI y teléfono de cada fila.
+-- Permite encontrar las filas pendientes después de rotar la clave.
+ALTER TABLE datos_personales ADD COLUMN IF NOT EXISTS clave_version INT;
+
+-- La versión viaja en los bytes 2 a 5 del texto cifrado (formato 0x01 de internal/security)
+UPDATE datos_personales
+SET clave_version = ('x' || encode(substring(coalesce(dni_encriptado, telefono_encriptado) FROM 2 FOR 4), 'hex'))::bit(32)::int
+WHERE clave_version IS NULL
+  AND length(coalesce(dni_encriptado, telefono_encriptado)) >= 7
+  AND get_byte(coalesce(dni_encriptado, telefono_encriptado), 0) = 1;
+
+CREATE INDEX IF NOT EXISTS idx_datos_personales_clave_version ON datos_personales (clave_version);
+
+-- Progreso del recifrado: ultimo_id es el cursor desde el que se reanuda el trabajo
+CREATE TABLE IF NOT EXISTS recifrado_trabajos (
+    id BIGSERIAL PRIMARY KEY,
+    tabla VARCHAR(100) NOT NULL,
+    version_destino INT NOT NULL,
+    estado VARCHAR(20) NOT NULL DEFAULT 'en_curso'
+        CHECK (estado IN ('en_curso', 'completado', 'cancelado')),
+    ultimo_id UUID,
+    procesadas BIGINT NOT NULL DEFAULT 0,
+    errores BIGINT NOT NULL DEFAULT 0,
+    ultimo_error TEXT,
+    iniciado_en TIMESTAMP NOT NULL DEFAULT NOW(),
+    actualizado_en TIMESTAMP NOT NULL DEFAULT NOW(),
+    finalizado_en TIMESTAMP
+);
+
+-- Un solo trabajo en curso por tabla
+CREATE UNIQUE INDEX IF NOT EXISTS recifrado_trabajos_en_curso ON recifrado_trabajos (tabla)
+    WHERE estado = 'en_curso';
+
+-- Rotar claves afecta a todos los consultorios: solo superadmin
+INSERT INTO permisos (nombre_permiso) VALUES ('claves:rotar')
+ON CONFLICT (nombre_permiso) DO NOTHING;
+
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r JOIN permisos p ON p.nombre_permiso = 'claves:rotar'
+WHERE r.nombre_rol = 'superadmin'
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM rol_permiso WHERE permiso_id IN (SELECT id FROM permisos WHERE nombre_permiso = 'claves:rotar');
+DELETE FROM permisos WHERE nombre_permiso = 'claves:rotar';
+DROP TABLE IF EXISTS recifrado_trabajos;
+DROP INDEX IF EXISTS idx_datos_personales_clave_version;
+ALTER TABLE datos_personales DROP COLUMN IF EXISTS clave_version;
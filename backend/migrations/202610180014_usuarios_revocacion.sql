@@ -0,0 +1,32 @@
+-- +goose Up
+-- Desactivar un usuario o cambiar su contraseña revoca sus tokens. El trigger deja el
+-- instante en credenciales_cambiadas_en y avisa por NOTIFY al servidor, que agrega al
+-- usuario a la lista de revocados de Redis (services.RevocationService).
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS credenciales_cambiadas_en TIMESTAMP;
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION revocar_tokens_usuario() RETURNS trigger AS $$
+BEGIN
+    IF (OLD.activo AND NOT NEW.activo) OR NEW.contrasena_hash IS DISTINCT FROM OLD.contrasena_hash THEN
+        NEW.credenciales_cambiadas_en := NOW();
+        PERFORM pg_notify('usuarios_revocados',
+            NEW.id::text || ':' || floor(extract(epoch FROM NEW.credenciales_cambiadas_en))::bigint);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS usuarios_revocar_tokens ON usuarios;
+CREATE TRIGGER usuarios_revocar_tokens
+BEFORE UPDATE OF activo, contrasena_hash ON usuarios
+FOR EACH ROW EXECUTE FUNCTION revocar_tokens_usuario();
+
+CREATE INDEX IF NOT EXISTS idx_usuarios_credenciales_cambiadas ON usuarios (credenciales_cambiadas_en)
+    WHERE credenciales_cambiadas_en IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_usuarios_credenciales_cambiadas;
+DROP TRIGGER IF EXISTS usuarios_revocar_tokens ON usuarios;
+DROP FUNCTION IF EXISTS revocar_tokens_usuario();
+ALTER TABLE usuarios DROP COLUMN IF EXISTS credenciales_cambiadas_en;
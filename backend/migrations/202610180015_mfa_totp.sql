@@ -0,0 +1,30 @@
+-- +goose Up
+-- Autenticación en dos pasos con TOTP (RFC 6238). Los roles con mfa_requerido obligan a sus
+-- usuarios a enrolarse en el próximo login.
+ALTER TABLE roles ADD COLUMN IF NOT EXISTS mfa_requerido BOOLEAN NOT NULL DEFAULT false;
+
+-- secreto_cifrado usa el mismo cifrado que datos_personales (internal/security.FieldCipher).
+-- Mientras confirmado_en sea NULL el enrolamiento está pendiente y no se exige en el login.
+-- ultimo_paso es el último período TOTP aceptado: un código no se puede usar dos veces.
+CREATE TABLE IF NOT EXISTS usuarios_mfa (
+    usuario_id UUID PRIMARY KEY REFERENCES usuarios(id) ON DELETE CASCADE,
+    secreto_cifrado BYTEA NOT NULL,
+    confirmado_en TIMESTAMP,
+    ultimo_paso BIGINT NOT NULL DEFAULT 0,
+    creado_en TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Códigos de recuperación de un solo uso, guardados como hash SHA-256
+CREATE TABLE IF NOT EXISTS usuarios_mfa_recuperacion (
+    id BIGSERIAL PRIMARY KEY,
+    usuario_id UUID NOT NULL REFERENCES usuarios(id) ON DELETE CASCADE,
+    codigo_hash VARCHAR(64) NOT NULL,
+    usado_en TIMESTAMP,
+    creado_en TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (usuario_id, codigo_hash)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS usuarios_mfa_recuperacion;
+DROP TABLE IF EXISTS usuarios_mfa;
+ALTER TABLE roles DROP COLUMN IF EXISTS mfa_requerido;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- Verificación del email de los usuarios y tokens de un solo uso para restablecer la
+-- contraseña y verificar el email. Del token solo se guarda su hash SHA-256; email es la
+-- dirección a la que se envió, para que un token de verificación no sirva si el email cambió.
+ALTER TABLE usuarios ADD COLUMN IF NOT EXISTS email_verificado_en TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS usuarios_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    usuario_id UUID NOT NULL REFERENCES usuarios(id) ON DELETE CASCADE,
+    tipo VARCHAR(20) NOT NULL CHECK (tipo IN ('restablecer', 'verificar_email')),
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    email VARCHAR(255) NOT NULL,
+    expira_en TIMESTAMP NOT NULL,
+    usado_en TIMESTAMP,
+    creado_en TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_usuarios_tokens_usuario ON usuarios_tokens (usuario_id, tipo, creado_en);
+
+-- +goose Down
+DROP TABLE IF EXISTS usuarios_tokens;
+ALTER TABLE usuarios DROP COLUMN IF EXISTS email_verificado_en;
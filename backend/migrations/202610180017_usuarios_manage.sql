@@ -0,0 +1,14 @@
+-- +goose Up
+-- Administración de usuarios del consultorio (desbloqueo de cuentas, altas y bajas)
+INSERT INTO permisos (nombre_permiso) VALUES ('usuarios:manage')
+ON CONFLICT (nombre_permiso) DO NOTHING;
+
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r JOIN permisos p ON p.nombre_permiso = 'usuarios:manage'
+WHERE r.nombre_rol IN ('admin', 'superadmin')
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM rol_permiso WHERE permiso_id IN (SELECT id FROM permisos WHERE nombre_permiso = 'usuarios:manage');
+DELETE FROM permisos WHERE nombre_permiso = 'usuarios:manage';
@@ -0,0 +1,67 @@
+-- +goose Up
+-- Alta de usuarios por invitación (reemplaza /register). El usuario invitado se crea sin
+-- contraseña (contrasena_hash vacío no coincide con ninguna) y la elige con el token de
+-- invitación que recibe por correo.
+ALTER TABLE usuarios_tokens DROP CONSTRAINT IF EXISTS usuarios_tokens_tipo_check;
+ALTER TABLE usuarios_tokens ADD CONSTRAINT usuarios_tokens_tipo_check
+    CHECK (tipo IN ('restablecer', 'verificar_email', 'invitacion'));
+
+-- El rol y el consultorio viajan en el access token: cambiarlos también revoca los tokens
+-- del usuario, además de desactivarlo o cambiar su contraseña
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION revocar_tokens_usuario() RETURNS trigger AS $$
+BEGIN
+    IF (OLD.activo AND NOT NEW.activo)
+        OR NEW.contrasena_hash IS DISTINCT FROM OLD.contrasena_hash
+        OR NEW.rol_id IS DISTINCT FROM OLD.rol_id
+        OR NEW.consultorio_id IS DISTINCT FROM OLD.consultorio_id THEN
+        NEW.credenciales_cambiadas_en := NOW();
+        PERFORM pg_notify('usuarios_revocados',
+            NEW.id::text || ':' || floor(extract(epoch FROM NEW.credenciales_cambiadas_en))::bigint);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS usuarios_revocar_tokens ON usuarios;
+CREATE TRIGGER usuarios_revocar_tokens
+BEFORE UPDATE OF activo, contrasena_hash, rol_id, consultorio_id ON usuarios
+FOR EACH ROW EXECUTE FUNCTION revocar_tokens_usuario();
+
+-- Listado de usuarios del consultorio
+INSERT INTO permisos (nombre_permiso) VALUES ('usuarios:read')
+ON CONFLICT (nombre_permiso) DO NOTHING;
+
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r JOIN permisos p ON p.nombre_permiso = 'usuarios:read'
+WHERE r.nombre_rol IN ('admin', 'superadmin')
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM rol_permiso WHERE permiso_id IN (SELECT id FROM permisos WHERE nombre_permiso = 'usuarios:read');
+DELETE FROM permisos WHERE nombre_permiso = 'usuarios:read';
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION revocar_tokens_usuario() RETURNS trigger AS $$
+BEGIN
+    IF (OLD.activo AND NOT NEW.activo) OR NEW.contrasena_hash IS DISTINCT FROM OLD.contrasena_hash THEN
+        NEW.credenciales_cambiadas_en := NOW();
+        PERFORM pg_notify('usuarios_revocados',
+            NEW.id::text || ':' || floor(extract(epoch FROM NEW.credenciales_cambiadas_en))::bigint);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TRIGGER IF EXISTS usuarios_revocar_tokens ON usuarios;
+CREATE TRIGGER usuarios_revocar_tokens
+BEFORE UPDATE OF activo, contrasena_hash ON usuarios
+FOR EACH ROW EXECUTE FUNCTION revocar_tokens_usuario();
+
+DELETE FROM usuarios_tokens WHERE tipo = 'invitacion';
+ALTER TABLE usuarios_tokens DROP CONSTRAINT IF EXISTS usuarios_tokens_tipo_check;
+ALTER TABLE usuarios_tokens ADD CONSTRAINT usuarios_tokens_tipo_check
+    CHECK (tipo IN ('restablecer', 'verificar_email'));
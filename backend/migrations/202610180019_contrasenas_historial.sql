@@ -0,0 +1,50 @@
+-- +goose Up
+-- Historial de contraseñas para que no se repitan las últimas N (PASSWORD_HISTORY). Solo
+-- se guardan los hashes, con el mismo algoritmo que contrasena_hash.
+CREATE TABLE IF NOT EXISTS usuarios_contrasenas (
+    id BIGSERIAL PRIMARY KEY,
+    usuario_id UUID NOT NULL REFERENCES usuarios(id) ON DELETE CASCADE,
+    contrasena_hash TEXT NOT NULL,
+    creado_en TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_usuarios_contrasenas_usuario ON usuarios_contrasenas (usuario_id, creado_en DESC);
+
+-- Al iniciar sesión el hash se recalcula si cambió el algoritmo o su costo. La contraseña
+-- es la misma, así que ese cambio (marcado con SET LOCAL mediapp.rehash = 'on') no revoca
+-- los tokens del usuario.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION revocar_tokens_usuario() RETURNS trigger AS $$
+BEGIN
+    IF (OLD.activo AND NOT NEW.activo)
+        OR (NEW.contrasena_hash IS DISTINCT FROM OLD.contrasena_hash
+            AND COALESCE(current_setting('mediapp.rehash', true), '') <> 'on')
+        OR NEW.rol_id IS DISTINCT FROM OLD.rol_id
+        OR NEW.consultorio_id IS DISTINCT FROM OLD.consultorio_id THEN
+        NEW.credenciales_cambiadas_en := NOW();
+        PERFORM pg_notify('usuarios_revocados',
+            NEW.id::text || ':' || floor(extract(epoch FROM NEW.credenciales_cambiadas_en))::bigint);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION revocar_tokens_usuario() RETURNS trigger AS $$
+BEGIN
+    IF (OLD.activo AND NOT NEW.activo)
+        OR NEW.contrasena_hash IS DISTINCT FROM OLD.contrasena_hash
+        OR NEW.rol_id IS DISTINCT FROM OLD.rol_id
+        OR NEW.consultorio_id IS DISTINCT FROM OLD.consultorio_id THEN
+        NEW.credenciales_cambiadas_en := NOW();
+        PERFORM pg_notify('usuarios_revocados',
+            NEW.id::text || ':' || floor(extract(epoch FROM NEW.credenciales_cambiadas_en))::bigint);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+DROP TABLE IF EXISTS usuarios_contrasenas;
@@ -0,0 +1,39 @@
+-- +goose Up
+-- API keys para integraciones sin login (laboratorios, facturación). Cada una opera sobre un
+-- consultorio con un subconjunto de permisos. La clave es mk_<prefijo>_<secreto>: el
+-- prefijo la identifica en los listados y del total solo se guarda el hash SHA-256.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY,
+    nombre VARCHAR(100) NOT NULL,
+    prefijo VARCHAR(12) NOT NULL UNIQUE,
+    clave_hash VARCHAR(64) NOT NULL,
+    consultorio_id UUID NOT NULL REFERENCES consultorios(id) ON DELETE CASCADE,
+    permisos TEXT[] NOT NULL,
+    limite_por_minuto INTEGER NOT NULL DEFAULT 60 CHECK (limite_por_minuto > 0),
+    expira_en TIMESTAMP,
+    revocada_en TIMESTAMP,
+    ultimo_uso_en TIMESTAMP,
+    ultima_ip VARCHAR(45),
+    creada_por UUID REFERENCES usuarios(id) ON DELETE SET NULL,
+    creada_en TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_consultorio ON api_keys (consultorio_id, creada_en DESC);
+
+-- Los cambios hechos con una API key quedan auditados a su nombre
+ALTER TABLE auditorias ADD COLUMN IF NOT EXISTS api_key_id UUID;
+
+INSERT INTO permisos (nombre_permiso) VALUES ('api_keys:manage')
+ON CONFLICT (nombre_permiso) DO NOTHING;
+
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r JOIN permisos p ON p.nombre_permiso = 'api_keys:manage'
+WHERE r.nombre_rol IN ('admin', 'superadmin')
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM rol_permiso WHERE permiso_id IN (SELECT id FROM permisos WHERE nombre_permiso = 'api_keys:manage');
+DELETE FROM permisos WHERE nombre_permiso = 'api_keys:manage';
+ALTER TABLE auditorias DROP COLUMN IF EXISTS api_key_id;
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,40 @@
+-- +goose Up
+-- Apps propias (portal de pacientes, app móvil) que autentican usuarios con OAuth2
+-- Authorization Code + PKCE en lugar de enviar la contraseña a /login. Los clientes públicos
+-- no tienen secreto; de los confidenciales solo se guarda el hash SHA-256 del secreto.
+CREATE TABLE IF NOT EXISTS oauth_clientes (
+    client_id VARCHAR(32) PRIMARY KEY,
+    nombre VARCHAR(100) NOT NULL,
+    redirect_uris TEXT[] NOT NULL CHECK (cardinality(redirect_uris) > 0),
+    scopes TEXT[] NOT NULL,
+    secreto_hash VARCHAR(64),
+    creado_por UUID REFERENCES usuarios(id) ON DELETE SET NULL,
+    creado_en TIMESTAMP NOT NULL DEFAULT NOW(),
+    revocado_en TIMESTAMP
+);
+
+-- Scopes que cada usuario autorizó a cada app; con el consentimiento dado la autorización
+-- no vuelve a preguntar
+CREATE TABLE IF NOT EXISTS oauth_consentimientos (
+    usuario_id UUID NOT NULL REFERENCES usuarios(id) ON DELETE CASCADE,
+    client_id VARCHAR(32) NOT NULL REFERENCES oauth_clientes(client_id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL,
+    otorgado_en TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (usuario_id, client_id)
+);
+
+-- Los clientes sirven a todos los consultorios: solo superadmin
+INSERT INTO permisos (nombre_permiso) VALUES ('oauth_clientes:manage')
+ON CONFLICT (nombre_permiso) DO NOTHING;
+
+INSERT INTO rol_permiso (rol_id, permiso_id)
+SELECT r.id, p.id
+FROM roles r JOIN permisos p ON p.nombre_permiso = 'oauth_clientes:manage'
+WHERE r.nombre_rol = 'superadmin'
+ON CONFLICT DO NOTHING;
+
+-- +goose Down
+DELETE FROM rol_permiso WHERE permiso_id IN (SELECT id FROM permisos WHERE nombre_permiso = 'oauth_clientes:manage');
+DELETE FROM permisos WHERE nombre_permiso = 'oauth_clientes:manage';
+DROP TABLE IF EXISTS oauth_consentimientos;
+DROP TABLE IF EXISTS oauth_clientes;
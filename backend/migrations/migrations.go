@@ -0,0 +1,10 @@
+// Package migrations incluye en el binario las migraciones de goose, para que el
+// diagnóstico compare el esquema de la base con el que esperan.
+package migrations
+
+import "embed"
+
+// Archivos son las migraciones vigentes; las de old/ ya no se aplican
+//
+//go:embed *.sql
+var Archivos embed.FS